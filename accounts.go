@@ -0,0 +1,931 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"email-mcp-server/auth"
+	"email-mcp-server/autoconfig"
+	"email-mcp-server/credentials"
+	"email-mcp-server/mail"
+)
+
+// defaultTimeout applies to accounts without TimeoutSeconds
+const defaultTimeout = 30 * time.Second
+
+// loadAccounts reads email_config.json or, when it does not exist, the
+// EMAIL_* environment variables. Accounts keep the order of the file and the
+// first one is the default. A file that cannot be parsed or contains invalid settings is
+// an error: falling back to other settings would hide the mistake.
+func loadAccounts(path string) ([]EmailConfig, string, error) {
+	var configs []EmailConfig
+
+	configData, err := os.ReadFile(path)
+	fromFile := err == nil
+	switch {
+	case err == nil:
+		decoder := json.NewDecoder(bytes.NewReader(configData))
+		decoder.DisallowUnknownFields()
+
+		var configMap map[string]EmailConfig
+		if err := decoder.Decode(&configMap); err != nil {
+			return nil, "", fmt.Errorf("invalid %s: %w", path, err)
+		}
+		order, err := objectKeys(configData)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid %s: %w", path, err)
+		}
+		seen := make(map[string]bool)
+		for _, id := range order {
+			if seen[id] {
+				return nil, "", fmt.Errorf("invalid %s: account %q is defined more than once", path, id)
+			}
+			seen[id] = true
+
+			config := configMap[id]
+			config.ID = id
+			configs = append(configs, config)
+		}
+		if len(configs) == 0 {
+			return nil, "", fmt.Errorf("invalid %s: no accounts defined", path)
+		}
+	case os.IsNotExist(err):
+		// Environment variables for backward compatibility
+		configs = append(configs, EmailConfig{
+			ID:          "default",
+			IMAPHost:    getEnv("IMAP_HOST", "imap.gmail.com"),
+			IMAPPort:    getEnvInt("IMAP_PORT", 993),
+			SMTPHost:    getEnv("SMTP_HOST", "smtp.gmail.com"),
+			SMTPPort:    getEnvInt("SMTP_PORT", 587),
+			Username:    getEnv("EMAIL_USERNAME", ""),
+			Password:    getEnv("EMAIL_PASSWORD", ""),
+			UseStartTLS: getEnv("USE_STARTTLS", "true") == "true",
+			IMAPAuth:    getEnv("IMAP_AUTH", ""),
+			SMTPAuth:    getEnv("SMTP_AUTH", ""),
+
+			SMTPHelloName: getEnv("SMTP_HELLO_NAME", ""),
+			SMTPNotify:    getEnv("SMTP_NOTIFY", ""),
+			Redact:        getEnv("REDACT", ""),
+
+			TLSCAFile:             getEnv("TLS_CA_FILE", ""),
+			TLSCertFile:           getEnv("TLS_CERT_FILE", ""),
+			TLSKeyFile:            getEnv("TLS_KEY_FILE", ""),
+			TLSMinVersion:         getEnv("TLS_MIN_VERSION", ""),
+			TLSInsecureSkipVerify: getEnv("TLS_INSECURE_SKIP_VERIFY", "false") == "true",
+		})
+	default:
+		return nil, "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var problems []string
+	for _, config := range configs {
+		problems = append(problems, config.validate()...)
+		if config.passwordSource() != credentials.SourcePlain && config.Password != "" {
+			problems = append(problems, fmt.Sprintf("account %q: Password must be empty when PasswordSource is %s", config.ID, config.PasswordSource))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, "", fmt.Errorf("invalid account configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	// Passwords kept outside the file are only held in memory
+	for i := range configs {
+		if err := configs[i].resolvePassword(); err != nil {
+			problems = append(problems, fmt.Sprintf("account %q: %v", configs[i].ID, err))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, "", fmt.Errorf("failed to read account passwords:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	for _, config := range configs {
+		config.warnInsecureTLS()
+	}
+	if fromFile {
+		for _, config := range configs {
+			if config.passwordSource() == credentials.SourcePlain {
+				log.Printf("Account %s keeps its password in plain text in %s; use migrate_credentials to move it to the keyring", config.ID, path)
+			}
+		}
+	}
+
+	return configs, configs[0].ID, nil
+}
+
+// objectKeys returns the keys of a JSON object in the order they appear;
+// decoding into a map loses it
+func objectKeys(data []byte) ([]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		keys = append(keys, token.(string))
+	}
+	return keys, nil
+}
+
+// validate returns one message per problem, each naming the account and the
+// setting to fix
+func (c *EmailConfig) validate() []string {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("account %q: ", c.ID)+fmt.Sprintf(format, args...))
+	}
+
+	if c.ID == "" {
+		add("account ID must not be empty")
+	}
+	switch c.Provider {
+	case "", providerIMAP:
+		if c.IMAPHost == "" {
+			add("IMAPHost is required (e.g. imap.gmail.com)")
+		}
+		if c.SMTPHost == "" {
+			add("SMTPHost is required (e.g. smtp.gmail.com)")
+		}
+		if c.IMAPPort < 1 || c.IMAPPort > 65535 {
+			add("IMAPPort %d is not a valid port (usually 993)", c.IMAPPort)
+		}
+		if c.SMTPPort < 1 || c.SMTPPort > 65535 {
+			add("SMTPPort %d is not a valid port (usually 587)", c.SMTPPort)
+		}
+	case providerGraph:
+		if c.GraphClientID == "" {
+			add("GraphClientID is required for Microsoft Graph accounts")
+		}
+		// The refresh token rotates, so it must be kept where it can be updated
+		if source := c.passwordSource(); source != credentials.SourceKeyring && source != credentials.SourceFile {
+			add("PasswordSource must be keyring or file for Microsoft Graph accounts")
+		}
+	default:
+		add("Provider %q is not one of imap or graph", c.Provider)
+	}
+	if c.Username == "" {
+		add("Username is required (EMAIL_USERNAME when configured through the environment)")
+	}
+	switch c.passwordSource() {
+	case credentials.SourcePlain:
+		if c.Password == "" {
+			add("Password is required (EMAIL_PASSWORD when configured through the environment)")
+		}
+	case credentials.SourceEnv:
+		if c.PasswordEnv == "" {
+			add("PasswordEnv is required when PasswordSource is env")
+		}
+	case credentials.SourceKeyring, credentials.SourceFile:
+	default:
+		add("PasswordSource %q is not one of plain, keyring, env or file", c.PasswordSource)
+	}
+	if c.TimeoutSeconds < 0 {
+		add("TimeoutSeconds must not be negative")
+	}
+	if c.DialTimeoutSeconds < 0 {
+		add("DialTimeoutSeconds must not be negative")
+	}
+	if _, err := auth.Normalize(c.IMAPAuth); err != nil {
+		add("IMAPAuth: %v", err)
+	}
+	if _, err := auth.Normalize(c.SMTPAuth); err != nil {
+		add("SMTPAuth: %v", err)
+	}
+	if strings.ContainsAny(c.SMTPHelloName, " \t\r\n") {
+		add("SMTPHelloName %q must be a single host name", c.SMTPHelloName)
+	}
+	if _, err := mail.ParseNotify(c.SMTPNotify); err != nil {
+		add("SMTPNotify: %v", err)
+	}
+	if _, err := mail.ParseRedact(c.Redact); err != nil {
+		add("Redact: %v", err)
+	}
+	if _, ok := tlsVersions[c.TLSMinVersion]; !ok && c.TLSMinVersion != "" {
+		add("TLSMinVersion %q is not one of 1.0, 1.1, 1.2 or 1.3", c.TLSMinVersion)
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		add("TLSCertFile and TLSKeyFile must be set together")
+	} else if _, err := c.tlsConfig(c.IMAPHost); err != nil {
+		add("%v", err)
+	}
+	if strings.ContainsAny(c.DisplayName, "\r\n") {
+		add("DisplayName must be a single line")
+	}
+	seen := make(map[string]bool)
+	for i, folder := range c.SyncFolders {
+		switch {
+		case folder.Name == "" || strings.ContainsAny(folder.Name, "\r\n"):
+			add("SyncFolders[%d] needs a Name on a single line", i)
+		case seen[folder.Name]:
+			add("SyncFolders lists %q twice", folder.Name)
+		}
+		seen[folder.Name] = true
+		if folder.Days < 0 || folder.InitialLimit < 0 {
+			add("Days and InitialLimit of SyncFolders %q must not be negative", folder.Name)
+		}
+	}
+	if c.CategoryWriteBack && c.Provider == providerGraph {
+		add("CategoryWriteBack needs an IMAP account: Microsoft Graph accounts have no keywords or labels")
+	}
+	for category, label := range c.CategoryLabels {
+		if strings.ContainsAny(label, "\r\n") || strings.HasPrefix(label, `\`) {
+			add("CategoryLabels of %q must be a single line not starting with a backslash", category)
+		}
+	}
+	for name, folder := range map[string]string{"ArchiveFolder": c.ArchiveFolder, "TrashFolder": c.TrashFolder, "SentFolder": c.SentFolder} {
+		if strings.ContainsAny(folder, "\r\n") {
+			add("%s must be a single line", name)
+		}
+	}
+
+	sort.Strings(problems)
+	return problems
+}
+
+// findAccount returns the account called name: the one with that ID, else
+// the only one whose ID differs in case or whose Username is name. Models
+// often pass a provider or an address ("Gmail", "Me@Example.com") instead of
+// the configured ID, so when nothing matches the error suggests the closest
+// accounts and lists every ID to retry with.
+func findAccount(configs []EmailConfig, name string) (*EmailConfig, error) {
+	for i := range configs {
+		if configs[i].ID == name {
+			return &configs[i], nil
+		}
+	}
+
+	for _, match := range []func(c *EmailConfig) bool{
+		func(c *EmailConfig) bool { return strings.EqualFold(c.ID, name) },
+		func(c *EmailConfig) bool { return c.Username != "" && strings.EqualFold(c.Username, name) },
+	} {
+		var found []*EmailConfig
+		for i := range configs {
+			if match(&configs[i]) {
+				found = append(found, &configs[i])
+			}
+		}
+		if len(found) == 1 {
+			return found[0], nil
+		}
+		if len(found) > 1 {
+			var ids []string
+			for _, c := range found {
+				ids = append(ids, c.ID)
+			}
+			return nil, fmt.Errorf("account %q %w: it matches %s; pass one of these IDs as account", name, errAmbiguousAccount, strings.Join(ids, ", "))
+		}
+	}
+
+	var ids, suggestions []string
+	query := strings.ToLower(strings.TrimSpace(name))
+	for _, c := range configs {
+		ids = append(ids, c.ID)
+		if query != "" && resembles(&c, query) {
+			suggestions = append(suggestions, fmt.Sprintf("%q", c.ID))
+		}
+	}
+	hint := "."
+	if len(suggestions) > 0 {
+		hint = "; did you mean " + strings.Join(suggestions, " or ") + "?"
+	}
+	return nil, fmt.Errorf("%w: %s%s Available accounts: %s", errAccountNotFound, name, hint, strings.Join(ids, ", "))
+}
+
+// resembles reports whether query, in lower case, could be meant for the
+// account: part of its ID, username or server, or its ID misspelt by up to
+// two letters
+func resembles(c *EmailConfig, query string) bool {
+	id := strings.ToLower(c.ID)
+	switch {
+	case strings.Contains(id, query), len(id) >= 3 && strings.Contains(query, id):
+		return true
+	case strings.Contains(strings.ToLower(c.Username), query), strings.Contains(strings.ToLower(c.IMAPHost), query):
+		return true
+	}
+	return editDistance(id, query) <= 2
+}
+
+// editDistance is the Levenshtein distance between a and b, by rune
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
+
+// fromAddress is the From header for mail sent by the account
+func (c *EmailConfig) fromAddress() string {
+	if c.DisplayName == "" {
+		return c.Username
+	}
+	return (&netmail.Address{Name: c.DisplayName, Address: c.Username}).String()
+}
+
+// signed appends the account signature to body unless it is already there
+func (c *EmailConfig) signed(body string) string {
+	if c.Signature == "" || strings.Contains(body, c.Signature) {
+		return body
+	}
+	return strings.TrimRight(body, "\n") + "\n\n-- \n" + c.Signature
+}
+
+// inDailySummary reports whether daily_summary covers the account
+func (c *EmailConfig) inDailySummary() bool {
+	return c.IncludeInDailySummary == nil || *c.IncludeInDailySummary
+}
+
+// movesToTrash reports whether deleting from folder moves emails to the
+// account's TrashFolder rather than expunging them. Deleting from the trash
+// itself is permanent.
+func (c *EmailConfig) movesToTrash(folder string) bool {
+	if folder == "" {
+		folder = "INBOX"
+	}
+	return c.TrashFolder != "" && !strings.EqualFold(folder, c.TrashFolder)
+}
+
+// redactKinds returns the personal data removed from the account's bodies;
+// Redact was checked by validate
+func (c *EmailConfig) redactKinds() []string {
+	kinds, _ := mail.ParseRedact(c.Redact)
+	return kinds
+}
+
+// redaction returns the redactKinds of an account, none when it is unknown
+func (es *EmailServer) redaction(accountID string) []string {
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil
+	}
+	return config.redactKinds()
+}
+
+// redactBody removes the personal data of the account's Redact setting from
+// a body the sync engine stores
+func (es *EmailServer) redactBody(accountID, body string) string {
+	return mail.Redact(body, es.redaction(accountID), nil)
+}
+
+func (c *EmailConfig) passwordSource() string {
+	if c.PasswordSource == "" {
+		return credentials.SourcePlain
+	}
+	return c.PasswordSource
+}
+
+// credentialStore returns the store holding the account password; plain
+// accounts have none
+func (c *EmailConfig) credentialStore() (credentials.Store, error) {
+	return credentials.Open(c.passwordSource(), c.PasswordEnv, credentialOptions())
+}
+
+// resolvePassword reads the password of accounts that keep it outside
+// email_config.json
+func (c *EmailConfig) resolvePassword() error {
+	if c.passwordSource() == credentials.SourcePlain {
+		return nil
+	}
+	store, err := c.credentialStore()
+	if err != nil {
+		return err
+	}
+	c.Password, err = store.Get(c.ID)
+	if c.isGraph() && errors.Is(err, credentials.ErrNotFound) {
+		// Not signed in yet; graph-login stores the refresh token
+		return nil
+	}
+	return err
+}
+
+// tlsVersions are the accepted values of TLSMinVersion
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsConfig returns the TLS settings for connecting to host. The CA bundle
+// and client certificate are read on every connection, so renewed files
+// are used without a restart.
+func (c *EmailConfig) tlsConfig(host string) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         host,
+		MinVersion:         tlsVersions[c.TLSMinVersion],
+		InsecureSkipVerify: c.TLSInsecureSkipVerify,
+	}
+
+	if c.TLSCAFile != "" {
+		data, err := os.ReadFile(c.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLSCAFile: %w", err)
+		}
+		// The internal CA is trusted in addition to the public ones
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("TLSCAFile %s holds no PEM certificates", c.TLSCAFile)
+		}
+		config.RootCAs = pool
+	}
+	if c.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLSCertFile/TLSKeyFile: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// insecureTLSWarning is shown wherever an account skipping certificate
+// verification is loaded or listed
+const insecureTLSWarning = "TLSInsecureSkipVerify is set: server certificates are not verified and the connection, password included, can be intercepted. Only use it in lab environments"
+
+// warnInsecureTLS logs insecureTLSWarning for accounts that need it
+func (c *EmailConfig) warnInsecureTLS() {
+	if c.TLSInsecureSkipVerify {
+		log.Printf("WARNING: account %s: %s", c.ID, insecureTLSWarning)
+	}
+}
+
+func (c *EmailConfig) timeout() time.Duration {
+	if c.TimeoutSeconds <= 0 {
+		return defaultTimeout
+	}
+	return time.Duration(c.TimeoutSeconds) * time.Second
+}
+
+func (c *EmailConfig) dialTimeout() time.Duration {
+	if c.DialTimeoutSeconds <= 0 {
+		return c.timeout()
+	}
+	return time.Duration(c.DialTimeoutSeconds) * time.Second
+}
+
+// accounts returns a snapshot of the configured accounts
+func (es *EmailServer) accounts() []EmailConfig {
+	es.configsMu.RLock()
+	defer es.configsMu.RUnlock()
+	return append([]EmailConfig(nil), es.configs...)
+}
+
+func (es *EmailServer) defaultAccountID() string {
+	es.configsMu.RLock()
+	defer es.configsMu.RUnlock()
+	return es.defaultAccount
+}
+
+// saveAccounts writes configs to path in order, replacing the file
+// atomically. Passwords are only written for plain accounts, and the file is
+// only readable by the owner in case there are any.
+func saveAccounts(path string, configs []EmailConfig) error {
+	var buf bytes.Buffer
+	buf.WriteString("{\n")
+	for i, config := range configs {
+		if config.passwordSource() != credentials.SourcePlain {
+			config.Password = ""
+		}
+		id, _ := json.Marshal(config.ID)
+		value, err := json.MarshalIndent(config, "  ", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode account %s: %w", config.ID, err)
+		}
+		fmt.Fprintf(&buf, "  %s: %s", id, value)
+		if i < len(configs)-1 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("}\n")
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// AccountTest is the outcome of logging in to an account's servers
+type AccountTest struct {
+	Account   string `json:"account"`
+	IMAP      string `json:"imap"`
+	SMTP      string `json:"smtp"`
+	Succeeded bool   `json:"succeeded"`
+	Warning   string `json:"warning,omitempty"`
+
+	imapErr, smtpErr error // nil when the login worked
+}
+
+// testAccount logs in to the IMAP and SMTP servers of config
+func testAccount(ctx context.Context, config *EmailConfig) AccountTest {
+	if config.isGraph() {
+		return testGraphAccount(ctx, config)
+	}
+	result := AccountTest{Account: config.ID, IMAP: "ok", SMTP: "ok", Succeeded: true}
+	if config.TLSInsecureSkipVerify {
+		result.Warning = insecureTLSWarning
+	}
+
+	if c, err := dialIMAP(ctx, config, nil); err != nil {
+		result.IMAP, result.Succeeded, result.imapErr = fmt.Sprintf("failed: %v", err), false, err
+	} else {
+		c.Logout()
+	}
+
+	if c, err := dialSMTP(ctx, config); err != nil {
+		result.SMTP, result.Succeeded, result.smtpErr = fmt.Sprintf("failed: %v", err), false, err
+	} else {
+		c.Quit()
+	}
+
+	return result
+}
+
+func (es *EmailServer) handleListAccounts(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	type accountInfo struct {
+		ID          string `json:"id"`
+		Username    string `json:"username"`
+		DisplayName string `json:"display_name,omitempty"`
+		IMAP        string `json:"imap"`
+		SMTP        string `json:"smtp"`
+		Default     bool   `json:"default"`
+		Warning     string `json:"warning,omitempty"`
+	}
+
+	defaultAccount := es.defaultAccountID()
+	var accounts []accountInfo
+	for _, config := range es.accounts() {
+		info := accountInfo{
+			ID:          config.ID,
+			Username:    config.Username,
+			DisplayName: config.DisplayName,
+			IMAP:        fmt.Sprintf("%s:%d", config.IMAPHost, config.IMAPPort),
+			SMTP:        fmt.Sprintf("%s:%d", config.SMTPHost, config.SMTPPort),
+			Default:     config.ID == defaultAccount,
+		}
+		if config.TLSInsecureSkipVerify {
+			info.Warning = insecureTLSWarning
+		}
+		if config.isGraph() {
+			info.IMAP, info.SMTP = "microsoft graph", "microsoft graph"
+		}
+		accounts = append(accounts, info)
+	}
+
+	accountsJSON, _ := json.MarshalIndent(accounts, "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Configured accounts (%d):\n\n%s", len(accounts), string(accountsJSON)),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleAddAccount(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	config := EmailConfig{IMAPPort: 993, SMTPPort: 587, UseStartTLS: true}
+	config.ID, _ = args["id"].(string)
+	config.Username, _ = args["username"].(string)
+	config.Password, _ = args["password"].(string)
+	config.PasswordSource, _ = args["password_source"].(string)
+	config.PasswordEnv, _ = args["password_env"].(string)
+	config.IMAPHost, _ = args["imap_host"].(string)
+	config.SMTPHost, _ = args["smtp_host"].(string)
+	config.DisplayName, _ = args["display_name"].(string)
+	config.Signature, _ = args["signature"].(string)
+	config.ArchiveFolder, _ = args["archive_folder"].(string)
+	config.TrashFolder, _ = args["trash_folder"].(string)
+	config.SentFolder, _ = args["sent_folder"].(string)
+	config.IMAPAuth, _ = args["imap_auth"].(string)
+	config.SMTPAuth, _ = args["smtp_auth"].(string)
+	config.SMTPHelloName, _ = args["smtp_hello_name"].(string)
+	config.SMTPNotify, _ = args["smtp_notify"].(string)
+	config.Redact, _ = args["redact"].(string)
+	config.TLSCAFile, _ = args["tls_ca_file"].(string)
+	config.TLSCertFile, _ = args["tls_cert_file"].(string)
+	config.TLSKeyFile, _ = args["tls_key_file"].(string)
+	config.TLSMinVersion, _ = args["tls_min_version"].(string)
+	config.TLSInsecureSkipVerify, _ = args["tls_insecure_skip_verify"].(bool)
+	if startTLS, ok := args["use_starttls"].(bool); ok {
+		config.UseStartTLS = startTLS
+	}
+	if include, ok := args["include_in_daily_summary"].(bool); ok {
+		config.IncludeInDailySummary = &include
+	}
+
+	if config.PasswordSource == credentials.SourcePlain {
+		config.PasswordSource = ""
+	}
+	if config.ID == "" || config.Username == "" {
+		return nil, invalidArgument("missing required parameters: id, username")
+	}
+	if config.passwordSource() == credentials.SourceEnv {
+		if config.Password != "" {
+			return nil, fmt.Errorf("password must not be passed with password_source env; set %s instead", config.PasswordEnv)
+		}
+		if config.PasswordEnv != "" {
+			if err := config.resolvePassword(); err != nil {
+				return nil, err
+			}
+		}
+	} else if config.Password == "" {
+		return nil, fmt.Errorf("%w: password", errMissingParameter)
+	}
+	// Servers not given are looked up from the address, with their ports
+	var discovered *autoconfig.Settings
+	if config.IMAPHost == "" || config.SMTPHost == "" {
+		settings, err := (&autoconfig.Discoverer{}).Discover(ctx, config.Username)
+		if err != nil {
+			return nil, fmt.Errorf("give imap_host and smtp_host: %w", err)
+		}
+		discovered = settings
+		if config.IMAPHost == "" {
+			config.IMAPHost, config.IMAPPort = settings.IMAPHost, settings.IMAPPort
+		}
+		if config.SMTPHost == "" {
+			config.SMTPHost, config.SMTPPort = settings.SMTPHost, settings.SMTPPort
+		}
+	}
+	if port, ok := args["imap_port"].(float64); ok {
+		config.IMAPPort = int(port)
+	}
+	if port, ok := args["smtp_port"].(float64); ok {
+		config.SMTPPort = int(port)
+	}
+	if problems := config.validate(); len(problems) > 0 {
+		return nil, invalidArgument("invalid account: %s", strings.Join(problems, "; "))
+	}
+
+	// Check the credentials before saving them, unless asked not to
+	if test, ok := args["test"].(bool); !ok || test {
+		if result := testAccount(ctx, &config); !result.Succeeded {
+			return nil, fmt.Errorf("account test failed (IMAP: %s, SMTP: %s); pass test=false to save it anyway", result.IMAP, result.SMTP)
+		}
+	}
+
+	es.configsMu.Lock()
+	for _, existing := range es.configs {
+		if existing.ID == config.ID {
+			es.configsMu.Unlock()
+			return nil, fmt.Errorf("account already exists: %s", config.ID)
+		}
+	}
+	var store credentials.Store
+	if source := config.passwordSource(); source == credentials.SourceKeyring || source == credentials.SourceFile {
+		var err error
+		if store, err = config.credentialStore(); err == nil {
+			err = store.Set(config.ID, config.Password)
+		}
+		if err != nil {
+			es.configsMu.Unlock()
+			return nil, fmt.Errorf("failed to store password: %w", err)
+		}
+	}
+	configs := append(append([]EmailConfig(nil), es.configs...), config)
+	_, statErr := os.Stat(es.configPath)
+	if err := saveAccounts(es.configPath, configs); err != nil {
+		es.configsMu.Unlock()
+		if store != nil {
+			store.Delete(config.ID)
+		}
+		return nil, err
+	}
+	es.configs = configs
+	es.configsMu.Unlock()
+
+	if es.syncer != nil {
+		es.syncer.AddAccount(config.ID)
+	}
+	es.notifyResourceListChanged()
+	es.notifyToolListChanged()
+
+	text := fmt.Sprintf("Account %s (%s) added and saved to %s", config.ID, config.Username, es.configPath)
+	if config.passwordSource() != credentials.SourcePlain {
+		text += fmt.Sprintf(" (password source: %s)", config.PasswordSource)
+	}
+	if discovered != nil {
+		text += fmt.Sprintf("; servers %s:%d and %s:%d found by %s lookup", config.IMAPHost, config.IMAPPort,
+			config.SMTPHost, config.SMTPPort, discovered.Source)
+	}
+	if os.IsNotExist(statErr) {
+		// The file now takes precedence over the EMAIL_* variables
+		text += "; the file did not exist, so the accounts from environment variables were saved to it too"
+	}
+	config.warnInsecureTLS()
+	if config.TLSInsecureSkipVerify {
+		text += "\n\nWARNING: " + insecureTLSWarning
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: text,
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleRemoveAccount(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["id"].(string)
+	if accountID == "" {
+		return nil, fmt.Errorf("%w: id", errMissingParameter)
+	}
+
+	es.configsMu.Lock()
+	target, err := findAccount(es.configs, accountID)
+	if err != nil {
+		es.configsMu.Unlock()
+		return nil, err
+	}
+	accountID = target.ID
+	var configs []EmailConfig
+	var removed EmailConfig
+	for _, config := range es.configs {
+		if config.ID != accountID {
+			configs = append(configs, config)
+		} else {
+			removed = config
+		}
+	}
+	if len(configs) == 0 {
+		es.configsMu.Unlock()
+		return nil, fmt.Errorf("cannot remove the only account")
+	}
+	if err := saveAccounts(es.configPath, configs); err != nil {
+		es.configsMu.Unlock()
+		return nil, err
+	}
+	es.configs = configs
+	if es.defaultAccount == accountID {
+		es.defaultAccount = configs[0].ID
+	}
+	defaultAccount := es.defaultAccount
+	es.configsMu.Unlock()
+
+	// Environment variables are managed outside the server
+	if source := removed.passwordSource(); source == credentials.SourceKeyring || source == credentials.SourceFile {
+		if store, err := removed.credentialStore(); err != nil {
+			log.Printf("Warning: could not delete the stored password of %s: %v", accountID, err)
+		} else if err := store.Delete(accountID); err != nil {
+			log.Printf("Warning: could not delete the stored password of %s: %v", accountID, err)
+		}
+	}
+
+	if es.syncer != nil {
+		es.syncer.RemoveAccount(accountID)
+	}
+	es.limitsMu.Lock()
+	delete(es.limits, accountID)
+	es.limitsMu.Unlock()
+	es.forgetCapabilities(accountID)
+	es.forgetHealth(accountID)
+	es.notifyResourceListChanged()
+	es.notifyToolListChanged()
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Account %s removed from %s; its synced emails stay in the local database. Default account: %s",
+				accountID, es.configPath, defaultAccount),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleTestAccount(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	// test_account ignores any cool-down, and its outcome starts or clears one
+	result := testAccount(ctx, config)
+	if config.isGraph() {
+		es.recordLogin(ctx, config, serverGraph, result.imapErr)
+	} else {
+		es.recordLogin(ctx, config, serverIMAP, result.imapErr)
+		es.recordLogin(ctx, config, serverSMTP, result.smtpErr)
+	}
+	resultJSON, _ := json.MarshalIndent(result, "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: string(resultJSON),
+		}},
+		IsError: !result.Succeeded,
+	}, nil
+}
+
+// handleMigrateCredentials moves plain-text passwords into the keyring or the
+// encrypted file and rewrites email_config.json without them. Each password
+// is read back before the file is rewritten, so a failed write never loses it.
+func (es *EmailServer) handleMigrateCredentials(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	to, _ := args["to"].(string)
+	if to == "" {
+		to = credentials.SourceKeyring
+	}
+	if to != credentials.SourceKeyring && to != credentials.SourceFile {
+		return nil, fmt.Errorf("unknown destination: %s (use keyring or file)", to)
+	}
+	if _, err := os.Stat(es.configPath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("nothing to migrate: the accounts come from environment variables, not %s", es.configPath)
+	}
+
+	store, err := credentials.Open(to, "", credentialOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	es.configsMu.Lock()
+	defer es.configsMu.Unlock()
+
+	if accountID != "" {
+		config, err := findAccount(es.configs, accountID)
+		if err != nil {
+			return nil, err
+		}
+		accountID = config.ID
+	}
+	configs := append([]EmailConfig(nil), es.configs...)
+	var migrated []string
+	for i := range configs {
+		config := &configs[i]
+		if accountID != "" && config.ID != accountID {
+			continue
+		}
+		if config.passwordSource() != credentials.SourcePlain {
+			if accountID != "" {
+				return nil, fmt.Errorf("account %s already uses password source %s", config.ID, config.PasswordSource)
+			}
+			continue
+		}
+
+		if err := store.Set(config.ID, config.Password); err != nil {
+			return nil, fmt.Errorf("failed to migrate %s: %w", config.ID, err)
+		}
+		if stored, err := store.Get(config.ID); err != nil || stored != config.Password {
+			return nil, fmt.Errorf("failed to migrate %s: the password could not be read back from the %s", config.ID, to)
+		}
+		config.PasswordSource = to
+		migrated = append(migrated, config.ID)
+	}
+	text := "No accounts keep their password in plain text"
+	if len(migrated) > 0 {
+		if err := saveAccounts(es.configPath, configs); err != nil {
+			return nil, err
+		}
+		es.configs = configs
+		text = fmt.Sprintf("Moved the passwords of %d accounts (%s) to the %s; %s no longer contains them",
+			len(migrated), strings.Join(migrated, ", "), to, es.configPath)
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: text,
+		}},
+	}, nil
+}
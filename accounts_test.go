@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindAccount(t *testing.T) {
+	configs := []EmailConfig{
+		{ID: "personal", Username: "me@gmail.com", IMAPHost: "imap.gmail.com"},
+		{ID: "work", Username: "me@corp.com", IMAPHost: "outlook.office365.com"},
+		{ID: "Shared", Username: "team@corp.com", IMAPHost: "imap.corp.com"},
+		{ID: "shared", Username: "ops@corp.com", IMAPHost: "imap.corp.com"},
+	}
+
+	tests := []struct {
+		name    string
+		want    string // ID found, "" for an error
+		wantErr error
+		hints   []string // text the error must hold
+	}{
+		{"work", "work", nil, nil},
+		{"Work", "work", nil, nil},
+		{"ME@GMAIL.COM", "personal", nil, nil},
+		{"Shared", "Shared", nil, nil},
+		{"SHARED", "", errAmbiguousAccount, []string{"Shared, shared"}},
+		{"Gmail", "", errAccountNotFound, []string{`did you mean "personal"?`, "Available accounts: personal, work, Shared, shared"}},
+		{"wrok", "", errAccountNotFound, []string{`did you mean "work"?`}},
+		{"office", "", errAccountNotFound, []string{`did you mean "work"?`}},
+		{"zzzzzzzz", "", errAccountNotFound, []string{"zzzzzzzz. Available accounts:"}},
+	}
+	for _, tt := range tests {
+		config, err := findAccount(configs, tt.name)
+		if tt.wantErr == nil {
+			if err != nil || config.ID != tt.want {
+				t.Errorf("findAccount(%q) = %v, %v; want %s", tt.name, config, err, tt.want)
+			}
+			continue
+		}
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("findAccount(%q) error = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if code, _ := classifyError(err); code != errCodeAccount {
+			t.Errorf("findAccount(%q) error classified as %s", tt.name, code)
+		}
+		for _, hint := range tt.hints {
+			if !strings.Contains(err.Error(), hint) {
+				t.Errorf("findAccount(%q) error = %q, want it to hold %q", tt.name, err, hint)
+			}
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"work", "work", 0},
+		{"work", "wrok", 2},
+		{"work", "works", 1},
+		{"", "abc", 3},
+		{"büro", "buro", 1},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// writeAccounts writes an email_config.json holding data and returns its path
+func writeAccounts(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "email_config.json")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadAccounts(t *testing.T) {
+	path := writeAccounts(t, `{
+		"work": {"IMAPHost": "imap.corp.com", "IMAPPort": 993, "SMTPHost": "smtp.corp.com", "SMTPPort": 587,
+			"Username": "me@corp.com", "Password": "secret", "DisplayName": "Ana Lopez", "TrashFolder": "Trash",
+			"Signature": "Ana", "IncludeInDailySummary": false, "TimeoutSeconds": 10},
+		"home": {"IMAPHost": "imap.home.com", "IMAPPort": 993, "SMTPHost": "smtp.home.com", "SMTPPort": 587,
+			"Username": "me@home.com", "Password": "secret"}
+	}`)
+	configs, defaultAccount, err := loadAccounts(path)
+	if err != nil {
+		t.Fatalf("loadAccounts: %v", err)
+	}
+	if len(configs) != 2 || configs[0].ID != "work" || configs[1].ID != "home" || defaultAccount != "work" {
+		t.Fatalf("loadAccounts = %+v, %s; want work then home, work the default", configs, defaultAccount)
+	}
+
+	work, home := &configs[0], &configs[1]
+	if work.fromAddress() != `"Ana Lopez" <me@corp.com>` || home.fromAddress() != "me@home.com" {
+		t.Errorf("fromAddress = %s, %s", work.fromAddress(), home.fromAddress())
+	}
+	if got := work.signed("Hi\n"); got != "Hi\n\n-- \nAna" {
+		t.Errorf("signed = %q", got)
+	}
+	if work.inDailySummary() || !home.inDailySummary() {
+		t.Error("IncludeInDailySummary is not applied")
+	}
+	if !work.movesToTrash("INBOX") || work.movesToTrash("trash") || home.movesToTrash("INBOX") {
+		t.Error("movesToTrash does not follow TrashFolder")
+	}
+	if work.timeout() != 10*time.Second || work.dialTimeout() != 10*time.Second || home.timeout() != defaultTimeout {
+		t.Errorf("timeouts = %v, %v, %v", work.timeout(), work.dialTimeout(), home.timeout())
+	}
+}
+
+func TestLoadAccountsErrors(t *testing.T) {
+	tests := []struct {
+		name, data string
+		want       []string
+	}{
+		{"unknown setting", `{"work": {"IMAPHots": "imap.corp.com"}}`, []string{`unknown field "IMAPHots"`}},
+		{"no accounts", `{}`, []string{"no accounts defined"}},
+		{"defined twice", `{"work": {}, "work": {}}`, []string{`account "work" is defined more than once`}},
+		{"every problem at once", `{"work": {"IMAPPort": 99999, "SMTPHost": "smtp.corp.com", "SMTPPort": 587, "Username": "me@corp.com",
+			"Password": "x", "TimeoutSeconds": -1, "TLSMinVersion": "1.4", "TLSCertFile": "cert.pem", "DisplayName": "A\nB"}}`, []string{
+			`account "work": IMAPHost is required`,
+			"IMAPPort 99999 is not a valid port",
+			"TimeoutSeconds must not be negative",
+			`TLSMinVersion "1.4" is not one of`,
+			"TLSCertFile and TLSKeyFile must be set together",
+			"DisplayName must be a single line",
+		}},
+		{"password with another source", `{"work": {"IMAPHost": "imap.corp.com", "IMAPPort": 993, "SMTPHost": "smtp.corp.com", "SMTPPort": 587,
+			"Username": "me@corp.com", "Password": "x", "PasswordSource": "keyring"}}`, []string{"Password must be empty when PasswordSource is keyring"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := loadAccounts(writeAccounts(t, tt.data))
+			if err == nil {
+				t.Fatal("loadAccounts succeeded")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("loadAccounts error = %q, want it to hold %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAccountTools(t *testing.T) {
+	es := newTestServer(t)
+	es.configPath = filepath.Join(t.TempDir(), "email_config.json")
+	ctx := context.Background()
+	// Nothing listens on port 1, so SMTP logins fail at once
+	es.configs[0].SMTPHost, es.configs[0].SMTPPort = "127.0.0.1", 1
+	work := es.configs[0]
+
+	add := map[string]interface{}{
+		"id": "home", "username": "username", "password": "password", "test": false,
+		"imap_host": work.IMAPHost, "imap_port": float64(work.IMAPPort), "smtp_host": "127.0.0.1", "smtp_port": float64(1), "use_starttls": false,
+	}
+	captureStdout(t, func() {
+		if _, err := es.handleAddAccount(ctx, add); err != nil {
+			t.Errorf("handleAddAccount: %v", err)
+		}
+	})
+	configs, _, err := loadAccounts(es.configPath)
+	if err != nil || len(configs) != 2 || configs[1].ID != "home" || configs[1].IMAPPort != work.IMAPPort {
+		t.Fatalf("saved accounts = %+v, %v; want work and home", configs, err)
+	}
+	if _, err := es.handleAddAccount(ctx, add); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("adding home again = %v, want it to exist already", err)
+	}
+
+	result, err := es.handleListAccounts(ctx, nil)
+	if err != nil {
+		t.Fatalf("handleListAccounts: %v", err)
+	}
+	if text := resultText(t, result); !strings.Contains(text, "Configured accounts (2)") || !strings.Contains(text, `"id": "home"`) {
+		t.Errorf("list_accounts = %q", text)
+	}
+
+	// The IMAP login works; there is no SMTP server to log in to
+	result, err = es.handleTestAccount(ctx, map[string]interface{}{"account": "home"})
+	if err != nil {
+		t.Fatalf("handleTestAccount: %v", err)
+	}
+	if tr := result.(ToolResult); !tr.IsError || !strings.Contains(tr.Content[0].Text, `"imap": "ok"`) {
+		t.Errorf("test_account = %+v, want IMAP ok and SMTP failed", tr)
+	}
+	if h := es.health[healthKey{"home", serverSMTP}]; h == nil || h.Failures != 1 {
+		t.Errorf("SMTP health after test_account = %+v, want the failure recorded", h)
+	}
+
+	captureStdout(t, func() {
+		if _, err := es.handleRemoveAccount(ctx, map[string]interface{}{"id": "HOME"}); err != nil {
+			t.Errorf("handleRemoveAccount: %v", err)
+		}
+	})
+	if configs, _, _ := loadAccounts(es.configPath); len(configs) != 1 || configs[0].ID != "work" {
+		t.Errorf("accounts after removing home = %+v", configs)
+	}
+	if h := es.health[healthKey{"home", serverSMTP}]; h != nil {
+		t.Error("health of the removed account kept")
+	}
+	if _, err := es.handleRemoveAccount(ctx, map[string]interface{}{"id": "work"}); err == nil {
+		t.Error("removing the only account succeeded")
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"email-mcp-server/ai"
+	"email-mcp-server/config"
+	"email-mcp-server/storage"
+)
+
+// Action rules from the action_rules of priority_rules.json run after every
+// sync of an account, followed by auto_archive_ignored when ignored mail is
+// archived. Each rule sends the emails it matches to bulkAction in one
+// command, after marking them read first with mark_read, and records them in
+// the applied_actions table, which keeps the rule from acting on them
+// again; a dry run only logs and records what the rule would do. Categories are the stored classifications, or for
+// emails never classified what the classification rules say, so the LLM is
+// not consulted during sync.
+
+func (es *EmailServer) applyActionRules(ctx context.Context, accountID string) {
+	if es.rules == nil || es.db == nil {
+		return
+	}
+	es.rulesMu.RLock()
+	rules := es.rules.ForAccount(accountID).Actions
+	es.rulesMu.RUnlock()
+	for _, rule := range rules {
+		if _, err := es.applyActionRule(ctx, accountID, rule, time.Now()); err != nil {
+			log.Printf("Action rule %q of %s failed: %v", rule.Name, accountID, err)
+		}
+	}
+}
+
+// applyActionRule runs rule over the synced emails of the account and
+// returns what it applied
+func (es *EmailServer) applyActionRule(ctx context.Context, accountID string, rule config.ActionRule, now time.Time) ([]storage.AppliedAction, error) {
+	folder := rule.Folder
+	if folder == "" {
+		folder = "INBOX"
+	}
+	var before time.Time
+	if rule.OlderThanDays > 0 {
+		before = now.AddDate(0, 0, -rule.OlderThanDays)
+	}
+
+	candidates, err := es.db.ActionCandidates(accountID, folder, rule.Name, rule.DryRun, before)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []storage.AppliedAction
+	var uids []uint32
+	for i := range candidates {
+		email := &candidates[i]
+		if !es.matchesActionRule(rule, email) {
+			continue
+		}
+		applied = append(applied, storage.AppliedAction{
+			AccountID:   accountID,
+			Folder:      folder,
+			UID:         email.UID,
+			MessageID:   email.MessageID,
+			From:        email.From,
+			Subject:     email.Subject,
+			Rule:        rule.Name,
+			Action:      rule.Action,
+			Destination: rule.Destination,
+			DryRun:      rule.DryRun,
+		})
+		uids = append(uids, email.UID)
+	}
+	if len(applied) == 0 {
+		return nil, nil
+	}
+
+	if rule.DryRun {
+		for _, a := range applied {
+			log.Printf("Dry run: action rule %q would %s %q from %s [%s/%d]",
+				rule.Name, describeRuleAction(rule), a.Subject, a.From, folder, a.UID)
+		}
+	} else {
+		if rule.MarkRead {
+			start := time.Now()
+			_, err := es.bulkAction(ctx, accountID, folder, uids, config.ActionMarkRead, "")
+			es.recordAudit(&storage.AuditEntry{
+				Actor:     "action_rule:" + rule.Name,
+				Operation: "bulk_action",
+				AccountID: accountID,
+				Result:    fmt.Sprintf("%d emails: %s", len(uids), describeBulkAction(config.ActionMarkRead, "")),
+			}, map[string]interface{}{"folder": folder, "uids": uids, "action": config.ActionMarkRead}, start, err)
+			if err != nil {
+				return nil, err
+			}
+		}
+		start := time.Now()
+		destination, err := es.bulkAction(ctx, accountID, folder, uids, rule.Action, rule.Destination)
+		es.recordAudit(&storage.AuditEntry{
+			Actor:     "action_rule:" + rule.Name,
+			Operation: "bulk_action",
+			AccountID: accountID,
+			Result:    fmt.Sprintf("%d emails: %s", len(uids), describeBulkAction(rule.Action, destination)),
+		}, map[string]interface{}{"folder": folder, "uids": uids, "action": rule.Action, "destination": rule.Destination}, start, err)
+		if err != nil {
+			return nil, err
+		}
+		for i := range applied {
+			applied[i].Destination = destination
+		}
+		log.Printf("Action rule %q applied %s to %d emails of %s", rule.Name, describeBulkAction(rule.Action, destination), len(applied), accountID)
+	}
+
+	if err := es.db.RecordAppliedActions(applied); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// describeRuleAction describes what rule does to the emails it matches
+func describeRuleAction(rule config.ActionRule) string {
+	action := describeBulkAction(rule.Action, rule.Destination)
+	if rule.MarkRead {
+		return "mark read and " + action
+	}
+	return action
+}
+
+// matchesActionRule checks the criteria of rule other than the age, which
+// ActionCandidates already applied
+func (es *EmailServer) matchesActionRule(rule config.ActionRule, email *storage.ActionCandidate) bool {
+	if rule.VIP && !es.isVIP(email.AccountID, email.FromAddress) {
+		return false
+	}
+	if rule.Ignored && !es.isIgnored(email.AccountID, email.FromAddress, email.Subject) {
+		return false
+	}
+
+	message := ai.Email{
+		AccountID:   email.AccountID,
+		Folder:      email.Folder,
+		UID:         email.UID,
+		MessageID:   email.MessageID,
+		From:        email.From,
+		To:          email.To,
+		Subject:     email.Subject,
+		Body:        email.BodySnippet,
+		Date:        email.Date,
+		Automated:   email.Automated,
+		Attachments: email.Attachments,
+	}
+	if !ai.MatchesConditions(rule.Conditions, message) {
+		return false
+	}
+
+	if rule.Category == "" {
+		return true
+	}
+	category := email.Category
+	if category == "" && es.classifier != nil {
+		category = es.classifier.ClassifyByRules(message).Category
+	}
+	return strings.EqualFold(category, rule.Category)
+}
+
+func (es *EmailServer) handleGetAppliedActions(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("action rules are not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	if accountID != "" {
+		config, err := es.getConfig(accountID)
+		if err != nil {
+			return nil, err
+		}
+		accountID = config.ID
+	}
+	rule, _ := args["rule"].(string)
+	limit := 50
+	if l, ok := args["limit"].(float64); ok && l >= 1 {
+		limit = int(l)
+	}
+
+	actions, err := es.db.ListAppliedActions(accountID, rule, time.Time{}, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	actionsJSON, _ := json.MarshalIndent(actions, "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("%d applied action(s):\n%s", len(actions), string(actionsJSON)),
+		}},
+	}, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"email-mcp-server/ai"
+	"email-mcp-server/storage"
+)
+
+func (es *EmailServer) handleExtractActionItems(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	var emails []ai.Email
+	title := ""
+
+	_, hasThreadID := args["thread_id"].(string)
+	if thread, _ := args["thread"].(bool); thread || hasThreadID {
+		threadID, stored, err := es.threadFromArgs(args)
+		if err != nil {
+			return nil, err
+		}
+		for _, email := range stored {
+			emails = append(emails, es.fullEmail(ctx, email))
+		}
+		title = fmt.Sprintf("thread %s (%d messages)", threadID, len(stored))
+	} else {
+		id, ok := args["id"].(float64)
+		if !ok {
+			return nil, fmt.Errorf("%w: id or thread_id", errMissingParameter)
+		}
+		accountID, _ := args["account"].(string)
+		folder, _ := args["folder"].(string)
+		if folder == "" {
+			folder = "INBOX"
+		}
+		config, err := es.getConfig(accountID)
+		if err != nil {
+			return nil, err
+		}
+
+		msg, err := es.getEmailBody(ctx, config.ID, folder, uint32(id))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get email: %w", err)
+		}
+		emails = append(emails, ai.Email{
+			AccountID: config.ID,
+			Folder:    folder,
+			UID:       msg.ID,
+			From:      msg.From,
+			To:        msg.To,
+			Subject:   msg.Subject,
+			Body:      msg.Body,
+			Date:      msg.Date,
+		})
+		title = fmt.Sprintf("email %d (%s)", msg.ID, msg.Subject)
+	}
+
+	result := es.actions.Extract(ctx, emails)
+	if es.db != nil {
+		es.saveActionItems(emails, result)
+	}
+
+	resultJSON, _ := json.MarshalIndent(result, "", "  ")
+	return ToolResult{
+		Content: []TextContent{
+			{Type: "text", Text: formatActionItems(title, result.Items)},
+			{Type: "text", Text: string(resultJSON)},
+		},
+	}, nil
+}
+
+// saveActionItems stores the action items found in each scanned email,
+// clearing those of emails that no longer ask for anything
+func (es *EmailServer) saveActionItems(emails []ai.Email, result *ai.ActionItems) {
+	for _, email := range emails {
+		stored := []storage.ActionItem{}
+		for _, item := range result.Items {
+			if item.Folder == email.Folder && item.UID == email.UID {
+				stored = append(stored, storage.ActionItem{
+					Action:      item.Action,
+					Owner:       item.Owner,
+					Due:         item.Due,
+					RequestedBy: item.RequestedBy,
+					Method:      result.Method,
+				})
+			}
+		}
+		if err := es.db.SaveActionItems(email.AccountID, email.Folder, email.UID, stored); err != nil {
+			log.Printf("Failed to store action items of %s/%d: %v", email.Folder, email.UID, err)
+		}
+	}
+}
+
+func formatActionItems(title string, items []ai.ActionItem) string {
+	if len(items) == 0 {
+		return fmt.Sprintf("No action items in %s", title)
+	}
+
+	now := time.Now()
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d action items in %s:\n", len(items), title)
+	for _, item := range items {
+		fmt.Fprintf(&b, "- %s", item.Action)
+		var details []string
+		if item.Owner != "" {
+			details = append(details, "owner: "+item.Owner)
+		}
+		if item.Due != nil {
+			details = append(details, "due "+formatDigestDay(item.Due.Local(), now))
+		}
+		if len(details) > 0 {
+			fmt.Fprintf(&b, " (%s)", strings.Join(details, ", "))
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
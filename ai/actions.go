@@ -0,0 +1,257 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"email-mcp-server/config"
+)
+
+// ActionItem is something an email asks someone to do
+type ActionItem struct {
+	Action      string     `json:"action"`
+	Owner       string     `json:"owner,omitempty"` // who should do it, when it can be told
+	Due         *time.Time `json:"due,omitempty"`
+	RequestedBy string     `json:"requested_by,omitempty"`
+	Folder      string     `json:"folder"` // the email it was found in
+	UID         uint32     `json:"uid"`
+}
+
+// ActionItems is the result of extracting the action items of an email or a
+// thread
+type ActionItems struct {
+	Items    []ActionItem `json:"items"`
+	Method   string       `json:"method"` // "llm" or "rules"
+	Provider string       `json:"provider,omitempty"`
+	Model    string       `json:"model,omitempty"`
+	Note     string       `json:"note,omitempty"`
+}
+
+// ActionExtractor finds action items with the configured LLM, falling back to
+// ExtractActionItems when no provider is available or a request fails
+type ActionExtractor struct {
+	ai       *config.AIConfig
+	provider Provider
+}
+
+// NewActionExtractor creates an action item extractor. provider may be nil.
+func NewActionExtractor(cfg *config.AIConfig, provider Provider) *ActionExtractor {
+	return &ActionExtractor{ai: cfg, provider: provider}
+}
+
+// Extract finds the action items of emails, given in chronological order
+func (x *ActionExtractor) Extract(ctx context.Context, emails []Email) *ActionItems {
+	if x.provider == nil {
+		return &ActionItems{Items: ExtractActionItems(emails), Method: "rules",
+			Note: "No LLM provider configured; action items found by pattern matching"}
+	}
+
+	reply, err := x.provider.Complete(ctx, Request{
+		System:      actionSystemPrompt,
+		Prompt:      buildPrompt("thread", emails) + "\nList the action items.",
+		MaxTokens:   x.ai.MaxTokens * 2,
+		Temperature: 0,
+	})
+	var items []ActionItem
+	if err == nil {
+		items, err = parseActionItems(reply, emails)
+	}
+	if err != nil {
+		return &ActionItems{Items: ExtractActionItems(emails), Method: "rules",
+			Note: fmt.Sprintf("LLM request failed (%v); action items found by pattern matching", err)}
+	}
+	return &ActionItems{Items: items, Method: "llm", Provider: x.provider.Name(), Model: x.provider.Model()}
+}
+
+const actionSystemPrompt = `You extract action items from emails: things someone is asked to do, or promises to do.
+Leave out greetings, information and questions that need no work.
+Reply with JSON only: {"action_items": [{"message": 1, "action": "short imperative sentence", "owner": "name or address, or empty", "due": "YYYY-MM-DD or empty"}]}
+"message" is the number of the message the item comes from. Use {"action_items": []} when there are none.`
+
+func parseActionItems(reply string, emails []Email) ([]ActionItem, error) {
+	var parsed struct {
+		ActionItems []struct {
+			Message int    `json:"message"`
+			Action  string `json:"action"`
+			Owner   string `json:"owner"`
+			Due     string `json:"due"`
+		} `json:"action_items"`
+	}
+	if err := extractJSON(reply, &parsed); err != nil {
+		return nil, err
+	}
+
+	items := []ActionItem{}
+	for _, p := range parsed.ActionItems {
+		if strings.TrimSpace(p.Action) == "" {
+			continue
+		}
+		email := emails[len(emails)-1]
+		if p.Message >= 1 && p.Message <= len(emails) {
+			email = emails[p.Message-1]
+		}
+		item := ActionItem{
+			Action:      strings.TrimSpace(p.Action),
+			Owner:       strings.TrimSpace(p.Owner),
+			RequestedBy: email.From,
+			Folder:      email.Folder,
+			UID:         email.UID,
+		}
+		if day, err := time.ParseInLocation("2006-01-02", p.Due, time.Local); err == nil {
+			due := day.Add(24*time.Hour - time.Second)
+			item.Due = &due
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// actionPhrases mark a sentence as a request
+var actionPhrases = []string{
+	"please", "could you", "can you", "would you", "need you to", "needs to", "make sure", "don't forget",
+	"do not forget", "remember to", "action:", "todo:", "to do:",
+	"por favor", "puedes", "podrías", "podrias", "necesito que", "recuerda", "no olvides",
+}
+
+// promisePrefixes open a sentence in which the sender commits to something
+var promisePrefixes = []string{"i will", "i'll", "we will", "we'll", "me encargo", "yo me encargo"}
+
+// followUpPromises are phrases in which the sender commits to writing again
+var followUpPromises = []string{
+	"get back to you", "i'll follow up", "i will follow up", "i'll let you know", "i will let you know",
+	"let me check", "i'll check and", "i'll come back to you", "will revert",
+	"te respondo", "te contesto", "te digo algo", "te confirmo", "te aviso", "lo reviso y",
+}
+
+// notActionPhrases are polite formulas that contain an action phrase but ask
+// for nothing
+var notActionPhrases = []string{
+	"please find", "please see attached", "please note", "please do not reply", "please don't reply",
+	"if you have any questions", "feel free to",
+}
+
+// actionHeadings introduce a list whose every item is an action
+var actionHeadings = regexp.MustCompile(`(?i)^(?:action items|next steps|to ?dos?|tasks|pr[oó]ximos pasos|tareas|pendientes)\s*:?$`)
+
+var (
+	sentenceEnd = regexp.MustCompile(`[.!?]+(?:\s+|$)`)
+	listMarker  = regexp.MustCompile(`^(?:[-*•]|\d+[.)]|\[ ?\])\s+`)
+	// "Ana, please..." or "@ana please..."
+	addressee = regexp.MustCompile(`^@?(\p{Lu}[\p{L}'-]+)\s*[,:]\s+`)
+	mention   = regexp.MustCompile(`(?:^|\s)@([\p{L}][\p{L}\d._-]*)`)
+	greeting  = regexp.MustCompile(`(?i)^(?:hi|hello|hey|dear|hola|buenos d[ií]as|buenas)\b[^,:]*[,:]?\s*`)
+)
+
+// notNames are capitalized words that open a sentence without naming anyone
+var notNames = map[string]bool{"thanks": true, "thank": true, "also": true, "so": true, "ok": true, "gracias": true}
+
+// ExtractActionItems finds action items by pattern matching: sentences with a
+// request such as "please" or "could you", promises such as "I'll", and the
+// items of lists under headings such as "Next steps:". The owner is the
+// person addressed or mentioned, the sender for promises, or the only
+// recipient; the due date is the first deadline in the sentence.
+func ExtractActionItems(emails []Email) []ActionItem {
+	items := []ActionItem{}
+	for _, email := range emails {
+		seen := make(map[string]bool)
+		sent := email.Date
+		if sent.IsZero() {
+			sent = time.Now()
+		}
+
+		inList := false
+		for _, line := range strings.Split(StripQuoted(email.Body), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				inList = false
+				continue
+			}
+			if actionHeadings.MatchString(line) {
+				inList = true
+				continue
+			}
+
+			listed := inList && listMarker.MatchString(line)
+			line = listMarker.ReplaceAllString(line, "")
+			for _, sentence := range splitSentences(line) {
+				lower := strings.ToLower(sentence)
+				promise := false
+				for _, prefix := range promisePrefixes {
+					promise = promise || strings.HasPrefix(lower, prefix)
+				}
+				if !listed && !promise && (!containsAny(lower, actionPhrases) || containsAny(lower, notActionPhrases)) {
+					continue
+				}
+				if seen[lower] {
+					continue
+				}
+				seen[lower] = true
+
+				item := ActionItem{
+					Action:      sentence,
+					Owner:       actionOwner(sentence, email, promise),
+					RequestedBy: email.From,
+					Folder:      email.Folder,
+					UID:         email.UID,
+				}
+				if deadlines := DetectDeadlines(sentence, sent); len(deadlines) > 0 {
+					item.Due = &deadlines[0].Due
+				}
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// FollowUpPromise returns the first sentence of text in which the sender
+// promises to get back to the recipient, such as "I'll get back to you
+// tomorrow", or "" if there is none
+func FollowUpPromise(text string) string {
+	for _, line := range strings.Split(StripQuoted(text), "\n") {
+		for _, sentence := range splitSentences(strings.TrimSpace(line)) {
+			if containsAny(strings.ToLower(sentence), followUpPromises) {
+				return sentence
+			}
+		}
+	}
+	return ""
+}
+
+func splitSentences(line string) []string {
+	var sentences []string
+	for _, s := range sentenceEnd.Split(greeting.ReplaceAllString(line, ""), -1) {
+		if s = strings.TrimSpace(s); len(strings.Fields(s)) >= 2 {
+			sentences = append(sentences, s)
+		}
+	}
+	return sentences
+}
+
+func actionOwner(sentence string, email Email, promise bool) string {
+	if promise {
+		return replyAddress(email.From)
+	}
+	if m := addressee.FindStringSubmatch(sentence); m != nil && !notNames[strings.ToLower(m[1])] {
+		return m[1]
+	}
+	if m := mention.FindStringSubmatch(sentence); m != nil {
+		return m[1]
+	}
+	if len(email.To) == 1 {
+		return replyAddress(email.To[0])
+	}
+	return ""
+}
+
+func containsAny(text string, phrases []string) bool {
+	for _, phrase := range phrases {
+		if strings.Contains(text, phrase) {
+			return true
+		}
+	}
+	return false
+}
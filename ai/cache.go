@@ -0,0 +1,122 @@
+package ai
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// CacheStats counts the lookups of a Cache
+type CacheStats struct {
+	Hits      int `json:"hits"`
+	Misses    int `json:"misses"`
+	Evictions int `json:"evictions"` // entries dropped to stay under the size bound
+	Entries   int `json:"entries"`
+}
+
+// Cache is a least recently used cache of at most maxEntries values that
+// expire ttl after they are stored. It is safe for concurrent use.
+type Cache[V any] struct {
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	order      *list.List // most recently used first
+	entries    map[string]*list.Element
+	stats      CacheStats
+}
+
+type cacheEntry[V any] struct {
+	key     string
+	value   V
+	expires time.Time
+}
+
+// NewCache creates a cache; ttl <= 0 disables caching, maxEntries <= 0
+// leaves the size unbounded
+func NewCache[V any](maxEntries int, ttl time.Duration) *Cache[V] {
+	if ttl <= 0 {
+		return nil
+	}
+	return &Cache[V]{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// Get returns the value stored for key unless it expired, marking it the
+// most recently used. A nil cache never has a value.
+func (c *Cache[V]) Get(key string) (V, bool) {
+	var zero V
+	if c == nil {
+		return zero, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if ok && time.Now().After(elem.Value.(*cacheEntry[V]).expires) {
+		c.remove(elem)
+		ok = false
+	}
+	if !ok {
+		c.stats.Misses++
+		return zero, false
+	}
+	c.stats.Hits++
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cacheEntry[V]).value, true
+}
+
+// Put stores value for key, then drops expired entries and, past
+// maxEntries, the least recently used ones. A nil cache stores nothing.
+func (c *Cache[V]) Put(key string, value V) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry[V])
+		entry.value, entry.expires = value, now.Add(c.ttl)
+		c.order.MoveToFront(elem)
+	} else {
+		c.entries[key] = c.order.PushFront(&cacheEntry[V]{key: key, value: value, expires: now.Add(c.ttl)})
+	}
+
+	for back := c.order.Back(); back != nil; back = c.order.Back() {
+		switch {
+		case now.After(back.Value.(*cacheEntry[V]).expires):
+		case c.maxEntries > 0 && c.order.Len() > c.maxEntries:
+			c.stats.Evictions++
+		default:
+			return
+		}
+		c.remove(back)
+	}
+}
+
+func (c *Cache[V]) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry[V]).key)
+}
+
+// Stats returns the lookup counters and the number of entries. A nil cache
+// has none.
+func (c *Cache[V]) Stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = c.order.Len()
+	return stats
+}
@@ -0,0 +1,577 @@
+package ai
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"email-mcp-server/config"
+	"email-mcp-server/mail"
+	"email-mcp-server/pool"
+)
+
+// Classification methods
+const (
+	MethodRules  = "rules"
+	MethodAI     = "ai"
+	MethodHybrid = "hybrid"
+	// MethodLearned marks categories taken from a learned sender mapping
+	MethodLearned = "learned"
+)
+
+// DefaultCategory is assigned when neither rules nor the LLM decide
+const DefaultCategory = "general"
+
+// CategoryAutomated is assigned to bounces, out-of-office replies and other
+// messages whose headers say a program sent them; the kind is its tag
+const CategoryAutomated = "automated"
+
+// TagNeedsReview marks classifications less confident than the review
+// threshold, listed by the review_queue tool
+const TagNeedsReview = "needs_review"
+
+// Classification is the category assigned to an email
+type Classification struct {
+	Category     string    `json:"category"`
+	Confidence   float64   `json:"confidence"`
+	Rule         string    `json:"rule,omitempty"` // rule that decided the category, if any
+	Tags         []string  `json:"tags,omitempty"`
+	Method       string    `json:"method"` // rules, ai, hybrid or learned
+	Reasoning    string    `json:"reasoning"`
+	ClassifiedAt time.Time `json:"classified_at"`
+}
+
+// ClassifierStats counts how emails were classified
+type ClassifierStats struct {
+	Total       int            `json:"total"`
+	ByMethod    map[string]int `json:"by_method"`
+	ByCategory  map[string]int `json:"by_category"`
+	AIRequests  int            `json:"ai_requests"`
+	AIErrors    int            `json:"ai_errors"`
+	RateLimited int            `json:"rate_limited"`
+	CacheHits   int            `json:"cache_hits"`
+	CacheMisses int            `json:"cache_misses"`
+	// Cached LLM results, and how many were dropped for cache_max_entries
+	CacheEntries   int `json:"cache_entries"`
+	CacheEvictions int `json:"cache_evictions"`
+}
+
+// Classifier assigns categories with rules first and, for emails the rules
+// are unsure about, the configured LLM
+type Classifier struct {
+	rules    ruleSet
+	accounts map[string]ruleSet // merged rules of accounts with their own section
+	cfg      config.ClassificationConfig
+	learning config.LearningConfig
+	ai       *config.AIConfig
+	provider Provider
+	limiter  *RateLimiter
+
+	cache *Cache[Classification] // LLM results by email; nil when caching is off
+
+	mu          sync.Mutex
+	stats       ClassifierStats
+	learned     map[string]LearnedSender // by lowercase sender address
+	adjustments map[string]float64       // learned confidence change by rule name
+}
+
+// NewClassifier creates a classifier. provider may be nil, in which case only
+// rules are used.
+func NewClassifier(rules *config.Rules, cfg *config.AIConfig, provider Provider) *Classifier {
+	return &Classifier{
+		rules:       newRuleSet(rules),
+		accounts:    accountRules(rules),
+		cfg:         cfg.Classification,
+		learning:    cfg.Learning,
+		ai:          cfg,
+		provider:    provider,
+		limiter:     NewRateLimiter(cfg.Classification.RateLimitPerMinute),
+		cache:       NewCache[Classification](cfg.Classification.CacheMaxEntries, time.Duration(cfg.Classification.CacheTTLMinutes)*time.Minute),
+		stats:       ClassifierStats{ByMethod: make(map[string]int), ByCategory: make(map[string]int)},
+		learned:     make(map[string]LearnedSender),
+		adjustments: make(map[string]float64),
+	}
+}
+
+// ruleSet holds classification rules in evaluation order with the strategy
+// that chooses among their matches
+type ruleSet struct {
+	rules    []config.ClassificationRule
+	strategy string
+}
+
+func newRuleSet(rules *config.Rules) ruleSet {
+	ordered := slices.Clone(rules.Classification)
+	slices.SortStableFunc(ordered, func(a, b config.ClassificationRule) int { return b.Priority - a.Priority })
+	return ruleSet{rules: ordered, strategy: cmp.Or(rules.MatchStrategy, config.MatchBestConfidence)}
+}
+
+// accountRules merges the rules of every account that has its own section
+func accountRules(rules *config.Rules) map[string]ruleSet {
+	accounts := make(map[string]ruleSet, len(rules.Accounts))
+	for accountID := range rules.Accounts {
+		accounts[accountID] = newRuleSet(rules.ForAccount(accountID))
+	}
+	return accounts
+}
+
+// rulesFor returns the rules that apply to the emails of accountID
+func (c *Classifier) rulesFor(accountID string) ruleSet {
+	if rules, ok := c.accounts[accountID]; ok {
+		return rules
+	}
+	return c.rules
+}
+
+// Classify categorizes an email with the rules of its account. The LLM is consulted only when the best rule
+// match is below the confidence threshold; if that request fails, the rule
+// result is returned when fallback_to_rules is set. Results still below the
+// review threshold are tagged TagNeedsReview.
+func (c *Classifier) Classify(ctx context.Context, email Email) (*Classification, error) {
+	result := c.ClassifyByRules(email)
+
+	if c.provider != nil && c.cfg.UseAI && result.Confidence < c.cfg.ConfidenceThreshold {
+		aiResult, err := c.classifyWithAI(ctx, email, result)
+		if err != nil {
+			if !c.cfg.FallbackToRules {
+				return nil, err
+			}
+			result.Reasoning += fmt.Sprintf("; AI not used: %v", err)
+		} else {
+			result = aiResult
+		}
+	}
+
+	if result.Confidence < c.cfg.ReviewThreshold && !slices.Contains(result.Tags, TagNeedsReview) {
+		// Tags may be shared with a rule or the cache
+		result.Tags = append(slices.Clone(result.Tags), TagNeedsReview)
+	}
+
+	c.record(result)
+	return result, nil
+}
+
+// ClassifyBatch classifies emails with up to workers calls of Classify at a
+// time, returning the result and error of each email in the same order. LLM
+// requests still honor the rate limit.
+func (c *Classifier) ClassifyBatch(ctx context.Context, emails []Email, workers int) ([]*Classification, []error) {
+	results := make([]*Classification, len(emails))
+	errs := pool.Run(ctx, len(emails), workers, func(ctx context.Context, i int) error {
+		result, err := c.Classify(ctx, emails[i])
+		results[i] = result
+		return err
+	})
+	return results, errs
+}
+
+// GetStats returns a snapshot of the classification counters
+func (c *Classifier) GetStats() ClassifierStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.ByMethod = make(map[string]int, len(c.stats.ByMethod))
+	for k, v := range c.stats.ByMethod {
+		stats.ByMethod[k] = v
+	}
+	stats.ByCategory = make(map[string]int, len(c.stats.ByCategory))
+	for k, v := range c.stats.ByCategory {
+		stats.ByCategory[k] = v
+	}
+
+	cache := c.cache.Stats()
+	stats.CacheHits, stats.CacheMisses = cache.Hits, cache.Misses
+	stats.CacheEntries, stats.CacheEvictions = cache.Entries, cache.Evictions
+	return stats
+}
+
+// ClassifyByRules returns CategoryAutomated for messages a program sent,
+// otherwise the category the match strategy of the account's rules picks
+// among the matching rules, or DefaultCategory with zero confidence when no
+// rule matches. The Reasoning names the rules and the strategy. A learned
+// sender mapping wins over rules that are less confident. The LLM is never
+// asked.
+func (c *Classifier) ClassifyByRules(email Email) *Classification {
+	if email.Automated != "" {
+		// The headers are conclusive, whatever the content looks like
+		return &Classification{
+			Category:     CategoryAutomated,
+			Confidence:   1,
+			Tags:         []string{email.Automated},
+			Method:       MethodRules,
+			Reasoning:    fmt.Sprintf("sent by a program (%s)", email.Automated),
+			ClassifiedAt: time.Now(),
+		}
+	}
+
+	result := &Classification{
+		Category:     DefaultCategory,
+		Method:       MethodRules,
+		Reasoning:    "no rule matched",
+		ClassifiedAt: time.Now(),
+	}
+
+	set := c.rulesFor(email.AccountID)
+	var matches []ruleMatch
+	for _, rule := range set.rules {
+		confidence := c.ruleConfidence(rule)
+		if confidence <= 0 || !c.matchesRule(rule, email) {
+			continue
+		}
+		matches = append(matches, ruleMatch{rule, confidence})
+		if rule.Exclusive || set.strategy == config.MatchFirst {
+			break
+		}
+	}
+	if len(matches) > 0 {
+		applyMatches(result, matches, set.strategy)
+	}
+
+	c.mu.Lock()
+	learned, ok := c.learned[senderAddress(email.From)]
+	c.mu.Unlock()
+	if ok && learned.Confidence > result.Confidence {
+		result.Category = learned.Category
+		result.Confidence = learned.Confidence
+		result.Rule = ""
+		result.Tags = nil
+		result.Method = MethodLearned
+		result.Reasoning = fmt.Sprintf("sender learned from %d corrections", learned.Samples)
+	}
+	return result
+}
+
+// ruleMatch is a rule that matched an email, with its learned confidence
+type ruleMatch struct {
+	rule       config.ClassificationRule
+	confidence float64
+}
+
+// applyMatches sets result from the rules that matched, in evaluation order,
+// as strategy chooses
+func applyMatches(result *Classification, matches []ruleMatch, strategy string) {
+	best := matches[0]
+	names := []string{fmt.Sprintf("%q", best.rule.Name)}
+	var path string
+	switch strategy {
+	case config.MatchFirst:
+		result.Category, result.Confidence, result.Rule, result.Tags = best.rule.Category, best.confidence, best.rule.Name, best.rule.Tags
+		path = fmt.Sprintf("first_match, priority %d", best.rule.Priority)
+
+	case config.MatchWeighted:
+		// Each matching rule is independent evidence for its category
+		combined := make(map[string]float64)
+		var categories []string
+		for _, m := range matches {
+			if _, ok := combined[m.rule.Category]; !ok {
+				categories = append(categories, m.rule.Category)
+			}
+			combined[m.rule.Category] = 1 - (1-combined[m.rule.Category])*(1-m.confidence)
+		}
+		category := categories[0]
+		for _, c := range categories[1:] {
+			if combined[c] > combined[category] {
+				category = c
+			}
+		}
+
+		names, result.Rule, result.Tags = nil, "", nil
+		for _, m := range matches {
+			if m.rule.Category != category {
+				continue
+			}
+			if result.Rule == "" {
+				result.Rule = m.rule.Name
+			}
+			names = append(names, fmt.Sprintf("%q", m.rule.Name))
+			for _, tag := range m.rule.Tags {
+				if !slices.Contains(result.Tags, tag) {
+					result.Tags = append(result.Tags, tag)
+				}
+			}
+		}
+		result.Category, result.Confidence = category, combined[category]
+		path = fmt.Sprintf("weighted, %d of %d matches for %s", len(names), len(matches), category)
+
+	default:
+		for _, m := range matches[1:] {
+			if m.confidence > best.confidence {
+				best = m
+			}
+		}
+		result.Category, result.Confidence, result.Rule, result.Tags = best.rule.Category, best.confidence, best.rule.Name, best.rule.Tags
+		names[0] = fmt.Sprintf("%q", best.rule.Name)
+		path = config.MatchBestConfidence
+		if len(matches) > 1 {
+			path += fmt.Sprintf(" of %d matches", len(matches))
+		}
+	}
+
+	if last := matches[len(matches)-1].rule; last.Exclusive {
+		path += fmt.Sprintf(", exclusive rule %q ended the evaluation", last.Name)
+	}
+	if len(names) > 1 {
+		result.Reasoning = fmt.Sprintf("matched rules %s (%s)", strings.Join(names, ", "), path)
+	} else {
+		result.Reasoning = fmt.Sprintf("matched rule %s (%s)", names[0], path)
+	}
+}
+
+func (c *Classifier) matchesRule(rule config.ClassificationRule, email Email) bool {
+	return MatchesConditions(rule.Conditions, email)
+}
+
+// MatchesConditions reports whether email meets every condition
+func MatchesConditions(conditions []config.Condition, email Email) bool {
+	for _, cond := range conditions {
+		if !matchesCondition(cond, email) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesCondition(cond config.Condition, email Email) bool {
+	for _, field := range conditionFields(cond.Field, email) {
+		for _, value := range cond.Values() {
+			if matchesValue(cond.Operator, field, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// conditionFields returns the values of email a condition on field is tested
+// against: one per attachment for attachment_name and attachment_type, none
+// when the email has no attachments
+func conditionFields(field string, email Email) []string {
+	switch field {
+	case "from":
+		return addressFields(email.From)
+	case "to":
+		return addressFields(email.To...)
+	case "subject":
+		return []string{email.Subject}
+	case "body":
+		return []string{email.Body}
+	case "has_attachment":
+		return []string{strconv.FormatBool(len(email.Attachments) > 0)}
+	case "attachment_name", "attachment_type":
+		var fields []string
+		for _, attachment := range email.Attachments {
+			if field == "attachment_name" {
+				fields = append(fields, attachment.Filename)
+			} else {
+				fields = append(fields, attachment.ContentType)
+			}
+		}
+		return fields
+	}
+	return nil
+}
+
+// addressFields returns the values a condition on from or to is tested
+// against: each address as written and, when it carries a display name, the
+// bare address too, so equals matches "Name <a@b>" given a@b
+func addressFields(values ...string) []string {
+	fields := slices.Clone(values)
+	for _, value := range values {
+		if addr, err := mail.ParseAddress(value); err == nil && addr.Address != strings.TrimSpace(value) {
+			fields = append(fields, addr.Address)
+		}
+	}
+	return fields
+}
+
+func matchesValue(operator, field, value string) bool {
+	if operator == "regex" {
+		re, err := config.CompileRegex(value)
+		return err == nil && matchesRegex(re, field)
+	}
+
+	field, value = strings.ToLower(field), strings.ToLower(value)
+	switch operator {
+	case "contains":
+		return strings.Contains(field, value)
+	case "equals":
+		return strings.TrimSpace(field) == value
+	case "starts_with":
+		return strings.HasPrefix(strings.TrimSpace(field), value)
+	case "domain":
+		addr := strings.TrimRight(strings.TrimSpace(field), ">")
+		return strings.HasSuffix(addr, "@"+value) || strings.HasSuffix(addr, "."+value)
+	}
+	return false
+}
+
+// maxRegexInput is how much of a field regex conditions see. Go regexes
+// match in time linear in the input, so with the complexity limits of
+// config.CompileRegex this bounds the time of a match and a long body cannot
+// stall classification.
+const maxRegexInput = 64 * 1024
+
+// matchesRegex reports whether re matches the first maxRegexInput bytes of
+// field
+func matchesRegex(re *regexp.Regexp, field string) bool {
+	if len(field) > maxRegexInput {
+		field = field[:maxRegexInput]
+	}
+	return re.MatchString(field)
+}
+
+// classifyWithAI asks the LLM for a category. rulesResult is the low-confidence
+// rule outcome: if the LLM agrees with a matched rule the result is "hybrid"
+// with the higher confidence of the two.
+func (c *Classifier) classifyWithAI(ctx context.Context, email Email, rulesResult *Classification) (*Classification, error) {
+	key := classificationCacheKey(email)
+	if cached, ok := c.cached(key); ok {
+		return cached, nil
+	}
+
+	if !c.limiter.Allow() {
+		c.mu.Lock()
+		c.stats.RateLimited++
+		c.mu.Unlock()
+		return nil, fmt.Errorf("rate limit of %d requests per minute reached", c.cfg.RateLimitPerMinute)
+	}
+
+	c.mu.Lock()
+	c.stats.AIRequests++
+	c.mu.Unlock()
+
+	reply, err := c.provider.Complete(ctx, Request{
+		System:      c.systemPrompt(),
+		Prompt:      classificationPrompt(email),
+		MaxTokens:   200,
+		Temperature: 0,
+	})
+	if err == nil {
+		var result *Classification
+		if result, err = c.parseReply(reply); err == nil {
+			if rulesResult.Confidence > 0 {
+				result.Method = MethodHybrid
+				if result.Category == rulesResult.Category {
+					result.Confidence = max(result.Confidence, rulesResult.Confidence)
+					result.Rule = rulesResult.Rule
+					result.Tags = rulesResult.Tags
+				}
+				result.Reasoning = fmt.Sprintf("%s (rules: %s, %.2f, %s)", result.Reasoning,
+					rulesResult.Category, rulesResult.Confidence, rulesResult.Reasoning)
+			}
+			c.store(key, *result)
+			return result, nil
+		}
+	}
+
+	c.mu.Lock()
+	c.stats.AIErrors++
+	c.mu.Unlock()
+	return nil, fmt.Errorf("AI classification failed: %w", err)
+}
+
+func (c *Classifier) systemPrompt() string {
+	return fmt.Sprintf(`You classify emails into exactly one of these categories: %s.
+Reply with JSON only: {"category": "...", "confidence": 0.0-1.0, "tags": ["..."], "reasoning": "one short sentence"}`,
+		strings.Join(c.cfg.Categories, ", "))
+}
+
+func classificationPrompt(email Email) string {
+	body := StripQuoted(email.Body)
+	if runes := []rune(body); len(runes) > 2000 {
+		body = string(runes[:2000]) + " [...]"
+	}
+	header := fmt.Sprintf("From: %s\nTo: %s\nSubject: %s\n", email.From, strings.Join(email.To, ", "), email.Subject)
+	if len(email.Attachments) > 0 {
+		names := make([]string, len(email.Attachments))
+		for i, attachment := range email.Attachments {
+			names[i] = fmt.Sprintf("%s (%s)", attachment.Filename, attachment.ContentType)
+		}
+		header += "Attachments: " + strings.Join(names, ", ") + "\n"
+	}
+	return header + "\n" + body
+}
+
+// extractJSON decodes the JSON object of a model reply into v
+func extractJSON(reply string, v interface{}) error {
+	// Models sometimes wrap JSON in prose or code fences
+	start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return fmt.Errorf("no JSON in reply: %q", reply)
+	}
+	if err := json.Unmarshal([]byte(reply[start:end+1]), v); err != nil {
+		return fmt.Errorf("invalid JSON in reply: %w", err)
+	}
+	return nil
+}
+
+func (c *Classifier) parseReply(reply string) (*Classification, error) {
+	var parsed struct {
+		Category   string   `json:"category"`
+		Confidence float64  `json:"confidence"`
+		Tags       []string `json:"tags"`
+		Reasoning  string   `json:"reasoning"`
+	}
+	if err := extractJSON(reply, &parsed); err != nil {
+		return nil, err
+	}
+
+	category := strings.ToLower(strings.TrimSpace(parsed.Category))
+	known := len(c.cfg.Categories) == 0
+	for _, allowed := range c.cfg.Categories {
+		if category == allowed {
+			known = true
+		}
+	}
+	if !known || category == "" {
+		return nil, fmt.Errorf("unknown category %q", parsed.Category)
+	}
+
+	return &Classification{
+		Category:     category,
+		Confidence:   min(max(parsed.Confidence, 0), 1),
+		Tags:         parsed.Tags,
+		Method:       MethodAI,
+		Reasoning:    parsed.Reasoning,
+		ClassifiedAt: time.Now(),
+	}, nil
+}
+
+func classificationCacheKey(email Email) string {
+	if email.MessageID != "" {
+		return email.AccountID + "\x00" + email.MessageID
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s", email.AccountID, email.From, email.Subject, email.Body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *Classifier) cached(key string) (*Classification, bool) {
+	result, ok := c.cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return &result, true
+}
+
+func (c *Classifier) store(key string, result Classification) {
+	c.cache.Put(key, result)
+}
+
+func (c *Classifier) record(result *Classification) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.Total++
+	c.stats.ByMethod[result.Method]++
+	c.stats.ByCategory[result.Category]++
+}
@@ -0,0 +1,172 @@
+package ai
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Deadline is a due date mentioned in an email
+type Deadline struct {
+	Phrase string    `json:"phrase"`
+	Due    time.Time `json:"due"` // end of the day it refers to
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+	"domingo": time.Sunday, "lunes": time.Monday, "martes": time.Tuesday, "miércoles": time.Wednesday,
+	"miercoles": time.Wednesday, "jueves": time.Thursday, "viernes": time.Friday, "sábado": time.Saturday,
+	"sabado": time.Saturday,
+}
+
+var months = map[string]time.Month{
+	"january": time.January, "february": time.February, "march": time.March, "april": time.April,
+	"may": time.May, "june": time.June, "july": time.July, "august": time.August,
+	"september": time.September, "october": time.October, "november": time.November, "december": time.December,
+	"jan": time.January, "feb": time.February, "mar": time.March, "apr": time.April, "jun": time.June,
+	"jul": time.July, "aug": time.August, "sep": time.September, "sept": time.September, "oct": time.October,
+	"nov": time.November, "dec": time.December,
+	"enero": time.January, "febrero": time.February, "marzo": time.March, "abril": time.April,
+	"mayo": time.May, "junio": time.June, "julio": time.July, "agosto": time.August,
+	"septiembre": time.September, "octubre": time.October, "noviembre": time.November, "diciembre": time.December,
+}
+
+// deadlinePattern matches a trigger such as "by", "due" or "EOD" followed by
+// a date expression: a relative day, a weekday, an ISO date, a day and month
+// name, or an end of day on one of those ("EOD tomorrow")
+var deadlinePattern = func() *regexp.Regexp {
+	alternation := func(m map[string]bool) string {
+		var words []string
+		for w := range m {
+			words = append(words, regexp.QuoteMeta(w))
+		}
+		// Longest first, so "september" wins over "sep"
+		sort.Slice(words, func(i, j int) bool { return len(words[i]) > len(words[j]) })
+		return strings.Join(words, "|")
+	}
+	dayNames, monthNames := make(map[string]bool), make(map[string]bool)
+	for w := range weekdays {
+		dayNames[w] = true
+	}
+	for m := range months {
+		monthNames[m] = true
+	}
+	day, month := alternation(dayNames), alternation(monthNames)
+
+	trigger := `by|due(?:\s+(?:on|by))?|before|until|deadline(?:\s+is)?:?|no later than|antes del?|para el|hasta el|fecha l[ií]mite:?|` + endOfDay
+	relative := `today|tomorrow|hoy|mañana|(?:next\s+|el\s+)?(?:` + day + `)`
+	date := `(?:` + endOfDay + `)\s+(?:` + relative + `)|` +
+		`tonight|eod|cob|end of (?:the )?(?:day|week|month)|` + relative + `|` +
+		`\d{4}-\d{2}-\d{2}|` +
+		`(?:` + month + `)\.?\s+\d{1,2}(?:st|nd|rd|th)?|` +
+		`\d{1,2}(?:st|nd|rd|th)?\s+(?:of\s+|de\s+)?(?:` + month + `)`
+	return regexp.MustCompile(`(?i)\b(?:` + trigger + `)\s+(` + date + `)\b`)
+}()
+
+// endOfDay matches the ways of saying "by the end of the working day"
+const endOfDay = `eod|cob|end of (?:the )?day`
+
+var dayNumber = regexp.MustCompile(`\d{1,2}`)
+
+// DetectDeadlines finds due dates such as "by Friday", "due March 14" or
+// "deadline: 2025-03-14" in text, resolved relative to now. Dates already in
+// the past are skipped, and each day is reported once.
+func DetectDeadlines(text string, now time.Time) []Deadline {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	var deadlines []Deadline
+	seen := make(map[string]bool)
+	for _, match := range deadlinePattern.FindAllStringSubmatch(text, -1) {
+		day, ok := resolveDeadline(strings.ToLower(match[1]), today)
+		if !ok || day.Before(today) || seen[day.Format("2006-01-02")] {
+			continue
+		}
+		seen[day.Format("2006-01-02")] = true
+		deadlines = append(deadlines, Deadline{
+			Phrase: strings.Join(strings.Fields(match[0]), " "),
+			Due:    day.Add(24*time.Hour - time.Second),
+		})
+	}
+	sort.Slice(deadlines, func(i, j int) bool { return deadlines[i].Due.Before(deadlines[j].Due) })
+	return deadlines
+}
+
+func resolveDeadline(expr string, today time.Time) (time.Time, bool) {
+	// "EOD Friday" is due on Friday, like "by Friday"
+	for _, prefix := range []string{"eod ", "cob ", "end of the day ", "end of day "} {
+		expr = strings.TrimPrefix(expr, prefix)
+	}
+
+	switch {
+	case expr == "today" || expr == "tonight" || expr == "eod" || expr == "cob" || expr == "hoy" || strings.HasSuffix(expr, " day"):
+		return today, true
+	case expr == "tomorrow" || expr == "mañana":
+		return today.AddDate(0, 0, 1), true
+	case strings.HasSuffix(expr, " week"):
+		return nextWeekday(today, time.Friday, false), true
+	case strings.HasSuffix(expr, " month"):
+		return time.Date(today.Year(), today.Month()+1, 0, 0, 0, 0, 0, today.Location()), true
+	}
+
+	if t, err := time.ParseInLocation("2006-01-02", expr, today.Location()); err == nil {
+		return t, true
+	}
+
+	words := strings.Fields(expr)
+	if len(words) > 0 {
+		next := words[0] == "next"
+		if wd, ok := weekdays[words[len(words)-1]]; ok {
+			return nextWeekday(today, wd, next), true
+		}
+	}
+
+	for _, word := range words {
+		month, ok := months[strings.TrimSuffix(word, ".")]
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(dayNumber.FindString(expr))
+		if err != nil || n < 1 || n > 31 {
+			return time.Time{}, false
+		}
+		t := time.Date(today.Year(), month, n, 0, 0, 0, 0, today.Location())
+		if t.Day() != n {
+			return time.Time{}, false // e.g. February 30
+		}
+		if t.Before(today) {
+			t = t.AddDate(1, 0, 0)
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// nextWeekday returns the next day falling on wd, today included unless
+// afterToday is set ("next Friday" said on a Friday)
+func nextWeekday(today time.Time, wd time.Weekday, afterToday bool) time.Time {
+	days := (int(wd) - int(today.Weekday()) + 7) % 7
+	if afterToday && days == 0 {
+		days = 7
+	}
+	return today.AddDate(0, 0, days)
+}
+
+// DeadlinePoints is how much a deadline due at due raises the priority of its
+// email at now: more as it approaches, and nothing once it has passed or
+// while it is more than a week away
+func DeadlinePoints(due, now time.Time) int {
+	switch until := due.Sub(now); {
+	case until < 0:
+		return 0
+	case until < 24*time.Hour:
+		return 25
+	case until < 72*time.Hour:
+		return 15
+	case until < 7*24*time.Hour:
+		return 5
+	}
+	return 0
+}
@@ -0,0 +1,40 @@
+package ai
+
+import (
+	"strings"
+)
+
+// Categories of automated mail, which never need a reply
+var automatedCategories = map[string]bool{"newsletter": true, "promotions": true, "notification": true, "social": true, CategoryAutomated: true}
+
+var requestPhrases = []string{
+	"please", "could you", "can you", "would you", "let me know", "what do you think", "your thoughts",
+	"get back to me", "can we", "are you available", "por favor", "puedes", "podrías", "podrias",
+	"me confirmas", "quedo a la espera", "qué te parece", "que te parece",
+}
+
+// NeedsReply reports whether email looks like it expects an answer: a
+// question or request from a person rather than an automated sender. category
+// is the email's classification, if known.
+func NeedsReply(email Email, category string) bool {
+	if automatedCategories[category] || email.Automated != "" {
+		return false
+	}
+	from := strings.ToLower(email.From)
+	for _, marker := range []string{"noreply", "no-reply", "donotreply", "do-not-reply", "mailer-daemon", "notifications@", "notification@"} {
+		if strings.Contains(from, marker) {
+			return false
+		}
+	}
+
+	text := strings.ToLower(email.Subject + "\n" + StripQuoted(email.Body))
+	if strings.Contains(text, "?") {
+		return true
+	}
+	for _, phrase := range requestPhrases {
+		if strings.Contains(text, phrase) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,28 @@
+package ai
+
+import (
+	"time"
+
+	"email-mcp-server/mail"
+)
+
+// Email is the provider-neutral view of a message used by the intelligence
+// features
+type Email struct {
+	AccountID string    `json:"account_id"`
+	Folder    string    `json:"folder"`
+	UID       uint32    `json:"uid"`
+	MessageID string    `json:"message_id,omitempty"`
+	ThreadID  string    `json:"thread_id,omitempty"`
+	From      string    `json:"from"`
+	To        []string  `json:"to"`
+	Subject   string    `json:"subject"`
+	Body      string    `json:"body"`
+	Date      time.Time `json:"date"`
+	// Kind of message a program sent, such as "bounce", from its headers;
+	// "" for messages a person sent
+	Automated string `json:"automated,omitempty"`
+	// Attachments without their content; only the names, types and sizes
+	// are used
+	Attachments []mail.Attachment `json:"attachments,omitempty"`
+}
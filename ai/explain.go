@@ -0,0 +1,175 @@
+package ai
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+
+	"email-mcp-server/config"
+	"email-mcp-server/mail"
+)
+
+// ConditionResult explains one condition of a rule
+type ConditionResult struct {
+	Field    string   `json:"field"`
+	Operator string   `json:"operator"`
+	Values   []string `json:"values"`
+	Matched  bool     `json:"matched"`
+	Reason   string   `json:"reason"`
+	Close    bool     `json:"close,omitempty"` // failed, but a looser operator would match
+}
+
+// RuleResult explains how one rule fared against an email
+type RuleResult struct {
+	Rule       string            `json:"rule"`
+	Category   string            `json:"category"`
+	Confidence float64           `json:"confidence"` // including learned adjustments
+	Matched    bool              `json:"matched"`
+	Failed     int               `json:"failed_conditions"`
+	Conditions []ConditionResult `json:"conditions"`
+}
+
+// RulesReport is the outcome of TestRules
+type RulesReport struct {
+	Result     *Classification `json:"result"`
+	Strategy   string          `json:"strategy"`    // match_strategy of the rules
+	Matched    []RuleResult    `json:"matched"`     // highest confidence first
+	NearMisses []RuleResult    `json:"near_misses"` // fewest failed conditions first
+	UsesAI     bool            `json:"uses_ai"`     // Classify would ask the LLM
+	VIPSender  bool            `json:"vip_sender"`
+	Ignored    bool            `json:"ignored"` // on ignored_senders or ignored_subjects
+}
+
+// TestRules evaluates rules against email without recording anything. A rule
+// is a near miss when it failed but at least half of its conditions matched,
+// or a failed condition would match with a looser operator. rules may be nil
+// to test the classifier's own rules. The rules of email.AccountID are used.
+func (c *Classifier) TestRules(email Email, rules *config.Rules) *RulesReport {
+	test := c
+	if rules != nil {
+		// Copy learned state so the result matches what Classify would do
+		c.mu.Lock()
+		test = &Classifier{
+			rules:       newRuleSet(rules),
+			accounts:    accountRules(rules),
+			cfg:         c.cfg,
+			learned:     maps.Clone(c.learned),
+			adjustments: maps.Clone(c.adjustments),
+		}
+		c.mu.Unlock()
+	}
+
+	set := test.rulesFor(email.AccountID)
+	report := &RulesReport{Result: test.ClassifyByRules(email), Strategy: set.strategy}
+	report.UsesAI = c.provider != nil && c.cfg.UseAI && report.Result.Confidence < c.cfg.ConfidenceThreshold
+
+	for _, rule := range set.rules {
+		result := RuleResult{
+			Rule:       rule.Name,
+			Category:   rule.Category,
+			Confidence: test.ruleConfidence(rule),
+			Matched:    true,
+		}
+		nearMiss := false
+		for _, cond := range rule.Conditions {
+			cr := explainCondition(cond, email)
+			if !cr.Matched {
+				result.Matched = false
+				result.Failed++
+				nearMiss = nearMiss || cr.Close
+			}
+			result.Conditions = append(result.Conditions, cr)
+		}
+
+		switch {
+		case result.Matched:
+			report.Matched = append(report.Matched, result)
+		case nearMiss || result.Failed*2 <= len(rule.Conditions):
+			report.NearMisses = append(report.NearMisses, result)
+		}
+	}
+
+	sort.SliceStable(report.Matched, func(i, j int) bool {
+		return report.Matched[i].Confidence > report.Matched[j].Confidence
+	})
+	sort.SliceStable(report.NearMisses, func(i, j int) bool {
+		return report.NearMisses[i].Failed < report.NearMisses[j].Failed
+	})
+
+	if rules == nil {
+		return report
+	}
+	account := rules.ForAccount(email.AccountID)
+	sender, err := mail.ParseAddress(email.From)
+	if err == nil {
+		report.VIPSender = account.IsVIP(sender)
+	}
+	report.Ignored = account.IsIgnored(sender, email.Subject)
+	return report
+}
+
+func explainCondition(cond config.Condition, email Email) ConditionResult {
+	result := ConditionResult{Field: cond.Field, Operator: cond.Operator, Values: cond.Values()}
+
+	fields := conditionFields(cond.Field, email)
+	if strings.HasPrefix(cond.Field, "attachment_") && len(email.Attachments) == 0 {
+		result.Reason = "the email has no attachments"
+		return result
+	}
+	if strings.TrimSpace(strings.Join(fields, "")) == "" {
+		result.Reason = cond.Field + " is empty"
+		return result
+	}
+
+	for _, value := range result.Values {
+		if cond.Operator == "regex" {
+			if _, err := config.CompileRegex(value); err != nil {
+				result.Reason = err.Error()
+				return result
+			}
+		}
+		for _, field := range fields {
+			if matchesValue(cond.Operator, field, value) {
+				result.Matched = true
+				result.Reason = fmt.Sprintf("%s %s %q", cond.Field, cond.Operator, value)
+				return result
+			}
+		}
+	}
+
+	// Look for values that a looser comparison would have accepted
+	for _, value := range result.Values {
+		for _, field := range fields {
+			switch {
+			case cond.Operator == "regex" && matchesValue("regex", field, "(?i)"+value):
+				result.Reason = fmt.Sprintf("regex %q only matches %s when ignoring case", value, cond.Field)
+			case cond.Operator != "regex" && cond.Operator != "contains" && matchesValue("contains", field, value):
+				result.Reason = fmt.Sprintf("%s contains %q, but not as %s", cond.Field, value, cond.Operator)
+			default:
+				continue
+			}
+			result.Close = true
+			return result
+		}
+	}
+
+	result.Reason = fmt.Sprintf("%s does not %s any of the values", cond.Field, describeOperator(cond.Operator))
+	return result
+}
+
+func describeOperator(operator string) string {
+	switch operator {
+	case "contains":
+		return "contain"
+	case "equals":
+		return "equal"
+	case "starts_with":
+		return "start with"
+	case "domain":
+		return "have the domain of"
+	case "regex":
+		return "match"
+	}
+	return operator
+}
@@ -0,0 +1,150 @@
+package ai
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"email-mcp-server/mail"
+)
+
+// Helpers shared by the extractors of invoices, orders and trips
+
+// currencySymbols map the symbols and codes written next to amounts to
+// their ISO 4217 codes
+var currencySymbols = map[string]string{
+	"€": "EUR", "eur": "EUR", "euros": "EUR", "euro": "EUR",
+	"$": "USD", "usd": "USD", "us$": "USD",
+	"£": "GBP", "gbp": "GBP",
+	"chf": "CHF", "mxn": "MXN", "cad": "CAD", "aud": "AUD",
+}
+
+// amountPattern matches an amount with its currency before or after it,
+// such as "€1,234.50", "USD 99" or "1.234,50 €"
+var amountPattern = func() *regexp.Regexp {
+	currency := `€|£|us\$|\$|\b(?:eur|usd|gbp|chf|mxn|cad|aud)\b`
+	number := `\d{1,3}(?:[.,\x{00A0}]\d{3})+(?:[.,]\d{1,2})?|\d+(?:[.,]\d{1,2})?`
+	return regexp.MustCompile(`(?i)(` + currency + `)\s?(` + number + `)|(` + number + `)\s?(` + currency + `|\beuros?\b)`)
+}()
+
+// totalWords mark the line that states the amount to pay
+var totalWords = []string{"total", "amount due", "balance due", "amount payable", "to pay", "importe", "a pagar", "a abonar"}
+
+// dateExpr matches a date with its year: 2025-03-31, 31/03/2025, 31.03.25,
+// "March 31, 2025", "31st March 2025" or "31 de marzo de 2025"
+const dateExpr = `\d{4}-\d{2}-\d{2}|\d{1,2}[/.-]\d{1,2}[/.-]\d{2,4}|[\p{L}]+\.?\s+\d{1,2}(?:st|nd|rd|th)?,?\s+\d{4}|\d{1,2}(?:st|nd|rd|th)?\s+(?:de\s+|of\s+)?[\p{L}]+\.?,?\s+(?:de\s+)?\d{4}`
+
+var numberPattern = regexp.MustCompile(`\d+`)
+
+// totalAmount finds the amount on a line mentioning the total, or the
+// largest amount when none does, with its currency
+func totalAmount(text string) (float64, string) {
+	var best, bestTotal float64
+	var currency, totalCurrency string
+	for _, line := range strings.Split(text, "\n") {
+		isTotal := hasAnyWord(strings.ToLower(line), totalWords)
+		for _, match := range amountPattern.FindAllStringSubmatch(line, -1) {
+			symbol, number := match[1], match[2]
+			if symbol == "" {
+				symbol, number = match[4], match[3]
+			}
+			amount, ok := parseAmount(number)
+			if !ok {
+				continue
+			}
+			code := currencySymbols[strings.ToLower(symbol)]
+			if amount > best {
+				best, currency = amount, code
+			}
+			if isTotal && amount > bestTotal {
+				bestTotal, totalCurrency = amount, code
+			}
+		}
+	}
+	if bestTotal > 0 {
+		return bestTotal, totalCurrency
+	}
+	return best, currency
+}
+
+func hasAnyWord(s string, words []string) bool {
+	for _, word := range words {
+		if strings.Contains(s, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAmount reads "1,234.50", "1.234,50" or "99,9": a separator
+// followed by one or two final digits is the decimal point, any other is a
+// thousands separator
+func parseAmount(s string) (float64, bool) {
+	s = strings.ReplaceAll(s, "\u00a0", "")
+	decimals := ""
+	if i := strings.LastIndexAny(s, ".,"); i >= 0 && len(s)-i-1 <= 2 {
+		s, decimals = s[:i], s[i+1:]
+	}
+	s = strings.NewReplacer(".", "", ",", "").Replace(s)
+	if decimals != "" {
+		s += "." + decimals
+	}
+	amount, err := strconv.ParseFloat(s, 64)
+	return amount, err == nil && amount > 0
+}
+
+// parseDate reads a date matched by dateExpr: 2025-03-31, 31/03/2025 (day
+// first), "March 31, 2025" or "31 de marzo de 2025"
+func parseDate(s string, loc *time.Location) (time.Time, bool) {
+	s = strings.ToLower(s)
+	if t, err := time.ParseInLocation("2006-01-02", s, loc); err == nil {
+		return t, true
+	}
+
+	var day, year int
+	var month time.Month
+	numbers := numberPattern.FindAllString(s, -1)
+	if parts := strings.FieldsFunc(s, func(r rune) bool { return r == '/' || r == '.' || r == '-' }); len(parts) == 3 && len(numbers) == 3 {
+		day, _ = strconv.Atoi(parts[0])
+		m, _ := strconv.Atoi(parts[1])
+		year, _ = strconv.Atoi(parts[2])
+		month = time.Month(m)
+	} else {
+		for _, word := range strings.Fields(s) {
+			if m, ok := months[strings.Trim(word, ".,")]; ok {
+				month = m
+			}
+		}
+		if month == 0 || len(numbers) != 2 {
+			return time.Time{}, false
+		}
+		day, _ = strconv.Atoi(numbers[0])
+		year, _ = strconv.Atoi(numbers[1])
+	}
+	if year < 100 {
+		year += 2000
+	}
+
+	t := time.Date(year, month, day, 0, 0, 0, 0, loc)
+	if month < 1 || month > 12 || t.Day() != day {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// merchantName names who sent a transactional email: the sender's display
+// name, or the domain of its address
+func merchantName(from string) string {
+	addr, err := mail.ParseAddress(from)
+	if err != nil {
+		return strings.TrimSpace(from)
+	}
+	if addr.Name != "" {
+		return addr.Name
+	}
+	if _, domain, ok := strings.Cut(addr.Address, "@"); ok {
+		return domain
+	}
+	return addr.Address
+}
@@ -0,0 +1,127 @@
+package ai
+
+import (
+	"context"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+
+	"email-mcp-server/config"
+)
+
+// Invoice is the payment an invoice email asks for. Fields that could not
+// be found are left empty.
+type Invoice struct {
+	Vendor   string     `json:"vendor,omitempty"`
+	Number   string     `json:"number,omitempty"`
+	Amount   float64    `json:"amount,omitempty"`
+	Currency string     `json:"currency,omitempty"` // ISO 4217 code, e.g. EUR
+	Due      *time.Time `json:"due,omitempty"`      // end of the day payment is due
+	Method   string     `json:"method"`             // "llm" or "rules"
+}
+
+// InvoiceExtractor reads invoices with ExtractInvoice, or with the
+// configured LLM when invoices.use_ai is set, falling back to ExtractInvoice
+// when a request fails
+type InvoiceExtractor struct {
+	ai       *config.AIConfig
+	provider Provider
+}
+
+// NewInvoiceExtractor creates an invoice extractor. provider may be nil.
+func NewInvoiceExtractor(cfg *config.AIConfig, provider Provider) *InvoiceExtractor {
+	return &InvoiceExtractor{ai: cfg, provider: provider}
+}
+
+// Extract reads the invoice an email carries
+func (x *InvoiceExtractor) Extract(ctx context.Context, email Email) *Invoice {
+	if x.provider == nil || !x.ai.Invoices.UseAI {
+		return ExtractInvoice(email)
+	}
+
+	reply, err := x.provider.Complete(ctx, Request{
+		System:      invoiceSystemPrompt,
+		Prompt:      classificationPrompt(email),
+		MaxTokens:   x.ai.MaxTokens,
+		Temperature: 0,
+	})
+	if err != nil {
+		return ExtractInvoice(email)
+	}
+	invoice, err := parseInvoice(reply)
+	if err != nil {
+		return ExtractInvoice(email)
+	}
+	return invoice
+}
+
+const invoiceSystemPrompt = `You read invoices and bills sent by email.
+Reply with JSON only: {"vendor": "who is to be paid", "number": "invoice number or empty", "amount": 0.00, "currency": "ISO 4217 code or empty", "due": "YYYY-MM-DD or empty"}
+"amount" is the total to pay, 0 when the email does not say.`
+
+func parseInvoice(reply string) (*Invoice, error) {
+	var parsed struct {
+		Vendor   string  `json:"vendor"`
+		Number   string  `json:"number"`
+		Amount   float64 `json:"amount"`
+		Currency string  `json:"currency"`
+		Due      string  `json:"due"`
+	}
+	if err := extractJSON(reply, &parsed); err != nil {
+		return nil, err
+	}
+
+	invoice := &Invoice{
+		Vendor:   strings.TrimSpace(parsed.Vendor),
+		Number:   strings.TrimSpace(parsed.Number),
+		Amount:   math.Max(0, parsed.Amount),
+		Currency: strings.ToUpper(strings.TrimSpace(parsed.Currency)),
+		Method:   "llm",
+	}
+	if day, err := time.ParseInLocation("2006-01-02", parsed.Due, time.Local); err == nil {
+		due := day.Add(24*time.Hour - time.Second)
+		invoice.Due = &due
+	}
+	return invoice, nil
+}
+
+// invoiceDuePattern matches the due date of an invoice: a trigger such as
+// "due date" or "vencimiento" followed by a numeric or written date
+var invoiceDuePattern = regexp.MustCompile(`(?i)\b(?:due(?:\s+date)?|payment\s+due|pay(?:able)?\s+by|vencimiento|fecha\s+de\s+(?:pago|cargo)|pagar\s+antes\s+del?)\s*(?:on|by|el|:)?\s*:?\s*(` + dateExpr + `)`)
+
+// invoiceNumberPattern matches "Invoice #F-1024", "Invoice no. 2025/17" or
+// "Factura nº A-33"; the number must carry a digit
+var invoiceNumberPattern = regexp.MustCompile(`(?i)\b(?:invoice|factura|bill)\s*(?:number|num\.?|no\.?|n[º°o]\.?|#)\s*:?\s*#?([A-Z0-9][A-Z0-9/-]*\d[A-Z0-9/-]*)`)
+
+// ExtractInvoice reads an invoice with patterns: the amount on a line
+// mentioning the total (the largest amount when none does), its currency, a
+// due date, the invoice number and the sender's name or domain as the vendor
+func ExtractInvoice(email Email) *Invoice {
+	invoice := &Invoice{Vendor: merchantName(email.From), Method: "rules"}
+	text := email.Subject + "\n" + StripQuoted(email.Body)
+
+	invoice.Amount, invoice.Currency = totalAmount(text)
+
+	sent := email.Date
+	if sent.IsZero() {
+		sent = time.Now()
+	}
+	if match := invoiceDuePattern.FindStringSubmatch(text); match != nil {
+		if day, ok := parseDate(match[1], sent.Location()); ok {
+			due := day.Add(24*time.Hour - time.Second)
+			invoice.Due = &due
+		}
+	}
+	if invoice.Due == nil {
+		// "due Friday" and other relative dates
+		if deadlines := DetectDeadlines(text, sent); len(deadlines) > 0 {
+			invoice.Due = &deadlines[0].Due
+		}
+	}
+
+	if match := invoiceNumberPattern.FindStringSubmatch(text); match != nil {
+		invoice.Number = match[1]
+	}
+	return invoice
+}
@@ -0,0 +1,121 @@
+package ai
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"email-mcp-server/config"
+	"email-mcp-server/mail"
+)
+
+// Feedback is a user's correction of a classification, with the totals the
+// caller has recorded so far (this correction included)
+type Feedback struct {
+	Email     Email
+	Predicted string // category the classifier assigned
+	Rule      string // rule behind Predicted, if any
+	Correct   string // category the user chose
+
+	SenderSamples int // corrections moving this sender to Correct
+	RuleMistakes  int // corrections of a wrong category from Rule
+}
+
+// LearnedSender maps a sender address to a category
+type LearnedSender struct {
+	Sender     string    `json:"sender"`
+	Category   string    `json:"category"`
+	Confidence float64   `json:"confidence"`
+	Samples    int       `json:"samples"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// RuleAdjustment is the learned confidence change of a rule
+type RuleAdjustment struct {
+	Rule       string  `json:"rule"`
+	Delta      float64 `json:"delta"`
+	Confidence float64 `json:"confidence"` // effective confidence after the change
+}
+
+// LearningUpdate describes what a correction changed. The caller persists
+// LearnedSender and RuleAdjustment so they survive a restart.
+type LearningUpdate struct {
+	LearnedSender  *LearnedSender  `json:"learned_sender,omitempty"`
+	RuleAdjustment *RuleAdjustment `json:"rule_adjustment,omitempty"`
+	Note           string          `json:"note,omitempty"`
+}
+
+// LearnFromFeedback applies a correction once enough of them agree: after
+// min_samples corrections the sender is mapped to the chosen category, and
+// after min_samples mistakes a rule loses confidence_step per further mistake
+func (c *Classifier) LearnFromFeedback(f Feedback) *LearningUpdate {
+	update := &LearningUpdate{}
+	if !c.learning.Enabled {
+		update.Note = "learning is disabled"
+		return update
+	}
+	minSamples := max(c.learning.MinSamples, 1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if sender := senderAddress(f.Email.From); sender != "" && f.SenderSamples >= minSamples {
+		learned := LearnedSender{
+			Sender:     sender,
+			Category:   f.Correct,
+			Confidence: c.learning.LearnedConfidence,
+			Samples:    f.SenderSamples,
+			UpdatedAt:  time.Now(),
+		}
+		c.learned[sender] = learned
+		update.LearnedSender = &learned
+	}
+
+	if rule, ok := c.rule(f.Email.AccountID, f.Rule); ok && f.Predicted != f.Correct && f.RuleMistakes >= minSamples {
+		delta := max(c.adjustments[rule.Name]-c.learning.ConfidenceStep, -rule.Confidence)
+		c.adjustments[rule.Name] = delta
+		update.RuleAdjustment = &RuleAdjustment{Rule: rule.Name, Delta: delta, Confidence: rule.Confidence + delta}
+	}
+
+	if update.LearnedSender == nil && update.RuleAdjustment == nil {
+		update.Note = fmt.Sprintf("recorded %d of %d corrections needed to learn this sender", f.SenderSamples, minSamples)
+	}
+	return update
+}
+
+// LoadLearned restores sender mappings and rule adjustments saved earlier
+func (c *Classifier) LoadLearned(senders []LearnedSender, adjustments map[string]float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, s := range senders {
+		c.learned[strings.ToLower(s.Sender)] = s
+	}
+	for rule, delta := range adjustments {
+		c.adjustments[rule] = delta
+	}
+}
+
+// rule finds a rule of an account by name
+func (c *Classifier) rule(accountID, name string) (config.ClassificationRule, bool) {
+	for _, rule := range c.rulesFor(accountID).rules {
+		if name != "" && rule.Name == name {
+			return rule, true
+		}
+	}
+	return config.ClassificationRule{}, false
+}
+
+// ruleConfidence is the configured confidence of rule plus what was learned
+func (c *Classifier) ruleConfidence(rule config.ClassificationRule) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return min(max(rule.Confidence+c.adjustments[rule.Name], 0), 1)
+}
+
+func senderAddress(from string) string {
+	if addr, err := mail.ParseAddress(from); err == nil {
+		return strings.ToLower(addr.Address)
+	}
+	return strings.ToLower(strings.TrimSpace(from))
+}
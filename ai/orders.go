@@ -0,0 +1,119 @@
+package ai
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Order statuses, from the wording of order, shipping and delivery emails
+const (
+	OrderPlaced    = "placed"
+	OrderShipped   = "shipped"
+	OrderDelivered = "delivered"
+	OrderCancelled = "cancelled"
+)
+
+// Order is a purchase an order confirmation, shipping or delivery email
+// reports on. Fields that could not be found are left empty.
+type Order struct {
+	Merchant       string  `json:"merchant,omitempty"`
+	Number         string  `json:"number,omitempty"`
+	Status         string  `json:"status"`
+	Carrier        string  `json:"carrier,omitempty"`
+	TrackingNumber string  `json:"tracking_number,omitempty"`
+	TrackingURL    string  `json:"tracking_url,omitempty"`
+	Total          float64 `json:"total,omitempty"`
+	Currency       string  `json:"currency,omitempty"`
+}
+
+// orderStatuses are checked in turn, so the furthest stage an email
+// mentions wins; emails mentioning none are OrderPlaced
+var orderStatuses = []struct {
+	status string
+	words  []string
+}{
+	{OrderCancelled, []string{"cancelled", "canceled", "cancelado", "cancelada", "anulado"}},
+	{OrderDelivered, []string{"delivered", "has arrived", "entregado", "entregada"}},
+	{OrderShipped, []string{"shipped", "on its way", "on the way", "out for delivery", "dispatched", "in transit",
+		"enviado", "enviada", "en camino", "en reparto"}},
+}
+
+// carrierHosts name the carrier of a tracking link by its host
+var carrierHosts = map[string]string{
+	"ups.com": "UPS", "fedex.com": "FedEx", "dhl.com": "DHL", "dhl.de": "DHL", "dhl.es": "DHL", "usps.com": "USPS",
+	"royalmail.com": "Royal Mail", "correos.es": "Correos", "seur.com": "SEUR", "mrw.es": "MRW", "gls-spain.es": "GLS",
+	"gls-group.eu": "GLS", "dpd.com": "DPD", "nacex.es": "Nacex", "ctt.pt": "CTT", "amazon.com": "Amazon",
+	"amazon.es": "Amazon", "amazon.co.uk": "Amazon", "amazon.de": "Amazon",
+}
+
+var (
+	// orderNumberPattern matches "Order #112-3456789", "Order number: A1234"
+	// or "Pedido nº 5567"; the number must carry a digit
+	orderNumberPattern = regexp.MustCompile(`(?i)\b(?:order|pedido|orden)\s*(?:number|num\.?|no\.?|n[º°o]\.?|#|id)?\s*:?\s*#?([A-Z0-9][A-Z0-9-]*\d[A-Z0-9-]*)`)
+	// trackingNumberPattern matches the number after "tracking number" or
+	// "número de seguimiento"
+	trackingNumberPattern = regexp.MustCompile(`(?i)\b(?:tracking\s*(?:number|no\.?|#|id|code)|n[úu]mero\s+de\s+(?:seguimiento|env[íi]o)|c[óo]digo\s+de\s+seguimiento)\s*:?\s*([A-Z0-9]*\d[A-Z0-9]*)`)
+	// upsNumber is the format of UPS tracking numbers
+	upsNumber   = regexp.MustCompile(`\b1Z[0-9A-Z]{16}\b`)
+	linkPattern = regexp.MustCompile(`https?://[^\s<>"')\]]+`)
+)
+
+// ExtractOrder reads an order, shipping or delivery email with patterns: the
+// order number, the furthest status it mentions, a tracking link and number
+// with the carrier they belong to, the total and the sender's name or domain
+// as the merchant
+func ExtractOrder(email Email) *Order {
+	order := &Order{Merchant: merchantName(email.From), Status: OrderPlaced}
+	text := email.Subject + "\n" + StripQuoted(email.Body)
+	lower := strings.ToLower(text)
+
+	for _, s := range orderStatuses {
+		if hasAnyWord(lower, s.words) {
+			order.Status = s.status
+			break
+		}
+	}
+	if match := orderNumberPattern.FindStringSubmatch(text); match != nil && len(match[1]) >= 4 {
+		order.Number = match[1]
+	}
+	if match := trackingNumberPattern.FindStringSubmatch(text); match != nil && len(match[1]) >= 8 {
+		order.TrackingNumber = match[1]
+	} else if number := upsNumber.FindString(text); number != "" {
+		order.TrackingNumber = number
+	}
+	if strings.HasPrefix(order.TrackingNumber, "1Z") && upsNumber.MatchString(order.TrackingNumber) {
+		order.Carrier = "UPS"
+	}
+
+	for _, link := range linkPattern.FindAllString(text, -1) {
+		u, err := url.Parse(strings.TrimRight(link, ".,;"))
+		if err != nil {
+			continue
+		}
+		carrier := carrierFor(u.Hostname())
+		tracking := strings.Contains(strings.ToLower(u.Path+"?"+u.RawQuery), "track")
+		if !tracking && (carrier == "" || carrier == "Amazon") {
+			continue
+		}
+		order.TrackingURL = u.String()
+		if carrier != "" {
+			order.Carrier = carrier
+		}
+		break
+	}
+
+	order.Total, order.Currency = totalAmount(text)
+	return order
+}
+
+// carrierFor names the carrier whose site host belongs to, "" for others
+func carrierFor(host string) string {
+	host = strings.ToLower(host)
+	for domain, carrier := range carrierHosts {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return carrier
+		}
+	}
+	return ""
+}
@@ -0,0 +1,358 @@
+package ai
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"email-mcp-server/config"
+	"email-mcp-server/mail"
+)
+
+// Priority levels, from most to least pressing
+const (
+	PriorityCritical = "critical"
+	PriorityHigh     = "high"
+	PriorityMedium   = "medium"
+	PriorityLow      = "low"
+	PriorityMinimal  = "minimal"
+)
+
+// PrioritySignals are facts about an email that its content does not carry
+type PrioritySignals struct {
+	VIP     bool // the sender is a VIP
+	Flagged bool // the email has the \Flagged flag
+	Ignored bool // the sender or subject is on the ignore lists
+	// Urgency keywords of the account's rules; config.DefaultKeywords when nil
+	Keywords []config.Keyword
+	// Emails received from the sender before, from the address book
+	SenderHistory int
+}
+
+// Priority scores how much an email needs attention, from 0 to 100
+type Priority struct {
+	Score   int            `json:"score"`
+	Level   string         `json:"level"`
+	Factors map[string]int `json:"factors"` // points added or removed by each factor
+}
+
+// Keyword points are capped so that wording alone cannot make an email
+// critical or bury it
+const (
+	maxKeywordPoints = 40
+	minKeywordPoints = -30
+)
+
+// documentWords name the attached documents that usually need acting on
+var documentWords = []string{"invoice", "factura", "contract", "contrato", "agreement", "acuerdo", "quote", "presupuesto",
+	"purchase order", "pedido"}
+
+// documentExtensions are the file types of documents, as opposed to images
+// and archives
+var documentExtensions = []string{".pdf", ".doc", ".docx", ".odt", ".rtf", ".xls", ".xlsx", ".ods"}
+
+// ScorePriority starts every email at 30 points and adds or removes points
+// for its sender and how often they write, flags, classification, urgency
+// keywords, deadlines, attached documents and whether it asks for a reply. Spam always scores 0. classification may
+// be nil.
+func ScorePriority(email Email, classification *Classification, signals PrioritySignals, now time.Time) Priority {
+	p := Priority{Score: 30, Factors: map[string]int{"base": 30}}
+	add := func(factor string, points int) {
+		p.Factors[factor] = points
+		p.Score += points
+	}
+
+	category := ""
+	var tags []string
+	if classification != nil {
+		category, tags = classification.Category, classification.Tags
+	}
+	hasTag := func(tag string) bool {
+		if category == tag {
+			return true
+		}
+		for _, t := range tags {
+			if t == tag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if category == "spam" {
+		return Priority{Score: 0, Level: PriorityMinimal, Factors: map[string]int{"base": 30, "spam": -30}}
+	}
+	if automatedCategories[category] {
+		add("automated", -20)
+	}
+
+	if signals.VIP {
+		add("vip_sender", 30)
+	}
+	if signals.Flagged {
+		add("flagged", 15)
+	}
+	if signals.Ignored {
+		add("ignored", -25)
+	}
+	if signals.SenderHistory >= 5 {
+		add("frequent_contact", 10)
+	}
+
+	keywords := signals.Keywords
+	if keywords == nil {
+		keywords = config.DefaultKeywords
+	}
+	var extra []int
+	if hasTag("urgent") {
+		extra = append(extra, 25)
+	}
+	switch points, _ := ScoreKeywords(email.Subject, StripQuoted(email.Body), keywords, extra...); {
+	case points > 0:
+		add("urgent", points)
+	case points < 0:
+		add("not_urgent", points)
+	case hasTag("important"):
+		add("important", 15)
+	}
+
+	// "by Friday" means the Friday after the email was sent, not after now
+	sent := now
+	if !email.Date.IsZero() && email.Date.Before(now) {
+		sent = email.Date
+	}
+	for _, deadline := range DetectDeadlines(email.Subject+"\n"+StripQuoted(email.Body), sent) {
+		if deadline.Due.After(now) {
+			if points := DeadlinePoints(deadline.Due, now); points > 0 {
+				add("deadline", points)
+			}
+			break
+		}
+	}
+
+	if ImportantDocument(email.Attachments) != "" {
+		add("document", 15)
+	}
+
+	if NeedsReply(email, category) {
+		add("needs_reply", 10)
+	}
+
+	p.Score = max(0, min(100, p.Score))
+	p.Level = PriorityLevel(p.Score)
+	return p
+}
+
+// ScoreKeywords adds up the weights of the keywords found in subject and,
+// at half weight, in body, with extra points counted as further hits. Each
+// keyword counts once, and hits have diminishing returns: the largest counts
+// in full, the next half, the one after a quarter, and so on, separately for
+// points added and removed, and the total stays between -30 and 40. It
+// returns the points and the phrases found, largest weight first. Words
+// matched by a negative keyword do not count for positive ones, so "not
+// urgent" is not also "urgent".
+func ScoreKeywords(subject, body string, keywords []config.Keyword, extra ...int) (int, []string) {
+	subjectWords, bodyWords := keywordWords(subject), keywordWords(body)
+	var subjectNegated, bodyNegated []phraseSpan
+	for _, keyword := range keywords {
+		if keyword.Weight < 0 {
+			phrase := keywordWords(keyword.Phrase)
+			subjectNegated = append(subjectNegated, phraseSpans(subjectWords, phrase, keyword.Within)...)
+			bodyNegated = append(bodyNegated, phraseSpans(bodyWords, phrase, keyword.Within)...)
+		}
+	}
+
+	type hit struct {
+		phrase string
+		points int
+	}
+	var hits []hit
+	for _, keyword := range keywords {
+		phrase := keywordWords(keyword.Phrase)
+		inSubject, inBody := phraseSpans(subjectWords, phrase, keyword.Within), phraseSpans(bodyWords, phrase, keyword.Within)
+		if keyword.Weight > 0 {
+			inSubject, inBody = outside(inSubject, subjectNegated), outside(inBody, bodyNegated)
+		}
+		switch {
+		case len(inSubject) > 0:
+			hits = append(hits, hit{keyword.Phrase, keyword.Weight})
+		case len(inBody) > 0:
+			hits = append(hits, hit{keyword.Phrase, keyword.Weight / 2})
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return abs(hits[i].points) > abs(hits[j].points) })
+
+	for _, points := range extra {
+		hits = append(hits, hit{points: points})
+	}
+	var added, removed []int
+	for _, h := range hits {
+		if h.points > 0 {
+			added = append(added, h.points)
+		} else if h.points < 0 {
+			removed = append(removed, -h.points)
+		}
+	}
+	total := diminishing(added) - diminishing(removed)
+
+	var found []string
+	for _, h := range hits {
+		if h.phrase != "" {
+			found = append(found, h.phrase)
+		}
+	}
+	return max(minKeywordPoints, min(maxKeywordPoints, total)), found
+}
+
+// diminishing sums points, largest first, halving each after the first
+func diminishing(points []int) int {
+	slices.SortFunc(points, func(a, b int) int { return b - a })
+	total := 0
+	for i, p := range points {
+		total += p >> i
+	}
+	return total
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// keywordWords splits text into lowercase words, dropping punctuation
+func keywordWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+}
+
+// phraseSpan holds the positions of the first and last word of a phrase
+// found in a text
+type phraseSpan struct{ first, last int }
+
+// phraseSpans returns where words contain phrase in order, with up to within
+// other words between consecutive words of the phrase
+func phraseSpans(words, phrase []string, within int) []phraseSpan {
+	if len(phrase) == 0 {
+		return nil
+	}
+	var spans []phraseSpan
+	for start, word := range words {
+		if word != phrase[0] {
+			continue
+		}
+		last, matched := start, 1
+		for next := start + 1; next < len(words) && next-last-1 <= within && matched < len(phrase); next++ {
+			if words[next] == phrase[matched] {
+				last, matched = next, matched+1
+			}
+		}
+		if matched == len(phrase) {
+			spans = append(spans, phraseSpan{start, last})
+		}
+	}
+	return spans
+}
+
+// outside returns the spans not within one of negated
+func outside(spans, negated []phraseSpan) []phraseSpan {
+	var kept []phraseSpan
+	for _, span := range spans {
+		if !slices.ContainsFunc(negated, func(n phraseSpan) bool { return n.first <= span.first && span.last <= n.last }) {
+			kept = append(kept, span)
+		}
+	}
+	return kept
+}
+
+// ImportantDocument returns the name of the first attachment that is a
+// document, such as a PDF, named like an invoice or a contract; "" when there
+// is none
+func ImportantDocument(attachments []mail.Attachment) string {
+	for _, attachment := range attachments {
+		name := strings.ToLower(attachment.Filename)
+		if !slices.Contains(documentExtensions, path.Ext(name)) {
+			continue
+		}
+		// Names often join words with dashes or underscores
+		name = strings.NewReplacer("_", " ", "-", " ").Replace(name)
+		for _, word := range documentWords {
+			if strings.Contains(name, word) {
+				return attachment.Filename
+			}
+		}
+	}
+	return ""
+}
+
+// ThreadActivity sums up the messages of a conversation for ScoreThread
+type ThreadActivity struct {
+	Messages     int
+	Unread       int
+	MaxScore     int     // highest priority score of its emails
+	MeanScore    float64 // mean priority score of its scored emails
+	LastActivity time.Time
+}
+
+// ScoreThread ranks a conversation by its most pressing email, nudged up by
+// the mean score, unread messages and how busy it is, and down the longer it
+// has been quiet
+func ScoreThread(t ThreadActivity, now time.Time) int {
+	score := float64(t.MaxScore)*0.7 + t.MeanScore*0.3
+	score += float64(min(t.Unread, 5) * 3)
+	if t.Messages >= 5 {
+		score += 5
+	}
+
+	switch idle := now.Sub(t.LastActivity); {
+	case idle > 14*24*time.Hour:
+		score -= 20
+	case idle > 7*24*time.Hour:
+		score -= 10
+	case idle > 3*24*time.Hour:
+		score -= 5
+	}
+	return max(0, min(100, int(score+0.5)))
+}
+
+// PriorityLevel names the level of a score
+func PriorityLevel(score int) string {
+	switch {
+	case score >= 80:
+		return PriorityCritical
+	case score >= 60:
+		return PriorityHigh
+	case score >= 40:
+		return PriorityMedium
+	case score >= 20:
+		return PriorityLow
+	}
+	return PriorityMinimal
+}
+
+// Reasons lists the factors that changed the score, largest first, e.g.
+// "vip sender +30"
+func (p Priority) Reasons() []string {
+	factors := make([]string, 0, len(p.Factors))
+	for factor := range p.Factors {
+		if factor != "base" {
+			factors = append(factors, factor)
+		}
+	}
+	sort.Slice(factors, func(i, j int) bool {
+		if p.Factors[factors[i]] != p.Factors[factors[j]] {
+			return p.Factors[factors[i]] > p.Factors[factors[j]]
+		}
+		return factors[i] < factors[j]
+	})
+
+	reasons := make([]string, len(factors))
+	for i, factor := range factors {
+		reasons[i] = fmt.Sprintf("%s %+d", strings.ReplaceAll(factor, "_", " "), p.Factors[factor])
+	}
+	return reasons
+}
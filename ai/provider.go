@@ -0,0 +1,220 @@
+// Package ai implements the intelligence features: summaries, classification
+// and the LLM providers backing them.
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"email-mcp-server/config"
+)
+
+// ErrNotConfigured is returned by NewProvider when the selected provider needs
+// an API key and none is set. Callers fall back to non-LLM behaviour.
+var ErrNotConfigured = errors.New("no LLM provider configured")
+
+// Request is a single-turn completion request
+type Request struct {
+	System      string
+	Prompt      string
+	MaxTokens   int
+	Temperature float64
+}
+
+// Provider sends completion requests to an LLM
+type Provider interface {
+	Name() string
+	Model() string
+	Complete(ctx context.Context, req Request) (string, error)
+}
+
+// NewProvider creates the provider selected by cfg
+func NewProvider(cfg *config.AIConfig) (Provider, error) {
+	base := httpProvider{
+		apiKey:  cfg.APIKey,
+		baseURL: strings.TrimSuffix(cfg.BaseURL, "/"),
+		model:   cfg.Model,
+		client:  &http.Client{Timeout: time.Duration(cfg.TimeoutSeconds) * time.Second},
+	}
+
+	switch strings.ToLower(cfg.Provider) {
+	case "openai":
+		if base.apiKey == "" {
+			return nil, ErrNotConfigured
+		}
+		base.setDefaults("https://api.openai.com/v1", "gpt-4o-mini")
+		return &openAIProvider{base}, nil
+	case "anthropic":
+		if base.apiKey == "" {
+			return nil, ErrNotConfigured
+		}
+		base.setDefaults("https://api.anthropic.com/v1", "claude-3-5-haiku-latest")
+		return &anthropicProvider{base}, nil
+	case "ollama":
+		base.setDefaults("http://localhost:11434", "llama3.1")
+		return &ollamaProvider{base}, nil
+	case "", "none":
+		return nil, ErrNotConfigured
+	}
+	return nil, fmt.Errorf("unknown AI provider: %s", cfg.Provider)
+}
+
+type httpProvider struct {
+	apiKey  string
+	baseURL string
+	model   string
+	client  *http.Client
+}
+
+func (p *httpProvider) setDefaults(baseURL, model string) {
+	if p.baseURL == "" {
+		p.baseURL = baseURL
+	}
+	if p.model == "" {
+		p.model = model
+	}
+}
+
+func (p *httpProvider) Model() string {
+	return p.model
+}
+
+// post sends body as JSON and decodes the JSON response into out
+func (p *httpProvider) post(ctx context.Context, url string, headers map[string]string, body, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s: %s", url, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type openAIProvider struct{ httpProvider }
+
+func (p *openAIProvider) Name() string { return "openai" }
+
+func (p *openAIProvider) Complete(ctx context.Context, req Request) (string, error) {
+	body := map[string]interface{}{
+		"model":       p.model,
+		"temperature": req.Temperature,
+		"max_tokens":  req.MaxTokens,
+		"messages": []chatMessage{
+			{Role: "system", Content: req.System},
+			{Role: "user", Content: req.Prompt},
+		},
+	}
+
+	var resp struct {
+		Choices []struct {
+			Message chatMessage `json:"message"`
+		} `json:"choices"`
+	}
+	headers := map[string]string{"Authorization": "Bearer " + p.apiKey}
+	if err := p.post(ctx, p.baseURL+"/chat/completions", headers, body, &resp); err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("empty response from openai")
+	}
+	return strings.TrimSpace(resp.Choices[0].Message.Content), nil
+}
+
+type anthropicProvider struct{ httpProvider }
+
+func (p *anthropicProvider) Name() string { return "anthropic" }
+
+func (p *anthropicProvider) Complete(ctx context.Context, req Request) (string, error) {
+	body := map[string]interface{}{
+		"model":       p.model,
+		"system":      req.System,
+		"temperature": req.Temperature,
+		"max_tokens":  req.MaxTokens,
+		"messages":    []chatMessage{{Role: "user", Content: req.Prompt}},
+	}
+
+	var resp struct {
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	headers := map[string]string{"x-api-key": p.apiKey, "anthropic-version": "2023-06-01"}
+	if err := p.post(ctx, p.baseURL+"/messages", headers, body, &resp); err != nil {
+		return "", err
+	}
+
+	var text strings.Builder
+	for _, block := range resp.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	if text.Len() == 0 {
+		return "", fmt.Errorf("empty response from anthropic")
+	}
+	return strings.TrimSpace(text.String()), nil
+}
+
+type ollamaProvider struct{ httpProvider }
+
+func (p *ollamaProvider) Name() string { return "ollama" }
+
+func (p *ollamaProvider) Complete(ctx context.Context, req Request) (string, error) {
+	body := map[string]interface{}{
+		"model":  p.model,
+		"stream": false,
+		"messages": []chatMessage{
+			{Role: "system", Content: req.System},
+			{Role: "user", Content: req.Prompt},
+		},
+		"options": map[string]interface{}{
+			"temperature": req.Temperature,
+			"num_predict": req.MaxTokens,
+		},
+	}
+
+	var resp struct {
+		Message chatMessage `json:"message"`
+	}
+	if err := p.post(ctx, p.baseURL+"/api/chat", nil, body, &resp); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(resp.Message.Content), nil
+}
@@ -0,0 +1,161 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"email-mcp-server/config"
+	"email-mcp-server/mail"
+)
+
+// ReplyOptions shape a generated reply
+type ReplyOptions struct {
+	Tone         string // formal, friendly or neutral
+	Length       string // brief, normal or detailed
+	Intent       string // accept, decline, acknowledge or empty to let the model decide
+	Instructions string // extra free-form guidance, e.g. "propose Tuesday instead"
+	Signature    string
+}
+
+// Reply is a generated reply, ready to be passed to create_draft
+type Reply struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	Method  string `json:"method"` // "llm" or "template"
+	Note    string `json:"note,omitempty"`
+}
+
+// ReplyGenerator drafts replies with the configured LLM, falling back to
+// simple templates when no provider is available
+type ReplyGenerator struct {
+	ai       *config.AIConfig
+	provider Provider
+}
+
+// NewReplyGenerator creates a reply generator. provider may be nil.
+func NewReplyGenerator(cfg *config.AIConfig, provider Provider) *ReplyGenerator {
+	return &ReplyGenerator{ai: cfg, provider: provider}
+}
+
+// GenerateReply drafts a reply to email
+func (g *ReplyGenerator) GenerateReply(ctx context.Context, email Email, opts ReplyOptions) *Reply {
+	reply := &Reply{To: replyAddress(email.From), Subject: replySubject(email.Subject)}
+
+	if g.provider == nil {
+		reply.Body, reply.Method = templateReply(email, opts), "template"
+		reply.Note = "No LLM provider configured; showing a template reply"
+		return reply
+	}
+
+	body, err := g.provider.Complete(ctx, Request{
+		System:      replySystemPrompt(opts),
+		Prompt:      buildPrompt("email", []Email{email}) + "\nWrite the reply.",
+		MaxTokens:   g.ai.MaxTokens * 2,
+		Temperature: g.ai.Temperature,
+	})
+	if err != nil || body == "" {
+		reply.Body, reply.Method = templateReply(email, opts), "template"
+		reply.Note = fmt.Sprintf("LLM request failed (%v); showing a template reply", err)
+		return reply
+	}
+
+	reply.Body, reply.Method = withSignature(body, opts.Signature), "llm"
+	return reply
+}
+
+func replySystemPrompt(opts ReplyOptions) string {
+	var b strings.Builder
+	b.WriteString("You write email replies on behalf of the recipient of the message below. ")
+	b.WriteString("Output only the reply body: no subject line, no quoted original, no placeholders like [Name]. ")
+
+	switch opts.Tone {
+	case "formal":
+		b.WriteString("Use a formal, professional tone. ")
+	case "friendly":
+		b.WriteString("Use a warm, friendly tone. ")
+	default:
+		b.WriteString("Use a neutral, polite tone. ")
+	}
+	switch opts.Length {
+	case "brief":
+		b.WriteString("Keep it to two or three sentences. ")
+	case "detailed":
+		b.WriteString("Address every point raised in the message. ")
+	default:
+		b.WriteString("Keep it concise. ")
+	}
+	switch opts.Intent {
+	case "accept":
+		b.WriteString("Accept the request or invitation. ")
+	case "decline":
+		b.WriteString("Politely decline the request or invitation. ")
+	case "acknowledge":
+		b.WriteString("Acknowledge receipt and say you will follow up. ")
+	}
+	if opts.Instructions != "" {
+		fmt.Fprintf(&b, "Also follow these instructions: %s. ", opts.Instructions)
+	}
+	if opts.Signature != "" {
+		b.WriteString("Do not add a signature; it is appended automatically. ")
+	}
+	b.WriteString("Write in the language of the original message.")
+	return b.String()
+}
+
+func templateReply(email Email, opts ReplyOptions) string {
+	name := senderName(email.From)
+	greeting, closing := "Hi "+name+",", "Best,"
+	if opts.Tone == "formal" {
+		greeting, closing = "Dear "+name+",", "Kind regards,"
+	}
+
+	var body string
+	switch opts.Intent {
+	case "accept":
+		body = fmt.Sprintf("Thank you for your message about \"%s\". I'm happy to accept.", email.Subject)
+	case "decline":
+		body = fmt.Sprintf("Thank you for your message about \"%s\". Unfortunately I have to decline this time.", email.Subject)
+	default:
+		body = fmt.Sprintf("Thank you for your message about \"%s\". I'll get back to you shortly.", email.Subject)
+	}
+	if opts.Instructions != "" {
+		body += "\n\n" + opts.Instructions
+	}
+
+	return withSignature(fmt.Sprintf("%s\n\n%s\n\n%s", greeting, body, closing), opts.Signature)
+}
+
+func withSignature(body, signature string) string {
+	if signature == "" {
+		return body
+	}
+	return strings.TrimRight(body, "\n") + "\n\n" + signature
+}
+
+func replySubject(subject string) string {
+	lower := strings.ToLower(strings.TrimSpace(subject))
+	if strings.HasPrefix(lower, "re:") || strings.HasPrefix(lower, "aw:") {
+		return subject
+	}
+	return "Re: " + subject
+}
+
+func replyAddress(from string) string {
+	if addr, err := mail.ParseAddress(from); err == nil {
+		return addr.Address
+	}
+	return strings.TrimSpace(from)
+}
+
+func senderName(from string) string {
+	addr, err := mail.ParseAddress(from)
+	if err != nil {
+		return "there"
+	}
+	if addr.Name != "" {
+		return strings.Fields(addr.Name)[0]
+	}
+	return "there"
+}
@@ -0,0 +1,324 @@
+package ai
+
+import (
+	"net"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Phishing risk levels
+const (
+	RiskHigh   = "high"
+	RiskMedium = "medium"
+	RiskLow    = "low"
+	RiskNone   = "none"
+)
+
+// PhishingInput is the message inspected by CheckPhishing. Headers are keyed
+// by canonical name, as in mail.ParsedEmail; without bodies only the header
+// checks run.
+type PhishingInput struct {
+	Headers  map[string][]string
+	TextBody string
+	HTMLBody string
+}
+
+func (in PhishingInput) header(key string) string {
+	values := in.Headers[textproto.CanonicalMIMEHeaderKey(key)]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// RiskFinding is one heuristic that raised the risk score
+type RiskFinding struct {
+	Check  string `json:"check"`
+	Points int    `json:"points"`
+	Detail string `json:"detail"`
+}
+
+// PhishingReport scores how likely an email is spam or phishing, from 0 to
+// 100. SPF, DKIM and DMARC hold the receiving server's verdicts (pass, fail,
+// softfail, none...) and are empty when it recorded none.
+type PhishingReport struct {
+	Score    int           `json:"score"`
+	Level    string        `json:"level"`
+	SPF      string        `json:"spf,omitempty"`
+	DKIM     string        `json:"dkim,omitempty"`
+	DMARC    string        `json:"dmarc,omitempty"`
+	Findings []RiskFinding `json:"findings,omitempty"`
+}
+
+// Brands often impersonated in display names and lookalike hosts. Names that
+// are also common words or first names are left out.
+var impersonatedBrands = []string{
+	"paypal", "apple", "icloud", "microsoft", "office365", "outlook", "amazon", "google", "netflix",
+	"facebook", "instagram", "linkedin", "whatsapp", "dhl", "fedex", "docusign", "dropbox", "coinbase",
+	"binance", "bankofamerica", "wellsfargo", "santander", "bbva", "caixabank", "correos", "hacienda",
+}
+
+var freemailDomains = map[string]bool{
+	"gmail.com": true, "googlemail.com": true, "yahoo.com": true, "hotmail.com": true, "outlook.com": true,
+	"live.com": true, "aol.com": true, "icloud.com": true, "proton.me": true, "protonmail.com": true,
+	"gmx.com": true, "mail.ru": true, "yandex.ru": true, "zoho.com": true,
+}
+
+var urlShorteners = map[string]bool{
+	"bit.ly": true, "tinyurl.com": true, "t.co": true, "goo.gl": true, "ow.ly": true, "is.gd": true,
+	"buff.ly": true, "rebrand.ly": true, "cutt.ly": true, "shorturl.at": true,
+}
+
+var suspiciousTLDs = map[string]bool{
+	"zip": true, "mov": true, "xyz": true, "top": true, "tk": true, "ml": true, "ga": true, "cf": true,
+	"gq": true, "click": true, "country": true, "work": true, "rest": true, "support": true,
+}
+
+var credentialPhrases = []string{
+	"verify your account", "confirm your password", "confirm your identity", "update your payment",
+	"account has been suspended", "account will be suspended", "unusual sign-in", "unusual activity",
+	"login to restore", "log in to restore", "reset your password immediately",
+	"verifique su cuenta", "verifica tu cuenta", "confirme sus datos", "confirma tus datos",
+	"cuenta ha sido suspendida", "cuenta será suspendida", "actividad inusual",
+}
+
+var (
+	authResultPattern = regexp.MustCompile(`(?i)\b(spf|dkim|dmarc)\s*=\s*([a-z]+)`)
+	anchorPattern     = regexp.MustCompile(`(?is)<a\s[^>]*href\s*=\s*["']([^"']+)["'][^>]*>(.*?)</a>`)
+	tagPattern        = regexp.MustCompile(`(?s)<[^>]*>`)
+	urlPattern        = regexp.MustCompile(`(?i)https?://[^\s<>"')\]]+`)
+	domainPattern     = regexp.MustCompile(`(?i)\b(?:[a-z0-9-]+\.)+[a-z]{2,}\b`)
+)
+
+// CheckPhishing scores an email on its SPF, DKIM and DMARC results, display
+// name spoofing, Reply-To mismatches, the links it contains and requests for
+// credentials. The score is the sum of the findings, capped at 100; each
+// check counts once however many links trigger it.
+func CheckPhishing(in PhishingInput) PhishingReport {
+	var report PhishingReport
+	found := make(map[string]bool)
+	add := func(check string, points int, detail string) {
+		if found[check] {
+			return
+		}
+		found[check] = true
+		report.Findings = append(report.Findings, RiskFinding{Check: check, Points: points, Detail: detail})
+		report.Score += points
+	}
+
+	checkAuthentication(in, &report, add)
+
+	from, err := mail.ParseAddress(in.header("From"))
+	fromDomain := ""
+	if err != nil {
+		if in.header("From") != "" {
+			add("malformed_from", 10, "From header cannot be parsed: "+in.header("From"))
+		}
+	} else {
+		fromDomain = addressDomain(from.Address)
+		checkDisplayName(from, fromDomain, add)
+	}
+
+	if replyTo, err := mail.ParseAddress(in.header("Reply-To")); err == nil && fromDomain != "" {
+		replyDomain := addressDomain(replyTo.Address)
+		if registrableDomain(replyDomain) != registrableDomain(fromDomain) {
+			if freemailDomains[replyDomain] && !freemailDomains[fromDomain] {
+				add("reply_to_mismatch", 25, "replies go to free webmail address "+replyTo.Address+", not "+fromDomain)
+			} else {
+				add("reply_to_mismatch", 15, "replies go to "+replyDomain+", not "+fromDomain)
+			}
+		}
+	}
+
+	checkLinks(in, add)
+
+	text := strings.ToLower(in.header("Subject") + "\n" + in.TextBody + "\n" + tagPattern.ReplaceAllString(in.HTMLBody, " "))
+	for _, phrase := range credentialPhrases {
+		if strings.Contains(text, phrase) {
+			add("credential_request", 15, "asks to \""+phrase+"\"")
+			break
+		}
+	}
+
+	sort.SliceStable(report.Findings, func(i, j int) bool { return report.Findings[i].Points > report.Findings[j].Points })
+	report.Score = min(100, report.Score)
+	report.Level = RiskLevel(report.Score)
+	return report
+}
+
+// RiskLevel names the level of a phishing score
+func RiskLevel(score int) string {
+	switch {
+	case score >= 60:
+		return RiskHigh
+	case score >= 30:
+		return RiskMedium
+	case score > 0:
+		return RiskLow
+	}
+	return RiskNone
+}
+
+// checkAuthentication reads the topmost Authentication-Results header, the
+// one added by the receiving server, falling back to Received-SPF for SPF
+func checkAuthentication(in PhishingInput, report *PhishingReport, add func(string, int, string)) {
+	for _, match := range authResultPattern.FindAllStringSubmatch(in.header("Authentication-Results"), -1) {
+		method, result := strings.ToLower(match[1]), strings.ToLower(match[2])
+		switch method {
+		case "spf":
+			if report.SPF == "" {
+				report.SPF = result
+			}
+		case "dkim":
+			// One valid signature is enough
+			if report.DKIM == "" || result == "pass" {
+				report.DKIM = result
+			}
+		case "dmarc":
+			if report.DMARC == "" {
+				report.DMARC = result
+			}
+		}
+	}
+	if report.SPF == "" {
+		if fields := strings.Fields(in.header("Received-SPF")); len(fields) > 0 {
+			report.SPF = strings.ToLower(fields[0])
+		}
+	}
+
+	switch report.SPF {
+	case "fail":
+		add("spf", 20, "SPF failed: the sending server is not allowed to send for this domain")
+	case "softfail":
+		add("spf", 10, "SPF soft-failed")
+	}
+	if report.DKIM == "fail" {
+		add("dkim", 15, "DKIM signature failed verification")
+	}
+	if report.DMARC == "fail" {
+		add("dmarc", 30, "DMARC failed: the From domain is not authenticated")
+	}
+}
+
+func checkDisplayName(from *mail.Address, fromDomain string, add func(string, int, string)) {
+	name := strings.ToLower(from.Name)
+	if name == "" {
+		return
+	}
+
+	if strings.Contains(name, "@") {
+		for _, shown := range domainPattern.FindAllString(name, -1) {
+			if registrableDomain(shown) != registrableDomain(fromDomain) {
+				add("display_name_spoof", 30, "display name shows "+shown+" but the address is at "+fromDomain)
+				return
+			}
+		}
+	}
+
+	// "Microsoft Outlook" from microsoft.com is fine: one of the brands named
+	// owning the domain is enough
+	words := strings.FieldsFunc(name, func(r rune) bool { return !('a' <= r && r <= 'z' || '0' <= r && r <= '9') })
+	compact := strings.Join(words, "")
+	var named []string
+	for _, brand := range impersonatedBrands {
+		if containsWord(words, brand) || len(brand) > 6 && strings.Contains(compact, brand) {
+			if strings.Contains(registrableDomain(fromDomain), brand) {
+				return
+			}
+			named = append(named, brand)
+		}
+	}
+	if len(named) > 0 {
+		add("brand_impersonation", 25, "display name mentions "+named[0]+" but the address is at "+fromDomain)
+	}
+}
+
+// checkLinks inspects every link of the text and HTML bodies
+func checkLinks(in PhishingInput, add func(string, int, string)) {
+	type link struct{ href, text string }
+	var links []link
+	for _, match := range anchorPattern.FindAllStringSubmatch(in.HTMLBody, -1) {
+		links = append(links, link{href: match[1], text: strings.TrimSpace(tagPattern.ReplaceAllString(match[2], ""))})
+	}
+	for _, href := range urlPattern.FindAllString(in.TextBody, -1) {
+		links = append(links, link{href: strings.TrimRight(href, ".,;:!?")})
+	}
+
+	for _, l := range links {
+		u, err := url.Parse(strings.TrimSpace(l.href))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+		host := strings.ToLower(u.Hostname())
+		domain := registrableDomain(host)
+
+		if u.User != nil {
+			add("url_userinfo", 20, "link hides its real host after an @: "+l.href)
+		}
+		if net.ParseIP(host) != nil {
+			add("ip_url", 20, "link points to a bare IP address: "+host)
+		}
+		if strings.Contains(host, "xn--") {
+			add("punycode_url", 15, "link uses an internationalized lookalike domain: "+host)
+		}
+		if urlShorteners[domain] {
+			add("url_shortener", 10, "link goes through a URL shortener: "+host)
+		}
+		if labels := strings.Split(host, "."); suspiciousTLDs[labels[len(labels)-1]] {
+			add("suspicious_tld", 10, "link uses a top-level domain common in abuse: "+host)
+		}
+		for _, brand := range impersonatedBrands {
+			if strings.Contains(host, brand) && !strings.Contains(domain, brand) {
+				add("lookalike_url", 25, "link mentions "+brand+" but belongs to "+domain)
+				break
+			}
+		}
+
+		if shown := domainPattern.FindString(l.text); shown != "" && net.ParseIP(host) == nil &&
+			registrableDomain(shown) != domain {
+			add("link_text_mismatch", 30, "link text shows "+shown+" but points to "+host)
+		}
+	}
+}
+
+func addressDomain(address string) string {
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		return strings.ToLower(address[i+1:])
+	}
+	return ""
+}
+
+// registrableDomain approximates the domain a host was registered under:
+// its last two labels, or three under country second-level domains such as
+// co.uk or com.au
+func registrableDomain(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	labels := strings.Split(host, ".")
+	if len(labels) <= 2 {
+		return host
+	}
+	n := 2
+	switch labels[len(labels)-2] {
+	case "co", "com", "org", "net", "gov", "edu", "ac", "gob":
+		if len(labels[len(labels)-1]) == 2 {
+			n = 3
+		}
+	}
+	return strings.Join(labels[len(labels)-n:], ".")
+}
+
+func containsWord(words []string, word string) bool {
+	for _, w := range words {
+		if w == word {
+			return true
+		}
+	}
+	return false
+}
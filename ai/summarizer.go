@@ -0,0 +1,257 @@
+package ai
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"email-mcp-server/config"
+)
+
+// maxPromptBody caps how much of each message body is sent to the LLM
+const maxPromptBody = 4000
+
+// Summary is the result of summarizing an email or a thread
+type Summary struct {
+	Text     string `json:"summary"`
+	Method   string `json:"method"` // "llm" or "extractive"
+	Provider string `json:"provider,omitempty"`
+	Model    string `json:"model,omitempty"`
+	Cached   bool   `json:"cached,omitempty"`
+	Note     string `json:"note,omitempty"`
+}
+
+// Summarizer produces summaries with the configured LLM. Without a provider,
+// or when a request fails, it falls back to an extractive summary built from
+// the first sentences of each message.
+type Summarizer struct {
+	cfg      config.SummarizationConfig
+	ai       *config.AIConfig
+	provider Provider
+
+	mu    sync.Mutex
+	cache map[string]cachedSummary
+}
+
+type cachedSummary struct {
+	summary Summary
+	expires time.Time
+}
+
+// NewSummarizer creates a summarizer. provider may be nil.
+func NewSummarizer(cfg *config.AIConfig, provider Provider) *Summarizer {
+	return &Summarizer{
+		cfg:      cfg.Summarization,
+		ai:       cfg,
+		provider: provider,
+		cache:    make(map[string]cachedSummary),
+	}
+}
+
+// SummarizeEmail summarizes a single message
+func (s *Summarizer) SummarizeEmail(ctx context.Context, email Email) *Summary {
+	return s.summarize(ctx, "email", []Email{email})
+}
+
+// SummarizeThread summarizes a conversation given in chronological order
+func (s *Summarizer) SummarizeThread(ctx context.Context, emails []Email) *Summary {
+	return s.summarize(ctx, "thread", emails)
+}
+
+func (s *Summarizer) summarize(ctx context.Context, kind string, emails []Email) *Summary {
+	if s.provider == nil {
+		summary := extractiveSummary(emails, s.maxLength())
+		summary.Note = "No LLM provider configured; showing an extractive summary"
+		return summary
+	}
+
+	prompt := buildPrompt(kind, emails)
+	key := s.cacheKey(kind, prompt)
+	if cached, ok := s.cached(key); ok {
+		return cached
+	}
+
+	text, err := s.provider.Complete(ctx, Request{
+		System:      s.systemPrompt(kind),
+		Prompt:      prompt,
+		MaxTokens:   s.ai.MaxTokens,
+		Temperature: s.ai.Temperature,
+	})
+	if err != nil {
+		summary := extractiveSummary(emails, s.maxLength())
+		summary.Note = fmt.Sprintf("LLM request failed (%v); showing an extractive summary", err)
+		return summary
+	}
+
+	summary := &Summary{Text: text, Method: "llm", Provider: s.provider.Name(), Model: s.provider.Model()}
+	s.store(key, *summary)
+	return summary
+}
+
+func (s *Summarizer) systemPrompt(kind string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "You summarize %ss for a busy reader. ", kind)
+	switch s.cfg.Style {
+	case "detailed":
+		b.WriteString("Write a thorough summary covering context, decisions, open questions and requested actions. ")
+	case "bullet_points":
+		b.WriteString("Answer with short bullet points: key facts first, then any requested actions and deadlines. ")
+	default:
+		b.WriteString("Write two or three plain sentences with the key point and any requested action. ")
+	}
+	if kind == "thread" {
+		b.WriteString("Say who said what when it matters and end with the current state of the conversation. ")
+	}
+	fmt.Fprintf(&b, "Use at most %d words. ", s.maxLength())
+	if s.cfg.Language != "" {
+		fmt.Fprintf(&b, "Write in %s.", s.cfg.Language)
+	} else {
+		b.WriteString("Write in the language of the email.")
+	}
+	return b.String()
+}
+
+func (s *Summarizer) maxLength() int {
+	if s.cfg.MaxLength > 0 {
+		return s.cfg.MaxLength
+	}
+	return 100
+}
+
+func (s *Summarizer) cacheKey(kind, prompt string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%d\x00%s\x00%s\x00%s", s.cfg.Style, s.cfg.Language, s.cfg.MaxLength,
+		s.provider.Model(), kind, prompt)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (s *Summarizer) cached(key string) (*Summary, bool) {
+	if !s.cfg.CacheEnabled {
+		return nil, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.cache[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(s.cache, key)
+		return nil, false
+	}
+	summary := entry.summary
+	summary.Cached = true
+	return &summary, true
+}
+
+func (s *Summarizer) store(key string, summary Summary) {
+	if !s.cfg.CacheEnabled || s.cfg.CacheTTLMinutes <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, entry := range s.cache {
+		if now.After(entry.expires) {
+			delete(s.cache, k)
+		}
+	}
+	s.cache[key] = cachedSummary{summary: summary, expires: now.Add(time.Duration(s.cfg.CacheTTLMinutes) * time.Minute)}
+}
+
+func buildPrompt(kind string, emails []Email) string {
+	var b strings.Builder
+	if kind == "thread" {
+		fmt.Fprintf(&b, "Summarize this conversation of %d messages, oldest first.\n", len(emails))
+	} else {
+		b.WriteString("Summarize this email.\n")
+	}
+
+	for i, email := range emails {
+		body := StripQuoted(email.Body)
+		if runes := []rune(body); len(runes) > maxPromptBody {
+			body = string(runes[:maxPromptBody]) + " [...]"
+		}
+		fmt.Fprintf(&b, "\n--- Message %d ---\nFrom: %s\nDate: %s\nSubject: %s\n\n%s\n",
+			i+1, email.From, email.Date.Format(time.RFC1123Z), email.Subject, body)
+	}
+	return b.String()
+}
+
+// extractiveSummary keeps the opening sentences of each message
+func extractiveSummary(emails []Email, maxWords int) *Summary {
+	perMessage := maxWords
+	if len(emails) > 1 {
+		perMessage = maxWords / len(emails)
+		if perMessage < 15 {
+			perMessage = 15
+		}
+	}
+
+	var lines []string
+	for _, email := range emails {
+		text := firstWords(leadingSentences(StripQuoted(email.Body), 2), perMessage)
+		if text == "" {
+			text = "(no text)"
+		}
+		if len(emails) == 1 {
+			lines = append(lines, fmt.Sprintf("%s — %s: %s", email.Subject, email.From, text))
+		} else {
+			lines = append(lines, fmt.Sprintf("- %s, %s: %s", email.Date.Format("2006-01-02 15:04"), email.From, text))
+		}
+	}
+	return &Summary{Text: strings.Join(lines, "\n"), Method: "extractive"}
+}
+
+// StripQuoted removes quoted replies ("> ..." lines and everything after an
+// "On ... wrote:" attribution) and signatures, leaving the new text of a message
+func StripQuoted(body string) string {
+	var kept []string
+	for _, line := range strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "--" || strings.HasPrefix(trimmed, "-----Original Message-----") {
+			break
+		}
+		if (strings.HasPrefix(trimmed, "On ") || strings.HasPrefix(trimmed, "El ")) &&
+			(strings.HasSuffix(trimmed, "wrote:") || strings.HasSuffix(trimmed, "escribió:")) {
+			break
+		}
+		if strings.HasPrefix(trimmed, ">") {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
+
+func leadingSentences(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	count := 0
+	for i, r := range text {
+		if r == '.' || r == '!' || r == '?' {
+			if i+1 == len(text) || text[i+1] == ' ' {
+				count++
+				if count == n {
+					return text[:i+1]
+				}
+			}
+		}
+	}
+	return text
+}
+
+func firstWords(text string, n int) string {
+	words := strings.Fields(text)
+	if len(words) <= n {
+		return text
+	}
+	return strings.Join(words[:n], " ") + "…"
+}
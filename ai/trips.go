@@ -0,0 +1,138 @@
+package ai
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Trip is a flight an itinerary, booking confirmation or boarding pass email
+// lists. Fields that could not be found are left empty.
+type Trip struct {
+	Airline          string     `json:"airline,omitempty"`
+	FlightNumber     string     `json:"flight_number"`
+	BookingReference string     `json:"booking_reference,omitempty"`
+	Origin           string     `json:"origin,omitempty"`      // IATA airport code, e.g. MAD
+	Destination      string     `json:"destination,omitempty"` // IATA airport code, e.g. BCN
+	Departure        *time.Time `json:"departure,omitempty"`
+}
+
+// airlines name the airlines by their IATA codes
+var airlines = map[string]string{
+	"IB": "Iberia", "I2": "Iberia Express", "VY": "Vueling", "UX": "Air Europa", "V7": "Volotea", "FR": "Ryanair",
+	"U2": "easyJet", "BA": "British Airways", "LH": "Lufthansa", "AF": "Air France", "KL": "KLM", "TP": "TAP Air Portugal",
+	"AZ": "ITA Airways", "LX": "Swiss", "AA": "American Airlines", "DL": "Delta", "UA": "United", "EK": "Emirates",
+}
+
+var (
+	// flightPattern matches "Flight IB 3101", "Vuelo: VY1234" or "Flight no.
+	// U2 7441"
+	flightPattern = regexp.MustCompile(`(?i:\b(?:flight|vuelo|vol|flug)\s*(?:no\.?|number|n[º°]\.?)?\s*:?\s*)([A-Z][A-Z0-9]|[0-9][A-Z])\s?(\d{1,4})\b`)
+	// flightCodePattern matches a bare flight number, only taken on lines
+	// that also show a route
+	flightCodePattern = regexp.MustCompile(`\b([A-Z][A-Z0-9]|[0-9][A-Z])\s?(\d{2,4})\b`)
+	// routePattern matches "MAD → BCN", "MAD-BCN" or "MAD to BCN"
+	routePattern = regexp.MustCompile(`\b([A-Z]{3})\s*(?:→|->|-|–|—|>|\bto\b|\ba\b)\s*([A-Z]{3})\b`)
+	// airportPattern matches the code in "Madrid (MAD)"
+	airportPattern = regexp.MustCompile(`\(([A-Z]{3})\)`)
+	datePattern    = regexp.MustCompile(`(?i)` + dateExpr)
+	timePattern    = regexp.MustCompile(`\b([01]?\d|2[0-3])[:h]([0-5]\d)\b`)
+	// bookingPattern matches "Booking reference: ABC123" or "Localizador
+	// XYZ9KL"
+	bookingPattern = regexp.MustCompile(`(?i:\b(?:booking\s+(?:reference|ref\.?|code)|reservation\s+(?:code|number)|confirmation\s+(?:code|number)|pnr|localizador|c[óo]digo\s+de\s+reserva)\s*:?\s*)([A-Z0-9]{5,8})\b`)
+)
+
+// ExtractTrips reads the flights an email lists with patterns: each flight
+// number with the route and departure written on its line or the two after
+// it, the booking reference and the airline named by the flight number's
+// code, or else the sender
+func ExtractTrips(email Email) []Trip {
+	text := email.Subject + "\n" + StripQuoted(email.Body)
+	lines := strings.Split(text, "\n")
+	loc := time.Local
+	if !email.Date.IsZero() {
+		loc = email.Date.Location()
+	}
+
+	reference := ""
+	if match := bookingPattern.FindStringSubmatch(text); match != nil {
+		reference = match[1]
+	}
+
+	var trips []Trip
+	seen := make(map[string]bool)
+	for i, line := range lines {
+		code, number := "", ""
+		if match := flightPattern.FindStringSubmatch(line); match != nil {
+			code, number = match[1], match[2]
+		} else if origin, _ := route(line); origin != "" {
+			if match := flightCodePattern.FindStringSubmatch(line); match != nil {
+				code, number = match[1], match[2]
+			}
+		}
+		if code == "" {
+			continue
+		}
+
+		trip := Trip{FlightNumber: code + number, BookingReference: reference, Airline: airlines[code]}
+		if trip.Airline == "" {
+			trip.Airline = merchantName(email.From)
+		}
+		nearby := lines[i:min(i+3, len(lines))]
+		for _, l := range nearby {
+			if trip.Origin, trip.Destination = route(l); trip.Origin != "" {
+				break
+			}
+		}
+		trip.Departure = departure(nearby, loc)
+
+		// Later mentions of a flight without its date are the same flight
+		key := trip.FlightNumber
+		if trip.Departure != nil {
+			key += trip.Departure.Format(time.RFC3339)
+		} else if seen[trip.FlightNumber] {
+			continue
+		}
+		if !seen[key] {
+			seen[key], seen[trip.FlightNumber] = true, true
+			trips = append(trips, trip)
+		}
+	}
+	return trips
+}
+
+// route finds the origin and destination airports written on a line
+func route(line string) (string, string) {
+	if match := routePattern.FindStringSubmatch(line); match != nil {
+		return match[1], match[2]
+	}
+	if codes := airportPattern.FindAllStringSubmatch(line, 2); len(codes) == 2 {
+		return codes[0][1], codes[1][1]
+	}
+	return "", ""
+}
+
+// departure finds the first date in lines, at the first time written on the
+// same line or after it
+func departure(lines []string, loc *time.Location) *time.Time {
+	for i, line := range lines {
+		for _, match := range datePattern.FindAllStringIndex(line, -1) {
+			day, ok := parseDate(line[match[0]:match[1]], loc)
+			if !ok {
+				continue
+			}
+			rest := append([]string{line[match[1]:]}, lines[i+1:]...)
+			for _, l := range rest {
+				if t := timePattern.FindStringSubmatch(l); t != nil {
+					hour, _ := strconv.Atoi(t[1])
+					minute, _ := strconv.Atoi(t[2])
+					day = day.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+					break
+				}
+			}
+			return &day
+		}
+	}
+	return nil
+}
@@ -0,0 +1,535 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"email-mcp-server/ai"
+	"email-mcp-server/config"
+	"email-mcp-server/mail"
+	"email-mcp-server/storage"
+)
+
+// initAI loads the AI settings and creates the LLM provider. Without an API
+// key the AI tools still work, using their rule-based fallbacks.
+func (es *EmailServer) initAI() {
+	cfg, err := config.LoadAIConfig(aiConfigPath())
+	if err != nil {
+		log.Printf("AI config unavailable, using defaults: %v", err)
+		cfg = config.DefaultAIConfig()
+	}
+	es.aiConfig = cfg
+
+	es.llm, err = ai.NewProvider(cfg)
+	if err != nil && err != ai.ErrNotConfigured {
+		log.Printf("AI provider unavailable: %v", err)
+	}
+
+	es.summarizer = ai.NewSummarizer(cfg, es.llm)
+	es.replies = ai.NewReplyGenerator(cfg, es.llm)
+	es.actions = ai.NewActionExtractor(cfg, es.llm)
+	es.invoices = ai.NewInvoiceExtractor(cfg, es.llm)
+
+	path := rulesPath()
+	rules, err := config.LoadRules(path)
+	if err != nil {
+		log.Printf("Classification rules unavailable, using defaults: %v", err)
+		rules = config.DefaultRules()
+	} else {
+		// Never overwrite a file we could not read
+		es.rulesPath = path
+	}
+	es.rules = rules
+	es.classifier = ai.NewClassifier(rules, cfg, es.llm)
+
+	if es.db != nil {
+		if err := es.loadLearned(); err != nil {
+			log.Printf("Learned classifications unavailable: %v", err)
+		}
+	}
+}
+
+// loadLearned restores what the classifier learned from earlier corrections
+func (es *EmailServer) loadLearned() error {
+	stored, err := es.db.ListLearnedSenders()
+	if err != nil {
+		return err
+	}
+	adjustments, err := es.db.RuleAdjustments()
+	if err != nil {
+		return err
+	}
+
+	senders := make([]ai.LearnedSender, 0, len(stored))
+	for _, s := range stored {
+		senders = append(senders, ai.LearnedSender(s))
+	}
+	es.classifier.LoadLearned(senders, adjustments)
+	return nil
+}
+
+func (es *EmailServer) handleSummarizeEmail(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	id, ok := args["id"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("%w: id", errMissingParameter)
+	}
+
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	email, err := es.getEmailBody(ctx, config.ID, folder, uint32(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get email: %w", err)
+	}
+
+	summary := es.summarizer.SummarizeEmail(context.Background(), ai.Email{
+		AccountID:   config.ID,
+		Folder:      folder,
+		UID:         email.ID,
+		From:        email.From,
+		To:          email.To,
+		Subject:     email.Subject,
+		Body:        email.Body,
+		Date:        email.Date,
+		Automated:   email.Automated,
+		Attachments: email.Attachments,
+	})
+
+	return summaryResult(fmt.Sprintf("Summary of email %d (%s):", email.ID, email.Subject), summary), nil
+}
+
+func (es *EmailServer) handleSummarizeThread(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	threadID, emails, err := es.threadFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	// The database only holds snippets; fetch full bodies where possible
+	thread := make([]ai.Email, 0, len(emails))
+	for _, email := range emails {
+		thread = append(thread, es.fullEmail(ctx, email))
+	}
+
+	summary := es.summarizer.SummarizeThread(context.Background(), thread)
+	return summaryResult(fmt.Sprintf("Summary of thread %s (%d messages):", threadID, len(emails)), summary), nil
+}
+
+// fullEmail converts a synced email, fetching its body from the server and
+// falling back to the stored snippet if that fails
+func (es *EmailServer) fullEmail(ctx context.Context, email storage.Email) ai.Email {
+	result := ai.Email{
+		AccountID:   email.AccountID,
+		Folder:      email.Folder,
+		UID:         email.UID,
+		MessageID:   email.MessageID,
+		ThreadID:    email.ThreadID,
+		From:        email.From,
+		To:          email.To,
+		Subject:     email.Subject,
+		Body:        email.BodySnippet,
+		Date:        email.Date,
+		Automated:   email.Automated,
+		Attachments: email.Attachments,
+	}
+
+	if msg, err := es.getEmailBody(ctx, email.AccountID, email.Folder, email.UID); err == nil {
+		result.Body = msg.Body
+	} else {
+		log.Printf("Using snippet for %s/%d: %v", email.Folder, email.UID, err)
+	}
+	return result
+}
+
+func (es *EmailServer) handleClassifyEmails(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	if folder == "" {
+		folder = "INBOX"
+	}
+	limit := 10
+	if l, ok := args["limit"].(float64); ok {
+		limit = int(l)
+	}
+
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	var emails []EmailMessage
+	if id, ok := args["id"].(float64); ok {
+		email, err := es.getEmailBody(ctx, config.ID, folder, uint32(id))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get email: %w", err)
+		}
+		emails = append(emails, *email)
+	} else if emails, err = es.getEmails(ctx, config.ID, folder, limit, true); err != nil {
+		return nil, fmt.Errorf("failed to get emails: %w", err)
+	}
+
+	type classifiedEmail struct {
+		ID             uint32             `json:"id"`
+		From           string             `json:"from"`
+		Subject        string             `json:"subject"`
+		Classification *ai.Classification `json:"classification,omitempty"`
+		Error          string             `json:"error,omitempty"`
+	}
+
+	batch := make([]ai.Email, len(emails))
+	for i, email := range emails {
+		batch[i] = ai.Email{
+			AccountID:   config.ID,
+			Folder:      folder,
+			UID:         email.ID,
+			From:        email.From,
+			To:          email.To,
+			Subject:     email.Subject,
+			Body:        email.Body,
+			Date:        email.Date,
+			Automated:   email.Automated,
+			Attachments: email.Attachments,
+		}
+	}
+	// An email that fails is reported with its error instead of failing the
+	// whole batch
+	classifications, errs := es.classifier.ClassifyBatch(ctx, batch, es.batchWorkers)
+
+	results := make([]classifiedEmail, len(emails))
+	classified := 0
+	var changes []categoryChange
+	for i, email := range emails {
+		results[i] = classifiedEmail{ID: email.ID, From: email.From, Subject: email.Subject}
+		if errs[i] != nil {
+			results[i].Error = errs[i].Error()
+			continue
+		}
+		classification := classifications[i]
+		results[i].Classification = classification
+		classified++
+
+		change := categoryChange{UID: email.ID, Category: classification.Category}
+		if es.db != nil {
+			if previous, err := es.db.GetClassification(config.ID, folder, email.ID); err == nil && previous != nil {
+				change.Previous = previous.Category
+			}
+			err := es.db.SaveClassification(&storage.Classification{
+				AccountID:    config.ID,
+				Folder:       folder,
+				UID:          email.ID,
+				Category:     classification.Category,
+				Confidence:   classification.Confidence,
+				Rule:         classification.Rule,
+				Tags:         classification.Tags,
+				Method:       classification.Method,
+				Reasoning:    classification.Reasoning,
+				ClassifiedAt: classification.ClassifiedAt,
+			})
+			if err != nil {
+				log.Printf("Failed to store classification of email %d: %v", email.ID, err)
+			}
+		}
+		changes = append(changes, change)
+	}
+	written, err := es.writeBackCategories(ctx, config, folder, changes)
+
+	resultsJSON, _ := json.MarshalIndent(results, "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Classified %d of %d emails:\n\n%s%s", classified, len(results), string(resultsJSON), describeWriteBack(written, err)),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleCorrectClassification(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("classification feedback is not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	if folder == "" {
+		folder = "INBOX"
+	}
+	id, ok := args["id"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("%w: id", errMissingParameter)
+	}
+	category, _ := args["category"].(string)
+	category = strings.ToLower(strings.TrimSpace(category))
+	if category == "" {
+		return nil, fmt.Errorf("%w: category", errMissingParameter)
+	}
+
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	email, err := es.getEmailBody(ctx, config.ID, folder, uint32(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get email: %w", err)
+	}
+
+	previous, err := es.db.GetClassification(config.ID, folder, email.ID)
+	if err != nil {
+		return nil, err
+	}
+	feedback := &storage.Feedback{AccountID: config.ID, Folder: folder, UID: email.ID, Sender: email.FromAddress.Address, Correct: category}
+	if previous != nil {
+		feedback.Predicted = previous.Category
+		feedback.Rule = previous.Rule
+	}
+	if feedback.Sender == "" {
+		feedback.Sender = email.From
+	}
+
+	senderSamples, ruleMistakes, err := es.db.SaveFeedback(feedback)
+	if err != nil {
+		return nil, err
+	}
+
+	update := es.classifier.LearnFromFeedback(ai.Feedback{
+		Email:         ai.Email{AccountID: config.ID, Folder: folder, UID: email.ID, From: email.From, Subject: email.Subject},
+		Predicted:     feedback.Predicted,
+		Rule:          feedback.Rule,
+		Correct:       category,
+		SenderSamples: senderSamples,
+		RuleMistakes:  ruleMistakes,
+	})
+	if update.LearnedSender != nil {
+		if err := es.db.SaveLearnedSender((*storage.LearnedSender)(update.LearnedSender)); err != nil {
+			return nil, err
+		}
+	}
+	if update.RuleAdjustment != nil {
+		if err := es.db.SaveRuleAdjustment(update.RuleAdjustment.Rule, update.RuleAdjustment.Delta); err != nil {
+			return nil, err
+		}
+	}
+
+	err = es.db.SaveClassification(&storage.Classification{
+		AccountID:    config.ID,
+		Folder:       folder,
+		UID:          email.ID,
+		Category:     category,
+		Confidence:   1,
+		Method:       "feedback",
+		Reasoning:    "corrected by user",
+		ClassifiedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	text := fmt.Sprintf("Email %d reclassified from %q to %q", email.ID, feedback.Predicted, category)
+	if previous != nil && slices.Contains(previous.Tags, ai.TagNeedsReview) {
+		text += "; removed from the review queue"
+	}
+	written, err := es.writeBackCategories(ctx, config, folder, []categoryChange{{UID: email.ID, Previous: feedback.Predicted, Category: category}})
+	updateJSON, _ := json.MarshalIndent(update, "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("%s\n\n%s%s", text, string(updateJSON), describeWriteBack(written, err)),
+		}},
+	}, nil
+}
+
+// handleReviewQueue lists the classifications tagged needs_review, which
+// correct_classification resolves
+func (es *EmailServer) handleReviewQueue(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("the review queue is not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	limit := 20
+	if l, ok := args["limit"].(float64); ok && l >= 1 {
+		limit = int(l)
+	}
+
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	items, total, err := es.db.ReviewQueue(config.ID, ai.TagNeedsReview, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	if total == 0 {
+		fmt.Fprintf(&b, "No classifications of %s need review", config.ID)
+	} else {
+		fmt.Fprintf(&b, "%d classifications of %s are below the review threshold of %.2f", total, config.ID, es.aiConfig.Classification.ReviewThreshold)
+		if len(items) < total {
+			fmt.Fprintf(&b, " (showing %d)", len(items))
+		}
+		b.WriteString("; confirm or fix each with correct_classification:\n")
+		for _, item := range items {
+			fmt.Fprintf(&b, "- %s #%d %q from %s: %s (%.2f, %s)\n", item.Email.Folder, item.Email.UID, item.Email.Subject,
+				item.Email.From, item.Classification.Category, item.Classification.Confidence, item.Classification.Method)
+		}
+	}
+
+	itemsJSON, _ := json.MarshalIndent(items, "", "  ")
+	return ToolResult{
+		Content: []TextContent{
+			{Type: "text", Text: strings.TrimRight(b.String(), "\n")},
+			{Type: "text", Text: string(itemsJSON)},
+		},
+	}, nil
+}
+
+// handleTestRules reads the rules file again, so edits can be tried without
+// restarting, and explains how each rule fares against an email. Nothing is
+// stored and the running classifier keeps its rules.
+func (es *EmailServer) handleTestRules(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+
+	var email ai.Email
+	if id, ok := args["id"].(float64); ok {
+		config, err := es.getConfig(accountID)
+		if err != nil {
+			return nil, err
+		}
+
+		msg, err := es.getEmailBody(ctx, config.ID, folder, uint32(id))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get email: %w", err)
+		}
+		email = ai.Email{
+			AccountID:   config.ID,
+			Folder:      folder,
+			UID:         msg.ID,
+			From:        msg.From,
+			To:          msg.To,
+			Subject:     msg.Subject,
+			Body:        msg.Body,
+			Date:        msg.Date,
+			Automated:   msg.Automated,
+			Attachments: msg.Attachments,
+		}
+	} else {
+		// Account sections of the rules file apply without an email too
+		email.AccountID = accountID
+		if email.AccountID == "" {
+			email.AccountID = es.defaultAccountID()
+		}
+		email.From, _ = args["from"].(string)
+		email.Subject, _ = args["subject"].(string)
+		email.Body, _ = args["body"].(string)
+		if to, ok := args["to"].(string); ok && to != "" {
+			email.To = []string{to}
+		}
+		for _, name := range stringSlice(args["attachments"]) {
+			contentType, _, _ := mime.ParseMediaType(mime.TypeByExtension(path.Ext(name)))
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+			email.Attachments = append(email.Attachments, mail.Attachment{Filename: name, ContentType: contentType})
+		}
+		if email.From == "" && email.Subject == "" && email.Body == "" && len(email.Attachments) == 0 {
+			return nil, invalidArgument("missing required parameters: id, or at least one of from, subject, body and attachments")
+		}
+	}
+
+	path := rulesPath()
+	rules, err := config.LoadRules(path)
+	if err != nil {
+		return nil, err
+	}
+
+	report := es.classifier.TestRules(email, rules)
+
+	reportJSON, _ := json.MarshalIndent(report, "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Rules from %s: %d matched, %d near misses, result %s (%.2f):\n\n%s",
+				path, len(report.Matched), len(report.NearMisses), report.Result.Category, report.Result.Confidence, string(reportJSON)),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleGenerateReply(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+
+	var email ai.Email
+	if id, ok := args["id"].(float64); ok {
+		config, err := es.getConfig(accountID)
+		if err != nil {
+			return nil, err
+		}
+
+		msg, err := es.getEmailBody(ctx, config.ID, folder, uint32(id))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get email: %w", err)
+		}
+		email = ai.Email{
+			AccountID:   config.ID,
+			Folder:      folder,
+			UID:         msg.ID,
+			From:        msg.From,
+			To:          msg.To,
+			Subject:     msg.Subject,
+			Body:        msg.Body,
+			Date:        msg.Date,
+			Automated:   msg.Automated,
+			Attachments: msg.Attachments,
+		}
+	} else {
+		// Account sections of the rules file apply without an email too
+		email.AccountID = accountID
+		if email.AccountID == "" {
+			email.AccountID = es.defaultAccountID()
+		}
+		email.From, _ = args["from"].(string)
+		email.Subject, _ = args["subject"].(string)
+		email.Body, _ = args["body"].(string)
+		if email.From == "" || email.Body == "" {
+			return nil, invalidArgument("missing required parameters: id, or from and body")
+		}
+	}
+
+	var opts ai.ReplyOptions
+	opts.Tone, _ = args["tone"].(string)
+	opts.Length, _ = args["length"].(string)
+	opts.Intent, _ = args["intent"].(string)
+	opts.Instructions, _ = args["instructions"].(string)
+
+	reply := es.replies.GenerateReply(context.Background(), email, opts)
+
+	replyJSON, _ := json.MarshalIndent(reply, "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Suggested reply (pass to create_draft or send_email to use it):\n\n%s", string(replyJSON)),
+		}},
+	}, nil
+}
+
+func summaryResult(title string, summary *ai.Summary) ToolResult {
+	details, _ := json.MarshalIndent(summary, "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("%s\n\n%s\n\n%s", title, summary.Text, string(details)),
+		}},
+	}
+}
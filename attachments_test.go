@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const invoiceMessage = `From: billing@shop.com
+To: username
+Subject: Your invoice
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary=b1
+
+--b1
+Content-Type: text/plain; charset=utf-8
+
+Please find the invoice attached.
+--b1
+Content-Type: application/pdf; name="invoice.pdf"
+Content-Disposition: attachment; filename="invoice.pdf"
+Content-Transfer-Encoding: base64
+
+JVBERi0xLjQKJcOkw7zDtsOf
+--b1--
+`
+
+func TestAttachments(t *testing.T) {
+	es := newTestServer(t)
+	ctx := context.Background()
+	uid := appendMessage(t, es, "INBOX", invoiceMessage)
+
+	attachments, err := es.listAttachments(ctx, "work", "INBOX", uid)
+	if err != nil {
+		t.Fatalf("listAttachments: %v", err)
+	}
+	if len(attachments) != 1 {
+		t.Fatalf("listAttachments = %+v, want one attachment", attachments)
+	}
+	att := attachments[0]
+	if att.Part != "2" || att.Filename != "invoice.pdf" || att.ContentType != "application/pdf" {
+		t.Errorf("attachment = %+v, want part 2, invoice.pdf, application/pdf", att)
+	}
+
+	info, data, err := es.downloadAttachment(ctx, "work", "INBOX", uid, "2")
+	if err != nil {
+		t.Fatalf("downloadAttachment: %v", err)
+	}
+	if want := "%PDF-1.4\n%äüöß"; string(data) != want {
+		t.Errorf("downloadAttachment data = %q, want %q", data, want)
+	}
+
+	if _, _, err := es.downloadAttachment(ctx, "work", "INBOX", uid, "1"); err == nil {
+		t.Error("downloadAttachment of the message body succeeded")
+	}
+
+	if attachments, err := es.listAttachments(ctx, "work", "INBOX", 6); err != nil || len(attachments) != 0 {
+		t.Errorf("listAttachments of a plain message = %+v, %v; want none", attachments, err)
+	}
+
+	es.downloadsDir = t.TempDir()
+	info.Filename = "../../etc/invoice.pdf"
+	path, err := es.saveAttachment(uid, info, data)
+	if err != nil {
+		t.Fatalf("saveAttachment: %v", err)
+	}
+	if want := filepath.Join(es.downloadsDir, fmt.Sprintf("%d-invoice.pdf", uid)); path != want {
+		t.Errorf("saveAttachment path = %s, want %s", path, want)
+	}
+	if saved, err := os.ReadFile(path); err != nil || string(saved) != string(data) {
+		t.Errorf("saved attachment = %q, %v", saved, err)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"email-mcp-server/mail"
+	"email-mcp-server/storage"
+)
+
+// Every call of a mutating tool (sends, deletes, moves, flag changes, bulk
+// actions, account and folder changes) goes to the audit_log table with the
+// client that made it, its arguments and its outcome. What the server does
+// on its own (action rules, the autoresponder, scheduled sends and snoozed
+// emails coming back) is recorded too, under the name of the job. Entries
+// older than AUDIT_RETENTION_DAYS are pruned.
+
+const (
+	// auditValueLimit is the longest argument value kept, so bodies and
+	// attachment contents do not fill the log
+	auditValueLimit = 500
+	// auditResultLimit is the longest result summary kept
+	auditResultLimit = 300
+)
+
+// auditRedacted are the arguments never written to the log
+var auditRedacted = map[string]bool{"password": true}
+
+// recordAudit completes entry with the outcome of an operation that started
+// at start and adds it to the audit log. Failing to record is logged, never
+// returned: the operation already happened.
+func (es *EmailServer) recordAudit(entry *storage.AuditEntry, args map[string]interface{}, start time.Time, err error) {
+	if es.db == nil {
+		return
+	}
+	entry.Time = start.UTC()
+	entry.DurationMs = time.Since(start).Milliseconds()
+	entry.Success = err == nil
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	entry.Result = truncate(entry.Result, auditResultLimit)
+	if len(args) > 0 {
+		entry.Arguments, _ = json.Marshal(auditValue("", args))
+	}
+	if err := es.db.RecordAudit(entry); err != nil {
+		log.Printf("Audit of %s by %s not recorded: %v", entry.Operation, entry.Actor, err)
+	}
+	es.pruneAudit(false)
+}
+
+// auditToolCall records a call of a mutating tool
+func (es *EmailServer) auditToolCall(params ToolCallParams, result interface{}, start time.Time, err error) {
+	entry := &storage.AuditEntry{
+		Actor:     es.clientActor(),
+		Operation: params.Name,
+		AccountID: es.auditAccount(params.Arguments),
+	}
+	if r, ok := result.(ToolResult); ok && len(r.Content) > 0 {
+		entry.Result, _, _ = strings.Cut(r.Content[0].Text, "\n")
+	}
+	es.recordAudit(entry, params.Arguments, start, err)
+}
+
+// auditAccount is the account a tool call acted on: the one it names, or
+// the default account
+func (es *EmailServer) auditAccount(args map[string]interface{}) string {
+	if account, _ := args["account"].(string); account != "" {
+		return account
+	}
+	if id, _ := args["id"].(string); id != "" {
+		return id
+	}
+	es.configsMu.RLock()
+	defer es.configsMu.RUnlock()
+	return es.defaultAccount
+}
+
+// clientActor names the MCP client as sent in initialize
+func (es *EmailServer) clientActor() string {
+	es.clientMu.Lock()
+	defer es.clientMu.Unlock()
+	if es.client == "" {
+		return "client"
+	}
+	return "client:" + es.client
+}
+
+// setClient remembers the clientInfo of an initialize request
+func (es *EmailServer) setClient(params map[string]interface{}) {
+	info, _ := params["clientInfo"].(map[string]interface{})
+	name, _ := info["name"].(string)
+	if version, _ := info["version"].(string); name != "" && version != "" {
+		name += " " + version
+	}
+	es.clientMu.Lock()
+	es.client = name
+	es.clientMu.Unlock()
+}
+
+// auditValue copies v with redacted and shortened values
+func auditValue(key string, v interface{}) interface{} {
+	if auditRedacted[key] {
+		return "[redacted]"
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, value := range v {
+			out[k] = auditValue(k, value)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, value := range v {
+			out[i] = auditValue(key, value)
+		}
+		return out
+	case string:
+		if short := truncate(v, auditValueLimit); short != v {
+			return fmt.Sprintf("%s… (%d bytes)", short, len(v))
+		}
+	}
+	return v
+}
+
+// messageAuditArgs describes an outgoing message in the audit log
+func messageAuditArgs(msg *mail.OutgoingMessage) map[string]interface{} {
+	args := map[string]interface{}{
+		"to":         strings.Join(msg.To, ", "),
+		"subject":    msg.Subject,
+		"message_id": msg.MessageID,
+	}
+	if len(msg.Cc) > 0 {
+		args["cc"] = strings.Join(msg.Cc, ", ")
+	}
+	if len(msg.Bcc) > 0 {
+		args["bcc"] = strings.Join(msg.Bcc, ", ")
+	}
+	return args
+}
+
+// sendScheduled is the scheduler's Sender: a scheduled email leaves
+// without a tool call, so it is audited here
+func (es *EmailServer) sendScheduled(ctx context.Context, accountID string, msg *mail.OutgoingMessage) error {
+	start := time.Now()
+	err := es.sendEmail(ctx, accountID, msg)
+	es.recordAudit(&storage.AuditEntry{Actor: "scheduler", Operation: "send_email", AccountID: accountID},
+		messageAuditArgs(msg), start, err)
+	return err
+}
+
+// wakeScheduled is the scheduler's Waker, audited like sendScheduled
+func (es *EmailServer) wakeScheduled(ctx context.Context, email *storage.SnoozedEmail) error {
+	start := time.Now()
+	err := es.wakeSnoozed(ctx, email)
+	es.recordAudit(&storage.AuditEntry{Actor: "scheduler", Operation: "wake_snoozed", AccountID: email.AccountID},
+		map[string]interface{}{"folder": email.Folder, "snooze_folder": email.SnoozeFolder, "message_id": email.MessageID, "subject": email.Subject},
+		start, err)
+	return err
+}
+
+// pruneAudit deletes the entries older than the retention, at most once a
+// day unless force is set
+func (es *EmailServer) pruneAudit(force bool) {
+	if es.db == nil || es.auditRetention <= 0 {
+		return
+	}
+	es.auditMu.Lock()
+	if !force && time.Since(es.auditPruned) < 24*time.Hour {
+		es.auditMu.Unlock()
+		return
+	}
+	es.auditPruned = time.Now()
+	es.auditMu.Unlock()
+
+	n, err := es.db.PruneAudit(time.Now().Add(-es.auditRetention))
+	if err != nil {
+		log.Printf("Audit log not pruned: %v", err)
+	} else if n > 0 {
+		log.Printf("Pruned %d audit log entries older than %v", n, es.auditRetention)
+	}
+}
+
+func (es *EmailServer) handleGetAuditLog(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("the audit log is not available: %w", errNoDatabase)
+	}
+
+	var filter storage.AuditFilter
+	if accountID, _ := args["account"].(string); accountID != "" {
+		config, err := es.getConfig(accountID)
+		if err != nil {
+			return nil, err
+		}
+		filter.AccountID = config.ID
+	}
+	filter.Operation, _ = args["operation"].(string)
+	filter.Actor, _ = args["actor"].(string)
+	filter.ErrorsOnly, _ = args["errors_only"].(bool)
+	if value, _ := args["since"].(string); value != "" {
+		since, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			return nil, invalidArgument("invalid since date %q, expected YYYY-MM-DD", value)
+		}
+		filter.Since = since
+	}
+	filter.Limit = 50
+	if l, ok := args["limit"].(float64); ok && l >= 1 {
+		filter.Limit = int(l)
+	}
+
+	entries, err := es.db.ListAudit(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	entriesJSON, _ := json.MarshalIndent(entries, "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("%d audit log entries, newest first:\n%s", len(entries), string(entriesJSON)),
+		}},
+	}, nil
+}
+
+// truncate cuts s to at most n characters
+func truncate(s string, n int) string {
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n])
+	}
+	return s
+}
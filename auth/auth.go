@@ -0,0 +1,162 @@
+// Package auth implements the SASL mechanisms used to log in to IMAP and
+// SMTP servers that reject PLAIN: LOGIN for legacy relays, CRAM-MD5 and
+// NTLM for corporate Exchange servers.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// Mechanisms. Auto tries those the server advertises, in the order of
+// the caller's preference.
+const (
+	Auto    = "AUTO"
+	Plain   = "PLAIN"
+	Login   = "LOGIN"
+	CRAMMD5 = "CRAM-MD5"
+	NTLM    = "NTLM"
+)
+
+// Mechanisms lists the mechanisms New supports
+var Mechanisms = []string{Plain, Login, CRAMMD5, NTLM}
+
+// Client runs one side of a SASL exchange. It has the method set of the
+// go-sasl Client used by go-imap's Authenticate.
+type Client interface {
+	// Start returns the mechanism name and the initial response, nil when
+	// the mechanism has none
+	Start() (mech string, ir []byte, err error)
+	// Next answers a server challenge
+	Next(challenge []byte) (response []byte, err error)
+}
+
+// Normalize returns mech in upper case, "" as Auto, or an error when it is
+// not a supported mechanism
+func Normalize(mech string) (string, error) {
+	mech = strings.ToUpper(strings.TrimSpace(mech))
+	if mech == "" || mech == Auto {
+		return Auto, nil
+	}
+	for _, supported := range Mechanisms {
+		if mech == supported {
+			return mech, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported authentication mechanism %q (use auto, %s)", mech, strings.Join(Mechanisms, ", "))
+}
+
+// New returns a client logging in with mech
+func New(mech, username, password string) (Client, error) {
+	switch strings.ToUpper(mech) {
+	case Plain:
+		return &plainClient{username, password}, nil
+	case Login:
+		return &loginClient{username, password}, nil
+	case CRAMMD5:
+		return &cramMD5Client{username, password}, nil
+	case NTLM:
+		return NewNTLMClient(username, password), nil
+	}
+	return nil, fmt.Errorf("unsupported authentication mechanism %q", mech)
+}
+
+// ClearText reports whether mech sends the password itself rather than a
+// proof of knowing it
+func ClearText(mech string) bool {
+	mech = strings.ToUpper(mech)
+	return mech == Plain || mech == Login
+}
+
+// plainClient implements PLAIN (RFC 4616)
+type plainClient struct {
+	username, password string
+}
+
+func (c *plainClient) Start() (string, []byte, error) {
+	return Plain, []byte("\x00" + c.username + "\x00" + c.password), nil
+}
+
+func (c *plainClient) Next(challenge []byte) ([]byte, error) {
+	return nil, errors.New("unexpected server challenge")
+}
+
+// loginClient implements the obsolete LOGIN mechanism still required by
+// some SMTP relays. The username is not sent as an initial response because
+// old relays only accept it after their "Username:" prompt.
+type loginClient struct {
+	username, password string
+}
+
+func (c *loginClient) Start() (string, []byte, error) {
+	return Login, nil, nil
+}
+
+func (c *loginClient) Next(challenge []byte) ([]byte, error) {
+	prompt := strings.ToLower(string(challenge))
+	switch {
+	case strings.Contains(prompt, "user"):
+		return []byte(c.username), nil
+	case strings.Contains(prompt, "pass"):
+		return []byte(c.password), nil
+	}
+	return nil, fmt.Errorf("unexpected LOGIN prompt %q", challenge)
+}
+
+// cramMD5Client implements CRAM-MD5 (RFC 2195)
+type cramMD5Client struct {
+	username, secret string
+}
+
+func (c *cramMD5Client) Start() (string, []byte, error) {
+	return CRAMMD5, nil, nil
+}
+
+func (c *cramMD5Client) Next(challenge []byte) ([]byte, error) {
+	mac := hmac.New(md5.New, []byte(c.secret))
+	mac.Write(challenge)
+	return []byte(c.username + " " + hex.EncodeToString(mac.Sum(nil))), nil
+}
+
+// SMTP adapts c to net/smtp. Like smtp.PlainAuth, mechanisms sending the
+// password in clear text are refused on unencrypted connections to hosts
+// other than localhost.
+func SMTP(c Client) smtp.Auth {
+	return &smtpAuth{c}
+}
+
+type smtpAuth struct {
+	client Client
+}
+
+func (a *smtpAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	mech, ir, err := a.client.Start()
+	if err != nil {
+		return "", nil, err
+	}
+	if ClearText(mech) && !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, fmt.Errorf("refusing %s authentication over an unencrypted connection", mech)
+	}
+	return mech, ir, nil
+}
+
+func (a *smtpAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	return a.client.Next(fromServer)
+}
+
+func isLocalhost(name string) bool {
+	if name == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(name)
+	return ip != nil && ip.IsLoopback()
+}
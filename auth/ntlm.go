@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// NTLM negotiate flags (MS-NLMP 2.2.2.5)
+const (
+	ntlmNegotiateUnicode        = 0x00000001
+	ntlmRequestTarget           = 0x00000004
+	ntlmNegotiateNTLM           = 0x00000200
+	ntlmNegotiateAlwaysSign     = 0x00008000
+	ntlmNegotiateExtendedSecure = 0x00080000
+	ntlmNegotiateTargetInfo     = 0x00800000
+	ntlmNegotiate128            = 0x20000000
+	ntlmNegotiate56             = 0x80000000
+)
+
+const (
+	ntlmSignature   = "NTLMSSP\x00"
+	avEOL           = 0 // MsvAvEOL, ends the target info
+	avTimestamp     = 7 // MsvAvTimestamp, the server's FILETIME
+	filetimeToEpoch = 116444736000000000
+)
+
+// ntlmClient authenticates with NTLMv2, the version Exchange servers
+// accept. The username may be given as DOMAIN\user; otherwise the domain
+// is left to the server, which also accepts user@domain.
+type ntlmClient struct {
+	user, domain, password string
+}
+
+// NewNTLMClient returns an NTLMv2 client for username and password
+func NewNTLMClient(username, password string) Client {
+	c := &ntlmClient{user: username, password: password}
+	if domain, user, ok := strings.Cut(username, `\`); ok {
+		c.domain, c.user = domain, user
+	}
+	return c
+}
+
+// Start sends the NEGOTIATE message as the initial response
+func (c *ntlmClient) Start() (string, []byte, error) {
+	msg := make([]byte, 32)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 1)
+	binary.LittleEndian.PutUint32(msg[12:], ntlmNegotiateUnicode|ntlmRequestTarget|ntlmNegotiateNTLM|
+		ntlmNegotiateAlwaysSign|ntlmNegotiateExtendedSecure|ntlmNegotiateTargetInfo|ntlmNegotiate128|ntlmNegotiate56)
+	// Empty domain and workstation fields
+	return NTLM, msg, nil
+}
+
+// Next answers the server's CHALLENGE message with an AUTHENTICATE message
+func (c *ntlmClient) Next(challenge []byte) ([]byte, error) {
+	flags, serverChallenge, targetInfo, err := parseNTLMChallenge(challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	clientChallenge := make([]byte, 8)
+	if _, err := rand.Read(clientChallenge); err != nil {
+		return nil, err
+	}
+	timestamp, serverTime := ntlmTimestamp(targetInfo)
+	if !serverTime {
+		timestamp = uint64(time.Now().UnixNano()/100) + filetimeToEpoch
+	}
+
+	key := ntowfv2(c.password, c.user, c.domain)
+
+	// NTLMv2 client challenge blob (MS-NLMP 2.2.2.7)
+	var blob bytes.Buffer
+	blob.Write([]byte{1, 1, 0, 0, 0, 0, 0, 0})
+	binary.Write(&blob, binary.LittleEndian, timestamp)
+	blob.Write(clientChallenge)
+	blob.Write([]byte{0, 0, 0, 0})
+	blob.Write(targetInfo)
+	blob.Write([]byte{0, 0, 0, 0})
+
+	proof := hmacMD5(key, serverChallenge, blob.Bytes())
+	ntResponse := append(proof, blob.Bytes()...)
+	// With a server timestamp the LMv2 response must be zeros
+	lmResponse := make([]byte, 24)
+	if !serverTime {
+		lmResponse = append(hmacMD5(key, serverChallenge, clientChallenge), clientChallenge...)
+	}
+
+	encode := func(s string) []byte { return []byte(s) }
+	if flags&ntlmNegotiateUnicode != 0 {
+		encode = utf16le
+	}
+	fields := [][]byte{lmResponse, ntResponse, encode(c.domain), encode(c.user), nil, nil}
+
+	// Header: signature, type, six security buffers and the flags
+	const headerSize = 64
+	msg := make([]byte, headerSize)
+	copy(msg, ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:], 3)
+	offset := headerSize
+	for i, field := range fields {
+		buffer := msg[12+8*i:]
+		binary.LittleEndian.PutUint16(buffer, uint16(len(field)))
+		binary.LittleEndian.PutUint16(buffer[2:], uint16(len(field)))
+		binary.LittleEndian.PutUint32(buffer[4:], uint32(offset))
+		offset += len(field)
+	}
+	binary.LittleEndian.PutUint32(msg[60:], flags)
+	for _, field := range fields {
+		msg = append(msg, field...)
+	}
+	return msg, nil
+}
+
+// parseNTLMChallenge reads the flags, server challenge and target info of a
+// CHALLENGE message (MS-NLMP 2.2.1.2)
+func parseNTLMChallenge(msg []byte) (flags uint32, challenge, targetInfo []byte, err error) {
+	if len(msg) < 32 || string(msg[:8]) != ntlmSignature || binary.LittleEndian.Uint32(msg[8:]) != 2 {
+		return 0, nil, nil, errors.New("invalid NTLM challenge")
+	}
+	flags = binary.LittleEndian.Uint32(msg[20:])
+	challenge = msg[24:32]
+	if len(msg) >= 48 {
+		length := int(binary.LittleEndian.Uint16(msg[40:]))
+		offset := int(binary.LittleEndian.Uint32(msg[44:]))
+		if offset+length > len(msg) {
+			return 0, nil, nil, fmt.Errorf("invalid NTLM challenge: target info out of range")
+		}
+		targetInfo = msg[offset : offset+length]
+	}
+	return flags, challenge, targetInfo, nil
+}
+
+// ntlmTimestamp returns the MsvAvTimestamp of the target info, if any
+func ntlmTimestamp(targetInfo []byte) (uint64, bool) {
+	for len(targetInfo) >= 4 {
+		id := binary.LittleEndian.Uint16(targetInfo)
+		length := int(binary.LittleEndian.Uint16(targetInfo[2:]))
+		if id == avEOL || 4+length > len(targetInfo) {
+			break
+		}
+		if id == avTimestamp && length == 8 {
+			return binary.LittleEndian.Uint64(targetInfo[4:]), true
+		}
+		targetInfo = targetInfo[4+length:]
+	}
+	return 0, false
+}
+
+// ntowfv2 is the NTLMv2 response key: the MD4 of the password keying an
+// HMAC of the upper-case user and the domain
+func ntowfv2(password, user, domain string) []byte {
+	hash := md4(utf16le(password))
+	return hmacMD5(hash[:], utf16le(strings.ToUpper(user)+domain))
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(md5.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+func utf16le(s string) []byte {
+	codes := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(codes))
+	for i, code := range codes {
+		binary.LittleEndian.PutUint16(b[2*i:], code)
+	}
+	return b
+}
+
+// md4 computes an MD4 digest (RFC 1320). The standard library does not
+// provide it, and NTLM needs it for the password hash only.
+func md4(data []byte) [16]byte {
+	a, b, c, d := uint32(0x67452301), uint32(0xefcdab89), uint32(0x98badcfe), uint32(0x10325476)
+
+	msg := append(append([]byte(nil), data...), 0x80)
+	for len(msg)%64 != 56 {
+		msg = append(msg, 0)
+	}
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(len(data))*8)
+
+	f := func(x, y, z uint32) uint32 { return x&y | ^x&z }
+	g := func(x, y, z uint32) uint32 { return x&y | x&z | y&z }
+	h := func(x, y, z uint32) uint32 { return x ^ y ^ z }
+	rotl := bits.RotateLeft32
+
+	var x [16]uint32
+	for block := msg; len(block) > 0; block = block[64:] {
+		for i := range x {
+			x[i] = binary.LittleEndian.Uint32(block[4*i:])
+		}
+		aa, bb, cc, dd := a, b, c, d
+
+		for _, k := range []int{0, 4, 8, 12} {
+			a = rotl(a+f(b, c, d)+x[k], 3)
+			d = rotl(d+f(a, b, c)+x[k+1], 7)
+			c = rotl(c+f(d, a, b)+x[k+2], 11)
+			b = rotl(b+f(c, d, a)+x[k+3], 19)
+		}
+		for _, k := range []int{0, 1, 2, 3} {
+			a = rotl(a+g(b, c, d)+x[k]+0x5a827999, 3)
+			d = rotl(d+g(a, b, c)+x[k+4]+0x5a827999, 5)
+			c = rotl(c+g(d, a, b)+x[k+8]+0x5a827999, 9)
+			b = rotl(b+g(c, d, a)+x[k+12]+0x5a827999, 13)
+		}
+		for _, k := range []int{0, 2, 1, 3} {
+			a = rotl(a+h(b, c, d)+x[k]+0x6ed9eba1, 3)
+			d = rotl(d+h(a, b, c)+x[k+8]+0x6ed9eba1, 9)
+			c = rotl(c+h(d, a, b)+x[k+4]+0x6ed9eba1, 11)
+			b = rotl(b+h(c, d, a)+x[k+12]+0x6ed9eba1, 15)
+		}
+
+		a, b, c, d = a+aa, b+bb, c+cc, d+dd
+	}
+
+	var sum [16]byte
+	binary.LittleEndian.PutUint32(sum[0:], a)
+	binary.LittleEndian.PutUint32(sum[4:], b)
+	binary.LittleEndian.PutUint32(sum[8:], c)
+	binary.LittleEndian.PutUint32(sum[12:], d)
+	return sum
+}
@@ -0,0 +1,365 @@
+// Package autoconfig finds the IMAP and SMTP servers of an email address:
+// from the presets of well-known providers, the Thunderbird autoconfig
+// files of the domain and its ISP database, RFC 6186 SRV records, and
+// finally the MX records of domains hosted by a known provider.
+package autoconfig
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Connection security of a server
+const (
+	SecurityTLS      = "tls"      // TLS from the start, port 993 or 465
+	SecurityStartTLS = "starttls" // Upgraded with STARTTLS
+)
+
+// Where settings were found
+const (
+	SourcePreset     = "preset"
+	SourceAutoconfig = "autoconfig"
+	SourceSRV        = "srv"
+	SourceMX         = "mx"
+)
+
+// Settings are the servers of an email account. Implicit TLS is only used
+// on ports 993 and 465, so settings with another port always use STARTTLS.
+type Settings struct {
+	Provider     string `json:"provider,omitempty"`
+	IMAPHost     string `json:"imap_host"`
+	IMAPPort     int    `json:"imap_port"`
+	IMAPSecurity string `json:"imap_security"`
+	SMTPHost     string `json:"smtp_host"`
+	SMTPPort     int    `json:"smtp_port"`
+	SMTPSecurity string `json:"smtp_security"`
+	Source       string `json:"source"`
+	Note         string `json:"note,omitempty"` // How to get a password that works
+}
+
+// Preset holds the settings of a well-known provider
+type Preset struct {
+	Settings
+	Domains []string // Address domains of the provider
+	MX      []string // Suffixes of the mail exchangers of domains it hosts
+}
+
+// Presets are the well-known providers
+var Presets = []Preset{
+	{
+		Settings: Settings{Provider: "Gmail", IMAPHost: "imap.gmail.com", IMAPPort: 993, IMAPSecurity: SecurityTLS,
+			SMTPHost: "smtp.gmail.com", SMTPPort: 587, SMTPSecurity: SecurityStartTLS,
+			Note: "Gmail needs an app password (https://myaccount.google.com/apppasswords), which requires 2-step verification"},
+		Domains: []string{"gmail.com", "googlemail.com"},
+		MX:      []string{".google.com", ".googlemail.com"},
+	},
+	{
+		Settings: Settings{Provider: "Outlook", IMAPHost: "outlook.office365.com", IMAPPort: 993, IMAPSecurity: SecurityTLS,
+			SMTPHost: "smtp-mail.outlook.com", SMTPPort: 587, SMTPSecurity: SecurityStartTLS,
+			Note: "Most Outlook.com and Microsoft 365 accounts no longer accept passwords over IMAP: if the login fails, set the account's Provider to graph and run email-mcp-server graph-login"},
+		Domains: []string{"outlook.com", "hotmail.com", "live.com", "msn.com"},
+		MX:      []string{".protection.outlook.com"},
+	},
+	{
+		Settings: Settings{Provider: "Yahoo", IMAPHost: "imap.mail.yahoo.com", IMAPPort: 993, IMAPSecurity: SecurityTLS,
+			SMTPHost: "smtp.mail.yahoo.com", SMTPPort: 465, SMTPSecurity: SecurityTLS,
+			Note: "Yahoo needs an app password generated in Account security"},
+		Domains: []string{"yahoo.com", "ymail.com", "rocketmail.com"},
+		MX:      []string{".yahoodns.net"},
+	},
+	{
+		Settings: Settings{Provider: "iCloud", IMAPHost: "imap.mail.me.com", IMAPPort: 993, IMAPSecurity: SecurityTLS,
+			SMTPHost: "smtp.mail.me.com", SMTPPort: 587, SMTPSecurity: SecurityStartTLS,
+			Note: "iCloud needs an app-specific password from https://account.apple.com, under Sign-In and Security"},
+		Domains: []string{"icloud.com", "me.com", "mac.com"},
+		MX:      []string{".mail.icloud.com"},
+	},
+	{
+		Settings: Settings{Provider: "Fastmail", IMAPHost: "imap.fastmail.com", IMAPPort: 993, IMAPSecurity: SecurityTLS,
+			SMTPHost: "smtp.fastmail.com", SMTPPort: 465, SMTPSecurity: SecurityTLS,
+			Note: "Fastmail needs an app password from Settings > Privacy & Security > Integrations"},
+		Domains: []string{"fastmail.com", "fastmail.fm"},
+		MX:      []string{".messagingengine.com"},
+	},
+	{
+		Settings: Settings{Provider: "Zoho", IMAPHost: "imap.zoho.com", IMAPPort: 993, IMAPSecurity: SecurityTLS,
+			SMTPHost: "smtp.zoho.com", SMTPPort: 465, SMTPSecurity: SecurityTLS,
+			Note: "Zoho needs IMAP access turned on in its mail settings, and an app password when two-factor authentication is on"},
+		Domains: []string{"zoho.com", "zohomail.com"},
+		MX:      []string{".zoho.com"},
+	},
+}
+
+// PresetFor returns the preset of the domain of an address, nil when the
+// provider is not well known
+func PresetFor(address string) *Preset {
+	domain := domainOf(address)
+	for i := range Presets {
+		if slices.Contains(Presets[i].Domains, domain) {
+			return &Presets[i]
+		}
+	}
+	return nil
+}
+
+// DefaultURLs are where autoconfig files are looked for, in order: those
+// the domain publishes, then the Thunderbird ISP database. {domain} and
+// {email} are replaced by the domain and the address.
+var DefaultURLs = []string{
+	"https://autoconfig.{domain}/mail/config-v1.1.xml?emailaddress={email}",
+	"https://{domain}/.well-known/autoconfig/mail/config-v1.1.xml?emailaddress={email}",
+	"https://autoconfig.thunderbird.net/v1.1/{domain}",
+}
+
+// Discoverer finds the settings of addresses. The zero value uses the
+// system resolver and DefaultURLs.
+type Discoverer struct {
+	HTTPClient *http.Client // A client with a 10 second timeout when nil
+	URLs       []string     // DefaultURLs when nil
+	Resolver   Resolver     // net.DefaultResolver when nil
+}
+
+// Resolver looks up the DNS records discovery uses
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+// Discover returns the settings of an address, trying a preset, the
+// autoconfig files, SRV records and then the MX records in turn
+func (d *Discoverer) Discover(ctx context.Context, address string) (*Settings, error) {
+	domain := domainOf(address)
+	if domain == "" {
+		return nil, fmt.Errorf("%q is not an email address", address)
+	}
+	if preset := PresetFor(address); preset != nil {
+		settings := preset.Settings
+		settings.Source = SourcePreset
+		return &settings, nil
+	}
+
+	var tried []string
+	for _, pattern := range d.urls() {
+		u := strings.NewReplacer("{domain}", url.PathEscape(domain), "{email}", url.QueryEscape(address)).Replace(pattern)
+		settings, err := d.fetchAutoconfig(ctx, u, address)
+		if err == nil {
+			settings.Source = SourceAutoconfig
+			return settings, nil
+		}
+		tried = append(tried, err.Error())
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+
+	settings, err := d.lookupSRV(ctx, domain)
+	if err == nil {
+		settings.Source = SourceSRV
+		return settings, nil
+	}
+	tried = append(tried, err.Error())
+
+	preset, err := d.lookupMX(ctx, domain)
+	if err == nil {
+		settings := preset.Settings
+		settings.Source = SourceMX
+		return &settings, nil
+	}
+	tried = append(tried, err.Error())
+	return nil, fmt.Errorf("no server settings found for %s (%s)", domain, strings.Join(tried, "; "))
+}
+
+func (d *Discoverer) urls() []string {
+	if d.URLs != nil {
+		return d.URLs
+	}
+	return DefaultURLs
+}
+
+func (d *Discoverer) resolver() Resolver {
+	if d.Resolver != nil {
+		return d.Resolver
+	}
+	return net.DefaultResolver
+}
+
+// clientConfig is the part of a Thunderbird autoconfig file that is used
+type clientConfig struct {
+	Provider struct {
+		DisplayName string         `xml:"displayName"`
+		Incoming    []serverConfig `xml:"incomingServer"`
+		Outgoing    []serverConfig `xml:"outgoingServer"`
+	} `xml:"emailProvider"`
+}
+
+type serverConfig struct {
+	Type       string `xml:"type,attr"`
+	Hostname   string `xml:"hostname"`
+	Port       int    `xml:"port"`
+	SocketType string `xml:"socketType"`
+}
+
+// fetchAutoconfig reads the autoconfig file at u
+func (d *Discoverer) fetchAutoconfig(ctx context.Context, u, address string) (*Settings, error) {
+	client := d.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: HTTP %d", u, resp.StatusCode)
+	}
+
+	var config clientConfig
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&config); err != nil {
+		return nil, fmt.Errorf("%s: %w", u, err)
+	}
+	settings, err := config.settings(address)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", u, err)
+	}
+	return settings, nil
+}
+
+// settings picks the first IMAP and SMTP servers of the file that use TLS
+// in a way this server supports, preferring them in the file's order
+func (c *clientConfig) settings(address string) (*Settings, error) {
+	local, domain, _ := strings.Cut(address, "@")
+	placeholders := strings.NewReplacer("%EMAILADDRESS%", address, "%EMAILLOCALPART%", local, "%EMAILDOMAIN%", domain)
+
+	settings := &Settings{Provider: c.Provider.DisplayName}
+	for _, server := range c.Provider.Incoming {
+		security := security(server.SocketType, server.Port, 993)
+		if server.Type == "imap" && server.Hostname != "" && security != "" {
+			settings.IMAPHost, settings.IMAPPort, settings.IMAPSecurity = placeholders.Replace(server.Hostname), server.Port, security
+			break
+		}
+	}
+	for _, server := range c.Provider.Outgoing {
+		security := security(server.SocketType, server.Port, 465)
+		if server.Type == "smtp" && server.Hostname != "" && security != "" {
+			settings.SMTPHost, settings.SMTPPort, settings.SMTPSecurity = placeholders.Replace(server.Hostname), server.Port, security
+			break
+		}
+	}
+	if settings.IMAPHost == "" || settings.SMTPHost == "" {
+		return nil, fmt.Errorf("no encrypted IMAP and SMTP servers")
+	}
+	return settings, nil
+}
+
+// security maps an autoconfig socket type to the security of a server whose
+// implicit TLS port is tlsPort, "" when it cannot be used: unencrypted, or
+// implicit TLS on another port
+func security(socketType string, port, tlsPort int) string {
+	switch strings.ToUpper(socketType) {
+	case "SSL":
+		if port == tlsPort {
+			return SecurityTLS
+		}
+	case "STARTTLS":
+		if port > 0 && port != tlsPort {
+			return SecurityStartTLS
+		}
+	}
+	return ""
+}
+
+// lookupSRV reads the RFC 6186 records of a domain, and those of RFC 8314
+// for implicit TLS, preferring implicit TLS
+func (d *Discoverer) lookupSRV(ctx context.Context, domain string) (*Settings, error) {
+	settings := &Settings{}
+	var err error
+	if settings.IMAPHost, settings.IMAPPort, settings.IMAPSecurity, err = d.srvServer(ctx, domain,
+		[]srvService{{"imaps", 993, SecurityTLS}, {"imap", 0, SecurityStartTLS}}); err != nil {
+		return nil, err
+	}
+	if settings.SMTPHost, settings.SMTPPort, settings.SMTPSecurity, err = d.srvServer(ctx, domain,
+		[]srvService{{"submissions", 465, SecurityTLS}, {"submission", 0, SecurityStartTLS}}); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// srvService is an SRV service name, with the only port its security works
+// on, 0 for any
+type srvService struct {
+	name     string
+	port     int
+	security string
+}
+
+func (d *Discoverer) srvServer(ctx context.Context, domain string, services []srvService) (string, int, string, error) {
+	for _, service := range services {
+		_, records, err := d.resolver().LookupSRV(ctx, service.name, "tcp", domain)
+		if err != nil {
+			continue
+		}
+		for _, record := range records {
+			host := strings.TrimSuffix(record.Target, ".")
+			port := int(record.Port)
+			// A target of "." means the service is not offered
+			if host == "" || (service.port != 0 && port != service.port) ||
+				(service.security == SecurityStartTLS && (port == 993 || port == 465)) {
+				continue
+			}
+			return host, port, service.security, nil
+		}
+	}
+	names := make([]string, len(services))
+	for i, service := range services {
+		names[i] = "_" + service.name + "._tcp." + domain
+	}
+	return "", 0, "", fmt.Errorf("no SRV record %s", strings.Join(names, " or "))
+}
+
+// lookupMX returns the preset of the provider hosting the mail of a domain
+func (d *Discoverer) lookupMX(ctx context.Context, domain string) (*Preset, error) {
+	records, err := d.resolver().LookupMX(ctx, domain)
+	if err != nil {
+		return nil, fmt.Errorf("MX lookup: %w", err)
+	}
+	for _, record := range records {
+		host := strings.ToLower(strings.TrimSuffix(record.Host, "."))
+		for i := range Presets {
+			for _, suffix := range Presets[i].MX {
+				if strings.HasSuffix(host, suffix) {
+					return &Presets[i], nil
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("MX records of %s name no known provider", domain)
+}
+
+func domainOf(address string) string {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(address[at+1:]))
+}
+
+// String describes the servers, e.g. "imap.example.com:993 (tls) and
+// smtp.example.com:587 (starttls)"
+func (s *Settings) String() string {
+	return net.JoinHostPort(s.IMAPHost, strconv.Itoa(s.IMAPPort)) + " (" + s.IMAPSecurity + ") and " +
+		net.JoinHostPort(s.SMTPHost, strconv.Itoa(s.SMTPPort)) + " (" + s.SMTPSecurity + ")"
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"email-mcp-server/mail"
+	"email-mcp-server/storage"
+)
+
+// The autoresponder answers new INBOX mail as sync stores it, at most once
+// per sender every IntervalDays. Mail dated before the autoresponder was
+// enabled, the account's own messages, no-reply senders and anything the
+// headers mark as automated or bulk (RFC 3834) are never answered, so two
+// autoresponders cannot loop.
+
+// autoreplyPlaceholders are the values available to autoreply templates
+var autoreplyPlaceholders = []string{"subject", "name", "sender", "until"}
+
+func (es *EmailServer) handleEnableAutoresponder(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("the autoresponder is not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	responder := &storage.Autoresponder{AccountID: config.ID, Enabled: true, Start: now}
+	responder.Subject, _ = args["subject"].(string)
+	responder.Body, _ = args["body"].(string)
+	responder.Template, _ = args["template"].(string)
+	responder.MatchFrom, _ = args["match_from"].(string)
+	responder.MatchSubject, _ = args["match_subject"].(string)
+	if days, ok := args["interval_days"].(float64); ok {
+		if days < 1 {
+			return nil, invalidArgument("interval_days must be at least 1")
+		}
+		responder.IntervalDays = int(days)
+	} else {
+		responder.IntervalDays = storage.DefaultAutoreplyIntervalDays
+	}
+	if value, _ := args["start"].(string); value != "" {
+		if responder.Start, err = parseSendAt(value); err != nil {
+			return nil, err
+		}
+	}
+	if value, _ := args["until"].(string); value != "" {
+		until, err := parseLaterTime("until", value, now)
+		if err != nil {
+			return nil, err
+		}
+		if !until.After(responder.Start) {
+			return nil, invalidArgument("until must be after the start")
+		}
+		responder.Until = &until
+	}
+
+	if responder.Template == "" && responder.Body == "" {
+		return nil, invalidArgument("missing required parameters: body or template")
+	}
+	if responder.Template != "" && responder.Body != "" {
+		return nil, fmt.Errorf("use either body or template, not both")
+	}
+	t, err := es.autoreplyTemplate(responder)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range t.Placeholders() {
+		if !slices.Contains(autoreplyPlaceholders, name) {
+			return nil, fmt.Errorf("placeholder {{%s}} is not available in automatic replies (use %s)", name, strings.Join(autoreplyPlaceholders, ", "))
+		}
+	}
+
+	if err := es.db.SaveAutoresponder(responder); err != nil {
+		return nil, err
+	}
+
+	text := fmt.Sprintf("Autoresponder enabled for %s, replying at most once every %d day(s) per sender", config.ID, responder.IntervalDays)
+	if responder.Until != nil {
+		text += fmt.Sprintf(" until %s", responder.Until.Local().Format("2006-01-02 15:04 MST"))
+	}
+	responderJSON, _ := json.MarshalIndent(responder, "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: text + "\n" + string(responderJSON),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleDisableAutoresponder(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("the autoresponder is not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+	if err := es.db.SetAutoresponderEnabled(config.ID, false); err != nil {
+		return nil, err
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Autoresponder disabled for %s", config.ID),
+		}},
+	}, nil
+}
+
+// autoRespond answers the new INBOX emails of an account when its
+// autoresponder is enabled
+func (es *EmailServer) autoRespond(accountID string, emails []*storage.Email) {
+	responder, err := es.db.GetAutoresponder(accountID)
+	if err != nil {
+		log.Printf("Autoresponder of %s unavailable: %v", accountID, err)
+		return
+	}
+	if responder == nil || !responder.Enabled {
+		return
+	}
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return
+	}
+	t, err := es.autoreplyTemplate(responder)
+	if err != nil {
+		log.Printf("Autoresponder of %s skipped: %v", accountID, err)
+		return
+	}
+
+	ctx := context.Background()
+	for _, email := range emails {
+		to, ok := es.autoreplyRecipient(ctx, config, responder, email)
+		if !ok {
+			continue
+		}
+		if err := es.sendAutoreply(ctx, config, responder, t, email, to); err != nil {
+			log.Printf("Automatic reply to %s failed: %v", to.Address, err)
+		}
+	}
+}
+
+// autoreplyRecipient applies the autoresponder's criteria and the loop
+// protections to email, returning where the reply goes, or false when email
+// gets none
+func (es *EmailServer) autoreplyRecipient(ctx context.Context, config *EmailConfig, responder *storage.Autoresponder, email *storage.Email) (mail.Address, bool) {
+	from := email.FromAddress
+	if from.IsZero() || from.IsRobot() || email.Automated != "" || strings.EqualFold(from.Address, config.Username) {
+		return mail.Address{}, false
+	}
+	if !responder.Active(email.Date) || !responder.Active(time.Now()) || !responder.Matches(email) {
+		return mail.Address{}, false
+	}
+
+	headers, err := es.getEmailHeaders(ctx, config.ID, email.Folder, email.UID)
+	if err != nil {
+		log.Printf("Autoresponder of %s skipped %s: %v", config.ID, from.Address, err)
+		return mail.Address{}, false
+	}
+	to := headers.AutoReplyAddress(from)
+	if headers.Automated() || to.IsZero() || to.IsRobot() || strings.EqualFold(to.Address, config.Username) {
+		return mail.Address{}, false
+	}
+
+	last, err := es.db.LastAutoreply(config.ID, to.Address)
+	if err != nil {
+		log.Printf("Autoresponder of %s skipped %s: %v", config.ID, to.Address, err)
+		return mail.Address{}, false
+	}
+	return to, last.IsZero() || time.Since(last) >= responder.Interval()
+}
+
+// sendAutoreply answers email, sending the reply to to
+func (es *EmailServer) sendAutoreply(ctx context.Context, config *EmailConfig, responder *storage.Autoresponder, t *storage.Template, email *storage.Email, to mail.Address) error {
+	vars := map[string]string{
+		"subject": email.Subject,
+		"name":    email.FromAddress.Name,
+		"sender":  email.FromAddress.Address,
+		"until":   "",
+	}
+	if vars["name"] == "" {
+		vars["name"] = email.FromAddress.Address
+	}
+	if responder.Until != nil {
+		vars["until"] = responder.Until.Local().Format("2006-01-02")
+	}
+	subject, body, missing := t.Render(vars)
+	if len(missing) > 0 {
+		return fmt.Errorf("template %q needs values for: %s", t.Name, strings.Join(missing, ", "))
+	}
+
+	msg := &mail.OutgoingMessage{
+		To:        []string{to.String()},
+		Subject:   subject,
+		Body:      config.signed(body),
+		MessageID: mail.NewMessageID(config.Username),
+		InReplyTo: email.MessageID,
+		AutoReply: true,
+	}
+	start := time.Now()
+	err := es.sendEmail(ctx, config.ID, msg)
+	es.recordAudit(&storage.AuditEntry{Actor: "autoresponder", Operation: "send_email", AccountID: config.ID},
+		messageAuditArgs(msg), start, err)
+	if err != nil {
+		return err
+	}
+	return es.db.RecordAutoreply(config.ID, to.Address, msg.MessageID, time.Now())
+}
+
+// autoreplyTemplate returns the template of the reply: the saved one the
+// autoresponder names, or its own subject and body. The subject defaults
+// to a reply to the original one.
+func (es *EmailServer) autoreplyTemplate(responder *storage.Autoresponder) (*storage.Template, error) {
+	if responder.Template != "" {
+		return es.db.GetTemplate(responder.Template)
+	}
+	t := &storage.Template{Name: "autoreply", Subject: responder.Subject, Body: responder.Body}
+	if t.Subject == "" {
+		t.Subject = "Re: {{subject}}"
+	}
+	return t, nil
+}
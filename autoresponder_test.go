@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"email-mcp-server/mail"
+	"email-mcp-server/storage"
+)
+
+func TestAutoreplyRecipient(t *testing.T) {
+	es := newTestServer(t)
+	ctx := context.Background()
+	config, _ := es.getConfig("work")
+	responder := &storage.Autoresponder{AccountID: "work", Enabled: true, IntervalDays: 7, Start: time.Now().Add(-time.Hour)}
+
+	uid := appendMessage(t, es, "INBOX", "Return-Path: <ana.garcia@relay.example.com>\nFrom: Ana <ana@example.com>\nReply-To: team@example.com\nSubject: Lunch?\n\nHello\n")
+	email := &storage.Email{AccountID: "work", Folder: "INBOX", UID: uid, Subject: "Lunch?", Date: time.Now(),
+		FromAddress: mail.Address{Name: "Ana", Address: "ana@example.com"}}
+
+	// Replies go to the Return-Path, not to From or Reply-To
+	to, ok := es.autoreplyRecipient(ctx, config, responder, email)
+	if !ok || to.Address != "ana.garcia@relay.example.com" {
+		t.Fatalf("autoreplyRecipient() = %v, %v; want the Return-Path", to, ok)
+	}
+
+	// The interval is kept per reply address
+	if err := es.db.RecordAutoreply("work", "ana@example.com", "<1@example.com>", time.Now()); err != nil {
+		t.Fatalf("RecordAutoreply: %v", err)
+	}
+	if _, ok := es.autoreplyRecipient(ctx, config, responder, email); !ok {
+		t.Error("a reply to the From address held back the reply to the Return-Path")
+	}
+	if err := es.db.RecordAutoreply("work", to.Address, "<2@example.com>", time.Now()); err != nil {
+		t.Fatalf("RecordAutoreply: %v", err)
+	}
+	if _, ok := es.autoreplyRecipient(ctx, config, responder, email); ok {
+		t.Error("the Return-Path was answered twice within the interval")
+	}
+
+	// A null Return-Path is never answered
+	uid = appendMessage(t, es, "INBOX", "Return-Path: <>\nFrom: Bo <bo@example.com>\nSubject: Hi\n\nHello\n")
+	email = &storage.Email{AccountID: "work", Folder: "INBOX", UID: uid, Subject: "Hi", Date: time.Now(),
+		FromAddress: mail.Address{Name: "Bo", Address: "bo@example.com"}}
+	if to, ok := es.autoreplyRecipient(ctx, config, responder, email); ok {
+		t.Errorf("message with a null Return-Path answered at %v", to)
+	}
+}
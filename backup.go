@@ -0,0 +1,335 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"email-mcp-server/storage"
+)
+
+// A backup is a gzipped tar archive holding manifest.json, a copy of the
+// local database (emails.db, or emails.db.enc when the database is
+// encrypted) and the configuration files that exist, under config/. The
+// database is copied with SQLite's online backup API, so backups are
+// consistent while sync runs.
+
+// backupManifest describes the content of a backup archive
+type backupManifest struct {
+	Created   time.Time         `json:"created"`
+	Database  string            `json:"database"`  // archive entry of the database
+	Encrypted bool              `json:"encrypted"` // database kept encrypted with DATABASE_ENCRYPTION
+	Files     map[string]string `json:"files"`     // configuration file role -> archive entry
+}
+
+// configFiles returns the configuration files included in backups by role
+func configFiles(configPath string) map[string]string {
+	return map[string]string{
+		"email_config":   configPath,
+		"ai_config":      aiConfigPath(),
+		"priority_rules": rulesPath(),
+		"notifications":  notificationsPath(),
+		"credentials":    credentialOptions().FilePath,
+	}
+}
+
+// createBackup writes a timestamped archive of db and the configuration
+// files to dir and returns its path and manifest
+func createBackup(db *storage.Database, configPath, dir string) (string, *backupManifest, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	tmp, err := os.MkdirTemp(dir, ".backup-")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	manifest := &backupManifest{
+		Created:   time.Now().UTC(),
+		Database:  "emails.db",
+		Encrypted: db.Encrypted(),
+		Files:     map[string]string{},
+	}
+	if manifest.Encrypted {
+		manifest.Database = "emails.db.enc"
+	}
+	dbCopy := filepath.Join(tmp, manifest.Database)
+	if err := db.Backup(dbCopy); err != nil {
+		return "", nil, err
+	}
+
+	entries := map[string]string{manifest.Database: dbCopy}
+	for role, path := range configFiles(configPath) {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		entry := "config/" + role + filepath.Ext(path)
+		manifest.Files[role] = entry
+		entries[entry] = path
+	}
+
+	archive := filepath.Join(dir, "email-mcp-backup-"+manifest.Created.Format("20060102-150405")+".tar.gz")
+	if err := writeBackupArchive(archive, manifest, entries); err != nil {
+		os.Remove(archive)
+		return "", nil, err
+	}
+	return archive, manifest, nil
+}
+
+func writeBackupArchive(archive string, manifest *backupManifest, entries map[string]string) error {
+	file, err := os.OpenFile(archive, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", archive, err)
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	manifestJSON, _ := json.MarshalIndent(manifest, "", "  ")
+	if err := addTarEntry(tw, "manifest.json", manifestJSON, manifest.Created); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		data, err := os.ReadFile(entries[name])
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", entries[name], err)
+		}
+		if err := addTarEntry(tw, name, data, manifest.Created); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", archive, err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", archive, err)
+	}
+	return file.Close()
+}
+
+func addTarEntry(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	header := &tar.Header{Name: name, Mode: 0o600, Size: int64(len(data)), ModTime: modTime}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// readBackupArchive returns the manifest and entries of a backup archive
+func readBackupArchive(archive string) (*backupManifest, map[string][]byte, error) {
+	file, err := os.Open(archive)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s: %w", archive, err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s is not a backup archive: %w", archive, err)
+	}
+	tr := tar.NewReader(gz)
+
+	entries := map[string][]byte{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s: %w", archive, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s: %w", archive, err)
+		}
+		entries[header.Name] = data
+	}
+
+	var manifest backupManifest
+	if err := json.Unmarshal(entries["manifest.json"], &manifest); err != nil {
+		return nil, nil, fmt.Errorf("%s is not a backup archive: missing or invalid manifest.json", archive)
+	}
+	if _, ok := entries[manifest.Database]; !ok {
+		return nil, nil, fmt.Errorf("%s is incomplete: %s is missing", archive, manifest.Database)
+	}
+	return &manifest, entries, nil
+}
+
+// restoreBackup replaces the content of db with the database of archive
+// and, with restoreConfig, the configuration files, keeping the current
+// ones as .bak. It returns what was restored.
+func restoreBackup(db *storage.Database, configPath, archive string, restoreConfig bool) ([]string, error) {
+	manifest, entries, err := readBackupArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(archive), ".restore-*.db")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(entries[manifest.Database])
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract %s: %w", manifest.Database, err)
+	}
+	if err := db.Restore(tmp.Name()); err != nil {
+		return nil, err
+	}
+	restored := []string{fmt.Sprintf("database (backup of %s)", manifest.Created.Local().Format("2006-01-02 15:04"))}
+
+	if !restoreConfig {
+		return restored, nil
+	}
+	paths := configFiles(configPath)
+	roles := make([]string, 0, len(manifest.Files))
+	for role := range manifest.Files {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	for _, role := range roles {
+		path, ok := paths[role]
+		data, found := entries[manifest.Files[role]]
+		if !ok || !found {
+			continue
+		}
+		if _, err := os.Stat(path); err == nil {
+			if err := os.Rename(path, path+".bak"); err != nil {
+				return restored, fmt.Errorf("failed to keep the current %s: %w", path, err)
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return restored, fmt.Errorf("failed to restore %s: %w", path, err)
+		}
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			return restored, fmt.Errorf("failed to restore %s: %w", path, err)
+		}
+		restored = append(restored, path)
+	}
+	return restored, nil
+}
+
+func (es *EmailServer) handleBackupData(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("backup is not available: %w", errNoDatabase)
+	}
+	dir, _ := args["directory"].(string)
+	if dir == "" {
+		dir = backupDir()
+	}
+
+	archive, manifest, err := createBackup(es.db, es.configPath, dir)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{manifest.Database}
+	for _, entry := range manifest.Files {
+		files = append(files, entry)
+	}
+	sort.Strings(files[1:])
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Backup saved to %s with %s", archive, strings.Join(files, ", ")),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleRestoreData(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("restore is not available: %w", errNoDatabase)
+	}
+	archive, _ := args["archive"].(string)
+	if archive == "" {
+		return nil, fmt.Errorf("%w: archive", errMissingParameter)
+	}
+	restoreConfig, _ := args["config"].(bool)
+
+	restored, err := restoreBackup(es.db, es.configPath, archive, restoreConfig)
+	if err != nil {
+		if len(restored) > 0 {
+			err = fmt.Errorf("restored %s, then: %w", strings.Join(restored, ", "), err)
+		}
+		return nil, err
+	}
+
+	text := "Restored the " + strings.Join(restored, ", ")
+	if len(restored) > 1 {
+		text += "; the replaced files were kept with a .bak suffix. Restart the server to load the restored configuration"
+	}
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: text,
+		}},
+	}, nil
+}
+
+// backupCommand implements `email-mcp-server backup [-dir directory]`
+func backupCommand(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	dir := flags.String("dir", backupDir(), "directory the archive is written to")
+	flags.Parse(args)
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	archive, _, err := createBackup(db, accountsPath(), *dir)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Backup saved to " + archive)
+	return nil
+}
+
+// restoreCommand implements `email-mcp-server restore [-config] archive`.
+// The server must not be running: it would overwrite the restored database.
+func restoreCommand(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	restoreConfig := flags.Bool("config", false, "also restore the configuration files, keeping the current ones as .bak")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: email-mcp-server restore [-config] <archive>")
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	restored, err := restoreBackup(db, accountsPath(), flags.Arg(0), *restoreConfig)
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("Restored the " + strings.Join(restored, ", "))
+	return nil
+}
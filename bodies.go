@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+
+	"email-mcp-server/imapext"
+	"email-mcp-server/mail"
+)
+
+// get_emails and get_email_body return at most BODY_MAX_KB of each body.
+// Messages up to that size are fetched whole; larger ones, newsletters of
+// several megabytes of HTML or mail with attachments, only have their header
+// and the first bytes of their text and HTML parts fetched, so neither the
+// server's memory nor the client's context fills with them.
+
+// fetchBodies fetches the messages of sizes, RFC822.SIZE by UID, from the
+// selected folder and decodes them. The UIDs of the messages cut to
+// es.bodyLimit are set in truncated; their attachments and calendar
+// invitations are left out.
+func (es *EmailServer) fetchBodies(c *client.Client, sizes map[uint32]uint32) (parsed map[uint32]*mail.ParsedEmail, truncated map[uint32]bool, err error) {
+	parsed = make(map[uint32]*mail.ParsedEmail, len(sizes))
+	truncated = make(map[uint32]bool)
+
+	whole, large := new(imap.SeqSet), new(imap.SeqSet)
+	for uid, size := range sizes {
+		if es.bodyLimit > 0 && size > uint32(es.bodyLimit) {
+			large.AddNum(uid)
+		} else {
+			whole.AddNum(uid)
+		}
+	}
+
+	if !whole.Empty() {
+		section := &imap.BodySectionName{Peek: true}
+		err := uidFetch(c, whole, []imap.FetchItem{imap.FetchUid, section.FetchItem()}, func(msg *imap.Message) {
+			if r := msg.GetBody(section); r != nil {
+				if p, err := mail.Parse(r); err == nil {
+					parsed[msg.Uid] = p
+				}
+			}
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if large.Empty() {
+		return parsed, truncated, nil
+	}
+
+	header := &imap.BodySectionName{BodyPartName: imap.BodyPartName{Specifier: imap.HeaderSpecifier}, Peek: true}
+	structures := make(map[uint32]*imap.BodyStructure)
+	err = uidFetch(c, large, []imap.FetchItem{imap.FetchUid, imap.FetchBodyStructure, header.FetchItem()}, func(msg *imap.Message) {
+		if r := msg.GetBody(header); r != nil && msg.BodyStructure != nil {
+			if p, err := mail.ParseHeader(r); err == nil {
+				parsed[msg.Uid] = p
+				structures[msg.Uid] = msg.BodyStructure
+			}
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for uid, bs := range structures {
+		text, html := imapext.BodyParts(bs)
+		var parts []*imapext.BodyPart
+		var items []imap.FetchItem
+		for _, part := range []*imapext.BodyPart{text, html} {
+			if part != nil {
+				parts = append(parts, part)
+				items = append(items, part.Section(es.bodyLimit).FetchItem())
+			}
+		}
+		if len(parts) == 0 {
+			continue
+		}
+
+		uidset := new(imap.SeqSet)
+		uidset.AddNum(uid)
+		err := uidFetch(c, uidset, items, func(msg *imap.Message) {
+			for _, part := range parts {
+				r := msg.GetBody(part.Section(es.bodyLimit))
+				if r == nil {
+					continue
+				}
+				body := mail.DecodePart(r, part.Encoding, part.Charset, es.bodyLimit)
+				if part.HTML {
+					parsed[uid].HTMLBody = body
+				} else {
+					parsed[uid].TextBody = body
+				}
+				if part.Truncated(es.bodyLimit) {
+					truncated[uid] = true
+				}
+			}
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to fetch body of email %d: %w", uid, err)
+		}
+	}
+	return parsed, truncated, nil
+}
+
+// uidFetch runs a UID FETCH, passing each message to f
+func uidFetch(c *client.Client, uidset *imap.SeqSet, items []imap.FetchItem, f func(*imap.Message)) error {
+	messages := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.UidFetch(uidset, items, messages)
+	}()
+	for msg := range messages {
+		f(msg)
+	}
+	return <-done
+}
+
+// setBodies copies the bodies of parsed to e, cutting each to limit bytes
+func (e *EmailMessage) setBodies(parsed *mail.ParsedEmail, limit int) {
+	var cut bool
+	e.Body, cut = truncateBody(parsed.TextBody, limit)
+	e.Truncated = e.Truncated || cut
+	e.HTMLBody, cut = truncateBody(parsed.HTMLBody, limit)
+	e.Truncated = e.Truncated || cut
+}
+
+// truncateBody cuts body to at most limit bytes without splitting a
+// character, reporting whether it did; 0 means no limit
+func truncateBody(body string, limit int) (string, bool) {
+	if limit <= 0 || len(body) <= limit {
+		return body, false
+	}
+	for limit > 0 && !utf8.RuneStart(body[limit]) {
+		limit--
+	}
+	return body[:limit], true
+}
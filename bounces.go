@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"email-mcp-server/storage"
+)
+
+// recordBounces stores the recipients a synced bounce reports on, read from
+// its delivery-status part, and updates the sent messages it reports on
+func (es *EmailServer) recordBounces(email *storage.Email) error {
+	parsed, err := es.getParsedEmail(context.Background(), email.AccountID, email.Folder, email.UID)
+	if err != nil {
+		return fmt.Errorf("failed to read bounce %s/%d: %w", email.Folder, email.UID, err)
+	}
+	date := email.Date
+	if date.IsZero() {
+		date = email.SyncedAt
+	}
+	bounces := parsed.Bounces()
+	if err := es.db.SaveBounces(email.AccountID, email.Folder, email.UID, date, bounces); err != nil {
+		return fmt.Errorf("failed to store bounces of %s/%d: %w", email.Folder, email.UID, err)
+	}
+	return es.trackDelivery(email, bounces)
+}
+
+func (es *EmailServer) handleListBounces(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("bounces are not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	days := 30
+	if d, ok := args["days"].(float64); ok && d >= 1 {
+		days = int(d)
+	}
+	limit := 50
+	if l, ok := args["limit"].(float64); ok && l >= 1 {
+		limit = int(l)
+	}
+
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses, err := es.db.BouncedAddresses(config.ID, time.Now().AddDate(0, 0, -days), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	addressesJSON, _ := json.MarshalIndent(addresses, "", "  ")
+	return ToolResult{
+		Content: []TextContent{
+			{Type: "text", Text: formatBounces(config.ID, addresses, days)},
+			{Type: "text", Text: string(addressesJSON)},
+		},
+	}, nil
+}
+
+func formatBounces(accountID string, addresses []storage.BouncedAddress, days int) string {
+	if len(addresses) == 0 {
+		return fmt.Sprintf("No bounced addresses in the last %d days for %s", days, accountID)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d bounced addresses in the last %d days for %s:\n", len(addresses), days, accountID)
+	for _, a := range addresses {
+		kind := "temporary"
+		if a.Permanent {
+			kind = "permanent"
+		}
+		fmt.Fprintf(&b, "- %s: %s, %d bounces, last %s", a.Recipient, kind, a.Bounces, a.LastBounced.Local().Format("2006-01-02"))
+		if a.Status != "" {
+			fmt.Fprintf(&b, " (%s)", a.Status)
+		}
+		if a.Diagnostic != "" {
+			fmt.Fprintf(&b, ": %s", a.Diagnostic)
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
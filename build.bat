@@ -1,7 +1,7 @@
 @echo off
 echo Building MCP Email Server...
 go mod tidy
-go build -o email-mcp-server.exe main.go
+go build -o email-mcp-server.exe .
 
 if exist email-mcp-server.exe (
     echo.
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/emersion/go-imap"
+
+	"email-mcp-server/imapext"
+	"email-mcp-server/storage"
+)
+
+// Bulk actions run over a single connection: every UID goes into one UID
+// STORE or UID MOVE, and deletions are expunged once at the end.
+
+// bulkTarget is an email a bulk action applies to, as reported by dry_run
+type bulkTarget struct {
+	ID      uint32 `json:"id"`
+	From    string `json:"from,omitempty"`
+	Subject string `json:"subject,omitempty"`
+}
+
+func (es *EmailServer) handleBulkAction(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	if folder == "" {
+		folder = "INBOX"
+	}
+	action, _ := args["action"].(string)
+	destination, _ := args["destination"].(string)
+	dryRun, _ := args["dry_run"].(bool)
+
+	switch action {
+	case "delete", "archive", "mark_read":
+	case "move_to":
+		if destination == "" {
+			return nil, fmt.Errorf("%w: destination", errMissingParameter)
+		}
+	case "":
+		return nil, fmt.Errorf("%w: action", errMissingParameter)
+	default:
+		return nil, fmt.Errorf("unknown action: %s", action)
+	}
+
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	targets, err := es.bulkTargets(config.ID, folder, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no emails selected: pass ids or a query matching synced emails")
+	}
+
+	if dryRun {
+		if action == "delete" && config.movesToTrash(folder) {
+			destination = config.TrashFolder
+		}
+		targetsJSON, _ := json.MarshalIndent(targets, "", "  ")
+		return ToolResult{
+			Content: []TextContent{{
+				Type: "text",
+				Text: fmt.Sprintf("Dry run: %s would apply to %d emails in %s:\n\n%s",
+					describeBulkAction(action, destination), len(targets), folder, string(targetsJSON)),
+			}},
+		}, nil
+	}
+
+	uids := make([]uint32, 0, len(targets))
+	for _, target := range targets {
+		uids = append(uids, target.ID)
+	}
+
+	if destination, err = es.bulkAction(ctx, config.ID, folder, uids, action, destination); err != nil {
+		return nil, fmt.Errorf("failed to %s emails: %w", action, err)
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Applied %s to %d emails in %s", describeBulkAction(action, destination), len(uids), folder),
+		}},
+	}, nil
+}
+
+// bulkTargets resolves the ids argument or, without one, the query against
+// the local database. Subjects are looked up locally when available.
+func (es *EmailServer) bulkTargets(accountID, folder string, args map[string]interface{}) ([]bulkTarget, error) {
+	var targets []bulkTarget
+
+	if ids, ok := args["ids"].([]interface{}); ok {
+		for _, raw := range ids {
+			id, ok := raw.(float64)
+			if !ok {
+				return nil, fmt.Errorf("%w: %v", errInvalidEmailID, raw)
+			}
+			target := bulkTarget{ID: uint32(id)}
+			if es.db != nil {
+				if email, err := es.db.GetEmail(accountID, folder, target.ID); err == nil && email != nil {
+					target.From, target.Subject = email.From, email.Subject
+				}
+			}
+			targets = append(targets, target)
+		}
+		return targets, nil
+	}
+
+	query, _ := args["query"].(string)
+	if query == "" {
+		return nil, fmt.Errorf("%w: ids or query", errMissingParameter)
+	}
+	if es.db == nil {
+		return nil, fmt.Errorf("query selection is not available: %w", errNoDatabase)
+	}
+
+	filter := storage.SearchFilter{AccountID: accountID, Folder: folder, Limit: 100}
+	if l, ok := args["limit"].(float64); ok {
+		filter.Limit = int(l)
+	}
+	results, err := es.db.SearchEmails(query, filter)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		targets = append(targets, bulkTarget{ID: result.UID, From: result.From, Subject: result.Subject})
+	}
+	return targets, nil
+}
+
+// bulkAction applies action to every UID with a single command and returns
+// the destination folder for archive and move_to
+func (es *EmailServer) bulkAction(ctx context.Context, accountID, folder string, uids []uint32, action, destination string) (string, error) {
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return "", err
+	}
+
+	c, err := es.connectIMAP(ctx, accountID)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	switch {
+	case action == "archive":
+		if destination, err = archiveFolder(c, config); err != nil {
+			return "", err
+		}
+	case action == "delete" && config.movesToTrash(folder):
+		action, destination = "move_to", config.TrashFolder
+	}
+
+	if _, err := selectFolder(c, folder, false); err != nil {
+		return "", err
+	}
+
+	uidset := new(imap.SeqSet)
+	uidset.AddNum(uids...)
+
+	switch action {
+	case "delete":
+		if err := imapext.UidDelete(c, uidset); err != nil {
+			return "", err
+		}
+	case "mark_read", "star":
+		flag := imap.SeenFlag
+		if action == "star" {
+			flag = imap.FlaggedFlag
+		}
+		item := imap.FormatFlagsOp(imap.AddFlags, true)
+		if err := c.UidStore(uidset, item, []interface{}{flag}, nil); err != nil {
+			return "", fmt.Errorf("failed to update flags: %w", err)
+		}
+	case "archive", "move_to":
+		if err := imapext.UidMove(c, uidset, destination); err != nil {
+			return "", fmt.Errorf("failed to move emails to %s: %w", destination, err)
+		}
+	}
+	if action != "mark_read" && action != "star" {
+		es.forgetEmails(config.ID, folder, uids...)
+	}
+
+	log.Printf("Bulk %s applied to %d emails in %s", action, len(uids), folder)
+	return destination, nil
+}
+
+func describeBulkAction(action, destination string) string {
+	switch action {
+	case "delete":
+		if destination == "" {
+			return "delete"
+		}
+		return "delete (moved to " + destination + ")"
+	case "archive":
+		if destination == "" {
+			return "archive"
+		}
+		return "archive to " + destination
+	case "move_to":
+		return "move to " + destination
+	}
+	return action
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap"
+
+	"email-mcp-server/storage"
+)
+
+// newBulkServer returns a test server whose INBOX also holds three
+// newsletters, synced to the database, and their UIDs
+func newBulkServer(t *testing.T) (*EmailServer, []uint32) {
+	t.Helper()
+	es := newTestServer(t)
+	var uids []uint32
+	for week := 1; week <= 3; week++ {
+		subject := fmt.Sprintf("Newsletter week %d", week)
+		uid := appendMessage(t, es, "INBOX", "From: news@shop.com\nSubject: "+subject+"\n\nDeals\n")
+		if err := es.db.CreateEmail(&storage.Email{AccountID: "work", Folder: "INBOX", UID: uid, From: "news@shop.com", Subject: subject}); err != nil {
+			t.Fatalf("CreateEmail: %v", err)
+		}
+		uids = append(uids, uid)
+	}
+	return es, uids
+}
+
+// resultText returns the text of a tool result
+func resultText(t *testing.T, result interface{}) string {
+	t.Helper()
+	tr, ok := result.(ToolResult)
+	if !ok || len(tr.Content) == 0 {
+		t.Fatalf("result = %#v, want a ToolResult", result)
+	}
+	return tr.Content[0].Text
+}
+
+func TestBulkActionDryRun(t *testing.T) {
+	es, _ := newBulkServer(t)
+	result, err := es.handleBulkAction(context.Background(), map[string]interface{}{
+		"action": "delete", "query": "newsletter", "dry_run": true,
+	})
+	if err != nil {
+		t.Fatalf("handleBulkAction: %v", err)
+	}
+	text := resultText(t, result)
+	if !strings.Contains(text, "delete would apply to 3 emails") || !strings.Contains(text, "Newsletter week 2") {
+		t.Errorf("dry run = %q, want the 3 newsletters listed", text)
+	}
+	if counts := folderMessages(t, es); counts["INBOX"] != 4 {
+		t.Errorf("dry run changed the INBOX: %v", counts)
+	}
+}
+
+func TestBulkAction(t *testing.T) {
+	es, uids := newBulkServer(t)
+	ctx := context.Background()
+	if err := es.createFolder(ctx, "work", "Promotions"); err != nil {
+		t.Fatalf("createFolder: %v", err)
+	}
+	ids := func(uids ...uint32) []interface{} {
+		var list []interface{}
+		for _, uid := range uids {
+			list = append(list, float64(uid))
+		}
+		return list
+	}
+
+	if _, err := es.handleBulkAction(ctx, map[string]interface{}{"action": "mark_read", "ids": ids(uids...)}); err != nil {
+		t.Fatalf("mark_read: %v", err)
+	}
+	for _, uid := range uids {
+		if !hasFlag(messageFlags(t, es, "INBOX", uid), imap.SeenFlag) {
+			t.Errorf("email %d not marked read", uid)
+		}
+	}
+
+	result, err := es.handleBulkAction(ctx, map[string]interface{}{"action": "move_to", "destination": "Promotions", "ids": ids(uids[:2]...)})
+	if err != nil {
+		t.Fatalf("move_to: %v", err)
+	}
+	if text := resultText(t, result); text != "Applied move to Promotions to 2 emails in INBOX" {
+		t.Errorf("move_to = %q", text)
+	}
+	if counts := folderMessages(t, es); counts["INBOX"] != 2 || counts["Promotions"] != 2 {
+		t.Errorf("messages after move_to = %v, want 2 in INBOX and Promotions", counts)
+	}
+
+	if _, err := es.handleBulkAction(ctx, map[string]interface{}{"action": "delete", "query": "newsletter"}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if counts := folderMessages(t, es); counts["INBOX"] != 1 {
+		t.Errorf("messages after delete = %v, want the one that is no newsletter", counts)
+	}
+
+	for _, args := range []map[string]interface{}{
+		{"action": "move_to", "ids": ids(6)},
+		{"action": "delete"},
+		{"action": "delete", "query": "no such email"},
+	} {
+		if _, err := es.handleBulkAction(ctx, args); err == nil {
+			t.Errorf("handleBulkAction(%v) succeeded", args)
+		}
+	}
+}
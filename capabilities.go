@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/emersion/go-imap/client"
+
+	"email-mcp-server/imapext"
+)
+
+// The IMAP extensions of each account are read from the first connection
+// and cached until get_capabilities is asked to refresh them. Moves,
+// deletions, sync and searches check the extensions of their connection to
+// use the best command available; get_capabilities reports the result.
+
+// accountCapabilities describes what an account's server supports and how
+// each operation is carried out with it
+type accountCapabilities struct {
+	Account  string                `json:"account"`
+	Provider string                `json:"provider"`
+	Checked  time.Time             `json:"checked"`
+	IMAP     *imapext.Capabilities `json:"imap,omitempty"`
+	Features map[string]string     `json:"features"`
+}
+
+// recordCapabilities caches the capabilities of a new connection unless the
+// account's are cached already
+func (es *EmailServer) recordCapabilities(accountID string, c *client.Client) {
+	es.capsMu.Lock()
+	_, ok := es.caps[accountID]
+	es.capsMu.Unlock()
+	if ok {
+		return
+	}
+
+	caps, err := imapext.Probe(c)
+	if err != nil {
+		log.Printf("Capabilities of account %s unknown: %v", accountID, err)
+		return
+	}
+	es.capsMu.Lock()
+	es.caps[accountID] = &accountCapabilities{
+		Account:  accountID,
+		Provider: providerIMAP,
+		Checked:  time.Now(),
+		IMAP:     caps,
+		Features: imapFeatures(caps, es.syncer != nil && es.syncer.Push),
+	}
+	es.capsMu.Unlock()
+}
+
+// forgetCapabilities drops the cached capabilities of an account
+func (es *EmailServer) forgetCapabilities(accountID string) {
+	es.capsMu.Lock()
+	delete(es.caps, accountID)
+	es.capsMu.Unlock()
+}
+
+// accountCapabilities returns the capabilities of an account, connecting to
+// probe them when they are not cached or refresh is set
+func (es *EmailServer) accountCapabilities(ctx context.Context, config *EmailConfig, refresh bool) (*accountCapabilities, error) {
+	if config.isGraph() {
+		return &accountCapabilities{
+			Account:  config.ID,
+			Provider: providerGraph,
+			Checked:  time.Now(),
+			Features: graphFeatures,
+		}, nil
+	}
+
+	if refresh {
+		es.forgetCapabilities(config.ID)
+	}
+	es.capsMu.Lock()
+	caps, ok := es.caps[config.ID]
+	es.capsMu.Unlock()
+	if ok {
+		return caps, nil
+	}
+
+	c, err := es.connectIMAP(ctx, config.ID)
+	if err != nil {
+		return nil, err
+	}
+	c.Logout()
+
+	es.capsMu.Lock()
+	caps, ok = es.caps[config.ID]
+	es.capsMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("capabilities of account %s could not be read", config.ID)
+	}
+	return caps, nil
+}
+
+// imapFeatures describes how each operation works with caps, push telling
+// whether SYNC_PUSH is on
+func imapFeatures(caps *imapext.Capabilities, push bool) map[string]string {
+	features := map[string]string{
+		"move":    "MOVE",
+		"delete":  "UID EXPUNGE of the deleted messages only",
+		"sync":    "new messages and flag changes (CONDSTORE)",
+		"search":  "ESEARCH, matches returned as ranges",
+		"folders": "Sent folder found by its \\Sent attribute unless SentFolder is set",
+		"push":    "IDLE is advertised, but sync polls every SYNC_INTERVAL_MINUTES; set SYNC_PUSH to sync on new mail",
+		"labels":  "Gmail labels (X-GM-EXT-1): listed by get_emails, changed by add_label and remove_label",
+	}
+	if !caps.UIDPlus {
+		features["delete"] = "EXPUNGE of the whole folder: messages other clients marked deleted are removed too"
+	}
+	if !caps.Move {
+		features["move"] = "COPY, then delete as described by delete"
+	}
+	if !caps.CondStore {
+		features["sync"] = "new messages and stars only; other flag changes made elsewhere are not synced"
+	}
+	if !caps.ESearch {
+		features["search"] = "SEARCH, every matching UID returned"
+	}
+	if !caps.SpecialUse {
+		features["folders"] = "no SPECIAL-USE attributes: set SentFolder to sync sent mail"
+	}
+	if push {
+		features["push"] = "IDLE on INBOX: new mail is synced as it arrives, the connection is renewed every IDLE_RENEW_MINUTES and reopened when lost"
+	}
+	if !caps.Idle {
+		features["push"] = "sync polls every SYNC_INTERVAL_MINUTES"
+	}
+	if !caps.Labels {
+		features["labels"] = "not supported, labels are a Gmail extension"
+	}
+	return features
+}
+
+// graphFeatures describes how each operation works for Graph accounts
+var graphFeatures = map[string]string{
+	"move":    "Graph move, messages keep their IDs",
+	"delete":  "moved to Deleted Items unless TrashFolder is set",
+	"sync":    "new messages and flag changes (delta queries)",
+	"search":  "local_search over synced mail only",
+	"folders": "listing only; creating, renaming and deleting folders is not supported",
+	"flags":   "seen and flagged only",
+	"labels":  "not supported, labels are a Gmail extension",
+	"push":    "sync polls every SYNC_INTERVAL_MINUTES",
+}
+
+func (es *EmailServer) handleGetCapabilities(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	refresh, _ := args["refresh"].(bool)
+	var configs []EmailConfig
+	if accountID, _ := args["account"].(string); accountID != "" {
+		config, err := es.getConfig(accountID)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, *config)
+	} else {
+		configs = es.accounts()
+	}
+
+	type result struct {
+		*accountCapabilities
+		Account string `json:"account"`
+		Error   string `json:"error,omitempty"`
+	}
+	var results []result
+	for i := range configs {
+		caps, err := es.accountCapabilities(ctx, &configs[i], refresh)
+		if err != nil {
+			results = append(results, result{Account: configs[i].ID, Error: err.Error()})
+			continue
+		}
+		results = append(results, result{accountCapabilities: caps, Account: caps.Account})
+	}
+
+	resultJSON, _ := json.MarshalIndent(results, "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: string(resultJSON),
+		}},
+	}, nil
+}
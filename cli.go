@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// The emails command runs tools from a shell, for scripts and for trying
+// features without an MCP client. It builds the same EmailServer as the MCP
+// mode and calls the tools through the registry, so arguments are
+// validated, TOOL_TIMEOUT_SECONDS applies and changes are audited, with
+// "client:cli" as the actor. The database is opened but the background sync
+// and scheduler are not started. Typing the command is the confirmation, so
+// CONFIRM_DESTRUCTIVE does not apply.
+
+// emailsUsage lists the subcommands of `email-mcp-server emails`
+const emailsUsage = `usage: email-mcp-server emails <command> [flags]
+
+commands:
+  list      list recent emails of a folder (get_emails)
+  send      send an email (send_email); -body - reads the body from stdin
+  sync      sync accounts into the local database now (sync_now)
+  classify  categorize recent emails or one email (classify_emails)
+  call      call any tool: emails call <tool> ['{"json": "arguments"}']
+
+Run email-mcp-server emails <command> -h for the flags of a command.`
+
+// listFlag collects a flag given several times, or once with
+// comma-separated values
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// arguments returns the list as tool arguments
+func (l listFlag) arguments() []interface{} {
+	items := make([]interface{}, len(l))
+	for i, item := range l {
+		items[i] = item
+	}
+	return items
+}
+
+// emailsCommand implements `email-mcp-server emails <command> [flags]`
+func emailsCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", emailsUsage)
+	}
+	var (
+		name      string
+		arguments map[string]interface{}
+		err       error
+	)
+	switch args[0] {
+	case "list":
+		name, arguments = "get_emails", listArguments(args[1:])
+	case "send":
+		name = "send_email"
+		arguments, err = sendArguments(args[1:], os.Stdin)
+	case "sync":
+		name, arguments = "sync_now", syncArguments(args[1:])
+	case "classify":
+		name, arguments = "classify_emails", classifyArguments(args[1:])
+	case "call":
+		name, arguments, err = callArguments(args[1:])
+	case "help", "-h", "--help":
+		fmt.Println(emailsUsage)
+		return nil
+	default:
+		return fmt.Errorf("unknown emails command %q\n\n%s", args[0], emailsUsage)
+	}
+	if err != nil {
+		return err
+	}
+
+	es := NewEmailServer()
+	es.confirmDestructive = false
+	es.client = "cli"
+	es.initDatabase()
+	es.initAI()
+	es.initNotifications()
+	defer func() {
+		// An encrypted database only reaches the disk when saved
+		if es.db != nil {
+			if err := es.db.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error closing database: %v\n", err)
+			}
+		}
+	}()
+
+	return es.runTool(context.Background(), name, arguments, os.Stdout)
+}
+
+// runTool calls a tool as tools/call would and writes the text of its
+// result to w. A result flagged as an error is returned as one.
+func (es *EmailServer) runTool(ctx context.Context, name string, arguments map[string]interface{}, w io.Writer) error {
+	result, err := es.callTool(ctx, ToolCallParams{Name: name, Arguments: arguments})
+	if err != nil {
+		return err
+	}
+	tr, ok := result.(ToolResult)
+	if !ok {
+		out, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Fprintln(w, string(out))
+		return nil
+	}
+	var texts []string
+	for _, content := range tr.Content {
+		texts = append(texts, content.Text)
+	}
+	if tr.IsError {
+		return fmt.Errorf("%s", strings.Join(texts, "\n\n"))
+	}
+	fmt.Fprintln(w, strings.Join(texts, "\n\n"))
+	return nil
+}
+
+func listArguments(args []string) map[string]interface{} {
+	flags := flag.NewFlagSet("emails list", flag.ExitOnError)
+	account := flags.String("account", "", "account ID (default: the first account)")
+	folder := flags.String("folder", "INBOX", "folder to list")
+	limit := flags.Int("limit", 10, "number of emails, 1 to 100")
+	body := flags.Bool("body", false, "also fetch and decode the bodies")
+	flags.Parse(args)
+
+	arguments := map[string]interface{}{"folder": *folder, "limit": float64(*limit), "include_body": *body}
+	setAccount(arguments, *account)
+	return arguments
+}
+
+// sendArguments reads the flags of emails send; a body of "-" is read from
+// stdin
+func sendArguments(args []string, stdin io.Reader) (map[string]interface{}, error) {
+	flags := flag.NewFlagSet("emails send", flag.ExitOnError)
+	account := flags.String("account", "", "account ID to send from (default: the first account)")
+	var to, cc, bcc, attach listFlag
+	flags.Var(&to, "to", "recipient, repeated or comma-separated")
+	flags.Var(&cc, "cc", "carbon-copy recipient, repeated or comma-separated")
+	flags.Var(&bcc, "bcc", "blind carbon-copy recipient, repeated or comma-separated")
+	flags.Var(&attach, "attach", "file to attach, repeated or comma-separated")
+	subject := flags.String("subject", "", "subject")
+	body := flags.String("body", "", "body, or - to read it from stdin")
+	flags.Parse(args)
+
+	if len(to) == 0 {
+		return nil, fmt.Errorf("emails send needs at least one -to recipient")
+	}
+	if *body == "-" {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the body from stdin: %w", err)
+		}
+		*body = string(data)
+	}
+
+	arguments := map[string]interface{}{"to": to.arguments(), "subject": *subject, "body": *body}
+	setAccount(arguments, *account)
+	if len(cc) > 0 {
+		arguments["cc"] = cc.arguments()
+	}
+	if len(bcc) > 0 {
+		arguments["bcc"] = bcc.arguments()
+	}
+	if len(attach) > 0 {
+		var attachments []interface{}
+		for _, path := range attach {
+			attachments = append(attachments, map[string]interface{}{"path": path})
+		}
+		arguments["attachments"] = attachments
+	}
+	return arguments, nil
+}
+
+func syncArguments(args []string) map[string]interface{} {
+	flags := flag.NewFlagSet("emails sync", flag.ExitOnError)
+	account := flags.String("account", "", "account ID (default: every account)")
+	flags.Parse(args)
+
+	arguments := map[string]interface{}{}
+	setAccount(arguments, *account)
+	return arguments
+}
+
+func classifyArguments(args []string) map[string]interface{} {
+	flags := flag.NewFlagSet("emails classify", flag.ExitOnError)
+	account := flags.String("account", "", "account ID (default: the first account)")
+	folder := flags.String("folder", "INBOX", "folder of the emails")
+	id := flags.Int("id", 0, "classify only this email")
+	limit := flags.Int("limit", 10, "number of recent emails to classify when no -id is given, 1 to 50")
+	flags.Parse(args)
+
+	arguments := map[string]interface{}{"folder": *folder, "limit": float64(*limit)}
+	setAccount(arguments, *account)
+	if *id > 0 {
+		arguments["id"] = float64(*id)
+	}
+	return arguments
+}
+
+// callArguments reads `emails call <tool> [json]`, decoding the arguments
+// as tools/call would
+func callArguments(args []string) (string, map[string]interface{}, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return "", nil, fmt.Errorf(`usage: email-mcp-server emails call <tool> ['{"json": "arguments"}']`)
+	}
+	arguments := map[string]interface{}{}
+	if len(args) == 2 {
+		if err := json.Unmarshal([]byte(args[1]), &arguments); err != nil {
+			return "", nil, fmt.Errorf("invalid arguments of %s: %w", args[0], err)
+		}
+	}
+	return args[0], arguments, nil
+}
+
+func setAccount(arguments map[string]interface{}, account string) {
+	if account != "" {
+		arguments["account"] = account
+	}
+}
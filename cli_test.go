@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCLIArguments(t *testing.T) {
+	if got, want := listArguments([]string{"-account", "work", "-folder", "Archive", "-limit", "5"}),
+		map[string]interface{}{"account": "work", "folder": "Archive", "limit": float64(5), "include_body": false}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listArguments = %v, want %v", got, want)
+	}
+	if got, want := syncArguments(nil), map[string]interface{}{}; !reflect.DeepEqual(got, want) {
+		t.Errorf("syncArguments = %v, want %v", got, want)
+	}
+	if got, want := classifyArguments([]string{"-id", "42"}),
+		map[string]interface{}{"folder": "INBOX", "limit": float64(10), "id": float64(42)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("classifyArguments = %v, want %v", got, want)
+	}
+
+	got, err := sendArguments([]string{"-to", "ana@example.com,bo@example.com", "-to", "cy@example.com",
+		"-attach", "a.pdf", "-subject", "Hi", "-body", "-"}, strings.NewReader("From stdin\n"))
+	if err != nil {
+		t.Fatalf("sendArguments: %v", err)
+	}
+	want := map[string]interface{}{
+		"to":          []interface{}{"ana@example.com", "bo@example.com", "cy@example.com"},
+		"subject":     "Hi",
+		"body":        "From stdin\n",
+		"attachments": []interface{}{map[string]interface{}{"path": "a.pdf"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sendArguments = %v, want %v", got, want)
+	}
+	if _, err := sendArguments([]string{"-subject", "Hi"}, strings.NewReader("")); err == nil {
+		t.Error("sendArguments without a recipient succeeded")
+	}
+
+	name, arguments, err := callArguments([]string{"get_email_body", `{"id": 6}`})
+	if err != nil || name != "get_email_body" || arguments["id"] != float64(6) {
+		t.Errorf("callArguments = %s, %v, %v", name, arguments, err)
+	}
+	if _, _, err := callArguments([]string{"get_email_body", `{"id":`}); err == nil {
+		t.Error("callArguments with invalid JSON succeeded")
+	}
+	if _, _, err := callArguments(nil); err == nil {
+		t.Error("callArguments without a tool succeeded")
+	}
+}
+
+func TestRunTool(t *testing.T) {
+	es := newTestServer(t)
+	es.tools.Register(Tool{Name: "echo", InputSchema: map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{"text": map[string]interface{}{"type": "string"}},
+	}}, func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+		text, _ := args["text"].(string)
+		return ToolResult{Content: []TextContent{{Type: "text", Text: text}}, IsError: text == "fail"}, nil
+	})
+	ctx := context.Background()
+
+	var out bytes.Buffer
+	if err := es.runTool(ctx, "echo", map[string]interface{}{"text": "hello"}, &out); err != nil || out.String() != "hello\n" {
+		t.Errorf("runTool = %q, %v; want hello", out.String(), err)
+	}
+	if err := es.runTool(ctx, "echo", map[string]interface{}{"text": "fail"}, &out); err == nil || err.Error() != "fail" {
+		t.Errorf("runTool of a failing result = %v, want the result text as error", err)
+	}
+	if err := es.runTool(ctx, "echo", map[string]interface{}{"text": 3}, &out); err == nil {
+		t.Error("runTool with an invalid argument succeeded")
+	}
+	if err := es.runTool(ctx, "missing", nil, &out); err == nil {
+		t.Error("runTool of an unknown tool succeeded")
+	}
+}
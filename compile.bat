@@ -14,7 +14,7 @@ echo Descargando dependencias...
 go mod tidy
 
 echo Compilando...
-go build -o email-mcp-server.exe main.go
+go build -o email-mcp-server.exe .
 
 if exist email-mcp-server.exe (
     echo.
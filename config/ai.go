@@ -0,0 +1,141 @@
+// Package config loads the optional settings files used by the intelligence
+// features. Every setting has a default, so a missing file is not an error.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// AIConfig selects the LLM provider used for summaries and classification
+type AIConfig struct {
+	Provider       string  `json:"provider"` // openai, anthropic or ollama
+	APIKey         string  `json:"api_key"`
+	BaseURL        string  `json:"base_url"` // overrides the provider's default endpoint
+	Model          string  `json:"model"`
+	Temperature    float64 `json:"temperature"`
+	MaxTokens      int     `json:"max_tokens"`
+	TimeoutSeconds int     `json:"timeout_seconds"`
+
+	Summarization  SummarizationConfig  `json:"summarization"`
+	Classification ClassificationConfig `json:"classification"`
+	Learning       LearningConfig       `json:"learning"`
+	Invoices       InvoicesConfig       `json:"invoices"`
+}
+
+// SummarizationConfig controls summarize_email and summarize_thread
+type SummarizationConfig struct {
+	Style           string `json:"style"`      // brief, detailed or bullet_points
+	MaxLength       int    `json:"max_length"` // approximate words per summary
+	Language        string `json:"language"`   // empty keeps the language of the email
+	CacheEnabled    bool   `json:"cache_enabled"`
+	CacheTTLMinutes int    `json:"cache_ttl_minutes"`
+}
+
+// ClassificationConfig controls when the classifier consults the LLM. Rules
+// are always evaluated first; the LLM is only asked when the best rule is less
+// confident than ConfidenceThreshold.
+type ClassificationConfig struct {
+	UseAI               bool     `json:"use_ai"`
+	FallbackToRules     bool     `json:"fallback_to_rules"` // use the rule result when the LLM fails
+	ConfidenceThreshold float64  `json:"confidence_threshold"`
+	ReviewThreshold     float64  `json:"review_threshold"` // results below it are tagged needs_review
+	Categories          []string `json:"categories"`       // categories the LLM may choose from
+	RateLimitPerMinute  int      `json:"rate_limit_per_minute"`
+	CacheTTLMinutes     int      `json:"cache_ttl_minutes"` // 0 disables the cache of LLM results
+	CacheMaxEntries     int      `json:"cache_max_entries"` // least recently used results beyond it are dropped; 0 for no bound
+}
+
+// LearningConfig controls how correct_classification feedback changes the
+// classifier. Nothing is learned from a single correction: a sender is mapped
+// to a category, or a rule loses confidence, only after MinSamples corrections.
+type LearningConfig struct {
+	Enabled           bool    `json:"enabled"`
+	MinSamples        int     `json:"min_samples"`
+	ConfidenceStep    float64 `json:"confidence_step"`    // lowered per further mistake of a rule
+	LearnedConfidence float64 `json:"learned_confidence"` // confidence of learned sender mappings
+}
+
+// InvoicesConfig controls how the invoices found by sync are read. Without
+// UseAI, or without a provider, patterns are used.
+type InvoicesConfig struct {
+	UseAI bool `json:"use_ai"`
+}
+
+// DefaultAIConfig returns the settings used when no file is present
+func DefaultAIConfig() *AIConfig {
+	return &AIConfig{
+		Provider:       "openai",
+		Temperature:    0.3,
+		MaxTokens:      500,
+		TimeoutSeconds: 30,
+		Summarization: SummarizationConfig{
+			Style:           "brief",
+			MaxLength:       100,
+			CacheEnabled:    true,
+			CacheTTLMinutes: 60,
+		},
+		Classification: ClassificationConfig{
+			UseAI:               true,
+			FallbackToRules:     true,
+			ConfidenceThreshold: 0.7,
+			ReviewThreshold:     0.5,
+			Categories: []string{"work", "personal", "invoice", "order", "travel", "newsletter",
+				"promotions", "notification", "social", "meeting", "support", "spam"},
+			RateLimitPerMinute: 20,
+			CacheTTLMinutes:    1440,
+			CacheMaxEntries:    5000,
+		},
+		Learning: LearningConfig{
+			Enabled:           true,
+			MinSamples:        3,
+			ConfidenceStep:    0.05,
+			LearnedConfidence: 0.9,
+		},
+	}
+}
+
+// LoadAIConfig reads the AI settings from path, if it exists, over the
+// defaults. AI_PROVIDER, AI_MODEL, AI_BASE_URL and AI_API_KEY override the
+// file; OPENAI_API_KEY and ANTHROPIC_API_KEY are used when no key is set.
+func LoadAIConfig(path string) (*AIConfig, error) {
+	cfg := DefaultAIConfig()
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("invalid AI config %s: %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read AI config %s: %w", path, err)
+	}
+
+	overrideString(&cfg.Provider, "AI_PROVIDER")
+	overrideString(&cfg.Model, "AI_MODEL")
+	overrideString(&cfg.BaseURL, "AI_BASE_URL")
+	overrideString(&cfg.APIKey, "AI_API_KEY")
+	if value := os.Getenv("AI_TEMPERATURE"); value != "" {
+		if t, err := strconv.ParseFloat(value, 64); err == nil {
+			cfg.Temperature = t
+		}
+	}
+
+	if cfg.APIKey == "" {
+		switch cfg.Provider {
+		case "openai":
+			cfg.APIKey = os.Getenv("OPENAI_API_KEY")
+		case "anthropic":
+			cfg.APIKey = os.Getenv("ANTHROPIC_API_KEY")
+		}
+	}
+
+	return cfg, nil
+}
+
+func overrideString(dest *string, key string) {
+	if value := os.Getenv(key); value != "" {
+		*dest = value
+	}
+}
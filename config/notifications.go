@@ -0,0 +1,107 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// NotificationConfig decides which synced emails trigger an alert and where
+// alerts are sent. Emails scoring at least HighThreshold are high priority,
+// and at least CriticalThreshold critical; each channel only receives alerts
+// of its MinLevel or above.
+type NotificationConfig struct {
+	Enabled           bool `json:"enabled"`
+	CriticalThreshold int  `json:"critical_threshold"`
+	HighThreshold     int  `json:"high_threshold"`
+	DedupMinutes      int  `json:"dedup_minutes"`       // an email is alerted about once in this window
+	RateLimitPerHour  int  `json:"rate_limit_per_hour"` // per channel; 0 disables limiting
+	// Alert, as critical, when a bounce reports that a message sent through
+	// the server could not be delivered
+	DeliveryFailures bool `json:"delivery_failures"`
+
+	Webhooks []WebhookChannel `json:"webhooks"`
+	Email    EmailChannel     `json:"email"`
+	Desktop  DesktopChannel   `json:"desktop"`
+}
+
+// WebhookChannel posts alerts to URL. Format is slack (also accepted by
+// Mattermost and Rocket.Chat), discord or json.
+type WebhookChannel struct {
+	Name     string `json:"name,omitempty"`
+	URL      string `json:"url"`
+	Format   string `json:"format"`
+	MinLevel string `json:"min_level"` // critical or high
+}
+
+// EmailChannel sends one summary email per sync with the alerts it raised
+type EmailChannel struct {
+	Enabled  bool   `json:"enabled"`
+	Account  string `json:"account"` // sending account; empty uses the default
+	To       string `json:"to"`      // empty sends to the account's own address
+	MinLevel string `json:"min_level"`
+}
+
+// DesktopChannel shows alerts with notify-send (Linux) or osascript (macOS)
+type DesktopChannel struct {
+	Enabled  bool   `json:"enabled"`
+	MinLevel string `json:"min_level"`
+}
+
+// DefaultNotificationConfig returns the settings used when no file is
+// present: notifications are off
+func DefaultNotificationConfig() *NotificationConfig {
+	return &NotificationConfig{
+		CriticalThreshold: 80,
+		HighThreshold:     60,
+		DedupMinutes:      1440,
+		RateLimitPerHour:  20,
+	}
+}
+
+// LoadNotificationConfig reads the notification settings from path, if it
+// exists, over the defaults
+func LoadNotificationConfig(path string) (*NotificationConfig, error) {
+	cfg := DefaultNotificationConfig()
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notification config %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("invalid notification config %s: %w", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid notification config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate reports thresholds out of range and channels that can never send
+func (c *NotificationConfig) Validate() error {
+	if c.HighThreshold < 0 || c.CriticalThreshold > 100 || c.HighThreshold > c.CriticalThreshold {
+		return fmt.Errorf("thresholds must satisfy 0 <= high_threshold <= critical_threshold <= 100")
+	}
+
+	levels := map[string]bool{"": true, "critical": true, "high": true}
+	for i, hook := range c.Webhooks {
+		if hook.URL == "" {
+			return fmt.Errorf("webhook %d: url is required", i+1)
+		}
+		switch hook.Format {
+		case "", "slack", "discord", "json":
+		default:
+			return fmt.Errorf("webhook %d: unknown format %q", i+1, hook.Format)
+		}
+		if !levels[hook.MinLevel] {
+			return fmt.Errorf("webhook %d: min_level must be critical or high", i+1)
+		}
+	}
+	if !levels[c.Email.MinLevel] || !levels[c.Desktop.MinLevel] {
+		return fmt.Errorf("min_level must be critical or high")
+	}
+	return nil
+}
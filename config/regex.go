@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sync"
+)
+
+// Limits on the regex conditions of rules. Go regexes run in linear time,
+// so a pattern cannot backtrack catastrophically, but a long pattern or one
+// with large repeat counts compiles to a program big enough to make every
+// match slow; such patterns are rejected.
+const (
+	maxRegexLength       = 1000
+	maxRegexInstructions = 5000
+	maxCachedRegexes     = 1000
+)
+
+var regexCache = struct {
+	sync.Mutex
+	compiled map[string]*regexp.Regexp
+}{compiled: make(map[string]*regexp.Regexp)}
+
+// CompileRegex compiles the pattern of a regex condition, rejecting patterns
+// above the complexity limits, and caches the result so that rules compile
+// each pattern once, when they are loaded
+func CompileRegex(pattern string) (*regexp.Regexp, error) {
+	regexCache.Lock()
+	re, ok := regexCache.compiled[pattern]
+	regexCache.Unlock()
+	if ok {
+		return re, nil
+	}
+
+	if len(pattern) > maxRegexLength {
+		return nil, fmt.Errorf("regex is longer than %d characters", maxRegexLength)
+	}
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex %q: %w", pattern, err)
+	}
+	prog, err := syntax.Compile(parsed.Simplify())
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex %q: %w", pattern, err)
+	}
+	if len(prog.Inst) > maxRegexInstructions {
+		return nil, fmt.Errorf("regex %q is too complex: reduce its repeat counts or alternatives", pattern)
+	}
+	re, err = regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex %q: %w", pattern, err)
+	}
+
+	regexCache.Lock()
+	// Patterns only change when the rules are edited; start over rather than
+	// grow without bound
+	if len(regexCache.compiled) >= maxCachedRegexes {
+		clear(regexCache.compiled)
+	}
+	regexCache.compiled[pattern] = re
+	regexCache.Unlock()
+	return re, nil
+}
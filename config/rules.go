@@ -0,0 +1,585 @@
+package config
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+
+	"email-mcp-server/mail"
+)
+
+// Rules holds the user-editable classification rules, read from
+// priority_rules.json. Accounts adjusts them per account ID; see ForAccount.
+type Rules struct {
+	Classification []ClassificationRule `json:"classification_rules"`
+	// MatchStrategy chooses among the classification rules that match an
+	// email; MatchBestConfidence when empty
+	MatchStrategy string   `json:"match_strategy,omitempty"`
+	VIPSenders    []string `json:"vip_senders,omitempty"`
+	// Mail from IgnoredSenders, addresses or domains, or whose subject
+	// contains one of IgnoredSubjects is ignored, see IsIgnored. With
+	// AutoArchiveIgnored, ForAccount adds the AutoArchiveRule action rule.
+	IgnoredSenders     []string `json:"ignored_senders,omitempty"`
+	IgnoredSubjects    []string `json:"ignored_subjects,omitempty"`
+	AutoArchiveIgnored bool     `json:"auto_archive_ignored,omitempty"`
+	// UrgencyKeywords weigh the words of an email's subject and body in its
+	// priority score; DefaultKeywords when nil
+	UrgencyKeywords []Keyword                `json:"urgency_keywords,omitempty"`
+	Actions         []ActionRule             `json:"action_rules,omitempty"`
+	Accounts        map[string]*AccountRules `json:"accounts,omitempty"`
+}
+
+// AccountRules are merged over the global rules for one account. A rule
+// with the name of a global rule replaces it; DisabledRules drops global
+// classification and action rules by name. The ignore lists are added to
+// the global ones, and AutoArchiveIgnored, when set, replaces the global
+// setting, as does MatchStrategy. UrgencyKeywords are added to the global
+// keywords, replacing those with the same phrase.
+type AccountRules struct {
+	Classification     []ClassificationRule `json:"classification_rules,omitempty"`
+	MatchStrategy      string               `json:"match_strategy,omitempty"`
+	VIPSenders         []string             `json:"vip_senders,omitempty"`
+	IgnoredSenders     []string             `json:"ignored_senders,omitempty"`
+	IgnoredSubjects    []string             `json:"ignored_subjects,omitempty"`
+	AutoArchiveIgnored *bool                `json:"auto_archive_ignored,omitempty"`
+	UrgencyKeywords    []Keyword            `json:"urgency_keywords,omitempty"`
+	Actions            []ActionRule         `json:"action_rules,omitempty"`
+	DisabledRules      []string             `json:"disabled_rules,omitempty"`
+}
+
+// ClassificationRule assigns Category when every condition matches. Rules
+// are evaluated by descending Priority, in file order among equals, and the
+// match strategy of the rules chooses among those that match. An Exclusive
+// rule that matches ends the evaluation: the rules after it are not tried.
+type ClassificationRule struct {
+	Name       string      `json:"name"`
+	Category   string      `json:"category"`
+	Confidence float64     `json:"confidence"` // 0-1
+	Priority   int         `json:"priority,omitempty"`
+	Exclusive  bool        `json:"exclusive,omitempty"`
+	Tags       []string    `json:"tags,omitempty"`
+	Conditions []Condition `json:"conditions"`
+}
+
+// Match strategies: the first matching rule wins, the most confident one
+// wins, or the confidences of the rules matching each category add up and
+// the category with the most wins
+const (
+	MatchFirst          = "first_match"
+	MatchBestConfidence = "best_confidence"
+	MatchWeighted       = "weighted"
+)
+
+// Actions an ActionRule can apply
+const (
+	ActionArchive  = "archive"
+	ActionDelete   = "delete"
+	ActionMarkRead = "mark_read"
+	ActionStar     = "star"
+	ActionMoveTo   = "move_to"
+)
+
+// AutoArchiveRule names the action rule that marks ignored mail read and
+// archives it when AutoArchiveIgnored is set
+const AutoArchiveRule = "auto_archive_ignored"
+
+// ActionRule applies Action during sync to the synced emails of Folder that
+// meet every criterion: the stored (or rule-based) Category, a VIP sender,
+// ignored mail, a minimum age and Conditions as in classification rules.
+// MarkRead marks the emails read before archiving, moving or starring them.
+// With DryRun the action is only logged and recorded.
+type ActionRule struct {
+	Name          string      `json:"name"`
+	Folder        string      `json:"folder,omitempty"` // Default: INBOX
+	Category      string      `json:"category,omitempty"`
+	VIP           bool        `json:"vip,omitempty"`
+	Ignored       bool        `json:"ignored,omitempty"`
+	OlderThanDays int         `json:"older_than_days,omitempty"`
+	Conditions    []Condition `json:"conditions,omitempty"`
+	Action        string      `json:"action"`
+	Destination   string      `json:"destination,omitempty"` // Folder for move_to
+	MarkRead      bool        `json:"mark_read,omitempty"`
+	DryRun        bool        `json:"dry_run,omitempty"`
+}
+
+// Condition tests one field of an email. Field is from, to, subject, body,
+// attachment_name or attachment_type, the last two tested against each
+// attachment's file name and content type; Operator is contains, equals,
+// starts_with, domain or regex. Comparisons other than regex ignore case. Any
+// lists alternative values, any of which may match. The has_attachment field
+// only takes equals with "true" or "false".
+type Condition struct {
+	Field    string   `json:"field"`
+	Operator string   `json:"operator"`
+	Value    string   `json:"value,omitempty"`
+	Any      []string `json:"any,omitempty"`
+}
+
+// Keyword adds Weight priority points to emails whose subject contains
+// Phrase, and half as many when only the body does; a negative Weight, such
+// as for "not urgent", removes points. Phrase is matched as whole words, in
+// order, ignoring case and punctuation. Within lets up to that many other
+// words come between the words of the phrase, so "reply today" with Within 2
+// matches "reply by today".
+type Keyword struct {
+	Phrase string `json:"phrase"`
+	Weight int    `json:"weight"`
+	Within int    `json:"within,omitempty"`
+}
+
+// DefaultKeywords are the urgency keywords of rules that set none
+var DefaultKeywords = []Keyword{
+	{Phrase: "urgent", Weight: 25},
+	{Phrase: "asap", Weight: 25},
+	{Phrase: "as soon as possible", Weight: 25},
+	{Phrase: "immediately", Weight: 15},
+	{Phrase: "action required", Weight: 20},
+	{Phrase: "time sensitive", Weight: 20},
+	{Phrase: "reply today", Weight: 15, Within: 2},
+	{Phrase: "urgente", Weight: 25},
+	{Phrase: "inmediato", Weight: 15},
+	{Phrase: "cuanto antes", Weight: 25},
+	{Phrase: "responde hoy", Weight: 15, Within: 2},
+	{Phrase: "not urgent", Weight: -30, Within: 1},
+	{Phrase: "no rush", Weight: -15},
+	{Phrase: "no urgente", Weight: -30, Within: 1},
+	{Phrase: "sin prisa", Weight: -15},
+	{Phrase: "unsubscribe", Weight: -10},
+	{Phrase: "darse de baja", Weight: -10},
+}
+
+// Values returns Value and Any as a single list
+func (c Condition) Values() []string {
+	if c.Value == "" {
+		return c.Any
+	}
+	return append([]string{c.Value}, c.Any...)
+}
+
+// LoadRules reads the rules at path, falling back to DefaultRules when the
+// file does not exist
+func LoadRules(path string) (*Rules, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return DefaultRules(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules %s: %w", path, err)
+	}
+
+	var rules Rules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid rules %s: %w", path, err)
+	}
+	if err := rules.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid rules %s: %w", path, err)
+	}
+	return &rules, nil
+}
+
+// SaveRules writes rules to path, replacing the file atomically
+func SaveRules(path string, rules *Rules) error {
+	data, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode rules: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to write rules %s: %w", path, err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write rules %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write rules %s: %w", path, err)
+	}
+	return nil
+}
+
+// ForAccount returns the rules that apply to accountID: the global rules
+// with the account's section merged over them. Accounts without a section,
+// and an empty accountID, get the global rules. When ignored mail is
+// archived, the AutoArchiveRule action rule is added last.
+func (r *Rules) ForAccount(accountID string) *Rules {
+	account, ok := r.Accounts[accountID]
+	if !ok || account == nil {
+		merged := &Rules{Classification: r.Classification, MatchStrategy: r.MatchStrategy, VIPSenders: r.VIPSenders,
+			IgnoredSenders: r.IgnoredSenders, IgnoredSubjects: r.IgnoredSubjects, AutoArchiveIgnored: r.AutoArchiveIgnored, UrgencyKeywords: r.UrgencyKeywords,
+			Actions: r.Actions}
+		return merged.withAutoArchive()
+	}
+
+	skip := make(map[string]bool)
+	for _, name := range account.DisabledRules {
+		skip[name] = true
+	}
+	for _, rule := range account.Classification {
+		if rule.Name != "" {
+			skip[rule.Name] = true
+		}
+	}
+
+	merged := &Rules{}
+	for _, rule := range r.Classification {
+		if !skip[rule.Name] {
+			merged.Classification = append(merged.Classification, rule)
+		}
+	}
+	merged.Classification = append(merged.Classification, account.Classification...)
+	merged.MatchStrategy = cmp.Or(account.MatchStrategy, r.MatchStrategy)
+
+	skip = make(map[string]bool)
+	for _, name := range account.DisabledRules {
+		skip[name] = true
+	}
+	for _, rule := range account.Actions {
+		skip[rule.Name] = true
+	}
+	for _, rule := range r.Actions {
+		if !skip[rule.Name] {
+			merged.Actions = append(merged.Actions, rule)
+		}
+	}
+	merged.Actions = append(merged.Actions, account.Actions...)
+	merged.VIPSenders = append(append(merged.VIPSenders, r.VIPSenders...), account.VIPSenders...)
+	merged.IgnoredSenders = append(append(merged.IgnoredSenders, r.IgnoredSenders...), account.IgnoredSenders...)
+	merged.IgnoredSubjects = append(append(merged.IgnoredSubjects, r.IgnoredSubjects...), account.IgnoredSubjects...)
+	merged.AutoArchiveIgnored = r.AutoArchiveIgnored
+	if account.AutoArchiveIgnored != nil {
+		merged.AutoArchiveIgnored = *account.AutoArchiveIgnored
+	}
+
+	merged.UrgencyKeywords = r.UrgencyKeywords
+	if len(account.UrgencyKeywords) > 0 {
+		keywords := r.UrgencyKeywords
+		if keywords == nil {
+			keywords = DefaultKeywords
+		}
+		merged.UrgencyKeywords = nil
+		for _, keyword := range keywords {
+			if !slices.ContainsFunc(account.UrgencyKeywords, func(k Keyword) bool { return strings.EqualFold(k.Phrase, keyword.Phrase) }) {
+				merged.UrgencyKeywords = append(merged.UrgencyKeywords, keyword)
+			}
+		}
+		merged.UrgencyKeywords = append(merged.UrgencyKeywords, account.UrgencyKeywords...)
+	}
+	return merged.withAutoArchive()
+}
+
+// withAutoArchive adds the AutoArchiveRule action rule to merged rules that
+// archive ignored mail
+func (r *Rules) withAutoArchive() *Rules {
+	if r.AutoArchiveIgnored {
+		r.Actions = append(slices.Clip(r.Actions), ActionRule{Name: AutoArchiveRule, Ignored: true, Action: ActionArchive, MarkRead: true})
+	}
+	return r
+}
+
+// IsIgnored reports whether mail from sender with subject is ignored: the
+// sender is one of IgnoredSenders, given as an address, a domain such as
+// example.com (subdomains included) or @example.com, or the subject
+// contains one of IgnoredSubjects. Comparisons ignore case.
+func (r *Rules) IsIgnored(sender mail.Address, subject string) bool {
+	address := strings.ToLower(sender.Address)
+	for _, entry := range r.IgnoredSenders {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if strings.Contains(entry, "@") && !strings.HasPrefix(entry, "@") {
+			if addr, err := mail.ParseAddress(entry); err == nil && sender.Equal(addr) {
+				return true
+			}
+			continue
+		}
+		domain := strings.TrimPrefix(entry, "@")
+		if address != "" && (strings.HasSuffix(address, "@"+domain) || strings.HasSuffix(address, "."+domain)) {
+			return true
+		}
+	}
+	subject = strings.ToLower(subject)
+	for _, entry := range r.IgnoredSubjects {
+		if entry = strings.ToLower(strings.TrimSpace(entry)); entry != "" && strings.Contains(subject, entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsVIP reports whether sender is one of VIPSenders. Entries are compared
+// by address, so "Name <a@b>" in the file matches a@b whatever name the
+// sender used.
+func (r *Rules) IsVIP(sender mail.Address) bool {
+	for _, vip := range r.VIPSenders {
+		addr, err := mail.ParseAddress(vip)
+		if err != nil {
+			addr = mail.Address{Address: strings.TrimSpace(vip)}
+		}
+		if sender.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetVIP adds or removes address from VIPSenders and reports whether the list
+// changed
+func (r *Rules) SetVIP(address string, vip bool) bool {
+	return setVIP(&r.VIPSenders, address, vip)
+}
+
+// SetAccountVIP is SetVIP for the VIPSenders of an account section, which is
+// created when needed
+func (r *Rules) SetAccountVIP(accountID, address string, vip bool) bool {
+	account, ok := r.Accounts[accountID]
+	if !ok || account == nil {
+		if !vip {
+			return false
+		}
+		if r.Accounts == nil {
+			r.Accounts = make(map[string]*AccountRules)
+		}
+		account = &AccountRules{}
+		r.Accounts[accountID] = account
+	}
+	return setVIP(&account.VIPSenders, address, vip)
+}
+
+func setVIP(senders *[]string, address string, vip bool) bool {
+	address = strings.ToLower(address)
+	for i, existing := range *senders {
+		if strings.ToLower(existing) != address {
+			continue
+		}
+		if !vip {
+			*senders = append((*senders)[:i], (*senders)[i+1:]...)
+		}
+		return !vip
+	}
+
+	if vip {
+		*senders = append(*senders, address)
+	}
+	return vip
+}
+
+// Validate reports rules that can never match or have unknown fields, and
+// account sections disabling rules that do not exist
+func (r *Rules) Validate() error {
+	if err := validateRules("", r.Classification); err != nil {
+		return err
+	}
+	if err := validateStrategy("", r.MatchStrategy); err != nil {
+		return err
+	}
+	if err := validateActions("", r.Actions); err != nil {
+		return err
+	}
+	if err := validateKeywords("", r.UrgencyKeywords); err != nil {
+		return err
+	}
+
+	names := make(map[string]bool)
+	for _, rule := range r.Classification {
+		names[rule.Name] = true
+	}
+	for _, rule := range r.Actions {
+		names[rule.Name] = true
+	}
+	for accountID, account := range r.Accounts {
+		if account == nil {
+			continue
+		}
+		if err := validateRules(fmt.Sprintf("account %s: ", accountID), account.Classification); err != nil {
+			return err
+		}
+		if err := validateStrategy(fmt.Sprintf("account %s: ", accountID), account.MatchStrategy); err != nil {
+			return err
+		}
+		if err := validateActions(fmt.Sprintf("account %s: ", accountID), account.Actions); err != nil {
+			return err
+		}
+		if err := validateKeywords(fmt.Sprintf("account %s: ", accountID), account.UrgencyKeywords); err != nil {
+			return err
+		}
+		for _, name := range account.DisabledRules {
+			if !names[name] {
+				return fmt.Errorf("account %s: disabled rule %q is not a classification or action rule", accountID, name)
+			}
+		}
+	}
+	return nil
+}
+
+var (
+	conditionFields = map[string]bool{"from": true, "to": true, "subject": true, "body": true,
+		"attachment_name": true, "attachment_type": true, "has_attachment": true}
+	conditionOperators = map[string]bool{"contains": true, "equals": true, "starts_with": true, "domain": true, "regex": true}
+)
+
+func validateStrategy(prefix, strategy string) error {
+	switch strategy {
+	case "", MatchFirst, MatchBestConfidence, MatchWeighted:
+		return nil
+	}
+	return fmt.Errorf("%sunknown match_strategy %q (use first_match, best_confidence or weighted)", prefix, strategy)
+}
+
+func validateKeywords(prefix string, keywords []Keyword) error {
+	for i, keyword := range keywords {
+		words := strings.FieldsFunc(keyword.Phrase, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+		if len(words) == 0 {
+			return fmt.Errorf("%surgency keyword %d: phrase must contain a word", prefix, i+1)
+		}
+		if keyword.Weight == 0 {
+			return fmt.Errorf("%surgency keyword %d (%s): weight is required", prefix, i+1, keyword.Phrase)
+		}
+		if keyword.Within < 0 {
+			return fmt.Errorf("%surgency keyword %d (%s): within must not be negative", prefix, i+1, keyword.Phrase)
+		}
+		if keyword.Within > 0 && len(words) < 2 {
+			return fmt.Errorf("%surgency keyword %d (%s): within needs a phrase of two or more words", prefix, i+1, keyword.Phrase)
+		}
+	}
+	return nil
+}
+
+func validateRules(prefix string, rules []ClassificationRule) error {
+	for i, rule := range rules {
+		if rule.Category == "" {
+			return fmt.Errorf("%sclassification rule %d (%s): category is required", prefix, i+1, rule.Name)
+		}
+		if rule.Confidence < 0 || rule.Confidence > 1 {
+			return fmt.Errorf("%sclassification rule %d (%s): confidence must be between 0 and 1", prefix, i+1, rule.Name)
+		}
+		if len(rule.Conditions) == 0 {
+			return fmt.Errorf("%sclassification rule %d (%s): at least one condition is required", prefix, i+1, rule.Name)
+		}
+		if err := validateConditions(rule.Conditions); err != nil {
+			return fmt.Errorf("%sclassification rule %d (%s): %w", prefix, i+1, rule.Name, err)
+		}
+	}
+	return nil
+}
+
+func validateActions(prefix string, rules []ActionRule) error {
+	names := make(map[string]bool)
+	for i, rule := range rules {
+		if rule.Name == "" {
+			return fmt.Errorf("%saction rule %d: name is required", prefix, i+1)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("%saction rule %d (%s): duplicate name", prefix, i+1, rule.Name)
+		}
+		if rule.Name == AutoArchiveRule {
+			return fmt.Errorf("%saction rule %d: the name %s is kept for auto_archive_ignored", prefix, i+1, rule.Name)
+		}
+		names[rule.Name] = true
+
+		switch rule.Action {
+		case ActionArchive, ActionDelete, ActionMarkRead, ActionStar:
+		case ActionMoveTo:
+			if rule.Destination == "" {
+				return fmt.Errorf("%saction rule %d (%s): move_to needs a destination", prefix, i+1, rule.Name)
+			}
+		default:
+			return fmt.Errorf("%saction rule %d (%s): unknown action %q (use archive, delete, mark_read, star or move_to)", prefix, i+1, rule.Name, rule.Action)
+		}
+		if rule.MarkRead && rule.Action != ActionArchive && rule.Action != ActionMoveTo && rule.Action != ActionStar {
+			return fmt.Errorf("%saction rule %d (%s): mark_read only goes with archive, move_to or star", prefix, i+1, rule.Name)
+		}
+		if rule.OlderThanDays < 0 {
+			return fmt.Errorf("%saction rule %d (%s): older_than_days must not be negative", prefix, i+1, rule.Name)
+		}
+		if rule.Category == "" && !rule.VIP && !rule.Ignored && rule.OlderThanDays == 0 && len(rule.Conditions) == 0 {
+			return fmt.Errorf("%saction rule %d (%s): at least one of category, vip, ignored, older_than_days or conditions is required", prefix, i+1, rule.Name)
+		}
+		if err := validateConditions(rule.Conditions); err != nil {
+			return fmt.Errorf("%saction rule %d (%s): %w", prefix, i+1, rule.Name, err)
+		}
+	}
+	return nil
+}
+
+func validateConditions(conditions []Condition) error {
+	for _, cond := range conditions {
+		if !conditionFields[cond.Field] {
+			return fmt.Errorf("unknown field %q", cond.Field)
+		}
+		if !conditionOperators[cond.Operator] {
+			return fmt.Errorf("unknown operator %q", cond.Operator)
+		}
+		if len(cond.Values()) == 0 {
+			return fmt.Errorf("condition on %s has no value", cond.Field)
+		}
+		if cond.Operator == "regex" {
+			for _, value := range cond.Values() {
+				if _, err := CompileRegex(value); err != nil {
+					return fmt.Errorf("condition on %s: %w", cond.Field, err)
+				}
+			}
+		}
+		if cond.Field == "has_attachment" {
+			if cond.Operator != "equals" {
+				return fmt.Errorf("condition on has_attachment must use equals")
+			}
+			for _, value := range cond.Values() {
+				if value != "true" && value != "false" {
+					return fmt.Errorf("condition on has_attachment takes true or false, not %q", value)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// DefaultRules returns a small built-in rule set covering common categories
+func DefaultRules() *Rules {
+	return &Rules{Classification: []ClassificationRule{
+		{
+			Name: "invoices", Category: "invoice", Confidence: 0.85, Tags: []string{"finance"},
+			Conditions: []Condition{{Field: "subject", Operator: "contains", Any: []string{"invoice", "factura", "receipt", "recibo"}}},
+		},
+		{
+			Name: "invoice_attachments", Category: "invoice", Confidence: 0.8, Tags: []string{"finance"},
+			Conditions: []Condition{{Field: "attachment_name", Operator: "contains", Any: []string{"invoice", "factura", "receipt", "recibo"}}},
+		},
+		{
+			Name: "orders", Category: "order", Confidence: 0.8, Tags: []string{"shopping"},
+			Conditions: []Condition{{Field: "subject", Operator: "contains", Any: []string{"order confirmation", "your order", "has shipped",
+				"out for delivery", "has been delivered", "tracking number", "tu pedido", "su pedido", "confirmación de pedido", "envío"}}},
+		},
+		{
+			Name: "travel", Category: "travel", Confidence: 0.8, Tags: []string{"travel"},
+			Conditions: []Condition{{Field: "subject", Operator: "contains", Any: []string{"flight", "itinerary", "boarding pass", "check-in",
+				"e-ticket", "vuelo", "tarjeta de embarque", "itinerario"}}},
+		},
+		{
+			Name: "newsletters", Category: "newsletter", Confidence: 0.8,
+			Conditions: []Condition{{Field: "body", Operator: "contains", Any: []string{"unsubscribe", "darse de baja", "darte de baja"}}},
+		},
+		{
+			Name: "promotions", Category: "promotions", Confidence: 0.6,
+			Conditions: []Condition{{Field: "subject", Operator: "contains", Any: []string{"% off", "sale", "discount", "oferta", "descuento"}}},
+		},
+		{
+			Name: "notifications", Category: "notification", Confidence: 0.7,
+			Conditions: []Condition{{Field: "from", Operator: "contains", Any: []string{"noreply", "no-reply", "notifications@", "notification@"}}},
+		},
+		{
+			Name: "social", Category: "social", Confidence: 0.75,
+			Conditions: []Condition{{Field: "from", Operator: "domain", Any: []string{"facebookmail.com", "linkedin.com", "twitter.com", "x.com", "instagram.com"}}},
+		},
+		{
+			Name: "meetings", Category: "meeting", Confidence: 0.65,
+			Conditions: []Condition{{Field: "subject", Operator: "contains", Any: []string{"meeting", "invitation:", "reunión", "invitación:"}}},
+		},
+	}}
+}
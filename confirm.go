@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"email-mcp-server/mail"
+	"email-mcp-server/storage"
+)
+
+// With CONFIRM_DESTRUCTIVE=true, delete_email, delete_folder, bulk_action,
+// unsubscribe, and send_email, send_draft, send_from_template and
+// schedule_email to a domain other than the account's own do nothing on the
+// first call. They return a description of what they would do and a one-time
+// token, and only a confirm_action call with that token within
+// CONFIRM_TOKEN_MINUTES runs them, with the arguments of the first call.
+// Recipients and query selections are resolved when the token is issued, so
+// the confirmed call acts on exactly what was described, and a draft edited
+// after that is not sent.
+
+// pendingAction is a destructive tool call waiting for confirm_action
+type pendingAction struct {
+	Tool    string
+	Args    map[string]interface{}
+	Summary string
+	Expires time.Time
+}
+
+// confirmPreparer decides whether a call needs confirmation. It returns a
+// description of what the call would do, empty when no confirmation is
+// needed, and the arguments the confirmed call runs with.
+type confirmPreparer func(ctx context.Context, args map[string]interface{}) (summary string, confirmed map[string]interface{}, err error)
+
+// confirmedKey marks the context of a call made by confirm_action
+type confirmedKey struct{}
+
+// confirmed wraps the handler of a destructive tool so that, in safety mode,
+// calls prepare says need confirmation wait for confirm_action
+func (es *EmailServer) confirmed(tool string, prepare confirmPreparer, handler ToolHandler) ToolHandler {
+	return func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+		if !es.confirmDestructive || ctx.Value(confirmedKey{}) != nil {
+			return handler(ctx, args)
+		}
+		summary, confirmedArgs, err := prepare(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		if summary == "" {
+			return handler(ctx, args)
+		}
+
+		token, err := es.addPendingAction(&pendingAction{
+			Tool:    tool,
+			Args:    confirmedArgs,
+			Summary: summary,
+			Expires: time.Now().Add(es.confirmTTL),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return ToolResult{
+			Content: []TextContent{{
+				Type: "text",
+				Text: fmt.Sprintf("Confirmation required, nothing was done yet: %s\n\nAsk the user, then call confirm_action with token %q within %v to proceed.",
+					summary, token, es.confirmTTL),
+			}},
+		}, nil
+	}
+}
+
+// addPendingAction stores action under a new token, dropping expired ones
+func (es *EmailServer) addPendingAction(action *pendingAction) (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to create confirmation token: %w", err)
+	}
+	token := hex.EncodeToString(b)
+
+	es.pendingMu.Lock()
+	defer es.pendingMu.Unlock()
+	now := time.Now()
+	for t, pending := range es.pending {
+		if now.After(pending.Expires) {
+			delete(es.pending, t)
+		}
+	}
+	es.pending[token] = action
+	return token, nil
+}
+
+// takePendingAction removes and returns the action of token
+func (es *EmailServer) takePendingAction(token string) (*pendingAction, error) {
+	es.pendingMu.Lock()
+	defer es.pendingMu.Unlock()
+
+	action, ok := es.pending[token]
+	if !ok {
+		return nil, fmt.Errorf("unknown confirmation token %q: it was already used or never issued", token)
+	}
+	delete(es.pending, token)
+	if time.Now().After(action.Expires) {
+		return nil, fmt.Errorf("confirmation token %q expired, call %s again", token, action.Tool)
+	}
+	return action, nil
+}
+
+func (es *EmailServer) handleConfirmAction(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	token, _ := args["token"].(string)
+	if token == "" {
+		return nil, fmt.Errorf("%w: token", errMissingParameter)
+	}
+	action, err := es.takePendingAction(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if cancel, _ := args["cancel"].(bool); cancel {
+		return ToolResult{
+			Content: []TextContent{{
+				Type: "text",
+				Text: "Cancelled: " + action.Summary,
+			}},
+		}, nil
+	}
+
+	// Audited as the confirmed tool, with the arguments it ran with
+	params := ToolCallParams{Name: action.Tool, Arguments: action.Args}
+	start := time.Now()
+	result, err := es.tools.Call(context.WithValue(ctx, confirmedKey{}, true), params)
+	es.auditToolCall(params, result, start, err)
+	return result, err
+}
+
+func (es *EmailServer) prepareDeleteEmail(ctx context.Context, args map[string]interface{}) (string, map[string]interface{}, error) {
+	accountID, _ := args["account"].(string)
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return "", nil, err
+	}
+	id, ok := args["id"].(float64)
+	if !ok {
+		return "", nil, errInvalidEmailID
+	}
+	folder, _ := args["folder"].(string)
+	if folder == "" {
+		folder = "INBOX"
+	}
+
+	summary := fmt.Sprintf("delete email ID %d in %s of %s", uint32(id), folder, config.ID)
+	if es.db != nil {
+		if email, err := es.db.GetEmail(config.ID, folder, uint32(id)); err == nil && email != nil {
+			summary += fmt.Sprintf(" (%q from %s)", email.Subject, email.From)
+		}
+	}
+	return summary, withArg(args, "account", config.ID), nil
+}
+
+func (es *EmailServer) prepareGetVerificationCode(ctx context.Context, args map[string]interface{}) (string, map[string]interface{}, error) {
+	if deleteAfter, _ := args["delete"].(bool); !deleteAfter {
+		return "", nil, nil
+	}
+	accountID, _ := args["account"].(string)
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("read the newest verification code in INBOX of %s and delete its email", config.ID), withArg(args, "account", config.ID), nil
+}
+
+func (es *EmailServer) prepareBulkAction(ctx context.Context, args map[string]interface{}) (string, map[string]interface{}, error) {
+	if dryRun, _ := args["dry_run"].(bool); dryRun {
+		return "", nil, nil
+	}
+	accountID, _ := args["account"].(string)
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return "", nil, err
+	}
+	folder, _ := args["folder"].(string)
+	if folder == "" {
+		folder = "INBOX"
+	}
+	targets, err := es.bulkTargets(config.ID, folder, args)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(targets) == 0 {
+		return "", nil, fmt.Errorf("no emails selected: pass ids or a query matching synced emails")
+	}
+
+	// The confirmed call acts on these emails even if the query would
+	// select others by then
+	ids := make([]interface{}, len(targets))
+	for i, target := range targets {
+		ids[i] = float64(target.ID)
+	}
+	confirmedArgs := withArg(args, "account", config.ID)
+	delete(confirmedArgs, "query")
+	confirmedArgs["ids"] = ids
+
+	action, _ := args["action"].(string)
+	destination, _ := args["destination"].(string)
+	if action == "delete" && config.movesToTrash(folder) {
+		destination = config.TrashFolder
+	}
+	targetsJSON, _ := json.MarshalIndent(targets, "", "  ")
+	summary := fmt.Sprintf("%s %d emails in %s of %s:\n%s",
+		describeBulkAction(action, destination), len(targets), folder, config.ID, string(targetsJSON))
+	return summary, confirmedArgs, nil
+}
+
+func (es *EmailServer) prepareSendEmail(ctx context.Context, args map[string]interface{}) (string, map[string]interface{}, error) {
+	accountID, _ := args["account"].(string)
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return "", nil, err
+	}
+	to, cc, bcc, err := es.recipientArgs(args)
+	if err != nil {
+		return "", nil, err
+	}
+	external, err := externalRecipients(config, to, cc, bcc)
+	if len(external) == 0 || err != nil {
+		return "", nil, err
+	}
+
+	subject, _ := args["subject"].(string)
+	summary := fmt.Sprintf("send %q from %s to external recipients %s", subject, config.ID, strings.Join(external, ", "))
+	return summary, withRecipients(args, config, to, cc, bcc), nil
+}
+
+func (es *EmailServer) prepareSendFromTemplate(ctx context.Context, args map[string]interface{}) (string, map[string]interface{}, error) {
+	accountID, _ := args["account"].(string)
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return "", nil, err
+	}
+	to, cc, bcc, err := es.recipientArgs(args)
+	if err != nil {
+		return "", nil, err
+	}
+	external, err := externalRecipients(config, to, cc, bcc)
+	if len(external) == 0 || err != nil {
+		return "", nil, err
+	}
+
+	name, _ := args["name"].(string)
+	summary := fmt.Sprintf("send template %q from %s to external recipients %s", name, config.ID, strings.Join(external, ", "))
+	return summary, withRecipients(args, config, to, cc, bcc), nil
+}
+
+func (es *EmailServer) prepareScheduleEmail(ctx context.Context, args map[string]interface{}) (string, map[string]interface{}, error) {
+	accountID, _ := args["account"].(string)
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return "", nil, err
+	}
+	to, cc, bcc, err := es.recipientArgs(args)
+	if err != nil {
+		return "", nil, err
+	}
+	external, err := externalRecipients(config, to, cc, bcc)
+	if len(external) == 0 || err != nil {
+		return "", nil, err
+	}
+
+	subject, _ := args["subject"].(string)
+	sendAt, _ := args["send_at"].(string)
+	summary := fmt.Sprintf("schedule %q from %s to external recipients %s for %s", subject, config.ID, strings.Join(external, ", "), sendAt)
+	return summary, withRecipients(args, config, to, cc, bcc), nil
+}
+
+func (es *EmailServer) prepareSendDraft(ctx context.Context, args map[string]interface{}) (string, map[string]interface{}, error) {
+	draft, err := es.draftFromArgs(args)
+	if err != nil {
+		return "", nil, err
+	}
+	config, err := es.getConfig(draft.AccountID)
+	if err != nil {
+		return "", nil, err
+	}
+	external, err := externalRecipients(config, draft.To, draft.Cc, draft.Bcc)
+	if len(external) == 0 || err != nil {
+		return "", nil, err
+	}
+
+	// The draft is loaded again when confirmed; the digest makes that call
+	// refuse a draft edited in between
+	summary := fmt.Sprintf("send draft %d %q from %s to external recipients %s", draft.ID, draft.Subject, config.ID, strings.Join(external, ", "))
+	return summary, withArg(args, "draft_digest", draftDigest(draft)), nil
+}
+
+// draftDigest identifies the account, recipients, subject and body of draft
+func draftDigest(draft *storage.Draft) string {
+	data, _ := json.Marshal([]interface{}{draft.AccountID, draft.To, draft.Cc, draft.Bcc, draft.Subject, draft.Body})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (es *EmailServer) prepareDeleteFolder(ctx context.Context, args map[string]interface{}) (string, map[string]interface{}, error) {
+	accountID, _ := args["account"].(string)
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return "", nil, err
+	}
+	name, _ := args["name"].(string)
+	if name == "" {
+		return "", nil, fmt.Errorf("%w: name", errMissingParameter)
+	}
+
+	summary := fmt.Sprintf("delete folder %s of %s and every email in it", name, config.ID)
+	return summary, withArg(args, "account", config.ID), nil
+}
+
+func (es *EmailServer) prepareUnsubscribe(ctx context.Context, args map[string]interface{}) (string, map[string]interface{}, error) {
+	method, _ := args["method"].(string)
+	if method == "" {
+		// Only lists the options
+		return "", nil, nil
+	}
+	accountID, _ := args["account"].(string)
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return "", nil, err
+	}
+	folder, _ := args["folder"].(string)
+	id, ok := args["id"].(float64)
+	if !ok {
+		return "", nil, fmt.Errorf("%w: id", errMissingParameter)
+	}
+
+	headers, err := es.getEmailHeaders(ctx, config.ID, folder, uint32(id))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get email: %w", err)
+	}
+	options := mail.ParseListUnsubscribe(headers.Header("List-Unsubscribe"), headers.Header("List-Unsubscribe-Post"))
+	for _, option := range options {
+		switch {
+		case method == "mailto" && option.Method == "mailto":
+			return fmt.Sprintf("send an unsubscribe email from %s to %s for email ID %d (%q)", config.ID, option.Address, uint32(id), headers.Header("Subject")),
+				withArg(args, "account", config.ID), nil
+		case method == "one_click" && option.OneClick:
+			return fmt.Sprintf("send a one-click unsubscribe request to %s for email ID %d (%q)", option.URL, uint32(id), headers.Header("Subject")),
+				withArg(args, "account", config.ID), nil
+		}
+	}
+	// The handler reports the missing option
+	return "", nil, nil
+}
+
+// externalRecipients returns the sorted addresses of to, cc and bcc outside
+// the account's own domain and CONFIRM_TRUSTED_DOMAINS
+func externalRecipients(config *EmailConfig, to, cc, bcc []string) ([]string, error) {
+	own, _ := mail.ParseAddress(config.Username)
+	trusted := map[string]bool{own.Domain(): true}
+	for _, domain := range getEnvList("CONFIRM_TRUSTED_DOMAINS") {
+		trusted[strings.ToLower(domain)] = true
+	}
+	external := map[string]bool{}
+	for _, recipient := range append(append(append([]string{}, to...), cc...), bcc...) {
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return nil, err
+		}
+		if !trusted[address.Domain()] {
+			external[address.Address] = true
+		}
+	}
+
+	recipients := make([]string, 0, len(external))
+	for address := range external {
+		recipients = append(recipients, address)
+	}
+	sort.Strings(recipients)
+	return recipients, nil
+}
+
+// withRecipients returns a copy of args with the account and the resolved
+// recipients, so the confirmed call cannot reach anyone else
+func withRecipients(args map[string]interface{}, config *EmailConfig, to, cc, bcc []string) map[string]interface{} {
+	confirmedArgs := withArg(args, "account", config.ID)
+	for key, list := range map[string][]string{"to": to, "cc": cc, "bcc": bcc} {
+		values := make([]interface{}, len(list))
+		for i, recipient := range list {
+			values[i] = recipient
+		}
+		confirmedArgs[key] = values
+	}
+	return confirmedArgs
+}
+
+// withArg returns a copy of args with key set to value
+func withArg(args map[string]interface{}, key string, value interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(args)+1)
+	for k, v := range args {
+		out[k] = v
+	}
+	out[key] = value
+	return out
+}
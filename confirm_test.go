@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"email-mcp-server/storage"
+)
+
+// newConfirmServer returns a server in safety mode with a send_email tool
+// that counts its calls
+func newConfirmServer(t *testing.T, calls *int) *EmailServer {
+	t.Helper()
+	t.Setenv("CONFIRM_TRUSTED_DOMAINS", "partner.com")
+	es := &EmailServer{
+		configs:            []EmailConfig{{ID: "work", Username: "me@corp.com"}},
+		defaultAccount:     "work",
+		tools:              NewToolRegistry(),
+		confirmDestructive: true,
+		confirmTTL:         time.Minute,
+		pending:            make(map[string]*pendingAction),
+	}
+	send := func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+		*calls++
+		return ToolResult{Content: []TextContent{{Type: "text", Text: "sent"}}}, nil
+	}
+	es.tools.Register(Tool{Name: "send_email", InputSchema: map[string]interface{}{"type": "object"}},
+		es.confirmed("send_email", es.prepareSendEmail, send))
+	return es
+}
+
+// issueToken calls send_email to an external recipient and returns the token
+func issueToken(t *testing.T, es *EmailServer) string {
+	t.Helper()
+	result, err := es.tools.Call(context.Background(), ToolCallParams{Name: "send_email", Arguments: map[string]interface{}{
+		"to": []interface{}{"ana@example.com"}, "subject": "Hi", "body": "Hello",
+	}})
+	if err != nil {
+		t.Fatalf("send_email: %v", err)
+	}
+	text := result.(ToolResult).Content[0].Text
+	if !strings.HasPrefix(text, "Confirmation required") || !strings.Contains(text, "ana@example.com") {
+		t.Fatalf("expected a confirmation request, got %q", text)
+	}
+	_, rest, _ := strings.Cut(text, `token "`)
+	token, _, _ := strings.Cut(rest, `"`)
+	if len(token) != 24 {
+		t.Fatalf("no token in %q", text)
+	}
+	return token
+}
+
+func TestConfirmTokenIssueAndConfirm(t *testing.T) {
+	calls := 0
+	es := newConfirmServer(t, &calls)
+
+	// Recipients in the account's domain or a trusted one need no token
+	for _, to := range []string{"boss@corp.com", "bob@partner.com"} {
+		if _, err := es.tools.Call(context.Background(), ToolCallParams{Name: "send_email", Arguments: map[string]interface{}{"to": to}}); err != nil {
+			t.Fatalf("send_email: %v", err)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("internal sends ran %d times, want 2", calls)
+	}
+
+	token := issueToken(t, es)
+	if calls != 2 {
+		t.Fatal("send_email ran before confirmation")
+	}
+	if action := es.pending[token]; action == nil || action.Args["account"] != "work" {
+		t.Fatalf("pending action = %+v", action)
+	}
+
+	if _, err := es.handleConfirmAction(context.Background(), map[string]interface{}{"token": token}); err != nil {
+		t.Fatalf("confirm_action: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("confirmed send ran %d times, want once", calls-2)
+	}
+
+	// Tokens are single use
+	if _, err := es.handleConfirmAction(context.Background(), map[string]interface{}{"token": token}); err == nil {
+		t.Error("a used token was accepted again")
+	}
+}
+
+func TestConfirmTokenExpiry(t *testing.T) {
+	calls := 0
+	es := newConfirmServer(t, &calls)
+	token := issueToken(t, es)
+	es.pending[token].Expires = time.Now().Add(-time.Second)
+
+	_, err := es.handleConfirmAction(context.Background(), map[string]interface{}{"token": token})
+	if err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expected an expired token error, got %v", err)
+	}
+	if calls != 0 {
+		t.Error("an expired token ran the call")
+	}
+
+	// Issuing a new token drops expired ones
+	es.pending[token] = &pendingAction{Tool: "send_email", Expires: time.Now().Add(-time.Second)}
+	issueToken(t, es)
+	if _, ok := es.pending[token]; ok {
+		t.Error("expired token was not dropped")
+	}
+}
+
+func TestConfirmTokenMismatch(t *testing.T) {
+	calls := 0
+	es := newConfirmServer(t, &calls)
+	token := issueToken(t, es)
+
+	for _, wrong := range []string{"", "0123456789abcdef01234567", strings.ToUpper(token)} {
+		if _, err := es.handleConfirmAction(context.Background(), map[string]interface{}{"token": wrong}); err == nil {
+			t.Errorf("token %q was accepted", wrong)
+		}
+	}
+	if calls != 0 {
+		t.Error("a wrong token ran the call")
+	}
+
+	// The issued token is still valid, and cancel drops it without running
+	if _, err := es.handleConfirmAction(context.Background(), map[string]interface{}{"token": token, "cancel": true}); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if _, ok := es.pending[token]; ok || calls != 0 {
+		t.Errorf("cancel left the token or ran the call (%d calls)", calls)
+	}
+}
+
+func TestConfirmWrapsDestructiveTools(t *testing.T) {
+	calls := 0
+	es := newConfirmServer(t, &calls)
+	es.tools = es.registerTools()
+
+	for name, args := range map[string]map[string]interface{}{
+		"delete_folder":      {"name": "Old"},
+		"send_from_template": {"name": "welcome", "to": "ana@example.com"},
+		"schedule_email":     {"to": "ana@example.com", "subject": "Hi", "body": "Hello", "send_at": "2030-01-01T09:00:00Z"},
+	} {
+		result, err := es.tools.Call(context.Background(), ToolCallParams{Name: name, Arguments: args})
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if text := result.(ToolResult).Content[0].Text; !strings.HasPrefix(text, "Confirmation required") {
+			t.Errorf("%s ran without confirmation: %q", name, text)
+		}
+	}
+	if len(es.pending) != 3 {
+		t.Errorf("%d pending actions, want 3", len(es.pending))
+	}
+}
+
+func TestConfirmSendDraftRejectsEditedDraft(t *testing.T) {
+	es := newTestServer(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	server := startFakeSMTP(t, listener)
+	config := smtpAccount(listener)
+	es.configs = []EmailConfig{*config}
+	es.confirmDestructive = true
+	es.confirmTTL = time.Minute
+	es.tools = es.registerTools()
+
+	draft := &storage.Draft{AccountID: "work", To: []string{"ana@example.com"}, Subject: "Hi", Body: "Hello"}
+	if err := es.db.CreateDraft(draft); err != nil {
+		t.Fatalf("CreateDraft: %v", err)
+	}
+	sendDraft := func() string {
+		t.Helper()
+		result, err := es.tools.Call(context.Background(), ToolCallParams{Name: "send_draft", Arguments: map[string]interface{}{"draft_id": float64(draft.ID)}})
+		if err != nil {
+			t.Fatalf("send_draft: %v", err)
+		}
+		_, rest, _ := strings.Cut(result.(ToolResult).Content[0].Text, `token "`)
+		token, _, _ := strings.Cut(rest, `"`)
+		return token
+	}
+
+	// Recipients added after the token was issued are not reached
+	token := sendDraft()
+	draft.Bcc = []string{"eve@example.net"}
+	if err := es.db.UpdateDraft(draft); err != nil {
+		t.Fatalf("UpdateDraft: %v", err)
+	}
+	_, err = es.handleConfirmAction(context.Background(), map[string]interface{}{"token": token})
+	if err == nil || !strings.Contains(err.Error(), "changed after confirmation") {
+		t.Fatalf("confirm_action of an edited draft = %v, want a changed draft error", err)
+	}
+	if commands, _ := server.received(); len(commands) != 0 {
+		t.Fatalf("edited draft was sent: %q", commands)
+	}
+
+	// A new token for the draft as it is now sends it
+	token = sendDraft()
+	if _, err := es.handleConfirmAction(context.Background(), map[string]interface{}{"token": token}); err != nil {
+		t.Fatalf("confirm_action: %v", err)
+	}
+	if commands, _ := server.received(); !slices.Contains(commands, "RCPT TO:<eve@example.net>") {
+		t.Errorf("commands = %q, want the current draft sent", commands)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"email-mcp-server/mail"
+	"email-mcp-server/storage"
+)
+
+// Contacts are collected by the storage layer from the sender and
+// recipients of every synced email. Recipients given to the sending tools as
+// a name rather than an address are looked up among them.
+
+func (es *EmailServer) handleSearchContacts(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("contacts are not available: %w", errNoDatabase)
+	}
+
+	query, _ := args["query"].(string)
+	limit := 10
+	if l, ok := args["limit"].(float64); ok && l > 0 {
+		limit = int(l)
+	}
+
+	offset, err := offsetArg(args)
+	if err != nil {
+		return nil, err
+	}
+
+	contacts, more, err := es.searchContacts(query, offset, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search contacts: %w", err)
+	}
+
+	var b strings.Builder
+	if query == "" {
+		fmt.Fprintf(&b, "%d contacts:\n", len(contacts))
+	} else {
+		fmt.Fprintf(&b, "%d contacts matching %q:\n", len(contacts), query)
+	}
+	for _, c := range contacts {
+		var addresses []string
+		for _, a := range c.Addresses {
+			addresses = append(addresses, a.Address)
+		}
+		name := c.Name
+		if name == "" {
+			name = "(no name)"
+		}
+		fmt.Fprintf(&b, "\n#%d %s <%s>\n   %d from them, %d as recipient, last seen %s",
+			c.ID, name, strings.Join(addresses, ">, <"), c.ReceivedCount, c.RecipientCount, c.LastSeen.Local().Format("2006-01-02"))
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: withNextCursor(b.String(), nextOffsetCursor(offset, limit, more)),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleGetContact(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("contacts are not available: %w", errNoDatabase)
+	}
+
+	var contact *storage.Contact
+	var err error
+	if id, ok := args["id"].(float64); ok {
+		contact, err = es.db.GetContact(int64(id))
+	} else if address, _ := args["address"].(string); address != "" {
+		contact, err = es.db.ContactByAddress(address)
+	} else {
+		return nil, invalidArgument("missing required parameters: id or address")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get contact: %w", err)
+	}
+	if contact == nil {
+		return nil, fmt.Errorf("contact %w", errNotFound)
+	}
+
+	limit := 10
+	if l, ok := args["recent_limit"].(float64); ok && l >= 0 {
+		limit = int(l)
+	}
+	recent, err := es.db.ContactEmails(contact, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get contact emails: %w", err)
+	}
+
+	vip := false
+	for _, a := range contact.Addresses {
+		vip = vip || es.isVIP("", mail.Address{Address: a.Address})
+	}
+
+	type recentEmail struct {
+		Account string `json:"account"`
+		ID      uint32 `json:"id"`
+		Subject string `json:"subject"`
+		Date    string `json:"date"`
+	}
+	result := struct {
+		*storage.Contact
+		VIP    bool          `json:"vip"`
+		Recent []recentEmail `json:"recent_emails,omitempty"`
+	}{Contact: contact, VIP: vip}
+	for _, e := range recent {
+		result.Recent = append(result.Recent, recentEmail{Account: e.AccountID, ID: e.UID, Subject: e.Subject, Date: e.Date.Format("2006-01-02 15:04")})
+	}
+
+	resultJSON, _ := json.MarshalIndent(result, "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: string(resultJSON),
+		}},
+	}, nil
+}
+
+// searchContacts searches the address book, leaving out the configured
+// accounts, which appear as the recipient of every synced email. It returns
+// limit contacts after the first offset, and whether more follow.
+func (es *EmailServer) searchContacts(query string, offset, limit int) ([]storage.Contact, bool, error) {
+	own := make(map[string]bool)
+	for _, config := range es.accounts() {
+		own[strings.ToLower(config.Username)] = true
+	}
+
+	// Ask for enough extra rows to fill the page after filtering, plus one
+	// to tell whether there is a next page
+	contacts, err := es.db.SearchContacts(query, offset+limit+1+len(own))
+	if err != nil {
+		return nil, false, err
+	}
+
+	var result []storage.Contact
+	for _, c := range contacts {
+		mine := len(c.Addresses) > 0
+		for _, a := range c.Addresses {
+			mine = mine && own[a.Address]
+		}
+		if !mine {
+			result = append(result, c)
+		}
+	}
+	if offset >= len(result) {
+		return nil, false, nil
+	}
+	result = result[offset:]
+	if len(result) > limit {
+		return result[:limit], true, nil
+	}
+	return result, false, nil
+}
+
+// recipientArgs reads the to, cc and bcc arguments of the sending tools,
+// resolving contact names
+func (es *EmailServer) recipientArgs(args map[string]interface{}) (to, cc, bcc []string, err error) {
+	if to, err = es.resolveRecipients(parseRecipients(args["to"])); err != nil {
+		return nil, nil, nil, err
+	}
+	if cc, err = es.resolveRecipients(parseRecipients(args["cc"])); err != nil {
+		return nil, nil, nil, err
+	}
+	if bcc, err = es.resolveRecipients(parseRecipients(args["bcc"])); err != nil {
+		return nil, nil, nil, err
+	}
+	return to, cc, bcc, nil
+}
+
+// resolveRecipients replaces each recipient without an @, such as "ana" or
+// "Ana Pérez", with the main address of the contact it names. A name
+// matching several contacts is an error listing them, unless exactly one
+// has that full name.
+func (es *EmailServer) resolveRecipients(recipients []string) ([]string, error) {
+	resolved := make([]string, 0, len(recipients))
+	for _, recipient := range recipients {
+		if strings.Contains(recipient, "@") {
+			resolved = append(resolved, recipient)
+			continue
+		}
+		if es.db == nil {
+			return nil, fmt.Errorf("%q is not an email address", recipient)
+		}
+
+		contacts, _, err := es.searchContacts(recipient, 0, 5)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up %q: %w", recipient, err)
+		}
+		if len(contacts) > 1 {
+			var exact []storage.Contact
+			for _, c := range contacts {
+				if strings.EqualFold(c.Name, recipient) {
+					exact = append(exact, c)
+				}
+			}
+			if len(exact) == 1 {
+				contacts = exact
+			}
+		}
+
+		switch len(contacts) {
+		case 0:
+			return nil, fmt.Errorf("%q is not an email address and matches no contact", recipient)
+		case 1:
+			resolved = append(resolved, contacts[0].Addresses[0].Address)
+		default:
+			var candidates []string
+			for _, c := range contacts {
+				candidates = append(candidates, fmt.Sprintf("%s <%s>", c.Name, c.Addresses[0].Address))
+			}
+			return nil, fmt.Errorf("%q matches several contacts (%s); use an email address", recipient, strings.Join(candidates, ", "))
+		}
+	}
+	return resolved, nil
+}
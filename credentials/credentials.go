@@ -0,0 +1,87 @@
+// Package credentials keeps account passwords out of email_config.json. Each
+// account names a Store: the OS keychain, an environment variable, or a file
+// encrypted with a passphrase.
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Password sources
+const (
+	SourcePlain   = "plain"   // Password field of email_config.json
+	SourceKeyring = "keyring" // OS keychain, Windows Credential Manager or Secret Service
+	SourceEnv     = "env"     // environment variable named by the account
+	SourceFile    = "file"    // AES-GCM encrypted file
+)
+
+// ErrNotFound is returned when a store has no password for an account
+var ErrNotFound = errors.New("password not found")
+
+// Store reads and writes account passwords
+type Store interface {
+	Get(account string) (string, error)
+	Set(account, password string) error
+	Delete(account string) error
+}
+
+// Options configure the stores
+type Options struct {
+	KeyringService string // service name in the OS keychain
+	FilePath       string // encrypted credentials file
+	Passphrase     string // passphrase of the credentials file
+}
+
+// OptionsFromEnv reads CREDENTIALS_FILE and CREDENTIALS_PASSPHRASE
+func OptionsFromEnv() Options {
+	opts := Options{
+		KeyringService: "email-mcp-server",
+		FilePath:       os.Getenv("CREDENTIALS_FILE"),
+		Passphrase:     os.Getenv("CREDENTIALS_PASSPHRASE"),
+	}
+	if opts.FilePath == "" {
+		opts.FilePath = "credentials.enc"
+	}
+	return opts
+}
+
+// Open returns the store for source. envVar is the variable read by the env
+// source.
+func Open(source, envVar string, opts Options) (Store, error) {
+	switch source {
+	case SourceKeyring:
+		return &keyringStore{service: opts.KeyringService}, nil
+	case SourceEnv:
+		if envVar == "" {
+			return nil, fmt.Errorf("the env password source needs the name of a variable")
+		}
+		return envStore(envVar), nil
+	case SourceFile:
+		if opts.Passphrase == "" {
+			return nil, fmt.Errorf("the file password source needs CREDENTIALS_PASSPHRASE")
+		}
+		return NewFileStore(opts.FilePath, opts.Passphrase), nil
+	}
+	return nil, fmt.Errorf("unknown password source %q (use plain, keyring, env or file)", source)
+}
+
+// envStore reads a password from an environment variable. It is read-only:
+// the variable is set outside the server.
+type envStore string
+
+func (e envStore) Get(account string) (string, error) {
+	if value := os.Getenv(string(e)); value != "" {
+		return value, nil
+	}
+	return "", fmt.Errorf("%w: environment variable %s is not set", ErrNotFound, string(e))
+}
+
+func (e envStore) Set(account, password string) error {
+	return fmt.Errorf("passwords from environment variables cannot be changed by the server; set %s instead", string(e))
+}
+
+func (e envStore) Delete(account string) error {
+	return nil
+}
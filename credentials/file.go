@@ -0,0 +1,170 @@
+package credentials
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// pbkdf2Iterations follows the OWASP recommendation for PBKDF2-HMAC-SHA256
+const pbkdf2Iterations = 600000
+
+// FileStore keeps every password in one file, encrypted with AES-256-GCM
+// under a key derived from a passphrase with PBKDF2
+type FileStore struct {
+	path       string
+	passphrase string
+	mu         sync.Mutex
+}
+
+// encryptedFile is the on-disk format
+type encryptedFile struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// NewFileStore opens the credentials file at path; it is created on the first
+// Set
+func NewFileStore(path, passphrase string) *FileStore {
+	return &FileStore{path: path, passphrase: passphrase}
+}
+
+func (f *FileStore) Get(account string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	passwords, err := f.load()
+	if err != nil {
+		return "", err
+	}
+	password, ok := passwords[account]
+	if !ok {
+		return "", fmt.Errorf("%w: no entry for %s in %s", ErrNotFound, account, f.path)
+	}
+	return password, nil
+}
+
+func (f *FileStore) Set(account, password string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	passwords, err := f.load()
+	if err != nil {
+		return err
+	}
+	passwords[account] = password
+	return f.save(passwords)
+}
+
+func (f *FileStore) Delete(account string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	passwords, err := f.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := passwords[account]; !ok {
+		return nil
+	}
+	delete(passwords, account)
+	return f.save(passwords)
+}
+
+func (f *FileStore) load() (map[string]string, error) {
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return make(map[string]string), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", f.path, err)
+	}
+
+	var file encryptedFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid credentials file %s: %w", f.path, err)
+	}
+	if file.Version != 1 {
+		return nil, fmt.Errorf("unsupported credentials file version %d", file.Version)
+	}
+
+	gcm, err := NewCipher(f.passphrase, file.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, file.Nonce, file.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: wrong CREDENTIALS_PASSPHRASE or corrupted file", f.path)
+	}
+
+	passwords := make(map[string]string)
+	if err := json.Unmarshal(plaintext, &passwords); err != nil {
+		return nil, fmt.Errorf("invalid credentials file %s: %w", f.path, err)
+	}
+	return passwords, nil
+}
+
+// save encrypts passwords with a fresh salt and nonce and replaces the file
+// atomically
+func (f *FileStore) save(passwords map[string]string) error {
+	plaintext, err := json.Marshal(passwords)
+	if err != nil {
+		return fmt.Errorf("failed to encode credentials: %w", err)
+	}
+
+	file := encryptedFile{Version: 1, Salt: make([]byte, 16)}
+	if _, err := rand.Read(file.Salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+	gcm, err := NewCipher(f.passphrase, file.Salt)
+	if err != nil {
+		return err
+	}
+	file.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	file.Ciphertext = gcm.Seal(nil, file.Nonce, plaintext, nil)
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode credentials: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o700); err != nil {
+		return fmt.Errorf("failed to write %s: %w", f.path, err)
+	}
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", f.path, err)
+	}
+	if err := os.Rename(tmp, f.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", f.path, err)
+	}
+	return nil
+}
+
+// NewCipher returns the AES-256-GCM cipher under the key derived from
+// passphrase and salt with PBKDF2-HMAC-SHA256. The credentials file and the
+// encrypted database both use it.
+func NewCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, pbkdf2Iterations, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,38 @@
+package credentials
+
+import (
+	"fmt"
+
+	"github.com/zalando/go-keyring"
+)
+
+// keyringStore keeps passwords in the OS keychain (macOS Keychain, Windows
+// Credential Manager, or the Secret Service on Linux), one entry per account
+type keyringStore struct {
+	service string
+}
+
+func (k *keyringStore) Get(account string) (string, error) {
+	password, err := keyring.Get(k.service, account)
+	if err == keyring.ErrNotFound {
+		return "", fmt.Errorf("%w: no keyring entry for %s/%s", ErrNotFound, k.service, account)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read keyring: %w", err)
+	}
+	return password, nil
+}
+
+func (k *keyringStore) Set(account, password string) error {
+	if err := keyring.Set(k.service, account, password); err != nil {
+		return fmt.Errorf("failed to write keyring: %w", err)
+	}
+	return nil
+}
+
+func (k *keyringStore) Delete(account string) error {
+	if err := keyring.Delete(k.service, account); err != nil && err != keyring.ErrNotFound {
+		return fmt.Errorf("failed to delete keyring entry: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"email-mcp-server/ai"
+	"email-mcp-server/mail"
+	"email-mcp-server/storage"
+)
+
+// recordDeadlines stores the deadlines mentioned in a synced email, resolved
+// relative to the day it was sent
+func (es *EmailServer) recordDeadlines(email *storage.Email) error {
+	sent := email.Date.Local()
+	if email.Date.IsZero() {
+		sent = email.SyncedAt.Local()
+	}
+
+	var deadlines []storage.Deadline
+	for _, deadline := range ai.DetectDeadlines(email.Subject+"\n"+ai.StripQuoted(email.BodySnippet), sent) {
+		deadlines = append(deadlines, storage.Deadline{
+			AccountID: email.AccountID,
+			Folder:    email.Folder,
+			UID:       email.UID,
+			Phrase:    deadline.Phrase,
+			Due:       deadline.Due,
+		})
+	}
+	if err := es.db.SaveDeadlines(email.AccountID, email.Folder, email.UID, deadlines); err != nil {
+		return fmt.Errorf("failed to store deadlines of %s/%d: %w", email.Folder, email.UID, err)
+	}
+	return nil
+}
+
+// processNewEmails records the deadlines, invoices, orders and trips of newly
+// synced emails and the recipients of bounces, answers those of INBOX when
+// the account's autoresponder is on and, when notifications are enabled,
+// alerts about the pressing ones. New sent emails update the reply times of
+// their contacts instead.
+func (es *EmailServer) processNewEmails(accountID, folder string, emails []*storage.Email) {
+	if es.isSentFolder(accountID, folder) {
+		es.updateResponseTimes(accountID)
+		return
+	}
+	for _, email := range emails {
+		if err := es.recordDeadlines(email); err != nil {
+			log.Print(err)
+		}
+		if err := es.recordTransaction(email); err != nil {
+			log.Print(err)
+		}
+		if email.Automated == mail.KindBounce {
+			if err := es.recordBounces(email); err != nil {
+				log.Print(err)
+			}
+		}
+	}
+	if folder == "INBOX" {
+		es.autoRespond(accountID, emails)
+	}
+	if es.notifier != nil && es.notifier.Enabled() {
+		es.alertNewEmails(accountID, folder, emails)
+	}
+}
+
+func (es *EmailServer) handleUpcomingDeadlines(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("deadlines are not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	days := 7
+	if d, ok := args["days"].(float64); ok && d >= 1 {
+		days = int(d)
+	}
+	limit := 50
+	if l, ok := args["limit"].(float64); ok && l >= 1 {
+		limit = int(l)
+	}
+	includeOverdue, _ := args["include_overdue"].(bool)
+
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	from := now
+	if includeOverdue {
+		from = now.AddDate(0, 0, -days)
+	}
+	deadlines, err := es.db.UpcomingDeadlines(config.ID, folder, from, now.AddDate(0, 0, days), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	deadlinesJSON, _ := json.MarshalIndent(deadlines, "", "  ")
+	return ToolResult{
+		Content: []TextContent{
+			{Type: "text", Text: formatDeadlines(config.ID, deadlines, days, now)},
+			{Type: "text", Text: string(deadlinesJSON)},
+		},
+	}, nil
+}
+
+func formatDeadlines(accountID string, deadlines []storage.UpcomingDeadline, days int, now time.Time) string {
+	if len(deadlines) == 0 {
+		return fmt.Sprintf("No deadlines in the next %d days for %s", days, accountID)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d deadlines in the next %d days for %s:\n", len(deadlines), days, accountID)
+	for _, deadline := range deadlines {
+		when := formatDigestDay(deadline.Due.Local(), now)
+		if deadline.Due.Before(now) {
+			when += " (overdue)"
+		} else if points := ai.DeadlinePoints(deadline.Due, now); points > 0 {
+			when += fmt.Sprintf(" (priority +%d)", points)
+		}
+		fmt.Fprintf(&b, "- %s · \"%s\" · %s from %s [%s/%d]\n",
+			when, deadline.Phrase, deadline.Email.Subject, deadline.Email.From, deadline.Email.Folder, deadline.Email.UID)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"email-mcp-server/mail"
+	"email-mcp-server/notifications"
+	"email-mcp-server/storage"
+)
+
+// Every message sent through the server is recorded with the Message-ID it
+// was sent with. When sync reads a bounce whose report quotes one of those
+// Message-IDs, the message, and the scheduled email it was sent as, are
+// marked delayed or failed; sent_status lists them and, with
+// "delivery_failures" in notifications.json, failures raise an alert.
+
+// recordSent records a message that was just sent
+func (es *EmailServer) recordSent(accountID string, msg *mail.OutgoingMessage) {
+	if es.db == nil {
+		return
+	}
+	sent := &storage.SentMessage{
+		AccountID: accountID,
+		MessageID: msg.MessageID,
+		To:        msg.Recipients(),
+		Subject:   msg.Subject,
+		SentAt:    time.Now(),
+	}
+	if err := es.db.RecordSent(sent); err != nil {
+		log.Printf("Sent email %s was not recorded: %v", msg.MessageID, err)
+	}
+}
+
+// trackDelivery updates the sent messages a bounce reports on and alerts
+// about those that failed
+func (es *EmailServer) trackDelivery(bounce *storage.Email, bounces []mail.Bounce) error {
+	updated, err := es.db.RecordDeliveryFailures(bounce.AccountID, bounces)
+	if err != nil {
+		return fmt.Errorf("failed to track delivery of bounce %s/%d: %w", bounce.Folder, bounce.UID, err)
+	}
+	if es.notifier == nil || !es.notifier.Enabled() || !es.notifier.DeliveryFailures() {
+		return nil
+	}
+
+	var alerts []notifications.Alert
+	for _, sent := range updated {
+		if sent.DeliveryStatus != storage.DeliveryFailed {
+			continue
+		}
+		alerts = append(alerts, notifications.Alert{
+			AccountID: sent.AccountID,
+			Folder:    bounce.Folder,
+			UID:       bounce.UID,
+			MessageID: sent.MessageID,
+			From:      bounce.From,
+			Subject:   "Undeliverable: " + sent.Subject,
+			Snippet:   sent.Diagnostic,
+			Date:      bounce.Date,
+			Score:     100,
+			Level:     notifications.LevelCritical,
+			Reasons:   []string{"delivery failed to " + strings.Join(sent.FailedRecipients, ", ")},
+		})
+	}
+	if len(alerts) == 0 {
+		return nil
+	}
+	if _, err := es.notifier.Notify(alerts); err != nil {
+		log.Printf("Failed to deliver notifications: %v", err)
+	}
+	return nil
+}
+
+func (es *EmailServer) handleSentStatus(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("sent status is not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	messageID, _ := args["message_id"].(string)
+	status, _ := args["status"].(string)
+	if status == "all" {
+		status = ""
+	}
+	days := 7
+	if d, ok := args["days"].(float64); ok && d >= 1 {
+		days = int(d)
+	}
+	limit := 50
+	if l, ok := args["limit"].(float64); ok && l >= 1 {
+		limit = int(l)
+	}
+
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []storage.SentMessage
+	if messageID != "" {
+		sent, err := es.db.GetSent(config.ID, messageID)
+		if err != nil {
+			return nil, err
+		}
+		if sent == nil {
+			return nil, fmt.Errorf("sent message %s %w: only messages sent through this server are recorded", messageID, errNotFound)
+		}
+		messages = append(messages, *sent)
+	} else if messages, err = es.db.ListSent(config.ID, status, time.Now().AddDate(0, 0, -days), limit); err != nil {
+		return nil, err
+	}
+
+	messagesJSON, _ := json.MarshalIndent(messages, "", "  ")
+	return ToolResult{
+		Content: []TextContent{
+			{Type: "text", Text: formatSentStatus(config.ID, messages, days)},
+			{Type: "text", Text: string(messagesJSON)},
+		},
+	}, nil
+}
+
+func formatSentStatus(accountID string, messages []storage.SentMessage, days int) string {
+	if len(messages) == 0 {
+		return fmt.Sprintf("No sent messages in the last %d days for %s", days, accountID)
+	}
+
+	counts := make(map[string]int)
+	for _, m := range messages {
+		counts[m.DeliveryStatus]++
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d sent messages for %s: %d failed, %d delayed, %d without problems\n", len(messages), accountID,
+		counts[storage.DeliveryFailed], counts[storage.DeliveryDelayed], counts[storage.DeliverySent])
+	for _, m := range messages {
+		icon := "✅"
+		switch m.DeliveryStatus {
+		case storage.DeliveryFailed:
+			icon = "❌"
+		case storage.DeliveryDelayed:
+			icon = "⏳"
+		}
+		fmt.Fprintf(&b, "%s %s %q to %s", icon, m.SentAt.Local().Format("2006-01-02 15:04"), m.Subject, strings.Join(m.To, ", "))
+		if len(m.FailedRecipients) > 0 {
+			fmt.Fprintf(&b, " - %s for %s", m.DeliveryStatus, strings.Join(m.FailedRecipients, ", "))
+		}
+		if m.Diagnostic != "" {
+			fmt.Fprintf(&b, ": %s", m.Diagnostic)
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
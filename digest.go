@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+
+	"email-mcp-server/ai"
+	"email-mcp-server/mail"
+	"email-mcp-server/scheduler"
+)
+
+// The daily digest classifies each account's latest emails and lists, across
+// accounts, the new high-priority ones, those that look like they need a
+// reply and the deadlines they mention. DIGEST_SCHEDULE also emails it on a
+// cron schedule.
+
+// digestItem is an email listed in the digest
+type digestItem struct {
+	Account    string
+	ID         uint32
+	From       string
+	Subject    string
+	Date       time.Time
+	Reasons    []string // why it is high priority
+	Deadlines  []ai.Deadline
+	NeedsReply bool
+	Actions    int // stored action items, see extract_action_items
+}
+
+type categoryCount struct {
+	emails, unread int
+}
+
+// digest is the data behind the markdown digest
+type digest struct {
+	generated  time.Time
+	window     time.Duration
+	accounts   []string // per-account sections, already formatted
+	unread     int
+	recent     int
+	categories map[string]map[string]*categoryCount // by account, then category
+	items      []digestItem
+}
+
+func (es *EmailServer) handleDailySummary(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	limit := 50
+	if l, ok := args["limit"].(float64); ok {
+		limit = int(l)
+	}
+	hours := 24
+	if h, ok := args["hours"].(float64); ok && h > 0 {
+		hours = int(h)
+	}
+
+	text := es.buildDigest(ctx, limit, time.Duration(hours)*time.Hour, time.Now()).markdown()
+
+	if send, _ := args["send"].(bool); send {
+		to, err := es.sendDigest(ctx, digestSubject(time.Now()), text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to send digest: %w", err)
+		}
+		text += fmt.Sprintf("\n\n_Digest sent to %s_", to)
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: text,
+		}},
+	}, nil
+}
+
+// buildDigest analyzes the latest limit emails of every account included in
+// the daily summary. window decides what counts as new.
+func (es *EmailServer) buildDigest(ctx context.Context, limit int, window time.Duration, now time.Time) *digest {
+	d := &digest{generated: now, window: window, categories: make(map[string]map[string]*categoryCount)}
+
+	for _, config := range es.accounts() {
+		if !config.inDailySummary() {
+			continue
+		}
+
+		emails, err := es.getEmails(ctx, config.ID, "", limit, true)
+		if err != nil {
+			d.accounts = append(d.accounts, fmt.Sprintf("### ❌ %s\nError getting emails: %v", config.ID, err))
+			continue
+		}
+
+		summary := es.summarizeEmails(emails)
+		d.unread += summary.UnreadCount
+		d.recent += summary.RecentCount
+
+		section := fmt.Sprintf("### %s (%s)\n- Total: %d · Unread: %d · Recent (24h): %d",
+			config.ID, config.Username, summary.TotalEmails, summary.UnreadCount, summary.RecentCount)
+		if len(summary.TopSenders) > 0 {
+			var senders []string
+			for i, sender := range summary.TopSenders {
+				if i >= 3 {
+					break
+				}
+				senders = append(senders, fmt.Sprintf("%s (%d)", sender.Email, sender.Count))
+			}
+			section += "\n- Top senders: " + strings.Join(senders, ", ")
+		}
+		d.accounts = append(d.accounts, section)
+
+		var actions map[uint32]int
+		if es.db != nil {
+			if actions, err = es.db.ActionItemCounts(config.ID, "INBOX"); err != nil {
+				log.Printf("Digest without action items of %s: %v", config.ID, err)
+			}
+		}
+
+		categories := make(map[string]*categoryCount)
+		d.categories[config.ID] = categories
+		for _, email := range emails {
+			d.addEmail(es, config.ID, email, categories, actions[email.ID])
+		}
+	}
+	return d
+}
+
+func (d *digest) addEmail(es *EmailServer, accountID string, email EmailMessage, categories map[string]*categoryCount, actions int) {
+	unread, answered, flagged := true, false, false
+	for _, flag := range email.Flags {
+		switch flag {
+		case imap.SeenFlag:
+			unread = false
+		case imap.AnsweredFlag:
+			answered = true
+		case imap.FlaggedFlag:
+			flagged = true
+		}
+	}
+
+	message := ai.Email{
+		AccountID:   accountID,
+		UID:         email.ID,
+		From:        email.From,
+		To:          email.To,
+		Subject:     email.Subject,
+		Body:        email.Body,
+		Date:        email.Date,
+		Automated:   email.Automated,
+		Attachments: email.Attachments,
+	}
+	category := ai.DefaultCategory
+	var tags []string
+	if classification, err := es.classifier.Classify(context.Background(), message); err == nil {
+		category, tags = classification.Category, classification.Tags
+	}
+
+	count := categories[category]
+	if count == nil {
+		count = &categoryCount{}
+		categories[category] = count
+	}
+	count.emails++
+	if unread {
+		count.unread++
+	}
+
+	item := digestItem{Account: accountID, ID: email.ID, From: email.From, Subject: email.Subject, Date: email.Date}
+	if !answered {
+		item.Deadlines = ai.DetectDeadlines(email.Subject+"\n"+ai.StripQuoted(email.Body), d.generated)
+		item.NeedsReply = (unread || d.generated.Sub(email.Date) < d.window) && ai.NeedsReply(message, category)
+		item.Actions = actions
+	}
+
+	if unread && d.generated.Sub(email.Date) < d.window {
+		if es.isVIP(accountID, email.FromAddress) {
+			item.Reasons = append(item.Reasons, "VIP sender")
+		}
+		if flagged {
+			item.Reasons = append(item.Reasons, "flagged")
+		}
+		for _, tag := range append(tags, category) {
+			if tag == "urgent" || tag == "important" {
+				item.Reasons = append(item.Reasons, tag)
+				break
+			}
+		}
+		if len(item.Deadlines) > 0 && item.Deadlines[0].Due.Sub(d.generated) < 48*time.Hour {
+			item.Reasons = append(item.Reasons, "due "+formatDigestDay(item.Deadlines[0].Due, d.generated))
+		}
+	}
+
+	if len(item.Reasons) > 0 || item.NeedsReply || len(item.Deadlines) > 0 || item.Actions > 0 {
+		d.items = append(d.items, item)
+	}
+}
+
+// markdown renders the digest
+func (d *digest) markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# 📊 Daily Digest - %s\n\n", d.generated.Format("Monday, 2 January 2006"))
+	fmt.Fprintf(&b, "**Overall:** %d unread · %d received in the last 24h · %d accounts\n", d.unread, d.recent, len(d.accounts))
+
+	var priority, replies, actions []digestItem
+	type deadlineItem struct {
+		deadline ai.Deadline
+		item     digestItem
+	}
+	var deadlines []deadlineItem
+	for _, item := range d.items {
+		if len(item.Reasons) > 0 {
+			priority = append(priority, item)
+		}
+		if item.NeedsReply {
+			replies = append(replies, item)
+		}
+		if item.Actions > 0 {
+			actions = append(actions, item)
+		}
+		for _, deadline := range item.Deadlines {
+			deadlines = append(deadlines, deadlineItem{deadline, item})
+		}
+	}
+	sort.SliceStable(deadlines, func(i, j int) bool { return deadlines[i].deadline.Due.Before(deadlines[j].deadline.Due) })
+
+	fmt.Fprintf(&b, "\n## 🔴 High priority (%d)\n", len(priority))
+	if len(priority) == 0 {
+		b.WriteString("_Nothing new needs attention._\n")
+	}
+	for _, item := range priority {
+		fmt.Fprintf(&b, "- %s · %s\n", d.describe(item), strings.Join(item.Reasons, ", "))
+	}
+
+	fmt.Fprintf(&b, "\n## ↩️ Needs reply (%d)\n", len(replies))
+	if len(replies) == 0 {
+		b.WriteString("_No open questions found._\n")
+	}
+	for _, item := range replies {
+		fmt.Fprintf(&b, "- %s\n", d.describe(item))
+	}
+
+	// Only emails whose action items were extracted; the badge marks them in
+	// the other sections too
+	if len(actions) > 0 {
+		fmt.Fprintf(&b, "\n## 📋 Needs action (%d)\n", len(actions))
+		for _, item := range actions {
+			fmt.Fprintf(&b, "- %s\n", d.describe(item))
+		}
+	}
+
+	fmt.Fprintf(&b, "\n## ⏰ Deadlines (%d)\n", len(deadlines))
+	if len(deadlines) == 0 {
+		b.WriteString("_No deadlines mentioned._\n")
+	}
+	for _, dl := range deadlines {
+		fmt.Fprintf(&b, "- **%s** - \"%s\" in %s\n", formatDigestDay(dl.deadline.Due, d.generated), dl.deadline.Phrase, d.describe(dl.item))
+	}
+
+	b.WriteString("\n## 📁 By category\n")
+	b.WriteString("| Account | Category | Emails | Unread |\n|---|---|---|---|\n")
+	var accountIDs []string
+	for accountID := range d.categories {
+		accountIDs = append(accountIDs, accountID)
+	}
+	sort.Strings(accountIDs)
+	for _, accountID := range accountIDs {
+		categories := d.categories[accountID]
+		var names []string
+		for name := range categories {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			if categories[names[i]].emails != categories[names[j]].emails {
+				return categories[names[i]].emails > categories[names[j]].emails
+			}
+			return names[i] < names[j]
+		})
+		for _, name := range names {
+			fmt.Fprintf(&b, "| %s | %s | %d | %d |\n", accountID, name, categories[name].emails, categories[name].unread)
+		}
+	}
+
+	b.WriteString("\n## 📧 Accounts\n")
+	b.WriteString(strings.Join(d.accounts, "\n\n"))
+	return b.String()
+}
+
+func (d *digest) describe(item digestItem) string {
+	subject := item.Subject
+	if subject == "" {
+		subject = "(no subject)"
+	}
+	text := fmt.Sprintf("**%s** - %s (%s #%d, %s)", subject, item.From, item.Account, item.ID, item.Date.Local().Format("Jan 2 15:04"))
+	if item.Actions > 0 {
+		text += fmt.Sprintf(" · 📋 needs action (%d)", item.Actions)
+	}
+	return text
+}
+
+// formatDigestDay names a day relative to now
+func formatDigestDay(t, now time.Time) string {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch days := int(t.Sub(today).Hours() / 24); days {
+	case 0:
+		return "today"
+	case 1:
+		return "tomorrow"
+	}
+	return t.Format("Mon Jan 2")
+}
+
+// sendDigest emails text from DIGEST_ACCOUNT (default: the default account)
+// to DIGEST_TO (default: that account's own address) and returns the
+// recipient
+func (es *EmailServer) sendDigest(ctx context.Context, subject, text string) (string, error) {
+	config, err := es.getConfig(os.Getenv("DIGEST_ACCOUNT"))
+	if err != nil {
+		return "", err
+	}
+	to := getEnv("DIGEST_TO", config.Username)
+
+	err = es.sendEmail(ctx, config.ID, &mail.OutgoingMessage{
+		To:      []string{to},
+		Subject: subject,
+		Body:    text,
+	})
+	return to, err
+}
+
+func digestSubject(at time.Time) string {
+	return "Daily email digest - " + at.Format("Mon Jan 2")
+}
+
+// initDigest starts emailing the digest on DIGEST_SCHEDULE, a cron
+// expression such as "0 8 * * 1-5"
+func (es *EmailServer) initDigest() {
+	spec := os.Getenv("DIGEST_SCHEDULE")
+	if spec == "" {
+		return
+	}
+
+	schedule, err := scheduler.ParseSchedule(spec)
+	if err != nil {
+		log.Printf("Digest emails disabled: %v", err)
+		return
+	}
+
+	es.digestJob = scheduler.NewJob(schedule, func(at time.Time) {
+		ctx := context.Background()
+		text := es.buildDigest(ctx, 50, 24*time.Hour, at).markdown()
+		if to, err := es.sendDigest(ctx, digestSubject(at), text); err != nil {
+			log.Printf("Failed to send digest: %v", err)
+		} else {
+			log.Printf("Digest sent to %s", to)
+		}
+	})
+	es.digestJob.Start()
+	log.Printf("Digest emails scheduled (%s)", spec)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Tool calls and resource reads can take minutes on a slow IMAP server, so
+// they run on their own goroutines, at most MAX_CONCURRENT_REQUESTS at a
+// time, and other requests are answered while they run. At most
+// MAX_QUEUED_REQUESTS more wait for a slot; they can be cancelled while
+// waiting. The remaining
+// methods are quick and handled in the order they arrive, so initialize is
+// done before anything after it. Responses are written whole, one per line,
+// as each request finishes; a batch is answered once all its requests have
+// finished, with the responses in the order of the requests.
+
+// concurrentMethods are the methods handled on their own goroutine
+var concurrentMethods = map[string]bool{
+	"tools/call":     true,
+	"resources/read": true,
+}
+
+// requestDispatcher hands the messages read from stdin to handleMessage
+type requestDispatcher struct {
+	es      *EmailServer
+	ctx     context.Context // ends when the client disconnects
+	slots   chan struct{}   // one per running concurrent request
+	queue   int             // concurrent requests that may wait for a slot
+	running sync.WaitGroup
+
+	idsMu sync.Mutex
+	ids   map[string]bool // IDs of the running and waiting concurrent requests
+}
+
+func newRequestDispatcher(ctx context.Context, es *EmailServer) *requestDispatcher {
+	limit := getEnvInt("MAX_CONCURRENT_REQUESTS", 8)
+	if limit < 1 {
+		limit = 1
+	}
+	return &requestDispatcher{
+		es:    es,
+		ctx:   ctx,
+		slots: make(chan struct{}, limit),
+		queue: max(getEnvInt("MAX_QUEUED_REQUESTS", 64), 0),
+		ids:   make(map[string]bool),
+	}
+}
+
+// serve handles the messages of lines until it is closed, then waits for
+// the running requests
+func (d *requestDispatcher) serve(lines <-chan []byte) {
+	for line := range lines {
+		if line == nil {
+			continue
+		}
+		// Applied again in order, for requests the reader saw cancelled
+		// before they were started here
+		d.es.applyCancellations(line)
+		if line[0] != '[' {
+			d.start(line, d.write)
+			continue
+		}
+
+		var batch []json.RawMessage
+		if err := json.Unmarshal(line, &batch); err != nil {
+			d.es.writeError(nil, -32700, fmt.Sprintf("Parse error: %v", err))
+			continue
+		}
+		if len(batch) == 0 {
+			d.es.writeError(nil, -32600, "Invalid Request: empty batch")
+			continue
+		}
+		d.startBatch(batch)
+	}
+	d.running.Wait()
+}
+
+// startBatch handles the messages of a batch. It is answered with one array
+// holding the responses to its requests; a batch of only notifications gets
+// no reply at all.
+func (d *requestDispatcher) startBatch(batch []json.RawMessage) {
+	responses := make([]*MCPResponse, len(batch))
+	var done sync.WaitGroup
+	done.Add(len(batch))
+	for i, raw := range batch {
+		d.start(raw, func(resp *MCPResponse) {
+			responses[i] = resp
+			done.Done()
+		})
+	}
+
+	d.running.Add(1)
+	go func() {
+		defer d.running.Done()
+		done.Wait()
+		var answered []*MCPResponse
+		for _, resp := range responses {
+			if resp != nil {
+				answered = append(answered, resp)
+			}
+		}
+		if len(answered) > 0 {
+			if err := d.es.writeMessage(answered); err != nil {
+				log.Printf("Error marshaling response: %v", err)
+			}
+		}
+	}()
+}
+
+// start handles raw and passes its response, nil for none, to reply.
+// Concurrent requests run on their own goroutine once a slot is free; other
+// messages are handled before start returns.
+func (d *requestDispatcher) start(raw []byte, reply func(*MCPResponse)) {
+	var req struct {
+		ID     interface{} `json:"id"`
+		Method string      `json:"method"`
+	}
+	if json.Unmarshal(raw, &req) != nil || req.ID == nil || !concurrentMethods[req.Method] {
+		reply(d.es.handleMessage(d.ctx, raw))
+		return
+	}
+
+	// Cancellations and responses name requests by ID, so two running
+	// requests cannot share one
+	key := requestKey(req.ID)
+	d.idsMu.Lock()
+	inUse, full := d.ids[key], len(d.ids) >= cap(d.slots)+d.queue
+	if !inUse && !full {
+		d.ids[key] = true
+	}
+	d.idsMu.Unlock()
+	switch {
+	case inUse:
+		reply(&MCPResponse{ID: req.ID, JSONRPC: "2.0", Error: &MCPError{Code: -32600, Message: fmt.Sprintf("Invalid Request: request %s is still running", key)}})
+		return
+	case full:
+		reply(&MCPResponse{ID: req.ID, JSONRPC: "2.0", Error: &MCPError{Code: -32000, Message: fmt.Sprintf("Server busy: %d requests are running or waiting (MAX_CONCURRENT_REQUESTS, MAX_QUEUED_REQUESTS)", cap(d.slots)+d.queue)}})
+		return
+	}
+
+	// The request can be cancelled from now on, also while it waits
+	ctx, cancel := context.WithCancel(d.ctx)
+	d.es.trackRequest(key, cancel)
+
+	// The slot is taken on the request's goroutine so that the messages
+	// after it, cancellations and quick methods, are handled meanwhile
+	d.running.Add(1)
+	go func() {
+		defer d.running.Done()
+		var resp *MCPResponse
+		select {
+		case d.slots <- struct{}{}:
+			if ctx.Err() == nil {
+				resp = d.es.handleMessage(ctx, raw)
+			}
+			<-d.slots
+		case <-ctx.Done():
+			// Like running ones, requests cancelled while waiting get no
+			// response
+		}
+		d.es.untrackRequest(key)
+		cancel()
+		d.idsMu.Lock()
+		delete(d.ids, key)
+		d.idsMu.Unlock()
+		reply(resp)
+	}()
+}
+
+// write sends the response to a single message
+func (d *requestDispatcher) write(resp *MCPResponse) {
+	if resp == nil {
+		return
+	}
+	if err := d.es.writeMessage(resp); err != nil {
+		log.Printf("Error marshaling response: %v", err)
+	}
+}
+
+// requestKey identifies a request by the JSON of its ID, so the string ID
+// "1" and the number 1 name different requests
+func requestKey(id interface{}) string {
+	key, err := json.Marshal(id)
+	if err != nil {
+		return fmt.Sprint(id)
+	}
+	return string(key)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newBlockingDispatcher returns a dispatcher with one slot and room for two
+// waiting requests, whose server has a block tool that returns once release
+// is closed
+func newBlockingDispatcher(t *testing.T) (*requestDispatcher, chan struct{}) {
+	es := newTestServer(t)
+	release := make(chan struct{})
+	es.tools.Register(Tool{Name: "block", InputSchema: map[string]interface{}{"type": "object"}},
+		func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+			<-release
+			return "done", nil
+		})
+	d := &requestDispatcher{es: es, ctx: context.Background(), slots: make(chan struct{}, 1), queue: 2, ids: make(map[string]bool)}
+	return d, release
+}
+
+// startNow calls d.start and fails when it does not return promptly
+func startNow(t *testing.T, d *requestDispatcher, raw string, replies chan<- *MCPResponse) {
+	t.Helper()
+	started := make(chan struct{})
+	go func() {
+		d.start([]byte(raw), func(resp *MCPResponse) { replies <- resp })
+		close(started)
+	}()
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("start blocked on %s", raw)
+	}
+}
+
+func callBlock(id string) string {
+	return fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"method":"tools/call","params":{"name":"block"}}`, id)
+}
+
+func TestDispatcherDoesNotBlockOnSlots(t *testing.T) {
+	d, release := newBlockingDispatcher(t)
+	replies := make(chan *MCPResponse, 3)
+
+	startNow(t, d, callBlock("1"), replies)
+	startNow(t, d, callBlock("2"), replies)
+	startNow(t, d, `{"jsonrpc":"2.0","id":3,"method":"tools/list"}`, replies)
+	if resp := <-replies; resp.ID != float64(3) || resp.Error != nil {
+		t.Fatalf("first reply = %+v, want the tools/list one while both calls wait", resp)
+	}
+
+	close(release)
+	d.running.Wait()
+	for i := 0; i < 2; i++ {
+		if resp := <-replies; resp.Error != nil {
+			t.Errorf("call %v failed: %+v", resp.ID, resp.Error)
+		}
+	}
+}
+
+func TestDispatcherRequestIDs(t *testing.T) {
+	d, release := newBlockingDispatcher(t)
+	replies := make(chan *MCPResponse, 3)
+
+	startNow(t, d, callBlock(`"1"`), replies)
+	startNow(t, d, callBlock("1"), replies)
+	startNow(t, d, callBlock(`"1"`), replies)
+	resp := <-replies
+	if resp.ID != "1" || resp.Error == nil || resp.Error.Code != -32600 {
+		t.Fatalf("reusing a running ID answered %+v, want a -32600 error", resp)
+	}
+
+	close(release)
+	d.running.Wait()
+	ids := map[interface{}]bool{}
+	for i := 0; i < 2; i++ {
+		resp := <-replies
+		if resp.Error != nil {
+			t.Errorf("call %v failed: %+v", resp.ID, resp.Error)
+		}
+		ids[resp.ID] = true
+	}
+	if !ids["1"] || !ids[float64(1)] {
+		t.Errorf("answered IDs %v, want both \"1\" and 1", ids)
+	}
+	if len(d.ids) != 0 {
+		t.Errorf("finished requests still tracked: %v", d.ids)
+	}
+}
+
+func TestDispatcherCancelWaitingRequest(t *testing.T) {
+	d, release := newBlockingDispatcher(t)
+	ran := make(chan struct{}, 1)
+	d.es.tools.Register(Tool{Name: "mark", InputSchema: map[string]interface{}{"type": "object"}},
+		func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+			ran <- struct{}{}
+			return "marked", nil
+		})
+	replies := make(chan *MCPResponse, 2)
+
+	// 2 waits for the slot 1 holds and is cancelled meanwhile
+	startNow(t, d, callBlock("1"), replies)
+	for len(d.slots) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	startNow(t, d, `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"mark"}}`, replies)
+	d.es.applyCancellations([]byte(`{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":2}}`))
+	if resp := <-replies; resp != nil {
+		t.Fatalf("cancelled waiting request answered %+v, want no response", resp)
+	}
+
+	close(release)
+	d.running.Wait()
+	if resp := <-replies; resp.ID != float64(1) || resp.Error != nil {
+		t.Errorf("call 1 answered %+v", resp)
+	}
+	select {
+	case <-ran:
+		t.Error("a request cancelled while waiting ran")
+	default:
+	}
+	if len(d.es.calls) != 0 {
+		t.Errorf("finished requests still cancellable: %v", d.es.calls)
+	}
+}
+
+func TestDispatcherQueueLimit(t *testing.T) {
+	d, release := newBlockingDispatcher(t)
+	replies := make(chan *MCPResponse, 4)
+
+	// One runs and two wait; the fourth is turned away at once
+	for id := 1; id <= 4; id++ {
+		startNow(t, d, callBlock(fmt.Sprint(id)), replies)
+	}
+	resp := <-replies
+	if resp.ID != float64(4) || resp.Error == nil || resp.Error.Code != -32000 {
+		t.Fatalf("request over the queue limit answered %+v, want a -32000 error", resp)
+	}
+
+	close(release)
+	d.running.Wait()
+	for i := 0; i < 3; i++ {
+		if resp := <-replies; resp.Error != nil {
+			t.Errorf("call %v failed: %+v", resp.ID, resp.Error)
+		}
+	}
+}
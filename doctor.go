@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+
+	"email-mcp-server/config"
+)
+
+// doctorTimeout bounds the network checks of one account
+const doctorTimeout = 2 * time.Minute
+
+// certificateWarning is how soon a server certificate may expire before
+// doctor warns about it
+const certificateWarning = 14 * 24 * time.Hour
+
+// doctor prints the outcome of each check as it runs and counts the failures
+type doctor struct {
+	failed, warned int
+}
+
+func (d *doctor) pass(name, detail string) {
+	fmt.Printf("  PASS  %s: %s\n", name, detail)
+}
+
+func (d *doctor) warn(name, detail string) {
+	d.warned++
+	fmt.Printf("  WARN  %s: %s\n", name, detail)
+}
+
+func (d *doctor) skip(name, detail string) {
+	fmt.Printf("  SKIP  %s: %s\n", name, detail)
+}
+
+func (d *doctor) fail(name string, err error, fix string) {
+	d.failed++
+	fmt.Printf("  FAIL  %s: %v\n", name, err)
+	if fix != "" {
+		fmt.Printf("        Fix: %s\n", fix)
+	}
+}
+
+// doctorCommand implements `email-mcp-server doctor [-account id]`: it checks
+// the configuration files, the database and, for each account, DNS, TLS,
+// login and folder access, so a misconfiguration shows up before the first
+// tool call fails. It returns an error when any check failed.
+func doctorCommand(args []string) error {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	accountID := flags.String("account", "", "only check this account")
+	flags.Parse(args)
+
+	d := &doctor{}
+	fmt.Println("Configuration")
+	d.pass("directory", homeDir())
+	configs, _, err := loadAccounts(accountsPath())
+	if err != nil {
+		d.fail("accounts", err, "fix the settings named above in "+accountsPath()+" (or the environment variables when it does not exist)")
+	} else {
+		d.pass("accounts", fmt.Sprintf("%d configured, settings valid and passwords readable", len(configs)))
+	}
+	d.checkConfigFiles()
+
+	fmt.Println("Database")
+	d.checkDatabase()
+
+	if configs != nil && *accountID != "" {
+		config, err := findAccount(configs, *accountID)
+		if err != nil {
+			return err
+		}
+		configs = []EmailConfig{*config}
+	}
+	for i := range configs {
+		config := &configs[i]
+		fmt.Printf("Account %s (%s)\n", config.ID, config.Username)
+		ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+		if config.isGraph() {
+			d.checkGraph(ctx, config)
+		} else {
+			d.checkIMAP(ctx, config)
+			d.checkSMTP(ctx, config)
+		}
+		cancel()
+	}
+
+	if d.failed > 0 {
+		return fmt.Errorf("%d checks failed, %d warnings", d.failed, d.warned)
+	}
+	fmt.Printf("All checks passed, %d warnings\n", d.warned)
+	return nil
+}
+
+// checkConfigFiles loads the optional configuration files the server reads
+// at startup, which fall back to defaults when missing or invalid
+func (d *doctor) checkConfigFiles() {
+	files := []struct {
+		name, path string
+		load       func(path string) error
+	}{
+		{"AI config", aiConfigPath(), func(path string) error {
+			_, err := config.LoadAIConfig(path)
+			return err
+		}},
+		{"classification rules", rulesPath(), func(path string) error {
+			_, err := config.LoadRules(path)
+			return err
+		}},
+		{"notifications", notificationsPath(), func(path string) error {
+			_, err := config.LoadNotificationConfig(path)
+			return err
+		}},
+	}
+	for _, file := range files {
+		if err := file.load(file.path); err != nil {
+			d.fail(file.name, err, "the server falls back to the defaults until "+file.path+" is fixed")
+		} else {
+			d.pass(file.name, file.path+" is valid or absent (defaults)")
+		}
+	}
+}
+
+func (d *doctor) checkDatabase() {
+	path := databasePath()
+	db, err := openDatabase()
+	if err != nil {
+		d.fail("open", err, "without a database sync, search and the AI tools are unavailable")
+		return
+	}
+	defer db.Close()
+	d.pass("open", path)
+
+	if err := db.CheckWritable(); err != nil {
+		d.fail("write", err, "check the permissions and free space of "+path+" and its directory")
+		return
+	}
+	if db.Encrypted() {
+		d.pass("write", "encrypted database is writable")
+		return
+	}
+	mode, _ := db.JournalMode()
+	d.pass("write", "writable, journal mode "+mode)
+}
+
+func (d *doctor) checkIMAP(ctx context.Context, config *EmailConfig) {
+	if !d.checkDNS(ctx, "imap dns", config.IMAPHost) {
+		d.skip("imap", "the host does not resolve")
+		return
+	}
+
+	switch {
+	case config.IMAPPort == 993:
+		if !d.checkIMAPTLS(ctx, config) {
+			d.skip("imap login", "no TLS connection")
+			return
+		}
+	case !config.UseStartTLS:
+		d.warn("imap tls", fmt.Sprintf("port %d without UseStartTLS sends the password unencrypted", config.IMAPPort))
+	}
+
+	c, err := dialIMAP(ctx, config, nil)
+	if err != nil {
+		d.fail("imap login", err, loginFix(config, serverIMAP, classifyLoginError(err)))
+		return
+	}
+	defer c.Logout()
+	if config.IMAPPort != 993 && config.UseStartTLS {
+		d.pass("imap tls", "STARTTLS")
+	}
+	d.pass("imap login", "logged in as "+config.Username)
+
+	status, err := c.Select("INBOX", true)
+	if err != nil {
+		d.fail("imap folders", fmt.Errorf("failed to open INBOX: %w", err), "")
+		return
+	}
+	d.pass("imap folders", fmt.Sprintf("INBOX has %d messages", status.Messages))
+
+	// Folders named in the configuration must exist, or moving, archiving
+	// and saving sent mail fail
+	for _, folder := range []struct{ setting, name string }{
+		{"SentFolder", config.SentFolder}, {"TrashFolder", config.TrashFolder}, {"ArchiveFolder", config.ArchiveFolder},
+	} {
+		if folder.name == "" {
+			continue
+		}
+		if err := imapFolderExists(c, folder.name); err != nil {
+			d.fail("imap folders", fmt.Errorf("%s %q: %w", folder.setting, folder.name, err), "use list_folders to see the folder names of the account")
+		} else {
+			d.pass("imap folders", fmt.Sprintf("%s %q exists", folder.setting, folder.name))
+		}
+	}
+}
+
+// imapFolderExists returns an error when the server lists no folder name
+func imapFolderExists(c *client.Client, name string) error {
+	mailboxes := make(chan *imap.MailboxInfo, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.List("", name, mailboxes)
+	}()
+	found := false
+	for range mailboxes {
+		found = true
+	}
+	if err := <-done; err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no such folder")
+	}
+	return nil
+}
+
+func (d *doctor) checkSMTP(ctx context.Context, config *EmailConfig) {
+	if !d.checkDNS(ctx, "smtp dns", config.SMTPHost) {
+		d.skip("smtp", "the host does not resolve")
+		return
+	}
+
+	c, err := openSMTP(ctx, config)
+	if err != nil {
+		problem := classifyLoginError(err)
+		name := "smtp connect"
+		if problem == problemTLS {
+			name = "smtp tls"
+		}
+		d.fail(name, err, loginFix(config, serverSMTP, problem))
+		return
+	}
+	if state, ok := c.TLSConnectionState(); ok {
+		d.pass("smtp tls", describeTLS(state))
+		d.checkCertificate("smtp tls", state)
+	} else {
+		d.warn("smtp tls", fmt.Sprintf("port %d offers no STARTTLS, mail and password are sent unencrypted", config.SMTPPort))
+	}
+
+	if ok, _ := c.Extension("AUTH"); !ok {
+		d.pass("smtp login", "the server needs no authentication")
+		c.Quit()
+		return
+	}
+	// loginSMTP closes the connection when the login fails
+	c, err = loginSMTP(ctx, c, config)
+	if err != nil {
+		d.fail("smtp login", err, loginFix(config, serverSMTP, classifyLoginError(err)))
+		return
+	}
+	c.Quit()
+	d.pass("smtp login", "logged in as "+config.Username)
+}
+
+func (d *doctor) checkGraph(ctx context.Context, config *EmailConfig) {
+	if !d.checkDNS(ctx, "graph dns", "graph.microsoft.com") {
+		d.skip("graph", "the host does not resolve")
+		return
+	}
+	if result := testGraphAccount(ctx, config); !result.Succeeded {
+		d.fail("graph login", result.imapErr, loginFix(config, serverGraph, classifyLoginError(result.imapErr)))
+		return
+	}
+	d.pass("graph login", "signed in as "+config.Username)
+}
+
+// checkDNS reports whether host resolves
+func (d *doctor) checkDNS(ctx context.Context, name, host string) bool {
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		d.fail(name, err, "check the host name and the DNS settings of this machine")
+		return false
+	}
+	d.pass(name, host+" resolves to "+strings.Join(addrs, ", "))
+	return true
+}
+
+// checkIMAPTLS opens a TLS connection to an IMAP server on port 993 and
+// reports its version and certificate
+func (d *doctor) checkIMAPTLS(ctx context.Context, config *EmailConfig) bool {
+	tlsConfig, err := config.tlsConfig(config.IMAPHost)
+	if err == nil {
+		var conn net.Conn
+		conn, err = dialContext(ctx, config, net.JoinHostPort(config.IMAPHost, strconv.Itoa(config.IMAPPort)))
+		if err == nil {
+			tlsConn := tls.Client(conn, tlsConfig)
+			defer tlsConn.Close()
+			if err = tlsConn.HandshakeContext(ctx); err == nil {
+				d.pass("imap tls", describeTLS(tlsConn.ConnectionState()))
+				d.checkCertificate("imap tls", tlsConn.ConnectionState())
+				return true
+			}
+		}
+	}
+	d.fail("imap tls", err, loginFix(config, serverIMAP, classifyLoginError(err)))
+	return false
+}
+
+// checkCertificate warns about certificates that are not verified or expire
+// soon
+func (d *doctor) checkCertificate(name string, state tls.ConnectionState) {
+	if len(state.PeerCertificates) == 0 {
+		return
+	}
+	cert := state.PeerCertificates[0]
+	switch {
+	case len(state.VerifiedChains) == 0:
+		d.warn(name, insecureTLSWarning)
+	case time.Until(cert.NotAfter) < certificateWarning:
+		d.warn(name, "the server certificate expires on "+cert.NotAfter.Local().Format("2006-01-02"))
+	}
+}
+
+func describeTLS(state tls.ConnectionState) string {
+	text := tls.VersionName(state.Version)
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		text += fmt.Sprintf(", certificate for %s valid until %s", cert.Subject.CommonName, cert.NotAfter.Local().Format("2006-01-02"))
+	}
+	return text
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+
+	"email-mcp-server/imapext"
+	"email-mcp-server/mail"
+	"email-mcp-server/storage"
+)
+
+// Drafts live in the local database so they can be reviewed and edited before
+// sending. With save_to_server a copy is also appended to the account's
+// Drafts folder; the copy is found again by its Message-ID.
+
+func (es *EmailServer) handleCreateDraft(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("drafts are not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	to, cc, bcc, err := es.recipientArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	draft := &storage.Draft{
+		AccountID: config.ID,
+		MessageID: mail.NewMessageID(config.Username),
+		To:        to,
+		Cc:        cc,
+		Bcc:       bcc,
+	}
+	draft.Subject, _ = args["subject"].(string)
+	draft.Body, _ = args["body"].(string)
+	if draft.Body != "" {
+		draft.Body = config.signed(draft.Body)
+	}
+
+	if saveToServer, _ := args["save_to_server"].(bool); saveToServer {
+		if draft.ServerFolder, err = es.saveServerDraft(ctx, draft); err != nil {
+			return nil, fmt.Errorf("failed to save draft to server: %w", err)
+		}
+	}
+
+	if err := es.db.CreateDraft(draft); err != nil {
+		return nil, err
+	}
+
+	return draftResult("Draft saved", draft), nil
+}
+
+func (es *EmailServer) handleListDrafts(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("drafts are not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	if accountID != "" {
+		config, err := es.getConfig(accountID)
+		if err != nil {
+			return nil, err
+		}
+		accountID = config.ID
+	}
+
+	drafts, err := es.db.ListDrafts(accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list drafts: %w", err)
+	}
+
+	draftsJSON, _ := json.MarshalIndent(drafts, "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("%d draft(s):\n%s", len(drafts), string(draftsJSON)),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleUpdateDraft(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	draft, err := es.draftFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the fields that were passed are changed
+	for key, field := range map[string]*[]string{"to": &draft.To, "cc": &draft.Cc, "bcc": &draft.Bcc} {
+		if _, ok := args[key]; ok {
+			if *field, err = es.resolveRecipients(parseRecipients(args[key])); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if subject, ok := args["subject"].(string); ok {
+		draft.Subject = subject
+	}
+	if body, ok := args["body"].(string); ok {
+		draft.Body = body
+	}
+
+	if draft.ServerFolder != "" {
+		if _, err := es.saveServerDraft(ctx, draft); err != nil {
+			return nil, fmt.Errorf("failed to update draft on server: %w", err)
+		}
+	}
+
+	if err := es.db.UpdateDraft(draft); err != nil {
+		return nil, err
+	}
+
+	return draftResult("Draft updated", draft), nil
+}
+
+func (es *EmailServer) handleSendDraft(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	draft, err := es.draftFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if digest, ok := args["draft_digest"].(string); ok && digest != draftDigest(draft) {
+		return nil, invalidArgument("draft %d changed after confirmation was requested, call send_draft again", draft.ID)
+	}
+	if len(draft.To) == 0 || draft.Subject == "" || draft.Body == "" {
+		return nil, fmt.Errorf("draft %d is incomplete: to, subject and body are required", draft.ID)
+	}
+
+	msg := draftMessage(draft)
+	if err := es.sendEmail(ctx, draft.AccountID, msg); err != nil {
+		return nil, fmt.Errorf("failed to send email: %w", err)
+	}
+
+	// The message is out; failing to clean up only leaves a stale draft behind
+	text := fmt.Sprintf("Draft %d sent successfully to %s", draft.ID, strings.Join(msg.Recipients(), ", "))
+	if draft.ServerFolder != "" {
+		if err := es.deleteServerDraft(ctx, draft); err != nil {
+			text += fmt.Sprintf("\nWarning: failed to remove the copy in %s: %v", draft.ServerFolder, err)
+		}
+	}
+	if err := es.db.DeleteDraft(draft.ID); err != nil {
+		text += fmt.Sprintf("\nWarning: failed to delete local draft: %v", err)
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: text,
+		}},
+	}, nil
+}
+
+func (es *EmailServer) draftFromArgs(args map[string]interface{}) (*storage.Draft, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("drafts are not available: %w", errNoDatabase)
+	}
+
+	id, ok := args["draft_id"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("%w: draft_id", errMissingParameter)
+	}
+	return es.db.GetDraft(int64(id))
+}
+
+func draftMessage(draft *storage.Draft) *mail.OutgoingMessage {
+	return &mail.OutgoingMessage{
+		To:        draft.To,
+		Cc:        draft.Cc,
+		Bcc:       draft.Bcc,
+		Subject:   draft.Subject,
+		Body:      draft.Body,
+		MessageID: draft.MessageID,
+		Date:      time.Now(),
+	}
+}
+
+func draftResult(prefix string, draft *storage.Draft) ToolResult {
+	text := fmt.Sprintf("%s (ID: %d)", prefix, draft.ID)
+	if draft.ServerFolder != "" {
+		text += fmt.Sprintf(", copy stored in %s", draft.ServerFolder)
+	}
+	draftJSON, _ := json.MarshalIndent(draft, "", "  ")
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: text + "\n" + string(draftJSON),
+		}},
+	}
+}
+
+// saveServerDraft appends the draft to the Drafts folder, replacing any copy
+// saved earlier, and returns the folder used
+func (es *EmailServer) saveServerDraft(ctx context.Context, draft *storage.Draft) (string, error) {
+	config, err := es.getConfig(draft.AccountID)
+	if err != nil {
+		return "", err
+	}
+
+	c, err := es.connectIMAP(ctx, draft.AccountID)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	folder := draft.ServerFolder
+	if folder == "" {
+		if folder, err = findSpecialFolder(c, imap.DraftsAttr); err != nil {
+			return "", err
+		}
+		if folder == "" {
+			folder = getEnv("DRAFTS_FOLDER", "Drafts")
+		}
+	} else if err := removeServerDraft(c, folder, draft.MessageID); err != nil {
+		return "", err
+	}
+
+	msg := draftMessage(draft)
+	msg.From = config.fromAddress()
+	data, err := msg.Build()
+	if err != nil {
+		return "", fmt.Errorf("failed to build message: %w", err)
+	}
+
+	if err := c.Append(folder, []string{imap.DraftFlag, imap.SeenFlag}, time.Now(), bytes.NewReader(data)); err != nil {
+		return "", fmt.Errorf("failed to append to %s: %w", folder, err)
+	}
+	return folder, nil
+}
+
+func (es *EmailServer) deleteServerDraft(ctx context.Context, draft *storage.Draft) error {
+	c, err := es.connectIMAP(ctx, draft.AccountID)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	return removeServerDraft(c, draft.ServerFolder, draft.MessageID)
+}
+
+// removeServerDraft deletes the messages in folder carrying the given
+// Message-ID
+func removeServerDraft(c *client.Client, folder, messageID string) error {
+	if _, err := selectFolder(c, folder, false); err != nil {
+		return err
+	}
+
+	criteria := imap.NewSearchCriteria()
+	criteria.Header.Add("Message-Id", messageID)
+	uids, err := c.UidSearch(criteria)
+	if err != nil {
+		return fmt.Errorf("failed to search %s: %w", folder, err)
+	}
+	if len(uids) == 0 {
+		return nil
+	}
+
+	uidset := new(imap.SeqSet)
+	uidset.AddNum(uids...)
+	if err := imapext.UidDelete(c, uidset); err != nil {
+		return fmt.Errorf("failed to delete draft: %w", err)
+	}
+	return nil
+}
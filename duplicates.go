@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"email-mcp-server/storage"
+)
+
+func (es *EmailServer) handleFindDuplicates(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("duplicates are not available: %w", errNoDatabase)
+	}
+
+	// Duplicates usually span accounts, so all of them are searched unless
+	// one is named
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	if accountID != "" {
+		config, err := es.getConfig(accountID)
+		if err != nil {
+			return nil, err
+		}
+		accountID = config.ID
+	}
+	limit := 50
+	if l, ok := args["limit"].(float64); ok && l >= 1 {
+		limit = int(l)
+	}
+
+	duplicates, err := es.db.ListDuplicates(accountID, folder, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	if len(duplicates) == 0 {
+		b.WriteString("No duplicate emails found")
+	} else {
+		fmt.Fprintf(&b, "%d duplicate emails:\n", len(duplicates))
+		for _, dup := range duplicates {
+			reason := "same Message-ID"
+			if dup.Reason != storage.DuplicateMessageID {
+				reason = "same content"
+			}
+			fmt.Fprintf(&b, "- %s %s #%d %q from %s is a copy of %s %s #%d (%s)\n",
+				dup.Email.AccountID, dup.Email.Folder, dup.Email.UID, dup.Email.Subject, dup.Email.From,
+				dup.OriginalAccountID, dup.OriginalFolder, dup.OriginalUID, reason)
+		}
+	}
+
+	duplicatesJSON, _ := json.MarshalIndent(duplicates, "", "  ")
+	return ToolResult{
+		Content: []TextContent{
+			{Type: "text", Text: strings.TrimRight(b.String(), "\n")},
+			{Type: "text", Text: string(duplicatesJSON)},
+		},
+	}, nil
+}
+
+// withoutDuplicates drops the emails of a folder that sync recorded as
+// copies of an earlier email
+func (es *EmailServer) withoutDuplicates(accountID, folder string, emails []EmailMessage) ([]EmailMessage, error) {
+	if es.db == nil {
+		return emails, nil
+	}
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+	if folder == "" {
+		folder = "INBOX"
+	}
+
+	duplicates, err := es.db.DuplicateUIDs(config.ID, folder)
+	if err != nil {
+		return nil, err
+	}
+	kept := emails[:0]
+	for _, email := range emails {
+		if !duplicates[email.ID] {
+			kept = append(kept, email)
+		}
+	}
+	return kept, nil
+}
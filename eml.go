@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+
+	"email-mcp-server/mail"
+)
+
+// getRawEmail fetches the full RFC 822 source of a message, unparsed
+func (es *EmailServer) getRawEmail(ctx context.Context, accountID, folder string, uid uint32) ([]byte, error) {
+	c, err := es.connectIMAP(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	if _, err := selectFolder(c, folder, true); err != nil {
+		return nil, err
+	}
+
+	uidset := new(imap.SeqSet)
+	uidset.AddNum(uid)
+	section := &imap.BodySectionName{Peek: true}
+
+	messages := make(chan *imap.Message, 1)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- c.UidFetch(uidset, []imap.FetchItem{imap.FetchUid, section.FetchItem()}, messages)
+	}()
+
+	var data []byte
+	var readErr error
+	for msg := range messages {
+		if r := msg.GetBody(section); r != nil {
+			data, readErr = io.ReadAll(r)
+		}
+	}
+
+	if err := <-done; err != nil {
+		return nil, err
+	}
+	if readErr != nil {
+		return nil, fmt.Errorf("failed to read email: %w", readErr)
+	}
+	if data == nil {
+		return nil, fmt.Errorf("email with ID %d %w", uid, errNotFound)
+	}
+
+	return data, nil
+}
+
+// downloadsPath resolves path inside the downloads directory, relative
+// paths from the directory itself. Paths leading out of it are refused, so
+// tools cannot read or write other files of the user.
+func (es *EmailServer) downloadsPath(path string) (string, error) {
+	root, err := filepath.Abs(es.downloadsDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve downloads directory: %w", err)
+	}
+	resolved := path
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(root, resolved)
+	}
+	rel, err := filepath.Rel(root, filepath.Clean(resolved))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", invalidArgument("path %s is outside the downloads directory %s", path, root)
+	}
+	return filepath.Join(root, rel), nil
+}
+
+// exportPath resolves where export_email writes a message, inside the
+// downloads directory; a directory gets a file named after the email ID and
+// subject
+func (es *EmailServer) exportPath(path string, uid uint32, subject string) (string, error) {
+	isDir := strings.HasSuffix(path, "/") || strings.HasSuffix(path, string(filepath.Separator))
+	path, err := es.downloadsPath(path)
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		isDir = true
+	}
+	if isDir {
+		name := strings.Map(func(r rune) rune {
+			if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+				return '_'
+			}
+			return r
+		}, strings.TrimSpace(subject))
+		if len(name) > 60 {
+			name = strings.ToValidUTF8(name[:60], "")
+		}
+		if name == "" {
+			name = "email"
+		}
+		path = filepath.Join(path, fmt.Sprintf("%d-%s.eml", uid, name))
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create export directory: %w", err)
+	}
+	return path, nil
+}
+
+func (es *EmailServer) handleExportEmail(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	id, ok := args["id"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("%w: id", errMissingParameter)
+	}
+	path, _ := args["path"].(string)
+
+	data, err := es.getRawEmail(ctx, accountID, folder, uint32(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to export email: %w", err)
+	}
+
+	subject := ""
+	if parsed, err := mail.ParseBytes(data); err == nil {
+		subject = parsed.Header("Subject")
+	}
+
+	if path == "" {
+		result, _ := json.MarshalIndent(map[string]interface{}{
+			"id":      uint32(id),
+			"subject": subject,
+			"size":    len(data),
+			"content": base64.StdEncoding.EncodeToString(data),
+		}, "", "  ")
+		return ToolResult{
+			Content: []TextContent{{
+				Type: "text",
+				Text: string(result),
+			}},
+		}, nil
+	}
+
+	path, err = es.exportPath(path, uint32(id), subject)
+	if err != nil {
+		return nil, err
+	}
+	// Never overwrite an earlier export
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save email: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to save email: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("failed to save email: %w", err)
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Email %d (%d bytes) exported to %s", uint32(id), len(data), path),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleImportEML(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	if folder == "" {
+		folder = "INBOX"
+	}
+	seen := true
+	if s, ok := args["seen"].(bool); ok {
+		seen = s
+	}
+
+	var data []byte
+	path, _ := args["path"].(string)
+	content, _ := args["content"].(string)
+	var err error
+	switch {
+	case path != "":
+		if path, err = es.downloadsPath(path); err != nil {
+			return nil, err
+		}
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	case content != "":
+		if data, err = base64.StdEncoding.DecodeString(content); err != nil {
+			return nil, invalidArgument("invalid base64 content: %w", err)
+		}
+	default:
+		return nil, invalidArgument("missing required parameters: path or content")
+	}
+
+	// IMAP requires CRLF line endings; .eml files saved on Unix often have
+	// bare LFs
+	data = bytes.ReplaceAll(bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+
+	parsed, err := mail.ParseBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("not a valid email: %w", err)
+	}
+	if parsed.Header("From") == "" && parsed.Header("Date") == "" && parsed.Header("Message-Id") == "" {
+		return nil, fmt.Errorf("not a valid email: no From, Date or Message-ID header")
+	}
+
+	// Keep the original date so the message sorts where it belongs
+	date, err := netmail.ParseDate(parsed.Header("Date"))
+	if err != nil {
+		date = time.Now()
+	}
+	var flags []string
+	if seen {
+		flags = append(flags, imap.SeenFlag)
+	}
+
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+	c, err := es.connectIMAP(ctx, config.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	if err := c.Append(folder, flags, date, bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("failed to append to %s: %w", folder, err)
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Imported \"%s\" from %s (%d bytes) into %s", parsed.Header("Subject"), parsed.Header("From"), len(data), folder),
+		}},
+	}, nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap"
+)
+
+const sampleEML = "From: ana@example.com\nTo: username\nSubject: Q3 report: draft?\nDate: Mon, 02 Jan 2006 15:04:05 +0000\n\nSee attached.\n"
+
+func TestImportExportEML(t *testing.T) {
+	es := newTestServer(t)
+	es.downloadsDir = t.TempDir()
+	ctx := context.Background()
+	if err := es.createFolder(ctx, "work", "Samples"); err != nil {
+		t.Fatalf("createFolder: %v", err)
+	}
+
+	os.WriteFile(filepath.Join(es.downloadsDir, "sample.eml"), []byte(sampleEML), 0o644)
+	if _, err := es.handleImportEML(ctx, map[string]interface{}{"path": "sample.eml", "folder": "Samples"}); err != nil {
+		t.Fatalf("handleImportEML: %v", err)
+	}
+	if counts := folderMessages(t, es); counts["Samples"] != 1 {
+		t.Fatalf("messages after import = %v, want 1 in Samples", counts)
+	}
+	// The first message of a new folder
+	const uid = 1
+	if !hasFlag(messageFlags(t, es, "Samples", uid), imap.SeenFlag) {
+		t.Error("imported email not marked seen")
+	}
+
+	// Line endings are converted to CRLF on import
+	want := strings.ReplaceAll(sampleEML, "\n", "\r\n")
+	result, err := es.handleExportEmail(ctx, map[string]interface{}{"folder": "Samples", "id": float64(uid)})
+	if err != nil {
+		t.Fatalf("handleExportEmail: %v", err)
+	}
+	var exported struct {
+		Subject string `json:"subject"`
+		Content string `json:"content"`
+	}
+	json.Unmarshal([]byte(resultText(t, result)), &exported)
+	if data, _ := base64.StdEncoding.DecodeString(exported.Content); string(data) != want || exported.Subject != "Q3 report: draft?" {
+		t.Errorf("exported %q, %q; want the imported email", exported.Subject, data)
+	}
+
+	// A directory gets a file named after the email, with the characters
+	// file systems reject replaced
+	if _, err := es.handleExportEmail(ctx, map[string]interface{}{"folder": "Samples", "id": float64(uid), "path": "archive/"}); err != nil {
+		t.Fatalf("handleExportEmail to a directory: %v", err)
+	}
+	path := filepath.Join(es.downloadsDir, "archive", "1-Q3 report_ draft_.eml")
+	if data, err := os.ReadFile(path); err != nil || string(data) != want {
+		t.Errorf("exported file = %q, %v", data, err)
+	}
+	if _, err := es.handleExportEmail(ctx, map[string]interface{}{"folder": "Samples", "id": float64(uid), "path": path}); err == nil {
+		t.Error("exporting over an earlier export succeeded")
+	}
+}
+
+func TestImportEMLRejects(t *testing.T) {
+	es := newTestServer(t)
+	es.downloadsDir = t.TempDir()
+	ctx := context.Background()
+	for name, args := range map[string]map[string]interface{}{
+		"no source":      {},
+		"invalid base64": {"content": "not base64!"},
+		"not an email":   {"content": base64.StdEncoding.EncodeToString([]byte("just some text\n"))},
+		"missing file":   {"path": "missing.eml"},
+	} {
+		if _, err := es.handleImportEML(ctx, args); err == nil {
+			t.Errorf("%s: handleImportEML succeeded", name)
+		}
+	}
+	if counts := folderMessages(t, es); counts["INBOX"] != 1 {
+		t.Errorf("rejected imports changed the INBOX: %v", counts)
+	}
+}
+
+func TestEMLPathsStayInDownloads(t *testing.T) {
+	es := newTestServer(t)
+	es.downloadsDir = filepath.Join(t.TempDir(), "downloads")
+	ctx := context.Background()
+
+	// A readable email just outside the downloads directory
+	outside := filepath.Join(filepath.Dir(es.downloadsDir), "secret.eml")
+	os.WriteFile(outside, []byte(sampleEML), 0o644)
+	for _, path := range []string{outside, "../secret.eml", "a/../../secret.eml"} {
+		if _, err := es.handleImportEML(ctx, map[string]interface{}{"path": path}); err == nil || !strings.Contains(err.Error(), "outside the downloads directory") {
+			t.Errorf("import_eml of %s = %v, want it refused", path, err)
+		}
+		if _, err := es.handleExportEmail(ctx, map[string]interface{}{"id": float64(6), "path": path}); err == nil || !strings.Contains(err.Error(), "outside the downloads directory") {
+			t.Errorf("export_email to %s = %v, want it refused", path, err)
+		}
+	}
+	if data, _ := os.ReadFile(outside); string(data) != sampleEML {
+		t.Errorf("file outside the downloads directory changed to %q", data)
+	}
+	if counts := folderMessages(t, es); counts["INBOX"] != 1 {
+		t.Errorf("messages = %v, want nothing imported", counts)
+	}
+
+	// Absolute paths inside the directory are fine
+	path := filepath.Join(es.downloadsDir, "inbox", "6.eml")
+	if _, err := es.handleExportEmail(ctx, map[string]interface{}{"id": float64(6), "path": path}); err != nil {
+		t.Fatalf("export_email to %s: %v", path, err)
+	}
+	if _, err := es.handleImportEML(ctx, map[string]interface{}{"path": path}); err != nil {
+		t.Errorf("import_eml of %s: %v", path, err)
+	}
+}
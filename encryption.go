@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"email-mcp-server/credentials"
+	"email-mcp-server/storage"
+)
+
+// DATABASE_ENCRYPTION keeps the local database encrypted on disk, in
+// DATABASE_PATH with a .enc suffix. The key comes from DATABASE_PASSPHRASE
+// (passphrase) or from a random passphrase the server keeps in the OS
+// keychain (keyring). An existing plain database is converted once with
+// `email-mcp-server encrypt-database`.
+
+// databaseKeyringEntry is the keychain entry holding the database passphrase
+const databaseKeyringEntry = "database-encryption"
+
+// openDatabase opens the local database, encrypted when configured
+func openDatabase() (*storage.Database, error) {
+	path := databasePath()
+	mode := getEnv("DATABASE_ENCRYPTION", "off")
+	if mode == "off" {
+		if _, err := os.Stat(path + ".enc"); err == nil {
+			return nil, fmt.Errorf("%s.enc is encrypted: set DATABASE_ENCRYPTION to open it", path)
+		}
+		return storage.New(path)
+	}
+
+	passphrase, err := databasePassphrase(mode)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path + ".enc"); os.IsNotExist(err) {
+		if _, err := os.Stat(path); err == nil {
+			return nil, fmt.Errorf("%s is not encrypted yet: stop the server and run `email-mcp-server encrypt-database`", path)
+		}
+	}
+	flush := time.Duration(getEnvInt("DATABASE_FLUSH_SECONDS", 30)) * time.Second
+	return storage.OpenEncrypted(path+".enc", passphrase, flush)
+}
+
+// databasePassphrase returns the database passphrase of mode, generating
+// and storing the keychain passphrase the first time
+func databasePassphrase(mode string) (string, error) {
+	switch mode {
+	case "passphrase":
+		passphrase := os.Getenv("DATABASE_PASSPHRASE")
+		if passphrase == "" {
+			return "", fmt.Errorf("DATABASE_ENCRYPTION=passphrase needs DATABASE_PASSPHRASE")
+		}
+		return passphrase, nil
+	case "keyring":
+		store, err := credentials.Open(credentials.SourceKeyring, "", credentialOptions())
+		if err != nil {
+			return "", err
+		}
+		passphrase, err := store.Get(databaseKeyringEntry)
+		if err == nil || !errors.Is(err, credentials.ErrNotFound) {
+			return passphrase, err
+		}
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		passphrase = hex.EncodeToString(b)
+		if err := store.Set(databaseKeyringEntry, passphrase); err != nil {
+			return "", err
+		}
+		return passphrase, nil
+	}
+	return "", fmt.Errorf("unknown DATABASE_ENCRYPTION %q (use off, passphrase or keyring)", mode)
+}
+
+// encryptDatabase converts the plain database to an encrypted one and
+// removes the plain files once the encrypted copy opens
+func encryptDatabase() error {
+	path := databasePath()
+	mode := getEnv("DATABASE_ENCRYPTION", "off")
+	if mode == "off" {
+		return fmt.Errorf("set DATABASE_ENCRYPTION to passphrase or keyring first")
+	}
+	passphrase, err := databasePassphrase(mode)
+	if err != nil {
+		return err
+	}
+	if err := storage.EncryptDatabase(path, path+".enc", passphrase); err != nil {
+		return err
+	}
+
+	db, err := storage.OpenEncrypted(path+".enc", passphrase, 0)
+	if err != nil {
+		os.Remove(path + ".enc")
+		return fmt.Errorf("encrypted copy does not open, %s was kept: %w", path, err)
+	}
+	if err := db.Close(); err != nil {
+		return err
+	}
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if err := os.Remove(path + suffix); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("encrypted to %s.enc but failed to remove %s: %w", path, path+suffix, err)
+		}
+	}
+	fmt.Printf("Encrypted %s to %s.enc and removed the plain copy\n", path, path)
+	return nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+
+	"email-mcp-server/mail"
+	"email-mcp-server/storage"
+)
+
+// export_emails writes the synced emails matching a filter to a file: CSV or
+// JSON Lines of the metadata with classifications and priorities, for
+// spreadsheets and pandas, or an mbox of the full messages fetched from the
+// server, for archiving.
+
+// exportCSVHeader names the columns of a CSV export
+var exportCSVHeader = []string{"account", "folder", "uid", "message_id", "thread_id", "date", "from_name", "from_address",
+	"to", "subject", "size", "flags", "category", "confidence", "tags", "priority_score", "priority_level", "snippet"}
+
+// exportFetchBatch is the number of messages fetched per IMAP command of an
+// mbox export
+const exportFetchBatch = 100
+
+func (es *EmailServer) handleExportEmails(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("export is not available: %w", errNoDatabase)
+	}
+	format, _ := args["format"].(string)
+	if format != "csv" && format != "jsonl" && format != "mbox" {
+		return nil, invalidArgument("invalid format %q (use csv, jsonl or mbox)", format)
+	}
+
+	var filter storage.ExportFilter
+	filter.Folder, _ = args["folder"].(string)
+	filter.Query, _ = args["query"].(string)
+	filter.From, _ = args["from"].(string)
+	filter.Category, _ = args["category"].(string)
+	filter.UnreadOnly, _ = args["unread_only"].(bool)
+	if accountID, _ := args["account"].(string); accountID != "" {
+		config, err := es.getConfig(accountID)
+		if err != nil {
+			return nil, err
+		}
+		filter.AccountID = config.ID
+	}
+	if p, ok := args["min_priority"].(float64); ok {
+		filter.MinPriority = int(p)
+	}
+	if l, ok := args["limit"].(float64); ok && l > 0 {
+		filter.Limit = int(l)
+	}
+	for key, dest := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value, _ := args[key].(string); value != "" {
+			date, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				return nil, invalidArgument("invalid %s date %q, expected YYYY-MM-DD", key, value)
+			}
+			*dest = date
+		}
+	}
+
+	emails, err := es.db.ExportEmails(filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(emails) == 0 {
+		return nil, fmt.Errorf("no synced emails match the filter")
+	}
+
+	path, _ := args["path"].(string)
+	path, err = es.exportFilePath(path, format)
+	if err != nil {
+		return nil, err
+	}
+	// Never overwrite an earlier export
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create export: %w", err)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+
+	written, skipped := len(emails), 0
+	switch format {
+	case "csv":
+		err = writeExportCSV(w, emails)
+	case "jsonl":
+		err = writeExportJSONL(w, emails)
+	case "mbox":
+		written, err = es.writeExportMbox(ctx, w, emails)
+		skipped = len(emails) - written
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to export emails: %w", err)
+	}
+
+	text := fmt.Sprintf("Exported %d emails to %s", written, path)
+	if skipped > 0 {
+		text += fmt.Sprintf("; %d are no longer on the server and were skipped", skipped)
+	}
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: text,
+		}},
+	}, nil
+}
+
+// exportFilePath resolves where export_emails writes, inside the downloads
+// directory; a directory or no path gets a file named after the current time
+func (es *EmailServer) exportFilePath(path, format string) (string, error) {
+	name := "emails-" + time.Now().Format("20060102-150405") + "." + format
+	if path == "" {
+		path = name
+	}
+	path, err := es.downloadsPath(path)
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, name)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create export directory: %w", err)
+	}
+	return path, nil
+}
+
+func writeExportCSV(w io.Writer, emails []storage.ExportedEmail) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(exportCSVHeader); err != nil {
+		return err
+	}
+	for _, e := range emails {
+		score, confidence := "", ""
+		if e.PriorityScore != nil {
+			score = strconv.Itoa(*e.PriorityScore)
+		}
+		if e.Category != "" {
+			confidence = strconv.FormatFloat(e.Confidence, 'f', 2, 64)
+		}
+		record := []string{
+			e.AccountID, e.Folder, strconv.FormatUint(uint64(e.UID), 10), e.MessageID, e.ThreadID,
+			e.Date.Format(time.RFC3339), e.FromAddress.Name, e.FromAddress.Address,
+			strings.Join(e.To, "; "), e.Subject, strconv.FormatUint(uint64(e.Size), 10), strings.Join(e.Flags, " "),
+			e.Category, confidence, strings.Join(e.Tags, "; "), score, e.PriorityLevel, e.BodySnippet,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeExportJSONL(w io.Writer, emails []storage.ExportedEmail) error {
+	enc := json.NewEncoder(w)
+	for _, e := range emails {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeExportMbox fetches the full messages of emails from their folders
+// and writes them as an mbox, returning how many were found on the server
+func (es *EmailServer) writeExportMbox(ctx context.Context, w io.Writer, emails []storage.ExportedEmail) (int, error) {
+	type folderKey struct{ account, folder string }
+	var order []folderKey
+	groups := map[folderKey][]*storage.ExportedEmail{}
+	for i := range emails {
+		key := folderKey{emails[i].AccountID, emails[i].Folder}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], &emails[i])
+	}
+
+	written := 0
+	for _, key := range order {
+		n, err := es.writeFolderMbox(ctx, w, key.account, key.folder, groups[key])
+		written += n
+		if err != nil {
+			return written, fmt.Errorf("%s/%s: %w", key.account, key.folder, err)
+		}
+	}
+	return written, nil
+}
+
+// writeFolderMbox fetches emails of one folder over a single connection, in
+// batches of exportFetchBatch
+func (es *EmailServer) writeFolderMbox(ctx context.Context, w io.Writer, accountID, folder string, emails []*storage.ExportedEmail) (int, error) {
+	c, err := es.connectIMAP(ctx, accountID)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+	if _, err := selectFolder(c, folder, true); err != nil {
+		return 0, err
+	}
+
+	byUID := make(map[uint32]*storage.ExportedEmail, len(emails))
+	for _, e := range emails {
+		byUID[e.UID] = e
+	}
+	section := &imap.BodySectionName{Peek: true}
+	written := 0
+	for start := 0; start < len(emails); start += exportFetchBatch {
+		uidset := new(imap.SeqSet)
+		for _, e := range emails[start:min(start+exportFetchBatch, len(emails))] {
+			uidset.AddNum(e.UID)
+		}
+
+		messages := make(chan *imap.Message, 10)
+		done := make(chan error, 1)
+		go func() {
+			done <- c.UidFetch(uidset, []imap.FetchItem{imap.FetchUid, section.FetchItem()}, messages)
+		}()
+
+		var writeErr error
+		for msg := range messages {
+			e, r := byUID[msg.Uid], msg.GetBody(section)
+			if e == nil || r == nil || writeErr != nil {
+				continue
+			}
+			raw, err := io.ReadAll(r)
+			if err == nil {
+				err = mail.WriteMbox(w, e.FromAddress.Address, e.Date, raw)
+			}
+			if err != nil {
+				writeErr = err
+				continue
+			}
+			written++
+		}
+		if err := <-done; err != nil {
+			return written, err
+		}
+		if writeErr != nil {
+			return written, writeErr
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportFilePath(t *testing.T) {
+	es := &EmailServer{downloadsDir: filepath.Join(t.TempDir(), "downloads")}
+
+	path, err := es.exportFilePath("2024/inbox.csv", "csv")
+	if want := filepath.Join(es.downloadsDir, "2024", "inbox.csv"); err != nil || path != want {
+		t.Errorf("exportFilePath() = %q, %v; want %q", path, err, want)
+	}
+	if path, err := es.exportFilePath("", "jsonl"); err != nil || filepath.Dir(path) != es.downloadsDir || !strings.HasSuffix(path, ".jsonl") {
+		t.Errorf("default export path = %q, %v", path, err)
+	}
+
+	outside := filepath.Join(filepath.Dir(es.downloadsDir), "elsewhere")
+	for _, path := range []string{"../elsewhere/out.csv", filepath.Join(outside, "out.csv")} {
+		if _, err := es.exportFilePath(path, "csv"); err == nil {
+			t.Errorf("exportFilePath(%q) succeeded, want it refused", path)
+		}
+	}
+	if _, err := os.Stat(outside); err == nil {
+		t.Error("a directory was created outside the downloads directory")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/emersion/go-imap"
+)
+
+// messageFlags returns the flags of message uid in folder of account work
+func messageFlags(t *testing.T, es *EmailServer, folder string, uid uint32) []string {
+	t.Helper()
+	c, err := es.connectIMAP(context.Background(), "work")
+	if err != nil {
+		t.Fatalf("connectIMAP: %v", err)
+	}
+	defer c.Close()
+	if _, err := c.Select(folder, true); err != nil {
+		t.Fatalf("Select: %v", err)
+	}
+
+	uidset := new(imap.SeqSet)
+	uidset.AddNum(uid)
+	messages := make(chan *imap.Message, 1)
+	if err := c.UidFetch(uidset, []imap.FetchItem{imap.FetchFlags}, messages); err != nil {
+		t.Fatalf("UidFetch: %v", err)
+	}
+	msg := <-messages
+	if msg == nil {
+		t.Fatalf("message %d not found", uid)
+	}
+	return msg.Flags
+}
+
+func TestSetFlags(t *testing.T) {
+	es := newTestServer(t)
+	ctx := context.Background()
+
+	if err := es.setFlags(ctx, "work", "INBOX", 6, []string{"read", `\Flagged`, "processed"}, nil); err != nil {
+		t.Fatalf("setFlags: %v", err)
+	}
+	flags := messageFlags(t, es, "INBOX", 6)
+	for _, want := range []string{imap.SeenFlag, imap.FlaggedFlag, "processed"} {
+		if !slices.Contains(flags, want) {
+			t.Errorf("flags = %v, want %s among them", flags, want)
+		}
+	}
+
+	if err := es.setFlags(ctx, "work", "INBOX", 6, []string{"answered"}, []string{"starred", "seen"}); err != nil {
+		t.Fatalf("setFlags: %v", err)
+	}
+	flags = messageFlags(t, es, "INBOX", 6)
+	if slices.Contains(flags, imap.SeenFlag) || slices.Contains(flags, imap.FlaggedFlag) {
+		t.Errorf("flags = %v, want \\Seen and \\Flagged removed", flags)
+	}
+	if !slices.Contains(flags, imap.AnsweredFlag) || !slices.Contains(flags, "processed") {
+		t.Errorf("flags = %v, want \\Answered and processed kept", flags)
+	}
+
+	if err := es.setFlags(ctx, "work", "Missing", 6, []string{"seen"}, nil); err == nil {
+		t.Error("setFlags in a missing folder succeeded")
+	}
+
+	for _, flag := range []string{"deleted", `\Deleted`} {
+		if err := es.setFlags(ctx, "work", "INBOX", 6, []string{flag}, nil); err == nil {
+			t.Errorf("setFlags added %s", flag)
+		}
+	}
+	if slices.Contains(messageFlags(t, es, "INBOX", 6), imap.DeletedFlag) {
+		t.Error("message marked \\Deleted by setFlags")
+	}
+}
+
+func TestNormalizeFlag(t *testing.T) {
+	tests := map[string]string{
+		"seen":      imap.SeenFlag,
+		"Read":      imap.SeenFlag,
+		`\Seen`:     imap.SeenFlag,
+		"starred":   imap.FlaggedFlag,
+		"FLAGGED":   imap.FlaggedFlag,
+		"answered":  imap.AnsweredFlag,
+		"draft":     imap.DraftFlag,
+		`\deleted`:  imap.DeletedFlag,
+		"processed": "processed",
+		"$Label1":   "$Label1",
+	}
+	for flag, want := range tests {
+		if got := normalizeFlag(flag); got != want {
+			t.Errorf("normalizeFlag(%q) = %q, want %q", flag, got, want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFolderManagement(t *testing.T) {
+	es := newTestServer(t)
+	ctx := context.Background()
+
+	if err := es.createFolder(ctx, "work", "Receipts"); err != nil {
+		t.Fatalf("createFolder: %v", err)
+	}
+	if err := es.createFolder(ctx, "work", "Receipts"); err == nil {
+		t.Error("createFolder of an existing folder succeeded")
+	}
+	if err := es.renameFolder(ctx, "work", "Receipts", "Invoices"); err != nil {
+		t.Fatalf("renameFolder: %v", err)
+	}
+	counts := folderMessages(t, es)
+	if _, ok := counts["Receipts"]; ok {
+		t.Error("renamed folder still listed under its old name")
+	}
+	if _, ok := counts["Invoices"]; !ok || counts["INBOX"] != 1 {
+		t.Errorf("folders = %v, want INBOX with 1 message and Invoices", counts)
+	}
+
+	for _, name := range []string{"INBOX", "inbox"} {
+		if err := es.deleteFolder(ctx, "work", name); err == nil {
+			t.Errorf("deleteFolder(%s) succeeded", name)
+		}
+	}
+	if err := es.deleteFolder(ctx, "work", "Invoices"); err != nil {
+		t.Fatalf("deleteFolder: %v", err)
+	}
+	if _, ok := folderMessages(t, es)["Invoices"]; ok {
+		t.Error("deleted folder still listed")
+	}
+}
+
+func TestFolderParameter(t *testing.T) {
+	es := newTestServer(t)
+	ctx := context.Background()
+	if err := es.createFolder(ctx, "work", "Archive"); err != nil {
+		t.Fatalf("createFolder: %v", err)
+	}
+	uid := appendMessage(t, es, "Archive", "From: ana@example.com\nSubject: Archived\n\nOld news\n")
+
+	emails, err := es.getEmails(ctx, "work", "Archive", 10, false)
+	if err != nil {
+		t.Fatalf("getEmails: %v", err)
+	}
+	if len(emails) != 1 || emails[0].Subject != "Archived" {
+		t.Fatalf("getEmails(Archive) = %+v, want the archived email", emails)
+	}
+
+	if err := es.deleteEmail(ctx, "work", "Archive", uid); err != nil {
+		t.Fatalf("deleteEmail: %v", err)
+	}
+	if counts := folderMessages(t, es); counts["Archive"] != 0 || counts["INBOX"] != 1 {
+		t.Errorf("messages after delete = %v, want Archive 0 and INBOX untouched", counts)
+	}
+
+	if _, err := es.getEmails(ctx, "work", "Missing", 10, false); err == nil {
+		t.Error("getEmails of a missing folder succeeded")
+	}
+}
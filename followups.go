@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"email-mcp-server/storage"
+)
+
+// Follow-ups are resolved by the sync engine when a synced email references
+// the tracked Message-ID, so only replies that reach a synced folder count.
+
+func (es *EmailServer) handleTrackFollowup(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("follow-up tracking is not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	replyBy, _ := args["reply_by"].(string)
+	if replyBy == "" {
+		return nil, fmt.Errorf("%w: reply_by", errMissingParameter)
+	}
+	deadline, err := parseLaterTime("reply_by", replyBy, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	followup := &storage.Followup{AccountID: config.ID, ReplyBy: deadline}
+	followup.MessageID, _ = args["message_id"].(string)
+	followup.Subject, _ = args["subject"].(string)
+
+	if id, ok := args["id"].(float64); ok {
+		headers, err := es.getEmailHeaders(ctx, config.ID, folder, uint32(id))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get email: %w", err)
+		}
+		followup.MessageID = headers.Header("Message-Id")
+		followup.Subject = headers.Header("Subject")
+		followup.To = parseRecipients(headers.Header("To"))
+		if followup.MessageID == "" {
+			return nil, fmt.Errorf("email %d has no Message-ID, so replies to it cannot be recognized", uint32(id))
+		}
+	}
+	if followup.MessageID == "" {
+		return nil, fmt.Errorf("%w: message_id or id", errMissingParameter)
+	}
+	if !strings.HasPrefix(followup.MessageID, "<") {
+		followup.MessageID = "<" + followup.MessageID + ">"
+	}
+
+	if err := es.db.TrackFollowup(followup); err != nil {
+		return nil, err
+	}
+
+	text := fmt.Sprintf("Waiting for a reply to %s until %s (follow-up ID: %d)",
+		followup.MessageID, deadline.Local().Format("2006-01-02 15:04 MST"), followup.ID)
+	if followup.Status == storage.FollowupResolved {
+		text = fmt.Sprintf("%s has already been answered by %s", followup.MessageID, followup.ReplyFrom)
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: text,
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handlePendingFollowups(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("follow-up tracking is not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	if accountID != "" {
+		config, err := es.getConfig(accountID)
+		if err != nil {
+			return nil, err
+		}
+		accountID = config.ID
+	}
+	overdueOnly, _ := args["overdue_only"].(bool)
+	includeResolved, _ := args["include_resolved"].(bool)
+
+	all, err := es.db.ListFollowups(accountID, includeResolved, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list follow-ups: %w", err)
+	}
+
+	var followups []storage.Followup
+	overdue := 0
+	for _, f := range all {
+		if f.Overdue {
+			overdue++
+		} else if overdueOnly {
+			continue
+		}
+		followups = append(followups, f)
+	}
+
+	followupsJSON, _ := json.MarshalIndent(followups, "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("%d follow-up(s), %d overdue:\n%s", len(followups), overdue, string(followupsJSON)),
+		}},
+	}, nil
+}
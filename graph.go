@@ -0,0 +1,502 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"email-mcp-server/graph"
+	"email-mcp-server/mail"
+	"email-mcp-server/storage"
+	emailsync "email-mcp-server/sync"
+)
+
+// Accounts with Provider "graph" are read and sent through Microsoft Graph
+// instead of IMAP and SMTP, for Microsoft 365 tenants that disable both. The
+// user signs in once with `email-mcp-server graph-login <account>`, which
+// keeps the refresh token in the account's PasswordSource. Graph messages
+// are numbered in the local database (see storage.GraphUID), so the
+// listing, reading, sending, moving, flagging and deleting tools take the
+// same IDs as for IMAP accounts; other tools report that the account is not
+// supported. Sync uses delta queries instead of UIDs.
+
+const (
+	providerIMAP  = "imap"
+	providerGraph = "graph"
+)
+
+// graphSentFolder is where Microsoft Graph keeps sent mail
+const graphSentFolder = "Sent Items"
+
+// isGraph reports whether the account uses Microsoft Graph
+func (c *EmailConfig) isGraph() bool {
+	return c.Provider == providerGraph
+}
+
+// graphOAuth returns the sign-in settings of a Graph account
+func (c *EmailConfig) graphOAuth() *graph.OAuth {
+	return &graph.OAuth{
+		Tenant:     c.GraphTenant,
+		ClientID:   c.GraphClientID,
+		Authority:  getEnv("GRAPH_AUTHORITY", ""),
+		HTTPClient: &http.Client{Timeout: c.timeout()},
+	}
+}
+
+// newGraphClient returns a client signed in with the refresh token of
+// config, storing each rotated token in the account's PasswordSource
+func newGraphClient(config *EmailConfig) (*graph.Client, error) {
+	if config.Password == "" {
+		return nil, fmt.Errorf("account %s is not signed in to Microsoft Graph: run email-mcp-server graph-login %s", config.ID, config.ID)
+	}
+	store, err := config.credentialStore()
+	if err != nil {
+		return nil, err
+	}
+	tokens := graph.NewTokenSource(config.graphOAuth(), config.Password)
+	accountID := config.ID
+	tokens.OnRefresh = func(refreshToken string) {
+		if err := store.Set(accountID, refreshToken); err != nil {
+			log.Printf("Failed to store the refreshed Microsoft Graph token of account %s: %v", accountID, err)
+		}
+	}
+	client := graph.NewClient(tokens)
+	client.BaseURL = getEnv("GRAPH_BASE_URL", "")
+	client.HTTPClient = &http.Client{Timeout: config.timeout()}
+	return client, nil
+}
+
+// graphClient returns the Graph client of an account, shared by every call
+// so access tokens are reused, after waiting for the account's rate limit
+// and making sure it is signed in. Graph accounts need the local database to
+// number their messages.
+func (es *EmailServer) graphClient(ctx context.Context, config *EmailConfig) (*graph.Client, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("account %s uses Microsoft Graph, which needs the local database: it could not be opened", config.ID)
+	}
+	if err := es.accountLimits(config).imap.Wait(ctx); err != nil {
+		return nil, fmt.Errorf("gave up waiting for the %w of account %s: %w", errRateLimit, config.ID, err)
+	}
+
+	if err := es.loginAllowed(config.ID, serverGraph); err != nil {
+		return nil, err
+	}
+
+	es.graphMu.Lock()
+	client, ok := es.graphClients[config.ID]
+	if !ok {
+		var err error
+		if client, err = newGraphClient(config); err != nil {
+			es.graphMu.Unlock()
+			return nil, err
+		}
+		es.graphClients[config.ID] = client
+	}
+	es.graphMu.Unlock()
+
+	// The access token is cached, so this only signs in when it expires
+	_, err := client.Tokens.AccessToken(ctx)
+	es.recordLogin(ctx, config, serverGraph, err)
+	if err != nil {
+		return nil, loginFailure(err)
+	}
+	return client, nil
+}
+
+// graphMessageID returns the client of an account and the Graph ID of the
+// message numbered uid
+func (es *EmailServer) graphMessageID(ctx context.Context, config *EmailConfig, uid uint32) (*graph.Client, string, error) {
+	client, err := es.graphClient(ctx, config)
+	if err != nil {
+		return nil, "", err
+	}
+	id, err := es.db.GraphID(config.ID, uid)
+	if err != nil {
+		return nil, "", err
+	}
+	return client, id, nil
+}
+
+// graphFolderID resolves a folder name, INBOX when empty
+func graphFolderID(ctx context.Context, client *graph.Client, folder string) (string, error) {
+	if folder == "" {
+		folder = "INBOX"
+	}
+	return client.FolderID(ctx, folder)
+}
+
+// graphEmailMessage converts the metadata of a Graph message
+func graphEmailMessage(msg *graph.Message, uid uint32) EmailMessage {
+	from := graphAddress(msg.From)
+	email := EmailMessage{
+		ID:          uid,
+		Subject:     msg.Subject,
+		From:        from.String(),
+		FromAddress: from,
+		To:          graphAddresses(msg.ToRecipients),
+		Date:        msg.ReceivedDateTime,
+		Flags:       graphFlags(msg),
+	}
+	return email
+}
+
+func graphAddress(r *graph.Recipient) mail.Address {
+	if r == nil {
+		return mail.Address{}
+	}
+	return mail.Address{Name: r.EmailAddress.Name, Address: r.EmailAddress.Address}
+}
+
+func graphAddresses(recipients []graph.Recipient) []string {
+	var addrs []string
+	for _, r := range recipients {
+		addrs = append(addrs, r.EmailAddress.Address)
+	}
+	return addrs
+}
+
+// graphFlags maps the state of a Graph message to IMAP flags
+func graphFlags(msg *graph.Message) []string {
+	flags := []string{}
+	if msg.IsRead {
+		flags = append(flags, `\Seen`)
+	}
+	if msg.Flagged() {
+		flags = append(flags, `\Flagged`)
+	}
+	if msg.IsDraft {
+		flags = append(flags, `\Draft`)
+	}
+	return flags
+}
+
+// graphEmailPage is getEmailPage for Graph accounts. Cursors hold the
+// number of emails already listed.
+func (es *EmailServer) graphEmailPage(ctx context.Context, config *EmailConfig, folder string, limit int, withBody bool, cursor string) ([]EmailMessage, string, error) {
+	client, err := es.graphClient(ctx, config)
+	if err != nil {
+		return nil, "", err
+	}
+	folderID, err := graphFolderID(ctx, client, folder)
+	if err != nil {
+		return nil, "", err
+	}
+	skip := 0
+	if cursor != "" {
+		values, err := decodeCursor(cursor, "graph", 1)
+		if err != nil {
+			return nil, "", err
+		}
+		skip = int(values[0])
+	}
+	if limit <= 0 || limit > 1000 {
+		limit = 1000
+	}
+
+	messages, more, err := client.Messages(ctx, folderID, limit, skip)
+	if err != nil {
+		return nil, "", err
+	}
+	redact := config.redactKinds()
+	emails := make([]EmailMessage, 0, len(messages))
+	for i := range messages {
+		uid, err := es.db.GraphUID(config.ID, messages[i].ID)
+		if err != nil {
+			return nil, "", err
+		}
+		email := graphEmailMessage(&messages[i], uid)
+		if withBody {
+			if parsed, err := graphParsed(ctx, client, messages[i].ID); err == nil {
+				email.setBodies(parsed, es.bodyLimit)
+				if report := checkPhishing(parsed); report.Score > 0 {
+					email.Risk = &report
+				}
+				email.redact(redact)
+			}
+		}
+		emails = append(emails, email)
+	}
+
+	next := ""
+	if more {
+		next = encodeCursor("graph", uint64(skip+len(messages)))
+	}
+	return emails, next, nil
+}
+
+// graphParsed downloads and decodes the MIME content of a message
+func graphParsed(ctx context.Context, client *graph.Client, id string) (*mail.ParsedEmail, error) {
+	raw, err := client.MIME(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := mail.ParseBytes(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse email: %w", err)
+	}
+	return parsed, nil
+}
+
+// graphEmailBody is getEmailBody for Graph accounts
+func (es *EmailServer) graphEmailBody(ctx context.Context, config *EmailConfig, uid uint32) (*EmailMessage, error) {
+	client, id, err := es.graphMessageID(ctx, config, uid)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := client.Message(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := graphParsed(ctx, client, id)
+	if err != nil {
+		return nil, err
+	}
+
+	email := graphEmailMessage(msg, uid)
+	email.setBodies(parsed, es.bodyLimit)
+	email.Meetings = parsed.Events()
+	if report := checkPhishing(parsed); report.Score > 0 {
+		email.Risk = &report
+	}
+	email.redact(config.redactKinds())
+	return &email, nil
+}
+
+// graphParsedEmail is fetchParsed for Graph accounts, which always download
+// the whole message
+func (es *EmailServer) graphParsedEmail(ctx context.Context, config *EmailConfig, uid uint32) (*mail.ParsedEmail, error) {
+	client, id, err := es.graphMessageID(ctx, config, uid)
+	if err != nil {
+		return nil, err
+	}
+	return graphParsed(ctx, client, id)
+}
+
+// graphMove moves a message to the folder named destination
+func (es *EmailServer) graphMove(ctx context.Context, config *EmailConfig, uid uint32, destination string) error {
+	if destination == "" {
+		return fmt.Errorf("destination folder is required")
+	}
+	client, id, err := es.graphMessageID(ctx, config, uid)
+	if err != nil {
+		return err
+	}
+	folderID, err := client.FolderID(ctx, destination)
+	if err != nil {
+		return err
+	}
+	if _, err := client.Move(ctx, id, folderID); err != nil {
+		return fmt.Errorf("failed to move email to %s: %w", destination, err)
+	}
+	return nil
+}
+
+// graphDelete moves a message to the account's TrashFolder, or to Deleted
+// Items as Outlook does. Deleting from Deleted Items is permanent.
+func (es *EmailServer) graphDelete(ctx context.Context, config *EmailConfig, folder string, uid uint32) error {
+	if config.movesToTrash(folder) {
+		return es.graphMove(ctx, config, uid, config.TrashFolder)
+	}
+	client, id, err := es.graphMessageID(ctx, config, uid)
+	if err != nil {
+		return err
+	}
+	return client.Delete(ctx, id)
+}
+
+// graphSetFlags is setFlags for Graph accounts, which only have the read
+// and flagged states
+func (es *EmailServer) graphSetFlags(ctx context.Context, config *EmailConfig, uid uint32, add, remove []string) error {
+	client, id, err := es.graphMessageID(ctx, config, uid)
+	if err != nil {
+		return err
+	}
+	for _, change := range []struct {
+		set   bool
+		flags []string
+	}{{true, add}, {false, remove}} {
+		for _, flag := range change.flags {
+			switch normalizeFlag(flag) {
+			case `\Seen`:
+				err = client.SetRead(ctx, id, change.set)
+			case `\Flagged`:
+				err = client.SetFlagged(ctx, id, change.set)
+			default:
+				return fmt.Errorf("flag %s is not supported by Microsoft Graph accounts (use seen or flagged)", flag)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to update flags: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// graphFolders is listFolders for Graph accounts; nested folders are named
+// by their path, e.g. "Inbox/Projects"
+func (es *EmailServer) graphFolders(ctx context.Context, config *EmailConfig) ([]FolderInfo, error) {
+	client, err := es.graphClient(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	folders, err := client.Folders(ctx)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]FolderInfo, 0, len(folders))
+	for _, f := range folders {
+		infos = append(infos, FolderInfo{Name: f.Path, Messages: uint32(f.TotalItemCount), Unseen: uint32(f.UnreadItemCount)})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos, nil
+}
+
+// testGraphAccount signs in to Microsoft Graph with the stored refresh token
+func testGraphAccount(ctx context.Context, config *EmailConfig) AccountTest {
+	result := AccountTest{Account: config.ID, IMAP: "ok (microsoft graph)", SMTP: "ok (microsoft graph)", Succeeded: true}
+	client, err := newGraphClient(config)
+	if err == nil {
+		_, err = client.Me(ctx)
+	}
+	if err != nil {
+		failed := fmt.Sprintf("failed: %v", err)
+		result.IMAP, result.SMTP, result.Succeeded = failed, failed, false
+		result.imapErr, result.smtpErr = err, err
+	}
+	return result
+}
+
+// graphDelta is the sync source of a Graph account
+type graphDelta struct {
+	es     *EmailServer
+	config *EmailConfig
+}
+
+// deltaSource returns the sync source of Graph accounts, nil for IMAP ones
+func (es *EmailServer) deltaSource(accountID string) emailsync.DeltaSource {
+	config, err := es.getConfig(accountID)
+	if err != nil || !config.isGraph() {
+		return nil
+	}
+	return &graphDelta{es: es, config: config}
+}
+
+func (g *graphDelta) SentFolder() string {
+	if g.config.SentFolder != "" {
+		return g.config.SentFolder
+	}
+	return graphSentFolder
+}
+
+func (g *graphDelta) Changes(ctx context.Context, folder, link string) ([]*storage.Email, string, error) {
+	client, err := g.es.graphClient(ctx, g.config)
+	if err != nil {
+		return nil, "", err
+	}
+	folderID, err := graphFolderID(ctx, client, folder)
+	if err != nil {
+		return nil, "", err
+	}
+	messages, next, err := client.Delta(ctx, folderID, link)
+	if graphErr, ok := err.(*graph.Error); ok && graphErr.Status == http.StatusGone && link != "" {
+		// The delta link expired; start over, keeping what was synced
+		log.Printf("Delta link of %s/%s expired, resyncing folder", g.config.ID, folder)
+		messages, next, err = client.Delta(ctx, folderID, "")
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	var emails []*storage.Email
+	var removed []uint32
+	for i := range messages {
+		msg := &messages[i]
+		uid, err := g.es.db.GraphUID(g.config.ID, msg.ID)
+		if err != nil {
+			return nil, "", err
+		}
+		if msg.Removed != nil {
+			// Deleted or moved out of the folder
+			removed = append(removed, uid)
+			continue
+		}
+		from := graphAddress(msg.From)
+		emails = append(emails, &storage.Email{
+			AccountID:   g.config.ID,
+			Folder:      folder,
+			UID:         uid,
+			MessageID:   msg.InternetMessageID,
+			Subject:     msg.Subject,
+			From:        from.String(),
+			FromAddress: from,
+			To:          graphAddresses(msg.ToRecipients),
+			Date:        msg.ReceivedDateTime,
+			Flags:       graphFlags(msg),
+		})
+	}
+	if len(removed) > 0 {
+		if err := g.es.db.DeleteEmails(g.config.ID, folder, removed); err != nil {
+			return nil, "", err
+		}
+	}
+	return emails, next, nil
+}
+
+func (g *graphDelta) Fetch(ctx context.Context, email *storage.Email) (*mail.ParsedEmail, error) {
+	return g.es.graphParsedEmail(ctx, g.config, email.UID)
+}
+
+// graphLoginCommand implements `email-mcp-server graph-login <account>`:
+// it signs in with the device code flow and stores the refresh token
+func graphLoginCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: email-mcp-server graph-login <account>")
+	}
+	configs, _, err := loadAccounts(accountsPath())
+	if err != nil {
+		return err
+	}
+	config, err := findAccount(configs, args[0])
+	if err != nil {
+		return err
+	}
+	if !config.isGraph() {
+		return fmt.Errorf("account %s does not use Microsoft Graph: set its Provider to graph", config.ID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Minute)
+	defer cancel()
+	oauth := config.graphOAuth()
+	code, err := oauth.StartDeviceLogin(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, code.Message)
+	token, err := oauth.WaitDeviceLogin(ctx, code)
+	if err != nil {
+		return err
+	}
+
+	store, err := config.credentialStore()
+	if err != nil {
+		return err
+	}
+	if err := store.Set(config.ID, token.RefreshToken); err != nil {
+		return fmt.Errorf("failed to store the refresh token: %w", err)
+	}
+	config.Password = token.RefreshToken
+	client, err := newGraphClient(config)
+	if err == nil {
+		var address string
+		if address, err = client.Me(ctx); err == nil {
+			fmt.Printf("Account %s signed in to Microsoft Graph as %s\n", config.ID, address)
+		}
+	}
+	return err
+}
@@ -0,0 +1,378 @@
+// Package graph is a minimal Microsoft Graph mail client, for Outlook and
+// Microsoft 365 accounts of tenants that block IMAP and SMTP
+package graph
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the Microsoft Graph v1.0 endpoint
+const DefaultBaseURL = "https://graph.microsoft.com/v1.0"
+
+// messageFields are the message properties fetched in listings
+const messageFields = "id,internetMessageId,conversationId,subject,from,toRecipients,ccRecipients,receivedDateTime,isRead,isDraft,flag,bodyPreview,hasAttachments"
+
+// Client calls Microsoft Graph on behalf of the signed-in user
+type Client struct {
+	BaseURL    string // DefaultBaseURL when empty
+	HTTPClient *http.Client
+	Tokens     *TokenSource
+}
+
+// NewClient returns a client authenticated by tokens
+func NewClient(tokens *TokenSource) *Client {
+	return &Client{Tokens: tokens}
+}
+
+// Error is an error response of Microsoft Graph
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("microsoft graph: %s: %s (HTTP %d)", e.Code, e.Message, e.Status)
+}
+
+// IsNotFound reports whether err is a Graph error for a missing item
+func IsNotFound(err error) bool {
+	graphErr, ok := err.(*Error)
+	return ok && graphErr.Status == http.StatusNotFound
+}
+
+// Recipient is an email address of a message
+type Recipient struct {
+	EmailAddress struct {
+		Name    string `json:"name"`
+		Address string `json:"address"`
+	} `json:"emailAddress"`
+}
+
+// Message is the metadata of a message
+type Message struct {
+	ID                string      `json:"id"`
+	InternetMessageID string      `json:"internetMessageId"`
+	ConversationID    string      `json:"conversationId"`
+	Subject           string      `json:"subject"`
+	From              *Recipient  `json:"from"`
+	ToRecipients      []Recipient `json:"toRecipients"`
+	CcRecipients      []Recipient `json:"ccRecipients"`
+	ReceivedDateTime  time.Time   `json:"receivedDateTime"`
+	IsRead            bool        `json:"isRead"`
+	IsDraft           bool        `json:"isDraft"`
+	Flag              struct {
+		FlagStatus string `json:"flagStatus"`
+	} `json:"flag"`
+	BodyPreview    string `json:"bodyPreview"`
+	HasAttachments bool   `json:"hasAttachments"`
+
+	// Removed is set on the items of a delta query that left the folder
+	Removed *struct {
+		Reason string `json:"reason"`
+	} `json:"@removed,omitempty"`
+}
+
+// Flagged reports whether the message is flagged for follow-up
+func (m *Message) Flagged() bool {
+	return m.Flag.FlagStatus == "flagged"
+}
+
+// Folder is a mail folder, with Path the names of its ancestors and its own
+// joined by "/"
+type Folder struct {
+	ID               string `json:"id"`
+	DisplayName      string `json:"displayName"`
+	ChildFolderCount int    `json:"childFolderCount"`
+	TotalItemCount   int    `json:"totalItemCount"`
+	UnreadItemCount  int    `json:"unreadItemCount"`
+	Path             string `json:"-"`
+}
+
+// wellKnownFolders maps IMAP folder names to the well-known names of Graph
+var wellKnownFolders = map[string]string{
+	"inbox":         "inbox",
+	"sent":          "sentitems",
+	"sent items":    "sentitems",
+	"sent messages": "sentitems",
+	"drafts":        "drafts",
+	"trash":         "deleteditems",
+	"deleted items": "deleteditems",
+	"junk":          "junkemail",
+	"junk email":    "junkemail",
+	"spam":          "junkemail",
+	"archive":       "archive",
+}
+
+func (c *Client) baseURL() string {
+	if c.BaseURL != "" {
+		return strings.TrimRight(c.BaseURL, "/")
+	}
+	return DefaultBaseURL
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// do sends a request to path, relative to the base URL unless absolute, and
+// decodes the JSON response into out when it is not nil. A throttled
+// request is retried once after the delay Graph asks for.
+func (c *Client) do(ctx context.Context, method, path, contentType string, body []byte, out interface{}) error {
+	if !strings.HasPrefix(path, "https://") && !strings.HasPrefix(path, "http://") {
+		path = c.baseURL() + path
+	}
+	for attempt := 0; ; attempt++ {
+		token, err := c.Tokens.AccessToken(ctx)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, method, path, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		// Immutable IDs survive moves between folders
+		req.Header.Set("Prefer", `IdType="ImmutableId"`)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		resp, err := c.httpClient().Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode == http.StatusTooManyRequests && attempt == 0 {
+			resp.Body.Close()
+			delay, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+			select {
+			case <-time.After(time.Duration(max(delay, 1)) * time.Second):
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode >= 300 {
+			var errResp struct {
+				Error struct {
+					Code    string `json:"code"`
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			json.NewDecoder(resp.Body).Decode(&errResp)
+			return &Error{Status: resp.StatusCode, Code: errResp.Error.Code, Message: errResp.Error.Message}
+		}
+		if out == nil {
+			return nil
+		}
+		if raw, ok := out.(*[]byte); ok {
+			*raw, err = io.ReadAll(resp.Body)
+			return err
+		}
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("invalid response from microsoft graph: %w", err)
+		}
+		return nil
+	}
+}
+
+func (c *Client) doJSON(ctx context.Context, method, path string, in, out interface{}) error {
+	var body []byte
+	contentType := ""
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+		contentType = "application/json"
+	}
+	return c.do(ctx, method, path, contentType, body, out)
+}
+
+// Me returns the email address of the signed-in user
+func (c *Client) Me(ctx context.Context) (string, error) {
+	var me struct {
+		Mail              string `json:"mail"`
+		UserPrincipalName string `json:"userPrincipalName"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/me?$select=mail,userPrincipalName", nil, &me); err != nil {
+		return "", err
+	}
+	if me.Mail != "" {
+		return me.Mail, nil
+	}
+	return me.UserPrincipalName, nil
+}
+
+// Folders returns every mail folder of the mailbox, parents first
+func (c *Client) Folders(ctx context.Context) ([]Folder, error) {
+	return c.folders(ctx, "/me/mailFolders?$top=100", "")
+}
+
+func (c *Client) folders(ctx context.Context, path, parent string) ([]Folder, error) {
+	var folders []Folder
+	for path != "" {
+		var page struct {
+			Value    []Folder `json:"value"`
+			NextLink string   `json:"@odata.nextLink"`
+		}
+		if err := c.doJSON(ctx, http.MethodGet, path, nil, &page); err != nil {
+			return nil, err
+		}
+		for _, f := range page.Value {
+			f.Path = f.DisplayName
+			if parent != "" {
+				f.Path = parent + "/" + f.DisplayName
+			}
+			folders = append(folders, f)
+			if f.ChildFolderCount > 0 {
+				children, err := c.folders(ctx, "/me/mailFolders/"+url.PathEscape(f.ID)+"/childFolders?$top=100", f.Path)
+				if err != nil {
+					return nil, err
+				}
+				folders = append(folders, children...)
+			}
+		}
+		path = page.NextLink
+	}
+	return folders, nil
+}
+
+// FolderID resolves an IMAP style folder name, such as INBOX or
+// "Projects/2024", to the ID of a Graph mail folder
+func (c *Client) FolderID(ctx context.Context, name string) (string, error) {
+	if wellKnown, ok := wellKnownFolders[strings.ToLower(name)]; ok {
+		var folder Folder
+		if err := c.doJSON(ctx, http.MethodGet, "/me/mailFolders/"+wellKnown+"?$select=id", nil, &folder); err == nil {
+			return folder.ID, nil
+		} else if !IsNotFound(err) {
+			return "", err
+		}
+	}
+	folders, err := c.Folders(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range folders {
+		if strings.EqualFold(f.Path, name) {
+			return f.ID, nil
+		}
+	}
+	return "", fmt.Errorf("folder %s not found", name)
+}
+
+// Messages returns a page of the messages of a folder, newest first, and
+// whether more follow
+func (c *Client) Messages(ctx context.Context, folderID string, top, skip int) ([]Message, bool, error) {
+	query := url.Values{
+		"$select":  {messageFields},
+		"$orderby": {"receivedDateTime desc"},
+		"$top":     {strconv.Itoa(top)},
+		"$skip":    {strconv.Itoa(skip)},
+	}
+	var page struct {
+		Value    []Message `json:"value"`
+		NextLink string    `json:"@odata.nextLink"`
+	}
+	path := "/me/mailFolders/" + url.PathEscape(folderID) + "/messages?" + query.Encode()
+	if err := c.doJSON(ctx, http.MethodGet, path, nil, &page); err != nil {
+		return nil, false, err
+	}
+	return page.Value, page.NextLink != "", nil
+}
+
+// Message returns the metadata of a message
+func (c *Client) Message(ctx context.Context, id string) (*Message, error) {
+	var msg Message
+	path := "/me/messages/" + url.PathEscape(id) + "?$select=" + messageFields
+	if err := c.doJSON(ctx, http.MethodGet, path, nil, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// MIME returns the full RFC 822 content of a message
+func (c *Client) MIME(ctx context.Context, id string) ([]byte, error) {
+	var raw []byte
+	if err := c.do(ctx, http.MethodGet, "/me/messages/"+url.PathEscape(id)+"/$value", "", nil, &raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// SendMIME sends an RFC 822 message. Graph saves a copy to Sent Items.
+func (c *Client) SendMIME(ctx context.Context, raw []byte) error {
+	body := []byte(base64.StdEncoding.EncodeToString(raw))
+	return c.do(ctx, http.MethodPost, "/me/sendMail", "text/plain", body, nil)
+}
+
+// Move moves a message to a folder and returns its ID there
+func (c *Client) Move(ctx context.Context, id, folderID string) (string, error) {
+	var moved Message
+	err := c.doJSON(ctx, http.MethodPost, "/me/messages/"+url.PathEscape(id)+"/move",
+		map[string]string{"destinationId": folderID}, &moved)
+	return moved.ID, err
+}
+
+// SetRead marks a message as read or unread
+func (c *Client) SetRead(ctx context.Context, id string, read bool) error {
+	return c.doJSON(ctx, http.MethodPatch, "/me/messages/"+url.PathEscape(id),
+		map[string]interface{}{"isRead": read}, nil)
+}
+
+// SetFlagged flags a message for follow-up or clears its flag
+func (c *Client) SetFlagged(ctx context.Context, id string, flagged bool) error {
+	status := "notFlagged"
+	if flagged {
+		status = "flagged"
+	}
+	return c.doJSON(ctx, http.MethodPatch, "/me/messages/"+url.PathEscape(id),
+		map[string]interface{}{"flag": map[string]string{"flagStatus": status}}, nil)
+}
+
+// Delete deletes a message, which Microsoft Graph moves to Deleted Items
+// unless it already is there
+func (c *Client) Delete(ctx context.Context, id string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/me/messages/"+url.PathEscape(id), nil, nil)
+}
+
+// Delta returns the messages of a folder added or changed since the delta
+// link of a previous call, or all of them when deltaLink is empty, with the
+// delta link for the next call. Removed messages have Removed set.
+func (c *Client) Delta(ctx context.Context, folderID, deltaLink string) ([]Message, string, error) {
+	path := deltaLink
+	if path == "" {
+		path = "/me/mailFolders/" + url.PathEscape(folderID) + "/messages/delta?$select=" + messageFields
+	}
+	var messages []Message
+	for {
+		var page struct {
+			Value     []Message `json:"value"`
+			NextLink  string    `json:"@odata.nextLink"`
+			DeltaLink string    `json:"@odata.deltaLink"`
+		}
+		if err := c.doJSON(ctx, http.MethodGet, path, nil, &page); err != nil {
+			return nil, "", err
+		}
+		messages = append(messages, page.Value...)
+		if page.NextLink == "" {
+			return messages, page.DeltaLink, nil
+		}
+		path = page.NextLink
+	}
+}
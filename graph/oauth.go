@@ -0,0 +1,221 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultAuthority is the Microsoft identity platform
+const DefaultAuthority = "https://login.microsoftonline.com"
+
+// Scopes are the delegated permissions requested at login. offline_access
+// returns the refresh token the server keeps instead of a password.
+const Scopes = "offline_access https://graph.microsoft.com/Mail.ReadWrite https://graph.microsoft.com/Mail.Send https://graph.microsoft.com/User.Read"
+
+// OAuth signs in to the Microsoft identity platform with the device code
+// flow of a public client application registration
+type OAuth struct {
+	Tenant     string // Directory (tenant) ID or domain; "common" when empty
+	ClientID   string // Application (client) ID
+	Authority  string // DefaultAuthority when empty
+	HTTPClient *http.Client
+}
+
+// Token is the result of a login or a refresh
+type Token struct {
+	AccessToken  string
+	RefreshToken string
+	Expiry       time.Time
+}
+
+// DeviceCode is the code the user enters at VerificationURI to sign in
+type DeviceCode struct {
+	UserCode        string `json:"user_code"`
+	DeviceCode      string `json:"device_code"`
+	VerificationURI string `json:"verification_uri"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+	Message         string `json:"message"` // Instructions for the user
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int    `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (o *OAuth) endpoint(path string) string {
+	authority, tenant := o.Authority, o.Tenant
+	if authority == "" {
+		authority = DefaultAuthority
+	}
+	if tenant == "" {
+		tenant = "common"
+	}
+	return strings.TrimRight(authority, "/") + "/" + url.PathEscape(tenant) + "/oauth2/v2.0/" + path
+}
+
+func (o *OAuth) httpClient() *http.Client {
+	if o.HTTPClient != nil {
+		return o.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (o *OAuth) post(ctx context.Context, path string, form url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.endpoint(path), strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := o.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid response from %s (HTTP %d): %w", path, resp.StatusCode, err)
+	}
+	return nil
+}
+
+// StartDeviceLogin requests a device code for the user to sign in with
+func (o *OAuth) StartDeviceLogin(ctx context.Context) (*DeviceCode, error) {
+	if o.ClientID == "" {
+		return nil, fmt.Errorf("the client ID of the app registration is required")
+	}
+	var code struct {
+		DeviceCode
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	form := url.Values{"client_id": {o.ClientID}, "scope": {Scopes}}
+	if err := o.post(ctx, "devicecode", form, &code); err != nil {
+		return nil, err
+	}
+	if code.Error != "" {
+		return nil, fmt.Errorf("device login refused: %s: %s", code.Error, code.ErrorDescription)
+	}
+	if code.Interval <= 0 {
+		code.Interval = 5
+	}
+	return &code.DeviceCode, nil
+}
+
+// WaitDeviceLogin polls until the user finished signing in with code,
+// declined, or the code expired
+func (o *OAuth) WaitDeviceLogin(ctx context.Context, code *DeviceCode) (*Token, error) {
+	interval := time.Duration(code.Interval) * time.Second
+	deadline := time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+	form := url.Values{
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		"client_id":   {o.ClientID},
+		"device_code": {code.DeviceCode},
+	}
+	for {
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		var resp tokenResponse
+		if err := o.post(ctx, "token", form, &resp); err != nil {
+			return nil, err
+		}
+		switch resp.Error {
+		case "":
+			return resp.token(), nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, fmt.Errorf("login failed: %s: %s", resp.Error, resp.ErrorDescription)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("login failed: the code expired before it was entered")
+		}
+	}
+}
+
+// Refresh exchanges a refresh token for a new access token. Microsoft
+// usually rotates the refresh token as well.
+func (o *OAuth) Refresh(ctx context.Context, refreshToken string) (*Token, error) {
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {o.ClientID},
+		"refresh_token": {refreshToken},
+		"scope":         {Scopes},
+	}
+	var resp tokenResponse
+	if err := o.post(ctx, "token", form, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("token refresh failed: %s: %s", resp.Error, resp.ErrorDescription)
+	}
+	token := resp.token()
+	if token.RefreshToken == "" {
+		token.RefreshToken = refreshToken
+	}
+	return token, nil
+}
+
+func (r *tokenResponse) token() *Token {
+	return &Token{
+		AccessToken:  r.AccessToken,
+		RefreshToken: r.RefreshToken,
+		Expiry:       time.Now().Add(time.Duration(r.ExpiresIn) * time.Second),
+	}
+}
+
+// TokenSource hands out access tokens, refreshing them shortly before they
+// expire. It is safe for concurrent use.
+type TokenSource struct {
+	oauth *OAuth
+	// OnRefresh, if set, receives each rotated refresh token, to be stored
+	// in place of the previous one
+	OnRefresh func(refreshToken string)
+
+	mu           sync.Mutex
+	refreshToken string
+	token        *Token
+}
+
+// NewTokenSource returns a TokenSource starting from a refresh token
+func NewTokenSource(oauth *OAuth, refreshToken string) *TokenSource {
+	return &TokenSource{oauth: oauth, refreshToken: refreshToken}
+}
+
+// AccessToken returns a valid access token
+func (s *TokenSource) AccessToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != nil && time.Until(s.token.Expiry) > time.Minute {
+		return s.token.AccessToken, nil
+	}
+	if s.refreshToken == "" {
+		return "", fmt.Errorf("not signed in")
+	}
+	token, err := s.oauth.Refresh(ctx, s.refreshToken)
+	if err != nil {
+		return "", err
+	}
+	s.token = token
+	if token.RefreshToken != s.refreshToken {
+		s.refreshToken = token.RefreshToken
+		if s.OnRefresh != nil {
+			s.OnRefresh(token.RefreshToken)
+		}
+	}
+	return token.AccessToken, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"email-mcp-server/graph"
+	"email-mcp-server/storage"
+)
+
+func TestGraphDeltaRemovesDeletedEmails(t *testing.T) {
+	es := newTestServer(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tenant/oauth2/v2.0/token", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "access-1", "expires_in": 3600})
+	})
+	mux.HandleFunc("/v1.0/me/mailFolders/inbox", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"id": "inbox-id"})
+	})
+	mux.HandleFunc("/v1.0/me/mailFolders/inbox-id/messages/delta", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"value": []map[string]interface{}{
+				{"id": "AAA", "@removed": map[string]string{"reason": "deleted"}},
+				{"id": "BBB", "subject": "Kept"},
+			},
+			"@odata.deltaLink": "next",
+		})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	oauth := &graph.OAuth{Tenant: "tenant", ClientID: "client", Authority: server.URL}
+	client := graph.NewClient(graph.NewTokenSource(oauth, "refresh-1"))
+	client.BaseURL = server.URL + "/v1.0"
+	es.graphClients = map[string]*graph.Client{"work": client}
+	config := &es.configs[0]
+	config.Provider = "graph"
+
+	uid, err := es.db.GraphUID("work", "AAA")
+	if err != nil {
+		t.Fatalf("GraphUID: %v", err)
+	}
+	if err := es.db.CreateEmail(&storage.Email{AccountID: "work", Folder: "INBOX", UID: uid, Subject: "Gone"}); err != nil {
+		t.Fatalf("CreateEmail: %v", err)
+	}
+
+	emails, link, err := es.deltaSource("work").Changes(context.Background(), "INBOX", "")
+	if err != nil {
+		t.Fatalf("Changes: %v", err)
+	}
+	if len(emails) != 1 || emails[0].Subject != "Kept" || link != "next" {
+		t.Errorf("Changes returned %d emails and link %q, want the kept email and next", len(emails), link)
+	}
+	if _, err := es.db.GetEmail("work", "INBOX", uid); err == nil {
+		t.Error("email removed from the folder is still stored")
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Every login to an account's IMAP, SMTP or Microsoft Graph server is
+// recorded. A failed one is classified as auth, network, tls or quota and the
+// server is then left alone for a cool-down that doubles with each failure
+// in a row, from 30 seconds up to 30 minutes, so a revoked password does not
+// get the account locked by retries. Meanwhile calls fail at once with the
+// last error and how to fix it. account_health reports the state of every
+// account; test_account always tries to log in, and the first login that
+// works clears the failures.
+
+// Servers whose logins are recorded
+const (
+	serverIMAP  = "imap"
+	serverSMTP  = "smtp"
+	serverGraph = "graph"
+)
+
+// Kinds of login failures
+const (
+	problemAuth    = "auth"
+	problemTLS     = "tls"
+	problemQuota   = "quota"
+	problemNetwork = "network"
+	problemOther   = "other"
+)
+
+const (
+	minCoolDown = 30 * time.Second
+	maxCoolDown = 30 * time.Minute
+)
+
+// problemKinds classifies a login error by its text, first match wins. TLS
+// comes first since certificate errors mention neither auth nor network.
+var problemKinds = []struct {
+	problem string
+	texts   []string
+}{
+	{problemTLS, []string{"x509", "certificate", "tls:", "does not look like a tls"}},
+	{problemAuth, []string{"authenticationfailed", "authentication failed", "authentication unsuccessful", "invalid credentials",
+		"login failed", "auth failed", "unauthorized", "password not found", "username and password not accepted", "535",
+		"invalid_grant", "token refresh failed", "not signed in", "application-specific password", "web login required"}},
+	{problemQuota, []string{"quota", "too many simultaneous", "too many connections", "limit exceeded", "bandwidth",
+		"rate limit", "throttl", "too many login"}},
+	{problemNetwork, []string{"timeout", "timed out", "connection refused", "connection reset", "no such host",
+		"network is unreachable", "broken pipe", "eof", "server unavailable", "dial tcp"}},
+}
+
+// healthKey names a server of an account
+type healthKey struct {
+	account, server string
+}
+
+// LoginHealth is the login state of a server of an account
+type LoginHealth struct {
+	Account     string     `json:"account"`
+	Server      string     `json:"server"` // imap, smtp or graph
+	Status      string     `json:"status"` // ok, failing or cooling_down; set by account_health
+	Problem     string     `json:"problem,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	Failures    int        `json:"consecutive_failures,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+	RetryAfter  *time.Time `json:"retry_after,omitempty"`
+	Fix         string     `json:"fix,omitempty"`
+}
+
+// loginAllowed returns an error while a server of an account is cooling down
+// after failed logins
+func (es *EmailServer) loginAllowed(accountID, server string) error {
+	es.healthMu.Lock()
+	defer es.healthMu.Unlock()
+
+	h, ok := es.health[healthKey{accountID, server}]
+	if !ok || h.RetryAfter == nil || time.Now().After(*h.RetryAfter) {
+		return nil
+	}
+	return &failedLogin{problem: h.Problem, err: fmt.Errorf("%s login of account %s paused until %s (%s failure, %d in a row): %s. %s (see account_health)",
+		strings.ToUpper(server), accountID, h.RetryAfter.Local().Format("15:04:05"), h.Problem, h.Failures, h.LastError, h.Fix)}
+}
+
+// recordLogin updates the login state of a server of an account. Logins
+// given up because the call was cancelled or timed out are not counted.
+func (es *EmailServer) recordLogin(ctx context.Context, config *EmailConfig, server string, err error) {
+	if err != nil && ctx.Err() != nil {
+		return
+	}
+	now := time.Now()
+
+	es.healthMu.Lock()
+	defer es.healthMu.Unlock()
+	key := healthKey{config.ID, server}
+	h, ok := es.health[key]
+	if !ok {
+		h = &LoginHealth{Account: config.ID, Server: server}
+		es.health[key] = h
+	}
+
+	if err == nil {
+		if h.Failures > 0 {
+			log.Printf("%s login of account %s works again after %d failures", strings.ToUpper(server), config.ID, h.Failures)
+		}
+		*h = LoginHealth{Account: config.ID, Server: server, LastSuccess: &now}
+		return
+	}
+
+	h.Failures++
+	h.Problem = classifyLoginError(err)
+	h.LastError = err.Error()
+	h.LastFailure = &now
+	h.Fix = loginFix(config, server, h.Problem)
+	retry := now.Add(coolDown(h.Failures))
+	h.RetryAfter = &retry
+	log.Printf("%s login of account %s failed (%s, %d in a row), next attempt after %s: %v",
+		strings.ToUpper(server), config.ID, h.Problem, h.Failures, retry.Format("15:04:05"), err)
+}
+
+// forgetHealth drops the login state of an account
+func (es *EmailServer) forgetHealth(accountID string) {
+	es.healthMu.Lock()
+	defer es.healthMu.Unlock()
+	for key := range es.health {
+		if key.account == accountID {
+			delete(es.health, key)
+		}
+	}
+}
+
+// coolDown is how long to wait after a number of failed logins in a row
+func coolDown(failures int) time.Duration {
+	if failures > 10 {
+		return maxCoolDown
+	}
+	return min(minCoolDown<<(failures-1), maxCoolDown)
+}
+
+func classifyLoginError(err error) string {
+	text := strings.ToLower(err.Error())
+	for _, kind := range problemKinds {
+		for _, t := range kind.texts {
+			if strings.Contains(text, t) {
+				return kind.problem
+			}
+		}
+	}
+	return problemOther
+}
+
+// loginFix tells how to fix a kind of login failure, with the advice of the
+// account's provider where it has its own
+func loginFix(config *EmailConfig, server, problem string) string {
+	addr := fmt.Sprintf("%s:%d", config.IMAPHost, config.IMAPPort)
+	switch server {
+	case serverSMTP:
+		addr = fmt.Sprintf("%s:%d", config.SMTPHost, config.SMTPPort)
+	case serverGraph:
+		addr = "graph.microsoft.com and login.microsoftonline.com"
+	}
+	host := strings.ToLower(addr)
+
+	switch problem {
+	case problemAuth:
+		switch {
+		case server == serverGraph:
+			return fmt.Sprintf("The Microsoft sign-in expired or was revoked: run email-mcp-server graph-login %s", config.ID)
+		case strings.Contains(host, "gmail") || strings.Contains(host, "google"):
+			return "Gmail wants an app password (https://myaccount.google.com/apppasswords) once 2-step verification is on, and revoking it or changing the Google password invalidates it: create a new one, update the account's password and run test_account"
+		case strings.Contains(host, "outlook") || strings.Contains(host, "office365") || strings.Contains(host, "hotmail"):
+			return "Outlook.com and Microsoft 365 turned off password logins for most accounts: use an app password if the account offers them, or switch the account to Provider graph and run email-mcp-server graph-login"
+		case strings.Contains(host, "yahoo") || strings.Contains(host, "icloud") || strings.Contains(host, "me.com"):
+			return "This provider needs an app password generated in its account security settings: create one, update the account's password and run test_account"
+		}
+		return "The server rejected the username or password: check them, and the password source (" + config.passwordSource() + "), then run test_account"
+	case problemTLS:
+		return "The TLS connection failed: check the port (993 for IMAP and 465 for SMTP use TLS at once, other ports need UseStartTLS for IMAP), the server's certificate, or trust its CA with TLSCAFile"
+	case problemQuota:
+		return "The server limits connections or traffic: lower IMAPPerMinute, close other mail clients of the account, or wait for the provider's limit to reset"
+	case problemNetwork:
+		return fmt.Sprintf("The server could not be reached: check the host and port (%s), the network and any firewall or proxy", addr)
+	}
+	return "Run test_account for the full error"
+}
+
+func (es *EmailServer) handleAccountHealth(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	var accounts []EmailConfig
+	if accountID != "" {
+		config, err := es.getConfig(accountID)
+		if err != nil {
+			return nil, err
+		}
+		accounts = []EmailConfig{*config}
+	} else {
+		accounts = es.accounts()
+	}
+
+	now := time.Now()
+	var report []LoginHealth
+	es.healthMu.Lock()
+	for _, config := range accounts {
+		servers := []string{serverIMAP, serverSMTP}
+		if config.isGraph() {
+			servers = []string{serverGraph}
+		}
+		for _, server := range servers {
+			h := LoginHealth{Account: config.ID, Server: server}
+			if stored, ok := es.health[healthKey{config.ID, server}]; ok {
+				h = *stored
+			}
+			switch {
+			case h.Failures == 0:
+				h.Status = "ok"
+			case h.RetryAfter != nil && now.Before(*h.RetryAfter):
+				h.Status = "cooling_down"
+			default:
+				h.Status = "failing"
+			}
+			report = append(report, h)
+		}
+	}
+	es.healthMu.Unlock()
+	sort.SliceStable(report, func(i, j int) bool { return report[i].Failures > report[j].Failures })
+
+	reportJSON, _ := json.MarshalIndent(report, "", "  ")
+	return ToolResult{
+		Content: []TextContent{
+			{Type: "text", Text: formatHealth(report, now)},
+			{Type: "text", Text: string(reportJSON)},
+		},
+	}, nil
+}
+
+func formatHealth(report []LoginHealth, now time.Time) string {
+	failing := 0
+	for _, h := range report {
+		if h.Failures > 0 {
+			failing++
+		}
+	}
+	if failing == 0 {
+		return fmt.Sprintf("All %d servers are healthy: no failed logins since the last successful one", len(report))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d of %d servers are failing:\n", failing, len(report))
+	for _, h := range report {
+		server := strings.ToUpper(h.Server)
+		switch {
+		case h.Failures == 0 && h.LastSuccess != nil:
+			fmt.Fprintf(&b, "- %s %s: ok, last login %s\n", h.Account, server, h.LastSuccess.Local().Format("15:04"))
+		case h.Failures == 0:
+			fmt.Fprintf(&b, "- %s %s: no login yet\n", h.Account, server)
+		default:
+			fmt.Fprintf(&b, "- %s %s: %s (%s failure, %d in a row): %s", h.Account, server, strings.ReplaceAll(h.Status, "_", " "),
+				h.Problem, h.Failures, h.LastError)
+			if h.Status == "cooling_down" {
+				fmt.Fprintf(&b, "; next attempt in %s", h.RetryAfter.Sub(now).Round(time.Second))
+			}
+			fmt.Fprintf(&b, "\n  Fix: %s\n", h.Fix)
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClassifyLoginError(t *testing.T) {
+	tests := map[string]string{
+		"x509: certificate signed by unknown authority":           problemTLS,
+		"IMAP login failed with PLAIN: [AUTHENTICATIONFAILED]":    problemAuth,
+		"535 5.7.8 Username and Password not accepted":            problemAuth,
+		"oauth2: invalid_grant":                                   problemAuth,
+		"[LIMIT] Too many simultaneous connections":               problemQuota,
+		"dial tcp 10.0.0.1:993: connect: connection refused":      problemNetwork,
+		"read tcp 10.0.0.2:51234->10.0.0.1:993: i/o timeout":      problemNetwork,
+		"the server is having a bad day":                          problemOther,
+		"tls: first record does not look like a TLS handshake":    problemTLS,
+		"Web login required: https://support.google.com/mail/...": problemAuth,
+	}
+	for text, want := range tests {
+		if got := classifyLoginError(errors.New(text)); got != want {
+			t.Errorf("classifyLoginError(%q) = %s, want %s", text, got, want)
+		}
+	}
+}
+
+func TestCoolDown(t *testing.T) {
+	tests := map[int]time.Duration{
+		1:   minCoolDown,
+		2:   2 * minCoolDown,
+		3:   4 * minCoolDown,
+		7:   maxCoolDown,
+		11:  maxCoolDown,
+		100: maxCoolDown,
+	}
+	for failures, want := range tests {
+		if got := coolDown(failures); got != want {
+			t.Errorf("coolDown(%d) = %v, want %v", failures, got, want)
+		}
+	}
+}
+
+func TestLoginCoolDown(t *testing.T) {
+	es := newTestServer(t)
+	ctx := context.Background()
+	es.configs[0].Password = "revoked"
+
+	_, err := es.connectIMAP(ctx, "work")
+	if err == nil {
+		t.Fatal("connectIMAP with a wrong password succeeded")
+	}
+	if code, retryable := classifyError(err); code != errCodeAuth || retryable {
+		t.Errorf("failed login classified as %s, %v; want %s", code, retryable, errCodeAuth)
+	}
+	h := es.health[healthKey{"work", serverIMAP}]
+	if h == nil || h.Problem != problemAuth || h.Failures != 1 || h.RetryAfter == nil || h.Fix == "" {
+		t.Fatalf("health after a failed login = %+v", h)
+	}
+
+	// During the cool-down the server is not tried, even with the right
+	// password
+	es.configs[0].Password = "password"
+	_, err = es.connectIMAP(ctx, "work")
+	var login *failedLogin
+	if !errors.As(err, &login) || login.problem != problemAuth || h.Failures != 1 {
+		t.Fatalf("connectIMAP while cooling down = %v, failures %d", err, h.Failures)
+	}
+
+	past := time.Now().Add(-time.Second)
+	h.RetryAfter = &past
+	c, err := es.connectIMAP(ctx, "work")
+	if err != nil {
+		t.Fatalf("connectIMAP after the cool-down: %v", err)
+	}
+	c.Close()
+	if h := es.health[healthKey{"work", serverIMAP}]; h.Failures != 0 || h.LastSuccess == nil || h.RetryAfter != nil {
+		t.Errorf("health after a working login = %+v", h)
+	}
+}
+
+func TestRecordLoginSkipsCancelledCalls(t *testing.T) {
+	es := newTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	es.recordLogin(ctx, &es.configs[0], serverIMAP, context.Canceled)
+	if h := es.health[healthKey{"work", serverIMAP}]; h != nil {
+		t.Errorf("a cancelled login was recorded: %+v", h)
+	}
+}
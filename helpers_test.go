@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"email-mcp-server/storage"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/server"
+)
+
+// movingBackend is the memory backend with MOVE, which the go-imap server
+// advertises whether or not the backend has it
+type movingBackend struct{ backend.Backend }
+
+func (b movingBackend) Login(conn *imap.ConnInfo, username, password string) (backend.User, error) {
+	user, err := b.Backend.Login(conn, username, password)
+	if err != nil {
+		return nil, err
+	}
+	return movingUser{user}, nil
+}
+
+type movingUser struct{ backend.User }
+
+func (u movingUser) GetMailbox(name string) (backend.Mailbox, error) {
+	mbox, err := u.User.GetMailbox(name)
+	if err != nil {
+		return nil, err
+	}
+	return movingMailbox{mbox}, nil
+}
+
+type movingMailbox struct{ backend.Mailbox }
+
+func (m movingMailbox) MoveMessages(uid bool, seqset *imap.SeqSet, dest string) error {
+	if err := m.CopyMessages(uid, seqset, dest); err != nil {
+		return err
+	}
+	if err := m.UpdateMessagesFlags(uid, seqset, imap.AddFlags, []string{imap.DeletedFlag}); err != nil {
+		return err
+	}
+	return m.Expunge()
+}
+
+// newTestServer returns a server with a database and one account, work, on
+// an in-memory IMAP server whose INBOX holds one message, UID 6
+func newTestServer(t *testing.T) *EmailServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	s := server.New(movingBackend{memory.New()})
+	s.AllowInsecureAuth = true
+	go s.Serve(listener)
+	t.Cleanup(func() { s.Close() })
+
+	db, err := storage.New(filepath.Join(t.TempDir(), "emails.db"))
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	imapPort, _ := strconv.Atoi(port)
+	return &EmailServer{
+		configs: []EmailConfig{{
+			ID: "work", Username: "username", Password: "password",
+			IMAPHost: host, IMAPPort: imapPort,
+		}},
+		defaultAccount: "work",
+		db:             db,
+		tools:          NewToolRegistry(),
+		pending:        make(map[string]*pendingAction),
+		calls:          make(map[string]context.CancelFunc),
+		limits:         make(map[string]*accountLimits),
+		caps:           make(map[string]*accountCapabilities),
+		health:         make(map[healthKey]*LoginHealth),
+		subscriptions:  make(map[string]bool),
+	}
+}
+
+// appendMessage stores raw, with CRLF line endings, in folder of account
+// work and returns its UID
+func appendMessage(t *testing.T, es *EmailServer, folder, raw string) uint32 {
+	t.Helper()
+	c, err := es.connectIMAP(context.Background(), "work")
+	if err != nil {
+		t.Fatalf("connectIMAP: %v", err)
+	}
+	defer c.Close()
+
+	raw = strings.ReplaceAll(raw, "\n", "\r\n")
+	if err := c.Append(folder, nil, time.Now(), strings.NewReader(raw)); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	status, err := c.Select(folder, true)
+	if err != nil {
+		t.Fatalf("Select: %v", err)
+	}
+	return status.UidNext - 1
+}
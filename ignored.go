@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"email-mcp-server/config"
+	"email-mcp-server/mail"
+	"email-mcp-server/scheduler"
+	"email-mcp-server/storage"
+)
+
+// Mail from the ignored_senders of priority_rules.json, or whose subject has
+// one of its ignored_subjects, loses priority points, can be matched by
+// action rules with "ignored" and, with auto_archive_ignored, is marked read
+// and archived after every sync by the auto_archive_ignored action rule.
+// So that nothing is archived unseen, ignored_mail lists what that rule
+// archived and, while any account archives ignored mail, the rollup of the
+// past week is emailed like the digest on IGNORED_ROLLUP_SCHEDULE, by
+// default Monday mornings, unless nothing was archived.
+
+// defaultIgnoredRollupSchedule sends the rollup on Mondays at 9:00
+const defaultIgnoredRollupSchedule = "0 9 * * 1"
+
+// isIgnored reports whether mail from from with subject is on the ignore
+// lists of an account
+func (es *EmailServer) isIgnored(accountID string, from mail.Address, subject string) bool {
+	es.rulesMu.RLock()
+	defer es.rulesMu.RUnlock()
+	return es.rules != nil && es.rules.ForAccount(accountID).IsIgnored(from, subject)
+}
+
+func (es *EmailServer) handleIgnoredMail(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("action rules are not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	if accountID != "" {
+		config, err := es.getConfig(accountID)
+		if err != nil {
+			return nil, err
+		}
+		accountID = config.ID
+	}
+	days := 7
+	if d, ok := args["days"].(float64); ok && d >= 1 {
+		days = int(d)
+	}
+
+	actions, err := es.ignoredMail(accountID, days, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	actionsJSON, _ := json.MarshalIndent(actions, "", "  ")
+	return ToolResult{
+		Content: []TextContent{
+			{Type: "text", Text: formatIgnoredRollup(actions, days)},
+			{Type: "text", Text: string(actionsJSON)},
+		},
+	}, nil
+}
+
+// ignoredMail returns what auto_archive_ignored did in the days before now,
+// dry runs included
+func (es *EmailServer) ignoredMail(accountID string, days int, now time.Time) ([]storage.AppliedAction, error) {
+	return es.db.ListAppliedActions(accountID, config.AutoArchiveRule, now.AddDate(0, 0, -days), 1000)
+}
+
+// formatIgnoredRollup lists archived ignored mail by account and sender,
+// the senders with the most emails first
+func formatIgnoredRollup(actions []storage.AppliedAction, days int) string {
+	if len(actions) == 0 {
+		return fmt.Sprintf("No ignored mail was archived in the last %d days", days)
+	}
+
+	type sender struct {
+		account, from string
+		subjects      []string
+		dryRun        bool
+	}
+	bySender := make(map[string]*sender)
+	var senders []*sender
+	for _, a := range actions {
+		key := a.AccountID + "\x00" + strings.ToLower(a.From)
+		s := bySender[key]
+		if s == nil {
+			s = &sender{account: a.AccountID, from: a.From}
+			bySender[key] = s
+			senders = append(senders, s)
+		}
+		s.subjects = append(s.subjects, a.Subject)
+		s.dryRun = s.dryRun || a.DryRun
+	}
+	sort.SliceStable(senders, func(i, j int) bool {
+		if senders[i].account != senders[j].account {
+			return senders[i].account < senders[j].account
+		}
+		return len(senders[i].subjects) > len(senders[j].subjects)
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Ignored mail archived in the last %d days: %d emails from %d senders\n", days, len(actions), len(senders))
+	account := ""
+	for _, s := range senders {
+		if s.account != account {
+			account = s.account
+			fmt.Fprintf(&b, "\n%s:\n", account)
+		}
+		note := ""
+		if s.dryRun {
+			note = " (dry run)"
+		}
+		fmt.Fprintf(&b, "- %s: %d%s\n", s.from, len(s.subjects), note)
+		for i, subject := range s.subjects {
+			if i == 3 {
+				fmt.Fprintf(&b, "  - and %d more\n", len(s.subjects)-i)
+				break
+			}
+			fmt.Fprintf(&b, "  - %s\n", subject)
+		}
+	}
+	b.WriteString("\nRemove a sender from ignored_senders in priority_rules.json to keep its mail in the inbox.")
+	return b.String()
+}
+
+// archivesIgnored reports whether auto_archive_ignored is on globally or for
+// an account
+func (es *EmailServer) archivesIgnored() bool {
+	if es.rules == nil {
+		return false
+	}
+	if es.rules.AutoArchiveIgnored {
+		return true
+	}
+	for _, account := range es.rules.Accounts {
+		if account != nil && account.AutoArchiveIgnored != nil && *account.AutoArchiveIgnored {
+			return true
+		}
+	}
+	return false
+}
+
+// initIgnoredRollup starts emailing the ignored mail rollup of the past week
+// on IGNORED_ROLLUP_SCHEDULE, a cron expression; "off" turns it off
+func (es *EmailServer) initIgnoredRollup() {
+	spec := getEnv("IGNORED_ROLLUP_SCHEDULE", defaultIgnoredRollupSchedule)
+	if spec == "off" || es.db == nil || !es.archivesIgnored() {
+		return
+	}
+
+	schedule, err := scheduler.ParseSchedule(spec)
+	if err != nil {
+		log.Printf("Ignored mail rollup disabled: %v", err)
+		return
+	}
+
+	es.ignoredJob = scheduler.NewJob(schedule, func(at time.Time) {
+		actions, err := es.ignoredMail("", 7, at)
+		if err != nil {
+			log.Printf("Failed to build the ignored mail rollup: %v", err)
+			return
+		}
+		if len(actions) == 0 {
+			return
+		}
+		subject := "Ignored mail of the week - " + at.Format("Mon Jan 2")
+		if to, err := es.sendDigest(context.Background(), subject, formatIgnoredRollup(actions, 7)); err != nil {
+			log.Printf("Failed to send the ignored mail rollup: %v", err)
+		} else {
+			log.Printf("Ignored mail rollup sent to %s", to)
+		}
+	})
+	es.ignoredJob.Start()
+	log.Printf("Ignored mail rollup scheduled (%s)", spec)
+}
@@ -0,0 +1,51 @@
+package imapext
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// Attachment is a part of a message that is an attachment rather than a
+// message body, as described by its BODYSTRUCTURE
+type Attachment struct {
+	Part        string `json:"part"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Encoding    string `json:"encoding"`
+	Size        uint32 `json:"size"`
+}
+
+// Attachments returns the attachment parts of bs, in the order they appear
+func Attachments(bs *imap.BodyStructure) []Attachment {
+	var attachments []Attachment
+	bs.Walk(func(path []int, part *imap.BodyStructure) bool {
+		if len(part.Parts) > 0 {
+			return true
+		}
+
+		// Named non-text parts count as attachments even without an explicit
+		// disposition; unnamed text parts are message bodies
+		filename, _ := part.Filename()
+		isText := strings.EqualFold(part.MIMEType, "text")
+		if !strings.EqualFold(part.Disposition, "attachment") && (filename == "" || isText) {
+			return true
+		}
+
+		parts := make([]string, len(path))
+		for i, n := range path {
+			parts[i] = strconv.Itoa(n)
+		}
+
+		attachments = append(attachments, Attachment{
+			Part:        strings.Join(parts, "."),
+			Filename:    filename,
+			ContentType: strings.ToLower(part.MIMEType + "/" + part.MIMESubType),
+			Encoding:    part.Encoding,
+			Size:        part.Size,
+		})
+		return true
+	})
+	return attachments
+}
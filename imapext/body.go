@@ -0,0 +1,62 @@
+package imapext
+
+import (
+	"strings"
+
+	"github.com/emersion/go-imap"
+)
+
+// BodyPart is a text/plain or text/html body part of a message, as described
+// by its BODYSTRUCTURE
+type BodyPart struct {
+	Path     []int
+	HTML     bool
+	Encoding string
+	Charset  string
+	Size     uint32
+}
+
+// BodyParts returns the first text/plain and text/html parts of bs that are
+// not attachments; either is nil when the message has none
+func BodyParts(bs *imap.BodyStructure) (text, html *BodyPart) {
+	bs.Walk(func(path []int, part *imap.BodyStructure) bool {
+		if len(part.Parts) > 0 || !strings.EqualFold(part.MIMEType, "text") {
+			return true
+		}
+		if filename, _ := part.Filename(); filename != "" || strings.EqualFold(part.Disposition, "attachment") {
+			return true
+		}
+
+		body := &BodyPart{
+			Path:     append([]int(nil), path...),
+			Encoding: part.Encoding,
+			Charset:  part.Params["charset"],
+			Size:     part.Size,
+		}
+		switch {
+		case strings.EqualFold(part.MIMESubType, "plain") && text == nil:
+			text = body
+		case strings.EqualFold(part.MIMESubType, "html") && html == nil:
+			body.HTML = true
+			html = body
+		}
+		return true
+	})
+	return text, html
+}
+
+// Section returns the section fetching the first limit bytes of the part,
+// BODY.PEEK[1]<0.4096> for the first part and a limit of 4096, or the whole
+// part when limit is 0
+func (p *BodyPart) Section(limit int) *imap.BodySectionName {
+	section := &imap.BodySectionName{BodyPartName: imap.BodyPartName{Path: p.Path}, Peek: true}
+	if limit > 0 {
+		section.Partial = []int{0, limit}
+	}
+	return section
+}
+
+// Truncated reports whether fetching limit bytes of the part leaves some out
+func (p *BodyPart) Truncated(limit int) bool {
+	return limit > 0 && p.Size > uint32(limit)
+}
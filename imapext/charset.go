@@ -0,0 +1,15 @@
+package imapext
+
+import (
+	"email-mcp-server/mail"
+
+	"github.com/emersion/go-imap"
+)
+
+// go-imap decodes the encoded words of envelopes, subjects and display
+// names, itself, but without a CharsetReader only those in UTF-8 and
+// ISO-8859-1. With mail's, envelopes in any charset the parser knows, such as
+// windows-1252 or ISO-8859-15, are decoded too.
+func init() {
+	imap.CharsetReader = mail.CharsetReader
+}
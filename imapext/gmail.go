@@ -0,0 +1,105 @@
+package imapext
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/responses"
+	"github.com/emersion/go-imap/utf7"
+)
+
+// Gmail labels are read and changed through Gmail's IMAP extension
+// (X-GM-EXT-1): the X-GM-LABELS fetch item, store item and search key.
+// Labels are sent as modified UTF-7, like folder names; system labels start
+// with a backslash, such as \Important or \Starred.
+
+// LabelsItem is the fetch item returning the labels of a message
+const LabelsItem imap.FetchItem = "X-GM-LABELS"
+
+// SupportsLabels reports whether the server is Gmail's, with labels
+func SupportsLabels(c *client.Client) bool {
+	return supports(c, "X-GM-EXT-1")
+}
+
+// Labels returns the labels of a message fetched with LabelsItem, nil when
+// it was fetched without
+func Labels(msg *imap.Message) []string {
+	item, ok := msg.Items[LabelsItem]
+	if !ok {
+		return nil
+	}
+	fields, _ := item.([]interface{})
+	labels := make([]string, 0, len(fields))
+	for _, field := range fields {
+		label, err := imap.ParseString(field)
+		if err != nil {
+			continue
+		}
+		if decoded, err := utf7.Encoding.NewDecoder().String(label); err == nil {
+			label = decoded
+		}
+		labels = append(labels, label)
+	}
+	return labels
+}
+
+// UidStoreLabels adds labels to the messages of uidset, or removes them
+func UidStoreLabels(c *client.Client, uidset *imap.SeqSet, labels []string, add bool) error {
+	item := imap.StoreItem("-X-GM-LABELS.SILENT")
+	if add {
+		item = "+X-GM-LABELS.SILENT"
+	}
+	values, err := encodeLabels(labels)
+	if err != nil {
+		return err
+	}
+	// go-imap's UidStore sends strings unquoted, as it does for flags, which
+	// breaks labels with spaces
+	cmd := &commands.Uid{Cmd: &commands.Store{SeqSet: uidset, Item: item, Value: values}}
+	status, err := c.Execute(cmd, nil)
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
+
+// UidSearchLabel returns the UIDs matching criteria that carry label
+func UidSearchLabel(c *client.Client, label string, criteria *imap.SearchCriteria) ([]uint32, error) {
+	values, err := encodeLabels([]string{label})
+	if err != nil {
+		return nil, err
+	}
+	args := append(criteria.Format(), imap.RawString("X-GM-LABELS"), values[0])
+	cmd := &commands.Uid{Cmd: &imap.Command{Name: "SEARCH", Arguments: args}}
+
+	res := new(responses.Search)
+	status, err := c.Execute(cmd, res)
+	if err != nil {
+		return nil, err
+	}
+	if err := status.Err(); err != nil {
+		return nil, err
+	}
+	return res.Ids, nil
+}
+
+// encodeLabels encodes labels to modified UTF-7. System labels are sent as
+// atoms, like flags; quoted, they would name a user label.
+func encodeLabels(labels []string) ([]interface{}, error) {
+	values := make([]interface{}, len(labels))
+	for i, label := range labels {
+		if strings.HasPrefix(label, `\`) && !strings.ContainsAny(label, " ()\"{") {
+			values[i] = imap.RawString(label)
+			continue
+		}
+		encoded, err := utf7.Encoding.NewEncoder().String(label)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label %q: %w", label, err)
+		}
+		values[i] = encoded
+	}
+	return values, nil
+}
@@ -0,0 +1,190 @@
+// Package imapext issues the IMAP extension commands go-imap does not
+// implement, falling back to plain IMAP4rev1 when the server lacks the
+// extension, and finds the body parts worth fetching on their own
+package imapext
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/responses"
+)
+
+// Capabilities are the extensions of an IMAP server that change how
+// messages are moved, synced and searched
+type Capabilities struct {
+	Move       bool     `json:"move"`        // RFC 6851 MOVE
+	UIDPlus    bool     `json:"uidplus"`     // RFC 4315 UID EXPUNGE
+	Idle       bool     `json:"idle"`        // RFC 2177 IDLE
+	CondStore  bool     `json:"condstore"`   // RFC 7162 CONDSTORE
+	QResync    bool     `json:"qresync"`     // RFC 7162 QRESYNC
+	ESearch    bool     `json:"esearch"`     // RFC 4731 ESEARCH
+	SpecialUse bool     `json:"special_use"` // RFC 6154 SPECIAL-USE
+	Labels     bool     `json:"labels"`      // Gmail's X-GM-EXT-1
+	All        []string `json:"all"`         // Every capability advertised, sorted
+}
+
+// Probe returns the capabilities the server advertised after login. go-imap
+// keeps them from the login response, so this usually costs no round trip.
+func Probe(c *client.Client) (*Capabilities, error) {
+	caps, err := c.Capability()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read capabilities: %w", err)
+	}
+	result := &Capabilities{
+		Move:       caps["MOVE"],
+		UIDPlus:    caps["UIDPLUS"],
+		Idle:       caps["IDLE"],
+		CondStore:  caps["CONDSTORE"] || caps["QRESYNC"], // QRESYNC implies CONDSTORE
+		QResync:    caps["QRESYNC"],
+		ESearch:    caps["ESEARCH"],
+		SpecialUse: caps["SPECIAL-USE"],
+		Labels:     caps["X-GM-EXT-1"],
+	}
+	for name := range caps {
+		result.All = append(result.All, name)
+	}
+	sort.Strings(result.All)
+	return result, nil
+}
+
+func supports(c *client.Client, capability string) bool {
+	ok, err := c.Support(capability)
+	return ok && err == nil
+}
+
+// UidExpunge permanently removes the messages of uidset marked \Deleted.
+// Without UIDPLUS the whole folder is expunged, which also removes messages
+// other clients marked \Deleted.
+func UidExpunge(c *client.Client, uidset *imap.SeqSet) error {
+	if !supports(c, "UIDPLUS") {
+		return c.Expunge(nil)
+	}
+	cmd := &commands.Uid{Cmd: &imap.Command{Name: "EXPUNGE", Arguments: []interface{}{uidset}}}
+	status, err := c.Execute(cmd, nil)
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
+
+// UidDelete marks the messages of uidset \Deleted and expunges them
+func UidDelete(c *client.Client, uidset *imap.SeqSet) error {
+	item := imap.FormatFlagsOp(imap.AddFlags, true)
+	if err := c.UidStore(uidset, item, []interface{}{imap.DeletedFlag}, nil); err != nil {
+		return fmt.Errorf("failed to mark emails as deleted: %w", err)
+	}
+	if err := UidExpunge(c, uidset); err != nil {
+		return fmt.Errorf("failed to expunge deleted emails: %w", err)
+	}
+	return nil
+}
+
+// UidMove moves the messages of uidset to dest with MOVE, or else with COPY
+// and UidDelete. go-imap's own fallback expunges the whole folder.
+func UidMove(c *client.Client, uidset *imap.SeqSet, dest string) error {
+	if supports(c, "MOVE") {
+		return c.UidMove(uidset, dest)
+	}
+	if err := c.UidCopy(uidset, dest); err != nil {
+		return err
+	}
+	return UidDelete(c, uidset)
+}
+
+// UidSearch returns the UIDs matching criteria. With ESEARCH the server
+// answers with ranges instead of every UID, which matters for searches
+// matching most of a large folder.
+func UidSearch(c *client.Client, criteria *imap.SearchCriteria) ([]uint32, error) {
+	if !supports(c, "ESEARCH") {
+		return c.UidSearch(criteria)
+	}
+
+	args := []interface{}{imap.RawString("RETURN"), []interface{}{imap.RawString("ALL")}}
+	args = append(args, criteria.Format()...)
+	cmd := &commands.Uid{Cmd: &imap.Command{Name: "SEARCH", Arguments: args}}
+
+	var uids []uint32
+	var parseErr error
+	handler := responses.HandlerFunc(func(resp imap.Resp) error {
+		name, fields, ok := imap.ParseNamedResp(resp)
+		if !ok || name != "ESEARCH" {
+			return responses.ErrUnhandled
+		}
+		// (TAG "A1") UID ALL 1:3,7
+		for i := 0; i+1 < len(fields); i++ {
+			if key, ok := fields[i].(string); ok && strings.EqualFold(key, "ALL") {
+				set, err := imap.ParseSeqSet(fmt.Sprint(fields[i+1]))
+				if err != nil {
+					parseErr = fmt.Errorf("invalid ESEARCH response: %w", err)
+					return nil
+				}
+				uids = expand(set)
+			}
+		}
+		return nil
+	})
+	status, err := c.Execute(cmd, handler)
+	if err != nil {
+		return nil, err
+	}
+	if err := status.Err(); err != nil {
+		return nil, err
+	}
+	return uids, parseErr
+}
+
+// expand lists the numbers of a sequence set without "*"
+func expand(set *imap.SeqSet) []uint32 {
+	var nums []uint32
+	for _, seq := range set.Set {
+		if seq.Start == 0 || seq.Stop < seq.Start {
+			continue
+		}
+		for n := seq.Start; n <= seq.Stop; n++ {
+			nums = append(nums, n)
+		}
+	}
+	return nums
+}
+
+// HighestModSeq returns the HIGHESTMODSEQ of a folder from STATUS, 0 when
+// the server does not support CONDSTORE. Call it before selecting the
+// folder: STATUS of the selected folder is not reliable.
+func HighestModSeq(c *client.Client, folder string) (uint64, error) {
+	if !supports(c, "CONDSTORE") && !supports(c, "QRESYNC") {
+		return 0, nil
+	}
+	status, err := c.Status(folder, []imap.StatusItem{"HIGHESTMODSEQ"})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read status of %s: %w", folder, err)
+	}
+	value, ok := status.Items["HIGHESTMODSEQ"]
+	if !ok {
+		return 0, nil
+	}
+	return strconv.ParseUint(fmt.Sprint(value), 10, 64)
+}
+
+// FetchChangedFlags sends the UIDs and flags of the messages of uidset
+// whose flags changed since modseq, a HIGHESTMODSEQ of the selected folder,
+// to ch, which is closed when done. It requires CONDSTORE.
+func FetchChangedFlags(c *client.Client, uidset *imap.SeqSet, modseq uint64, ch chan *imap.Message) error {
+	defer close(ch)
+
+	fetch := &commands.Fetch{SeqSet: uidset, Items: []imap.FetchItem{imap.FetchUid, imap.FetchFlags}}
+	inner := fetch.Command()
+	inner.Arguments = append(inner.Arguments, []interface{}{imap.RawString("CHANGEDSINCE"), imap.RawString(strconv.FormatUint(modseq, 10))})
+	cmd := &commands.Uid{Cmd: inner}
+
+	status, err := c.Execute(cmd, &responses.Fetch{Messages: ch, SeqSet: uidset, Uid: true})
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
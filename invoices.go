@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"email-mcp-server/ai"
+	"email-mcp-server/storage"
+)
+
+// Synced emails classified as invoices, orders or travel, by their stored
+// classification or else by the rules, have their invoice, order or flights
+// extracted: invoices into the invoices table with their vendor, number,
+// amount, currency and due date, found with patterns or, with "invoices":
+// {"use_ai": true} in ai_config.json, the LLM. list_invoices lists them and
+// upcoming_payments lists those falling due; orders.go and trips.go handle
+// the others.
+
+// recordTransaction extracts the invoice, order or flights of a synced email
+// by its category
+func (es *EmailServer) recordTransaction(email *storage.Email) error {
+	category := ""
+	if stored, err := es.db.GetClassification(email.AccountID, email.Folder, email.UID); err == nil && stored != nil {
+		category = stored.Category
+	} else {
+		category = es.classifier.ClassifyByRules(ai.Email{
+			AccountID:   email.AccountID,
+			Folder:      email.Folder,
+			UID:         email.UID,
+			MessageID:   email.MessageID,
+			ThreadID:    email.ThreadID,
+			From:        email.From,
+			To:          email.To,
+			Subject:     email.Subject,
+			Body:        email.BodySnippet,
+			Date:        email.Date,
+			Automated:   email.Automated,
+			Attachments: email.Attachments,
+		}).Category
+	}
+
+	switch category {
+	case "invoice":
+		return es.recordInvoice(email)
+	case "order":
+		return es.recordOrder(email)
+	case "travel":
+		return es.recordTrips(email)
+	}
+	return nil
+}
+
+// recordInvoice extracts and stores the invoice of a synced email
+func (es *EmailServer) recordInvoice(email *storage.Email) error {
+	// The amount is often further down than the snippet goes
+	ctx := context.Background()
+	invoice := es.invoices.Extract(ctx, es.fullEmail(ctx, *email))
+	err := es.db.SaveInvoice(&storage.Invoice{
+		AccountID:   email.AccountID,
+		Folder:      email.Folder,
+		UID:         email.UID,
+		Vendor:      invoice.Vendor,
+		Number:      invoice.Number,
+		Amount:      invoice.Amount,
+		Currency:    invoice.Currency,
+		Due:         invoice.Due,
+		Method:      invoice.Method,
+		ExtractedAt: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store invoice %s/%d: %w", email.Folder, email.UID, err)
+	}
+	return nil
+}
+
+func (es *EmailServer) handleListInvoices(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("invoices are not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	vendor, _ := args["vendor"].(string)
+	days := 90
+	if d, ok := args["days"].(float64); ok && d >= 1 {
+		days = int(d)
+	}
+	limit := 50
+	if l, ok := args["limit"].(float64); ok && l >= 1 {
+		limit = int(l)
+	}
+
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	invoices, err := es.db.ListInvoices(config.ID, folder, vendor, time.Now().AddDate(0, 0, -days), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	invoicesJSON, _ := json.MarshalIndent(invoices, "", "  ")
+	return ToolResult{
+		Content: []TextContent{
+			{Type: "text", Text: formatInvoices(config.ID, invoices, days)},
+			{Type: "text", Text: string(invoicesJSON)},
+		},
+	}, nil
+}
+
+func (es *EmailServer) handleUpcomingPayments(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("payments are not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	days := 30
+	if d, ok := args["days"].(float64); ok && d >= 1 {
+		days = int(d)
+	}
+	limit := 50
+	if l, ok := args["limit"].(float64); ok && l >= 1 {
+		limit = int(l)
+	}
+	includeOverdue, _ := args["include_overdue"].(bool)
+
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	from := now
+	if includeOverdue {
+		from = now.AddDate(0, 0, -days)
+	}
+	invoices, err := es.db.UpcomingPayments(config.ID, from, now.AddDate(0, 0, days), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	invoicesJSON, _ := json.MarshalIndent(invoices, "", "  ")
+	return ToolResult{
+		Content: []TextContent{
+			{Type: "text", Text: formatPayments(config.ID, invoices, days, now)},
+			{Type: "text", Text: string(invoicesJSON)},
+		},
+	}, nil
+}
+
+func formatInvoices(accountID string, invoices []storage.Invoice, days int) string {
+	if len(invoices) == 0 {
+		return fmt.Sprintf("No invoices in the last %d days for %s", days, accountID)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d invoices in the last %d days for %s, %s:\n", len(invoices), days, accountID, formatTotals(invoices))
+	for _, inv := range invoices {
+		fmt.Fprintf(&b, "- %s · %s · %s", inv.Email.Date.Local().Format("2006-01-02"), invoiceVendor(inv), formatAmount(inv))
+		if inv.Due != nil {
+			fmt.Fprintf(&b, " · due %s", inv.Due.Local().Format("2006-01-02"))
+		}
+		fmt.Fprintf(&b, " · %s [%s/%d]\n", inv.Email.Subject, inv.Folder, inv.UID)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func formatPayments(accountID string, invoices []storage.Invoice, days int, now time.Time) string {
+	if len(invoices) == 0 {
+		return fmt.Sprintf("No payments due in the next %d days for %s", days, accountID)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d payments due in the next %d days for %s, %s:\n", len(invoices), days, accountID, formatTotals(invoices))
+	for _, inv := range invoices {
+		when := formatDigestDay(inv.Due.Local(), now)
+		if inv.Due.Before(now) {
+			when += " (overdue)"
+		}
+		fmt.Fprintf(&b, "- %s · %s · %s · %s [%s/%d]\n", when, invoiceVendor(inv), formatAmount(inv), inv.Email.Subject, inv.Folder, inv.UID)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// formatTotals adds up the amounts of invoices per currency, e.g. "120.00
+// EUR, 35.50 USD"
+func formatTotals(invoices []storage.Invoice) string {
+	totals := make(map[string]float64)
+	for _, inv := range invoices {
+		if inv.Amount > 0 {
+			totals[inv.Currency] += inv.Amount
+		}
+	}
+	if len(totals) == 0 {
+		return "no amounts found"
+	}
+
+	currencies := make([]string, 0, len(totals))
+	for currency := range totals {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	parts := make([]string, len(currencies))
+	for i, currency := range currencies {
+		parts[i] = strings.TrimSpace(fmt.Sprintf("%.2f %s", totals[currency], currency))
+	}
+	return "totalling " + strings.Join(parts, ", ")
+}
+
+func formatAmount(inv storage.Invoice) string {
+	if inv.Amount == 0 {
+		return "amount unknown"
+	}
+	return strings.TrimSpace(fmt.Sprintf("%.2f %s", inv.Amount, inv.Currency))
+}
+
+func invoiceVendor(inv storage.Invoice) string {
+	vendor := inv.Vendor
+	if vendor == "" {
+		vendor = inv.Email.From
+	}
+	if inv.Number != "" {
+		vendor += " #" + inv.Number
+	}
+	return vendor
+}
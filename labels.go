@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"email-mcp-server/imapext"
+
+	"github.com/emersion/go-imap"
+)
+
+// Gmail accounts list the labels of each email in get_emails, which can
+// filter by label, and add_label and remove_label change them. The labels of
+// classified emails are mirrored into their classification as "label:" tags,
+// so the tag-based tools see them too.
+
+// errNoLabels is returned for accounts whose server is not Gmail's
+func errNoLabels(accountID string) error {
+	return fmt.Errorf("account %s does not support labels: they need Gmail's IMAP extension (X-GM-EXT-1)", accountID)
+}
+
+// saveLabels mirrors the labels of listed emails into their classification
+// tags
+func (es *EmailServer) saveLabels(accountID, folder string, emails []EmailMessage) {
+	if es.db == nil {
+		return
+	}
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return
+	}
+	if folder == "" {
+		folder = "INBOX"
+	}
+	labels := make(map[uint32][]string, len(emails))
+	for _, email := range emails {
+		if email.Labels != nil {
+			labels[email.ID] = email.Labels
+		}
+	}
+	if err := es.db.SaveLabels(config.ID, folder, labels); err != nil {
+		log.Printf("Error saving labels of %s/%s: %v", config.ID, folder, err)
+	}
+}
+
+// changeLabels adds labels to an email, or removes them, and returns the
+// labels the email has afterwards
+func (es *EmailServer) changeLabels(ctx context.Context, accountID, folder string, uid uint32, labels []string, add bool) ([]string, error) {
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+	if config.isGraph() {
+		return nil, errNoLabels(config.ID)
+	}
+
+	c, err := es.connectIMAP(ctx, config.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	if !imapext.SupportsLabels(c) {
+		return nil, errNoLabels(config.ID)
+	}
+	if _, err := selectFolder(c, folder, false); err != nil {
+		return nil, err
+	}
+
+	uidset := new(imap.SeqSet)
+	uidset.AddNum(uid)
+	if err := imapext.UidStoreLabels(c, uidset, labels, add); err != nil {
+		return nil, fmt.Errorf("failed to store labels: %w", err)
+	}
+
+	var email *EmailMessage
+	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchFlags, imap.FetchRFC822Size, imap.FetchUid, imapext.LabelsItem}
+	err = uidFetch(c, uidset, items, func(msg *imap.Message) {
+		e := newEmailMessage(msg)
+		email = &e
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read labels: %w", err)
+	}
+	if email == nil {
+		return nil, fmt.Errorf("email with ID %d %w", uid, errNotFound)
+	}
+	es.saveLabels(config.ID, folder, []EmailMessage{*email})
+	return email.Labels, nil
+}
+
+func (es *EmailServer) handleAddLabel(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	return es.handleChangeLabels(ctx, args, true)
+}
+
+func (es *EmailServer) handleRemoveLabel(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	return es.handleChangeLabels(ctx, args, false)
+}
+
+func (es *EmailServer) handleChangeLabels(ctx context.Context, args map[string]interface{}, add bool) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	id, ok := args["id"].(float64)
+	if !ok {
+		return nil, errInvalidEmailID
+	}
+	label, _ := args["label"].(string)
+	if label == "" {
+		return nil, fmt.Errorf("%w: label", errMissingParameter)
+	}
+
+	verb, action := "added to", "add"
+	if !add {
+		verb, action = "removed from", "remove"
+	}
+	current, err := es.changeLabels(ctx, accountID, folder, uint32(id), []string{label}, add)
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s label: %w", action, err)
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Label %s %s email ID %d; its labels are now: %s", label, verb, uint32(id), formatLabels(current)),
+		}},
+	}, nil
+}
+
+// formatLabels lists labels for a message
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return "none"
+	}
+	return strings.Join(labels, ", ")
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestGetEmailsNewestFirst(t *testing.T) {
+	es := newTestServer(t)
+	ctx := context.Background()
+	c, err := es.connectIMAP(ctx, "work")
+	if err != nil {
+		t.Fatalf("connectIMAP: %v", err)
+	}
+	for _, day := range []int{3, 1, 2} {
+		date := time.Date(2030, 1, day, 9, 0, 0, 0, time.UTC)
+		msg := fmt.Sprintf("From: ana@example.com\r\nSubject: Day %d\r\nDate: %s\r\n\r\nHello\r\n", day, date.Format(time.RFC1123Z))
+		if err := c.Append("INBOX", nil, date, bytes.NewBufferString(msg)); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	c.Logout()
+
+	// The page holds the last three messages of the folder, sorted by date
+	emails, err := es.getEmails(ctx, "work", "INBOX", 3, false)
+	if err != nil {
+		t.Fatalf("getEmails: %v", err)
+	}
+	var subjects []string
+	for _, email := range emails {
+		subjects = append(subjects, email.Subject)
+	}
+	if len(emails) != 3 || subjects[0] != "Day 3" || subjects[1] != "Day 2" || subjects[2] != "Day 1" {
+		t.Errorf("limit 3 listed %v, want Day 3, Day 2, Day 1", subjects)
+	}
+
+	if emails, _ = es.getEmails(ctx, "work", "INBOX", 10, false); len(emails) != 4 {
+		t.Errorf("limit 10 listed %d emails, want all 4", len(emails))
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"slices"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+
+	"email-mcp-server/auth"
+)
+
+// Accounts pick an authentication mechanism with IMAPAuth and SMTPAuth. The
+// default, auto, tries every supported mechanism the server advertises in
+// the order below until one is accepted, so a server rejecting PLAIN falls
+// back to the next one. Naming a mechanism tries only that one, which avoids
+// repeated failed logins on servers that lock accounts out.
+
+// imapMechanisms is the order auto tries on IMAP. LOGIN stands for the LOGIN
+// command, used first as it always was, not the SASL mechanism.
+var imapMechanisms = []string{auth.Login, auth.Plain, auth.CRAMMD5, auth.NTLM}
+
+// smtpMechanisms is the order auto tries on SMTP
+var smtpMechanisms = []string{auth.Plain, auth.Login, auth.CRAMMD5, auth.NTLM}
+
+// loginIMAP authenticates c with the account's IMAPAuth mechanism
+func loginIMAP(c *client.Client, config *EmailConfig) error {
+	mechs, err := negotiate(config.IMAPAuth, imapMechanisms, func(mech string) bool {
+		if mech == auth.Login {
+			disabled, _ := c.Support("LOGINDISABLED")
+			return !disabled
+		}
+		ok, _ := c.SupportAuth(mech)
+		return ok
+	})
+	if err != nil {
+		return fmt.Errorf("IMAP login failed: %w", err)
+	}
+
+	var failures []string
+	for _, mech := range mechs {
+		err := authenticateIMAP(c, mech, config)
+		if err == nil {
+			return nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", mech, err))
+		if c.State() == imap.LogoutState {
+			break
+		}
+	}
+	return loginError("IMAP", failures)
+}
+
+func authenticateIMAP(c *client.Client, mech string, config *EmailConfig) error {
+	if mech == auth.Login {
+		return c.Login(config.Username, config.Password)
+	}
+	sasl, err := auth.New(mech, config.Username, config.Password)
+	if err != nil {
+		return err
+	}
+	return c.Authenticate(sasl)
+}
+
+// loginSMTP authenticates c with the account's SMTPAuth mechanism. net/smtp
+// ends the session when AUTH fails, so each fallback reconnects; the
+// returned client replaces c.
+func loginSMTP(ctx context.Context, c *smtp.Client, config *EmailConfig) (*smtp.Client, error) {
+	_, offered := c.Extension("AUTH")
+	advertised := strings.Fields(strings.ToUpper(offered))
+	mechs, err := negotiate(config.SMTPAuth, smtpMechanisms, func(mech string) bool {
+		return slices.Contains(advertised, mech)
+	})
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("SMTP login failed: %w", err)
+	}
+
+	var failures []string
+	for i, mech := range mechs {
+		if i > 0 {
+			if c, err = openSMTP(ctx, config); err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", mech, err))
+				break
+			}
+		}
+		sasl, err := auth.New(mech, config.Username, config.Password)
+		if err == nil {
+			err = c.Auth(auth.SMTP(sasl))
+		}
+		if err == nil {
+			return c, nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", mech, err))
+		c.Close()
+	}
+	return nil, loginError("SMTP", failures)
+}
+
+// negotiate returns the mechanisms to try: the configured one alone, or for
+// auto those of preference the server offers
+func negotiate(configured string, preference []string, offered func(mech string) bool) ([]string, error) {
+	mech, err := auth.Normalize(configured)
+	if err != nil {
+		return nil, err
+	}
+	if mech != auth.Auto {
+		return []string{mech}, nil
+	}
+
+	var mechs []string
+	for _, mech := range preference {
+		if offered(mech) {
+			mechs = append(mechs, mech)
+		}
+	}
+	if len(mechs) == 0 {
+		return nil, fmt.Errorf("the server offers none of the supported mechanisms (%s)", strings.Join(preference, ", "))
+	}
+	return mechs, nil
+}
+
+func loginError(protocol string, failures []string) error {
+	if len(failures) == 1 {
+		return fmt.Errorf("%s login failed with %s", protocol, failures[0])
+	}
+	return fmt.Errorf("%s login failed with every mechanism tried (%s)", protocol, strings.Join(failures, "; "))
+}
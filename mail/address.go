@@ -0,0 +1,78 @@
+package mail
+
+import (
+	"fmt"
+	netmail "net/mail"
+	"strings"
+)
+
+// Address is a mailbox split into its display name and bare addr-spec, so
+// matching can compare addresses instead of "Name <address>" strings
+type Address struct {
+	Name    string `json:"name,omitempty"`
+	Address string `json:"address"`
+}
+
+// ParseAddress parses an RFC 5322 mailbox such as "a@b", "Name <a@b>" or
+// "\"Doe, John\" <a@b>", decoding RFC 2047 names. Unquoted names net/mail
+// rejects, like the "Doe, John <a@b>" stored by earlier versions, are
+// recovered from the angle brackets.
+func ParseAddress(s string) (Address, error) {
+	s = strings.TrimSpace(s)
+	if addr, err := netmail.ParseAddress(s); err == nil {
+		return Address{Name: addr.Name, Address: addr.Address}, nil
+	}
+
+	open, end := strings.LastIndex(s, "<"), strings.LastIndex(s, ">")
+	if open < 0 || end < open || !strings.Contains(s[open:end], "@") {
+		return Address{}, fmt.Errorf("invalid email address %q", s)
+	}
+	return Address{Name: strings.Trim(strings.TrimSpace(s[:open]), `"`), Address: strings.TrimSpace(s[open+1 : end])}, nil
+}
+
+// IsZero reports whether a holds no address
+func (a Address) IsZero() bool {
+	return a.Address == ""
+}
+
+// Equal reports whether a and b are the same mailbox. Addresses compare
+// ignoring case and names are not compared.
+func (a Address) Equal(b Address) bool {
+	return a.Address != "" && strings.EqualFold(a.Address, b.Address)
+}
+
+// Domain returns the lowercased part of the address after the last @
+func (a Address) Domain() string {
+	if i := strings.LastIndex(a.Address, "@"); i >= 0 {
+		return strings.ToLower(a.Address[i+1:])
+	}
+	return ""
+}
+
+// String formats a as "Name <address>", quoting the name when it holds
+// characters RFC 5322 only allows in quoted strings (e.g. the comma of
+// "Doe, John"), so the result parses back with ParseAddress. Unlike
+// net/mail, non-ASCII names are kept readable instead of being encoded.
+func (a Address) String() string {
+	if a.Name == "" {
+		return a.Address
+	}
+	name := a.Name
+	if needsQuoting(name) {
+		name = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+	}
+	return name + " <" + a.Address + ">"
+}
+
+// needsQuoting reports whether name is not a sequence of atoms
+func needsQuoting(name string) bool {
+	for _, r := range name {
+		if r >= 0x80 || r == ' ' {
+			continue
+		}
+		if r < 0x21 || r == 0x7f || strings.ContainsRune(`()<>[]:;@\,."`, r) {
+			return true
+		}
+	}
+	return strings.HasPrefix(name, " ") || strings.HasSuffix(name, " ") || strings.Contains(name, "  ")
+}
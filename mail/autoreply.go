@@ -0,0 +1,57 @@
+package mail
+
+import (
+	netmail "net/mail"
+	"strings"
+)
+
+// robotLocalParts are the local parts of addresses that never read replies
+var robotLocalParts = []string{"mailer-daemon", "postmaster", "noreply", "no-reply", "donotreply", "do-not-reply", "bounce", "bounces"}
+
+// Automated reports whether the headers mark the message as sent by a
+// program, which automatic replies must not answer (RFC 3834): anything
+// Auto-Submitted, bulk or list mail, and senders asking for no auto replies
+func (p *ParsedEmail) Automated() bool {
+	if submitted := strings.ToLower(p.Header("Auto-Submitted")); submitted != "" && submitted != "no" {
+		return true
+	}
+	switch strings.ToLower(strings.TrimSpace(p.Header("Precedence"))) {
+	case "bulk", "list", "junk":
+		return true
+	}
+	if p.Header("List-Id") != "" || p.Header("List-Unsubscribe") != "" || p.Header("X-Autoreply") != "" {
+		return true
+	}
+	suppress := strings.ToLower(p.Header("X-Auto-Response-Suppress"))
+	return strings.Contains(suppress, "all") || strings.Contains(suppress, "oof") || strings.Contains(suppress, "autoreply")
+}
+
+// IsRobot reports whether the address belongs to a mailer daemon or a
+// no-reply sender
+func (a Address) IsRobot() bool {
+	local, _, _ := strings.Cut(strings.ToLower(a.Address), "@")
+	for _, robot := range robotLocalParts {
+		if local == robot || strings.HasPrefix(local, robot+"+") {
+			return true
+		}
+	}
+	return false
+}
+
+// AutoReplyAddress returns where an automatic reply to the message goes
+// (RFC 3834 section 4): its Return-Path, else its first Reply-To address,
+// else from. A null Return-Path, as bounces carry, gives the zero Address.
+func (p *ParsedEmail) AutoReplyAddress(from Address) Address {
+	if returnPath := strings.TrimSpace(p.Header("Return-Path")); returnPath != "" {
+		if returnPath == "<>" {
+			return Address{}
+		}
+		if addr, err := ParseAddress(returnPath); err == nil && !addr.IsZero() {
+			return addr
+		}
+	}
+	if list, err := netmail.ParseAddressList(p.Header("Reply-To")); err == nil && len(list) > 0 {
+		return Address{Name: list[0].Name, Address: list[0].Address}
+	}
+	return from
+}
@@ -0,0 +1,157 @@
+package mail
+
+import (
+	"bufio"
+	"bytes"
+	"mime"
+	"net/textproto"
+	"strings"
+)
+
+// Kinds of messages sent by a program rather than a person, see AutoKind
+const (
+	KindBounce        = "bounce"         // Delivery failure or delay report
+	KindAutoReply     = "autoreply"      // Out-of-office and vacation replies
+	KindAutoGenerated = "auto_generated" // Anything else marked Auto-Submitted
+)
+
+// AutoKindHeaders are the headers AutoKind reads, for fetching only those
+var AutoKindHeaders = []string{"From", "Subject", "Content-Type", "Auto-Submitted", "X-Autoreply", "X-Autorespond", "X-Failed-Recipients"}
+
+// bounceSubjects start the subjects of bounces sent without a
+// multipart/report body
+var bounceSubjects = []string{"undeliverable", "undelivered mail", "delivery status notification", "delivery failure",
+	"mail delivery failed", "returned mail", "failure notice", "mail system error"}
+
+// autoReplySubjects start the subjects of out-of-office replies sent without
+// an Auto-Submitted header
+var autoReplySubjects = []string{"out of office", "automatic reply", "auto reply", "autoreply", "auto-reply",
+	"respuesta automática", "fuera de la oficina"}
+
+// AutoKind tells which kind of message a program sent: KindBounce for
+// delivery status notifications (a multipart/report of delivery-status, or a
+// mailer daemon's failure notice), KindAutoReply for out-of-office replies
+// and KindAutoGenerated for other Auto-Submitted messages. It is "" for
+// messages a person sent. Only the headers are read.
+func (p *ParsedEmail) AutoKind() string {
+	mediaType, params, _ := mime.ParseMediaType(p.Header("Content-Type"))
+	if mediaType == "multipart/report" && strings.Contains(strings.ToLower(params["report-type"]), "delivery-status") {
+		return KindBounce
+	}
+	if p.Header("X-Failed-Recipients") != "" {
+		return KindBounce
+	}
+
+	subject := strings.ToLower(strings.TrimSpace(p.Header("Subject")))
+	if from, err := ParseAddress(p.Header("From")); err == nil && from.IsRobot() && hasAnyPrefix(subject, bounceSubjects) {
+		return KindBounce
+	}
+
+	submitted := strings.ToLower(strings.TrimSpace(p.Header("Auto-Submitted")))
+	switch {
+	case strings.HasPrefix(submitted, "auto-replied"),
+		p.Header("X-Autoreply") != "", p.Header("X-Autorespond") != "",
+		hasAnyPrefix(subject, autoReplySubjects):
+		return KindAutoReply
+	case submitted != "" && submitted != "no":
+		return KindAutoGenerated
+	}
+	return ""
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Bounce is a recipient a delivery status notification reports on
+type Bounce struct {
+	Recipient  string `json:"recipient"`
+	Action     string `json:"action"`               // failed, delayed, ...
+	Status     string `json:"status,omitempty"`     // Enhanced status code such as 5.1.1
+	Diagnostic string `json:"diagnostic,omitempty"` // The remote server's reply
+	// Message-ID of the message that bounced, when the report includes it
+	OriginalMessageID string `json:"original_message_id,omitempty"`
+}
+
+// Permanent reports whether the address will keep failing: a failed action
+// or a 5.x.x status
+func (b Bounce) Permanent() bool {
+	return strings.EqualFold(b.Action, "failed") || strings.HasPrefix(b.Status, "5")
+}
+
+// Bounces returns the recipients a bounce reports on, read from its
+// message/delivery-status part (RFC 3464). Bounces without one fall back to
+// the X-Failed-Recipients header. Recipients delivered or relayed are left
+// out.
+func (p *ParsedEmail) Bounces() []Bounce {
+	var bounces []Bounce
+	var original string
+	for _, attachment := range p.Attachments {
+		switch attachment.ContentType {
+		case "message/delivery-status", "message/global-delivery-status":
+			bounces = append(bounces, parseDeliveryStatus(attachment.Data)...)
+		case "message/rfc822", "text/rfc822-headers", "message/global", "message/global-headers":
+			// Only the header of the original message is read
+			header, _ := textproto.NewReader(bufio.NewReader(bytes.NewReader(attachment.Data))).ReadMIMEHeader()
+			original = strings.Trim(header.Get("Message-Id"), "<> ")
+		}
+	}
+
+	if len(bounces) == 0 {
+		for _, recipient := range strings.Split(p.Header("X-Failed-Recipients"), ",") {
+			if recipient = strings.TrimSpace(recipient); recipient != "" {
+				bounces = append(bounces, Bounce{Recipient: strings.ToLower(recipient), Action: "failed"})
+			}
+		}
+	}
+	for i := range bounces {
+		bounces[i].OriginalMessageID = original
+	}
+	return bounces
+}
+
+// parseDeliveryStatus reads the per-recipient fields of a delivery-status
+// body: a block of per-message fields, then a block for each recipient
+func parseDeliveryStatus(data []byte) []Bounce {
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(data)))
+	var bounces []Bounce
+	_, err := r.ReadMIMEHeader()
+	for err == nil {
+		var fields textproto.MIMEHeader
+		// A block cut short by the end of the body is still read
+		fields, err = r.ReadMIMEHeader()
+		recipient := deliveryAddress(fields.Get("Final-Recipient"))
+		if recipient == "" {
+			recipient = deliveryAddress(fields.Get("Original-Recipient"))
+		}
+		action := strings.ToLower(strings.TrimSpace(fields.Get("Action")))
+		if recipient == "" || action == "delivered" || action == "relayed" || action == "expanded" {
+			continue
+		}
+		bounces = append(bounces, Bounce{
+			Recipient:  recipient,
+			Action:     action,
+			Status:     strings.TrimSpace(fields.Get("Status")),
+			Diagnostic: deliveryValue(fields.Get("Diagnostic-Code")),
+		})
+	}
+	return bounces
+}
+
+// deliveryAddress reads an address field such as "rfc822; ana@example.com"
+func deliveryAddress(value string) string {
+	return strings.ToLower(strings.Trim(deliveryValue(value), "<>"))
+}
+
+// deliveryValue drops the type of a typed field such as "smtp; 550 ..."
+func deliveryValue(value string) string {
+	if _, v, ok := strings.Cut(value, ";"); ok {
+		value = v
+	}
+	return strings.Join(strings.Fields(value), " ")
+}
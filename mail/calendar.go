@@ -0,0 +1,419 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Participation statuses of a meeting attendee (RFC 5545 PARTSTAT)
+const (
+	PartStatNeedsAction = "NEEDS-ACTION"
+	PartStatAccepted    = "ACCEPTED"
+	PartStatDeclined    = "DECLINED"
+	PartStatTentative   = "TENTATIVE"
+)
+
+// Attendee is the organizer or an attendee of a calendar event
+type Attendee struct {
+	Name   string `json:"name,omitempty"`
+	Email  string `json:"email"`
+	Role   string `json:"role,omitempty"`
+	Status string `json:"status,omitempty"` // PARTSTAT; empty for the organizer
+	RSVP   bool   `json:"rsvp,omitempty"`   // A response is expected
+}
+
+// Event is a VEVENT of an iCalendar object (RFC 5545). Method is the iTIP
+// method of the enclosing calendar (RFC 5546): REQUEST for invitations,
+// CANCEL, REPLY...
+type Event struct {
+	Method      string     `json:"method,omitempty"`
+	UID         string     `json:"uid"`
+	Sequence    int        `json:"sequence,omitempty"`
+	Status      string     `json:"status,omitempty"`
+	Summary     string     `json:"summary"`
+	Description string     `json:"description,omitempty"`
+	Location    string     `json:"location,omitempty"`
+	Start       time.Time  `json:"start"`
+	End         time.Time  `json:"end"`
+	AllDay      bool       `json:"all_day,omitempty"`
+	Organizer   *Attendee  `json:"organizer,omitempty"`
+	Attendees   []Attendee `json:"attendees,omitempty"`
+}
+
+// Attendee returns the attendee with the given address, or nil
+func (e *Event) Attendee(address string) *Attendee {
+	for i := range e.Attendees {
+		if strings.EqualFold(e.Attendees[i].Email, address) {
+			return &e.Attendees[i]
+		}
+	}
+	return nil
+}
+
+// Windows zone names sent by Exchange and Outlook in TZID, for the zones
+// most often seen; others fall back to the server's local time
+var windowsZones = map[string]string{
+	"GMT Standard Time":              "Europe/London",
+	"Greenwich Standard Time":        "Atlantic/Reykjavik",
+	"W. Europe Standard Time":        "Europe/Berlin",
+	"Romance Standard Time":          "Europe/Paris",
+	"Central Europe Standard Time":   "Europe/Budapest",
+	"Central European Standard Time": "Europe/Warsaw",
+	"FLE Standard Time":              "Europe/Kiev",
+	"GTB Standard Time":              "Europe/Bucharest",
+	"Russian Standard Time":          "Europe/Moscow",
+	"Eastern Standard Time":          "America/New_York",
+	"Central Standard Time":          "America/Chicago",
+	"Mountain Standard Time":         "America/Denver",
+	"Pacific Standard Time":          "America/Los_Angeles",
+	"Central Standard Time (Mexico)": "America/Mexico_City",
+	"SA Pacific Standard Time":       "America/Bogota",
+	"Argentina Standard Time":        "America/Buenos_Aires",
+	"E. South America Standard Time": "America/Sao_Paulo",
+	"India Standard Time":            "Asia/Kolkata",
+	"China Standard Time":            "Asia/Shanghai",
+	"Tokyo Standard Time":            "Asia/Tokyo",
+	"AUS Eastern Standard Time":      "Australia/Sydney",
+}
+
+// calendarLine is one unfolded content line: NAME;PARAM=value:VALUE
+type calendarLine struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// ParseCalendar reads the events of an iCalendar object. Properties it does
+// not know are skipped, as are VTIMEZONE definitions: TZID parameters are
+// resolved with the IANA database instead.
+func ParseCalendar(data []byte) ([]Event, error) {
+	lines := unfoldCalendar(data)
+	if len(lines) == 0 || lines[0].name != "BEGIN" || !strings.EqualFold(lines[0].value, "VCALENDAR") {
+		return nil, fmt.Errorf("not an iCalendar object")
+	}
+
+	var (
+		method   string
+		events   []Event
+		event    *Event
+		duration time.Duration
+		depth    int // Components nested in the current VEVENT, such as VALARM
+	)
+	for _, line := range lines {
+		if event == nil {
+			switch {
+			case line.name == "METHOD":
+				method = strings.ToUpper(line.value)
+			case line.name == "BEGIN" && strings.EqualFold(line.value, "VEVENT"):
+				event, duration, depth = &Event{}, 0, 0
+			}
+			continue
+		}
+
+		switch {
+		case line.name == "BEGIN":
+			depth++
+			continue
+		case line.name == "END" && depth > 0:
+			depth--
+			continue
+		case line.name == "END":
+			if event.End.IsZero() && !event.Start.IsZero() {
+				event.End = event.Start.Add(duration)
+			}
+			event.Method = method
+			events = append(events, *event)
+			event = nil
+			continue
+		case depth > 0:
+			continue
+		}
+
+		var err error
+		switch line.name {
+		case "UID":
+			event.UID = line.value
+		case "SEQUENCE":
+			event.Sequence, _ = strconv.Atoi(line.value)
+		case "STATUS":
+			event.Status = strings.ToUpper(line.value)
+		case "SUMMARY":
+			event.Summary = unescapeText(line.value)
+		case "DESCRIPTION":
+			event.Description = unescapeText(line.value)
+		case "LOCATION":
+			event.Location = unescapeText(line.value)
+		case "DTSTART":
+			event.Start, event.AllDay, err = parseCalendarTime(line)
+		case "DTEND":
+			event.End, _, err = parseCalendarTime(line)
+		case "DURATION":
+			duration, err = parseCalendarDuration(line.value)
+		case "ORGANIZER":
+			organizer := calendarAttendee(line)
+			event.Organizer = &organizer
+		case "ATTENDEE":
+			attendee := calendarAttendee(line)
+			if attendee.Status == "" {
+				attendee.Status = PartStatNeedsAction
+			}
+			event.Attendees = append(event.Attendees, attendee)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", line.name, err)
+		}
+	}
+
+	return events, nil
+}
+
+// Events parses the text/calendar parts of the message, such as meeting
+// invitations. Parts that cannot be parsed are skipped.
+func (p *ParsedEmail) Events() []Event {
+	var events []Event
+	for _, att := range p.Attachments {
+		isCalendar := att.ContentType == "text/calendar" || att.ContentType == "application/ics" ||
+			strings.HasSuffix(strings.ToLower(att.Filename), ".ics")
+		if !isCalendar {
+			continue
+		}
+		parsed, err := ParseCalendar(att.Data)
+		if err != nil {
+			continue
+		}
+		// Invitations usually carry the same calendar twice, as an
+		// alternative body and as an .ics attachment
+		for _, event := range parsed {
+			if !containsEvent(events, event) {
+				events = append(events, event)
+			}
+		}
+	}
+	return events
+}
+
+func containsEvent(events []Event, event Event) bool {
+	for _, e := range events {
+		if e.UID == event.UID && e.Sequence == event.Sequence && e.Method == event.Method {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildCalendarReply renders the iTIP REPLY (RFC 5546) telling the organizer
+// of event that attendee answered with status: PartStatAccepted,
+// PartStatDeclined or PartStatTentative.
+func BuildCalendarReply(event Event, attendee Attendee, status string, now time.Time) ([]byte, error) {
+	switch status {
+	case PartStatAccepted, PartStatDeclined, PartStatTentative:
+	default:
+		return nil, fmt.Errorf("invalid response status: %s", status)
+	}
+	if event.UID == "" {
+		return nil, fmt.Errorf("event has no UID")
+	}
+	if event.Organizer == nil || event.Organizer.Email == "" {
+		return nil, fmt.Errorf("event has no organizer to reply to")
+	}
+
+	var buf bytes.Buffer
+	writeLine := func(line string) {
+		// Fold at 75 octets, without splitting UTF-8 sequences
+		for len(line) > 75 {
+			n := 75
+			for n > 0 && line[n]&0xC0 == 0x80 {
+				n--
+			}
+			buf.WriteString(line[:n] + "\r\n ")
+			line = line[n:]
+		}
+		buf.WriteString(line + "\r\n")
+	}
+
+	writeLine("BEGIN:VCALENDAR")
+	writeLine("VERSION:2.0")
+	writeLine("PRODID:-//email-mcp-server//EN")
+	writeLine("METHOD:REPLY")
+	writeLine("BEGIN:VEVENT")
+	writeLine("UID:" + event.UID)
+	writeLine("SEQUENCE:" + strconv.Itoa(event.Sequence))
+	writeLine("DTSTAMP:" + now.UTC().Format("20060102T150405Z"))
+	if !event.Start.IsZero() {
+		if event.AllDay {
+			writeLine("DTSTART;VALUE=DATE:" + event.Start.Format("20060102"))
+		} else {
+			writeLine("DTSTART:" + event.Start.UTC().Format("20060102T150405Z"))
+		}
+	}
+	if event.Summary != "" {
+		writeLine("SUMMARY:" + escapeText(event.Summary))
+	}
+	writeLine("ORGANIZER" + nameParam(event.Organizer.Name) + ":mailto:" + event.Organizer.Email)
+	writeLine("ATTENDEE;PARTSTAT=" + status + nameParam(attendee.Name) + ":mailto:" + attendee.Email)
+	writeLine("END:VEVENT")
+	writeLine("END:VCALENDAR")
+	return buf.Bytes(), nil
+}
+
+func nameParam(name string) string {
+	name = strings.NewReplacer(`"`, "", "\r", "", "\n", "").Replace(name)
+	if name == "" {
+		return ""
+	}
+	return `;CN="` + name + `"`
+}
+
+// unfoldCalendar splits data into content lines, joining folded ones
+func unfoldCalendar(data []byte) []calendarLine {
+	var lines []calendarLine
+	var current string
+	flush := func() {
+		if current != "" {
+			if line, ok := parseCalendarLine(current); ok {
+				lines = append(lines, line)
+			}
+		}
+		current = ""
+	}
+
+	for _, raw := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		if strings.HasPrefix(raw, " ") || strings.HasPrefix(raw, "\t") {
+			current += raw[1:]
+			continue
+		}
+		flush()
+		current = raw
+	}
+	flush()
+	return lines
+}
+
+func parseCalendarLine(raw string) (calendarLine, bool) {
+	// The value starts at the first colon outside a quoted parameter value
+	quoted := false
+	colon := -1
+	for i, r := range raw {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon <= 0 {
+		return calendarLine{}, false
+	}
+
+	line := calendarLine{params: make(map[string]string), value: raw[colon+1:]}
+	fields := splitUnquoted(raw[:colon], ';')
+	line.name = strings.ToUpper(strings.TrimSpace(fields[0]))
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		line.params[strings.ToUpper(strings.TrimSpace(key))] = strings.Trim(value, `"`)
+	}
+	return line, true
+}
+
+func splitUnquoted(s string, sep rune) []string {
+	var fields []string
+	quoted := false
+	start := 0
+	for i, r := range s {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == sep && !quoted {
+			fields = append(fields, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(fields, s[start:])
+}
+
+// parseCalendarTime reads a DATE or DATE-TIME value: UTC when it ends in Z,
+// in its TZID zone when one is given, and otherwise floating, which is read
+// as local time
+func parseCalendarTime(line calendarLine) (time.Time, bool, error) {
+	value := strings.TrimSpace(line.value)
+	if line.params["VALUE"] == "DATE" || len(value) == 8 {
+		t, err := time.ParseInLocation("20060102", value, time.Local)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, calendarLocation(line.params["TZID"]))
+	return t, false, err
+}
+
+func calendarLocation(tzid string) *time.Location {
+	tzid = strings.TrimPrefix(tzid, "/")
+	if tzid == "" {
+		return time.Local
+	}
+	if name, ok := windowsZones[tzid]; ok {
+		tzid = name
+	}
+	if loc, err := time.LoadLocation(tzid); err == nil {
+		return loc
+	}
+	return time.Local
+}
+
+// parseCalendarDuration reads a positive RFC 5545 duration such as PT1H30M
+// or P1D
+func parseCalendarDuration(value string) (time.Duration, error) {
+	value = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(value)), "+")
+	if !strings.HasPrefix(value, "P") {
+		return 0, fmt.Errorf("invalid duration: %s", value)
+	}
+
+	var d time.Duration
+	units := map[byte]time.Duration{'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour, 'H': time.Hour, 'M': time.Minute, 'S': time.Second}
+	n := 0
+	digits := false
+	for i := 1; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == 'T':
+		case c >= '0' && c <= '9':
+			n = n*10 + int(c-'0')
+			digits = true
+		case units[c] != 0 && digits:
+			d += time.Duration(n) * units[c]
+			n, digits = 0, false
+		default:
+			return 0, fmt.Errorf("invalid duration: %s", value)
+		}
+	}
+	return d, nil
+}
+
+func calendarAttendee(line calendarLine) Attendee {
+	address := strings.TrimSpace(line.value)
+	if len(address) > 7 && strings.EqualFold(address[:7], "mailto:") {
+		address = address[7:]
+	}
+	return Attendee{
+		Name:   unescapeText(line.params["CN"]),
+		Email:  address,
+		Role:   strings.ToUpper(line.params["ROLE"]),
+		Status: strings.ToUpper(line.params["PARTSTAT"]),
+		RSVP:   strings.EqualFold(line.params["RSVP"], "TRUE"),
+	}
+}
+
+func unescapeText(value string) string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(value)
+}
+
+func escapeText(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, ",", `\,`, ";", `\;`).Replace(value)
+}
@@ -0,0 +1,129 @@
+package mail
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// Charsets are looked up by their WHATWG labels first, which read
+// ISO-8859-1 as windows-1252 like browsers do: mail labelled Latin-1 often
+// carries windows-1252 quotes and euro signs. Text that is not valid UTF-8
+// although labelled so, or labelled US-ASCII or not at all, is read as
+// windows-1252 too.
+
+var wordDecoder = &mime.WordDecoder{CharsetReader: CharsetReader}
+
+// DecodeHeader decodes RFC 2047 encoded-words, returning the input unchanged
+// if it cannot be decoded. Encoded words some senders break, with spaces
+// left unencoded inside them, are decoded as well.
+func DecodeHeader(value string) string {
+	decoded, err := wordDecoder.DecodeHeader(value)
+	if err == nil && !strings.Contains(decoded, "=?") {
+		return decoded
+	}
+	return decodeWordsLeniently(value)
+}
+
+// encodedWord matches an encoded word, allowing spaces in its text
+var encodedWord = regexp.MustCompile(`=\?[^?\s]+\?[bBqQ]\?[^?]*\?=`)
+
+// decodeWordsLeniently decodes each encoded word on its own, dropping the
+// whitespace between adjacent words as RFC 2047 requires. Words that still
+// cannot be decoded are left as they are.
+func decodeWordsLeniently(value string) string {
+	var b strings.Builder
+	last, afterWord := 0, false
+	for _, loc := range encodedWord.FindAllStringIndex(value, -1) {
+		between, word := value[last:loc[0]], value[loc[0]:loc[1]]
+		last = loc[1]
+
+		// Spaces in Q-encoded text stand for underscores
+		parts := strings.SplitN(word, "?", 4)
+		if strings.EqualFold(parts[2], "q") {
+			word = strings.ReplaceAll(word, " ", "_")
+		}
+		text, err := wordDecoder.Decode(word)
+		if err != nil {
+			b.WriteString(between + value[loc[0]:loc[1]])
+			afterWord = false
+			continue
+		}
+		if !afterWord || strings.TrimSpace(between) != "" {
+			b.WriteString(between)
+		}
+		b.WriteString(text)
+		afterWord = true
+	}
+	b.WriteString(value[last:])
+	return b.String()
+}
+
+// decodeCharset converts data in the given charset to UTF-8. Data in an
+// unknown charset is returned as is when it is valid UTF-8 and read as
+// windows-1252 otherwise.
+func decodeCharset(charset string, data []byte) string {
+	enc := lookupCharset(charset)
+	if enc == nil {
+		enc = fallbackCharset(data)
+		if enc == nil {
+			return string(data)
+		}
+	}
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return string(data)
+	}
+	return string(decoded)
+}
+
+// fallbackCharset returns the charset of text whose label is missing or
+// unknown: nil, for UTF-8, when it is valid UTF-8, windows-1252 otherwise
+func fallbackCharset(data []byte) encoding.Encoding {
+	if utf8.Valid(data) {
+		return nil
+	}
+	return charmap.Windows1252
+}
+
+func lookupCharset(charset string) encoding.Encoding {
+	charset = strings.ToLower(strings.TrimSpace(charset))
+	// Text labelled US-ASCII is often UTF-8 in fact, so it is read as
+	// unlabelled text
+	if charset == "" || charset == "utf-8" || charset == "utf8" || charset == "us-ascii" || charset == "ascii" {
+		return nil
+	}
+	if enc, err := htmlindex.Get(charset); err == nil {
+		return enc
+	}
+	if enc, err := ianaindex.MIME.Encoding(charset); err == nil && enc != nil {
+		return enc
+	}
+	return nil
+}
+
+// CharsetReader converts text in the named charset to UTF-8, for
+// mime.WordDecoder and go-imap's envelope decoding. Unknown charsets are
+// read as UTF-8 when valid and as windows-1252 otherwise.
+func CharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	enc := lookupCharset(charset)
+	if enc == nil {
+		data, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		if enc = fallbackCharset(data); enc == nil {
+			return bytes.NewReader(data), nil
+		}
+		input = bytes.NewReader(data)
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
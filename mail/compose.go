@@ -0,0 +1,288 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// OutgoingMessage describes a message to be sent over SMTP
+type OutgoingMessage struct {
+	From        string
+	To          []string
+	Cc          []string
+	Bcc         []string // Envelope-only; never written to the headers
+	Subject     string
+	Body        string
+	Attachments []Attachment
+	Calendar    []byte    // iCalendar object sent as a text/calendar alternative of Body
+	MessageID   string    // Written as Message-ID; generated from From when empty
+	Date        time.Time // Written as Date; the time of Build when zero
+	Notify      []string  // DSN conditions requested for every recipient; empty leaves it to the server
+	InReplyTo   string    // Message-ID of the message answered, written as In-Reply-To and References
+	AutoReply   bool      // Marks the message as Auto-Submitted: auto-replied (RFC 3834)
+}
+
+// Build renders the message in RFC 5322 form, with the Date, Message-ID and
+// MIME-Version headers every message needs. Non-ASCII subjects and display
+// names are encoded as RFC 2047 encoded words, and long header lines are
+// folded. A plain text body is sent as is when it is short-lined ASCII and
+// quoted-printable otherwise. Messages with attachments are sent as
+// multipart/mixed with base64-encoded parts as described in RFC 2045; a
+// Calendar is sent as multipart/alternative next to the text body.
+func (m *OutgoingMessage) Build() ([]byte, error) {
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	messageID := m.MessageID
+	if messageID == "" {
+		messageID = NewMessageID(m.From)
+	}
+
+	var buf bytes.Buffer
+	writeHeader(&buf, "From", formatAddressList([]string{m.From}))
+	if len(m.To) > 0 {
+		writeHeader(&buf, "To", formatAddressList(m.To))
+	}
+	if len(m.Cc) > 0 {
+		writeHeader(&buf, "Cc", formatAddressList(m.Cc))
+	}
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("UTF-8", m.Subject))
+	writeHeader(&buf, "Date", date.Format(time.RFC1123Z))
+	writeHeader(&buf, "Message-ID", messageID)
+	if m.InReplyTo != "" && !strings.ContainsAny(m.InReplyTo, "\r\n") {
+		writeHeader(&buf, "In-Reply-To", m.InReplyTo)
+		writeHeader(&buf, "References", m.InReplyTo)
+	}
+	if m.AutoReply {
+		writeHeader(&buf, "Auto-Submitted", "auto-replied")
+	}
+	writeHeader(&buf, "MIME-Version", "1.0")
+
+	if len(m.Attachments) == 0 && m.Calendar == nil {
+		if is7Bit(m.Body) {
+			fmt.Fprintf(&buf, "Content-Type: text/plain; charset=UTF-8\r\nContent-Transfer-Encoding: 7bit\r\n\r\n%s", m.Body)
+			return buf.Bytes(), nil
+		}
+		buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n")
+		qp := quotedprintable.NewWriter(&buf)
+		if _, err := qp.Write([]byte(m.Body)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&buf)
+	if len(m.Attachments) == 0 {
+		fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", mw.Boundary())
+		if err := m.writeAlternatives(mw); err != nil {
+			return nil, err
+		}
+		if err := mw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", mw.Boundary())
+
+	if m.Calendar != nil {
+		altBoundary := multipart.NewWriter(nil).Boundary()
+		altPart, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": altBoundary})},
+		})
+		if err != nil {
+			return nil, err
+		}
+		aw := multipart.NewWriter(altPart)
+		if err := aw.SetBoundary(altBoundary); err != nil {
+			return nil, err
+		}
+		if err := m.writeAlternatives(aw); err != nil {
+			return nil, err
+		}
+		if err := aw.Close(); err != nil {
+			return nil, err
+		}
+	} else if err := m.writeText(mw); err != nil {
+		return nil, err
+	}
+
+	for _, att := range m.Attachments {
+		if att.Filename == "" {
+			return nil, fmt.Errorf("attachment filename is required")
+		}
+
+		contentType := att.ContentType
+		if contentType == "" {
+			contentType = mime.TypeByExtension(filepath.Ext(att.Filename))
+		}
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(contentType, map[string]string{"name": att.Filename})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": att.Filename})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBase64Lines(part, att.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeText writes Body as a quoted-printable text/plain part
+func (m *OutgoingMessage) writeText(mw *multipart.Writer) error {
+	textPart, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qp := quotedprintable.NewWriter(textPart)
+	if _, err := qp.Write([]byte(m.Body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// writeAlternatives writes Body followed by Calendar, whose iTIP method is
+// repeated in the Content-Type as calendar clients expect (RFC 6047)
+func (m *OutgoingMessage) writeAlternatives(mw *multipart.Writer) error {
+	if err := m.writeText(mw); err != nil {
+		return err
+	}
+
+	params := map[string]string{"charset": "UTF-8"}
+	for _, line := range unfoldCalendar(m.Calendar) {
+		if line.name == "METHOD" {
+			params["method"] = strings.ToUpper(line.value)
+			break
+		}
+	}
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {mime.FormatMediaType("text/calendar", params)},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return err
+	}
+	return writeBase64Lines(part, m.Calendar)
+}
+
+// maxHeaderLine is the line length headers are folded at (RFC 5322 2.1.1)
+const maxHeaderLine = 78
+
+// writeHeader writes a header field, folding the value at spaces so lines
+// stay within maxHeaderLine where it has spaces to fold at; a long first word
+// goes on its own line after the field name. Line breaks in the value are
+// replaced, so it cannot start another field.
+func writeHeader(buf *bytes.Buffer, name, value string) {
+	value = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(value)
+	line := name + ":"
+	for _, word := range strings.Split(value, " ") {
+		if len(line)+1+len(word) > maxHeaderLine && strings.TrimSpace(line) != "" {
+			buf.WriteString(line + "\r\n")
+			line = ""
+		}
+		line += " " + word
+	}
+	buf.WriteString(line + "\r\n")
+}
+
+// formatAddressList joins addresses for a header, encoding non-ASCII display
+// names. Addresses that do not parse are written as given.
+func formatAddressList(addresses []string) string {
+	formatted := make([]string, len(addresses))
+	for i, address := range addresses {
+		formatted[i] = address
+		parsed, err := netmail.ParseAddress(address)
+		if err != nil {
+			continue
+		}
+		if parsed.Name == "" {
+			formatted[i] = parsed.Address
+		} else {
+			formatted[i] = parsed.String()
+		}
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// is7Bit reports whether body can be sent without a transfer encoding:
+// ASCII, with lines of at most 998 characters (RFC 5322 2.1.1)
+func is7Bit(body string) bool {
+	for _, line := range strings.Split(body, "\n") {
+		if len(line) > 998 {
+			return false
+		}
+	}
+	for i := 0; i < len(body); i++ {
+		if body[i] >= 0x80 || body[i] == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Recipients returns the SMTP envelope recipients: To, Cc and Bcc combined
+func (m *OutgoingMessage) Recipients() []string {
+	recipients := make([]string, 0, len(m.To)+len(m.Cc)+len(m.Bcc))
+	recipients = append(recipients, m.To...)
+	recipients = append(recipients, m.Cc...)
+	return append(recipients, m.Bcc...)
+}
+
+// writeBase64Lines encodes data wrapped at 76 characters per line (RFC 2045)
+func writeBase64Lines(w io.Writer, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 0 {
+		n := 76
+		if len(encoded) < n {
+			n = len(encoded)
+		}
+		if _, err := fmt.Fprintf(w, "%s\r\n", encoded[:n]); err != nil {
+			return err
+		}
+		encoded = encoded[n:]
+	}
+	return nil
+}
+
+// NewMessageID returns a unique Message-ID for a message sent from the given
+// address, using its domain as the right-hand side
+func NewMessageID(from string) string {
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 && i < len(from)-1 {
+		domain = strings.Trim(from[i+1:], "<> ")
+	}
+
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(buf), domain)
+}
@@ -0,0 +1,56 @@
+package mail
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Delivery status notification conditions a sender may request for each
+// recipient (RFC 3461). Never excludes the others.
+const (
+	NotifySuccess = "SUCCESS"
+	NotifyFailure = "FAILURE"
+	NotifyDelay   = "DELAY"
+	NotifyNever   = "NEVER"
+)
+
+// ParseNotify normalizes DSN conditions given separately or comma-separated,
+// returning them upper-cased and without duplicates
+func ParseNotify(values ...string) ([]string, error) {
+	var notify []string
+	for _, value := range values {
+		for _, condition := range strings.Split(value, ",") {
+			condition = strings.ToUpper(strings.TrimSpace(condition))
+			switch condition {
+			case "":
+				continue
+			case NotifySuccess, NotifyFailure, NotifyDelay, NotifyNever:
+			default:
+				return nil, fmt.Errorf("unknown delivery notification %q (use success, failure, delay or never)", condition)
+			}
+			if !slices.Contains(notify, condition) {
+				notify = append(notify, condition)
+			}
+		}
+	}
+	if slices.Contains(notify, NotifyNever) && len(notify) > 1 {
+		return nil, fmt.Errorf("delivery notification never cannot be combined with other conditions")
+	}
+	return notify, nil
+}
+
+// XText encodes s as an RFC 3461 xtext, the form of the ENVID and ORCPT
+// parameters: characters outside printable ASCII, "+" and "=" become +XX
+func XText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < '!' || c > '~' || c == '+' || c == '=' {
+			fmt.Fprintf(&b, "+%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
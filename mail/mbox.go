@@ -0,0 +1,37 @@
+package mail
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"time"
+)
+
+// mboxFromLine matches the lines mboxrd quotes with one more '>'
+var mboxFromLine = regexp.MustCompile(`(?m)^>*From `)
+
+// WriteMbox appends a message to an mbox file in the mboxrd format: a
+// "From " separator line with the envelope sender and date, the message with
+// LF line endings and its "From " lines quoted, then a blank line
+func WriteMbox(w io.Writer, sender string, date time.Time, raw []byte) error {
+	if sender == "" {
+		sender = "MAILER-DAEMON"
+	}
+	if date.IsZero() {
+		date = time.Now()
+	}
+	body := bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n"))
+	body = mboxFromLine.ReplaceAllFunc(body, func(line []byte) []byte {
+		return append([]byte(">"), line...)
+	})
+	if !bytes.HasSuffix(body, []byte("\n")) {
+		body = append(body, '\n')
+	}
+
+	var b bytes.Buffer
+	b.WriteString("From " + sender + " " + date.UTC().Format(time.ANSIC) + "\n")
+	b.Write(body)
+	b.WriteString("\n")
+	_, err := w.Write(b.Bytes())
+	return err
+}
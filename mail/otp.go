@@ -0,0 +1,60 @@
+package mail
+
+import (
+	"strconv"
+	"strings"
+)
+
+// verificationSubjects mark the subjects of login and verification emails
+var verificationSubjects = []string{"verification", "verify", "code", "otp", "passcode", "one-time", "sign in", "sign-in",
+	"log in", "login", "2fa", "two-factor", "security", "código", "codigo", "verificación", "verifica", "inicio de sesión", "clave"}
+
+// verificationSenders are found in the local parts of the addresses that
+// send codes, such as account-security-noreply@ or verify@
+var verificationSenders = []string{"noreply", "no-reply", "donotreply", "do-not-reply", "security", "verify", "verification",
+	"auth", "account", "login", "otp"}
+
+// VerificationCode finds the one-time code of a login or verification
+// email: a 4 to 8 digit number next to a word like "code", "OTP" or
+// "código", in the subject or else the body. Four-digit numbers that read as
+// years are skipped. It is "" when there is none.
+func VerificationCode(subject, body string) string {
+	for _, text := range []string{subject, body} {
+		best, bestAt := "", -1
+		for _, match := range append(otpAfterPattern.FindAllStringSubmatchIndex(text, -1), otpBeforePattern.FindAllStringSubmatchIndex(text, -1)...) {
+			code := text[match[2]:match[3]]
+			if isYear(code) || (bestAt >= 0 && match[2] >= bestAt) {
+				continue
+			}
+			best, bestAt = code, match[2]
+		}
+		if best != "" {
+			return best
+		}
+	}
+	return ""
+}
+
+// LooksLikeVerification reports whether an email seems sent to deliver a
+// code: from a no-reply, security or verification address, or with a
+// subject about verifying or signing in
+func LooksLikeVerification(from Address, subject string) bool {
+	local, _, _ := strings.Cut(strings.ToLower(from.Address), "@")
+	for _, word := range verificationSenders {
+		if strings.Contains(local, word) {
+			return true
+		}
+	}
+	subject = strings.ToLower(subject)
+	for _, word := range verificationSubjects {
+		if strings.Contains(subject, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func isYear(code string) bool {
+	n, _ := strconv.Atoi(code)
+	return len(code) == 4 && n >= 1900 && n <= 2099
+}
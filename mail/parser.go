@@ -0,0 +1,178 @@
+// Package mail parses raw RFC 5322 messages into decoded text, HTML and
+// attachment parts so every tool works from the same representation.
+package mail
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	netmail "net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// Attachment is a non-body MIME part of a message
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	ContentID   string `json:"content_id,omitempty"`
+	Inline      bool   `json:"inline,omitempty"`
+	Size        int    `json:"size"`
+	Data        []byte `json:"-"`
+}
+
+// ParsedEmail is the decoded content of a message
+type ParsedEmail struct {
+	Headers     map[string][]string `json:"headers"`
+	TextBody    string              `json:"text_body"`
+	HTMLBody    string              `json:"html_body,omitempty"`
+	Attachments []Attachment        `json:"attachments,omitempty"`
+}
+
+// Header returns the first decoded value of the named header
+func (p *ParsedEmail) Header(key string) string {
+	values := p.Headers[textproto.CanonicalMIMEHeaderKey(key)]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Parse reads a raw message and decodes its headers, bodies and attachments
+func Parse(r io.Reader) (*ParsedEmail, error) {
+	msg, err := netmail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+
+	parsed := decodeHeaders(msg.Header)
+	if err := parsed.walk(textproto.MIMEHeader(msg.Header), msg.Body); err != nil {
+		return nil, err
+	}
+
+	parsed.TextBody = strings.TrimSpace(parsed.TextBody)
+	parsed.HTMLBody = strings.TrimSpace(parsed.HTMLBody)
+	return parsed, nil
+}
+
+// ParseHeader decodes the header block of a message, fetched without its
+// body, which Parse rejects when it announces a multipart body
+func ParseHeader(r io.Reader) (*ParsedEmail, error) {
+	msg, err := netmail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message header: %w", err)
+	}
+	return decodeHeaders(msg.Header), nil
+}
+
+func decodeHeaders(header netmail.Header) *ParsedEmail {
+	parsed := &ParsedEmail{Headers: make(map[string][]string, len(header))}
+	for key, values := range header {
+		for _, value := range values {
+			parsed.Headers[key] = append(parsed.Headers[key], DecodeHeader(value))
+		}
+	}
+	return parsed
+}
+
+// ParseBytes is a convenience wrapper around Parse
+func ParseBytes(raw []byte) (*ParsedEmail, error) {
+	return Parse(bytes.NewReader(raw))
+}
+
+func (p *ParsedEmail) walk(header textproto.MIMEHeader, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+
+	disposition, dispParams, _ := mime.ParseMediaType(header.Get("Content-Disposition"))
+	filename := DecodeHeader(dispParams["filename"])
+	if filename == "" {
+		filename = DecodeHeader(params["name"])
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		return p.walkMultipart(mediaType, params["boundary"], body)
+	}
+
+	data, err := io.ReadAll(DecodeTransfer(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil && len(data) == 0 {
+		return fmt.Errorf("failed to decode %s part: %w", mediaType, err)
+	}
+
+	isBody := disposition != "attachment" && filename == "" &&
+		(mediaType == "text/plain" || mediaType == "text/html")
+	if !isBody {
+		p.Attachments = append(p.Attachments, Attachment{
+			Filename:    filename,
+			ContentType: mediaType,
+			ContentID:   strings.Trim(header.Get("Content-Id"), "<>"),
+			Inline:      disposition == "inline",
+			Size:        len(data),
+			Data:        data,
+		})
+		return nil
+	}
+
+	text := decodeCharset(params["charset"], data)
+	if mediaType == "text/html" {
+		p.HTMLBody += text
+	} else {
+		p.TextBody += text
+	}
+	return nil
+}
+
+func (p *ParsedEmail) walkMultipart(mediaType, boundary string, body io.Reader) error {
+	if boundary == "" {
+		return fmt.Errorf("%s part without boundary", mediaType)
+	}
+
+	mr := multipart.NewReader(body, boundary)
+	for {
+		part, err := mr.NextRawPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s part: %w", mediaType, err)
+		}
+
+		if mediaType != "multipart/alternative" {
+			if err := p.walk(part.Header, part); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Alternatives carry the same content; keep only one text and one
+		// HTML rendering, preferring the later (richer) ones
+		alt := &ParsedEmail{}
+		if err := alt.walk(part.Header, part); err != nil {
+			return err
+		}
+		if alt.TextBody != "" {
+			p.TextBody = alt.TextBody
+		}
+		if alt.HTMLBody != "" {
+			p.HTMLBody = alt.HTMLBody
+		}
+		p.Attachments = append(p.Attachments, alt.Attachments...)
+	}
+}
+
+// DecodeTransfer wraps body in a decoder for the given Content-Transfer-Encoding
+func DecodeTransfer(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	}
+	return body
+}
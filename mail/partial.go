@@ -0,0 +1,55 @@
+package mail
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// DecodePart decodes a body part fetched on its own, possibly only its first
+// bytes, from its Content-Transfer-Encoding and charset to UTF-8. It decodes
+// as it reads and stops after limit bytes of text (0 for no limit), so a part
+// cut short by a partial fetch yields the text before the cut instead of an
+// error, without a half base64 quantum or a half character at the end.
+func DecodePart(r io.Reader, encoding, charset string, limit int) string {
+	if strings.EqualFold(strings.TrimSpace(encoding), "quoted-printable") {
+		// An escape cut in half would come out as is
+		raw, _ := io.ReadAll(r)
+		if i := bytes.LastIndexByte(raw, '='); i >= 0 && i >= len(raw)-2 {
+			raw = raw[:i]
+		}
+		r = bytes.NewReader(raw)
+	}
+
+	var decoded io.Reader = DecodeTransfer(encoding, r)
+	enc := lookupCharset(charset)
+	if enc != nil {
+		decoded = enc.NewDecoder().Reader(decoded)
+	}
+	if limit > 0 {
+		decoded = io.LimitReader(decoded, int64(limit))
+	}
+
+	// Errors only mean the part was cut; keep what was decoded before them
+	data, _ := io.ReadAll(decoded)
+	text := string(trimPartialRune(data))
+	if enc == nil {
+		// Unlabelled text that is not UTF-8 is read as windows-1252
+		text = decodeCharset("", []byte(text))
+	}
+	return strings.TrimSpace(text)
+}
+
+// trimPartialRune drops a character cut in half at the end of data
+func trimPartialRune(data []byte) []byte {
+	for n := 1; n <= utf8.UTFMax && n <= len(data); n++ {
+		if tail := data[len(data)-n:]; utf8.RuneStart(tail[0]) {
+			if !utf8.FullRune(tail) {
+				data = data[:len(data)-n]
+			}
+			break
+		}
+	}
+	return data
+}
@@ -0,0 +1,187 @@
+package mail
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Kinds of personal data Redact removes
+const (
+	RedactCard = "card" // Payment card numbers passing the Luhn check
+	RedactIBAN = "iban" // IBANs passing the mod-97 check
+	RedactSSN  = "ssn"  // US social security numbers written ###-##-####
+	RedactOTP  = "otp"  // 4 to 8 digit codes next to words like "code" or "OTP"
+)
+
+// RedactKinds lists every kind of data Redact knows, in the order applied
+var RedactKinds = []string{RedactCard, RedactIBAN, RedactSSN, RedactOTP}
+
+var (
+	cardPattern = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+	ibanPattern = regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,4})?\b`)
+	ssnPattern  = regexp.MustCompile(`\b(\d{3})-(\d{2})-(\d{4})\b`)
+	// The code may come before or after the word announcing it, on the
+	// same line
+	otpWords         = `(?:otp|passcode|one-time|verification|code|pin|c[oó]digo|clave)`
+	otpAfterPattern  = regexp.MustCompile(`(?i)\b` + otpWords + `\b[^\n\d]{0,40}?\b(\d{4,8})\b`)
+	otpBeforePattern = regexp.MustCompile(`(?i)\b(\d{4,8})\b[^\n\d]{0,30}?\b` + otpWords + `\b`)
+)
+
+// ParseRedact reads a comma-separated list of redaction kinds, where "all"
+// stands for every kind
+func ParseRedact(value string) ([]string, error) {
+	var kinds []string
+	for _, kind := range strings.Split(value, ",") {
+		kind = strings.ToLower(strings.TrimSpace(kind))
+		switch {
+		case kind == "":
+		case kind == "all":
+			return RedactKinds, nil
+		case slices.Contains(RedactKinds, kind):
+			if !slices.Contains(kinds, kind) {
+				kinds = append(kinds, kind)
+			}
+		default:
+			return nil, fmt.Errorf("unknown redaction %q, expected %s or all", kind, strings.Join(RedactKinds, ", "))
+		}
+	}
+	return kinds, nil
+}
+
+// Redact replaces the kinds of personal data found in text with
+// "[REDACTED <KIND>]", adding the number of replacements of each kind to
+// report when it is not nil
+func Redact(text string, kinds []string, report map[string]int) string {
+	for _, kind := range RedactKinds {
+		if !slices.Contains(kinds, kind) {
+			continue
+		}
+		n := 0
+		switch kind {
+		case RedactCard:
+			text, n = redactMatches(text, cardPattern, kind, validCard)
+		case RedactIBAN:
+			text, n = redactIBANs(text)
+		case RedactSSN:
+			text, n = redactMatches(text, ssnPattern, kind, validSSN)
+		case RedactOTP:
+			var m int
+			text, n = redactGroups(text, otpAfterPattern, kind)
+			text, m = redactGroups(text, otpBeforePattern, kind)
+			n += m
+		}
+		if n > 0 && report != nil {
+			report[kind] += n
+		}
+	}
+	return text
+}
+
+func redactionMark(kind string) string {
+	return "[REDACTED " + strings.ToUpper(kind) + "]"
+}
+
+// redactMatches replaces the matches of re that valid accepts
+func redactMatches(text string, re *regexp.Regexp, kind string, valid func(string) bool) (string, int) {
+	n := 0
+	text = re.ReplaceAllStringFunc(text, func(match string) string {
+		if !valid(match) {
+			return match
+		}
+		n++
+		return redactionMark(kind)
+	})
+	return text, n
+}
+
+// redactGroups replaces the first group of each match of re
+func redactGroups(text string, re *regexp.Regexp, kind string) (string, int) {
+	matches := re.FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		return text, 0
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		b.WriteString(text[last:m[2]])
+		b.WriteString(redactionMark(kind))
+		last = m[3]
+	}
+	b.WriteString(text[last:])
+	return b.String(), len(matches)
+}
+
+// redactIBANs replaces IBANs, dropping trailing groups the pattern took
+// from the following words until the checksum holds
+func redactIBANs(text string) (string, int) {
+	n := 0
+	text = ibanPattern.ReplaceAllStringFunc(text, func(match string) string {
+		groups := strings.Fields(match)
+		for end := len(groups); end > 0; end-- {
+			candidate := strings.Join(groups[:end], "")
+			if len(candidate) < 15 {
+				break
+			}
+			if validIBAN(candidate) {
+				n++
+				rest := strings.Join(groups[end:], " ")
+				if rest != "" {
+					rest = " " + rest
+				}
+				return redactionMark(RedactIBAN) + rest
+			}
+		}
+		return match
+	})
+	return text, n
+}
+
+// validCard applies the Luhn check to a 13 to 19 digit number
+func validCard(s string) bool {
+	digits := strings.NewReplacer(" ", "", "-", "").Replace(s)
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	for i := range digits {
+		d := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// validIBAN applies the ISO 13616 mod-97 check
+func validIBAN(s string) bool {
+	if len(s) < 15 || len(s) > 34 {
+		return false
+	}
+	rearranged := s[4:] + s[:4]
+	var digits strings.Builder
+	for _, r := range rearranged {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r >= 'A' && r <= 'Z':
+			fmt.Fprintf(&digits, "%d", r-'A'+10)
+		default:
+			return false
+		}
+	}
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// validSSN rejects the area, group and serial numbers never issued
+func validSSN(s string) bool {
+	parts := strings.Split(s, "-")
+	area, group, serial := parts[0], parts[1], parts[2]
+	return area != "000" && area != "666" && area[0] != '9' && group != "00" && serial != "0000"
+}
@@ -0,0 +1,84 @@
+package mail
+
+import (
+	"net/url"
+	"strings"
+)
+
+// UnsubscribeOption is one way to leave a mailing list, taken from the
+// List-Unsubscribe header (RFC 2369)
+type UnsubscribeOption struct {
+	Method   string `json:"method"` // mailto or http
+	URL      string `json:"url"`
+	Address  string `json:"address,omitempty"` // mailto recipient
+	Subject  string `json:"subject,omitempty"`
+	Body     string `json:"body,omitempty"`
+	OneClick bool   `json:"one_click,omitempty"` // RFC 8058 POST is supported
+}
+
+// ParseListUnsubscribe parses the List-Unsubscribe header and, for one-click
+// support, the List-Unsubscribe-Post header. Unknown or malformed URIs are
+// skipped.
+func ParseListUnsubscribe(header, post string) []UnsubscribeOption {
+	oneClick := strings.EqualFold(strings.ReplaceAll(post, " ", ""), "List-Unsubscribe=One-Click")
+
+	var options []UnsubscribeOption
+	for _, raw := range bracketedURIs(header) {
+		u, err := url.Parse(raw)
+		if err != nil {
+			continue
+		}
+
+		switch strings.ToLower(u.Scheme) {
+		case "mailto":
+			address := u.Opaque
+			if address == "" {
+				address = u.Path
+			}
+			if unescaped, err := url.PathUnescape(address); err == nil {
+				address = unescaped
+			}
+			if address == "" {
+				continue
+			}
+			query := u.Query()
+			options = append(options, UnsubscribeOption{
+				Method:  "mailto",
+				URL:     raw,
+				Address: address,
+				Subject: query.Get("subject"),
+				Body:    query.Get("body"),
+			})
+		case "http", "https":
+			options = append(options, UnsubscribeOption{
+				Method: "http",
+				URL:    raw,
+				// RFC 8058 requires HTTPS for one-click unsubscription
+				OneClick: oneClick && strings.EqualFold(u.Scheme, "https"),
+			})
+		}
+	}
+	return options
+}
+
+// bracketedURIs returns the <...> enclosed URIs of a header, in order. The
+// URIs are taken whole before the commas separating them are dropped, as a
+// URI may itself contain commas, and whitespace within the brackets, left by
+// folding, is ignored (RFC 2369).
+func bracketedURIs(header string) []string {
+	var uris []string
+	for {
+		start := strings.IndexByte(header, '<')
+		if start < 0 {
+			return uris
+		}
+		end := strings.IndexByte(header[start:], '>')
+		if end < 0 {
+			return uris
+		}
+		if uri := strings.Join(strings.Fields(header[start+1:start+end]), ""); uri != "" {
+			uris = append(uris, uri)
+		}
+		header = header[start+end+1:]
+	}
+}
@@ -2,30 +2,67 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/smtp"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
+
+	"email-mcp-server/ai"
+	"email-mcp-server/config"
+	"email-mcp-server/graph"
+	"email-mcp-server/imapext"
+	"email-mcp-server/mail"
+	"email-mcp-server/notifications"
+	"email-mcp-server/scheduler"
+	"email-mcp-server/storage"
+	emailsync "email-mcp-server/sync"
 )
 
-// Load .env file
+// loadEnv loads .env, else .env.example, from the working directory, the
+// executable's directory or the configuration directory, first found first
 func loadEnv() {
-	file, err := os.Open(".env")
-	if err != nil {
-		// Try .env.example if .env doesn't exist
-		file, err = os.Open(".env.example")
-		if err != nil {
-			return // No .env file found, use system environment
+	// The home directory is not known yet, as .env can set CONFIG_DIR
+	dirs := []string{".", executableDir(), os.Getenv("CONFIG_DIR")}
+	if dirs[2] == "" {
+		dirs[2] = userDir(os.UserConfigDir)
+	}
+	var file *os.File
+	for _, name := range []string{".env", ".env.example"} {
+		for _, dir := range dirs {
+			if dir == "" {
+				continue
+			}
+			if f, err := os.Open(filepath.Join(dir, name)); err == nil {
+				file = f
+				break
+			}
 		}
+		if file != nil {
+			break
+		}
+	}
+	if file == nil {
+		return // No .env file found, use system environment
 	}
 	defer file.Close()
 
@@ -65,8 +102,9 @@ type MCPResponse struct {
 }
 
 type MCPError struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
 }
 
 type ServerInfo struct {
@@ -78,6 +116,7 @@ type Tool struct {
 	Name        string      `json:"name"`
 	Description string      `json:"description"`
 	InputSchema interface{} `json:"inputSchema"`
+	Mutating    bool        `json:"-"` // Changes mail, accounts or settings; calls go to the audit log
 }
 
 type ToolCallParams struct {
@@ -97,14 +136,56 @@ type ToolResult struct {
 
 // Email Types
 type EmailConfig struct {
-	ID          string // Unique identifier for the account
+	ID          string `json:"-"` // Unique identifier for the account, the key in email_config.json
 	IMAPHost    string
 	IMAPPort    int
 	SMTPHost    string
 	SMTPPort    int
 	Username    string
-	Password    string
+	Password    string `json:",omitempty"` // Empty when PasswordSource is not plain
 	UseStartTLS bool
+
+	DisplayName           string `json:",omitempty"` // Name shown in the From header
+	ArchiveFolder         string `json:",omitempty"` // Used by archive_email instead of discovering the folder
+	TrashFolder           string `json:",omitempty"` // When set, deleted emails are moved here instead of expunged
+	SentFolder            string `json:",omitempty"` // When set, a copy of each sent email is stored here
+	Signature             string `json:",omitempty"` // Appended to composed messages
+	IncludeInDailySummary *bool  `json:",omitempty"` // Defaults to true
+	TimeoutSeconds        int    `json:",omitempty"` // Read/write timeout of each IMAP command and SMTP session (default: 30)
+	DialTimeoutSeconds    int    `json:",omitempty"` // Timeout for establishing connections (default: TimeoutSeconds)
+	IMAPPerMinute         int    `json:",omitempty"` // IMAP commands sent per minute, logins included, queued beyond that (default: 60, negative: unlimited)
+	SMTPPerMinute         int    `json:",omitempty"` // Emails sent per minute, queued beyond that (default: 20, negative: unlimited)
+	IMAPAuth              string `json:",omitempty"` // auto (default), LOGIN (the LOGIN command), PLAIN, CRAM-MD5 or NTLM
+	SMTPAuth              string `json:",omitempty"` // auto (default), PLAIN, LOGIN, CRAM-MD5 or NTLM
+	SMTPHelloName         string `json:",omitempty"` // Name sent with EHLO/HELO (default: localhost)
+	SMTPNotify            string `json:",omitempty"` // DSN conditions requested by default: comma-separated SUCCESS, FAILURE, DELAY or NEVER
+	Redact                string `json:",omitempty"` // Personal data removed from bodies before they are returned or stored: comma-separated card, iban, ssn, otp or all
+
+	SyncFolders []SyncFolder `json:",omitempty"` // Folders synced into the local database (default: INBOX and the Sent folder)
+
+	CategoryWriteBack bool              `json:",omitempty"` // Write stored categories to the server as keywords, or labels on Gmail
+	CategoryLabels    map[string]string `json:",omitempty"` // Keyword or label per category (default: $Category/<Category>, "" to not write one)
+
+	TLSCAFile             string `json:",omitempty"` // PEM bundle of CAs trusted besides the system roots
+	TLSCertFile           string `json:",omitempty"` // PEM client certificate, with TLSKeyFile
+	TLSKeyFile            string `json:",omitempty"` // PEM private key of TLSCertFile
+	TLSMinVersion         string `json:",omitempty"` // 1.0, 1.1, 1.2 or 1.3 (default: Go's minimum, 1.2)
+	TLSInsecureSkipVerify bool   `json:",omitempty"` // Accept any server certificate; lab environments only
+
+	PasswordSource string `json:",omitempty"` // plain (default), keyring, env or file; see credentials
+	PasswordEnv    string `json:",omitempty"` // Variable holding the password when PasswordSource is env
+
+	Provider      string `json:",omitempty"` // imap (default) or graph for Microsoft Graph, see graph.go
+	GraphTenant   string `json:",omitempty"` // Directory (tenant) ID or domain of a graph account (default: common)
+	GraphClientID string `json:",omitempty"` // Application (client) ID of the app registration of a graph account
+}
+
+// SyncFolder is a folder an account syncs into the local database and how
+type SyncFolder struct {
+	Name         string
+	HeadersOnly  bool `json:",omitempty"` // Store headers, flags and attachment names without a body snippet
+	Days         int  `json:",omitempty"` // Only fetch messages of the last Days days on the first sync (default: no limit)
+	InitialLimit int  `json:",omitempty"` // Newest messages fetched on the first sync (default: SYNC_INITIAL_LIMIT)
 }
 
 type EmailMessage struct {
@@ -113,11 +194,45 @@ type EmailMessage struct {
 	From    string    `json:"from"`
 	To      []string  `json:"to"`
 	Date    time.Time `json:"date"`
-	Body    string    `json:"body"`
-	Size    uint32    `json:"size"`
-	Flags   []string  `json:"flags"`
+	Body    string    `json:"body,omitempty"`
+	// From parsed into name and address, used for matching senders
+	FromAddress mail.Address `json:"from_address"`
+	HTMLBody    string       `json:"html_body,omitempty"`
+	Size        uint32       `json:"size"`
+	Flags       []string     `json:"flags"`
+	// Set by get_emails when the phishing checks find anything
+	Risk *ai.PhishingReport `json:"phishing_risk,omitempty"`
+	// Calendar events the message carries, set by get_email_body
+	Meetings []mail.Event `json:"meetings,omitempty"`
+	// Values removed from the bodies by the account's Redact setting, by kind
+	Redactions map[string]int `json:"redactions,omitempty"`
+	// Set when the bodies were cut to BODY_MAX_KB
+	Truncated bool `json:"truncated,omitempty"`
+	// Gmail labels, for accounts whose server is Gmail's
+	Labels []string `json:"labels,omitempty"`
+	// Kind of message a program sent: bounce, autoreply or auto_generated
+	Automated string `json:"automated,omitempty"`
+	// Attachments found in the fetched body, without their content
+	Attachments []mail.Attachment `json:"attachments,omitempty"`
+}
+
+// redact removes the personal data the account's Redact setting names from
+// the bodies, recording what was removed
+func (e *EmailMessage) redact(kinds []string) {
+	if len(kinds) == 0 {
+		return
+	}
+	report := make(map[string]int)
+	e.Body = mail.Redact(e.Body, kinds, report)
+	e.HTMLBody = mail.Redact(e.HTMLBody, kinds, report)
+	if len(report) > 0 {
+		e.Redactions = report
+	}
 }
 
+// AttachmentInfo describes an attachment part found in a message's BODYSTRUCTURE
+type AttachmentInfo = imapext.Attachment
+
 type EmailSummary struct {
 	TotalEmails int           `json:"total_emails"`
 	UnreadCount int           `json:"unread_count"`
@@ -132,56 +247,187 @@ type SenderCount struct {
 }
 
 type EmailServer struct {
+	configsMu      sync.RWMutex // guards configs and defaultAccount
 	configs        []EmailConfig
 	defaultAccount string
+	configPath     string // email_config.json, written by add_account and remove_account
+	downloadsDir   string
+	bodyLimit      int // Bytes of each body get_emails and get_email_body return; 0 means no limit
+	batchWorkers   int // Emails classify_emails and recalc_priorities process at a time
+	db             *storage.Database
+	syncer         *emailsync.Engine
+	dispatcher     *scheduler.Dispatcher
+	digestJob      *scheduler.Job
+	ignoredJob     *scheduler.Job
+	notifier       *notifications.Notifier
+	aiConfig       *config.AIConfig
+	llm            ai.Provider // nil when no provider is configured
+	summarizer     *ai.Summarizer
+	classifier     *ai.Classifier
+	rulesMu        sync.RWMutex // guards the VIPs of rules, which tools edit
+	rules          *config.Rules
+	rulesPath      string // empty when the rules file could not be read
+	replies        *ai.ReplyGenerator
+	actions        *ai.ActionExtractor
+	invoices       *ai.InvoiceExtractor
+	tools          *ToolRegistry
+	callTimeout    time.Duration // Upper bound of a tools/call; 0 means none
+	auditRetention time.Duration // Age at which audit log entries are pruned; 0 keeps them
+
+	confirmDestructive bool          // Destructive tools wait for confirm_action
+	confirmTTL         time.Duration // How long a confirmation token stays valid
+	pendingMu          sync.Mutex
+	pending            map[string]*pendingAction // Calls waiting for confirm_action by token
+
+	limitsMu sync.Mutex
+	limits   map[string]*accountLimits // Rate limiters by account ID
+
+	graphMu      sync.Mutex
+	graphClients map[string]*graph.Client // Microsoft Graph clients by account ID
+
+	capsMu sync.Mutex
+	caps   map[string]*accountCapabilities // Server capabilities by account ID, see capabilities.go
+
+	healthMu sync.Mutex
+	health   map[healthKey]*LoginHealth // Login state by account and server, see health.go
+
+	callsMu sync.Mutex
+	calls   map[string]context.CancelFunc // Started tool calls and resource reads by request ID
+
+	clientMu sync.Mutex
+	client   string // clientInfo name and version from initialize
+
+	auditMu     sync.Mutex
+	auditPruned time.Time // Last time old audit log entries were deleted
+
+	outMu         sync.Mutex // serializes writes to stdout
+	subsMu        sync.Mutex
+	subscriptions map[string]bool // subscribed resource URIs
 }
 
 func NewEmailServer() *EmailServer {
 	// Load .env file first
 	loadEnv()
 
-	var configs []EmailConfig
-	defaultAccount := ""
-
-	// Try to load from config file first
-	if configData, err := os.ReadFile("email_config.json"); err == nil {
-		// Load from JSON config file
-		var configMap map[string]EmailConfig
-		if err := json.Unmarshal(configData, &configMap); err == nil {
-			for id, config := range configMap {
-				config.ID = id
-				configs = append(configs, config)
-				if defaultAccount == "" {
-					defaultAccount = id
-				}
-			}
-		}
+	configPath := accountsPath()
+	configs, defaultAccount, err := loadAccounts(configPath)
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	// If no configs loaded from file, use environment variables for backward compatibility
-	if len(configs) == 0 {
-		config := EmailConfig{
-			ID:          "default",
-			IMAPHost:    getEnv("IMAP_HOST", "imap.gmail.com"),
-			IMAPPort:    getEnvInt("IMAP_PORT", 993),
-			SMTPHost:    getEnv("SMTP_HOST", "smtp.gmail.com"),
-			SMTPPort:    getEnvInt("SMTP_PORT", 587),
-			Username:    getEnv("EMAIL_USERNAME", ""),
-			Password:    getEnv("EMAIL_PASSWORD", ""),
-			UseStartTLS: getEnv("USE_STARTTLS", "true") == "true",
-		}
+	es := &EmailServer{
+		configs:            configs,
+		defaultAccount:     defaultAccount,
+		configPath:         configPath,
+		downloadsDir:       downloadsDir(),
+		bodyLimit:          getEnvInt("BODY_MAX_KB", 256) * 1024,
+		batchWorkers:       getEnvInt("BATCH_WORKERS", 4),
+		callTimeout:        time.Duration(getEnvInt("TOOL_TIMEOUT_SECONDS", 300)) * time.Second,
+		auditRetention:     time.Duration(getEnvInt("AUDIT_RETENTION_DAYS", 90)) * 24 * time.Hour,
+		confirmDestructive: getEnv("CONFIRM_DESTRUCTIVE", "false") == "true",
+		confirmTTL:         time.Duration(getEnvInt("CONFIRM_TOKEN_MINUTES", 10)) * time.Minute,
+		pending:            make(map[string]*pendingAction),
+		calls:              make(map[string]context.CancelFunc),
+		limits:             make(map[string]*accountLimits),
+		graphClients:       make(map[string]*graph.Client),
+		caps:               make(map[string]*accountCapabilities),
+		health:             make(map[healthKey]*LoginHealth),
+		subscriptions:      make(map[string]bool),
+	}
+	es.tools = es.registerTools()
+	if err := es.tools.Restrict(getEnvList("TOOLS_ALLOW"), getEnvList("TOOLS_DENY")); err != nil {
+		log.Fatal(err)
+	}
+	return es
+}
 
-		if config.Username == "" || config.Password == "" {
-			log.Fatal("EMAIL_USERNAME and EMAIL_PASSWORD environment variables are required")
-		}
+// initSync opens the local database and starts the background sync and
+// scheduled sending loops.
+// The server keeps working without the database; only sync tools fail.
+func (es *EmailServer) initSync() {
+	es.initDatabase()
+	if es.db == nil {
+		return
+	}
+
+	dispatchInterval := time.Duration(getEnvInt("SCHEDULER_INTERVAL_SECONDS", 30)) * time.Second
+	es.dispatcher = scheduler.NewDispatcher(es.db, es.sendScheduled, dispatchInterval, getEnvInt("SCHEDULER_MAX_ATTEMPTS", 5))
+	es.dispatcher.SetWaker(es.wakeScheduled)
+	es.dispatcher.Start()
+}
+
+// initDatabase opens the local database and sets up the sync engine without
+// starting it, leaving es.db nil when the database cannot be opened
+func (es *EmailServer) initDatabase() {
+	db, err := openDatabase()
+	if err != nil {
+		log.Printf("Local database unavailable, sync disabled: %v", err)
+		return
+	}
+	es.db = db
+	es.pruneAudit(true)
+
+	var accounts []string
+	for _, config := range es.accounts() {
+		accounts = append(accounts, config.ID)
+	}
+
+	interval := time.Duration(getEnvInt("SYNC_INTERVAL_MINUTES", 0)) * time.Minute
+	es.syncer = emailsync.NewEngine(db, es.connectIMAP, accounts, interval, getEnvInt("SYNC_INITIAL_LIMIT", 200))
+	es.syncer.OnNewMail = es.notifyNewMail
+	es.syncer.OnNewEmails = es.processNewEmails
+	es.syncer.OnSynced = es.applyActionRules
+	es.syncer.SentFolder = es.sentFolder
+	es.syncer.Folders = es.syncFolders
+	es.syncer.Redact = es.redactBody
+	es.syncer.Delta = es.deltaSource
+	es.syncer.SnippetLength = getEnvInt("SNIPPET_LENGTH", 500)
+	es.syncer.SnippetBytes = getEnvInt("SNIPPET_FETCH_BYTES", 4096)
+	es.syncer.Push = getEnv("SYNC_PUSH", "false") == "true"
+	es.syncer.IdleRenew = time.Duration(getEnvInt("IDLE_RENEW_MINUTES", 25)) * time.Minute
+}
+
+// syncFolders returns the SyncFolders of an account, nil when it syncs INBOX
+// and the Sent folder
+func (es *EmailServer) syncFolders(accountID string) []emailsync.Folder {
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil
+	}
+	var folders []emailsync.Folder
+	for _, f := range config.SyncFolders {
+		folders = append(folders, emailsync.Folder{
+			Name:         f.Name,
+			HeadersOnly:  f.HeadersOnly,
+			MaxAge:       time.Duration(f.Days) * 24 * time.Hour,
+			InitialLimit: f.InitialLimit,
+		})
+	}
+	return folders
+}
 
-		configs = append(configs, config)
-		defaultAccount = "default"
+// isSentFolder reports whether sync stored the sent mail of an account in
+// folder: its SentFolder, else the Sent folder sync found
+func (es *EmailServer) isSentFolder(accountID, folder string) bool {
+	if config, err := es.getConfig(accountID); err == nil && config.SentFolder != "" {
+		return folder == config.SentFolder
+	}
+	if es.syncer == nil {
+		return false
 	}
+	for _, status := range es.syncer.Status() {
+		if status.AccountID == accountID {
+			return status.SentFolder != "" && folder == status.SentFolder
+		}
+	}
+	return false
+}
 
-	return &EmailServer{
-		configs:        configs,
-		defaultAccount: defaultAccount,
+// startSync starts the background sync once the classifier and notifier
+// that new mail is handed to exist
+func (es *EmailServer) startSync() {
+	if es.syncer != nil {
+		es.syncer.Start()
 	}
 }
 
@@ -201,615 +447,2517 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// getEnvList splits a comma-separated variable, dropping empty items
+func getEnvList(key string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func (es *EmailServer) getConfig(accountID string) (*EmailConfig, error) {
+	es.configsMu.RLock()
+	defer es.configsMu.RUnlock()
+
 	if accountID == "" {
 		accountID = es.defaultAccount
 	}
-	for _, config := range es.configs {
-		if config.ID == accountID {
-			return &config, nil
-		}
+	config, err := findAccount(es.configs, accountID)
+	if err != nil {
+		return nil, err
 	}
-	return nil, fmt.Errorf("account not found: %s", accountID)
+	// A copy, so callers can neither change nor race with es.configs
+	c := *config
+	return &c, nil
 }
 
-func (es *EmailServer) connectIMAP(accountID string) (*client.Client, error) {
+func (es *EmailServer) connectIMAP(ctx context.Context, accountID string) (*client.Client, error) {
 	config, err := es.getConfig(accountID)
 	if err != nil {
 		return nil, err
 	}
+	if config.isGraph() {
+		return nil, fmt.Errorf("account %s uses Microsoft Graph, which this tool does not support yet", config.ID)
+	}
+	limiter := es.accountLimits(config).imap
+	if err := limiter.Wait(ctx); err != nil {
+		return nil, fmt.Errorf("gave up waiting for the IMAP %w of account %s: %w", errRateLimit, config.ID, err)
+	}
+	if err := es.loginAllowed(config.ID, serverIMAP); err != nil {
+		return nil, err
+	}
+	c, err := dialIMAP(ctx, config, limiter)
+	es.recordLogin(ctx, config, serverIMAP, err)
+	if err != nil {
+		return nil, loginFailure(err)
+	}
+	es.recordCapabilities(config.ID, c)
+	return c, nil
+}
 
-	var c *client.Client
-	var connErr error
+// dialIMAP connects and logs in with the settings of config. The connection
+// is closed when ctx ends, aborting a command that is still running. Once
+// logged in, each command waits for a token of limiter, nil for none.
+func dialIMAP(ctx context.Context, config *EmailConfig, limiter *ai.RateLimiter) (*client.Client, error) {
+	tlsConfig, err := config.tlsConfig(config.IMAPHost)
+	if err != nil {
+		return nil, err
+	}
+	addr := net.JoinHostPort(config.IMAPHost, strconv.Itoa(config.IMAPPort))
+	conn, err := dialContext(ctx, config, addr)
+	if err != nil {
+		return nil, err
+	}
+	limited := &limitedConn{Conn: conn, ctx: ctx, accountID: config.ID}
+	conn = limited
 
 	if config.IMAPPort == 993 {
 		// Use implicit TLS for port 993
-		c, connErr = client.DialTLS(fmt.Sprintf("%s:%d", config.IMAPHost, config.IMAPPort), nil)
-	} else {
-		// Use STARTTLS for other ports
-		c, connErr = client.Dial(fmt.Sprintf("%s:%d", config.IMAPHost, config.IMAPPort))
-		if connErr != nil {
-			return nil, connErr
-		}
-		if config.UseStartTLS {
-			if connErr = c.StartTLS(&tls.Config{ServerName: config.IMAPHost}); connErr != nil {
-				return nil, connErr
-			}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
 		}
+		conn = tlsConn
+	}
+
+	c, err := client.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
 	}
+	c.Timeout = config.timeout()
 
-	if connErr != nil {
-		return nil, connErr
+	// Use STARTTLS for other ports
+	if config.IMAPPort != 993 && config.UseStartTLS {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			c.Terminate()
+			return nil, err
+		}
 	}
 
-	if connErr = c.Login(config.Username, config.Password); connErr != nil {
-		c.Close()
-		return nil, connErr
+	if err := loginIMAP(c, config); err != nil {
+		c.Terminate()
+		return nil, err
 	}
+	// The caller waited for the login's token before connecting
+	limited.limiter = limiter
 
 	return c, nil
 }
 
-func (es *EmailServer) sendEmail(accountID, to, subject, body string) error {
-	config, err := es.getConfig(accountID)
+// dialContext opens a TCP connection to addr within the account's dial
+// timeout. The connection is closed when ctx ends; closing it first releases
+// ctx.
+func dialContext(ctx context.Context, config *EmailConfig, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: config.dialTimeout()}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	return &ctxConn{Conn: conn, stop: stop}, nil
+}
 
-	auth := smtp.PlainAuth("", config.Username, config.Password, config.SMTPHost)
-
-	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s",
-		config.Username, to, subject, body)
+// ctxConn stops watching its context once closed
+type ctxConn struct {
+	net.Conn
+	stop func() bool
+}
 
-	addr := fmt.Sprintf("%s:%d", config.SMTPHost, config.SMTPPort)
-	return smtp.SendMail(addr, auth, config.Username, []string{to}, []byte(msg))
+func (c *ctxConn) Close() error {
+	c.stop()
+	return c.Conn.Close()
 }
 
-func (es *EmailServer) getEmails(accountID string, limit int) ([]EmailMessage, error) {
-	c, err := es.connectIMAP(accountID)
+// sendEmail sends msg from the account, filling in the From address and,
+// when msg has none, the Message-ID. Sent messages are recorded for
+// sent_status, so bounces can be matched to them.
+func (es *EmailServer) sendEmail(ctx context.Context, accountID string, msg *mail.OutgoingMessage) error {
+	config, err := es.getConfig(accountID)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer c.Close()
 
-	mbox, err := c.Select("INBOX", false)
-	if err != nil {
-		return nil, err
+	if len(msg.Recipients()) == 0 {
+		return fmt.Errorf("at least one recipient is required")
 	}
 
-	if mbox.Messages == 0 {
-		return []EmailMessage{}, nil
+	msg.From = config.fromAddress()
+	if msg.MessageID == "" {
+		msg.MessageID = mail.NewMessageID(config.Username)
 	}
-
-	from := uint32(1)
-	to := mbox.Messages
-	if limit > 0 && uint32(limit) < mbox.Messages {
-		from = mbox.Messages - uint32(limit) + 1
+	if len(msg.Notify) == 0 {
+		if msg.Notify, err = mail.ParseNotify(config.SMTPNotify); err != nil {
+			return err
+		}
+	}
+	data, err := msg.Build()
+	if err != nil {
+		return fmt.Errorf("failed to build message: %w", err)
 	}
 
-	seqset := new(imap.SeqSet)
-	seqset.AddRange(from, to)
-
-	messages := make(chan *imap.Message, 10)
-	done := make(chan error, 1)
-
-	// CAMBIO CRÍTICO: Incluir UID en el fetch
-	go func() {
-		done <- c.Fetch(seqset, []imap.FetchItem{imap.FetchEnvelope, imap.FetchFlags, imap.FetchRFC822Size, imap.FetchUid}, messages)
-	}()
-
-	var emails []EmailMessage
-	for msg := range messages {
-		email := EmailMessage{
-			ID:      msg.Uid, // CAMBIO: Usar UID en lugar de SeqNum
-			Subject: msg.Envelope.Subject,
-			From:    formatSingleAddress(msg.Envelope.From),
-			To:      formatAddresses(msg.Envelope.To),
-			Date:    msg.Envelope.Date,
-			Size:    msg.Size,
-			Flags:   msg.Flags,
+	if err := es.accountLimits(config).smtp.Wait(ctx); err != nil {
+		return fmt.Errorf("gave up waiting for the SMTP %w of account %s: %w", errRateLimit, config.ID, err)
+	}
+	if config.isGraph() {
+		// Graph keeps its own copy in Sent Items
+		client, err := es.graphClient(ctx, config)
+		if err != nil {
+			return err
 		}
-
-		body := fmt.Sprintf("Subject: %s\nFrom: %s\nDate: %s", // This is not the actual email body
-			msg.Envelope.Subject, email.From, msg.Envelope.Date.Format("2006-01-02 15:04:05"))
-		email.Body = body
-
-		emails = append(emails, email)
+		if err := client.SendMIME(ctx, data); err != nil {
+			return err
+		}
+		es.recordSent(config.ID, msg)
+		return nil
 	}
-
-	if err := <-done; err != nil {
-		return nil, err
+	if err := es.sendSMTP(ctx, config, msg, data); err != nil {
+		return err
 	}
+	es.recordSent(config.ID, msg)
 
-	sort.Slice(emails, func(i, j int) bool {
-		return emails[i].Date.After(emails[j].Date)
-	})
-
-	return emails, nil
+	if config.SentFolder != "" {
+		if err := es.appendMessage(ctx, config.ID, config.SentFolder, []string{imap.SeenFlag}, data); err != nil {
+			log.Printf("Sent email was not copied to %s: %v", config.SentFolder, err)
+		}
+	}
+	return nil
 }
 
-func (es *EmailServer) deleteEmail(accountID string, uid uint32) error {
-	c, err := es.connectIMAP(accountID)
-	if err != nil {
+// sendSMTP delivers data like smtp.SendMail, but with the account timeouts
+// applied to the connection and aborted when ctx ends. The delivery status
+// notifications of msg.Notify are requested when the server supports DSN.
+func (es *EmailServer) sendSMTP(ctx context.Context, config *EmailConfig, msg *mail.OutgoingMessage, data []byte) error {
+	if err := es.loginAllowed(config.ID, serverSMTP); err != nil {
 		return err
 	}
+	c, err := dialSMTP(ctx, config)
+	es.recordLogin(ctx, config, serverSMTP, err)
+	if err != nil {
+		return loginFailure(err)
+	}
 	defer c.Close()
 
-	if _, err := c.Select("INBOX", false); err != nil {
-		return err
+	notify := msg.Notify
+	if ok, _ := c.Extension("DSN"); !ok && len(notify) > 0 {
+		log.Printf("SMTP server of account %s does not support delivery status notifications; sending without", config.ID)
+		notify = nil
 	}
 
-	// CAMBIO CRÍTICO: Usar UID set en lugar de sequence set
-	uidset := new(imap.SeqSet)
-	uidset.AddNum(uid)
+	if len(notify) == 0 {
+		err = c.Mail(config.Username)
+	} else {
+		err = mailDSN(c, config.Username, msg.MessageID)
+	}
+	if err != nil {
+		return err
+	}
+	for _, rcpt := range msg.Recipients() {
+		if len(notify) == 0 {
+			err = c.Rcpt(rcpt)
+		} else {
+			err = rcptDSN(c, rcpt, notify)
+		}
+		if err != nil {
+			return err
+		}
+	}
 
-	// Marcar como eliminado usando UID STORE
-	item := imap.FormatFlagsOp(imap.AddFlags, true)
-	flags := []interface{}{imap.DeletedFlag}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
 
-	if err := c.UidStore(uidset, item, flags, nil); err != nil {
-		return fmt.Errorf("failed to mark email as deleted: %v", err)
+// mailDSN sends MAIL FROM with the DSN parameters (RFC 3461): bounces return
+// only the headers, and carry envID, the Message-ID, to match them with the
+// sent email. net/smtp has no way to add parameters to its commands.
+func mailDSN(c *smtp.Client, from, envID string) error {
+	if err := validateEnvelope(from); err != nil {
+		return err
+	}
+	params := " RET=HDRS"
+	if envID != "" {
+		params += " ENVID=" + mail.XText(envID)
+	}
+	if ok, _ := c.Extension("8BITMIME"); ok {
+		params += " BODY=8BITMIME"
 	}
+	return smtpCommand(c, 250, "MAIL FROM:<%s>%s", from, params)
+}
 
-	// Expunge para eliminar permanentemente
-	if err := c.Expunge(nil); err != nil {
-		return fmt.Errorf("failed to expunge deleted emails: %v", err)
+// rcptDSN sends RCPT TO with the notify conditions and the original
+// recipient, which bounces report even when the server rewrites the address
+func rcptDSN(c *smtp.Client, to string, notify []string) error {
+	if err := validateEnvelope(to); err != nil {
+		return err
 	}
+	return smtpCommand(c, 25, "RCPT TO:<%s> NOTIFY=%s ORCPT=rfc822;%s", to, strings.Join(notify, ","), mail.XText(to))
+}
 
+func validateEnvelope(addr string) error {
+	if strings.ContainsAny(addr, "\r\n") {
+		return fmt.Errorf("smtp: A line must not contain CR or LF")
+	}
 	return nil
 }
 
-func (es *EmailServer) summarizeEmails(emails []EmailMessage) EmailSummary {
-	unreadCount := 0
-	recentCount := 0
-	senderMap := make(map[string]int)
-
-	for _, email := range emails {
-		// Count unread (no \Seen flag)
-		seen := false
-		for _, flag := range email.Flags {
-			if flag == imap.SeenFlag {
-				seen = true
-				break
-			}
-		}
-		if !seen {
-			unreadCount++
-		}
-
-		// Count recent (within 24 hours)
-		if time.Since(email.Date) < 24*time.Hour {
-			recentCount++
-		}
-
-		// Count senders
-		senderMap[email.From]++
+// smtpCommand sends a command and reads its response, expecting code
+func smtpCommand(c *smtp.Client, code int, format string, args ...interface{}) error {
+	id, err := c.Text.Cmd(format, args...)
+	if err != nil {
+		return err
 	}
+	c.Text.StartResponse(id)
+	defer c.Text.EndResponse(id)
+	_, _, err = c.Text.ReadResponse(code)
+	return err
+}
 
-	// Get top senders
-	var topSenders []SenderCount
-	for email, count := range senderMap {
-		topSenders = append(topSenders, SenderCount{Email: email, Count: count})
+// dialSMTP connects, upgrades to TLS when offered and authenticates with the
+// settings of config. The connection is closed when ctx ends.
+func dialSMTP(ctx context.Context, config *EmailConfig) (*smtp.Client, error) {
+	c, err := openSMTP(ctx, config)
+	if err != nil {
+		return nil, err
 	}
-	sort.Slice(topSenders, func(i, j int) bool {
-		return topSenders[i].Count > topSenders[j].Count
-	})
-	if len(topSenders) > 5 {
-		topSenders = topSenders[:5]
+	if ok, _ := c.Extension("AUTH"); !ok {
+		return c, nil
 	}
+	return loginSMTP(ctx, c, config)
+}
 
-	// Generate summary text
-	summary := fmt.Sprintf("Email Summary:\n")
-	summary += fmt.Sprintf("• Total: %d emails\n", len(emails))
-	summary += fmt.Sprintf("• Unread: %d emails\n", unreadCount)
-	summary += fmt.Sprintf("• Recent (24h): %d emails\n", recentCount)
+// openSMTP connects with implicit TLS on port 465, or upgrades to TLS when
+// offered
+func openSMTP(ctx context.Context, config *EmailConfig) (*smtp.Client, error) {
+	tlsConfig, err := config.tlsConfig(config.SMTPHost)
+	if err != nil {
+		return nil, err
+	}
+	addr := net.JoinHostPort(config.SMTPHost, strconv.Itoa(config.SMTPPort))
+	conn, err := dialContext(ctx, config, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(config.timeout()))
+
+	if config.SMTPPort == 465 {
+		// Use implicit TLS (SMTPS) for port 465
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
 
-	if len(topSenders) > 0 {
-		summary += "\nTop Senders:\n"
-		for i, sender := range topSenders {
-			if i >= 3 {
-				break
-			}
-			summary += fmt.Sprintf("• %s (%d emails)\n", sender.Email, sender.Count)
+	c, err := smtp.NewClient(conn, config.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if config.SMTPHelloName != "" {
+		if err := c.Hello(config.SMTPHelloName); err != nil {
+			c.Close()
+			return nil, err
 		}
 	}
 
-	return EmailSummary{
-		TotalEmails: len(emails),
-		UnreadCount: unreadCount,
-		RecentCount: recentCount,
-		TopSenders:  topSenders,
-		Summary:     summary,
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			c.Close()
+			return nil, err
+		}
 	}
+	return c, nil
 }
 
-func formatSingleAddress(addrs []*imap.Address) string {
-	if len(addrs) == 0 {
-		return ""
+// appendMessage stores a raw message in folder
+func (es *EmailServer) appendMessage(ctx context.Context, accountID, folder string, flags []string, data []byte) error {
+	c, err := es.connectIMAP(ctx, accountID)
+	if err != nil {
+		return err
 	}
-	addr := addrs[0]
-	if addr.PersonalName != "" {
-		return fmt.Sprintf("%s <%s@%s>", addr.PersonalName, addr.MailboxName, addr.HostName)
+	defer c.Close()
+
+	if err := c.Append(folder, flags, time.Now(), bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to append to %s: %w", folder, err)
 	}
-	return fmt.Sprintf("%s@%s", addr.MailboxName, addr.HostName)
+	return nil
 }
 
-func formatAddresses(addrs []*imap.Address) []string {
-	var result []string
-	for _, addr := range addrs {
-		result = append(result, fmt.Sprintf("%s@%s", addr.MailboxName, addr.HostName))
+// parseRecipients accepts either a JSON array of addresses or a single
+// comma-separated string
+func parseRecipients(v interface{}) []string {
+	if s, ok := v.(string); ok {
+		var recipients []string
+		for _, addr := range strings.Split(s, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				recipients = append(recipients, addr)
+			}
+		}
+		return recipients
 	}
-	return result
+	return stringSlice(v)
 }
 
-// New helper function to extract readable content from raw email
-func extractEmailBody(rawEmail string) string {
-	lines := strings.Split(rawEmail, "\n")
-	inBody := false
-	var bodyLines []string
+// parseAttachments converts the send_email attachments argument into MIME
+// attachments. Each entry provides either a local file path or base64 content.
+func parseAttachments(raw interface{}) ([]mail.Attachment, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	items, ok := raw.([]interface{})
+	if !ok {
+		return nil, invalidArgument("attachments must be an array")
+	}
 
-	for _, line := range lines {
-		// Headers end with an empty line
-		if !inBody && strings.TrimSpace(line) == "" {
-			inBody = true
-			continue
+	var attachments []mail.Attachment
+	for i, item := range items {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, invalidArgument("attachment %d must be an object", i)
 		}
 
-		if inBody {
-			// Skip MIME boundaries and headers within multipart messages
-			if strings.HasPrefix(line, "--") ||
-				strings.HasPrefix(line, "Content-") ||
-				strings.HasPrefix(line, "MIME-Version") {
-				continue
+		path, _ := entry["path"].(string)
+		content, _ := entry["content"].(string)
+		filename, _ := entry["filename"].(string)
+		contentType, _ := entry["content_type"].(string)
+
+		var data []byte
+		var err error
+		switch {
+		case path != "":
+			if data, err = os.ReadFile(path); err != nil {
+				return nil, fmt.Errorf("failed to read attachment %d: %w", i, err)
 			}
-
-			// Clean up the line
-			cleanLine := strings.TrimSpace(line)
-			if cleanLine != "" {
-				bodyLines = append(bodyLines, cleanLine)
+			if filename == "" {
+				filename = filepath.Base(path)
+			}
+		case content != "":
+			if data, err = base64.StdEncoding.DecodeString(content); err != nil {
+				return nil, invalidArgument("invalid base64 content for attachment %d: %w", i, err)
 			}
+		default:
+			return nil, fmt.Errorf("attachment %d requires path or content", i)
 		}
-	}
 
-	result := strings.Join(bodyLines, "\n")
-
-	// If we got a very short result, return first 500 chars of raw email as fallback
-	if len(result) < 10 {
-		if len(rawEmail) > 500 {
-			return rawEmail[:500] + "..."
+		if filename == "" {
+			return nil, fmt.Errorf("attachment %d requires filename", i)
 		}
-		return rawEmail
+
+		attachments = append(attachments, mail.Attachment{
+			Filename:    filename,
+			ContentType: contentType,
+			Size:        len(data),
+			Data:        data,
+		})
 	}
 
-	return result
+	return attachments, nil
 }
 
-func main() {
-	server := NewEmailServer()
-	scanner := bufio.NewScanner(os.Stdin)
+// getEmails lists the most recent messages in a folder. When withBody is set
+// the full message is fetched with BODY.PEEK[] and its text/HTML parts decoded.
+func (es *EmailServer) getEmails(ctx context.Context, accountID, folder string, limit int, withBody bool) ([]EmailMessage, error) {
+	emails, _, err := es.getEmailPage(ctx, accountID, folder, limit, withBody, "", "")
+	return emails, err
+}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue
-		}
+// listedEmail is an email of a page with the header it was fetched with
+type listedEmail struct {
+	email  EmailMessage
+	header *mail.ParsedEmail
+}
 
-		var req MCPRequest
-		if err := json.Unmarshal([]byte(line), &req); err != nil {
-			log.Printf("Error parsing request: %v", err)
-			continue
+// getEmailPage returns the newest limit emails of a folder, limit being at
+// least 1, or with a cursor the ones older than the previous page, along with
+// the cursor of the next page ("" on the last one). Cursors hold a UID, so
+// mail arriving between pages does not shift them. When label is set, only
+// emails with that Gmail label are listed.
+func (es *EmailServer) getEmailPage(ctx context.Context, accountID, folder string, limit int, withBody bool, cursor, label string) ([]EmailMessage, string, error) {
+	if config, err := es.getConfig(accountID); err == nil && config.isGraph() {
+		if label != "" {
+			return nil, "", errNoLabels(config.ID)
 		}
+		return es.graphEmailPage(ctx, config, folder, limit, withBody, cursor)
+	}
+	c, err := es.connectIMAP(ctx, accountID)
+	if err != nil {
+		return nil, "", err
+	}
+	defer c.Close()
+	redact := es.redaction(accountID)
 
-		if req.JSONRPC != "2.0" {
-			log.Printf("Invalid JSON-RPC version: %s", req.JSONRPC)
-			continue
-		}
+	labels := imapext.SupportsLabels(c)
+	if label != "" && !labels {
+		return nil, "", errNoLabels(accountID)
+	}
 
-		var resp MCPResponse
-		resp.ID = req.ID
-		resp.JSONRPC = "2.0"
+	mbox, err := selectFolder(c, folder, false)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if mbox.Messages == 0 {
+		return []EmailMessage{}, "", nil
+	}
+
+	seqset := new(imap.SeqSet)
+	var more bool
+	byUID := cursor != "" || label != ""
+	if !byUID {
+		from := uint32(1)
+		to := mbox.Messages
+		if uint32(limit) < mbox.Messages {
+			from = mbox.Messages - uint32(limit) + 1
+		}
+		seqset.AddRange(from, to)
+		more = from > 1
+	} else {
+		criteria := imap.NewSearchCriteria()
+		if cursor != "" {
+			values, err := decodeCursor(cursor, "emails", 2)
+			if err != nil {
+				return nil, "", err
+			}
+			if uint32(values[0]) != mbox.UidValidity {
+				return nil, "", fmt.Errorf("cursor expired: the folder was renumbered on the server, list it again without cursor")
+			}
+			before := uint32(values[1])
+			if before <= 1 {
+				return []EmailMessage{}, "", nil
+			}
+			criteria.Uid = new(imap.SeqSet)
+			criteria.Uid.AddRange(1, before-1)
+		}
+
+		var uids []uint32
+		if label != "" {
+			uids, err = imapext.UidSearchLabel(c, label, criteria)
+		} else {
+			uids, err = imapext.UidSearch(c, criteria)
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+		if len(uids) > limit {
+			uids = uids[len(uids)-limit:]
+			more = true
+		}
+		if len(uids) == 0 {
+			return []EmailMessage{}, "", nil
+		}
+		seqset.AddNum(uids...)
+	}
+
+	// CAMBIO CRÍTICO: Incluir UID en el fetch
+	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchFlags, imap.FetchRFC822Size, imap.FetchUid}
+	// The headers alone are enough for the authentication and sender
+	// phishing checks; the link checks need the body, fetched afterwards
+	section := &imap.BodySectionName{BodyPartName: imap.BodyPartName{Specifier: imap.HeaderSpecifier}, Peek: true}
+	items = append(items, section.FetchItem())
+	if labels {
+		items = append(items, imapext.LabelsItem)
+	}
+
+	messages := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
+
+	go func() {
+		if !byUID {
+			done <- c.Fetch(seqset, items, messages)
+		} else {
+			done <- c.UidFetch(seqset, items, messages)
+		}
+	}()
+
+	// The fetch is limited to the page, so it is collected whole
+	var listed []listedEmail
+	for msg := range messages {
+		item := listedEmail{email: newEmailMessage(msg)}
+		if r := msg.GetBody(section); r != nil {
+			if parsed, err := mail.ParseHeader(r); err == nil {
+				item.header = parsed
+			}
+		}
+		listed = append(listed, item)
+	}
+
+	if err := <-done; err != nil {
+		return nil, "", err
+	}
+
+	sort.Slice(listed, func(i, j int) bool {
+		return listed[i].email.Date.After(listed[j].email.Date)
+	})
+	if withBody {
+		sizes := make(map[uint32]uint32, len(listed))
+		for _, item := range listed {
+			sizes[item.email.ID] = item.email.Size
+		}
+		parsed, truncated, err := es.fetchBodies(c, sizes)
+		if err != nil {
+			return nil, "", err
+		}
+		for i := range listed {
+			email := &listed[i].email
+			if p, ok := parsed[email.ID]; ok {
+				listed[i].header = p
+				email.Truncated = truncated[email.ID]
+				email.setBodies(p, es.bodyLimit)
+				email.Attachments = attachmentMetadata(p.Attachments)
+				email.redact(redact)
+			}
+		}
+	}
+
+	emails := make([]EmailMessage, len(listed))
+	for i, item := range listed {
+		emails[i] = item.email
+		if item.header != nil {
+			emails[i].Automated = item.header.AutoKind()
+			if report := checkPhishing(item.header); report.Score > 0 {
+				emails[i].Risk = &report
+			}
+		}
+	}
+
+	next := ""
+	if more && len(emails) > 0 {
+		oldest := emails[0].ID
+		for _, email := range emails {
+			oldest = min(oldest, email.ID)
+		}
+		next = encodeCursor("emails", uint64(mbox.UidValidity), uint64(oldest))
+	}
+	if labels {
+		es.saveLabels(accountID, folder, emails)
+	}
+	return emails, next, nil
+}
+
+// getEmailBody fetches a single message by UID, including its decoded text and
+// HTML bodies.
+func (es *EmailServer) getEmailBody(ctx context.Context, accountID, folder string, uid uint32) (*EmailMessage, error) {
+	if config, err := es.getConfig(accountID); err == nil && config.isGraph() {
+		return es.graphEmailBody(ctx, config, uid)
+	}
+	c, err := es.connectIMAP(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	redact := es.redaction(accountID)
+
+	if _, err := selectFolder(c, folder, true); err != nil {
+		return nil, err
+	}
+
+	uidset := new(imap.SeqSet)
+	uidset.AddNum(uid)
+
+	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchFlags, imap.FetchRFC822Size, imap.FetchUid}
+
+	var email *EmailMessage
+	err = uidFetch(c, uidset, items, func(msg *imap.Message) {
+		e := newEmailMessage(msg)
+		email = &e
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if email == nil {
+		return nil, fmt.Errorf("email with ID %d %w", uid, errNotFound)
+	}
+
+	parsed, truncated, err := es.fetchBodies(c, map[uint32]uint32{uid: email.Size})
+	if err != nil {
+		return nil, err
+	}
+	if p, ok := parsed[uid]; ok {
+		email.Truncated = truncated[uid]
+		email.setBodies(p, es.bodyLimit)
+		email.Meetings = p.Events()
+		email.Automated = p.AutoKind()
+		email.Attachments = attachmentMetadata(p.Attachments)
+		if report := checkPhishing(p); report.Score > 0 {
+			email.Risk = &report
+		}
+		email.redact(redact)
+	} else {
+		log.Printf("Error parsing body of UID %d", uid)
+	}
+
+	return email, nil
+}
+
+// getEmailHeaders fetches and decodes only the header block of a message
+func (es *EmailServer) getEmailHeaders(ctx context.Context, accountID, folder string, uid uint32) (*mail.ParsedEmail, error) {
+	section := &imap.BodySectionName{BodyPartName: imap.BodyPartName{Specifier: imap.HeaderSpecifier}, Peek: true}
+	return es.fetchParsed(ctx, accountID, folder, uid, section)
+}
+
+// getParsedEmail fetches and decodes a whole message, headers included
+func (es *EmailServer) getParsedEmail(ctx context.Context, accountID, folder string, uid uint32) (*mail.ParsedEmail, error) {
+	return es.fetchParsed(ctx, accountID, folder, uid, &imap.BodySectionName{Peek: true})
+}
+
+func (es *EmailServer) fetchParsed(ctx context.Context, accountID, folder string, uid uint32, section *imap.BodySectionName) (*mail.ParsedEmail, error) {
+	if config, err := es.getConfig(accountID); err == nil && config.isGraph() {
+		return es.graphParsedEmail(ctx, config, uid)
+	}
+	c, err := es.connectIMAP(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	if _, err := selectFolder(c, folder, true); err != nil {
+		return nil, err
+	}
+
+	uidset := new(imap.SeqSet)
+	uidset.AddNum(uid)
+
+	messages := make(chan *imap.Message, 1)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- c.UidFetch(uidset, []imap.FetchItem{imap.FetchUid, section.FetchItem()}, messages)
+	}()
+
+	parse := mail.Parse
+	if section.Specifier == imap.HeaderSpecifier {
+		parse = mail.ParseHeader
+	}
+	var parsed *mail.ParsedEmail
+	var parseErr error
+	for msg := range messages {
+		if r := msg.GetBody(section); r != nil {
+			parsed, parseErr = parse(r)
+		}
+	}
+
+	if err := <-done; err != nil {
+		return nil, err
+	}
+	if parseErr != nil {
+		return nil, fmt.Errorf("failed to parse email: %w", parseErr)
+	}
+	if parsed == nil {
+		return nil, fmt.Errorf("email with ID %d %w", uid, errNotFound)
+	}
+
+	return parsed, nil
+}
+
+// listAttachments reads the BODYSTRUCTURE of a message and returns the parts
+// that are attachments rather than message bodies.
+func (es *EmailServer) listAttachments(ctx context.Context, accountID, folder string, uid uint32) ([]AttachmentInfo, error) {
+	c, err := es.connectIMAP(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	if _, err := selectFolder(c, folder, true); err != nil {
+		return nil, err
+	}
+
+	bs, err := fetchBodyStructure(c, uid)
+	if err != nil {
+		return nil, err
+	}
+
+	return imapext.Attachments(bs), nil
+}
+
+// downloadAttachment fetches and decodes a single attachment part
+func (es *EmailServer) downloadAttachment(ctx context.Context, accountID, folder string, uid uint32, part string) (*AttachmentInfo, []byte, error) {
+	c, err := es.connectIMAP(ctx, accountID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer c.Close()
+
+	if _, err := selectFolder(c, folder, true); err != nil {
+		return nil, nil, err
+	}
+
+	bs, err := fetchBodyStructure(c, uid)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var info *AttachmentInfo
+	for _, att := range imapext.Attachments(bs) {
+		if att.Part == part {
+			att := att
+			info = &att
+			break
+		}
+	}
+	if info == nil {
+		return nil, nil, fmt.Errorf("attachment part %s %w in email %d", part, errNotFound, uid)
+	}
+
+	path, err := parsePartPath(part)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	uidset := new(imap.SeqSet)
+	uidset.AddNum(uid)
+	section := &imap.BodySectionName{BodyPartName: imap.BodyPartName{Path: path}, Peek: true}
+
+	messages := make(chan *imap.Message, 1)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- c.UidFetch(uidset, []imap.FetchItem{section.FetchItem()}, messages)
+	}()
+
+	var data []byte
+	for msg := range messages {
+		if r := msg.GetBody(section); r != nil {
+			data, err = io.ReadAll(mail.DecodeTransfer(info.Encoding, r))
+			if err != nil {
+				log.Printf("Error decoding attachment %s of UID %d: %v", part, uid, err)
+			}
+		}
+	}
+
+	if err := <-done; err != nil {
+		return nil, nil, err
+	}
+
+	if data == nil {
+		return nil, nil, fmt.Errorf("attachment part %s of email %d is empty", part, uid)
+	}
+
+	return info, data, nil
+}
+
+// saveAttachment writes attachment data to the downloads directory, keeping
+// only the base name of the attachment so it cannot escape the directory.
+func (es *EmailServer) saveAttachment(uid uint32, info *AttachmentInfo, data []byte) (string, error) {
+	if err := os.MkdirAll(es.downloadsDir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create downloads directory: %w", err)
+	}
+
+	name := filepath.Base(filepath.Clean("/" + strings.ReplaceAll(info.Filename, "\\", "/")))
+	if name == "/" || name == "." {
+		name = fmt.Sprintf("attachment-%d-%s", uid, info.Part)
+	}
+
+	path := filepath.Join(es.downloadsDir, fmt.Sprintf("%d-%s", uid, name))
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return "", fmt.Errorf("failed to save attachment: %w", err)
+	}
+
+	return path, nil
+}
+
+func fetchBodyStructure(c *client.Client, uid uint32) (*imap.BodyStructure, error) {
+	uidset := new(imap.SeqSet)
+	uidset.AddNum(uid)
+
+	messages := make(chan *imap.Message, 1)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- c.UidFetch(uidset, []imap.FetchItem{imap.FetchBodyStructure}, messages)
+	}()
+
+	var bs *imap.BodyStructure
+	for msg := range messages {
+		bs = msg.BodyStructure
+	}
+
+	if err := <-done; err != nil {
+		return nil, err
+	}
+
+	if bs == nil {
+		return nil, fmt.Errorf("email with ID %d %w", uid, errNotFound)
+	}
+
+	return bs, nil
+}
+
+// attachmentMetadata copies attachments without their content
+func attachmentMetadata(attachments []mail.Attachment) []mail.Attachment {
+	var metadata []mail.Attachment
+	for _, attachment := range attachments {
+		attachment.Data = nil
+		metadata = append(metadata, attachment)
+	}
+	return metadata
+}
+
+func parsePartPath(part string) ([]int, error) {
+	var path []int
+	for _, field := range strings.Split(part, ".") {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 1 {
+			return nil, invalidArgument("invalid part: %s", part)
+		}
+		path = append(path, n)
+	}
+	return path, nil
+}
+
+// stringSlice converts a JSON array argument into a slice of strings,
+// ignoring non-string entries
+func stringSlice(v interface{}) []string {
+	items, _ := v.([]interface{})
+	var result []string
+	for _, item := range items {
+		if s, ok := item.(string); ok && s != "" {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func newEmailMessage(msg *imap.Message) EmailMessage {
+	from := firstAddress(msg.Envelope.From)
+	return EmailMessage{
+		ID:          msg.Uid, // CAMBIO: Usar UID en lugar de SeqNum
+		Subject:     mail.DecodeHeader(msg.Envelope.Subject),
+		From:        from.String(),
+		FromAddress: from,
+		To:          formatAddresses(msg.Envelope.To),
+		Date:        msg.Envelope.Date,
+		Size:        msg.Size,
+		Flags:       msg.Flags,
+		Labels:      imapext.Labels(msg),
+	}
+}
+
+func (es *EmailServer) deleteEmail(ctx context.Context, accountID, folder string, uid uint32) error {
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return err
+	}
+	if config.isGraph() {
+		if err := es.graphDelete(ctx, config, folder, uid); err != nil {
+			return err
+		}
+		es.forgetEmails(config.ID, folder, uid)
+		return nil
+	}
+
+	c, err := es.connectIMAP(ctx, accountID)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if config.movesToTrash(folder) {
+		err = moveMessage(c, folder, uid, config.TrashFolder)
+	} else if _, err = selectFolder(c, folder, false); err == nil {
+		// CAMBIO CRÍTICO: Usar UID set en lugar de sequence set
+		uidset := new(imap.SeqSet)
+		uidset.AddNum(uid)
+
+		// With UIDPLUS only this message is expunged
+		err = imapext.UidDelete(c, uidset)
+	}
+	if err != nil {
+		return err
+	}
+	es.forgetEmails(config.ID, folder, uid)
+	return nil
+}
+
+// forgetEmails removes emails the server no longer has in folder, deleted or
+// moved elsewhere, from the local database, so local_search, priority_inbox
+// and bulk_action queries stop finding them. Failures are logged: the next
+// sync removes them too.
+func (es *EmailServer) forgetEmails(accountID, folder string, uids ...uint32) {
+	if es.db == nil {
+		return
+	}
+	if folder == "" {
+		folder = "INBOX"
+	}
+	if err := es.db.DeleteEmails(accountID, folder, uids); err != nil {
+		log.Printf("Failed to remove %d emails of %s/%s from the local database: %v", len(uids), accountID, folder, err)
+	}
+}
+
+// moveEmail moves a message between folders. imapext.UidMove issues UID MOVE
+// when the server advertises the MOVE capability and otherwise falls back to
+// UID COPY + UID STORE \Deleted + UID EXPUNGE (EXPUNGE without UIDPLUS).
+func (es *EmailServer) moveEmail(ctx context.Context, accountID, folder string, uid uint32, destination string) error {
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return err
+	}
+	if config.isGraph() {
+		err = es.graphMove(ctx, config, uid, destination)
+	} else {
+		var c *client.Client
+		if c, err = es.connectIMAP(ctx, accountID); err != nil {
+			return err
+		}
+		defer c.Close()
+		err = moveMessage(c, folder, uid, destination)
+	}
+	if err != nil {
+		return err
+	}
+	es.forgetEmails(config.ID, folder, uid)
+	return nil
+}
+
+// archiveEmail moves a message to the account's archive folder, discovered
+// through the SPECIAL-USE \Archive (or Gmail's \All) attribute. The chosen
+// folder is returned.
+func (es *EmailServer) archiveEmail(ctx context.Context, accountID, folder string, uid uint32) (string, error) {
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return "", err
+	}
+	if config.isGraph() {
+		archive := config.ArchiveFolder
+		if archive == "" {
+			archive = "Archive"
+		}
+		if err := es.graphMove(ctx, config, uid, archive); err != nil {
+			return "", err
+		}
+		es.forgetEmails(config.ID, folder, uid)
+		return archive, nil
+	}
+
+	c, err := es.connectIMAP(ctx, accountID)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	archive, err := archiveFolder(c, config)
+	if err != nil {
+		return "", err
+	}
+	if err := moveMessage(c, folder, uid, archive); err != nil {
+		return "", err
+	}
+	es.forgetEmails(config.ID, folder, uid)
+	return archive, nil
+}
+
+// archiveFolder returns the account's ArchiveFolder, else the folder with the
+// \Archive (or Gmail's \All) attribute, else ARCHIVE_FOLDER
+func archiveFolder(c *client.Client, config *EmailConfig) (string, error) {
+	if config.ArchiveFolder != "" {
+		return config.ArchiveFolder, nil
+	}
+
+	archive, err := findSpecialFolder(c, imap.ArchiveAttr, imap.AllAttr)
+	if err != nil {
+		return "", err
+	}
+	if archive == "" {
+		archive = getEnv("ARCHIVE_FOLDER", "Archive")
+	}
+	return archive, nil
+}
+
+func moveMessage(c *client.Client, folder string, uid uint32, destination string) error {
+	if destination == "" {
+		return fmt.Errorf("destination folder is required")
+	}
+
+	if _, err := selectFolder(c, folder, false); err != nil {
+		return err
+	}
+
+	uidset := new(imap.SeqSet)
+	uidset.AddNum(uid)
+
+	if err := imapext.UidMove(c, uidset, destination); err != nil {
+		return fmt.Errorf("failed to move email to %s: %w", destination, err)
+	}
+
+	return nil
+}
+
+// selectFolder selects a mailbox, defaulting to INBOX
+func selectFolder(c *client.Client, folder string, readOnly bool) (*imap.MailboxStatus, error) {
+	if folder == "" {
+		folder = "INBOX"
+	}
+	mbox, err := c.Select(folder, readOnly)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select folder %s: %w", folder, err)
+	}
+	return mbox, nil
+}
+
+// FolderInfo describes a mailbox as returned by list_folders
+type FolderInfo struct {
+	Name       string   `json:"name"`
+	Attributes []string `json:"attributes,omitempty"`
+	Messages   uint32   `json:"messages"`
+	Unseen     uint32   `json:"unseen"`
+}
+
+// listFolders returns every mailbox of the account with its message counts.
+// Folders that cannot be selected (\Noselect) are listed without counts.
+func (es *EmailServer) listFolders(ctx context.Context, accountID string) ([]FolderInfo, error) {
+	if config, err := es.getConfig(accountID); err == nil && config.isGraph() {
+		return es.graphFolders(ctx, config)
+	}
+	c, err := es.connectIMAP(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	mailboxes := make(chan *imap.MailboxInfo, 10)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- c.List("", "*", mailboxes)
+	}()
+
+	var folders []FolderInfo
+	for mbox := range mailboxes {
+		folders = append(folders, FolderInfo{Name: mbox.Name, Attributes: mbox.Attributes})
+	}
+
+	if err := <-done; err != nil {
+		return nil, err
+	}
+
+	for i, folder := range folders {
+		selectable := true
+		for _, attr := range folder.Attributes {
+			if attr == imap.NoSelectAttr {
+				selectable = false
+			}
+		}
+		if !selectable {
+			continue
+		}
+
+		status, err := c.Status(folder.Name, []imap.StatusItem{imap.StatusMessages, imap.StatusUnseen})
+		if err != nil {
+			log.Printf("Error getting status of folder %s: %v", folder.Name, err)
+			continue
+		}
+		folders[i].Messages = status.Messages
+		folders[i].Unseen = status.Unseen
+	}
+
+	sort.Slice(folders, func(i, j int) bool {
+		return folders[i].Name < folders[j].Name
+	})
+
+	return folders, nil
+}
+
+func (es *EmailServer) createFolder(ctx context.Context, accountID, name string) error {
+	c, err := es.connectIMAP(ctx, accountID)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	return c.Create(name)
+}
+
+func (es *EmailServer) renameFolder(ctx context.Context, accountID, name, newName string) error {
+	c, err := es.connectIMAP(ctx, accountID)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	return c.Rename(name, newName)
+}
+
+func (es *EmailServer) deleteFolder(ctx context.Context, accountID, name string) error {
+	if strings.EqualFold(name, "INBOX") {
+		return fmt.Errorf("INBOX cannot be deleted")
+	}
+
+	c, err := es.connectIMAP(ctx, accountID)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	return c.Delete(name)
+}
+
+// findSpecialFolder returns the first mailbox carrying one of the given
+// SPECIAL-USE attributes (RFC 6154), tried in order, or "" if none does.
+func findSpecialFolder(c *client.Client, attrs ...string) (string, error) {
+	mailboxes := make(chan *imap.MailboxInfo, 10)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- c.List("", "*", mailboxes)
+	}()
+
+	found := make(map[string]string)
+	for mbox := range mailboxes {
+		for _, attr := range mbox.Attributes {
+			if _, ok := found[attr]; !ok {
+				found[attr] = mbox.Name
+			}
+		}
+	}
+
+	if err := <-done; err != nil {
+		return "", err
+	}
+
+	for _, attr := range attrs {
+		if name, ok := found[attr]; ok {
+			return name, nil
+		}
+	}
+	return "", nil
+}
+
+// setFlags adds and removes flags on a message via UID STORE. Flag names may be
+// given as system flags (\Seen) or their short forms (seen); anything else is
+// stored as a custom keyword. \Deleted cannot be added: on servers without
+// UIDPLUS the next expunge would remove the message with the one deleted.
+func (es *EmailServer) setFlags(ctx context.Context, accountID, folder string, uid uint32, add, remove []string) error {
+	for _, flag := range add {
+		if normalizeFlag(flag) == imap.DeletedFlag {
+			return invalidArgument("flag %s cannot be added, use delete_email to delete the email", flag)
+		}
+	}
+	if config, err := es.getConfig(accountID); err == nil && config.isGraph() {
+		return es.graphSetFlags(ctx, config, uid, add, remove)
+	}
+	c, err := es.connectIMAP(ctx, accountID)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if _, err := selectFolder(c, folder, false); err != nil {
+		return err
+	}
+
+	uidset := new(imap.SeqSet)
+	uidset.AddNum(uid)
+
+	for _, change := range []struct {
+		op    imap.FlagsOp
+		flags []string
+	}{{imap.AddFlags, add}, {imap.RemoveFlags, remove}} {
+		if len(change.flags) == 0 {
+			continue
+		}
+
+		var flags []interface{}
+		for _, flag := range change.flags {
+			flags = append(flags, normalizeFlag(flag))
+		}
+
+		item := imap.FormatFlagsOp(change.op, true)
+		if err := c.UidStore(uidset, item, flags, nil); err != nil {
+			return fmt.Errorf("failed to update flags: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// normalizeFlag maps short flag names to IMAP system flags
+func normalizeFlag(flag string) string {
+	switch strings.ToLower(strings.TrimPrefix(flag, "\\")) {
+	case "seen", "read":
+		return imap.SeenFlag
+	case "flagged", "starred":
+		return imap.FlaggedFlag
+	case "answered":
+		return imap.AnsweredFlag
+	case "draft":
+		return imap.DraftFlag
+	case "deleted":
+		return imap.DeletedFlag
+	}
+	return flag
+}
+
+func (es *EmailServer) summarizeEmails(emails []EmailMessage) EmailSummary {
+	unreadCount := 0
+	recentCount := 0
+	senderMap := make(map[string]int)
+
+	for _, email := range emails {
+		// Count unread (no \Seen flag)
+		seen := false
+		for _, flag := range email.Flags {
+			if flag == imap.SeenFlag {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			unreadCount++
+		}
+
+		// Count recent (within 24 hours)
+		if time.Since(email.Date) < 24*time.Hour {
+			recentCount++
+		}
+
+		// Count senders by address, whatever name they used
+		sender := strings.ToLower(email.FromAddress.Address)
+		if sender == "" {
+			sender = email.From
+		}
+		senderMap[sender]++
+	}
+
+	// Get top senders
+	var topSenders []SenderCount
+	for email, count := range senderMap {
+		topSenders = append(topSenders, SenderCount{Email: email, Count: count})
+	}
+	sort.Slice(topSenders, func(i, j int) bool {
+		return topSenders[i].Count > topSenders[j].Count
+	})
+	if len(topSenders) > 5 {
+		topSenders = topSenders[:5]
+	}
+
+	// Generate summary text
+	summary := fmt.Sprintf("Email Summary:\n")
+	summary += fmt.Sprintf("• Total: %d emails\n", len(emails))
+	summary += fmt.Sprintf("• Unread: %d emails\n", unreadCount)
+	summary += fmt.Sprintf("• Recent (24h): %d emails\n", recentCount)
+
+	if len(topSenders) > 0 {
+		summary += "\nTop Senders:\n"
+		for i, sender := range topSenders {
+			if i >= 3 {
+				break
+			}
+			summary += fmt.Sprintf("• %s (%d emails)\n", sender.Email, sender.Count)
+		}
+	}
+
+	return EmailSummary{
+		TotalEmails: len(emails),
+		UnreadCount: unreadCount,
+		RecentCount: recentCount,
+		TopSenders:  topSenders,
+		Summary:     summary,
+	}
+}
+
+// firstAddress returns the first envelope address, e.g. the sender
+func firstAddress(addrs []*imap.Address) mail.Address {
+	if len(addrs) == 0 {
+		return mail.Address{}
+	}
+	return mail.Address{Name: mail.DecodeHeader(addrs[0].PersonalName), Address: addrs[0].Address()}
+}
+
+func formatAddresses(addrs []*imap.Address) []string {
+	var result []string
+	for _, addr := range addrs {
+		result = append(result, addr.Address())
+	}
+	return result
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1:])
+		return
+	}
+
+	server := NewEmailServer()
+	server.initSync()
+	server.initAI()
+	server.initNotifications()
+	server.initDigest()
+	server.initIgnoredRollup()
+	server.startSync()
+
+	// An encrypted database only reaches the disk when saved, so it is
+	// closed on the way out, whether stdin ends or the server is stopped
+	var closeOnce sync.Once
+	closeDatabase := func() {
+		closeOnce.Do(func() {
+			if server.db == nil {
+				return
+			}
+			if err := server.db.Close(); err != nil {
+				log.Printf("Error closing database: %v", err)
+			}
+		})
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-stop
+		log.Printf("Received %v, closing the database", sig)
+		closeDatabase()
+		os.Exit(0)
+	}()
+
+	// Messages are read in the background so that a client disconnecting, or
+	// cancelling a request, takes effect while a tool call is still running
+	ctx, disconnect := context.WithCancel(context.Background())
+	defer disconnect()
+	// Buffered so the reader keeps seeing cancellations and EOF while every
+	// slot is taken and other requests wait
+	lines := make(chan []byte, 64)
+	go server.readMessages(os.Stdin, lines, disconnect)
+
+	newRequestDispatcher(ctx, server).serve(lines)
+	closeDatabase()
+}
+
+// runCommand runs the maintenance command named by the first argument
+// instead of serving MCP on stdin
+func runCommand(args []string) {
+	loadEnv()
+	var err error
+	switch args[0] {
+	case "encrypt-database":
+		err = encryptDatabase()
+	case "backup":
+		err = backupCommand(args[1:])
+	case "restore":
+		err = restoreCommand(args[1:])
+	case "graph-login":
+		err = graphLoginCommand(args[1:])
+	case "doctor", "--doctor":
+		err = doctorCommand(args[1:])
+	case "emails":
+		err = emailsCommand(args[1:])
+	case "setup", "--setup":
+		err = setupCommand(args[1:])
+	default:
+		err = fmt.Errorf("unknown command %q (available: encrypt-database, backup, restore, graph-login, doctor, emails, setup)", args[0])
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// readMessages reads JSON-RPC messages from r into lines until the client
+// disconnects, then calls disconnect and closes lines. Cancellation
+// notifications are applied as soon as they are read.
+func (es *EmailServer) readMessages(r io.Reader, lines chan<- []byte, disconnect context.CancelFunc) {
+	defer close(lines)
+	defer disconnect()
+
+	// Messages are newline-delimited; bufio.Scanner would silently stop at
+	// its 64KB token limit, so lines are read with an explicit size cap
+	reader := bufio.NewReader(r)
+	maxSize := getEnvInt("MAX_MESSAGE_SIZE", 10*1024*1024)
+
+	for {
+		line, err := readMessage(reader, maxSize)
+		if err == errMessageTooLarge {
+			es.writeError(nil, -32600, fmt.Sprintf("Invalid Request: message exceeds %d bytes", maxSize))
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Error reading stdin: %v", err)
+			}
+			return
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		es.applyCancellations(line)
+		lines <- line
+	}
+}
+
+// applyCancellations cancels the running requests named by the
+// notifications/cancelled messages of line, a message or a batch
+func (es *EmailServer) applyCancellations(line []byte) {
+	type cancelled struct {
+		Method string `json:"method"`
+		Params struct {
+			RequestID interface{} `json:"requestId"`
+		} `json:"params"`
+	}
+
+	var messages []cancelled
+	if line[0] == '[' {
+		json.Unmarshal(line, &messages)
+	} else {
+		var msg cancelled
+		if json.Unmarshal(line, &msg) == nil {
+			messages = append(messages, msg)
+		}
+	}
+
+	for _, msg := range messages {
+		if msg.Method != "notifications/cancelled" || msg.Params.RequestID == nil {
+			continue
+		}
+		es.callsMu.Lock()
+		if cancel, ok := es.calls[requestKey(msg.Params.RequestID)]; ok {
+			cancel()
+		}
+		es.callsMu.Unlock()
+	}
+}
+
+// trackRequest lets notifications/cancelled for the request of key call
+// cancel, until untrackRequest
+func (es *EmailServer) trackRequest(key string, cancel context.CancelFunc) {
+	es.callsMu.Lock()
+	es.calls[key] = cancel
+	es.callsMu.Unlock()
+}
+
+func (es *EmailServer) untrackRequest(key string) {
+	es.callsMu.Lock()
+	delete(es.calls, key)
+	es.callsMu.Unlock()
+}
+
+// callTool runs a tools/call request with a context that ends when the call
+// times out, the client cancels it or disconnects
+func (es *EmailServer) callTool(ctx context.Context, params ToolCallParams) (interface{}, error) {
+	ctx, cancel := es.requestContext(ctx)
+	defer cancel()
+
+	start := time.Now()
+	result, err := es.tools.Call(ctx, params)
+	switch ctx.Err() {
+	case context.Canceled:
+		result, err = nil, errRequestCancelled
+	case context.DeadlineExceeded:
+		if err == nil {
+			err = ctx.Err()
+		}
+		result, err = nil, fmt.Errorf("%s timed out after %v (TOOL_TIMEOUT_SECONDS): %w", params.Name, es.callTimeout, err)
+	}
+	if es.tools.Mutating(params.Name) {
+		es.auditToolCall(params, result, start, err)
+	}
+	return result, err
+}
+
+// requestContext bounds a request by TOOL_TIMEOUT_SECONDS. Cancelling it
+// closes the IMAP and SMTP connections the request opened.
+func (es *EmailServer) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if es.callTimeout > 0 {
+		return context.WithTimeout(ctx, es.callTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// errRequestCancelled is returned by callTool when the client cancelled the
+// request or disconnected
+var errRequestCancelled = errors.New("request cancelled")
+
+var errMessageTooLarge = errors.New("message too large")
+
+// readMessage reads one newline-terminated message. Lines longer than
+// maxSize are consumed and discarded so the stream stays in sync.
+func readMessage(r *bufio.Reader, maxSize int) ([]byte, error) {
+	var line []byte
+	tooLarge := false
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if !tooLarge {
+			if maxSize > 0 && len(line)+len(chunk) > maxSize {
+				tooLarge = true
+				line = nil
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if tooLarge {
+			return nil, errMessageTooLarge
+		}
+		if err == io.EOF && len(line) > 0 {
+			return line, nil
+		}
+		return line, err
+	}
+}
+
+// handleMessage processes a single JSON-RPC message and returns the response
+// to send, or nil for notifications
+func (es *EmailServer) handleMessage(ctx context.Context, raw []byte) *MCPResponse {
+	var req MCPRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return &MCPResponse{JSONRPC: "2.0", Error: &MCPError{Code: -32700, Message: fmt.Sprintf("Parse error: %v", err)}}
+	}
+
+	// Notifications (requests without ID) never get a response
+	if req.ID == nil {
+		return nil
+	}
+
+	resp := &MCPResponse{ID: req.ID, JSONRPC: "2.0"}
+	if req.JSONRPC != "2.0" {
+		resp.Error = &MCPError{Code: -32600, Message: "Invalid Request: jsonrpc must be \"2.0\""}
+		return resp
+	}
+
+	switch req.Method {
+	case "initialize":
+		params, _ := req.Params.(map[string]interface{})
+		es.setClient(params)
+		requested, _ := params["protocolVersion"].(string)
+		resp.Result = map[string]interface{}{
+			"protocolVersion": negotiateVersion(requested),
+			"capabilities": map[string]interface{}{
+				"tools": map[string]interface{}{
+					"listChanged": true,
+				},
+				"resources": map[string]interface{}{
+					"subscribe":   true,
+					"listChanged": true,
+				},
+			},
+			"serverInfo": ServerInfo{
+				Name:    "email-server",
+				Version: "1.0.0",
+			},
+		}
+
+	case "tools/list":
+		resp.Result = map[string]interface{}{
+			"tools": es.listTools(),
+		}
+
+	case "tools/call":
+		if req.Params == nil {
+			resp.Error = &MCPError{Code: -32602, Message: "Invalid params: params is required"}
+		} else {
+			params, ok := req.Params.(map[string]interface{})
+			if !ok {
+				resp.Error = &MCPError{Code: -32602, Message: "Invalid params: expected object"}
+			} else {
+				toolParams := ToolCallParams{}
+				if name, ok := params["name"].(string); ok {
+					toolParams.Name = name
+				} else {
+					resp.Error = &MCPError{Code: -32602, Message: "Invalid params: name is required"}
+				}
+
+				if resp.Error == nil {
+					if args, ok := params["arguments"].(map[string]interface{}); ok {
+						toolParams.Arguments = args
+					} else {
+						toolParams.Arguments = make(map[string]interface{})
+					}
+
+					result, err := es.callTool(ctx, toolParams)
+					if err == errRequestCancelled {
+						// Cancelled requests get no response
+						return nil
+					}
+					var invalid *InvalidParamsError
+					var notFound *ToolNotFoundError
+					switch {
+					case errors.As(err, &invalid):
+						resp.Error = &MCPError{Code: -32602, Message: err.Error(), Data: invalid}
+					case errors.As(err, &notFound):
+						resp.Error = &MCPError{Code: -32602, Message: err.Error(), Data: notFound.Data()}
+					case err != nil:
+						// The tool ran and failed: the model sees why
+						resp.Result = es.toolErrorResult(err, toolParams.Arguments)
+					default:
+						resp.Result = result
+					}
+				}
+			}
+		}
+
+	case "resources/list", "resources/templates/list", "resources/read", "resources/subscribe", "resources/unsubscribe":
+		params, _ := req.Params.(map[string]interface{})
+		ctx, cancel := es.requestContext(ctx)
+		resp.Result, resp.Error = es.handleResourceRequest(ctx, req.Method, params)
+		cancel()
+
+	default:
+		resp.Error = &MCPError{Code: -32601, Message: "Method not found"}
+	}
+
+	// CRÍTICO: Asegurar que solo uno de result o error esté presente
+	if resp.Error != nil {
+		resp.Result = nil
+	} else if resp.Result == nil {
+		// Si no hay error pero tampoco result, añadir result vacío
+		resp.Result = map[string]interface{}{}
+	}
+	return resp
+}
+
+// writeError sends an error response that is not tied to a request, e.g.
+// for input that could not be parsed
+func (es *EmailServer) writeError(id interface{}, code int, message string) {
+	resp := MCPResponse{ID: id, JSONRPC: "2.0", Error: &MCPError{Code: code, Message: message}}
+	if err := es.writeMessage(resp); err != nil {
+		log.Printf("Error marshaling response: %v", err)
+	}
+}
+
+// writeMessage writes a JSON-RPC message to stdout. Responses and background
+// notifications share stdout, so writes must not interleave.
+func (es *EmailServer) writeMessage(msg interface{}) error {
+	output, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	es.outMu.Lock()
+	defer es.outMu.Unlock()
+	fmt.Println(string(output))
+	return nil
+}
+
+func (es *EmailServer) handleSendEmail(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	subject, _ := args["subject"].(string)
+	body, _ := args["body"].(string)
+	to, cc, bcc, err := es.recipientArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(to) == 0 || subject == "" || body == "" {
+		return nil, invalidArgument("missing required parameters: to, subject, body")
+	}
+
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	attachments, err := parseAttachments(args["attachments"])
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &mail.OutgoingMessage{
+		To:          to,
+		Cc:          cc,
+		Bcc:         bcc,
+		Subject:     subject,
+		Body:        config.signed(body),
+		Attachments: attachments,
+		MessageID:   mail.NewMessageID(config.Username),
+	}
+
+	if values, ok := args["notify"].([]interface{}); ok {
+		var conditions []string
+		for _, value := range values {
+			if condition, ok := value.(string); ok {
+				conditions = append(conditions, condition)
+			}
+		}
+		if msg.Notify, err = mail.ParseNotify(conditions...); err != nil {
+			return nil, err
+		}
+	}
 
-		// Handle notifications (requests without ID)
-		if req.ID == nil {
-			// For notifications, we don't send a response
-			continue
+	var replyBy time.Time
+	if value, _ := args["expect_reply_by"].(string); value != "" {
+		if es.db == nil {
+			return nil, fmt.Errorf("follow-up tracking is not available: %w", errNoDatabase)
+		}
+		if replyBy, err = parseLaterTime("expect_reply_by", value, time.Now()); err != nil {
+			return nil, err
 		}
+	}
 
-		switch req.Method {
-		case "initialize":
-			resp.Result = map[string]interface{}{
-				"protocolVersion": "2024-11-05",
-				"capabilities": map[string]interface{}{
-					"tools": map[string]interface{}{},
-				},
-				"serverInfo": ServerInfo{
-					Name:    "email-server",
-					Version: "1.0.0",
-				},
-			}
+	err = es.sendEmail(ctx, config.ID, msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send email: %w", err)
+	}
 
-		case "tools/list":
-			resp.Result = map[string]interface{}{
-				"tools": []Tool{
-					{
-						Name:        "get_emails",
-						Description: "Get list of emails from inbox",
-						InputSchema: map[string]interface{}{
-							"type": "object",
-							"properties": map[string]interface{}{
-								"account": map[string]interface{}{
-									"type":        "string",
-									"description": "Account ID to use (optional, uses default if not specified)",
-								},
-								"limit": map[string]interface{}{
-									"type":        "number",
-									"description": "Maximum number of emails to retrieve (default: 10)",
-									"minimum":     1,
-									"maximum":     100,
-								},
-							},
-						},
-					},
-					{
-						Name:        "send_email",
-						Description: "Send an email",
-						InputSchema: map[string]interface{}{
-							"type": "object",
-							"properties": map[string]interface{}{
-								"account": map[string]interface{}{
-									"type":        "string",
-									"description": "Account ID to use for sending (optional, uses default if not specified)",
-								},
-								"to": map[string]interface{}{
-									"type":        "string",
-									"description": "Recipient email address",
-								},
-								"subject": map[string]interface{}{
-									"type":        "string",
-									"description": "Email subject",
-								},
-								"body": map[string]interface{}{
-									"type":        "string",
-									"description": "Email body content",
-								},
-							},
-							"required": []string{"to", "subject", "body"},
-						},
-					},
-					{
-						Name:        "summarize_emails",
-						Description: "Get a summary of emails in inbox",
-						InputSchema: map[string]interface{}{
-							"type": "object",
-							"properties": map[string]interface{}{
-								"account": map[string]interface{}{
-									"type":        "string",
-									"description": "Account ID to use (optional, uses default if not specified)",
-								},
-								"limit": map[string]interface{}{
-									"type":        "number",
-									"description": "Number of emails to analyze (default: 50)",
-									"minimum":     1,
-									"maximum":     200,
-								},
-							},
-						},
-					},
-					{
-						Name:        "delete_email",
-						Description: "Delete an email by ID",
-						InputSchema: map[string]interface{}{
-							"type": "object",
-							"properties": map[string]interface{}{
-								"account": map[string]interface{}{
-									"type":        "string",
-									"description": "Account ID to use (optional, uses default if not specified)",
-								},
-								"id": map[string]interface{}{
-									"type":        "number",
-									"description": "Email ID to delete",
-								},
-							},
-							"required": []string{"id"},
-						},
-					},
-					{
-						Name:        "daily_summary",
-						Description: "Get daily summary of emails from all configured accounts",
-						InputSchema: map[string]interface{}{
-							"type": "object",
-							"properties": map[string]interface{}{
-								"limit": map[string]interface{}{
-									"type":        "number",
-									"description": "Number of emails to analyze per account (default: 50)",
-									"minimum":     1,
-									"maximum":     200,
-								},
-							},
-						},
-					},
-				},
-			}
+	text := fmt.Sprintf("Email sent successfully to %s", strings.Join(msg.Recipients(), ", "))
+	if len(attachments) > 0 {
+		text += fmt.Sprintf(" with %d attachment(s)", len(attachments))
+	}
+	text += fmt.Sprintf(" (Message-ID: %s)", msg.MessageID)
+
+	if !replyBy.IsZero() {
+		followup := &storage.Followup{AccountID: config.ID, MessageID: msg.MessageID, Subject: subject, To: to, ReplyBy: replyBy}
+		if err := es.db.TrackFollowup(followup); err != nil {
+			text += fmt.Sprintf("; the follow-up could not be tracked: %v", err)
+		} else {
+			text += fmt.Sprintf("; expecting a reply by %s", replyBy.Local().Format("2006-01-02 15:04 MST"))
+		}
+	}
 
-		case "tools/call":
-			if req.Params == nil {
-				resp.Error = &MCPError{Code: -32602, Message: "Invalid params: params is required"}
-			} else {
-				params, ok := req.Params.(map[string]interface{})
-				if !ok {
-					resp.Error = &MCPError{Code: -32602, Message: "Invalid params: expected object"}
-				} else {
-					toolParams := ToolCallParams{}
-					if name, ok := params["name"].(string); ok {
-						toolParams.Name = name
-					} else {
-						resp.Error = &MCPError{Code: -32602, Message: "Invalid params: name is required"}
-					}
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: text,
+		}},
+	}, nil
+}
 
-					if resp.Error == nil {
-						if args, ok := params["arguments"].(map[string]interface{}); ok {
-							toolParams.Arguments = args
-						} else {
-							toolParams.Arguments = make(map[string]interface{})
-						}
-
-						result, err := server.handleToolCall(toolParams)
-						if err != nil {
-							resp.Error = &MCPError{Code: -32603, Message: err.Error()}
-						} else {
-							resp.Result = result
-						}
-					}
-				}
-			}
+func (es *EmailServer) handleGetEmails(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	limit := 10
+	if l, ok := args["limit"].(float64); ok {
+		limit = int(l)
+	}
+	includeBody, _ := args["include_body"].(bool)
+	includeHTML, _ := args["include_html"].(bool)
 
-		default:
-			resp.Error = &MCPError{Code: -32601, Message: "Method not found"}
-		}
+	cursor, _ := args["cursor"].(string)
+	label, _ := args["label"].(string)
 
-		// Only send response for requests with ID (not notifications)
-		if req.ID != nil {
-			// CRÍTICO: Asegurar que solo uno de result o error esté presente
-			if resp.Error != nil {
-				resp.Result = nil
-			} else if resp.Result == nil {
-				// Si no hay error pero tampoco result, añadir result vacío
-				resp.Result = map[string]interface{}{}
-			}
+	emails, next, err := es.getEmailPage(ctx, accountID, folder, limit, includeBody, cursor, label)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get emails: %w", err)
+	}
 
-			output, err := json.Marshal(resp)
-			if err != nil {
-				log.Printf("Error marshaling response: %v", err)
-				continue
-			}
+	if hide, _ := args["hide_duplicates"].(bool); hide {
+		if emails, err = es.withoutDuplicates(accountID, folder, emails); err != nil {
+			return nil, fmt.Errorf("failed to hide duplicates: %w", err)
+		}
+	}
 
-			fmt.Println(string(output))
+	if !includeHTML {
+		for i := range emails {
+			emails[i].HTMLBody = ""
 		}
 	}
+
+	emailsJSON, _ := json.MarshalIndent(emails, "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: withNextCursor(fmt.Sprintf("Retrieved %d emails:\n\n%s", len(emails), string(emailsJSON)), next),
+		}},
+	}, nil
 }
 
-func (es *EmailServer) handleToolCall(params ToolCallParams) (interface{}, error) {
-	switch params.Name {
-	case "send_email":
-		accountID, _ := params.Arguments["account"].(string)
-		to, _ := params.Arguments["to"].(string)
-		subject, _ := params.Arguments["subject"].(string)
-		body, _ := params.Arguments["body"].(string)
+func (es *EmailServer) handleGetEmailBody(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	id, ok := args["id"].(float64)
+	if !ok {
+		return nil, errInvalidEmailID
+	}
+	includeHTML, _ := args["include_html"].(bool)
 
-		if to == "" || subject == "" || body == "" {
-			return nil, fmt.Errorf("missing required parameters: to, subject, body")
-		}
+	email, err := es.getEmailBody(ctx, accountID, folder, uint32(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get email body: %w", err)
+	}
+
+	if !includeHTML {
+		email.HTMLBody = ""
+	}
+
+	emailJSON, _ := json.MarshalIndent(email, "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: string(emailJSON),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleListAttachments(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	id, ok := args["id"].(float64)
+	if !ok {
+		return nil, errInvalidEmailID
+	}
+
+	attachments, err := es.listAttachments(ctx, accountID, folder, uint32(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list attachments: %w", err)
+	}
+
+	attachmentsJSON, _ := json.MarshalIndent(attachments, "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Email ID %d has %d attachment(s):\n\n%s", uint32(id), len(attachments), string(attachmentsJSON)),
+		}},
+	}, nil
+}
 
-		err := es.sendEmail(accountID, to, subject, body)
+func (es *EmailServer) handleDownloadAttachment(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	id, ok := args["id"].(float64)
+	if !ok {
+		return nil, errInvalidEmailID
+	}
+	part, _ := args["part"].(string)
+	if part == "" {
+		return nil, fmt.Errorf("%w: part", errMissingParameter)
+	}
+	save, _ := args["save"].(bool)
+
+	info, data, err := es.downloadAttachment(ctx, accountID, folder, uint32(id), part)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download attachment: %w", err)
+	}
+
+	if save {
+		path, err := es.saveAttachment(uint32(id), info, data)
 		if err != nil {
-			return nil, fmt.Errorf("failed to send email: %v", err)
+			return nil, err
 		}
-
 		return ToolResult{
 			Content: []TextContent{{
 				Type: "text",
-				Text: fmt.Sprintf("Email sent successfully to %s", to),
+				Text: fmt.Sprintf("Attachment %s (%s, %d bytes) saved to %s", info.Filename, info.ContentType, len(data), path),
 			}},
 		}, nil
+	}
 
-	case "get_emails":
-		accountID, _ := params.Arguments["account"].(string)
-		limit := 10
-		if l, ok := params.Arguments["limit"].(float64); ok {
-			limit = int(l)
-		}
+	result, _ := json.MarshalIndent(map[string]interface{}{
+		"filename":     info.Filename,
+		"content_type": info.ContentType,
+		"size":         len(data),
+		"content":      base64.StdEncoding.EncodeToString(data),
+	}, "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: string(result),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleSummarizeEmails(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	limit := 50
+	if l, ok := args["limit"].(float64); ok {
+		limit = int(l)
+	}
+
+	emails, err := es.getEmails(ctx, accountID, folder, limit, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get emails: %w", err)
+	}
+
+	summary := es.summarizeEmails(emails)
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: summary.Summary,
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleDeleteEmail(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	id, ok := args["id"].(float64)
+	if !ok {
+		return nil, errInvalidEmailID
+	}
+
+	err := es.deleteEmail(ctx, accountID, folder, uint32(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete email: %w", err)
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Email ID %d deleted successfully", uint32(id)),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleMoveEmail(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	id, ok := args["id"].(float64)
+	if !ok {
+		return nil, errInvalidEmailID
+	}
+	destination, _ := args["destination"].(string)
+	if destination == "" {
+		return nil, fmt.Errorf("%w: destination", errMissingParameter)
+	}
+
+	if err := es.moveEmail(ctx, accountID, folder, uint32(id), destination); err != nil {
+		return nil, fmt.Errorf("failed to move email: %w", err)
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Email ID %d moved to %s", uint32(id), destination),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleArchiveEmail(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	id, ok := args["id"].(float64)
+	if !ok {
+		return nil, errInvalidEmailID
+	}
+
+	archive, err := es.archiveEmail(ctx, accountID, folder, uint32(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to archive email: %w", err)
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Email ID %d archived to %s", uint32(id), archive),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleSetFlags(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	id, ok := args["id"].(float64)
+	if !ok {
+		return nil, errInvalidEmailID
+	}
+	add := stringSlice(args["add"])
+	remove := stringSlice(args["remove"])
+	if len(add) == 0 && len(remove) == 0 {
+		return nil, fmt.Errorf("at least one flag to add or remove is required")
+	}
+
+	if err := es.setFlags(ctx, accountID, folder, uint32(id), add, remove); err != nil {
+		return nil, fmt.Errorf("failed to set flags: %w", err)
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Email ID %d flags updated (added: %v, removed: %v)", uint32(id), add, remove),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleListFolders(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+
+	folders, err := es.listFolders(ctx, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list folders: %w", err)
+	}
+
+	foldersJSON, _ := json.MarshalIndent(folders, "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Found %d folders:\n\n%s", len(folders), string(foldersJSON)),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleCreateFolder(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	name, _ := args["name"].(string)
+	if name == "" {
+		return nil, fmt.Errorf("%w: name", errMissingParameter)
+	}
+
+	if err := es.createFolder(ctx, accountID, name); err != nil {
+		return nil, fmt.Errorf("failed to create folder: %w", err)
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Folder %s created", name),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleRenameFolder(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	name, _ := args["name"].(string)
+	newName, _ := args["new_name"].(string)
+	if name == "" || newName == "" {
+		return nil, invalidArgument("missing required parameters: name, new_name")
+	}
+
+	if err := es.renameFolder(ctx, accountID, name, newName); err != nil {
+		return nil, fmt.Errorf("failed to rename folder: %w", err)
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Folder %s renamed to %s", name, newName),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleDeleteFolder(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	name, _ := args["name"].(string)
+	if name == "" {
+		return nil, fmt.Errorf("%w: name", errMissingParameter)
+	}
+
+	if err := es.deleteFolder(ctx, accountID, name); err != nil {
+		return nil, fmt.Errorf("failed to delete folder: %w", err)
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Folder %s deleted", name),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleSyncNow(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.syncer == nil {
+		return nil, fmt.Errorf("sync is not available: %w", errNoDatabase)
+	}
+	accountID, _ := args["account"].(string)
 
-		emails, err := es.getEmails(accountID, limit)
+	var statuses []emailsync.Status
+	if accountID == "" {
+		statuses = es.syncer.SyncAll(ctx)
+	} else {
+		config, err := es.getConfig(accountID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get emails: %v", err)
+			return nil, err
 		}
+		status, err := es.syncer.SyncAccount(ctx, config.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sync account %s: %w", config.ID, err)
+		}
+		statuses = append(statuses, *status)
+	}
 
-		emailsJSON, _ := json.MarshalIndent(emails, "", "  ")
-		return ToolResult{
-			Content: []TextContent{{
-				Type: "text",
-				Text: fmt.Sprintf("Retrieved %d emails:\n\n%s", len(emails), string(emailsJSON)),
-			}},
-		}, nil
-
-	case "summarize_emails":
-		accountID, _ := params.Arguments["account"].(string)
-		limit := 50
-		if l, ok := params.Arguments["limit"].(float64); ok {
-			limit = int(l)
+	var lines []string
+	for _, status := range statuses {
+		if status.LastError != "" {
+			lines = append(lines, fmt.Sprintf("❌ %s: %s", status.AccountID, status.LastError))
+			continue
 		}
+		lines = append(lines, fmt.Sprintf("✅ %s: %d new emails (%d synced in total)", status.AccountID, status.NewMessages, status.TotalSynced))
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: "Sync completed:\n" + strings.Join(lines, "\n"),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleSyncStatus(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.syncer == nil {
+		return nil, fmt.Errorf("sync is not available: %w", errNoDatabase)
+	}
 
-		emails, err := es.getEmails(accountID, limit)
+	statusJSON, _ := json.MarshalIndent(es.syncer.Status(), "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: string(statusJSON),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleLocalSearch(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("local search is not available: %w", errNoDatabase)
+	}
+	query, _ := args["query"].(string)
+	if query == "" {
+		return nil, fmt.Errorf("%w: query", errMissingParameter)
+	}
+
+	filter := storage.SearchFilter{Limit: 20}
+	filter.Folder, _ = args["folder"].(string)
+	filter.From, _ = args["from"].(string)
+	filter.Starred, _ = args["starred"].(bool)
+	if accountID, _ := args["account"].(string); accountID != "" {
+		config, err := es.getConfig(accountID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get emails: %v", err)
+			return nil, err
+		}
+		filter.AccountID = config.ID
+	}
+	if l, ok := args["limit"].(float64); ok && l > 0 {
+		filter.Limit = int(l)
+	}
+	offset, err := offsetArg(args)
+	if err != nil {
+		return nil, err
+	}
+	for key, dest := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value, _ := args[key].(string); value != "" {
+			date, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				return nil, invalidArgument("invalid %s date %q, expected YYYY-MM-DD", key, value)
+			}
+			*dest = date
 		}
+	}
 
-		summary := es.summarizeEmails(emails)
-		return ToolResult{
-			Content: []TextContent{{
-				Type: "text",
-				Text: summary.Summary,
-			}},
-		}, nil
+	// One extra result tells whether there is a next page
+	limit := filter.Limit
+	filter.Limit, filter.Offset = limit+1, offset
+	results, err := es.db.SearchEmails(query, filter)
+	if err != nil {
+		return nil, err
+	}
+	more := len(results) > limit
+	if more {
+		results = results[:limit]
+	}
+
+	resultsJSON, _ := json.MarshalIndent(results, "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: withNextCursor(fmt.Sprintf("Found %d emails matching %q:\n\n%s", len(results), query, string(resultsJSON)),
+				nextOffsetCursor(offset, limit, more)),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleGetThread(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	threadID, emails, err := es.threadFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	threadJSON, _ := json.MarshalIndent(emails, "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Thread %s (%d messages):\n\n%s", threadID, len(emails), string(threadJSON)),
+		}},
+	}, nil
+}
+
+// threadFromArgs loads the thread named by thread_id, or the thread of the
+// email given by id and folder
+func (es *EmailServer) threadFromArgs(args map[string]interface{}) (string, []storage.Email, error) {
+	if es.db == nil {
+		return "", nil, fmt.Errorf("threads are not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return "", nil, err
+	}
 
-	case "delete_email":
-		accountID, _ := params.Arguments["account"].(string)
-		id, ok := params.Arguments["id"].(float64)
+	threadID, _ := args["thread_id"].(string)
+	if threadID == "" {
+		id, ok := args["id"].(float64)
 		if !ok {
-			return nil, fmt.Errorf("invalid email ID")
+			return "", nil, fmt.Errorf("%w: id or thread_id", errMissingParameter)
+		}
+		folder, _ := args["folder"].(string)
+		if folder == "" {
+			folder = "INBOX"
 		}
 
-		err := es.deleteEmail(accountID, uint32(id))
+		email, err := es.db.GetEmail(config.ID, folder, uint32(id))
 		if err != nil {
-			return nil, fmt.Errorf("failed to delete email: %v", err)
+			return "", nil, err
 		}
+		threadID = email.ThreadID
+	}
 
-		return ToolResult{
-			Content: []TextContent{{
-				Type: "text",
-				Text: fmt.Sprintf("Email ID %d deleted successfully", uint32(id)),
-			}},
-		}, nil
+	emails, err := es.db.GetThread(config.ID, threadID)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(emails) == 0 {
+		return "", nil, fmt.Errorf("thread %w: %s", errNotFound, threadID)
+	}
+	return threadID, emails, nil
+}
 
-	case "daily_summary":
-		limit := 50
-		if l, ok := params.Arguments["limit"].(float64); ok {
-			limit = int(l)
-		}
+// MCP resources expose accounts, folders and messages under email:// URIs:
+//
+//	email://{account}                 folders of an account
+//	email://{account}/{folder}        most recent messages in a folder
+//	email://{account}/{folder}/{uid}  a single message
+//
+// Folder names are path-escaped so hierarchy delimiters such as "/" stay
+// within one segment.
+type Resource struct {
+	URI         string `json:"uri"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType,omitempty"`
+}
 
-		var allSummaries []string
-		totalUnread := 0
-		totalRecent := 0
+type ResourceTemplate struct {
+	URITemplate string `json:"uriTemplate"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	MimeType    string `json:"mimeType,omitempty"`
+}
 
-		for _, config := range es.configs {
-			emails, err := es.getEmails(config.ID, limit)
-			if err != nil {
-				allSummaries = append(allSummaries, fmt.Sprintf("❌ Error getting emails for %s: %v", config.ID, err))
-				continue
-			}
+type ResourceContents struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+func (es *EmailServer) handleResourceRequest(ctx context.Context, method string, params map[string]interface{}) (interface{}, *MCPError) {
+	if method == "resources/list" {
+		return map[string]interface{}{"resources": es.listResources()}, nil
+	}
+	if method == "resources/templates/list" {
+		return map[string]interface{}{"resourceTemplates": resourceTemplates()}, nil
+	}
+
+	uri, _ := params["uri"].(string)
+	if uri == "" {
+		return nil, &MCPError{Code: -32602, Message: "Invalid params: uri is required"}
+	}
+
+	accountID, folder, uid, err := parseResourceURI(uri)
+	var config *EmailConfig
+	if err == nil {
+		config, err = es.getConfig(accountID)
+	}
+	if err != nil {
+		return nil, &MCPError{Code: -32002, Message: fmt.Sprintf("Resource not found: %v", err)}
+	}
 
-			summary := es.summarizeEmails(emails)
-			allSummaries = append(allSummaries, fmt.Sprintf("📧 **Account: %s (%s)**\n%s", config.ID, config.Username, summary.Summary))
-			totalUnread += summary.UnreadCount
-			totalRecent += summary.RecentCount
+	switch method {
+	case "resources/subscribe":
+		if uid != 0 {
+			return nil, &MCPError{Code: -32602, Message: "Invalid params: only account and folder resources can be subscribed to"}
 		}
+		es.subsMu.Lock()
+		es.subscriptions[subscriptionURI(config, folder)] = true
+		es.subsMu.Unlock()
+		return map[string]interface{}{}, nil
+
+	case "resources/unsubscribe":
+		es.subsMu.Lock()
+		delete(es.subscriptions, subscriptionURI(config, folder))
+		es.subsMu.Unlock()
+		return map[string]interface{}{}, nil
+	}
 
-		result := fmt.Sprintf("📊 **Daily Email Summary - All Accounts**\n\n")
-		result += fmt.Sprintf("📈 **Overall Stats:**\n")
-		result += fmt.Sprintf("• Total Unread: %d emails\n", totalUnread)
-		result += fmt.Sprintf("• Total Recent (24h): %d emails\n", totalRecent)
-		result += fmt.Sprintf("• Accounts monitored: %d\n\n", len(es.configs))
+	// A resource shows what its tool would, so it is hidden with the tool
+	if tool := resourceTool(folder, uid); es.tools.Disabled(tool) {
+		notFound := &ToolNotFoundError{Tool: tool, Disabled: true}
+		return nil, &MCPError{Code: -32002, Message: fmt.Sprintf("Resource not found: %v", notFound), Data: notFound.Data()}
+	}
 
-		result += strings.Join(allSummaries, "\n\n")
+	contents, err := es.readResource(ctx, uri, accountID, folder, uid)
+	if err != nil {
+		return nil, &MCPError{Code: -32603, Message: err.Error()}
+	}
+	return map[string]interface{}{"contents": []ResourceContents{contents}}, nil
+}
 
-		return ToolResult{
-			Content: []TextContent{{
-				Type: "text",
-				Text: result,
-			}},
-		}, nil
+func (es *EmailServer) listResources() []Resource {
+	var resources []Resource
+	for _, config := range es.accounts() {
+		if !es.tools.Disabled(resourceTool("", 0)) {
+			resources = append(resources, Resource{
+				URI:         resourceURI(config.ID, "", 0),
+				Name:        fmt.Sprintf("%s folders", config.ID),
+				Description: fmt.Sprintf("Folders of account %s (%s)", config.ID, config.Username),
+				MimeType:    "application/json",
+			})
+		}
+		if !es.tools.Disabled(resourceTool("INBOX", 0)) {
+			resources = append(resources, Resource{
+				URI:         resourceURI(config.ID, "INBOX", 0),
+				Name:        fmt.Sprintf("%s inbox", config.ID),
+				Description: fmt.Sprintf("Most recent emails in the inbox of %s", config.ID),
+				MimeType:    "application/json",
+			})
+		}
+	}
+	return resources
+}
+
+func resourceTemplates() []ResourceTemplate {
+	return []ResourceTemplate{
+		{
+			URITemplate: "email://{account}/{folder}",
+			Name:        "Folder",
+			Description: "Most recent emails in a folder",
+			MimeType:    "application/json",
+		},
+		{
+			URITemplate: "email://{account}/{folder}/{id}",
+			Name:        "Email",
+			Description: "Headers and decoded text body of a single email",
+			MimeType:    "text/plain",
+		},
+	}
+}
+
+func (es *EmailServer) readResource(ctx context.Context, uri, accountID, folder string, uid uint32) (ResourceContents, error) {
+	var data interface{}
+	var err error
 
+	switch {
+	case folder == "":
+		data, err = es.listFolders(ctx, accountID)
+	case uid == 0:
+		data, err = es.getEmails(ctx, accountID, folder, 20, false)
 	default:
-		return nil, fmt.Errorf("unknown tool: %s", params.Name)
+		var email *EmailMessage
+		if email, err = es.getEmailBody(ctx, accountID, folder, uid); err != nil {
+			return ResourceContents{}, err
+		}
+		text := fmt.Sprintf("From: %s\nTo: %s\nDate: %s\nSubject: %s\n\n%s",
+			email.From, strings.Join(email.To, ", "), email.Date.Format(time.RFC1123Z), email.Subject, email.Body)
+		return ResourceContents{URI: uri, MimeType: "text/plain", Text: text}, nil
+	}
+
+	if err != nil {
+		return ResourceContents{}, err
+	}
+	dataJSON, _ := json.MarshalIndent(data, "", "  ")
+	return ResourceContents{URI: uri, MimeType: "application/json", Text: string(dataJSON)}, nil
+}
+
+// resourceTool names the tool returning the content of a resource
+func resourceTool(folder string, uid uint32) string {
+	switch {
+	case folder == "":
+		return "list_folders"
+	case uid == 0:
+		return "get_emails"
+	}
+	return "get_email_body"
+}
+
+func resourceURI(accountID, folder string, uid uint32) string {
+	uri := "email://" + url.PathEscape(accountID)
+	if folder != "" {
+		uri += "/" + url.PathEscape(folder)
+	}
+	if uid != 0 {
+		uri += fmt.Sprintf("/%d", uid)
+	}
+	return uri
+}
+
+// subscriptionURI is the URI notifyNewMail sends for a subscribed account
+// or folder resource, however the client spelled it: escaped differently,
+// with a trailing slash or naming INBOX in another case
+func subscriptionURI(config *EmailConfig, folder string) string {
+	if strings.EqualFold(folder, "INBOX") {
+		folder = "INBOX"
+	}
+	return resourceURI(config.ID, folder, 0)
+}
+
+func parseResourceURI(uri string) (accountID, folder string, uid uint32, err error) {
+	rest, ok := strings.CutPrefix(uri, "email://")
+	if !ok {
+		return "", "", 0, invalidArgument("unsupported URI scheme: %s", uri)
+	}
+
+	segments := strings.Split(strings.TrimSuffix(rest, "/"), "/")
+	if len(segments) > 3 || segments[0] == "" {
+		return "", "", 0, invalidArgument("invalid email resource URI: %s", uri)
+	}
+
+	unescaped := make([]string, len(segments))
+	for i, segment := range segments {
+		if unescaped[i], err = url.PathUnescape(segment); err != nil {
+			return "", "", 0, invalidArgument("invalid email resource URI: %s", uri)
+		}
+	}
+
+	accountID = unescaped[0]
+	if len(unescaped) > 1 {
+		folder = unescaped[1]
+	}
+	if len(unescaped) > 2 {
+		id, err := strconv.ParseUint(unescaped[2], 10, 32)
+		if err != nil || id == 0 {
+			return "", "", 0, invalidArgument("invalid email ID in URI: %s", uri)
+		}
+		uid = uint32(id)
+	}
+	return accountID, folder, uid, nil
+}
+
+// notifyNewMail sends notifications/resources/updated for subscribed account
+// and folder resources after the sync engine stores new mail
+func (es *EmailServer) notifyNewMail(accountID, folder string, count int) {
+	es.subsMu.Lock()
+	var uris []string
+	for _, uri := range []string{resourceURI(accountID, "", 0), resourceURI(accountID, folder, 0)} {
+		if es.subscriptions[uri] {
+			uris = append(uris, uri)
+		}
+	}
+	es.subsMu.Unlock()
+
+	for _, uri := range uris {
+		notification := map[string]interface{}{
+			"jsonrpc": "2.0",
+			"method":  "notifications/resources/updated",
+			"params":  map[string]interface{}{"uri": uri},
+		}
+		if err := es.writeMessage(notification); err != nil {
+			log.Printf("Error sending resource update for %s: %v", uri, err)
+		}
+	}
+}
+
+// notifyResourceListChanged tells the client that accounts were added or
+// removed, so the resource list must be fetched again
+func (es *EmailServer) notifyResourceListChanged() {
+	notification := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "notifications/resources/list_changed",
+	}
+	if err := es.writeMessage(notification); err != nil {
+		log.Printf("Error sending resource list change: %v", err)
 	}
 }
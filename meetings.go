@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+
+	"email-mcp-server/mail"
+)
+
+// meetingInvite is a calendar event found in an email, with the RSVP status
+// of the account's own address
+type meetingInvite struct {
+	EmailID  uint32
+	Subject  string
+	From     string
+	Event    mail.Event
+	MyStatus string
+}
+
+// listMeetings scans the newest limit messages of a folder for calendar
+// parts. BODYSTRUCTURE is fetched first so only messages carrying one are
+// downloaded whole.
+func (es *EmailServer) listMeetings(ctx context.Context, accountID, folder string, limit int) ([]meetingInvite, error) {
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := es.connectIMAP(ctx, config.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	mbox, err := selectFolder(c, folder, true)
+	if err != nil {
+		return nil, err
+	}
+	if mbox.Messages == 0 {
+		return nil, nil
+	}
+
+	from := uint32(1)
+	if limit > 0 && uint32(limit) < mbox.Messages {
+		from = mbox.Messages - uint32(limit) + 1
+	}
+	seqset := new(imap.SeqSet)
+	seqset.AddRange(from, mbox.Messages)
+
+	messages := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Fetch(seqset, []imap.FetchItem{imap.FetchUid, imap.FetchBodyStructure}, messages)
+	}()
+
+	uidset := new(imap.SeqSet)
+	for msg := range messages {
+		if msg.BodyStructure != nil && hasCalendarPart(msg.BodyStructure) {
+			uidset.AddNum(msg.Uid)
+		}
+	}
+	if err := <-done; err != nil {
+		return nil, err
+	}
+	if uidset.Empty() {
+		return nil, nil
+	}
+
+	section := &imap.BodySectionName{Peek: true}
+	messages = make(chan *imap.Message, 10)
+	done = make(chan error, 1)
+	go func() {
+		done <- c.UidFetch(uidset, []imap.FetchItem{imap.FetchEnvelope, imap.FetchUid, section.FetchItem()}, messages)
+	}()
+
+	var invites []meetingInvite
+	for msg := range messages {
+		r := msg.GetBody(section)
+		if r == nil {
+			continue
+		}
+		parsed, err := mail.Parse(r)
+		if err != nil {
+			log.Printf("Error parsing body of UID %d: %v", msg.Uid, err)
+			continue
+		}
+		email := newEmailMessage(msg)
+		for _, event := range parsed.Events() {
+			invite := meetingInvite{EmailID: msg.Uid, Subject: email.Subject, From: email.From, Event: event}
+			if me := event.Attendee(config.Username); me != nil {
+				invite.MyStatus = me.Status
+			}
+			invites = append(invites, invite)
+		}
+	}
+	if err := <-done; err != nil {
+		return nil, err
+	}
+
+	sort.Slice(invites, func(i, j int) bool { return invites[i].Event.Start.Before(invites[j].Event.Start) })
+	return invites, nil
+}
+
+// hasCalendarPart reports whether a message has a text/calendar or .ics part
+func hasCalendarPart(bs *imap.BodyStructure) bool {
+	found := false
+	bs.Walk(func(path []int, part *imap.BodyStructure) bool {
+		filename, _ := part.Filename()
+		contentType := strings.ToLower(part.MIMEType + "/" + part.MIMESubType)
+		if contentType == "text/calendar" || contentType == "application/ics" ||
+			strings.HasSuffix(strings.ToLower(filename), ".ics") {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+func (es *EmailServer) handleListMeetings(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	limit := 50
+	if l, ok := args["limit"].(float64); ok && l > 0 {
+		limit = int(l)
+	}
+	includePast, _ := args["include_past"].(bool)
+
+	invites, err := es.listMeetings(ctx, accountID, folder, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list meetings: %w", err)
+	}
+
+	now := time.Now()
+	var b strings.Builder
+	shown := 0
+	for _, invite := range invites {
+		event := invite.Event
+		end := event.End
+		if end.IsZero() {
+			end = event.Start
+		}
+		if !includePast && end.Before(now) {
+			continue
+		}
+		shown++
+
+		title := event.Summary
+		if title == "" {
+			title = invite.Subject
+		}
+		fmt.Fprintf(&b, "📅 %s\n", title)
+		fmt.Fprintf(&b, "   When: %s\n", formatEventTime(event))
+		if event.Location != "" {
+			fmt.Fprintf(&b, "   Where: %s\n", event.Location)
+		}
+		if event.Organizer != nil {
+			fmt.Fprintf(&b, "   Organizer: %s\n", formatAttendee(*event.Organizer))
+		}
+		if len(event.Attendees) > 0 {
+			fmt.Fprintf(&b, "   Attendees: %d\n", len(event.Attendees))
+		}
+		switch {
+		case event.Method == "CANCEL" || event.Status == "CANCELLED":
+			b.WriteString("   Status: cancelled\n")
+		case invite.MyStatus != "":
+			fmt.Fprintf(&b, "   Your response: %s\n", strings.ToLower(invite.MyStatus))
+		}
+		fmt.Fprintf(&b, "   Email ID: %d\n\n", invite.EmailID)
+	}
+
+	if shown == 0 {
+		return ToolResult{
+			Content: []TextContent{{
+				Type: "text",
+				Text: fmt.Sprintf("No meeting invitations found in the last %d emails.", limit),
+			}},
+		}, nil
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Found %d meeting(s):\n\n%s", shown, strings.TrimRight(b.String(), "\n")),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleRespondToMeeting(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	id, ok := args["id"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("%w: id", errMissingParameter)
+	}
+	response, _ := args["response"].(string)
+	status, verb := "", ""
+	switch strings.ToLower(response) {
+	case "accept":
+		status, verb = mail.PartStatAccepted, "Accepted"
+	case "decline":
+		status, verb = mail.PartStatDeclined, "Declined"
+	case "tentative":
+		status, verb = mail.PartStatTentative, "Tentative"
+	default:
+		return nil, invalidArgument("response must be accept, decline or tentative")
+	}
+	comment, _ := args["comment"].(string)
+
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := es.getParsedEmail(ctx, config.ID, folder, uint32(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get email: %w", err)
+	}
+
+	var event *mail.Event
+	for _, e := range parsed.Events() {
+		if e.Method == "REQUEST" {
+			e := e
+			event = &e
+			break
+		}
+	}
+	if event == nil {
+		return nil, fmt.Errorf("email %d does not contain a meeting invitation", uint32(id))
+	}
+	if event.Organizer == nil || event.Organizer.Email == "" {
+		return nil, fmt.Errorf("the invitation has no organizer to reply to")
+	}
+
+	attendee := mail.Attendee{Name: config.DisplayName, Email: config.Username}
+	if me := event.Attendee(config.Username); me != nil {
+		attendee.Email = me.Email
+		if me.Name != "" {
+			attendee.Name = me.Name
+		}
+	}
+	calendar, err := mail.BuildCalendarReply(*event, attendee, status, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to build response: %w", err)
+	}
+
+	name := attendee.Name
+	if name == "" {
+		name = attendee.Email
+	}
+	body := fmt.Sprintf("%s has %s the invitation: %s", name, strings.ToLower(verb), event.Summary)
+	if status == mail.PartStatTentative {
+		body = fmt.Sprintf("%s has tentatively accepted the invitation: %s", name, event.Summary)
+	}
+	if comment != "" {
+		body += "\n\n" + comment
+	}
+
+	msg := &mail.OutgoingMessage{
+		To:        []string{event.Organizer.Email},
+		Subject:   verb + ": " + event.Summary,
+		Body:      body,
+		Calendar:  calendar,
+		Date:      time.Now(),
+		MessageID: mail.NewMessageID(config.Username),
+	}
+	if err := es.sendEmail(ctx, config.ID, msg); err != nil {
+		return nil, fmt.Errorf("failed to send response: %w", err)
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("%s \"%s\" (%s); the response was sent to %s", verb, event.Summary, formatEventTime(*event), event.Organizer.Email),
+		}},
+	}, nil
+}
+
+func formatEventTime(event mail.Event) string {
+	if event.AllDay {
+		start := event.Start.Format("Mon 2 Jan 2006")
+		// DTEND of an all-day event is the day after the last one
+		if last := event.End.AddDate(0, 0, -1); last.After(event.Start) {
+			return start + " – " + last.Format("Mon 2 Jan 2006") + " (all day)"
+		}
+		return start + " (all day)"
+	}
+
+	start := event.Start.Local()
+	text := start.Format("Mon 2 Jan 2006 15:04")
+	if end := event.End.Local(); !event.End.IsZero() {
+		if end.YearDay() == start.YearDay() && end.Year() == start.Year() {
+			text += "–" + end.Format("15:04")
+		} else {
+			text += " – " + end.Format("Mon 2 Jan 2006 15:04")
+		}
+	}
+	return text + " " + start.Format("MST")
+}
+
+func formatAttendee(a mail.Attendee) string {
+	if a.Name == "" {
+		return a.Email
+	}
+	return fmt.Sprintf("%s <%s>", a.Name, a.Email)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// folderMessages returns the number of messages in each folder of account
+// work
+func folderMessages(t *testing.T, es *EmailServer) map[string]uint32 {
+	t.Helper()
+	folders, err := es.listFolders(context.Background(), "work")
+	if err != nil {
+		t.Fatalf("listFolders: %v", err)
+	}
+	counts := make(map[string]uint32)
+	for _, folder := range folders {
+		counts[folder.Name] = folder.Messages
+	}
+	return counts
+}
+
+func TestMoveEmail(t *testing.T) {
+	es := newTestServer(t)
+	ctx := context.Background()
+	if err := es.createFolder(ctx, "work", "Processed"); err != nil {
+		t.Fatalf("createFolder: %v", err)
+	}
+
+	if err := es.moveEmail(ctx, "work", "INBOX", 6, ""); err == nil {
+		t.Error("moveEmail without a destination succeeded")
+	}
+	if err := es.moveEmail(ctx, "work", "INBOX", 6, "Processed"); err != nil {
+		t.Fatalf("moveEmail: %v", err)
+	}
+	if counts := folderMessages(t, es); counts["INBOX"] != 0 || counts["Processed"] != 1 {
+		t.Errorf("messages after move = %v, want INBOX 0 and Processed 1", counts)
+	}
+}
+
+func TestArchiveEmail(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("ARCHIVE_FOLDER", func(t *testing.T) {
+		es := newTestServer(t)
+		t.Setenv("ARCHIVE_FOLDER", "Old")
+		if err := es.createFolder(ctx, "work", "Old"); err != nil {
+			t.Fatalf("createFolder: %v", err)
+		}
+		archive, err := es.archiveEmail(ctx, "work", "INBOX", 6)
+		if err != nil {
+			t.Fatalf("archiveEmail: %v", err)
+		}
+		if archive != "Old" {
+			t.Errorf("archiveEmail folder = %s, want Old", archive)
+		}
+		if counts := folderMessages(t, es); counts["INBOX"] != 0 || counts["Old"] != 1 {
+			t.Errorf("messages after archive = %v, want INBOX 0 and Old 1", counts)
+		}
+	})
+
+	t.Run("account ArchiveFolder", func(t *testing.T) {
+		es := newTestServer(t)
+		t.Setenv("ARCHIVE_FOLDER", "Old")
+		es.configs[0].ArchiveFolder = "Kept"
+		if err := es.createFolder(ctx, "work", "Kept"); err != nil {
+			t.Fatalf("createFolder: %v", err)
+		}
+		archive, err := es.archiveEmail(ctx, "work", "INBOX", 6)
+		if err != nil {
+			t.Fatalf("archiveEmail: %v", err)
+		}
+		if archive != "Kept" {
+			t.Errorf("archiveEmail folder = %s, want Kept", archive)
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"email-mcp-server/storage"
+)
+
+func TestMutationsForgetLocalEmails(t *testing.T) {
+	es := newTestServer(t)
+	ctx := context.Background()
+	if err := es.db.CreateEmail(&storage.Email{AccountID: "work", Folder: "INBOX", UID: 6, Subject: "Hello"}); err != nil {
+		t.Fatalf("CreateEmail: %v", err)
+	}
+	stored := func() bool {
+		_, err := es.db.GetEmail("work", "INBOX", 6)
+		return err == nil
+	}
+
+	if _, err := es.bulkAction(ctx, "work", "INBOX", []uint32{6}, "mark_read", ""); err != nil {
+		t.Fatalf("bulkAction mark_read: %v", err)
+	}
+	if !stored() {
+		t.Fatal("mark_read removed the local email")
+	}
+
+	if err := es.moveEmail(ctx, "work", "INBOX", 6, "Missing"); err == nil {
+		t.Fatal("moveEmail to a missing folder succeeded")
+	}
+	if !stored() {
+		t.Fatal("a failed move removed the local email")
+	}
+
+	if err := es.deleteEmail(ctx, "work", "INBOX", 6); err != nil {
+		t.Fatalf("deleteEmail: %v", err)
+	}
+	if stored() {
+		t.Error("deleted email still stored")
+	}
+}
+
+func TestBulkDeleteForgetsLocalEmails(t *testing.T) {
+	es := newTestServer(t)
+	ctx := context.Background()
+	if err := es.db.CreateEmail(&storage.Email{AccountID: "work", Folder: "INBOX", UID: 6, Subject: "Hello"}); err != nil {
+		t.Fatalf("CreateEmail: %v", err)
+	}
+
+	if _, err := es.bulkAction(ctx, "work", "INBOX", []uint32{6}, "delete", ""); err != nil {
+		t.Fatalf("bulkAction delete: %v", err)
+	}
+	if _, err := es.db.GetEmail("work", "INBOX", 6); err == nil {
+		t.Error("bulk deleted email still stored")
+	}
+}
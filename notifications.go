@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"email-mcp-server/config"
+	"email-mcp-server/mail"
+	"email-mcp-server/notifications"
+	"email-mcp-server/storage"
+)
+
+// initNotifications loads NOTIFICATIONS_CONFIG_PATH (default
+// notifications.json). When notifications are enabled, processNewEmails
+// scores the mail each sync stores.
+func (es *EmailServer) initNotifications() {
+	cfg, err := config.LoadNotificationConfig(notificationsPath())
+	if err != nil {
+		log.Printf("Notifications disabled: %v", err)
+		cfg = config.DefaultNotificationConfig()
+	}
+	es.notifier = notifications.New(cfg, es.sendNotification)
+}
+
+// sendNotification sends an alert email, to the sending account's own address
+// when the email channel names no recipient. Alerts outlive the sync that
+// raised them, so only the account timeouts bound the send.
+func (es *EmailServer) sendNotification(accountID string, msg *mail.OutgoingMessage) error {
+	if len(msg.To) == 0 {
+		config, err := es.getConfig(accountID)
+		if err != nil {
+			return err
+		}
+		msg.To = []string{config.Username}
+	}
+	return es.sendEmail(context.Background(), accountID, msg)
+}
+
+// alertNewEmails scores newly synced emails and notifies about those above
+// the thresholds. Emails older than a day, such as most of those fetched by
+// the first sync of an account, never raise an alert.
+func (es *EmailServer) alertNewEmails(accountID, folder string, emails []*storage.Email) {
+	now := time.Now()
+
+	var alerts []notifications.Alert
+	for _, email := range emails {
+		if now.Sub(email.Date) > 24*time.Hour {
+			continue
+		}
+
+		priority, err := es.scorePriority(context.Background(), email, now)
+		if err != nil {
+			log.Print(err)
+		}
+
+		level := es.notifier.Level(priority.Score)
+		if level == "" {
+			continue
+		}
+		alerts = append(alerts, notifications.Alert{
+			AccountID: accountID,
+			Folder:    folder,
+			UID:       email.UID,
+			MessageID: email.MessageID,
+			From:      email.From,
+			Subject:   email.Subject,
+			Snippet:   email.BodySnippet,
+			Date:      email.Date,
+			Score:     priority.Score,
+			Level:     level,
+			Reasons:   priority.Reasons(),
+		})
+	}
+	if len(alerts) == 0 {
+		return
+	}
+
+	result, err := es.notifier.Notify(alerts)
+	if err != nil {
+		log.Printf("Failed to deliver notifications: %v", err)
+	}
+	if result.RateLimited > 0 {
+		log.Printf("Notifications rate limited on %d channel(s)", result.RateLimited)
+	}
+}
+
+func (es *EmailServer) handleTestNotification(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	level := notifications.LevelCritical
+	if l, ok := args["level"].(string); ok && l != "" {
+		if l != notifications.LevelCritical && l != notifications.LevelHigh {
+			return nil, invalidArgument("level must be critical or high")
+		}
+		level = l
+	}
+
+	config, err := es.getConfig("")
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	score := 90
+	if level == notifications.LevelHigh {
+		score = 65
+	}
+	result, err := es.notifier.Notify([]notifications.Alert{{
+		AccountID: config.ID,
+		Folder:    "INBOX",
+		MessageID: fmt.Sprintf("<test-%d@email-mcp-server>", now.UnixNano()),
+		From:      "Email MCP Server <" + config.Username + ">",
+		Subject:   "Test notification",
+		Snippet:   "If you can read this, notifications reach this channel.",
+		Date:      now,
+		Score:     score,
+		Level:     level,
+		Reasons:   []string{"test"},
+	}})
+
+	text := fmt.Sprintf("🔔 Test %s notification: delivered to %d channel(s)", level, result.Delivered)
+	if result.RateLimited > 0 {
+		text += fmt.Sprintf(", %d rate limited", result.RateLimited)
+	}
+	if result.Delivered == 0 && result.RateLimited == 0 && err == nil {
+		text += "\nNo channel accepts this level; configure webhooks, email or desktop in notifications.json"
+	}
+	if err != nil {
+		text += fmt.Sprintf("\n❌ %v", err)
+	}
+	if !es.notifier.Enabled() {
+		text += "\nNote: notifications are disabled (\"enabled\": false), so synced mail raises no alerts"
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: text,
+		}},
+	}, nil
+}
@@ -0,0 +1,154 @@
+package notifications
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"email-mcp-server/config"
+	"email-mcp-server/mail"
+)
+
+// Discord accepts at most 10 embeds per message
+const maxEmbeds = 10
+
+func headline(alerts []Alert) string {
+	if len(alerts) == 1 {
+		return fmt.Sprintf("%s priority email from %s", strings.ToUpper(alerts[0].Level[:1])+alerts[0].Level[1:], alerts[0].From)
+	}
+	return fmt.Sprintf("%d high-priority emails", len(alerts))
+}
+
+func subjectOf(alert Alert) string {
+	if alert.Subject == "" {
+		return "(no subject)"
+	}
+	return alert.Subject
+}
+
+func (n *Notifier) postWebhook(hook config.WebhookChannel, alerts []Alert) error {
+	var payload interface{}
+	switch hook.Format {
+	case "discord":
+		payload = discordPayload(alerts)
+	case "json":
+		payload = map[string]interface{}{"text": headline(alerts), "alerts": alerts}
+	default:
+		payload = slackPayload(alerts)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(hook.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// slackPayload uses Slack's mrkdwn text, which Mattermost and Rocket.Chat
+// incoming webhooks also accept
+func slackPayload(alerts []Alert) map[string]interface{} {
+	var b strings.Builder
+	fmt.Fprintf(&b, ":rotating_light: *%s*", headline(alerts))
+	for _, alert := range alerts {
+		fmt.Fprintf(&b, "\n• *[%s %d]* %s - %s (%s)", alert.Level, alert.Score, subjectOf(alert), alert.From, alert.AccountID)
+		if len(alert.Reasons) > 0 {
+			fmt.Fprintf(&b, " _%s_", strings.Join(alert.Reasons, ", "))
+		}
+	}
+	return map[string]interface{}{"text": b.String()}
+}
+
+func discordPayload(alerts []Alert) map[string]interface{} {
+	var embeds []map[string]interface{}
+	for i, alert := range alerts {
+		if i == maxEmbeds {
+			break
+		}
+		color := 0xF39C12 // orange
+		if alert.Level == LevelCritical {
+			color = 0xE74C3C // red
+		}
+		embed := map[string]interface{}{
+			"title":       subjectOf(alert),
+			"description": alert.Snippet,
+			"color":       color,
+			"fields": []map[string]interface{}{
+				{"name": "From", "value": alert.From, "inline": true},
+				{"name": "Account", "value": alert.AccountID, "inline": true},
+				{"name": "Priority", "value": fmt.Sprintf("%s (%d)", alert.Level, alert.Score), "inline": true},
+			},
+		}
+		if !alert.Date.IsZero() {
+			embed["timestamp"] = alert.Date.UTC().Format(time.RFC3339)
+		}
+		embeds = append(embeds, embed)
+	}
+
+	content := "🚨 **" + headline(alerts) + "**"
+	if len(alerts) > maxEmbeds {
+		content += fmt.Sprintf(" (showing %d)", maxEmbeds)
+	}
+	return map[string]interface{}{"content": content, "embeds": embeds}
+}
+
+func (n *Notifier) sendEmail(alerts []Alert) error {
+	if n.send == nil {
+		return fmt.Errorf("no email sender configured")
+	}
+
+	var b strings.Builder
+	b.WriteString(headline(alerts) + ":\n")
+	for _, alert := range alerts {
+		fmt.Fprintf(&b, "\n[%s, score %d] %s\nFrom: %s (account %s, %s #%d)\n", alert.Level, alert.Score, subjectOf(alert), alert.From, alert.AccountID, alert.Folder, alert.UID)
+		if len(alert.Reasons) > 0 {
+			fmt.Fprintf(&b, "Why: %s\n", strings.Join(alert.Reasons, ", "))
+		}
+		if alert.Snippet != "" {
+			fmt.Fprintf(&b, "%s\n", alert.Snippet)
+		}
+	}
+
+	msg := &mail.OutgoingMessage{Subject: "[Email alert] " + headline(alerts), Body: b.String()}
+	if n.cfg.Email.To != "" {
+		msg.To = []string{n.cfg.Email.To}
+	}
+	return n.send(n.cfg.Email.Account, msg)
+}
+
+func showDesktop(alerts []Alert) error {
+	title := headline(alerts)
+	var lines []string
+	for _, alert := range alerts {
+		lines = append(lines, subjectOf(alert))
+	}
+	body := strings.Join(lines, "\n")
+
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd":
+		urgency := "normal"
+		if len(filterLevel(alerts, LevelCritical)) > 0 {
+			urgency = "critical"
+		}
+		return exec.Command("notify-send", "-u", urgency, "-a", "Email", title, body).Run()
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptString(body), appleScriptString(title))
+		return exec.Command("osascript", "-e", script).Run()
+	}
+	return fmt.Errorf("desktop notifications are not supported on %s", runtime.GOOS)
+}
+
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
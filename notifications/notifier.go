@@ -0,0 +1,204 @@
+// Package notifications alerts the user about high-priority mail through
+// webhooks (Slack or Discord compatible), a summary email or the desktop.
+package notifications
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"email-mcp-server/config"
+	"email-mcp-server/mail"
+)
+
+// Alert levels
+const (
+	LevelCritical = "critical"
+	LevelHigh     = "high"
+)
+
+// Alert is an email that scored above a notification threshold
+type Alert struct {
+	AccountID string    `json:"account_id"`
+	Folder    string    `json:"folder"`
+	UID       uint32    `json:"uid"`
+	MessageID string    `json:"message_id,omitempty"`
+	From      string    `json:"from"`
+	Subject   string    `json:"subject"`
+	Snippet   string    `json:"snippet,omitempty"`
+	Date      time.Time `json:"date"`
+	Score     int       `json:"score"`
+	Level     string    `json:"level"`
+	Reasons   []string  `json:"reasons,omitempty"`
+}
+
+func (a Alert) key() string {
+	if a.MessageID != "" {
+		return a.AccountID + "|" + a.MessageID
+	}
+	return fmt.Sprintf("%s|%s|%d", a.AccountID, a.Folder, a.UID)
+}
+
+// Sender sends an email from an account
+type Sender func(accountID string, msg *mail.OutgoingMessage) error
+
+// Result counts what happened to a batch of alerts
+type Result struct {
+	Alerts      int `json:"alerts"`     // alerts left after deduplication
+	Duplicates  int `json:"duplicates"` // alerts already sent within the dedup window
+	Delivered   int `json:"delivered"`  // channels that received the batch
+	RateLimited int `json:"rate_limited"`
+}
+
+// Notifier delivers alerts to the configured channels. Each channel gets at
+// most one message per Notify call, listing every alert of its level, so
+// the rate limit counts messages rather than emails.
+type Notifier struct {
+	cfg    *config.NotificationConfig
+	send   Sender
+	client *http.Client
+
+	mu     sync.Mutex
+	seen   map[string]time.Time   // alert key -> when it was sent
+	recent map[string][]time.Time // channel -> deliveries in the last hour
+}
+
+// New creates a notifier. send is used by the email channel.
+func New(cfg *config.NotificationConfig, send Sender) *Notifier {
+	return &Notifier{
+		cfg:    cfg,
+		send:   send,
+		client: &http.Client{Timeout: 10 * time.Second},
+		seen:   make(map[string]time.Time),
+		recent: make(map[string][]time.Time),
+	}
+}
+
+// Enabled reports whether notifications are switched on
+func (n *Notifier) Enabled() bool {
+	return n.cfg.Enabled
+}
+
+// DeliveryFailures reports whether failed deliveries of sent messages raise
+// alerts
+func (n *Notifier) DeliveryFailures() bool {
+	return n.cfg.DeliveryFailures
+}
+
+// Level returns the alert level of a priority score, or "" when the score is
+// below the high threshold
+func (n *Notifier) Level(score int) string {
+	switch {
+	case score >= n.cfg.CriticalThreshold:
+		return LevelCritical
+	case score >= n.cfg.HighThreshold:
+		return LevelHigh
+	}
+	return ""
+}
+
+// Notify delivers alerts to every channel. Alerts about an email that was
+// already notified within the dedup window are dropped.
+func (n *Notifier) Notify(alerts []Alert) (Result, error) {
+	now := time.Now()
+	var result Result
+
+	n.mu.Lock()
+	window := time.Duration(n.cfg.DedupMinutes) * time.Minute
+	for key, at := range n.seen {
+		if now.Sub(at) >= window {
+			delete(n.seen, key)
+		}
+	}
+	var fresh []Alert
+	for _, alert := range alerts {
+		if _, dup := n.seen[alert.key()]; dup {
+			result.Duplicates++
+			continue
+		}
+		if window > 0 {
+			n.seen[alert.key()] = now
+		}
+		fresh = append(fresh, alert)
+	}
+	n.mu.Unlock()
+
+	result.Alerts = len(fresh)
+	if len(fresh) == 0 {
+		return result, nil
+	}
+
+	var errs []error
+	deliver := func(channel, minLevel string, send func([]Alert) error) {
+		selected := filterLevel(fresh, minLevel)
+		if len(selected) == 0 {
+			return
+		}
+		if !n.allow(channel, now) {
+			result.RateLimited++
+			return
+		}
+		if err := send(selected); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", channel, err))
+			return
+		}
+		result.Delivered++
+	}
+
+	for i, hook := range n.cfg.Webhooks {
+		name := hook.Name
+		if name == "" {
+			name = fmt.Sprintf("webhook %d", i+1)
+		}
+		deliver(name, hook.MinLevel, func(alerts []Alert) error { return n.postWebhook(hook, alerts) })
+	}
+	if n.cfg.Email.Enabled {
+		deliver("email", n.cfg.Email.MinLevel, n.sendEmail)
+	}
+	if n.cfg.Desktop.Enabled {
+		deliver("desktop", n.cfg.Desktop.MinLevel, showDesktop)
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// allow records a delivery on channel unless it already had
+// RateLimitPerHour deliveries in the last hour
+func (n *Notifier) allow(channel string, now time.Time) bool {
+	if n.cfg.RateLimitPerHour <= 0 {
+		return true
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	recent := n.recent[channel][:0]
+	for _, at := range n.recent[channel] {
+		if now.Sub(at) < time.Hour {
+			recent = append(recent, at)
+		}
+	}
+	if len(recent) >= n.cfg.RateLimitPerHour {
+		n.recent[channel] = recent
+		return false
+	}
+	n.recent[channel] = append(recent, now)
+	return true
+}
+
+// filterLevel keeps the alerts at minLevel or above; an empty minLevel means
+// high
+func filterLevel(alerts []Alert, minLevel string) []Alert {
+	if minLevel != LevelCritical {
+		return alerts
+	}
+	var selected []Alert
+	for _, alert := range alerts {
+		if alert.Level == LevelCritical {
+			selected = append(selected, alert)
+		}
+	}
+	return selected
+}
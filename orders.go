@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"email-mcp-server/ai"
+	"email-mcp-server/storage"
+)
+
+// recordOrder extracts and stores the order a synced email reports on
+func (es *EmailServer) recordOrder(email *storage.Email) error {
+	// Tracking links are often further down than the snippet goes
+	order := ai.ExtractOrder(es.fullEmail(context.Background(), *email))
+	err := es.db.SaveOrder(&storage.Order{
+		AccountID:      email.AccountID,
+		Folder:         email.Folder,
+		UID:            email.UID,
+		Merchant:       order.Merchant,
+		Number:         order.Number,
+		Status:         order.Status,
+		Carrier:        order.Carrier,
+		TrackingNumber: order.TrackingNumber,
+		TrackingURL:    order.TrackingURL,
+		Total:          order.Total,
+		Currency:       order.Currency,
+		ExtractedAt:    time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store order %s/%d: %w", email.Folder, email.UID, err)
+	}
+	return nil
+}
+
+func (es *EmailServer) handleMyOrders(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("orders are not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	status, _ := args["status"].(string)
+	if status == "all" {
+		status = ""
+	}
+	days := 60
+	if d, ok := args["days"].(float64); ok && d >= 1 {
+		days = int(d)
+	}
+	limit := 50
+	if l, ok := args["limit"].(float64); ok && l >= 1 {
+		limit = int(l)
+	}
+
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	orders, err := es.db.ListOrders(config.ID, folder, status, time.Now().AddDate(0, 0, -days), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	ordersJSON, _ := json.MarshalIndent(orders, "", "  ")
+	return ToolResult{
+		Content: []TextContent{
+			{Type: "text", Text: formatOrders(config.ID, orders, status, days)},
+			{Type: "text", Text: string(ordersJSON)},
+		},
+	}, nil
+}
+
+func formatOrders(accountID string, orders []storage.Order, status string, days int) string {
+	kind := "orders"
+	if status != "" {
+		kind = status + " orders"
+	}
+	if len(orders) == 0 {
+		return fmt.Sprintf("No %s in the last %d days for %s", kind, days, accountID)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d %s in the last %d days for %s:\n", len(orders), kind, days, accountID)
+	for _, o := range orders {
+		merchant := o.Merchant
+		if merchant == "" {
+			merchant = o.Email.From
+		}
+		if o.Number != "" {
+			merchant += " #" + o.Number
+		}
+		fmt.Fprintf(&b, "- %s · %s · %s", o.Email.Date.Local().Format("2006-01-02"), merchant, o.Status)
+		if o.Total > 0 {
+			fmt.Fprintf(&b, " · %s", strings.TrimSpace(fmt.Sprintf("%.2f %s", o.Total, o.Currency)))
+		}
+		if tracking := strings.TrimSpace(o.Carrier + " " + o.TrackingNumber); tracking != "" {
+			fmt.Fprintf(&b, " · %s", tracking)
+		}
+		if o.TrackingURL != "" {
+			fmt.Fprintf(&b, " · %s", o.TrackingURL)
+		}
+		fmt.Fprintf(&b, " [%s/%d]\n", o.Folder, o.UID)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
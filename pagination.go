@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+)
+
+// Listing tools return a next_cursor when more results are available; passing
+// it back as cursor continues where the previous page stopped. Cursors are
+// opaque to clients: a kind followed by numbers, base64-encoded.
+//
+//	emails:<uidvalidity>:<uid>  get_emails, emails with a lower UID
+//	offset:<n>                  database listings, results after the first n
+
+// encodeCursor builds a cursor of the given kind
+func encodeCursor(kind string, values ...uint64) string {
+	fields := []string{kind}
+	for _, v := range values {
+		fields = append(fields, strconv.FormatUint(v, 10))
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(strings.Join(fields, ":")))
+}
+
+// decodeCursor reads a cursor of the given kind holding n numbers
+func decodeCursor(cursor, kind string, n int) ([]uint64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, invalidArgument("invalid cursor %q", cursor)
+	}
+	fields := strings.Split(string(raw), ":")
+	if len(fields) != n+1 || fields[0] != kind {
+		return nil, invalidArgument("invalid cursor %q: it was not returned by this tool", cursor)
+	}
+
+	values := make([]uint64, n)
+	for i, field := range fields[1:] {
+		if values[i], err = strconv.ParseUint(field, 10, 64); err != nil {
+			return nil, invalidArgument("invalid cursor %q", cursor)
+		}
+	}
+	return values, nil
+}
+
+// offsetArg reads the cursor argument of a tool paginated by offset
+func offsetArg(args map[string]interface{}) (int, error) {
+	cursor, _ := args["cursor"].(string)
+	if cursor == "" {
+		return 0, nil
+	}
+	values, err := decodeCursor(cursor, "offset", 1)
+	if err != nil {
+		return 0, err
+	}
+	return int(values[0]), nil
+}
+
+// nextOffsetCursor returns the cursor of the page after offset, or "" when
+// the page was the last one
+func nextOffsetCursor(offset, limit int, more bool) string {
+	if !more {
+		return ""
+	}
+	return encodeCursor("offset", uint64(offset+limit))
+}
+
+// withNextCursor appends the next_cursor line to a listing, when there is a
+// next page
+func withNextCursor(text, next string) string {
+	if next == "" {
+		return text
+	}
+	return text + "\n\nnext_cursor: " + next
+}
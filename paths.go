@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"email-mcp-server/credentials"
+)
+
+// MCP clients start the server from a directory of their choosing, so
+// relative paths are not resolved against it. A path set to an absolute
+// path in its variable is used as is; relative ones are resolved against
+// the home directory:
+//  1. CONFIG_DIR when set
+//  2. the working directory, then the executable's directory, when it holds
+//     email_config.json or the database, as every install did before
+//  3. email-mcp-server in os.UserConfigDir
+//
+// Downloaded attachments, which can be fetched again, go to CACHE_DIR,
+// by default email-mcp-server in os.UserCacheDir, unless the home is one of
+// the directories of step 2.
+
+// appName names the directories of the server in the user's configuration
+// and cache directories
+const appName = "email-mcp-server"
+
+// homeMarkers are the files that make a directory the home of an install
+// that predates CONFIG_DIR
+var homeMarkers = []string{"email_config.json", filepath.Join("data", "emails.db"), filepath.Join("data", "emails.db.enc")}
+
+var (
+	homeOnce   sync.Once
+	home       string
+	legacyHome bool // home is the working or the executable's directory
+)
+
+// homeDir returns the directory relative paths are resolved against. It is
+// found once, after loadEnv, so CONFIG_DIR can be set in .env.
+func homeDir() string {
+	homeOnce.Do(func() {
+		if dir := os.Getenv("CONFIG_DIR"); dir != "" {
+			home = absolute(dir)
+			return
+		}
+		for _, dir := range []string{".", executableDir()} {
+			if dir != "" && holdsAny(dir, homeMarkers) {
+				home, legacyHome = absolute(dir), true
+				return
+			}
+		}
+		home = userDir(os.UserConfigDir)
+	})
+	return home
+}
+
+// cacheDir is where files that can be fetched again are kept
+func cacheDir() string {
+	if dir := os.Getenv("CACHE_DIR"); dir != "" {
+		return dir
+	}
+	if homeDir(); legacyHome {
+		return home
+	}
+	return userDir(os.UserCacheDir)
+}
+
+// userDir returns the directory of the server in a directory of the user,
+// the working directory when the user has none
+func userDir(base func() (string, error)) string {
+	dir, err := base()
+	if err != nil {
+		return absolute(".")
+	}
+	return filepath.Join(dir, appName)
+}
+
+// executableDir is the directory of the running binary, "" when unknown
+func executableDir() string {
+	exe, err := os.Executable()
+	if err != nil {
+		return ""
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	return filepath.Dir(exe)
+}
+
+func holdsAny(dir string, names []string) bool {
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(dir, name)); !errors.Is(err, os.ErrNotExist) {
+			return true
+		}
+	}
+	return false
+}
+
+func absolute(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// resolvePath returns the path named by the variable key, def when it is
+// unset, resolved against dir when relative
+func resolvePath(key, def, dir string) string {
+	path := getEnv(key, def)
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// findFile returns the first of the home, working and executable's
+// directories holding name, "" when none does
+func findFile(name string) string {
+	for _, dir := range []string{homeDir(), ".", executableDir()} {
+		if dir != "" && holdsAny(dir, []string{name}) {
+			return filepath.Join(dir, name)
+		}
+	}
+	return ""
+}
+
+func accountsPath() string {
+	return resolvePath("EMAIL_CONFIG_PATH", "email_config.json", homeDir())
+}
+
+func aiConfigPath() string {
+	return resolvePath("AI_CONFIG_PATH", "ai_config.json", homeDir())
+}
+
+func rulesPath() string {
+	return resolvePath("PRIORITY_RULES_PATH", "priority_rules.json", homeDir())
+}
+
+func notificationsPath() string {
+	return resolvePath("NOTIFICATIONS_CONFIG_PATH", "notifications.json", homeDir())
+}
+
+func databasePath() string {
+	return resolvePath("DATABASE_PATH", filepath.Join("data", "emails.db"), homeDir())
+}
+
+func backupDir() string {
+	return resolvePath("BACKUP_DIR", "backups", homeDir())
+}
+
+func downloadsDir() string {
+	return resolvePath("DOWNLOADS_DIR", "downloads", cacheDir())
+}
+
+// credentialOptions are the credential store options of the environment,
+// with the encrypted credentials file resolved like the other files
+func credentialOptions() credentials.Options {
+	opts := credentials.OptionsFromEnv()
+	opts.FilePath = resolvePath("CREDENTIALS_FILE", "credentials.enc", homeDir())
+	return opts
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// resetHome makes homeDir look for the home again, and again after the test
+func resetHome(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		homeOnce, home, legacyHome = sync.Once{}, "", false
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestConfigDir(t *testing.T) {
+	resetHome(t)
+	dir := t.TempDir()
+	t.Chdir(t.TempDir())
+	t.Setenv("CONFIG_DIR", dir)
+	t.Setenv("CACHE_DIR", filepath.Join(dir, "cache"))
+	t.Setenv("DATABASE_PATH", "/var/lib/mail/emails.db")
+	t.Setenv("BACKUP_DIR", "old/backups")
+
+	if got := homeDir(); got != dir {
+		t.Errorf("homeDir = %s, want CONFIG_DIR %s", got, dir)
+	}
+	for _, tt := range []struct{ got, want string }{
+		{accountsPath(), filepath.Join(dir, "email_config.json")},
+		{databasePath(), "/var/lib/mail/emails.db"},
+		{backupDir(), filepath.Join(dir, "old", "backups")},
+		{downloadsDir(), filepath.Join(dir, "cache", "downloads")},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("path = %s, want %s", tt.got, tt.want)
+		}
+	}
+}
+
+func TestLegacyHome(t *testing.T) {
+	resetHome(t)
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("CONFIG_DIR", "")
+	t.Setenv("CACHE_DIR", "")
+	if err := os.WriteFile("email_config.json", []byte("[]"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// An install that keeps its files next to where it runs keeps working
+	wd, _ := os.Getwd()
+	if got := homeDir(); got != wd {
+		t.Errorf("homeDir = %s, want the working directory %s", got, wd)
+	}
+	if got := downloadsDir(); got != filepath.Join(wd, "downloads") {
+		t.Errorf("downloadsDir = %s, want it in the working directory", got)
+	}
+}
+
+func TestUserDirs(t *testing.T) {
+	resetHome(t)
+	t.Chdir(t.TempDir())
+	config, cache := t.TempDir(), t.TempDir()
+	t.Setenv("CONFIG_DIR", "")
+	t.Setenv("CACHE_DIR", "")
+	t.Setenv("DOWNLOADS_DIR", "")
+	t.Setenv("XDG_CONFIG_HOME", config)
+	t.Setenv("XDG_CACHE_HOME", cache)
+	want, err := os.UserConfigDir()
+	if err != nil {
+		t.Skip("no user configuration directory on this system")
+	}
+
+	if got := homeDir(); got != filepath.Join(want, appName) {
+		t.Errorf("homeDir = %s, want %s", got, filepath.Join(want, appName))
+	}
+	wantCache, _ := os.UserCacheDir()
+	if got := downloadsDir(); got != filepath.Join(wantCache, appName, "downloads") {
+		t.Errorf("downloadsDir = %s, want it in %s", got, wantCache)
+	}
+
+	noDir := func() (string, error) { return "", errors.New("$HOME is not defined") }
+	if got, wd := userDir(noDir), absolute("."); got != wd {
+		t.Errorf("userDir without a user directory = %s, want the working directory %s", got, wd)
+	}
+}
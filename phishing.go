@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"email-mcp-server/ai"
+	"email-mcp-server/mail"
+)
+
+// checkPhishing runs the phishing heuristics on a parsed message
+func checkPhishing(parsed *mail.ParsedEmail) ai.PhishingReport {
+	return ai.CheckPhishing(ai.PhishingInput{
+		Headers:  parsed.Headers,
+		TextBody: parsed.TextBody,
+		HTMLBody: parsed.HTMLBody,
+	})
+}
+
+func (es *EmailServer) handleCheckPhishing(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+
+	var parsed *mail.ParsedEmail
+	if id, ok := args["id"].(float64); ok {
+		config, err := es.getConfig(accountID)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err = es.getParsedEmail(ctx, config.ID, folder, uint32(id))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get email: %w", err)
+		}
+	} else if raw, ok := args["raw"].(string); ok && raw != "" {
+		var err error
+		parsed, err = mail.ParseBytes([]byte(strings.ReplaceAll(raw, "\r\n", "\n")))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse raw email: %w", err)
+		}
+	} else {
+		return nil, invalidArgument("missing required parameters: id or raw")
+	}
+
+	report := checkPhishing(parsed)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Phishing risk: %s (%d/100)\n", report.Level, report.Score)
+	fmt.Fprintf(&b, "From: %s\nSubject: %s\n", parsed.Header("From"), parsed.Header("Subject"))
+	orUnknown := func(result string) string {
+		if result == "" {
+			return "not recorded"
+		}
+		return result
+	}
+	fmt.Fprintf(&b, "Authentication: SPF %s, DKIM %s, DMARC %s\n", orUnknown(report.SPF), orUnknown(report.DKIM), orUnknown(report.DMARC))
+	if len(report.Findings) == 0 {
+		b.WriteString("\nNo warning signs found.")
+	} else {
+		b.WriteString("\nFindings:\n")
+		for _, finding := range report.Findings {
+			fmt.Fprintf(&b, "- [+%d] %s: %s\n", finding.Points, finding.Check, finding.Detail)
+		}
+	}
+	if report.Level == ai.RiskHigh {
+		b.WriteString("\n⚠️ Do not open links or attachments, or reply with personal data.")
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: strings.TrimRight(b.String(), "\n"),
+		}},
+	}, nil
+}
@@ -0,0 +1,58 @@
+// Package pool runs batch work, such as classifying or scoring thousands of
+// synced emails, on a bounded number of goroutines
+package pool
+
+import (
+	"context"
+	"sync"
+)
+
+// Run calls fn for every index below n on up to workers goroutines and
+// returns the error of each call by index, nil where it succeeded. Items not
+// started when ctx ends get its error instead. workers < 1 runs one at a
+// time.
+func Run(ctx context.Context, n, workers int, fn func(ctx context.Context, i int) error) []error {
+	errs := make([]error, n)
+	workers = max(min(workers, n), 1)
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = fn(ctx, i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		if ctx.Err() == nil {
+			select {
+			case next <- i:
+				continue
+			case <-ctx.Done():
+			}
+		}
+		errs[i] = ctx.Err()
+	}
+	close(next)
+	wg.Wait()
+	return errs
+}
+
+// Failed returns how many of errs are set and the first of them
+func Failed(errs []error) (int, error) {
+	var count int
+	var first error
+	for _, err := range errs {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			count++
+		}
+	}
+	return count, first
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+
+	"email-mcp-server/ai"
+	"email-mcp-server/pool"
+	"email-mcp-server/storage"
+)
+
+// scorePriority scores a synced email from its snippet, using its stored
+// classification when there is one, and stores the result. The priority is
+// returned even when it could not be stored.
+func (es *EmailServer) scorePriority(ctx context.Context, email *storage.Email, now time.Time) (ai.Priority, error) {
+	message := ai.Email{
+		AccountID:   email.AccountID,
+		Folder:      email.Folder,
+		UID:         email.UID,
+		MessageID:   email.MessageID,
+		ThreadID:    email.ThreadID,
+		From:        email.From,
+		To:          email.To,
+		Subject:     email.Subject,
+		Body:        email.BodySnippet,
+		Date:        email.Date,
+		Automated:   email.Automated,
+		Attachments: email.Attachments,
+	}
+
+	var classification *ai.Classification
+	if stored, err := es.db.GetClassification(email.AccountID, email.Folder, email.UID); err == nil && stored != nil {
+		classification = &ai.Classification{Category: stored.Category, Confidence: stored.Confidence, Tags: stored.Tags}
+	} else if c, err := es.classifier.Classify(ctx, message); err == nil {
+		classification = c
+	}
+
+	signals := ai.PrioritySignals{
+		VIP:     es.isVIP(email.AccountID, email.FromAddress),
+		Flagged: slices.Contains(email.Flags, imap.FlaggedFlag),
+		Ignored: es.isIgnored(email.AccountID, email.FromAddress, email.Subject),
+	}
+	es.rulesMu.RLock()
+	if es.rules != nil {
+		signals.Keywords = es.rules.ForAccount(email.AccountID).UrgencyKeywords
+	}
+	es.rulesMu.RUnlock()
+	// The email itself was already counted when it was stored
+	if contact, err := es.db.ContactByAddress(email.FromAddress.Address); err == nil && contact != nil {
+		signals.SenderHistory = contact.ReceivedCount - 1
+	}
+
+	priority := ai.ScorePriority(message, classification, signals, now)
+	err := es.db.SavePriority(&storage.Priority{
+		AccountID: email.AccountID,
+		Folder:    email.Folder,
+		UID:       email.UID,
+		Score:     priority.Score,
+		Level:     priority.Level,
+		Factors:   priority.Factors,
+		ScoredAt:  now,
+	})
+	if err != nil {
+		return priority, fmt.Errorf("failed to store priority of %s/%d: %w", email.Folder, email.UID, err)
+	}
+	return priority, nil
+}
+
+func (es *EmailServer) handleRecalcPriorities(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("priorities are not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	limit := 500
+	if l, ok := args["limit"].(float64); ok && l >= 1 {
+		limit = int(l)
+	}
+
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	emails, err := es.db.GetEmails(config.ID, limit)
+	if err != nil {
+		return nil, err
+	}
+	// Sent mail synced from the Sent folder needs no attention
+	emails = slices.DeleteFunc(emails, func(e storage.Email) bool {
+		return config.Username != "" && strings.Contains(strings.ToLower(e.From), strings.ToLower(config.Username))
+	})
+
+	// Emails are scored on es.batchWorkers goroutines; the database
+	// serializes their writes
+	now := time.Now()
+	scored := make([]string, len(emails))
+	errs := pool.Run(ctx, len(emails), es.batchWorkers, func(ctx context.Context, i int) error {
+		if err := es.recordDeadlines(&emails[i]); err != nil {
+			return err
+		}
+		priority, err := es.scorePriority(ctx, &emails[i], now)
+		if err != nil {
+			return err
+		}
+		scored[i] = priority.Level
+		return nil
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("stopped before scoring all %d emails: %w", len(emails), err)
+	}
+
+	levels := make(map[string]int)
+	for _, level := range scored {
+		if level != "" {
+			levels[level]++
+		}
+	}
+
+	var counts []string
+	for _, level := range []string{ai.PriorityCritical, ai.PriorityHigh, ai.PriorityMedium, ai.PriorityLow, ai.PriorityMinimal} {
+		if levels[level] > 0 {
+			counts = append(counts, fmt.Sprintf("%s: %d", level, levels[level]))
+		}
+	}
+	failed, firstErr := pool.Failed(errs)
+	text := fmt.Sprintf("Recalculated the priority of %d synced emails of %s", len(emails)-failed, config.ID)
+	if len(counts) > 0 {
+		text += " (" + strings.Join(counts, ", ") + ")"
+	}
+	if failed > 0 {
+		text += fmt.Sprintf("\n%d emails failed, the first with: %v", failed, firstErr)
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: text,
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handlePriorityStats(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("priorities are not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	dist, err := es.db.PriorityDistribution(config.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute the priority distribution: %w", err)
+	}
+
+	distJSON, _ := json.MarshalIndent(dist, "", "  ")
+	return ToolResult{
+		Content: []TextContent{
+			{Type: "text", Text: formatPriorityStats(dist)},
+			{Type: "text", Text: string(distJSON)},
+		},
+	}, nil
+}
+
+func formatPriorityStats(dist *storage.PriorityDistribution) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Priority distribution for %s: %d scored emails, average score %.0f\n", dist.AccountID, dist.Scored, dist.AverageScore)
+	if dist.Unscored > 0 {
+		fmt.Fprintf(&b, "%d synced emails were never scored; run recalc_priorities to score them\n", dist.Unscored)
+	}
+
+	if len(dist.Levels) > 0 {
+		b.WriteString("\nBy level:\n")
+		for _, level := range dist.Levels {
+			fmt.Fprintf(&b, "- %s: %d (%.0f%%, average score %.0f)\n",
+				level.Level, level.Count, 100*float64(level.Count)/float64(dist.Scored), level.AverageScore)
+		}
+	}
+
+	if len(dist.Factors) > 0 {
+		b.WriteString("\nMost common factors:\n")
+		for i, factor := range dist.Factors {
+			if i == 8 {
+				break
+			}
+			fmt.Fprintf(&b, "- %s: %d emails\n", strings.ReplaceAll(factor.Factor, "_", " "), factor.Count)
+		}
+	}
+
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func (es *EmailServer) handlePriorityInbox(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("priorities are not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	limit := 20
+	if l, ok := args["limit"].(float64); ok && l >= 1 {
+		limit = int(l)
+	}
+	minLevel := ai.PriorityHigh
+	if l, ok := args["level"].(string); ok && l != "" {
+		minLevel = strings.ToLower(l)
+	}
+	// Levels from the highest down to the minimum asked for
+	levels := []string{ai.PriorityCritical, ai.PriorityHigh, ai.PriorityMedium, ai.PriorityLow, ai.PriorityMinimal}
+	i := slices.Index(levels, minLevel)
+	if i < 0 {
+		return nil, fmt.Errorf("unknown priority level %q: use one of %s", minLevel, strings.Join(levels, ", "))
+	}
+	levels = levels[:i+1]
+	starred, _ := args["starred"].(string)
+	if starred != "" && starred != storage.StarredOnly && starred != storage.StarredFirst {
+		return nil, fmt.Errorf("unknown starred %q: use %s or %s", starred, storage.StarredOnly, storage.StarredFirst)
+	}
+
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	emails, err := es.db.PriorityInbox(config.ID, folder, levels, starred, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	emailsJSON, _ := json.MarshalIndent(emails, "", "  ")
+	return ToolResult{
+		Content: []TextContent{
+			{Type: "text", Text: formatPriorityInbox(config.ID, minLevel, emails)},
+			{Type: "text", Text: string(emailsJSON)},
+		},
+	}, nil
+}
+
+func formatPriorityInbox(accountID, minLevel string, emails []storage.PrioritizedEmail) string {
+	if len(emails) == 0 {
+		return fmt.Sprintf("No scored emails of %s at priority %s or above; run recalc_priorities to score synced emails", accountID, minLevel)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Emails of %s at priority %s or above:\n", accountID, minLevel)
+	for i, e := range emails {
+		level := fmt.Sprintf("%s %d", e.Level, e.Score)
+		if e.Starred {
+			level += ", starred"
+		}
+		fmt.Fprintf(&b, "%d. [%s] %s · %s · %s (%s #%d)\n", i+1, level, e.Subject, e.From,
+			e.Date.Local().Format("Mon Jan 2 15:04"), e.Folder, e.UID)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// rankedThread is a thread rollup with its conversation score
+type rankedThread struct {
+	storage.ThreadPriority
+	Score int    `json:"score"`
+	Level string `json:"level"`
+}
+
+func (es *EmailServer) handlePriorityThreads(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("priorities are not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	limit := 10
+	if l, ok := args["limit"].(float64); ok && l >= 1 {
+		limit = int(l)
+	}
+	days := 30
+	if d, ok := args["days"].(float64); ok && d >= 1 {
+		days = int(d)
+	}
+
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	threads, err := es.db.ThreadPriorities(config.ID, now.AddDate(0, 0, -days))
+	if err != nil {
+		return nil, err
+	}
+
+	ranked := make([]rankedThread, 0, len(threads))
+	for _, t := range threads {
+		score := ai.ScoreThread(ai.ThreadActivity{
+			Messages:     t.Messages,
+			Unread:       t.Unread,
+			MaxScore:     t.MaxScore,
+			MeanScore:    t.MeanScore,
+			LastActivity: t.LastActivity,
+		}, now)
+		ranked = append(ranked, rankedThread{ThreadPriority: t, Score: score, Level: ai.PriorityLevel(score)})
+	}
+	// Threads come most recently active first, so ties keep that order
+	slices.SortStableFunc(ranked, func(a, b rankedThread) int { return b.Score - a.Score })
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+
+	rankedJSON, _ := json.MarshalIndent(ranked, "", "  ")
+	return ToolResult{
+		Content: []TextContent{
+			{Type: "text", Text: formatPriorityThreads(config.ID, ranked, days)},
+			{Type: "text", Text: string(rankedJSON)},
+		},
+	}, nil
+}
+
+func formatPriorityThreads(accountID string, threads []rankedThread, days int) string {
+	if len(threads) == 0 {
+		return fmt.Sprintf("No conversations with activity in the last %d days for %s", days, accountID)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Most important conversations of the last %d days for %s:\n", days, accountID)
+	for i, t := range threads {
+		fmt.Fprintf(&b, "%d. [%s %d] %s · %d messages", i+1, t.Level, t.Score, t.Subject, t.Messages)
+		if t.Unread > 0 {
+			fmt.Fprintf(&b, ", %d unread", t.Unread)
+		}
+		fmt.Fprintf(&b, " · last activity %s", t.LastActivity.Local().Format("Mon Jan 2 15:04"))
+		if t.Scored < t.Messages {
+			fmt.Fprintf(&b, " · %d unscored", t.Messages-t.Scored)
+		}
+		fmt.Fprintf(&b, " (thread_id %s)\n", t.ThreadID)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
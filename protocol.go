@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// protocolVersions are the MCP revisions the server speaks, newest first
+var protocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// negotiateVersion answers the protocolVersion a client sends in initialize:
+// the same version when the server speaks it, the newest one otherwise, for
+// the client to disconnect from if it does not speak that one
+func negotiateVersion(requested string) string {
+	if slices.Contains(protocolVersions, requested) {
+		return requested
+	}
+	return protocolVersions[0]
+}
+
+// listTools returns the tools of tools/list. Each account argument lists the
+// configured account IDs as its enum and names the default account, so the
+// model does not have to guess them; the list changes with the accounts and
+// clients are sent notifications/tools/list_changed. Calls are still
+// validated against the registered schemas, so names findAccount resolves,
+// such as usernames, keep working.
+func (es *EmailServer) listTools() []Tool {
+	es.configsMu.RLock()
+	ids := make([]interface{}, len(es.configs))
+	for i, config := range es.configs {
+		ids[i] = config.ID
+	}
+	defaultAccount := es.defaultAccount
+	es.configsMu.RUnlock()
+
+	tools := es.tools.List()
+	if len(ids) == 0 {
+		return tools
+	}
+
+	listed := make([]Tool, len(tools))
+	for i, tool := range tools {
+		listed[i] = tool
+		schema, _ := tool.InputSchema.(map[string]interface{})
+		properties, _ := schema["properties"].(map[string]interface{})
+		account, ok := properties["account"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		// The registered schema is shared, so the path down to the
+		// description is copied
+		description, _ := account["description"].(string)
+		account = maps.Clone(account)
+		account["description"] = strings.TrimSuffix(description, ".") + ". Default account: " + defaultAccount
+		account["enum"] = ids
+		properties = maps.Clone(properties)
+		properties["account"] = account
+		schema = maps.Clone(schema)
+		schema["properties"] = properties
+		listed[i].InputSchema = schema
+	}
+	return listed
+}
+
+// notifyToolListChanged tells the client that accounts were added or
+// removed, so the tool list must be fetched again
+func (es *EmailServer) notifyToolListChanged() {
+	notification := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "notifications/tools/list_changed",
+	}
+	if err := es.writeMessage(notification); err != nil {
+		log.Printf("Error sending tool list change: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateVersion(t *testing.T) {
+	tests := map[string]string{
+		"2025-06-18": "2025-06-18",
+		"2025-03-26": "2025-03-26",
+		"2024-11-05": "2024-11-05",
+		"2030-01-01": protocolVersions[0],
+		"":           protocolVersions[0],
+	}
+	for requested, want := range tests {
+		if got := negotiateVersion(requested); got != want {
+			t.Errorf("negotiateVersion(%q) = %s, want %s", requested, got, want)
+		}
+	}
+}
+
+func TestInitialize(t *testing.T) {
+	es := newTestServer(t)
+	resp := es.handleMessage(context.Background(), []byte(`{"jsonrpc":"2.0","id":1,"method":"initialize",
+		"params":{"protocolVersion":"2025-03-26","clientInfo":{"name":"test","version":"1"}}}`))
+	if resp == nil || resp.Error != nil {
+		t.Fatalf("initialize = %+v", resp)
+	}
+
+	var result struct {
+		ProtocolVersion string `json:"protocolVersion"`
+		Capabilities    struct {
+			Tools struct {
+				ListChanged bool `json:"listChanged"`
+			} `json:"tools"`
+		} `json:"capabilities"`
+	}
+	data, _ := json.Marshal(resp.Result)
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatalf("initialize result %s: %v", data, err)
+	}
+	if result.ProtocolVersion != "2025-03-26" {
+		t.Errorf("protocolVersion = %s, want the requested 2025-03-26", result.ProtocolVersion)
+	}
+	if !result.Capabilities.Tools.ListChanged {
+		t.Error("tools.listChanged is not advertised")
+	}
+}
+
+func TestRemoveAccountNotifiesToolListChanged(t *testing.T) {
+	es := newTestServer(t)
+	es.configPath = filepath.Join(t.TempDir(), "email_config.json")
+	es.configs = append(es.configs, EmailConfig{ID: "home", Username: "me@home.com", Password: "secret",
+		IMAPHost: "imap.home.com", IMAPPort: 993, SMTPHost: "smtp.home.com", SMTPPort: 587})
+
+	output := captureStdout(t, func() {
+		if _, err := es.handleRemoveAccount(context.Background(), map[string]interface{}{"id": "home"}); err != nil {
+			t.Errorf("handleRemoveAccount: %v", err)
+		}
+	})
+	if !strings.Contains(output, `"method":"notifications/tools/list_changed"`) {
+		t.Errorf("output = %q, want a tools/list_changed notification", output)
+	}
+}
+
+func TestListToolsAccountEnum(t *testing.T) {
+	es := newTestServer(t)
+	es.configs = append(es.configs, EmailConfig{ID: "home", Username: "me@home.com"})
+	es.defaultAccount = "home"
+	accountSchema := func() map[string]interface{} {
+		return map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{"type": "string", "description": "Account ID to use."},
+			},
+		}
+	}
+	handler := func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+		return args["account"], nil
+	}
+	es.tools.Register(Tool{Name: "get_emails", InputSchema: accountSchema()}, handler)
+	es.tools.Register(Tool{Name: "list_accounts", InputSchema: map[string]interface{}{"type": "object"}}, handler)
+
+	tools := es.listTools()
+	account := tools[0].InputSchema.(map[string]interface{})["properties"].(map[string]interface{})["account"].(map[string]interface{})
+	if enum, _ := account["enum"].([]interface{}); len(enum) != 2 || enum[0] != "work" || enum[1] != "home" {
+		t.Errorf("account enum = %v, want work and home", account["enum"])
+	}
+	if account["description"] != "Account ID to use. Default account: home" {
+		t.Errorf("account description = %q", account["description"])
+	}
+	if _, ok := tools[1].InputSchema.(map[string]interface{})["properties"]; ok {
+		t.Error("a tool without an account argument got one")
+	}
+
+	// The registered schema is left alone, so calls can still name an
+	// account by its username
+	if _, ok := es.tools.List()[0].InputSchema.(map[string]interface{})["properties"].(map[string]interface{})["account"].(map[string]interface{})["enum"]; ok {
+		t.Error("listTools changed the registered schema")
+	}
+	result, err := es.tools.Call(context.Background(), ToolCallParams{Name: "get_emails", Arguments: map[string]interface{}{"account": "me@home.com"}})
+	if err != nil || result != "me@home.com" {
+		t.Errorf("call with a username = %v, %v", result, err)
+	}
+
+	// Accounts added later show up in the next list
+	es.configs = append(es.configs, EmailConfig{ID: "shop"})
+	account = es.listTools()[0].InputSchema.(map[string]interface{})["properties"].(map[string]interface{})["account"].(map[string]interface{})
+	if enum, _ := account["enum"].([]interface{}); len(enum) != 3 || enum[2] != "shop" {
+		t.Errorf("account enum after adding an account = %v", account["enum"])
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"email-mcp-server/ai"
+)
+
+// Default per-account limits, low enough that a burst of tool calls does not
+// get the account temporarily locked by Gmail or Outlook
+const (
+	defaultIMAPPerMinute = 60
+	defaultSMTPPerMinute = 20
+)
+
+// accountLimits are the token buckets of an account. Calls over the limit
+// wait for a token instead of failing.
+type accountLimits struct {
+	imapPerMinute int
+	smtpPerMinute int
+	imap          *ai.RateLimiter // nil when unlimited
+	smtp          *ai.RateLimiter
+}
+
+// accountLimits returns the limiters of config's account, replacing them
+// when its limits changed
+func (es *EmailServer) accountLimits(config *EmailConfig) *accountLimits {
+	imapPerMinute := perMinute(config.IMAPPerMinute, defaultIMAPPerMinute)
+	smtpPerMinute := perMinute(config.SMTPPerMinute, defaultSMTPPerMinute)
+
+	es.limitsMu.Lock()
+	defer es.limitsMu.Unlock()
+
+	limits, ok := es.limits[config.ID]
+	if !ok || limits.imapPerMinute != imapPerMinute || limits.smtpPerMinute != smtpPerMinute {
+		limits = &accountLimits{
+			imapPerMinute: imapPerMinute,
+			smtpPerMinute: smtpPerMinute,
+			imap:          ai.NewRateLimiter(imapPerMinute),
+			smtp:          ai.NewRateLimiter(smtpPerMinute),
+		}
+		es.limits[config.ID] = limits
+	}
+	return limits
+}
+
+// limitedConn is an IMAP connection whose commands wait for a token of the
+// account's limiter. go-imap sets the connection deadline once at the start
+// of every command, so that is where it waits.
+type limitedConn struct {
+	net.Conn
+	ctx       context.Context
+	accountID string
+	limiter   *ai.RateLimiter // nil until logged in
+}
+
+func (c *limitedConn) SetDeadline(t time.Time) error {
+	if err := c.limiter.Wait(c.ctx); err != nil {
+		return fmt.Errorf("gave up waiting for the IMAP %w of account %s: %w", errRateLimit, c.accountID, err)
+	}
+	return c.Conn.SetDeadline(t)
+}
+
+// perMinute resolves a configured limit: 0 means the default and a negative
+// value no limit
+func perMinute(configured, defaultValue int) int {
+	if configured == 0 {
+		return defaultValue
+	}
+	return configured
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIMAPRateLimitPerCommand(t *testing.T) {
+	es := newTestServer(t)
+	es.configs[0].IMAPPerMinute = 4
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	// The login and the capability probe of the first connection take two
+	// tokens, the next two commands the others
+	c, err := es.connectIMAP(ctx, "work")
+	if err != nil {
+		t.Fatalf("connectIMAP: %v", err)
+	}
+	defer c.Terminate()
+	for i := 0; i < 2; i++ {
+		if err := c.Noop(); err != nil {
+			t.Fatalf("command %d within the limit failed: %v", i+1, err)
+		}
+	}
+
+	err = c.Noop()
+	if err == nil || !strings.Contains(err.Error(), "rate limit of account work") {
+		t.Errorf("command over the limit returned %v, want a rate limit error once ctx ended", err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestResourceSubscriptionsAreCanonical(t *testing.T) {
+	es := newTestServer(t)
+	ctx := context.Background()
+	subscribe := func(method, uri string) {
+		t.Helper()
+		if _, err := es.handleResourceRequest(ctx, method, map[string]interface{}{"uri": uri}); err != nil {
+			t.Fatalf("%s %s: %+v", method, uri, err)
+		}
+	}
+
+	subscribe("resources/subscribe", "email://work/inbox/")
+	subscribe("resources/subscribe", "email://%77ork/Projects%2F2024")
+	subscribe("resources/subscribe", "email://work/")
+	for _, uri := range []string{"email://work/INBOX", "email://work/Projects%2F2024", "email://work"} {
+		if !es.subscriptions[uri] {
+			t.Errorf("no subscription to %s in %v", uri, es.subscriptions)
+		}
+	}
+	if len(es.subscriptions) != 3 {
+		t.Errorf("subscriptions = %v, want 3", es.subscriptions)
+	}
+
+	subscribe("resources/unsubscribe", "email://work/Inbox")
+	if es.subscriptions["email://work/INBOX"] {
+		t.Error("unsubscribing with another spelling kept the subscription")
+	}
+}
+
+func TestResourcesFollowToolRestrictions(t *testing.T) {
+	es := newTestServer(t)
+	es.tools = es.registerTools()
+	if err := es.tools.Restrict(nil, []string{"get_email*"}); err != nil {
+		t.Fatalf("Restrict: %v", err)
+	}
+	ctx := context.Background()
+
+	for _, uri := range []string{"email://work/INBOX", "email://work/INBOX/6"} {
+		_, err := es.handleResourceRequest(ctx, "resources/read", map[string]interface{}{"uri": uri})
+		if err == nil || err.Code != -32002 {
+			t.Errorf("reading %s with its tool denied returned %+v, want -32002", uri, err)
+		}
+	}
+	if _, err := es.handleResourceRequest(ctx, "resources/read", map[string]interface{}{"uri": "email://work"}); err != nil {
+		t.Errorf("reading the folders of work failed: %+v", err)
+	}
+
+	resources := es.listResources()
+	if len(resources) != 1 || resources[0].URI != "email://work" {
+		t.Errorf("listed %+v, want only the folders of work", resources)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+
+	"email-mcp-server/ai"
+	"email-mcp-server/storage"
+)
+
+// responseWindow is how far back sync looks when it updates the average
+// reply time of each contact
+const responseWindow = 90 * 24 * time.Hour
+
+// sentFolder returns the account's SentFolder, else the folder with the \Sent
+// attribute, else "" so that sync skips sent mail
+func (es *EmailServer) sentFolder(c *client.Client, accountID string) (string, error) {
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return "", err
+	}
+	if config.SentFolder != "" {
+		return config.SentFolder, nil
+	}
+	return findSpecialFolder(c, imap.SentAttr)
+}
+
+// updateResponseTimes stores how quickly the user replied to each contact in
+// the last responseWindow, after sync stored new sent emails
+func (es *EmailServer) updateResponseTimes(accountID string) {
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	activity, err := es.db.ResponseActivity(config.ID, []string{config.Username}, now.Add(-responseWindow), now)
+	if err == nil {
+		err = es.db.SaveResponseTimes(activity.Contacts)
+	}
+	if err != nil {
+		log.Printf("Failed to update response times of %s: %v", accountID, err)
+	}
+}
+
+// commitment is a sent email promising to get back to its recipients that
+// the user has not followed with another message in the thread
+type commitment struct {
+	Promise  string    `json:"promise"`
+	To       []string  `json:"to"`
+	Subject  string    `json:"subject"`
+	Folder   string    `json:"folder"`
+	UID      uint32    `json:"uid"`
+	ThreadID string    `json:"thread_id"`
+	Sent     time.Time `json:"sent"`
+}
+
+// waitingSender is a contact whose emails expect an answer the user has not
+// sent, with the oldest of them
+type waitingSender struct {
+	Sender   string    `json:"sender"`
+	Emails   int       `json:"emails"`
+	Subject  string    `json:"subject"`
+	Folder   string    `json:"folder"`
+	UID      uint32    `json:"uid"`
+	ThreadID string    `json:"thread_id"`
+	Since    time.Time `json:"since"`
+}
+
+type responseReport struct {
+	AccountID    string                    `json:"account_id"`
+	Since        time.Time                 `json:"since"`
+	Sent         int                       `json:"sent"`
+	ResponseTime *storage.ResponseTime     `json:"response_time,omitempty"`
+	Contacts     []storage.ContactResponse `json:"contacts"`
+	Commitments  []commitment              `json:"commitments"`
+	Waiting      []waitingSender           `json:"longest_waiting"`
+}
+
+func (es *EmailServer) handleMyResponseStats(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("response statistics are not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	days := 30
+	if d, ok := args["days"].(float64); ok && d >= 1 {
+		days = int(d)
+	}
+	limit := 10
+	if l, ok := args["limit"].(float64); ok && l >= 1 {
+		limit = int(l)
+	}
+
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	activity, err := es.db.ResponseActivity(config.ID, []string{config.Username}, now.AddDate(0, 0, -days), now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute response statistics: %w", err)
+	}
+	if err := es.db.SaveResponseTimes(activity.Contacts); err != nil {
+		log.Printf("Failed to update response times of %s: %v", config.ID, err)
+	}
+
+	report := responseReport{
+		AccountID:    config.ID,
+		Since:        activity.Since,
+		Sent:         activity.Sent,
+		ResponseTime: activity.Overall,
+		Contacts:     activity.Contacts,
+		Commitments:  []commitment{},
+		Waiting:      []waitingSender{},
+	}
+	if len(report.Contacts) > limit {
+		report.Contacts = report.Contacts[:limit]
+	}
+
+	for _, email := range activity.LastSent {
+		if promise := ai.FollowUpPromise(email.BodySnippet); promise != "" {
+			report.Commitments = append(report.Commitments, commitment{
+				Promise:  promise,
+				To:       email.To,
+				Subject:  email.Subject,
+				Folder:   email.Folder,
+				UID:      email.UID,
+				ThreadID: email.ThreadID,
+				Sent:     email.Date,
+			})
+		}
+	}
+
+	bySender := make(map[string]int)
+	for _, email := range activity.Unanswered {
+		category := ""
+		if c, err := es.db.GetClassification(email.AccountID, email.Folder, email.UID); err == nil && c != nil {
+			category = c.Category
+		}
+		message := ai.Email{From: email.From, To: email.To, Subject: email.Subject, Body: email.BodySnippet, Automated: email.Automated}
+		if !ai.NeedsReply(message, category) {
+			continue
+		}
+
+		sender := strings.ToLower(email.FromAddress.Address)
+		if sender == "" {
+			sender = strings.ToLower(strings.TrimSpace(email.From))
+		}
+		// Unanswered emails come oldest first, so the first one of each
+		// sender is the one they have waited for longest
+		if i, ok := bySender[sender]; ok {
+			report.Waiting[i].Emails++
+			continue
+		}
+		bySender[sender] = len(report.Waiting)
+		report.Waiting = append(report.Waiting, waitingSender{
+			Sender:   sender,
+			Emails:   1,
+			Subject:  email.Subject,
+			Folder:   email.Folder,
+			UID:      email.UID,
+			ThreadID: email.ThreadID,
+			Since:    email.Date,
+		})
+	}
+	if len(report.Waiting) > limit {
+		report.Waiting = report.Waiting[:limit]
+	}
+
+	reportJSON, _ := json.MarshalIndent(report, "", "  ")
+	return ToolResult{
+		Content: []TextContent{
+			{Type: "text", Text: formatResponseReport(report, days, now)},
+			{Type: "text", Text: string(reportJSON)},
+		},
+	}, nil
+}
+
+func formatResponseReport(r responseReport, days int, now time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "📤 Your responses for %s, last %d days: %d emails sent\n", r.AccountID, days, r.Sent)
+	if r.Sent == 0 {
+		b.WriteString("No sent emails are synced; set SentFolder on the account if its Sent folder has no \\Sent attribute\n")
+	}
+
+	if rt := r.ResponseTime; rt != nil {
+		fmt.Fprintf(&b, "Reply time: %.1fh on average, %.1fh median (%d replies)\n", rt.AverageHours, rt.MedianHours, rt.Replies)
+	}
+	if len(r.Contacts) > 0 {
+		b.WriteString("\nBy contact:\n")
+		for _, c := range r.Contacts {
+			fmt.Fprintf(&b, "- %s: %.1fh on average (%d replies)\n", c.Contact, c.AverageHours, c.Replies)
+		}
+	}
+
+	if len(r.Commitments) > 0 {
+		b.WriteString("\nPromised follow-ups not yet sent:\n")
+		for _, c := range r.Commitments {
+			fmt.Fprintf(&b, "- \"%s\" to %s · %s · %s [thread_id %s]\n",
+				c.Promise, strings.Join(c.To, ", "), c.Subject, formatWaiting(now.Sub(c.Sent)), c.ThreadID)
+		}
+	}
+
+	if len(r.Waiting) > 0 {
+		b.WriteString("\nLongest waiting for your reply:\n")
+		for _, w := range r.Waiting {
+			fmt.Fprintf(&b, "- %s · %s · %s", w.Sender, w.Subject, formatWaiting(now.Sub(w.Since)))
+			if w.Emails > 1 {
+				fmt.Fprintf(&b, " (%d emails)", w.Emails)
+			}
+			fmt.Fprintf(&b, " [%s/%d]\n", w.Folder, w.UID)
+		}
+	}
+
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// formatWaiting describes how long something has waited, e.g. "3 days ago"
+func formatWaiting(d time.Duration) string {
+	if d < 24*time.Hour {
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	}
+	return fmt.Sprintf("%d days ago", int(d.Hours()/24))
+}
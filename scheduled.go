@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"email-mcp-server/storage"
+)
+
+func (es *EmailServer) handleScheduleEmail(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("scheduled sending is not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	to, cc, bcc, err := es.recipientArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	email := &storage.ScheduledEmail{
+		AccountID: config.ID,
+		To:        to,
+		Cc:        cc,
+		Bcc:       bcc,
+	}
+	email.Subject, _ = args["subject"].(string)
+	email.Body, _ = args["body"].(string)
+	sendAt, _ := args["send_at"].(string)
+
+	if len(email.To) == 0 || email.Subject == "" || email.Body == "" || sendAt == "" {
+		return nil, invalidArgument("missing required parameters: to, subject, body, send_at")
+	}
+	email.Body = config.signed(email.Body)
+
+	if email.SendAt, err = parseSendAt(sendAt); err != nil {
+		return nil, err
+	}
+	if email.SendAt.Before(time.Now().Add(-time.Minute)) {
+		return nil, fmt.Errorf("send_at is in the past: %s", sendAt)
+	}
+
+	if err := es.db.CreateScheduled(email); err != nil {
+		return nil, err
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Email scheduled (ID: %d) to %s at %s",
+				email.ID, strings.Join(email.To, ", "), email.SendAt.Local().Format("2006-01-02 15:04 MST")),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleListScheduled(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("scheduled sending is not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	if accountID != "" {
+		config, err := es.getConfig(accountID)
+		if err != nil {
+			return nil, err
+		}
+		accountID = config.ID
+	}
+	includeDone, _ := args["include_done"].(bool)
+
+	emails, err := es.db.ListScheduled(accountID, includeDone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list scheduled emails: %w", err)
+	}
+
+	emailsJSON, _ := json.MarshalIndent(emails, "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("%d scheduled email(s):\n%s", len(emails), string(emailsJSON)),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleCancelScheduled(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("scheduled sending is not available: %w", errNoDatabase)
+	}
+
+	id, ok := args["id"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("%w: id", errMissingParameter)
+	}
+
+	if err := es.db.CancelScheduled(int64(id)); err != nil {
+		return nil, err
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Scheduled email %d cancelled", int64(id)),
+		}},
+	}, nil
+}
+
+// parseSendAt accepts RFC 3339 timestamps, or a date and time without zone
+// which is taken as server local time
+func parseSendAt(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02T15:04:05"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, invalidArgument("invalid send_at %q: use RFC 3339 or YYYY-MM-DD HH:MM", value)
+}
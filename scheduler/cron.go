@@ -0,0 +1,167 @@
+package scheduler
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a five-field cron expression: minute, hour, day of month, month
+// and day of week (0 or 7 is Sunday). Fields accept *, lists, ranges and
+// steps, e.g. "0 8 * * 1-5" or "*/30 9-17 * * *".
+type Schedule struct {
+	minute, hour, dom, month, dow uint64 // bit n set when value n matches
+	domAny, dowAny                bool
+}
+
+// ParseSchedule parses a cron expression
+func ParseSchedule(spec string) (*Schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields (minute hour day month weekday)", spec)
+	}
+
+	s := &Schedule{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	for i, f := range []struct {
+		bits     *uint64
+		min, max int
+	}{{&s.minute, 0, 59}, {&s.hour, 0, 23}, {&s.dom, 1, 31}, {&s.month, 1, 12}, {&s.dow, 0, 7}} {
+		bits, err := parseField(fields[i], f.min, f.max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+		*f.bits = bits
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+func parseField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			rangePart, step = part[:i], n
+		}
+
+		lo, hi := min, max
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			} else if step > 1 {
+				hi = max // "5/15" means from 5 to the end
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// Next returns the first time after t that matches the schedule, to the
+// minute, or the zero time if none does within five years
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches follows cron: when both day fields are restricted, either may
+// match
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// Job runs a function at every time matching a schedule
+type Job struct {
+	schedule *Schedule
+	run      func(at time.Time)
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewJob creates a job that calls run at each time matching schedule
+func NewJob(schedule *Schedule, run func(at time.Time)) *Job {
+	return &Job{schedule: schedule, run: run}
+}
+
+// Start runs the job in the background until Stop is called
+func (j *Job) Start() {
+	j.stop = make(chan struct{})
+	j.stopped = make(chan struct{})
+
+	go func() {
+		defer close(j.stopped)
+
+		for {
+			next := j.schedule.Next(time.Now())
+			if next.IsZero() {
+				log.Printf("Schedule never matches; job stopped")
+				return
+			}
+
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-timer.C:
+				j.run(next)
+			case <-j.stop:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the background loop and waits for a running call to finish
+func (j *Job) Stop() {
+	if j.stop == nil {
+		return
+	}
+	close(j.stop)
+	<-j.stopped
+	j.stop = nil
+}
@@ -0,0 +1,190 @@
+// Package scheduler sends queued messages once their scheduled time has
+// passed and wakes snoozed emails, retrying failures with exponential backoff.
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"email-mcp-server/mail"
+	"email-mcp-server/storage"
+)
+
+// Sender delivers a message from an account over SMTP, giving up when ctx
+// ends. It fills in the Message-ID when msg has none.
+type Sender func(ctx context.Context, accountID string, msg *mail.OutgoingMessage) error
+
+// Waker returns a snoozed email to its folder, giving up when ctx ends
+type Waker func(ctx context.Context, email *storage.SnoozedEmail) error
+
+// Dispatcher polls the database for due scheduled emails and sends them, and
+// for due snoozed emails when a Waker is set
+type Dispatcher struct {
+	db          *storage.Database
+	send        Sender
+	wake        Waker
+	interval    time.Duration
+	maxAttempts int
+	backoff     time.Duration
+
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+// NewDispatcher creates a dispatcher that checks for due messages every
+// interval and gives up on a message after maxAttempts failed sends. The
+// wait before retry n is backoff * 2^(n-1).
+func NewDispatcher(db *storage.Database, send Sender, interval time.Duration, maxAttempts int) *Dispatcher {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &Dispatcher{
+		db:          db,
+		send:        send,
+		interval:    interval,
+		maxAttempts: maxAttempts,
+		backoff:     time.Minute,
+	}
+}
+
+// SetWaker enables waking snoozed emails. Call it before Start.
+func (d *Dispatcher) SetWaker(wake Waker) {
+	d.wake = wake
+}
+
+// Start runs the dispatch loop in the background until Stop is called
+func (d *Dispatcher) Start() {
+	if d.interval <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+	d.stopped = make(chan struct{})
+
+	go func() {
+		defer close(d.stopped)
+
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+
+		for {
+			d.DispatchDue(ctx, time.Now())
+			d.WakeDue(ctx, time.Now())
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the background loop, aborting a running dispatch, and waits for
+// it to return
+func (d *Dispatcher) Stop() {
+	if d.cancel == nil {
+		return
+	}
+	d.cancel()
+	<-d.stopped
+	d.cancel = nil
+}
+
+// DispatchDue sends every message due at now and returns how many were sent
+func (d *Dispatcher) DispatchDue(ctx context.Context, now time.Time) int {
+	due, err := d.db.DueScheduled(now)
+	if err != nil {
+		log.Printf("Failed to load scheduled emails: %v", err)
+		return 0
+	}
+
+	sent := 0
+	for i := range due {
+		if ctx.Err() != nil {
+			// Stopped; the rest stay due for the next run
+			break
+		}
+		email := &due[i]
+		msg := &mail.OutgoingMessage{
+			To:      email.To,
+			Cc:      email.Cc,
+			Bcc:     email.Bcc,
+			Subject: email.Subject,
+			Body:    email.Body,
+		}
+		err := d.send(ctx, email.AccountID, msg)
+
+		email.Attempts++
+		if err == nil {
+			sentAt := time.Now()
+			email.Status = storage.ScheduledSent
+			email.SentAt = &sentAt
+			email.LastError = ""
+			email.MessageID = msg.MessageID
+			email.DeliveryStatus = storage.DeliverySent
+			sent++
+		} else {
+			email.LastError = err.Error()
+			if email.Attempts >= d.maxAttempts {
+				email.Status = storage.ScheduledFailed
+				log.Printf("Giving up on scheduled email %d after %d attempts: %v", email.ID, email.Attempts, err)
+			} else {
+				email.NextAttempt = now.Add(d.backoff << (email.Attempts - 1))
+				log.Printf("Scheduled email %d failed, retrying at %s: %v", email.ID, email.NextAttempt.Format(time.RFC3339), err)
+			}
+		}
+
+		if err := d.db.UpdateScheduled(email); err != nil {
+			log.Printf("Failed to update scheduled email %d: %v", email.ID, err)
+		}
+	}
+	return sent
+}
+
+// WakeDue wakes every snoozed email due at now and returns how many were woken
+func (d *Dispatcher) WakeDue(ctx context.Context, now time.Time) int {
+	if d.wake == nil {
+		return 0
+	}
+
+	due, err := d.db.DueSnoozed(now)
+	if err != nil {
+		log.Printf("Failed to load snoozed emails: %v", err)
+		return 0
+	}
+
+	woken := 0
+	for i := range due {
+		if ctx.Err() != nil {
+			// Stopped; the rest stay due for the next run
+			break
+		}
+		email := &due[i]
+		err := d.wake(ctx, email)
+
+		email.Attempts++
+		if err == nil {
+			wokenAt := time.Now()
+			email.Status = storage.SnoozeWoken
+			email.WokenAt = &wokenAt
+			email.LastError = ""
+			woken++
+		} else {
+			email.LastError = err.Error()
+			if email.Attempts >= d.maxAttempts {
+				email.Status = storage.SnoozeFailed
+				log.Printf("Giving up on snoozed email %d after %d attempts: %v", email.ID, email.Attempts, err)
+			} else {
+				email.NextAttempt = now.Add(d.backoff << (email.Attempts - 1))
+				log.Printf("Snoozed email %d could not be woken, retrying at %s: %v", email.ID, email.NextAttempt.Format(time.RFC3339), err)
+			}
+		}
+
+		if err := d.db.UpdateSnoozed(email); err != nil {
+			log.Printf("Failed to update snoozed email %d: %v", email.ID, err)
+		}
+	}
+	return woken
+}
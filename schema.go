@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Tool arguments are checked against the tool's InputSchema before its
+// handler runs, so a wrong type is reported instead of being read as a zero
+// value by the handler's type assertions. Only the parts of JSON Schema the
+// tools use are supported: type (a name or a list of names), enum, minimum,
+// maximum, items, properties and required. Properties a schema does not
+// declare are allowed, and null counts as leaving a property out.
+
+// ArgumentError is a tool argument that does not match the tool's schema.
+// Field is the path of the argument, such as "ids[2]" or
+// "attachments[0].filename".
+type ArgumentError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// InvalidParamsError lists the arguments of a tools/call that do not match
+// the tool's schema; it is answered with a -32602 error
+type InvalidParamsError struct {
+	Tool   string          `json:"tool"`
+	Errors []ArgumentError `json:"errors"`
+}
+
+func (e *InvalidParamsError) Error() string {
+	problems := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		problems[i] = err.Field + ": " + err.Reason
+	}
+	return fmt.Sprintf("Invalid params for %s: %s", e.Tool, strings.Join(problems, "; "))
+}
+
+// validateArgs checks the arguments of a call to tool against its input
+// schema, returning an *InvalidParamsError naming every offending field
+func validateArgs(tool string, schema interface{}, args map[string]interface{}) error {
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	var errs []ArgumentError
+	validateObject("", args, s, &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return &InvalidParamsError{Tool: tool, Errors: errs}
+}
+
+// validateValue appends the problems of value, at field, to errs
+func validateValue(field string, value interface{}, schema map[string]interface{}, errs *[]ArgumentError) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, ArgumentError{Field: field, Reason: fmt.Sprintf(format, args...)})
+	}
+
+	if types := schemaTypes(schema["type"]); len(types) > 0 && !slices.ContainsFunc(types, func(t string) bool { return hasType(value, t) }) {
+		fail("expected %s, got %s", strings.Join(types, " or "), jsonType(value))
+		return
+	}
+
+	if enum := schemaStrings(schema["enum"]); len(enum) > 0 {
+		if s, ok := value.(string); !ok || !slices.Contains(enum, s) {
+			fail("must be one of %s, got %v", strings.Join(enum, ", "), value)
+			return
+		}
+	}
+
+	switch v := value.(type) {
+	case float64:
+		if min, ok := schemaNumber(schema["minimum"]); ok && v < min {
+			fail("must be at least %s, got %s", formatNumber(min), formatNumber(v))
+		}
+		if max, ok := schemaNumber(schema["maximum"]); ok && v > max {
+			fail("must be at most %s, got %s", formatNumber(max), formatNumber(v))
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				validateValue(fmt.Sprintf("%s[%d]", field, i), item, items, errs)
+			}
+		}
+	case map[string]interface{}:
+		validateObject(field, v, schema, errs)
+	}
+}
+
+// validateObject checks the required and declared properties of an object
+func validateObject(field string, object map[string]interface{}, schema map[string]interface{}, errs *[]ArgumentError) {
+	path := func(name string) string {
+		if field == "" {
+			return name
+		}
+		return field + "." + name
+	}
+
+	for _, name := range schemaStrings(schema["required"]) {
+		if object[name] == nil {
+			*errs = append(*errs, ArgumentError{Field: path(name), Reason: "is required"})
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	// Errors come in a stable order
+	sort.Strings(names)
+	for _, name := range names {
+		property, ok := properties[name].(map[string]interface{})
+		if !ok || object[name] == nil {
+			continue
+		}
+		validateValue(path(name), object[name], property, errs)
+	}
+}
+
+// hasType reports whether a decoded JSON value is of the JSON Schema type t
+func hasType(value interface{}, t string) bool {
+	switch t {
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	default:
+		return jsonType(value) == t
+	}
+}
+
+// jsonType names the JSON type of a value decoded by encoding/json
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// schemaTypes reads "type", a name or a list of names
+func schemaTypes(v interface{}) []string {
+	if t, ok := v.(string); ok {
+		return []string{t}
+	}
+	return schemaStrings(v)
+}
+
+// schemaStrings reads a list of strings declared as []string or, in a schema
+// decoded from JSON, []interface{}
+func schemaStrings(v interface{}) []string {
+	switch list := v.(type) {
+	case []string:
+		return list
+	case []interface{}:
+		strs := make([]string, 0, len(list))
+		for _, item := range list {
+			strs = append(strs, fmt.Sprint(item))
+		}
+		return strs
+	}
+	return nil
+}
+
+// formatNumber writes n without an exponent, so IDs read as typed
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+// schemaNumber reads a number declared as any Go numeric type
+func schemaNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateArgs(t *testing.T) {
+	schema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"account": map[string]interface{}{"type": "string"},
+			"uid":     map[string]interface{}{"type": "integer", "minimum": 1},
+			"limit":   map[string]interface{}{"type": "number", "minimum": 1, "maximum": 100},
+			"mode":    map[string]interface{}{"type": "string", "enum": []string{"read", "unread"}},
+			"id":      map[string]interface{}{"type": []string{"string", "integer"}},
+			"ids":     map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "integer"}},
+			"attachments": map[string]interface{}{
+				"type": "array",
+				"items": map[string]interface{}{
+					"type":       "object",
+					"properties": map[string]interface{}{"filename": map[string]interface{}{"type": "string"}},
+					"required":   []string{"filename"},
+				},
+			},
+		},
+		"required": []string{"account", "uid"},
+	}
+
+	tests := []struct {
+		name string
+		args string
+		want []ArgumentError
+	}{
+		{"valid", `{"account": "work", "uid": 6, "limit": 10, "mode": "read"}`, nil},
+		{"unknown property", `{"account": "work", "uid": 6, "folder": "INBOX"}`, nil},
+		{"null leaves a property out", `{"account": "work", "uid": 6, "limit": null}`, nil},
+		{"missing required", `{"account": "work"}`, []ArgumentError{{"uid", "is required"}}},
+		{"null required", `{"account": null, "uid": 6}`, []ArgumentError{{"account", "is required"}}},
+		{"wrong type", `{"account": 3, "uid": 6}`, []ArgumentError{{"account", "expected string, got number"}}},
+		{"integer with a fraction", `{"account": "work", "uid": 6.5}`, []ArgumentError{{"uid", "expected integer, got number"}}},
+		{"integer as string", `{"account": "work", "uid": "6"}`, []ArgumentError{{"uid", "expected integer, got string"}}},
+		{"below minimum", `{"account": "work", "uid": 0}`, []ArgumentError{{"uid", "must be at least 1, got 0"}}},
+		{"above maximum", `{"account": "work", "uid": 6, "limit": 500}`, []ArgumentError{{"limit", "must be at most 100, got 500"}}},
+		{"enum", `{"account": "work", "uid": 6, "mode": "all"}`, []ArgumentError{{"mode", "must be one of read, unread, got all"}}},
+		{"type list", `{"account": "work", "uid": 6, "id": 42}`, nil},
+		{"type list mismatch", `{"account": "work", "uid": 6, "id": true}`, []ArgumentError{{"id", "expected string or integer, got boolean"}}},
+		{"array items", `{"account": "work", "uid": 6, "ids": [1, "2", 3]}`, []ArgumentError{{"ids[1]", "expected integer, got string"}}},
+		{"nested objects", `{"account": "work", "uid": 6, "attachments": [{"filename": "a.pdf"}, {"filename": 7}, {}]}`, []ArgumentError{
+			{"attachments[1].filename", "expected string, got number"},
+			{"attachments[2].filename", "is required"},
+		}},
+		{"every problem in field order", `{"uid": "x", "mode": "all", "limit": 0}`, []ArgumentError{
+			{"account", "is required"},
+			{"limit", "must be at least 1, got 0"},
+			{"mode", "must be one of read, unread, got all"},
+			{"uid", "expected integer, got string"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.args), &args); err != nil {
+				t.Fatal(err)
+			}
+			err := validateArgs("get_email", schema, args)
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("validateArgs() = %v, want nil", err)
+				}
+				return
+			}
+			var invalid *InvalidParamsError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("validateArgs() = %v, want an *InvalidParamsError", err)
+			}
+			if invalid.Tool != "get_email" || !reflect.DeepEqual(invalid.Errors, tt.want) {
+				t.Errorf("validateArgs() = %+v, want %+v", invalid.Errors, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateArgsSchemaFromJSON(t *testing.T) {
+	// Schemas decoded from JSON hold their lists as []interface{} and their
+	// numbers as float64
+	var schema interface{}
+	if err := json.Unmarshal([]byte(`{
+		"type": "object",
+		"properties": {"priority": {"type": "string", "enum": ["low", "high"]}, "days": {"type": "integer", "maximum": 30}},
+		"required": ["priority"]
+	}`), &schema); err != nil {
+		t.Fatal(err)
+	}
+	err := validateArgs("set_priority", schema, map[string]interface{}{"priority": "urgent", "days": float64(31)})
+	var invalid *InvalidParamsError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("validateArgs() = %v, want an *InvalidParamsError", err)
+	}
+	want := []ArgumentError{
+		{"days", "must be at most 30, got 31"},
+		{"priority", "must be one of low, high, got urgent"},
+	}
+	if !reflect.DeepEqual(invalid.Errors, want) {
+		t.Errorf("validateArgs() = %+v, want %+v", invalid.Errors, want)
+	}
+	if got := invalid.Error(); got != "Invalid params for set_priority: days: must be at most 30, got 31; priority: must be one of low, high, got urgent" {
+		t.Errorf("Error() = %q", got)
+	}
+}
+
+func TestValidateArgsEmailIDs(t *testing.T) {
+	// An email ID out of the UID range or with a fraction would wrap or be
+	// truncated to another message's UID by uint32()
+	schemas := (&EmailServer{}).registerTools().schemas
+	tests := []struct {
+		tool string
+		args map[string]interface{}
+		want string
+	}{
+		{"delete_email", map[string]interface{}{"id": float64(6)}, ""},
+		{"delete_email", map[string]interface{}{"id": float64(4294967295)}, ""},
+		{"delete_email", map[string]interface{}{"id": float64(4294967297)}, "id: must be at most 4294967295, got 4294967297"},
+		{"delete_email", map[string]interface{}{"id": float64(-1)}, "id: must be at least 1, got -1"},
+		{"delete_email", map[string]interface{}{"id": float64(0)}, "id: must be at least 1, got 0"},
+		{"delete_email", map[string]interface{}{"id": 1.5}, "id: expected integer, got number"},
+		{"bulk_action", map[string]interface{}{"action": "mark_read", "ids": []interface{}{float64(1), 2.5, float64(1 << 32)}}, "ids[1]: expected integer, got number; ids[2]: must be at most 4294967295, got 4294967296"},
+		{"send_draft", map[string]interface{}{"draft_id": 0.5}, "draft_id: expected integer, got number"},
+	}
+	for _, tt := range tests {
+		err := validateArgs(tt.tool, schemas[tt.tool], tt.args)
+		got := ""
+		if err != nil {
+			_, got, _ = strings.Cut(err.Error(), tt.tool+": ")
+		}
+		if got != tt.want {
+			t.Errorf("%s %v: got %q, want %q", tt.tool, tt.args, got, tt.want)
+		}
+	}
+}
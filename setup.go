@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"email-mcp-server/autoconfig"
+	"email-mcp-server/config"
+	"email-mcp-server/credentials"
+)
+
+// prompter asks questions on the terminal
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// ask returns the answer to question, def when it is empty
+func (p *prompter) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	line, err := p.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", fmt.Errorf("setup needs answers on stdin: %w", err)
+	}
+	if line = strings.TrimSpace(line); line != "" {
+		return line, nil
+	}
+	return def, nil
+}
+
+// require asks until the answer is not empty
+func (p *prompter) require(question, def string) (string, error) {
+	for {
+		answer, err := p.ask(question, def)
+		if err != nil || answer != "" {
+			return answer, err
+		}
+	}
+}
+
+func (p *prompter) confirm(question string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	answer, err := p.ask(question+" ("+hint+")", "")
+	if err != nil || answer == "" {
+		return def, err
+	}
+	return strings.HasPrefix(strings.ToLower(answer), "y"), nil
+}
+
+// number asks for a number between 1 and max
+func (p *prompter) number(question string, def, max int) (int, error) {
+	for {
+		answer, err := p.ask(question, strconv.Itoa(def))
+		if err != nil {
+			return 0, err
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= max {
+			return n, nil
+		}
+		fmt.Fprintf(p.out, "Enter a number from 1 to %d\n", max)
+	}
+}
+
+// secret asks without echoing the answer where stty can turn echo off
+func (p *prompter) secret(question string) (string, error) {
+	if stty("-echo") != nil {
+		return p.require(question, "")
+	}
+	defer func() {
+		stty("echo")
+		fmt.Fprintln(p.out)
+	}()
+	return p.require(question, "")
+}
+
+// stty changes the settings of the terminal on stdin; it fails on Windows
+// and when stdin is not a terminal
+func stty(setting string) error {
+	cmd := exec.Command("stty", setting)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
+
+// setupCommand implements `email-mcp-server setup`: it asks for an account,
+// tests it, adds it to email_config.json and writes priority_rules.json and
+// ai_config.json from the examples when they do not exist
+func setupCommand(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("usage: email-mcp-server setup")
+	}
+	p := &prompter{in: bufio.NewReader(os.Stdin), out: os.Stdout}
+
+	path := accountsPath()
+	var configs []EmailConfig
+	if _, err := os.Stat(path); err == nil {
+		existing, _, err := loadAccounts(path)
+		if err != nil {
+			return fmt.Errorf("%v\nfix %s, or run email-mcp-server doctor, before adding an account", err, path)
+		}
+		configs = existing
+		fmt.Printf("%s has %d accounts; this adds another.\n", path, len(configs))
+	}
+
+	account, err := setupAccount(p, configs)
+	if err != nil {
+		return err
+	}
+	if account != nil {
+		if err := saveSetupAccount(p, path, account, configs); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range []struct {
+		path, example string
+		defaults      interface{}
+	}{
+		{rulesPath(), "priority_rules.example.json", config.DefaultRules()},
+		{aiConfigPath(), "ai_config.example.json", config.DefaultAIConfig()},
+	} {
+		created, err := scaffoldConfig(file.path, file.example, file.defaults)
+		if err != nil {
+			return err
+		}
+		if created {
+			fmt.Printf("Created %s, edit it to adjust the defaults\n", file.path)
+		}
+	}
+
+	binary, err := os.Executable()
+	if err != nil {
+		binary = "email-mcp-server"
+	}
+	command, _ := json.Marshal(binary)
+	fmt.Printf("\nNext steps:\n- Check everything with %s doctor\n", binary)
+	fmt.Println("- Add the server to your MCP client, e.g. in claude_desktop_config.json:")
+	fmt.Printf("  \"email\": {\"command\": %s, \"args\": [], \"env\": {}}\n", command)
+	return nil
+}
+
+// setupAccount asks for the settings of an account and tests them. It is
+// nil when the user gave up on an account that failed its test.
+func setupAccount(p *prompter, existing []EmailConfig) (*EmailConfig, error) {
+	c := &EmailConfig{UseStartTLS: true}
+	var err error
+	if c.Username, err = p.require("Email address", ""); err != nil {
+		return nil, err
+	}
+
+	settings, err := chooseServers(p, c.Username)
+	if err != nil {
+		return nil, err
+	}
+	c.IMAPHost, c.IMAPPort, c.SMTPHost, c.SMTPPort = settings.IMAPHost, settings.IMAPPort, settings.SMTPHost, settings.SMTPPort
+	if c.IMAPHost == "" {
+		if err := askServers(p, c); err != nil {
+			return nil, err
+		}
+	}
+
+	_, domain, _ := strings.Cut(c.Username, "@")
+	id, _, _ := strings.Cut(domain, ".")
+	for {
+		if c.ID, err = p.require("Account ID, the name tools use for it", strings.ToLower(id)); err != nil {
+			return nil, err
+		}
+		if !slices.ContainsFunc(existing, func(e EmailConfig) bool { return e.ID == c.ID }) {
+			break
+		}
+		fmt.Printf("Account %s already exists\n", c.ID)
+	}
+	if c.DisplayName, err = p.ask("Display name for sent mail (optional)", ""); err != nil {
+		return nil, err
+	}
+
+	if settings.Note != "" {
+		fmt.Println(settings.Note)
+	}
+	for {
+		if c.Password, err = p.secret("Password"); err != nil {
+			return nil, err
+		}
+		fmt.Println("Testing the connection...")
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		result := testAccount(ctx, c)
+		cancel()
+		if result.Succeeded {
+			fmt.Println("IMAP and SMTP logins work")
+			return c, nil
+		}
+		fmt.Printf("IMAP: %s\nSMTP: %s\n", result.IMAP, result.SMTP)
+		if err := result.imapErr; err != nil {
+			fmt.Println("Fix: " + loginFix(c, serverIMAP, classifyLoginError(err)))
+		} else {
+			fmt.Println("Fix: " + loginFix(c, serverSMTP, classifyLoginError(result.smtpErr)))
+		}
+
+		retry, err := p.confirm("Try another password", true)
+		if err != nil {
+			return nil, err
+		}
+		if retry {
+			continue
+		}
+		if settings.IMAPHost != "" {
+			if again, err := p.confirm("Change the servers", false); err != nil {
+				return nil, err
+			} else if again {
+				if err := askServers(p, c); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+		save, err := p.confirm("Save the account anyway", false)
+		if err != nil || !save {
+			return nil, err
+		}
+		return c, nil
+	}
+}
+
+// chooseServers looks up the servers of an address and offers them, else
+// the presets. Its hosts are empty when the user picks Other.
+func chooseServers(p *prompter, address string) (*autoconfig.Settings, error) {
+	fmt.Println("Looking up the servers of " + address + "...")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	found, err := (&autoconfig.Discoverer{}).Discover(ctx, address)
+	cancel()
+	if err == nil {
+		name := found.Provider
+		if name == "" {
+			name = "the domain"
+		}
+		fmt.Printf("Found the servers of %s by %s lookup: %s\n", name, found.Source, found)
+		use, err := p.confirm("Use them", true)
+		if err != nil || use {
+			return found, err
+		}
+	} else {
+		fmt.Println(err)
+	}
+
+	fmt.Println("Providers:")
+	for i, preset := range autoconfig.Presets {
+		fmt.Printf("  %d. %s\n", i+1, preset.Provider)
+	}
+	other := len(autoconfig.Presets) + 1
+	fmt.Printf("  %d. Other\n", other)
+	choice, err := p.number("Provider", other, other)
+	if err != nil || choice == other {
+		return &autoconfig.Settings{IMAPPort: 993, SMTPPort: 587}, err
+	}
+	return &autoconfig.Presets[choice-1].Settings, nil
+}
+
+// askServers asks for the hosts and ports of an account
+func askServers(p *prompter, c *EmailConfig) error {
+	var err error
+	if c.IMAPHost, err = p.require("IMAP host", c.IMAPHost); err != nil {
+		return err
+	}
+	if c.IMAPPort, err = p.number("IMAP port (993 uses TLS, others STARTTLS)", c.IMAPPort, 65535); err != nil {
+		return err
+	}
+	if c.SMTPHost, err = p.require("SMTP host", c.SMTPHost); err != nil {
+		return err
+	}
+	c.SMTPPort, err = p.number("SMTP port (465 uses TLS, others STARTTLS)", c.SMTPPort, 65535)
+	return err
+}
+
+// saveSetupAccount stores the password where the user picks and adds the
+// account to email_config.json
+func saveSetupAccount(p *prompter, path string, c *EmailConfig, configs []EmailConfig) error {
+	sources := []string{credentials.SourceKeyring, credentials.SourceFile, credentials.SourcePlain}
+	fmt.Println("Where should the password be kept?")
+	fmt.Println("  1. keyring: the system keychain (recommended)")
+	fmt.Println("  2. file: an encrypted file, needs CREDENTIALS_PASSPHRASE")
+	fmt.Println("  3. plain: in email_config.json")
+	for {
+		choice, err := p.number("Password storage", 1, len(sources))
+		if err != nil {
+			return err
+		}
+		c.PasswordSource = sources[choice-1]
+		if c.PasswordSource == credentials.SourcePlain {
+			c.PasswordSource = ""
+			break
+		}
+		store, err := c.credentialStore()
+		if err == nil {
+			err = store.Set(c.ID, c.Password)
+		}
+		if err == nil {
+			break
+		}
+		fmt.Printf("Failed to store the password: %v\n", err)
+	}
+
+	if problems := c.validate(); len(problems) > 0 {
+		return fmt.Errorf("invalid account: %s", strings.Join(problems, "; "))
+	}
+	if err := saveAccounts(path, append(configs, *c)); err != nil {
+		return err
+	}
+	fmt.Printf("Account %s saved to %s\n", c.ID, path)
+	return nil
+}
+
+// scaffoldConfig creates path from the example, found like .env, or else
+// from defaults, unless path exists. It reports whether it created it.
+func scaffoldConfig(path, example string, defaults interface{}) (bool, error) {
+	if _, err := os.Stat(path); err == nil {
+		return false, nil
+	}
+	data, err := os.ReadFile(findFile(example))
+	if err != nil {
+		if data, err = json.MarshalIndent(defaults, "", "  "); err != nil {
+			return false, err
+		}
+		data = append(data, '\n')
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return false, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return false, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newPrompter returns a prompter answering with the lines of answers
+func newPrompter(answers ...string) *prompter {
+	return &prompter{in: bufio.NewReader(strings.NewReader(strings.Join(answers, "\n") + "\n")), out: io.Discard}
+}
+
+func TestPrompter(t *testing.T) {
+	p := newPrompter("", "  work  ", "", "me@corp.com", "yes", "", "0", "abc", "3")
+	if answer, _ := p.ask("Folder", "INBOX"); answer != "INBOX" {
+		t.Errorf("ask with an empty answer = %q, want the default", answer)
+	}
+	if answer, _ := p.ask("Account", ""); answer != "work" {
+		t.Errorf("ask = %q, want the trimmed answer", answer)
+	}
+	if answer, _ := p.require("Email address", ""); answer != "me@corp.com" {
+		t.Errorf("require = %q, want it to ask again after an empty answer", answer)
+	}
+	if ok, _ := p.confirm("Use them", false); !ok {
+		t.Error("confirm(yes) = false")
+	}
+	if ok, _ := p.confirm("Use them", true); !ok {
+		t.Error("confirm with an empty answer did not take the default")
+	}
+	if n, _ := p.number("Provider", 1, 5); n != 3 {
+		t.Errorf("number = %d, want 3 after two invalid answers", n)
+	}
+	if _, err := p.ask("More", ""); err == nil {
+		t.Error("ask after the answers ran out succeeded")
+	}
+}
+
+func TestAskServers(t *testing.T) {
+	c := &EmailConfig{IMAPPort: 993, SMTPPort: 587}
+	if err := askServers(newPrompter("imap.corp.com", "", "smtp.corp.com", "465"), c); err != nil {
+		t.Fatalf("askServers: %v", err)
+	}
+	if c.IMAPHost != "imap.corp.com" || c.IMAPPort != 993 || c.SMTPHost != "smtp.corp.com" || c.SMTPPort != 465 {
+		t.Errorf("askServers = %+v", c)
+	}
+}
+
+func TestSaveSetupAccount(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "email_config.json")
+	existing := []EmailConfig{{ID: "home", Username: "me@home.com", Password: "secret",
+		IMAPHost: "imap.home.com", IMAPPort: 993, SMTPHost: "smtp.home.com", SMTPPort: 587}}
+	c := &EmailConfig{ID: "work", Username: "me@corp.com", Password: "hunter2",
+		IMAPHost: "imap.corp.com", IMAPPort: 993, SMTPHost: "smtp.corp.com", SMTPPort: 587}
+
+	// Choice 3 keeps the password in the file
+	if err := saveSetupAccount(newPrompter("3"), path, c, existing); err != nil {
+		t.Fatalf("saveSetupAccount: %v", err)
+	}
+	configs, _, err := loadAccounts(path)
+	if err != nil {
+		t.Fatalf("loadAccounts: %v", err)
+	}
+	if len(configs) != 2 || configs[0].ID != "home" || configs[1].ID != "work" || configs[1].Password != "hunter2" {
+		t.Errorf("saved accounts = %+v", configs)
+	}
+
+	invalid := &EmailConfig{ID: "bad", Username: "me@bad.com", Password: "x", IMAPPort: 993, SMTPPort: 587}
+	if err := saveSetupAccount(newPrompter("3"), path, invalid, configs); err == nil {
+		t.Error("saveSetupAccount of an account without hosts succeeded")
+	}
+}
+
+func TestScaffoldConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config", "ai_config.json")
+
+	created, err := scaffoldConfig(path, "no_such.example.json", map[string]interface{}{"provider": "none"})
+	if err != nil || !created {
+		t.Fatalf("scaffoldConfig = %v, %v; want the file created", created, err)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != "{\n  \"provider\": \"none\"\n}\n" {
+		t.Errorf("scaffolded from defaults = %q", data)
+	}
+
+	os.WriteFile(path, []byte("{}"), 0o600)
+	if created, err := scaffoldConfig(path, "no_such.example.json", nil); err != nil || created {
+		t.Errorf("scaffoldConfig of an existing file = %v, %v; want it left alone", created, err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "{}" {
+		t.Errorf("existing file overwritten with %q", data)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"email-mcp-server/mail"
+)
+
+// fakeSMTP is an SMTP server that accepts every message and records the
+// commands it receives
+type fakeSMTP struct {
+	extensions []string
+
+	mu       sync.Mutex
+	commands []string
+	data     string
+}
+
+// startFakeSMTP serves SMTP on listener, advertising extensions
+func startFakeSMTP(t *testing.T, listener net.Listener, extensions ...string) *fakeSMTP {
+	t.Helper()
+	s := &fakeSMTP{extensions: extensions}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 fake ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, line)
+		s.mu.Unlock()
+
+		verb, _, _ := strings.Cut(strings.ToUpper(line), " ")
+		switch verb {
+		case "EHLO":
+			// The first line greets, the rest list the extensions
+			reply := append([]string{"fake"}, s.extensions...)
+			for i, ext := range reply {
+				sep := "-"
+				if i == len(reply)-1 {
+					sep = " "
+				}
+				text.PrintfLine("250%s%s", sep, ext)
+			}
+		case "MAIL", "RCPT", "RSET", "NOOP", "HELO":
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 Go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.data = string(data)
+			s.mu.Unlock()
+			text.PrintfLine("250 Queued")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("502 Not implemented")
+		}
+	}
+}
+
+// received returns the commands and the message received so far
+func (s *fakeSMTP) received() ([]string, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.commands), s.data
+}
+
+// smtpAccount returns an account sending through listener
+func smtpAccount(listener net.Listener) *EmailConfig {
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	smtpPort, _ := strconv.Atoi(port)
+	return &EmailConfig{ID: "work", Username: "me@corp.com", SMTPHost: host, SMTPPort: smtpPort}
+}
+
+var testMessage = []byte("Subject: Hi\r\n\r\nHello\r\n")
+
+func TestSendSMTPWithDSN(t *testing.T) {
+	es := newTestServer(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	server := startFakeSMTP(t, listener, "DSN", "8BITMIME")
+	config := smtpAccount(listener)
+	config.SMTPHelloName = "mail.corp.com"
+
+	msg := &mail.OutgoingMessage{To: []string{"bo@example.com"}, Cc: []string{"cy+news@example.com"},
+		MessageID: "<abc@corp.com>", Notify: []string{mail.NotifyFailure, mail.NotifyDelay}}
+	if err := es.sendSMTP(context.Background(), config, msg, testMessage); err != nil {
+		t.Fatalf("sendSMTP: %v", err)
+	}
+
+	commands, data := server.received()
+	for _, want := range []string{
+		"EHLO mail.corp.com",
+		"MAIL FROM:<me@corp.com> RET=HDRS ENVID=<abc@corp.com> BODY=8BITMIME",
+		"RCPT TO:<bo@example.com> NOTIFY=FAILURE,DELAY ORCPT=rfc822;bo@example.com",
+		"RCPT TO:<cy+news@example.com> NOTIFY=FAILURE,DELAY ORCPT=rfc822;cy+2Bnews@example.com",
+	} {
+		if !slices.Contains(commands, want) {
+			t.Errorf("commands = %q, want %q among them", commands, want)
+		}
+	}
+	if data != "Subject: Hi\n\nHello\n" {
+		t.Errorf("message = %q", data)
+	}
+}
+
+func TestSendSMTPWithoutDSN(t *testing.T) {
+	es := newTestServer(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	server := startFakeSMTP(t, listener)
+
+	// Notifications are dropped when the server does not offer DSN
+	msg := &mail.OutgoingMessage{To: []string{"bo@example.com"}, Notify: []string{mail.NotifySuccess}}
+	if err := es.sendSMTP(context.Background(), smtpAccount(listener), msg, testMessage); err != nil {
+		t.Fatalf("sendSMTP: %v", err)
+	}
+	commands, _ := server.received()
+	if !slices.Contains(commands, "MAIL FROM:<me@corp.com>") || !slices.Contains(commands, "RCPT TO:<bo@example.com>") {
+		t.Errorf("commands = %q, want MAIL and RCPT without DSN parameters", commands)
+	}
+}
+
+func TestSendSMTPImplicitTLS(t *testing.T) {
+	// Implicit TLS is chosen by the port
+	listener, err := net.Listen("tcp", "127.0.0.1:465")
+	if err != nil {
+		t.Skipf("port 465 is not available: %v", err)
+	}
+	certs, caFile := testCertificate(t)
+	server := startFakeSMTP(t, tls.NewListener(listener, &tls.Config{Certificates: certs}))
+	es := newTestServer(t)
+	config := smtpAccount(listener)
+	config.TLSCAFile = caFile
+
+	msg := &mail.OutgoingMessage{To: []string{"bo@example.com"}}
+	if err := es.sendSMTP(context.Background(), config, msg, testMessage); err != nil {
+		t.Fatalf("sendSMTP over implicit TLS: %v", err)
+	}
+	if commands, _ := server.received(); !slices.Contains(commands, "RCPT TO:<bo@example.com>") {
+		t.Errorf("commands = %q", commands)
+	}
+
+	// Without trusting the certificate the handshake fails
+	config.TLSCAFile = ""
+	err = es.sendSMTP(context.Background(), config, msg, testMessage)
+	if err == nil {
+		t.Fatal("sendSMTP to an untrusted certificate succeeded")
+	}
+	if code, _ := classifyError(err); code != errCodeConnection {
+		t.Errorf("untrusted certificate classified as %s, want %s", code, errCodeConnection)
+	}
+}
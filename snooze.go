@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+
+	"email-mcp-server/storage"
+)
+
+// Snoozed emails wait in SNOOZE_FOLDER. The scheduler's dispatcher wakes them
+// through wakeSnoozed: the email is flagged, marked unread and moved back, so
+// sync picks it up as new mail.
+
+func (es *EmailServer) handleSnoozeEmail(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("snoozing is not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	if folder == "" {
+		folder = "INBOX"
+	}
+	id, ok := args["id"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("%w: id", errMissingParameter)
+	}
+	until, _ := args["until"].(string)
+	if until == "" {
+		return nil, fmt.Errorf("%w: until", errMissingParameter)
+	}
+
+	wakeAt, err := parseLaterTime("until", until, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !wakeAt.After(time.Now()) {
+		return nil, fmt.Errorf("until is in the past: %s", until)
+	}
+
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	snoozed, err := es.snoozeEmail(ctx, config.ID, folder, uint32(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to snooze email: %w", err)
+	}
+	snoozed.Until = wakeAt
+
+	if err := es.db.CreateSnoozed(snoozed); err != nil {
+		// Without a record nothing would bring the email back
+		if wakeErr := es.wakeSnoozed(ctx, snoozed); wakeErr != nil {
+			log.Printf("Failed to return email %s to %s: %v", snoozed.MessageID, folder, wakeErr)
+		}
+		return nil, err
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Email %d (%s) snoozed until %s (snooze ID: %d); it waits in %s",
+				uint32(id), snoozed.Subject, wakeAt.Local().Format("2006-01-02 15:04 MST"), snoozed.ID, snoozed.SnoozeFolder),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleListSnoozed(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("snoozing is not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	if accountID != "" {
+		config, err := es.getConfig(accountID)
+		if err != nil {
+			return nil, err
+		}
+		accountID = config.ID
+	}
+	includeDone, _ := args["include_done"].(bool)
+
+	emails, err := es.db.ListSnoozed(accountID, includeDone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snoozed emails: %w", err)
+	}
+
+	emailsJSON, _ := json.MarshalIndent(emails, "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("%d snoozed email(s):\n%s", len(emails), string(emailsJSON)),
+		}},
+	}, nil
+}
+
+// snoozeEmail moves a message to the snooze folder, creating it if needed.
+// The move changes the UID, so the Message-ID is kept to find it again.
+func (es *EmailServer) snoozeEmail(ctx context.Context, accountID, folder string, uid uint32) (*storage.SnoozedEmail, error) {
+	c, err := es.connectIMAP(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	if _, err := selectFolder(c, folder, false); err != nil {
+		return nil, err
+	}
+
+	uidset := new(imap.SeqSet)
+	uidset.AddNum(uid)
+
+	messages := make(chan *imap.Message, 1)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- c.UidFetch(uidset, []imap.FetchItem{imap.FetchEnvelope, imap.FetchUid}, messages)
+	}()
+
+	var envelope *imap.Envelope
+	for msg := range messages {
+		envelope = msg.Envelope
+	}
+
+	if err := <-done; err != nil {
+		return nil, err
+	}
+	if envelope == nil {
+		return nil, fmt.Errorf("email with ID %d %w", uid, errNotFound)
+	}
+	if envelope.MessageId == "" {
+		return nil, fmt.Errorf("email %d has no Message-ID, so it could not be found again after moving", uid)
+	}
+
+	snoozeFolder := getEnv("SNOOZE_FOLDER", "Snoozed")
+	if strings.EqualFold(folder, snoozeFolder) {
+		return nil, fmt.Errorf("email %d is already in %s", uid, snoozeFolder)
+	}
+	if err := ensureFolder(c, snoozeFolder); err != nil {
+		return nil, err
+	}
+	if err := moveMessage(c, folder, uid, snoozeFolder); err != nil {
+		return nil, err
+	}
+	es.forgetEmails(accountID, folder, uid)
+
+	return &storage.SnoozedEmail{
+		AccountID:    accountID,
+		Folder:       folder,
+		SnoozeFolder: snoozeFolder,
+		MessageID:    envelope.MessageId,
+		From:         firstAddress(envelope.From).String(),
+		Subject:      envelope.Subject,
+	}, nil
+}
+
+// wakeSnoozed returns a snoozed email to its folder, flagged and unread so it
+// stands out, and notifies subscribers of that folder
+func (es *EmailServer) wakeSnoozed(ctx context.Context, email *storage.SnoozedEmail) error {
+	c, err := es.connectIMAP(ctx, email.AccountID)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if _, err := selectFolder(c, email.SnoozeFolder, false); err != nil {
+		return err
+	}
+
+	criteria := imap.NewSearchCriteria()
+	criteria.Header.Add("Message-Id", email.MessageID)
+	uids, err := c.UidSearch(criteria)
+	if err != nil {
+		return fmt.Errorf("failed to search %s: %w", email.SnoozeFolder, err)
+	}
+	if len(uids) == 0 {
+		return fmt.Errorf("email %s is no longer in %s", email.MessageID, email.SnoozeFolder)
+	}
+
+	uidset := new(imap.SeqSet)
+	uidset.AddNum(uids...)
+
+	if err := c.UidStore(uidset, imap.FormatFlagsOp(imap.AddFlags, true), []interface{}{imap.FlaggedFlag}, nil); err != nil {
+		return fmt.Errorf("failed to update flags: %w", err)
+	}
+	if err := c.UidStore(uidset, imap.FormatFlagsOp(imap.RemoveFlags, true), []interface{}{imap.SeenFlag}, nil); err != nil {
+		return fmt.Errorf("failed to update flags: %w", err)
+	}
+	if err := c.UidMove(uidset, email.Folder); err != nil {
+		return fmt.Errorf("failed to move email to %s: %w", email.Folder, err)
+	}
+	es.forgetEmails(email.AccountID, email.SnoozeFolder, uids...)
+
+	log.Printf("Snoozed email %q is back in %s", email.Subject, email.Folder)
+	es.notifyNewMail(email.AccountID, email.Folder, len(uids))
+	return nil
+}
+
+// ensureFolder creates a mailbox unless it already exists
+func ensureFolder(c *client.Client, name string) error {
+	mailboxes := make(chan *imap.MailboxInfo, 10)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- c.List("", name, mailboxes)
+	}()
+
+	exists := false
+	for range mailboxes {
+		exists = true
+	}
+
+	if err := <-done; err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	if err := c.Create(name); err != nil {
+		return fmt.Errorf("failed to create folder %s: %w", name, err)
+	}
+	return nil
+}
+
+// parseLaterTime reads the param argument in the formats of send_at, or as a
+// duration from now such as 45m, 3h or 2d
+func parseLaterTime(param, value string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n > 0 {
+			return now.AddDate(0, 0, n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(d), nil
+	}
+	if t, err := parseSendAt(value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, invalidArgument("invalid %s %q: use a duration such as 3h or 2d, RFC 3339 or YYYY-MM-DD HH:MM", param, value)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+// Starred emails carry the \Flagged flag, which email clients show as a star
+// or pin. star_email and unstar_email change it on the server and in the
+// local database at once, rescoring the priority of emails already scored,
+// and sync brings in the stars set elsewhere: through CONDSTORE where the
+// server has it, else by searching for the flagged messages of each synced
+// folder. priority_inbox and local_search can then list only the starred
+// emails, and priority_inbox can pin them above the others.
+
+func (es *EmailServer) handleStarEmail(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	return es.handleChangeStar(ctx, args, true)
+}
+
+func (es *EmailServer) handleUnstarEmail(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	return es.handleChangeStar(ctx, args, false)
+}
+
+func (es *EmailServer) handleChangeStar(ctx context.Context, args map[string]interface{}, starred bool) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	if folder == "" {
+		folder = "INBOX"
+	}
+	id, ok := args["id"].(float64)
+	if !ok {
+		return nil, errInvalidEmailID
+	}
+	uid := uint32(id)
+
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+	add, remove, verb := []string{imap.FlaggedFlag}, []string(nil), "starred"
+	if !starred {
+		add, remove, verb = nil, add, "unstarred"
+	}
+	if err := es.setFlags(ctx, config.ID, folder, uid, add, remove); err != nil {
+		return nil, fmt.Errorf("failed to set flags: %w", err)
+	}
+	es.updateStarred(ctx, config.ID, folder, uid, starred)
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Email ID %d %s", uid, verb),
+		}},
+	}, nil
+}
+
+// updateStarred records the new star of an email in the local database and
+// rescores its priority when it has one, logging failures: the next sync
+// corrects them
+func (es *EmailServer) updateStarred(ctx context.Context, accountID, folder string, uid uint32, starred bool) {
+	if es.db == nil {
+		return
+	}
+	if err := es.db.SetStarred(accountID, folder, []uint32{uid}, starred); err != nil {
+		log.Printf("Failed to store the star of %s/%s/%d: %v", accountID, folder, uid, err)
+		return
+	}
+	if priority, err := es.db.GetPriority(accountID, folder, uid); err != nil || priority == nil {
+		return
+	}
+	email, err := es.db.GetEmail(accountID, folder, uid)
+	if err != nil {
+		return
+	}
+	if _, err := es.scorePriority(ctx, email, time.Now()); err != nil {
+		log.Print(err)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"email-mcp-server/storage"
+)
+
+func (es *EmailServer) handleInboxStats(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("statistics are not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	days := 30
+	if d, ok := args["days"].(float64); ok && d >= 1 {
+		days = int(d)
+	}
+
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := es.db.MailboxStats(config.ID, []string{config.Username}, days, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute statistics: %w", err)
+	}
+
+	statsJSON, _ := json.MarshalIndent(stats, "", "  ")
+	return ToolResult{
+		Content: []TextContent{
+			{Type: "text", Text: formatInboxStats(stats, days)},
+			{Type: "text", Text: string(statsJSON)},
+		},
+	}, nil
+}
+
+func formatInboxStats(stats *storage.MailboxStats, days int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "📊 Mailbox statistics for %s, last %d days (since %s)\n\n", stats.AccountID, days, stats.Since.Format("2 Jan 2006"))
+
+	fmt.Fprintf(&b, "Received: %d (%.1f per day), sent: %d, still unread: %d\n",
+		stats.Received, float64(stats.Received)/float64(len(stats.Daily)), stats.Sent, stats.Unread)
+	var busiest storage.VolumeCount
+	for _, day := range stats.Daily {
+		if day.Received > busiest.Received {
+			busiest = day
+		}
+	}
+	if busiest.Received > 0 {
+		fmt.Fprintf(&b, "Busiest day: %s (%d received)\n", busiest.Date, busiest.Received)
+	}
+	if n := len(stats.Weekly); n >= 2 {
+		this, last := stats.Weekly[n-1], stats.Weekly[n-2]
+		fmt.Fprintf(&b, "This week: %d received (%+d vs last week), %d still unread (%+d)\n",
+			this.Received, this.Received-last.Received, this.Unread, this.Unread-last.Unread)
+	}
+
+	if len(stats.Categories) > 0 {
+		b.WriteString("\nBy category:\n")
+		for _, c := range stats.Categories {
+			fmt.Fprintf(&b, "- %s: %d\n", c.Category, c.Count)
+		}
+		if stats.Unclassified > 0 {
+			fmt.Fprintf(&b, "- (not classified): %d\n", stats.Unclassified)
+		}
+	}
+
+	if len(stats.TopSenders) > 0 {
+		b.WriteString("\nTop senders:\n")
+		for i, s := range stats.TopSenders {
+			if i == 5 {
+				break
+			}
+			fmt.Fprintf(&b, "- %s: %d\n", s.Sender, s.Count)
+		}
+	}
+
+	if stats.YourResponse != nil || stats.TheirResponse != nil {
+		b.WriteString("\nResponse time:\n")
+		if r := stats.YourResponse; r != nil {
+			fmt.Fprintf(&b, "- You: %.1fh on average, %.1fh median (%d replies)\n", r.AverageHours, r.MedianHours, r.Replies)
+		}
+		if r := stats.TheirResponse; r != nil {
+			fmt.Fprintf(&b, "- Others, on tracked follow-ups: %.1fh on average, %.1fh median (%d replies)\n", r.AverageHours, r.MedianHours, r.Replies)
+		}
+	}
+
+	fmt.Fprintf(&b, "\nStorage: %d synced emails, %s\n", stats.TotalEmails, formatBytes(stats.TotalBytes))
+	for _, f := range stats.Folders {
+		fmt.Fprintf(&b, "- %s: %d emails (%d unread), %s\n", f.Folder, f.Emails, f.Unread, formatBytes(f.Bytes))
+	}
+
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
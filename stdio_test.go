@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadMessage(t *testing.T) {
+	// Lines are read whole past bufio's buffer and the 64KB limit of
+	// bufio.Scanner, and one over the cap is skipped without losing the next
+	long := strings.Repeat("x", 100*1024)
+	r := bufio.NewReader(strings.NewReader(long + "\n" + strings.Repeat("y", 200*1024) + "\nshort\nlast"))
+
+	for _, want := range []struct {
+		line string
+		err  error
+	}{
+		{long + "\n", nil},
+		{"", errMessageTooLarge},
+		{"short\n", nil},
+		{"last", nil},
+		{"", io.EOF},
+	} {
+		line, err := readMessage(r, 150*1024)
+		if string(line) != want.line || err != want.err {
+			t.Fatalf("readMessage = %.20q (%d bytes), %v; want %.20q, %v", line, len(line), err, want.line, want.err)
+		}
+	}
+}
+
+// captureStdout returns what f writes to stdout
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+
+	f()
+	os.Stdout = stdout
+	w.Close()
+	return <-output
+}
+
+func TestServeStdio(t *testing.T) {
+	t.Setenv("MAX_MESSAGE_SIZE", "150000")
+	es := newTestServer(t)
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"tools/list","params":{"pad":"` + strings.Repeat("x", 100*1024) + `"}}`,
+		strings.Repeat("z", 200*1024),
+		`{not json`,
+		`[{"jsonrpc":"2.0","id":2,"method":"tools/list"},{"jsonrpc":"2.0","method":"notifications/initialized"}]`,
+		`[]`,
+		`[{"jsonrpc":"2.0","method":"notifications/initialized"}]`,
+		`[1,2`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/list"}`,
+	}, "\n")
+
+	output := captureStdout(t, func() {
+		lines := make(chan []byte, 64)
+		go es.readMessages(strings.NewReader(input), lines, func() {})
+		newRequestDispatcher(context.Background(), es).serve(lines)
+	})
+
+	var ids []float64
+	var errorCodes []int
+	var batches [][]MCPResponse
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if strings.HasPrefix(line, "[") {
+			var batch []MCPResponse
+			if err := json.Unmarshal([]byte(line), &batch); err != nil {
+				t.Fatalf("invalid batch reply %s: %v", line, err)
+			}
+			batches = append(batches, batch)
+			continue
+		}
+		var resp MCPResponse
+		if err := json.Unmarshal([]byte(line), &resp); err != nil {
+			t.Fatalf("invalid reply %.100s: %v", line, err)
+		}
+		if resp.Error != nil {
+			if resp.ID != nil {
+				t.Errorf("error reply %s has an ID", line)
+			}
+			errorCodes = append(errorCodes, resp.Error.Code)
+			continue
+		}
+		ids = append(ids, resp.ID.(float64))
+	}
+
+	slices.Sort(ids)
+	if !slices.Equal(ids, []float64{1, 3}) {
+		t.Errorf("replies to requests %v, want 1 and 3", ids)
+	}
+	// Too large, not JSON, empty batch, batch not JSON
+	slices.Sort(errorCodes)
+	if !slices.Equal(errorCodes, []int{-32700, -32700, -32600, -32600}) {
+		t.Errorf("error codes %v, want two -32700 and two -32600", errorCodes)
+	}
+	if len(batches) != 1 || len(batches[0]) != 1 || batches[0][0].ID != float64(2) {
+		t.Errorf("batch replies %+v, want one holding the reply to request 2", batches)
+	}
+}
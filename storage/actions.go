@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ActionItem is a stored action item of a synced email
+type ActionItem struct {
+	ID          int64      `json:"id"`
+	AccountID   string     `json:"account_id"`
+	Folder      string     `json:"folder"`
+	UID         uint32     `json:"uid"`
+	Action      string     `json:"action"`
+	Owner       string     `json:"owner,omitempty"`
+	Due         *time.Time `json:"due,omitempty"`
+	RequestedBy string     `json:"requested_by,omitempty"`
+	Method      string     `json:"method"` // "llm" or "rules"
+	ExtractedAt time.Time  `json:"extracted_at"`
+}
+
+func (d *Database) initActionItems() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS action_items (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		account_id TEXT NOT NULL,
+		folder TEXT NOT NULL,
+		uid INTEGER NOT NULL,
+		action TEXT NOT NULL,
+		owner TEXT,
+		due DATETIME,
+		requested_by TEXT,
+		method TEXT NOT NULL,
+		extracted_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_action_items_email ON action_items(account_id, folder, uid);`
+
+	if _, err := d.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to initialize action items: %w", err)
+	}
+	return nil
+}
+
+// SaveActionItems replaces the action items stored for an email. An empty
+// list records that the email asks for nothing.
+func (d *Database) SaveActionItems(accountID, folder string, uid uint32, items []ActionItem) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to save action items: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM action_items WHERE account_id = ? AND folder = ? AND uid = ?`, accountID, folder, uid); err != nil {
+		return fmt.Errorf("failed to save action items: %w", err)
+	}
+	now := time.Now().UTC()
+	for i := range items {
+		item := &items[i]
+		item.AccountID, item.Folder, item.UID, item.ExtractedAt = accountID, folder, uid, now
+
+		var due sql.NullTime
+		if item.Due != nil {
+			due = sql.NullTime{Time: item.Due.UTC(), Valid: true}
+		}
+		err := tx.QueryRow(`
+			INSERT INTO action_items (account_id, folder, uid, action, owner, due, requested_by, method, extracted_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+			accountID, folder, uid, item.Action, item.Owner, due, item.RequestedBy, item.Method, now).Scan(&item.ID)
+		if err != nil {
+			return fmt.Errorf("failed to save action items: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// ActionItems returns the stored action items of an email, in the order they
+// were found
+func (d *Database) ActionItems(accountID, folder string, uid uint32) ([]ActionItem, error) {
+	rows, err := d.db.Query(`
+		SELECT id, action, owner, due, requested_by, method, extracted_at FROM action_items
+		WHERE account_id = ? AND folder = ? AND uid = ? ORDER BY id`, accountID, folder, uid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query action items: %w", err)
+	}
+	defer rows.Close()
+
+	var items []ActionItem
+	for rows.Next() {
+		item := ActionItem{AccountID: accountID, Folder: folder, UID: uid}
+		var owner, requestedBy sql.NullString
+		var due sql.NullTime
+		if err := rows.Scan(&item.ID, &item.Action, &owner, &due, &requestedBy, &item.Method, &item.ExtractedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan action item: %w", err)
+		}
+		item.Owner, item.RequestedBy = owner.String, requestedBy.String
+		if due.Valid {
+			item.Due = &due.Time
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// ActionItemCounts returns the number of stored action items of each email of
+// a folder that has any, by UID
+func (d *Database) ActionItemCounts(accountID, folder string) (map[uint32]int, error) {
+	rows, err := d.db.Query(`
+		SELECT uid, COUNT(*) FROM action_items WHERE account_id = ? AND folder = ? GROUP BY uid`, accountID, folder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count action items: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[uint32]int)
+	for rows.Next() {
+		var uid uint32
+		var count int
+		if err := rows.Scan(&uid, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan action item count: %w", err)
+		}
+		counts[uid] = count
+	}
+	return counts, rows.Err()
+}
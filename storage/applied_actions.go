@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// AppliedAction records an action rule applied to a synced email, or the
+// action it would have applied in a dry run. Each rule acts on an email
+// once; once an email was moved away, no rule considers it again.
+type AppliedAction struct {
+	ID          int64     `json:"id"`
+	AccountID   string    `json:"account_id"`
+	Folder      string    `json:"folder"`
+	UID         uint32    `json:"uid"`
+	MessageID   string    `json:"message_id,omitempty"`
+	From        string    `json:"from,omitempty"`
+	Subject     string    `json:"subject,omitempty"`
+	Rule        string    `json:"rule"`
+	Action      string    `json:"action"`
+	Destination string    `json:"destination,omitempty"`
+	DryRun      bool      `json:"dry_run"`
+	AppliedAt   time.Time `json:"applied_at"`
+}
+
+// ActionCandidate is a synced email an action rule may apply to, with its
+// stored category, empty when it was never classified
+type ActionCandidate struct {
+	Email
+	Category string `json:"category,omitempty"`
+}
+
+func (d *Database) initAppliedActions() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS applied_actions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		account_id TEXT NOT NULL,
+		folder TEXT NOT NULL,
+		uid INTEGER NOT NULL,
+		message_id TEXT,
+		sender TEXT,
+		subject TEXT,
+		rule TEXT NOT NULL,
+		action TEXT NOT NULL,
+		destination TEXT,
+		dry_run BOOLEAN NOT NULL,
+		applied_at DATETIME NOT NULL,
+		UNIQUE(account_id, folder, uid, rule, dry_run)
+	);
+	CREATE INDEX IF NOT EXISTS idx_applied_actions_time ON applied_actions(account_id, applied_at);`
+
+	if _, err := d.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to initialize applied actions: %w", err)
+	}
+	return nil
+}
+
+// ActionCandidates returns the synced emails of a folder dated before before
+// (no bound when zero), oldest first, that rule has not acted on yet (in a
+// dry run when dryRun is set) and that no rule has moved away
+func (d *Database) ActionCandidates(accountID, folder, rule string, dryRun bool, before time.Time) ([]ActionCandidate, error) {
+	if before.IsZero() {
+		before = time.Now().AddDate(100, 0, 0)
+	}
+	rows, err := d.db.Query(`
+		SELECT `+emailColumns+`, c.category FROM emails
+		LEFT JOIN classifications c ON c.account_id = emails.account_id AND c.folder = emails.folder AND c.uid = emails.uid
+		WHERE emails.account_id = ? AND emails.folder = ? AND emails.date < ? AND NOT EXISTS (
+			SELECT 1 FROM applied_actions a
+			WHERE a.account_id = emails.account_id AND a.folder = emails.folder AND a.uid = emails.uid
+				AND ((a.rule = ? AND a.dry_run = ?) OR (NOT a.dry_run AND a.action IN ('archive', 'delete', 'move_to'))))
+		ORDER BY emails.date`,
+		accountID, folder, before.UTC(), rule, dryRun)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query action candidates: %w", err)
+	}
+	defer rows.Close()
+
+	var candidates []ActionCandidate
+	for rows.Next() {
+		var category sql.NullString
+		e, err := scanEmail(rows, &category)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, ActionCandidate{Email: *e, Category: category.String})
+	}
+	return candidates, rows.Err()
+}
+
+// RecordAppliedActions adds actions to the audit table, setting their IDs
+func (d *Database) RecordAppliedActions(actions []AppliedAction) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := range actions {
+		a := &actions[i]
+		if a.AppliedAt.IsZero() {
+			a.AppliedAt = time.Now().UTC()
+		}
+		err := tx.QueryRow(`
+			INSERT INTO applied_actions (account_id, folder, uid, message_id, sender, subject, rule, action, destination, dry_run, applied_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(account_id, folder, uid, rule, dry_run) DO UPDATE SET applied_at = excluded.applied_at
+			RETURNING id`,
+			a.AccountID, a.Folder, a.UID, a.MessageID, a.From, a.Subject, a.Rule, a.Action, a.Destination, a.DryRun, a.AppliedAt).
+			Scan(&a.ID)
+		if err != nil {
+			return fmt.Errorf("failed to record applied action: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// ListAppliedActions returns the most recent applied actions of an account,
+// optionally of one rule and applied since a time (no bound when zero). An
+// empty accountID lists every account.
+func (d *Database) ListAppliedActions(accountID, rule string, since time.Time, limit int) ([]AppliedAction, error) {
+	query := `SELECT id, account_id, folder, uid, message_id, sender, subject, rule, action, destination, dry_run, applied_at
+		FROM applied_actions WHERE 1 = 1`
+	var args []interface{}
+	if accountID != "" {
+		query += ` AND account_id = ?`
+		args = append(args, accountID)
+	}
+	if rule != "" {
+		query += ` AND rule = ?`
+		args = append(args, rule)
+	}
+	if !since.IsZero() {
+		query += ` AND applied_at >= ?`
+		args = append(args, since.UTC())
+	}
+	query += ` ORDER BY applied_at DESC, id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query applied actions: %w", err)
+	}
+	defer rows.Close()
+
+	var actions []AppliedAction
+	for rows.Next() {
+		var a AppliedAction
+		var messageID, sender, subject, destination sql.NullString
+		if err := rows.Scan(&a.ID, &a.AccountID, &a.Folder, &a.UID, &messageID, &sender, &subject, &a.Rule, &a.Action,
+			&destination, &a.DryRun, &a.AppliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan applied action: %w", err)
+		}
+		a.MessageID, a.From, a.Subject, a.Destination = messageID.String, sender.String, subject.String, destination.String
+		actions = append(actions, a)
+	}
+	return actions, rows.Err()
+}
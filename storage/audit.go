@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// AuditEntry records one operation that changed mail, accounts or settings:
+// who asked for it, what it was called with and how it ended. Actor is the
+// MCP client for tool calls, or the background job (action rule,
+// autoresponder, scheduler) that acted on its own.
+type AuditEntry struct {
+	ID         int64           `json:"id"`
+	Time       time.Time       `json:"time"`
+	Actor      string          `json:"actor"`
+	Operation  string          `json:"operation"`
+	AccountID  string          `json:"account_id,omitempty"`
+	Arguments  json.RawMessage `json:"arguments,omitempty"`
+	Success    bool            `json:"success"`
+	Result     string          `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	DurationMs int64           `json:"duration_ms"`
+}
+
+// AuditFilter narrows ListAudit. Zero values are ignored.
+type AuditFilter struct {
+	AccountID  string
+	Operation  string
+	Actor      string
+	Since      time.Time
+	ErrorsOnly bool
+	Limit      int
+}
+
+func (d *Database) initAudit() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		time DATETIME NOT NULL,
+		actor TEXT NOT NULL,
+		operation TEXT NOT NULL,
+		account_id TEXT,
+		arguments TEXT,
+		success BOOLEAN NOT NULL,
+		result TEXT,
+		error TEXT,
+		duration_ms INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_audit_log_time ON audit_log(time);`
+
+	if _, err := d.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to initialize audit log: %w", err)
+	}
+	return nil
+}
+
+// RecordAudit appends e to the audit log, setting its ID
+func (d *Database) RecordAudit(e *AuditEntry) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	var arguments sql.NullString
+	if len(e.Arguments) > 0 {
+		arguments = sql.NullString{String: string(e.Arguments), Valid: true}
+	}
+	err := d.db.QueryRow(`
+		INSERT INTO audit_log (time, actor, operation, account_id, arguments, success, result, error, duration_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id`,
+		e.Time.UTC(), e.Actor, e.Operation, e.AccountID, arguments, e.Success, e.Result, e.Error, e.DurationMs).
+		Scan(&e.ID)
+	if err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	return nil
+}
+
+// ListAudit returns the most recent audit entries matching filter, newest
+// first
+func (d *Database) ListAudit(filter AuditFilter) ([]AuditEntry, error) {
+	query := `SELECT id, time, actor, operation, account_id, arguments, success, result, error, duration_ms
+		FROM audit_log WHERE 1 = 1`
+	var args []interface{}
+	if filter.AccountID != "" {
+		query += ` AND account_id = ?`
+		args = append(args, filter.AccountID)
+	}
+	if filter.Operation != "" {
+		query += ` AND operation = ?`
+		args = append(args, filter.Operation)
+	}
+	if filter.Actor != "" {
+		query += ` AND actor LIKE ?`
+		args = append(args, "%"+filter.Actor+"%")
+	}
+	if !filter.Since.IsZero() {
+		query += ` AND time >= ?`
+		args = append(args, filter.Since.UTC())
+	}
+	if filter.ErrorsOnly {
+		query += ` AND NOT success`
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+	query += ` ORDER BY time DESC, id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		var accountID, arguments, result, errText sql.NullString
+		if err := rows.Scan(&e.ID, &e.Time, &e.Actor, &e.Operation, &accountID, &arguments, &e.Success,
+			&result, &errText, &e.DurationMs); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		e.AccountID, e.Result, e.Error = accountID.String, result.String, errText.String
+		if arguments.Valid {
+			e.Arguments = json.RawMessage(arguments.String)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// PruneAudit deletes the audit entries recorded before t and returns how
+// many were removed
+func (d *Database) PruneAudit(before time.Time) (int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	res, err := d.db.Exec(`DELETE FROM audit_log WHERE time < ?`, before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune audit log: %w", err)
+	}
+	return res.RowsAffected()
+}
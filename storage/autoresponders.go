@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultAutoreplyIntervalDays is how long a sender waits for a second
+// automatic reply when the autoresponder does not say
+const DefaultAutoreplyIntervalDays = 7
+
+// Autoresponder answers new inbound mail of an account, e.g. while on
+// vacation. Its subject and body may use the placeholders of a Template;
+// with Template set, that saved template is used instead.
+type Autoresponder struct {
+	AccountID    string     `json:"account_id"`
+	Enabled      bool       `json:"enabled"`
+	Subject      string     `json:"subject,omitempty"`
+	Body         string     `json:"body,omitempty"`
+	Template     string     `json:"template,omitempty"`
+	IntervalDays int        `json:"interval_days"`
+	MatchFrom    string     `json:"match_from,omitempty"`    // Only senders whose address contains this
+	MatchSubject string     `json:"match_subject,omitempty"` // Only subjects containing this
+	Start        time.Time  `json:"start"`                   // Mail dated before is not answered
+	Until        *time.Time `json:"until,omitempty"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// Active reports whether the autoresponder answers mail dated at t
+func (a *Autoresponder) Active(t time.Time) bool {
+	return a.Enabled && !t.Before(a.Start) && (a.Until == nil || t.Before(*a.Until))
+}
+
+// Matches reports whether email meets the sender and subject criteria
+func (a *Autoresponder) Matches(email *Email) bool {
+	if a.MatchFrom != "" && !strings.Contains(strings.ToLower(email.FromAddress.Address), strings.ToLower(a.MatchFrom)) {
+		return false
+	}
+	return a.MatchSubject == "" || strings.Contains(strings.ToLower(email.Subject), strings.ToLower(a.MatchSubject))
+}
+
+// Interval is the time between two replies to the same sender
+func (a *Autoresponder) Interval() time.Duration {
+	days := a.IntervalDays
+	if days < 1 {
+		days = DefaultAutoreplyIntervalDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+func (d *Database) initAutoresponders() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS autoresponders (
+		account_id TEXT PRIMARY KEY,
+		enabled BOOLEAN NOT NULL,
+		subject TEXT,
+		body TEXT,
+		template TEXT,
+		interval_days INTEGER NOT NULL,
+		match_from TEXT,
+		match_subject TEXT,
+		start_at DATETIME NOT NULL,
+		until DATETIME,
+		updated_at DATETIME NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS autoreplies (
+		account_id TEXT NOT NULL,
+		sender TEXT NOT NULL,
+		message_id TEXT,
+		replied_at DATETIME NOT NULL,
+		PRIMARY KEY (account_id, sender)
+	);`
+
+	if _, err := d.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to initialize autoresponders: %w", err)
+	}
+	return nil
+}
+
+// SaveAutoresponder creates or replaces the autoresponder of a.AccountID
+func (d *Database) SaveAutoresponder(a *Autoresponder) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	a.UpdatedAt = time.Now().UTC()
+	var until sql.NullTime
+	if a.Until != nil {
+		until = sql.NullTime{Time: a.Until.UTC(), Valid: true}
+	}
+
+	_, err := d.db.Exec(`
+		INSERT OR REPLACE INTO autoresponders
+			(account_id, enabled, subject, body, template, interval_days, match_from, match_subject, start_at, until, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.AccountID, a.Enabled, a.Subject, a.Body, a.Template, a.IntervalDays, a.MatchFrom, a.MatchSubject,
+		a.Start.UTC(), until, a.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save autoresponder: %w", err)
+	}
+	return nil
+}
+
+// GetAutoresponder returns the autoresponder of an account, or nil when it
+// never had one
+func (d *Database) GetAutoresponder(accountID string) (*Autoresponder, error) {
+	var a Autoresponder
+	var subject, body, template, matchFrom, matchSubject sql.NullString
+	var until sql.NullTime
+	err := d.db.QueryRow(`
+		SELECT account_id, enabled, subject, body, template, interval_days, match_from, match_subject, start_at, until, updated_at
+		FROM autoresponders WHERE account_id = ?`, accountID).
+		Scan(&a.AccountID, &a.Enabled, &subject, &body, &template, &a.IntervalDays, &matchFrom, &matchSubject,
+			&a.Start, &until, &a.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query autoresponder: %w", err)
+	}
+
+	a.Subject, a.Body, a.Template = subject.String, body.String, template.String
+	a.MatchFrom, a.MatchSubject = matchFrom.String, matchSubject.String
+	if until.Valid {
+		a.Until = &until.Time
+	}
+	return &a, nil
+}
+
+// SetAutoresponderEnabled turns the autoresponder of an account on or off,
+// keeping its settings
+func (d *Database) SetAutoresponderEnabled(accountID string, enabled bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	res, err := d.db.Exec(`UPDATE autoresponders SET enabled = ?, updated_at = ? WHERE account_id = ?`,
+		enabled, time.Now().UTC(), accountID)
+	if err != nil {
+		return fmt.Errorf("failed to update autoresponder: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("account %s has no autoresponder", accountID)
+	}
+	return nil
+}
+
+// LastAutoreply returns when sender was last answered automatically, zero if
+// never
+func (d *Database) LastAutoreply(accountID, sender string) (time.Time, error) {
+	var repliedAt time.Time
+	err := d.db.QueryRow(`SELECT replied_at FROM autoreplies WHERE account_id = ? AND sender = ?`,
+		accountID, strings.ToLower(sender)).Scan(&repliedAt)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to query autoreplies: %w", err)
+	}
+	return repliedAt, nil
+}
+
+// RecordAutoreply notes that sender was answered with messageID at t
+func (d *Database) RecordAutoreply(accountID, sender, messageID string, t time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec(`
+		INSERT INTO autoreplies (account_id, sender, message_id, replied_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(account_id, sender) DO UPDATE SET message_id = excluded.message_id, replied_at = excluded.replied_at`,
+		accountID, strings.ToLower(sender), messageID, t.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record autoreply: %w", err)
+	}
+	return nil
+}
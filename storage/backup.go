@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+
+	sqlite "modernc.org/sqlite"
+)
+
+// Backup writes a consistent copy of the database to path, which must not
+// exist, while the database stays in use. A plain database is copied with
+// SQLite's online backup API; an encrypted one is written encrypted under
+// the same passphrase.
+func (d *Database) Backup(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+
+	if d.enc != nil {
+		var image []byte
+		err := d.enc.pin.Raw(func(dc interface{}) error {
+			var err error
+			image, err = dc.(sqliteConn).Serialize()
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to serialize database: %w", err)
+		}
+		return writeEncrypted(path, d.enc.salt, d.enc.aead, image)
+	}
+
+	conn, err := d.db.Conn(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close()
+	err = conn.Raw(func(dc interface{}) error {
+		return runBackup(dc.(sqliteConn).NewBackup("file:" + path))
+	})
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+
+	// The copy keeps the WAL flag of the source, which an encrypted database
+	// restored from it could not open in memory
+	copied, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer copied.Close()
+	if _, err := copied.Exec(`PRAGMA journal_mode=DELETE`); err != nil {
+		return fmt.Errorf("failed to finish backup: %w", err)
+	}
+	return nil
+}
+
+// Restore replaces the whole content of the database with the copy at path
+// written by Backup, then applies the schema of this version. An encrypted
+// copy can only be restored into an encrypted database.
+func (d *Database) Restore(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	d.mu.Lock()
+	if bytes.HasPrefix(data, []byte(encryptedMagic)) {
+		err = d.restoreEncrypted(data)
+	} else {
+		err = d.restoreFile(path)
+	}
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := d.initSchema(); err != nil {
+		return err
+	}
+	return d.Flush()
+}
+
+func (d *Database) restoreEncrypted(data []byte) error {
+	if d.enc == nil {
+		return fmt.Errorf("the backup is encrypted: enable database encryption with the passphrase it was made with to restore it")
+	}
+	_, image, err := decryptImage(data, d.enc.passphrase)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt backup: %w", err)
+	}
+	if err := restoreImage(image, d.enc.uri); err != nil {
+		return fmt.Errorf("failed to restore database: %w", err)
+	}
+	return nil
+}
+
+// restoreFile copies a plain database file over the database
+func (d *Database) restoreFile(path string) error {
+	var conn *sql.Conn
+	if d.enc != nil {
+		conn = d.enc.pin
+	} else {
+		var err error
+		if conn, err = d.db.Conn(context.Background()); err != nil {
+			return fmt.Errorf("failed to connect to database: %w", err)
+		}
+		defer conn.Close()
+	}
+	err := conn.Raw(func(dc interface{}) error {
+		return runBackup(dc.(sqliteConn).NewRestore("file:" + path + "?mode=ro"))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore database: %w", err)
+	}
+	return nil
+}
+
+// runBackup copies every page of a backup or restore
+func runBackup(backup *sqlite.Backup, err error) error {
+	if err != nil {
+		return err
+	}
+	for {
+		more, err := backup.Step(-1)
+		if err != nil {
+			backup.Finish()
+			return err
+		}
+		if !more {
+			break
+		}
+	}
+	return backup.Finish()
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"email-mcp-server/mail"
+)
+
+// BouncedAddress is a recipient that bounced, with its latest bounce
+type BouncedAddress struct {
+	Recipient   string    `json:"recipient"`
+	Bounces     int       `json:"bounces"`
+	Permanent   bool      `json:"permanent"` // The latest bounce was a failure, not a delay
+	Action      string    `json:"action"`
+	Status      string    `json:"status,omitempty"`
+	Diagnostic  string    `json:"diagnostic,omitempty"`
+	LastBounced time.Time `json:"last_bounced"`
+	Folder      string    `json:"folder"`
+	UID         uint32    `json:"uid"` // The latest bounce message
+}
+
+func (d *Database) initBounces() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS bounces (
+		account_id TEXT NOT NULL,
+		folder TEXT NOT NULL,
+		uid INTEGER NOT NULL,
+		recipient TEXT NOT NULL,
+		action TEXT NOT NULL,
+		status TEXT,
+		diagnostic TEXT,
+		original_message_id TEXT,
+		permanent INTEGER NOT NULL,
+		date DATETIME NOT NULL,
+		PRIMARY KEY(account_id, folder, uid, recipient)
+	);
+	CREATE INDEX IF NOT EXISTS idx_bounces_recipient ON bounces(account_id, recipient, date);`
+
+	if _, err := d.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to initialize bounces: %w", err)
+	}
+	return nil
+}
+
+// SaveBounces stores the recipients a bounce message reports on, replacing
+// the ones stored for it before
+func (d *Database) SaveBounces(accountID, folder string, uid uint32, date time.Time, bounces []mail.Bounce) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to save bounces: %w", err)
+	}
+	defer tx.Rollback()
+	db := prepared{d: d, tx: tx}
+
+	if _, err := db.Exec(`DELETE FROM bounces WHERE account_id = ? AND folder = ? AND uid = ?`, accountID, folder, uid); err != nil {
+		return fmt.Errorf("failed to save bounces: %w", err)
+	}
+	for _, b := range bounces {
+		_, err := db.Exec(`
+			INSERT OR REPLACE INTO bounces (account_id, folder, uid, recipient, action, status, diagnostic, original_message_id, permanent, date)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			accountID, folder, uid, b.Recipient, b.Action, b.Status, b.Diagnostic, b.OriginalMessageID, b.Permanent(), date.UTC())
+		if err != nil {
+			return fmt.Errorf("failed to save bounces: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// BouncedAddresses returns the recipients of an account that bounced since
+// a time, most recently bounced first, each with its latest bounce
+func (d *Database) BouncedAddresses(accountID string, since time.Time, limit int) ([]BouncedAddress, error) {
+	rows, err := d.db.Query(`
+		SELECT recipient, bounces, permanent, action, status, diagnostic, date, folder, uid FROM (
+			SELECT *, COUNT(*) OVER (PARTITION BY recipient) AS bounces,
+				ROW_NUMBER() OVER (PARTITION BY recipient ORDER BY date DESC) AS latest
+			FROM bounces WHERE account_id = ? AND date >= ?)
+		WHERE latest = 1 ORDER BY date DESC LIMIT ?`, accountID, since.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query bounces: %w", err)
+	}
+	defer rows.Close()
+
+	var addresses []BouncedAddress
+	for rows.Next() {
+		var a BouncedAddress
+		var status, diagnostic sql.NullString
+		if err := rows.Scan(&a.Recipient, &a.Bounces, &a.Permanent, &a.Action, &status, &diagnostic, &a.LastBounced, &a.Folder, &a.UID); err != nil {
+			return nil, fmt.Errorf("failed to scan bounce: %w", err)
+		}
+		a.Status, a.Diagnostic = status.String, diagnostic.String
+		addresses = append(addresses, a)
+	}
+	return addresses, rows.Err()
+}
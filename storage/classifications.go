@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Classification is the stored category of an email
+type Classification struct {
+	AccountID    string    `json:"account_id"`
+	Folder       string    `json:"folder"`
+	UID          uint32    `json:"uid"`
+	MessageID    string    `json:"message_id,omitempty"`
+	Category     string    `json:"category"`
+	Confidence   float64   `json:"confidence"`
+	Rule         string    `json:"rule,omitempty"`
+	Tags         []string  `json:"tags,omitempty"`
+	Method       string    `json:"method"`
+	Reasoning    string    `json:"reasoning,omitempty"`
+	ClassifiedAt time.Time `json:"classified_at"`
+}
+
+func (d *Database) initClassifications() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS classifications (
+		account_id TEXT NOT NULL,
+		folder TEXT NOT NULL,
+		uid INTEGER NOT NULL,
+		message_id TEXT,
+		category TEXT NOT NULL,
+		confidence REAL NOT NULL,
+		tags TEXT,
+		method TEXT NOT NULL,
+		reasoning TEXT,
+		classified_at DATETIME NOT NULL,
+		PRIMARY KEY(account_id, folder, uid)
+	);
+	CREATE INDEX IF NOT EXISTS idx_classifications_category ON classifications(account_id, category);`
+
+	if _, err := d.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to initialize classifications: %w", err)
+	}
+	if err := d.addColumn("classifications", "rule TEXT"); err != nil {
+		return err
+	}
+	return d.initFeedback()
+}
+
+// SaveClassification stores the classification of an email, replacing any
+// previous one
+func (d *Database) SaveClassification(c *Classification) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Label tags mirror the mail server, not the classifier, so a new
+	// classification keeps the stored ones
+	var stored sql.NullString
+	err := d.db.QueryRow(`SELECT tags FROM classifications WHERE account_id = ? AND folder = ? AND uid = ?`,
+		c.AccountID, c.Folder, c.UID).Scan(&stored)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read tags: %w", err)
+	}
+	previous, err := decodeTags(stored)
+	if err != nil {
+		return err
+	}
+	tags, err := encodeTags(withLabelTags(c.Tags, onlyLabelTags(previous)))
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.Exec(`
+		INSERT INTO classifications (account_id, folder, uid, message_id, category, confidence, rule, tags, method, reasoning, classified_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(account_id, folder, uid) DO UPDATE SET
+			message_id = excluded.message_id, category = excluded.category, confidence = excluded.confidence,
+			rule = excluded.rule, tags = excluded.tags, method = excluded.method, reasoning = excluded.reasoning,
+			classified_at = excluded.classified_at`,
+		c.AccountID, c.Folder, c.UID, c.MessageID, c.Category, c.Confidence, c.Rule, tags, c.Method,
+		c.Reasoning, c.ClassifiedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save classification: %w", err)
+	}
+	return nil
+}
+
+// GetClassification returns the stored classification of an email, or nil if
+// it was never classified
+func (d *Database) GetClassification(accountID, folder string, uid uint32) (*Classification, error) {
+	c := &Classification{AccountID: accountID, Folder: folder, UID: uid}
+	var messageID, rule, tags, reasoning sql.NullString
+
+	err := d.db.QueryRow(`
+		SELECT message_id, category, confidence, rule, tags, method, reasoning, classified_at
+		FROM classifications WHERE account_id = ? AND folder = ? AND uid = ?`, accountID, folder, uid).
+		Scan(&messageID, &c.Category, &c.Confidence, &rule, &tags, &c.Method, &reasoning, &c.ClassifiedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read classification: %w", err)
+	}
+
+	c.MessageID = messageID.String
+	c.Rule = rule.String
+	c.Reasoning = reasoning.String
+	if c.Tags, err = decodeTags(tags); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ReviewItem is a synced email whose classification awaits review
+type ReviewItem struct {
+	Email          Email          `json:"email"`
+	Classification Classification `json:"classification"`
+}
+
+// ReviewQueue returns the synced emails of an account whose classification
+// carries tag, least confident first, and how many there are in total
+func (d *Database) ReviewQueue(accountID, tag string, limit int) ([]ReviewItem, int, error) {
+	const filter = `FROM classifications c
+		JOIN emails ON emails.account_id = c.account_id AND emails.folder = c.folder AND emails.uid = c.uid
+		WHERE c.account_id = ? AND EXISTS (SELECT 1 FROM json_each(c.tags) WHERE value = ?)`
+
+	var total int
+	if err := d.db.QueryRow(`SELECT COUNT(*) `+filter, accountID, tag).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count the review queue: %w", err)
+	}
+
+	rows, err := d.db.Query(`
+		SELECT `+emailColumns+`, c.message_id, c.category, c.confidence, c.rule, c.tags, c.method, c.reasoning, c.classified_at
+		`+filter+` ORDER BY c.confidence, emails.date DESC LIMIT ?`, accountID, tag, limit)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query the review queue: %w", err)
+	}
+	defer rows.Close()
+
+	var items []ReviewItem
+	for rows.Next() {
+		var item ReviewItem
+		c := &item.Classification
+		var messageID, rule, tags, reasoning sql.NullString
+		e, err := scanEmail(rows, &messageID, &c.Category, &c.Confidence, &rule, &tags, &c.Method, &reasoning, &c.ClassifiedAt)
+		if err != nil {
+			return nil, 0, err
+		}
+		item.Email = *e
+		c.AccountID, c.Folder, c.UID = e.AccountID, e.Folder, e.UID
+		c.MessageID, c.Rule, c.Reasoning = messageID.String, rule.String, reasoning.String
+		if c.Tags, err = decodeTags(tags); err != nil {
+			return nil, 0, err
+		}
+		items = append(items, item)
+	}
+	return items, total, rows.Err()
+}
@@ -0,0 +1,359 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"email-mcp-server/mail"
+)
+
+// Contact is a person seen in synced mail, with every address they used.
+// Counts and dates are totals over the addresses.
+type Contact struct {
+	ID             int64            `json:"id"`
+	Name           string           `json:"name,omitempty"`
+	Addresses      []ContactAddress `json:"addresses"`
+	FirstSeen      time.Time        `json:"first_seen"`
+	LastSeen       time.Time        `json:"last_seen"`
+	ReceivedCount  int              `json:"received_count"`  // emails sent by the contact
+	RecipientCount int              `json:"recipient_count"` // emails the contact was a recipient of
+}
+
+// ContactAddress is one email address of a contact
+type ContactAddress struct {
+	Address        string    `json:"address"`
+	Name           string    `json:"name,omitempty"` // latest display name used with the address
+	FirstSeen      time.Time `json:"first_seen"`
+	LastSeen       time.Time `json:"last_seen"`
+	ReceivedCount  int       `json:"received_count"`
+	RecipientCount int       `json:"recipient_count"`
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (d *Database) initContacts() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS contacts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT,
+		created_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS contact_addresses (
+		address TEXT PRIMARY KEY,
+		contact_id INTEGER NOT NULL REFERENCES contacts(id) ON DELETE CASCADE,
+		name TEXT,
+		first_seen DATETIME NOT NULL,
+		last_seen DATETIME NOT NULL,
+		received_count INTEGER NOT NULL DEFAULT 0,
+		recipient_count INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS idx_contact_addresses_contact ON contact_addresses(contact_id);`
+
+	if _, err := d.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to initialize contacts: %w", err)
+	}
+
+	// Databases synced before contacts existed get their address book now
+	var contacts, emails int
+	if err := d.db.QueryRow(`SELECT COUNT(*) FROM contact_addresses`).Scan(&contacts); err != nil {
+		return fmt.Errorf("failed to initialize contacts: %w", err)
+	}
+	if err := d.db.QueryRow(`SELECT COUNT(*) FROM emails`).Scan(&emails); err != nil {
+		return fmt.Errorf("failed to initialize contacts: %w", err)
+	}
+	if contacts == 0 && emails > 0 {
+		return d.rebuildContacts()
+	}
+	return nil
+}
+
+// RebuildContacts recomputes the address book from the synced emails
+func (d *Database) RebuildContacts() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.rebuildContacts()
+}
+
+func (d *Database) rebuildContacts() error {
+	rows, err := d.db.Query(`SELECT from_name, from_addr, recipients, date FROM emails ORDER BY date`)
+	if err != nil {
+		return fmt.Errorf("failed to rebuild contacts: %w", err)
+	}
+	type entry struct {
+		from mail.Address
+		to   []string
+		date time.Time
+	}
+	var entries []entry
+	for rows.Next() {
+		var fromName, fromAddr, recipients sql.NullString
+		var e entry
+		if err := rows.Scan(&fromName, &fromAddr, &recipients, &e.date); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to rebuild contacts: %w", err)
+		}
+		e.from = mail.Address{Name: fromName.String, Address: fromAddr.String}
+		if recipients.String != "" {
+			e.to = strings.Split(recipients.String, ", ")
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to rebuild contacts: %w", err)
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to rebuild contacts: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM contact_addresses; DELETE FROM contacts`); err != nil {
+		return fmt.Errorf("failed to rebuild contacts: %w", err)
+	}
+	for _, e := range entries {
+		if err := recordContacts(tx, e.from, e.to, e.date); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to rebuild contacts: %w", err)
+	}
+	return nil
+}
+
+// recordContacts counts an email for its sender and each of its recipients
+func recordContacts(db execer, from mail.Address, to []string, date time.Time) error {
+	if !from.IsZero() {
+		if err := touchAddress(db, from, date, 1, 0); err != nil {
+			return err
+		}
+	}
+	for _, recipient := range to {
+		if addr, err := mail.ParseAddress(recipient); err == nil {
+			if err := touchAddress(db, addr, date, 0, 1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// touchAddress adds to the counts of an address, creating its contact when
+// the address is new. A new address whose display name is a full name
+// already used by a contact joins that contact.
+func touchAddress(db execer, addr mail.Address, date time.Time, received, recipient int) error {
+	address := strings.ToLower(addr.Address)
+	name := strings.TrimSpace(addr.Name)
+
+	var contactID int64
+	var firstSeen, lastSeen time.Time
+	err := db.QueryRow(`SELECT contact_id, first_seen, last_seen FROM contact_addresses WHERE address = ?`, address).
+		Scan(&contactID, &firstSeen, &lastSeen)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read contact %s: %w", address, err)
+	}
+
+	if err == nil {
+		if date.Before(firstSeen) {
+			firstSeen = date
+		}
+		if date.After(lastSeen) {
+			lastSeen = date
+		}
+		_, err = db.Exec(`
+			UPDATE contact_addresses SET name = COALESCE(NULLIF(?, ''), name), first_seen = ?, last_seen = ?,
+				received_count = received_count + ?, recipient_count = recipient_count + ?
+			WHERE address = ?`,
+			name, firstSeen, lastSeen, received, recipient, address)
+		if err == nil && name != "" {
+			_, err = db.Exec(`UPDATE contacts SET name = ? WHERE id = ? AND COALESCE(name, '') = ''`, name, contactID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update contact %s: %w", address, err)
+		}
+		return nil
+	}
+
+	if strings.Contains(name, " ") {
+		err = db.QueryRow(`SELECT id FROM contacts WHERE lower(name) = lower(?) ORDER BY id LIMIT 1`, name).Scan(&contactID)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("failed to read contact %s: %w", name, err)
+		}
+	}
+	if contactID == 0 {
+		err = db.QueryRow(`INSERT INTO contacts (name, created_at) VALUES (?, ?) RETURNING id`, name, time.Now()).Scan(&contactID)
+		if err != nil {
+			return fmt.Errorf("failed to create contact %s: %w", address, err)
+		}
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO contact_addresses (address, contact_id, name, first_seen, last_seen, received_count, recipient_count)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		address, contactID, name, date, date, received, recipient)
+	if err != nil {
+		return fmt.Errorf("failed to create contact %s: %w", address, err)
+	}
+	return nil
+}
+
+// SearchContacts returns contacts whose name or an address contains query,
+// those starting with it first, then the most frequent correspondents. An
+// empty query lists every contact.
+func (d *Database) SearchContacts(query string, limit int) ([]Contact, error) {
+	query = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(strings.TrimSpace(query)))
+	prefix, word, substring := query+"%", "% "+query+"%", "%"+query+"%"
+
+	rows, err := d.db.Query(`
+		SELECT a.contact_id
+		FROM contact_addresses a JOIN contacts c ON c.id = a.contact_id
+		GROUP BY a.contact_id
+		HAVING SUM(lower(a.address) LIKE ? ESCAPE '\' OR lower(COALESCE(a.name, '')) LIKE ? ESCAPE '\'
+			OR lower(COALESCE(c.name, '')) LIKE ? ESCAPE '\') > 0
+		ORDER BY MAX(lower(a.address) LIKE ? ESCAPE '\' OR lower(COALESCE(c.name, '')) LIKE ? ESCAPE '\'
+				OR lower(COALESCE(c.name, '')) LIKE ? ESCAPE '\') DESC,
+			SUM(a.received_count) * 2 + SUM(a.recipient_count) DESC, a.contact_id
+		LIMIT ?`,
+		substring, substring, substring, prefix, prefix, word, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search contacts: %w", err)
+	}
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan contact: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to search contacts: %w", err)
+	}
+
+	contacts := make([]Contact, 0, len(ids))
+	for _, id := range ids {
+		contact, err := d.GetContact(id)
+		if err != nil {
+			return nil, err
+		}
+		if contact != nil {
+			contacts = append(contacts, *contact)
+		}
+	}
+	return contacts, nil
+}
+
+// GetContact returns a contact by ID, or nil if it does not exist
+func (d *Database) GetContact(id int64) (*Contact, error) {
+	contact := &Contact{ID: id}
+	var name sql.NullString
+	err := d.db.QueryRow(`SELECT name FROM contacts WHERE id = ?`, id).Scan(&name)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read contact: %w", err)
+	}
+	contact.Name = name.String
+
+	rows, err := d.db.Query(`
+		SELECT address, name, first_seen, last_seen, received_count, recipient_count
+		FROM contact_addresses WHERE contact_id = ?`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read contact addresses: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a ContactAddress
+		var addrName sql.NullString
+		if err := rows.Scan(&a.Address, &addrName, &a.FirstSeen, &a.LastSeen, &a.ReceivedCount, &a.RecipientCount); err != nil {
+			return nil, fmt.Errorf("failed to scan contact address: %w", err)
+		}
+		a.Name = addrName.String
+
+		if len(contact.Addresses) == 0 || a.FirstSeen.Before(contact.FirstSeen) {
+			contact.FirstSeen = a.FirstSeen
+		}
+		if a.LastSeen.After(contact.LastSeen) {
+			contact.LastSeen = a.LastSeen
+		}
+		contact.ReceivedCount += a.ReceivedCount
+		contact.RecipientCount += a.RecipientCount
+		contact.Addresses = append(contact.Addresses, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read contact addresses: %w", err)
+	}
+
+	// Most used address first
+	sort.SliceStable(contact.Addresses, func(i, j int) bool {
+		wi := contact.Addresses[i].ReceivedCount*2 + contact.Addresses[i].RecipientCount
+		wj := contact.Addresses[j].ReceivedCount*2 + contact.Addresses[j].RecipientCount
+		return wi > wj
+	})
+	return contact, nil
+}
+
+// ContactByAddress returns the contact using address, or nil if none does
+func (d *Database) ContactByAddress(address string) (*Contact, error) {
+	if addr, err := mail.ParseAddress(address); err == nil {
+		address = addr.Address
+	}
+
+	var id int64
+	err := d.db.QueryRow(`SELECT contact_id FROM contact_addresses WHERE address = ?`, strings.ToLower(address)).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read contact: %w", err)
+	}
+	return d.GetContact(id)
+}
+
+// ContactEmails returns the latest synced emails sent from any address of
+// contact, newest first
+func (d *Database) ContactEmails(contact *Contact, limit int) ([]Email, error) {
+	if len(contact.Addresses) == 0 {
+		return nil, nil
+	}
+
+	var conditions []string
+	var args []interface{}
+	for _, a := range contact.Addresses {
+		conditions = append(conditions, `lower(sender) = ? OR lower(sender) LIKE ?`)
+		args = append(args, a.Address, "%<"+a.Address+">")
+	}
+	args = append(args, limit)
+
+	rows, err := d.db.Query(`SELECT `+emailColumns+` FROM emails WHERE `+strings.Join(conditions, " OR ")+
+		` ORDER BY date DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query contact emails: %w", err)
+	}
+	defer rows.Close()
+
+	var emails []Email
+	for rows.Next() {
+		e, err := scanEmail(rows)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, *e)
+	}
+	return emails, rows.Err()
+}
@@ -0,0 +1,803 @@
+// Package storage persists synced emails and sync state in a local SQLite
+// database.
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"email-mcp-server/mail"
+)
+
+// ErrNotFound is wrapped by the errors of lookups that found nothing
+var ErrNotFound = errors.New("not found")
+
+// Database wraps the SQLite connection. Writes are serialized through mu
+// because SQLite only allows a single writer at a time.
+type Database struct {
+	db  *sql.DB
+	mu  sync.Mutex
+	enc *encryption // nil for a plain database file
+
+	stmtMu sync.Mutex
+	stmts  map[string]*sql.Stmt // Prepared statements by query, see prepared.go
+}
+
+// Email is a message synced from an IMAP folder
+type Email struct {
+	ID        int64  `json:"id"`
+	AccountID string `json:"account_id"`
+	Folder    string `json:"folder"`
+	UID       uint32 `json:"uid"`
+	MessageID string `json:"message_id,omitempty"`
+	Subject   string `json:"subject"`
+	From      string `json:"from"`
+	// From parsed into name and address, stored in from_name and from_addr.
+	// CreateEmail parses From when it is empty.
+	FromAddress mail.Address `json:"from_address"`
+	To          []string     `json:"to"`
+	Date        time.Time    `json:"date"`
+	BodySnippet string       `json:"body_snippet,omitempty"`
+	Size        uint32       `json:"size"`
+	Flags       []string     `json:"flags"`
+	InReplyTo   string       `json:"in_reply_to,omitempty"`
+	References  []string     `json:"references,omitempty"`
+	ThreadID    string       `json:"thread_id,omitempty"`
+	SyncedAt    time.Time    `json:"synced_at"`
+	// Kind of message a program sent, such as "bounce", see
+	// mail.ParsedEmail.AutoKind; "" for messages a person sent
+	Automated string `json:"automated,omitempty"`
+	// Names, types and sizes of the attachments, without their content
+	Attachments []mail.Attachment `json:"attachments,omitempty"`
+	// Starred reports whether Flags holds \Flagged, the flag email clients
+	// show as a star or pin
+	Starred bool `json:"starred,omitempty"`
+}
+
+// SyncState tracks how far a folder has been synced. When the server's
+// UIDVALIDITY changes, previously stored UIDs are no longer meaningful.
+type SyncState struct {
+	AccountID   string    `json:"account_id"`
+	Folder      string    `json:"folder"`
+	UIDValidity uint32    `json:"uid_validity"`
+	UIDNext     uint32    `json:"uid_next"`
+	LastUID     uint32    `json:"last_uid"`
+	LastSync    time.Time `json:"last_sync"`
+	LastError   string    `json:"last_error,omitempty"`
+	// Delta link of the next incremental query of a Microsoft Graph folder,
+	// which has no UIDVALIDITY or UIDNEXT
+	DeltaLink string `json:"-"`
+	// HIGHESTMODSEQ of the folder at the last sync, 0 without CONDSTORE
+	ModSeq uint64 `json:"mod_seq,omitempty"`
+}
+
+// New opens (creating if needed) the database at path and applies the schema
+func New(path string) (*Database, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %w", err)
+		}
+	}
+
+	// WAL lets readers (tool calls) proceed while the sync engine writes, and
+	// busy_timeout makes a second writer wait for the lock instead of failing
+	// immediately with SQLITE_BUSY
+	dsn := "file:" + path + "?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=foreign_keys(ON)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	d := &Database{db: db}
+	if err := d.initSchema(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// JournalMode reports the journal mode in effect, e.g. "wal"
+func (d *Database) JournalMode() (string, error) {
+	var mode string
+	err := d.db.QueryRow(`PRAGMA journal_mode`).Scan(&mode)
+	return mode, err
+}
+
+// CheckWritable makes a write that is rolled back, and for an encrypted
+// database also writes a scratch file next to it, to tell whether changes
+// can be saved
+func (d *Database) CheckWritable() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`CREATE TABLE write_check (id INTEGER)`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO write_check (id) VALUES (1)`); err != nil {
+		return err
+	}
+
+	if d.enc != nil {
+		scratch := d.enc.path + ".check"
+		if err := os.WriteFile(scratch, nil, 0o600); err != nil {
+			return err
+		}
+		os.Remove(scratch)
+	}
+	return nil
+}
+
+// Close closes the underlying connection, first saving an encrypted
+// database
+func (d *Database) Close() error {
+	d.closeStmts()
+	if d.enc != nil {
+		if err := d.closeEncrypted(); err != nil {
+			d.db.Close()
+			return err
+		}
+	}
+	return d.db.Close()
+}
+
+func (d *Database) initSchema() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS emails (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		account_id TEXT NOT NULL,
+		folder TEXT NOT NULL,
+		uid INTEGER NOT NULL,
+		message_id TEXT,
+		subject TEXT,
+		sender TEXT,
+		recipients TEXT,
+		date DATETIME,
+		body_snippet TEXT,
+		size INTEGER,
+		flags TEXT,
+		in_reply_to TEXT,
+		references_ids TEXT,
+		thread_id TEXT,
+		synced_at DATETIME NOT NULL,
+		UNIQUE(account_id, folder, uid)
+	);
+	CREATE INDEX IF NOT EXISTS idx_emails_account_date ON emails(account_id, date);
+	CREATE INDEX IF NOT EXISTS idx_emails_message_id ON emails(message_id);
+
+	CREATE TABLE IF NOT EXISTS sync_state (
+		account_id TEXT NOT NULL,
+		folder TEXT NOT NULL,
+		uid_validity INTEGER NOT NULL,
+		uid_next INTEGER NOT NULL,
+		last_uid INTEGER NOT NULL,
+		last_sync DATETIME,
+		last_error TEXT,
+		PRIMARY KEY(account_id, folder)
+	);`
+
+	if _, err := d.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to initialize schema: %w", err)
+	}
+
+	// Columns added after the first release; CREATE TABLE IF NOT EXISTS does
+	// not add them to existing databases
+	for _, column := range []string{"in_reply_to TEXT", "references_ids TEXT", "thread_id TEXT", "from_name TEXT", "from_addr TEXT", "automated TEXT", "attachments TEXT"} {
+		if err := d.addColumn("emails", column); err != nil {
+			return err
+		}
+	}
+	if err := d.addColumn("sync_state", "delta_link TEXT"); err != nil {
+		return err
+	}
+	if err := d.addColumn("sync_state", "mod_seq INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if _, err := d.db.Exec(`CREATE INDEX IF NOT EXISTS idx_emails_thread ON emails(account_id, thread_id)`); err != nil {
+		return fmt.Errorf("failed to initialize schema: %w", err)
+	}
+	if _, err := d.db.Exec(`CREATE INDEX IF NOT EXISTS idx_emails_from_addr ON emails(from_addr)`); err != nil {
+		return fmt.Errorf("failed to initialize schema: %w", err)
+	}
+	if err := d.backfillFromAddresses(); err != nil {
+		return err
+	}
+
+	if err := d.initSearchIndex(); err != nil {
+		return err
+	}
+	if err := d.initDrafts(); err != nil {
+		return err
+	}
+	if err := d.initTemplates(); err != nil {
+		return err
+	}
+	if err := d.initAutoresponders(); err != nil {
+		return err
+	}
+	if err := d.initScheduled(); err != nil {
+		return err
+	}
+	if err := d.initSent(); err != nil {
+		return err
+	}
+	if err := d.initClassifications(); err != nil {
+		return err
+	}
+	if err := d.initSenders(); err != nil {
+		return err
+	}
+	if err := d.initSnoozed(); err != nil {
+		return err
+	}
+	if err := d.initFollowups(); err != nil {
+		return err
+	}
+	if err := d.initPriorities(); err != nil {
+		return err
+	}
+	if err := d.initBounces(); err != nil {
+		return err
+	}
+	if err := d.initDeadlines(); err != nil {
+		return err
+	}
+	if err := d.initInvoices(); err != nil {
+		return err
+	}
+	if err := d.initOrders(); err != nil {
+		return err
+	}
+	if err := d.initTrips(); err != nil {
+		return err
+	}
+	if err := d.initActionItems(); err != nil {
+		return err
+	}
+	if err := d.initDuplicates(); err != nil {
+		return err
+	}
+	if err := d.initAppliedActions(); err != nil {
+		return err
+	}
+	if err := d.initAudit(); err != nil {
+		return err
+	}
+	if err := d.initGraphIDs(); err != nil {
+		return err
+	}
+	return d.initContacts()
+}
+
+// addColumn adds a column to table unless it already exists. definition is
+// the column name followed by its type.
+func (d *Database) addColumn(table, definition string) error {
+	name := strings.Fields(definition)[0]
+
+	var count int
+	err := d.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, name).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	if _, err := d.db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + definition); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, name, err)
+	}
+	return nil
+}
+
+// backfillFromAddresses parses the sender of emails stored before the
+// from_name and from_addr columns existed
+func (d *Database) backfillFromAddresses() error {
+	rows, err := d.db.Query(`SELECT id, sender FROM emails WHERE from_addr IS NULL AND sender IS NOT NULL`)
+	if err != nil {
+		return fmt.Errorf("failed to read senders: %w", err)
+	}
+	parsed := make(map[int64]mail.Address)
+	for rows.Next() {
+		var id int64
+		var sender string
+		if err := rows.Scan(&id, &sender); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read senders: %w", err)
+		}
+		addr, _ := mail.ParseAddress(sender)
+		parsed[id] = addr
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read senders: %w", err)
+	}
+
+	for id, addr := range parsed {
+		if _, err := d.db.Exec(`UPDATE emails SET from_name = ?, from_addr = ? WHERE id = ?`, addr.Name, addr.Address, id); err != nil {
+			return fmt.Errorf("failed to store sender address: %w", err)
+		}
+	}
+	return nil
+}
+
+// emailsPerInsert is the number of rows of each INSERT of CreateEmails
+const emailsPerInsert = 50
+
+// CreateEmail stores a synced email, see CreateEmails
+func (d *Database) CreateEmail(email *Email) error {
+	return d.CreateEmails([]*Email{email})
+}
+
+// CreateEmails stores synced emails in one transaction, inserting them
+// emailsPerInsert rows at a time; either all of them are stored or none.
+// Re-syncing the same UID updates its flags instead of creating a duplicate
+// row. A missing ThreadID is resolved from the References and In-Reply-To
+// headers, see resolveThreadID. New emails are counted in the contacts of
+// their sender and recipients, and recorded as duplicates when an earlier
+// email has the same Message-ID or content. FromAddress is parsed from From
+// when not set. The priority rollup of each thread is refreshed either way.
+func (d *Database) CreateEmails(emails []*Email) error {
+	if len(emails) == 0 {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to save email: %w", err)
+	}
+	defer tx.Rollback()
+	db := prepared{d: d, tx: tx}
+
+	now := time.Now()
+	isNew := make([]bool, len(emails))
+	for i, email := range emails {
+		if email.SyncedAt.IsZero() {
+			email.SyncedAt = now
+		}
+		if email.FromAddress.IsZero() {
+			email.FromAddress, _ = mail.ParseAddress(email.From)
+		}
+
+		// A stored email keeps its thread
+		var threadID sql.NullString
+		err := db.QueryRow(`SELECT thread_id FROM emails WHERE account_id = ? AND folder = ? AND uid = ?`,
+			email.AccountID, email.Folder, email.UID).Scan(&threadID)
+		switch {
+		case err == sql.ErrNoRows:
+			isNew[i] = true
+		case err != nil:
+			return fmt.Errorf("failed to save email: %w", err)
+		case threadID.String != "":
+			email.ThreadID = threadID.String
+		}
+		if email.ThreadID == "" {
+			if email.ThreadID, err = resolveThreadID(db, email, emails[:i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	hashes := make([]string, len(emails))
+	for start := 0; start < len(emails); start += emailsPerInsert {
+		chunk := emails[start:min(start+emailsPerInsert, len(emails))]
+
+		args := make([]interface{}, 0, 20*len(chunk))
+		for i, email := range chunk {
+			hashes[start+i] = ContentHash(email)
+			attachments, err := encodeAttachments(email.Attachments)
+			if err != nil {
+				return err
+			}
+			args = append(args, email.AccountID, email.Folder, email.UID, email.MessageID, email.Subject, email.From,
+				email.FromAddress.Name, email.FromAddress.Address, strings.Join(email.To, ", "), email.Date, email.BodySnippet, email.Size,
+				strings.Join(email.Flags, " "), email.InReplyTo, strings.Join(email.References, " "), email.ThreadID,
+				email.SyncedAt, hashes[start+i], email.Automated, attachments)
+		}
+		values := strings.TrimSuffix(strings.Repeat("(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?), ", len(chunk)), ", ")
+		rows, err := db.Query(`
+			INSERT INTO emails (account_id, folder, uid, message_id, subject, sender, from_name, from_addr, recipients, date,
+				body_snippet, size, flags, in_reply_to, references_ids, thread_id, synced_at, content_hash, automated, attachments)
+			VALUES `+values+`
+			ON CONFLICT(account_id, folder, uid) DO UPDATE SET flags = excluded.flags, synced_at = excluded.synced_at
+			RETURNING id, account_id, folder, uid`, args...)
+		if err != nil {
+			return fmt.Errorf("failed to save email: %w", err)
+		}
+		ids := make(map[string]int64, len(chunk))
+		for rows.Next() {
+			var id int64
+			var accountID, folder string
+			var uid uint32
+			if err := rows.Scan(&id, &accountID, &folder, &uid); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to save email: %w", err)
+			}
+			ids[fmt.Sprintf("%s/%s/%d", accountID, folder, uid)] = id
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to save email: %w", err)
+		}
+		for _, email := range chunk {
+			email.ID = ids[fmt.Sprintf("%s/%s/%d", email.AccountID, email.Folder, email.UID)]
+		}
+	}
+
+	type thread struct{ accountID, threadID string }
+	threads := make(map[thread]bool)
+	for i, email := range emails {
+		if isNew[i] {
+			if err := recordDuplicate(db, email, hashes[i]); err != nil {
+				return err
+			}
+			if err := recordContacts(db, email.FromAddress, email.To, email.Date); err != nil {
+				return err
+			}
+		}
+		threads[thread{email.AccountID, email.ThreadID}] = true
+	}
+	// A new message or a change of flags alters the thread's rollup
+	for t := range threads {
+		if err := refreshThreadPriority(db, t.accountID, t.threadID); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to save email: %w", err)
+	}
+	return nil
+}
+
+// emailColumns lists the emails columns in the order read by scanEmail
+const emailColumns = `emails.id, emails.account_id, emails.folder, emails.uid, emails.message_id, emails.subject,
+	emails.sender, emails.recipients, emails.date, emails.body_snippet, emails.size, emails.flags,
+	emails.in_reply_to, emails.references_ids, emails.thread_id, emails.synced_at, emails.from_name, emails.from_addr,
+	emails.automated, emails.attachments`
+
+// GetEmails returns the most recent synced emails of an account, newest first
+func (d *Database) GetEmails(accountID string, limit int) ([]Email, error) {
+	rows, err := d.db.Query(`SELECT `+emailColumns+` FROM emails WHERE account_id = ? ORDER BY date DESC LIMIT ?`,
+		accountID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query emails: %w", err)
+	}
+	defer rows.Close()
+
+	var emails []Email
+	for rows.Next() {
+		e, err := scanEmail(rows)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, *e)
+	}
+	return emails, rows.Err()
+}
+
+// scanEmail reads emailColumns, followed by any extra destinations
+func scanEmail(rows *sql.Rows, extra ...interface{}) (*Email, error) {
+	var e Email
+	var messageID, subject, sender, recipients, snippet, flags, inReplyTo, references, threadID, fromName, fromAddr, automated, attachments sql.NullString
+	dest := []interface{}{&e.ID, &e.AccountID, &e.Folder, &e.UID, &messageID, &subject, &sender,
+		&recipients, &e.Date, &snippet, &e.Size, &flags, &inReplyTo, &references, &threadID, &e.SyncedAt, &fromName, &fromAddr,
+		&automated, &attachments}
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
+		return nil, fmt.Errorf("failed to scan email: %w", err)
+	}
+
+	e.MessageID = messageID.String
+	e.Subject = subject.String
+	e.From = sender.String
+	e.FromAddress = mail.Address{Name: fromName.String, Address: fromAddr.String}
+	e.BodySnippet = snippet.String
+	e.To = splitAddresses(recipients.String)
+	e.Flags = strings.Fields(flags.String)
+	e.Starred = hasFlag(e.Flags, FlaggedFlag)
+	e.InReplyTo = inReplyTo.String
+	e.References = strings.Fields(references.String)
+	e.ThreadID = threadID.String
+	e.Automated = automated.String
+	if attachments.String != "" {
+		if err := json.Unmarshal([]byte(attachments.String), &e.Attachments); err != nil {
+			return nil, fmt.Errorf("failed to scan email: invalid attachments: %w", err)
+		}
+	}
+	return &e, nil
+}
+
+// encodeAttachments stores the attachments of an email as JSON, NULL when
+// it has none
+func encodeAttachments(attachments []mail.Attachment) (interface{}, error) {
+	if len(attachments) == 0 {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(attachments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode attachments: %w", err)
+	}
+	return string(encoded), nil
+}
+
+// CountEmails returns the number of synced emails for an account
+func (d *Database) CountEmails(accountID string) (int, error) {
+	var count int
+	err := d.db.QueryRow(`SELECT COUNT(*) FROM emails WHERE account_id = ?`, accountID).Scan(&count)
+	return count, err
+}
+
+// DeleteFolderEmails removes every synced email of a folder with their
+// priorities, deadlines, invoices, orders, trips, action items, bounces and
+// duplicate records, used when the folder's UIDVALIDITY changes. Contacts
+// and thread priorities are recomputed without them, so the emails are not
+// counted twice when synced again.
+func (d *Database) DeleteFolderEmails(accountID, folder string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.deleteEmailRows(accountID, folder, "", nil); err != nil {
+		return err
+	}
+	if err := d.rebuildThreadPriorities(accountID); err != nil {
+		return err
+	}
+	return d.rebuildContacts()
+}
+
+// DeleteEmails removes the synced emails of a folder with the given UIDs and
+// the records DeleteFolderEmails removes with them, used when messages were
+// expunged or moved to another folder on the server
+func (d *Database) DeleteEmails(accountID, folder string, uids []uint32) error {
+	if len(uids) == 0 {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Stay well below SQLite's limit on query parameters
+	for chunk := range slices.Chunk(uids, 500) {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(chunk)), ",")
+		args := make([]interface{}, len(chunk))
+		for i, uid := range chunk {
+			args[i] = uid
+		}
+		if err := d.deleteEmailRows(accountID, folder, " AND uid IN ("+placeholders+")", args); err != nil {
+			return err
+		}
+	}
+	if err := d.rebuildThreadPriorities(accountID); err != nil {
+		return err
+	}
+	return d.rebuildContacts()
+}
+
+// SyncedUIDs returns the UIDs of the synced emails of a folder up to maxUID
+func (d *Database) SyncedUIDs(accountID, folder string, maxUID uint32) ([]uint32, error) {
+	rows, err := d.db.Query(`SELECT uid FROM emails WHERE account_id = ? AND folder = ? AND uid <= ? ORDER BY uid`,
+		accountID, folder, maxUID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uids []uint32
+	for rows.Next() {
+		var uid uint32
+		if err := rows.Scan(&uid); err != nil {
+			return nil, err
+		}
+		uids = append(uids, uid)
+	}
+	return uids, rows.Err()
+}
+
+// emailTables hold the records derived from a synced email, keyed by its
+// account, folder and UID
+var emailTables = []string{"emails", "priorities", "deadlines", "invoices", "orders", "trips", "action_items", "bounces"}
+
+// deleteEmailRows deletes the rows of a folder's emails matching uidFilter,
+// an SQL condition on uid starting with AND, or all of them when it is ""
+func (d *Database) deleteEmailRows(accountID, folder, uidFilter string, uidArgs []interface{}) error {
+	args := append([]interface{}{accountID, folder}, uidArgs...)
+	for _, table := range emailTables {
+		// Scores would otherwise be attached to new messages reusing the UIDs
+		if _, err := d.db.Exec(`DELETE FROM `+table+` WHERE account_id = ? AND folder = ?`+uidFilter, args...); err != nil {
+			return err
+		}
+	}
+
+	originalFilter := strings.ReplaceAll(uidFilter, "uid IN", "original_uid IN")
+	_, err := d.db.Exec(`DELETE FROM duplicates WHERE (account_id = ? AND folder = ?`+uidFilter+`) OR (original_account_id = ? AND original_folder = ?`+originalFilter+`)`,
+		append(args, args...)...)
+	return err
+}
+
+// UpdateFlags replaces the flags of a synced email; emails that were never
+// synced are ignored
+func (d *Database) UpdateFlags(accountID, folder string, uid uint32, flags []string) error {
+	return d.UpdateAllFlags(accountID, folder, map[uint32][]string{uid: flags})
+}
+
+// UpdateAllFlags replaces the flags of synced emails of a folder, by UID, in
+// one transaction; emails that were never synced are ignored
+func (d *Database) UpdateAllFlags(accountID, folder string, flags map[uint32][]string) error {
+	if len(flags) == 0 {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to update flags: %w", err)
+	}
+	defer tx.Rollback()
+	db := prepared{d: d, tx: tx}
+
+	threads := make(map[string]bool)
+	for uid, f := range flags {
+		var threadID sql.NullString
+		err := db.QueryRow(`UPDATE emails SET flags = ? WHERE account_id = ? AND folder = ? AND uid = ? RETURNING thread_id`,
+			strings.Join(f, " "), accountID, folder, uid).Scan(&threadID)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update flags: %w", err)
+		}
+		threads[threadID.String] = true
+	}
+	for threadID := range threads {
+		if err := refreshThreadPriority(db, accountID, threadID); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to update flags: %w", err)
+	}
+	return nil
+}
+
+// FlaggedFlag is the IMAP flag of starred emails
+const FlaggedFlag = `\Flagged`
+
+// starredCondition matches the emails whose flags hold FlaggedFlag
+const starredCondition = `(' ' || COALESCE(emails.flags, '') || ' ') LIKE '% ` + FlaggedFlag + ` %'`
+
+// hasFlag reports whether flags holds flag; flags are case-insensitive
+func hasFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if strings.EqualFold(f, flag) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetStarred adds FlaggedFlag to the synced emails of a folder, or removes
+// it, after the same change on the server; emails that were never synced
+// are ignored
+func (d *Database) SetStarred(accountID, folder string, uids []uint32, starred bool) error {
+	if len(uids) == 0 {
+		return nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(uids)), ", ")
+	args := []interface{}{accountID, folder}
+	for _, uid := range uids {
+		args = append(args, uid)
+	}
+	return d.updateStarred(accountID, folder, `uid IN (`+placeholders+`)`, args,
+		func(uint32) bool { return starred })
+}
+
+// SyncStarred stars the synced emails of a folder up to lastUID whose UID
+// is in starred and unstars the others. It keeps stars in step on servers
+// without CONDSTORE, whose other flag changes are not synced.
+func (d *Database) SyncStarred(accountID, folder string, starred []uint32, lastUID uint32) error {
+	set := make(map[uint32]bool, len(starred))
+	for _, uid := range starred {
+		set[uid] = true
+	}
+	return d.updateStarred(accountID, folder, `uid <= ?`, []interface{}{accountID, folder, lastUID},
+		func(uid uint32) bool { return set[uid] })
+}
+
+// updateStarred sets the star of the emails of a folder matching condition
+// to what starred returns for their UID, only writing those that change
+func (d *Database) updateStarred(accountID, folder, condition string, args []interface{}, starred func(uint32) bool) error {
+	rows, err := d.db.Query(`SELECT uid, flags FROM emails WHERE account_id = ? AND folder = ? AND `+condition, args...)
+	if err != nil {
+		return fmt.Errorf("failed to read flags: %w", err)
+	}
+	changed := make(map[uint32][]string)
+	for rows.Next() {
+		var uid uint32
+		var stored sql.NullString
+		if err := rows.Scan(&uid, &stored); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read flags: %w", err)
+		}
+		flags := strings.Fields(stored.String)
+		want := starred(uid)
+		if hasFlag(flags, FlaggedFlag) == want {
+			continue
+		}
+		if want {
+			flags = append(flags, FlaggedFlag)
+		} else {
+			flags = slices.DeleteFunc(flags, func(f string) bool { return strings.EqualFold(f, FlaggedFlag) })
+		}
+		changed[uid] = flags
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read flags: %w", err)
+	}
+	return d.UpdateAllFlags(accountID, folder, changed)
+}
+
+// GetSyncState returns the sync state of a folder, or nil if it was never synced
+func (d *Database) GetSyncState(accountID, folder string) (*SyncState, error) {
+	state := &SyncState{AccountID: accountID, Folder: folder}
+	var lastSync sql.NullTime
+	var lastError, deltaLink sql.NullString
+
+	err := d.prepared().QueryRow(`
+		SELECT uid_validity, uid_next, last_uid, last_sync, last_error, delta_link, mod_seq
+		FROM sync_state WHERE account_id = ? AND folder = ?`, accountID, folder).
+		Scan(&state.UIDValidity, &state.UIDNext, &state.LastUID, &lastSync, &lastError, &deltaLink, &state.ModSeq)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync state: %w", err)
+	}
+
+	state.LastSync = lastSync.Time
+	state.LastError = lastError.String
+	state.DeltaLink = deltaLink.String
+	return state, nil
+}
+
+// SaveSyncState stores the sync state of a folder
+func (d *Database) SaveSyncState(state *SyncState) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.prepared().Exec(`
+		INSERT INTO sync_state (account_id, folder, uid_validity, uid_next, last_uid, last_sync, last_error, delta_link, mod_seq)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(account_id, folder) DO UPDATE SET
+			uid_validity = excluded.uid_validity, uid_next = excluded.uid_next, last_uid = excluded.last_uid,
+			last_sync = excluded.last_sync, last_error = excluded.last_error, delta_link = excluded.delta_link,
+			mod_seq = excluded.mod_seq`,
+		state.AccountID, state.Folder, state.UIDValidity, state.UIDNext, state.LastUID, state.LastSync, state.LastError,
+		state.DeltaLink, state.ModSeq)
+	if err != nil {
+		return fmt.Errorf("failed to save sync state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// Deadline is a due date found in a synced email
+type Deadline struct {
+	AccountID string    `json:"account_id"`
+	Folder    string    `json:"folder"`
+	UID       uint32    `json:"uid"`
+	Phrase    string    `json:"phrase"`
+	Due       time.Time `json:"due"`
+}
+
+// UpcomingDeadline is a deadline together with the email mentioning it
+type UpcomingDeadline struct {
+	Phrase string    `json:"phrase"`
+	Due    time.Time `json:"due"`
+	Email  Email     `json:"email"`
+}
+
+func (d *Database) initDeadlines() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS deadlines (
+		account_id TEXT NOT NULL,
+		folder TEXT NOT NULL,
+		uid INTEGER NOT NULL,
+		phrase TEXT NOT NULL,
+		due DATETIME NOT NULL,
+		PRIMARY KEY(account_id, folder, uid, due)
+	);
+	CREATE INDEX IF NOT EXISTS idx_deadlines_due ON deadlines(account_id, due);`
+
+	if _, err := d.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to initialize deadlines: %w", err)
+	}
+	return nil
+}
+
+// SaveDeadlines replaces the deadlines stored for an email. An empty list
+// clears them.
+func (d *Database) SaveDeadlines(accountID, folder string, uid uint32, deadlines []Deadline) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to save deadlines: %w", err)
+	}
+	defer tx.Rollback()
+	db := prepared{d: d, tx: tx}
+
+	if _, err := db.Exec(`DELETE FROM deadlines WHERE account_id = ? AND folder = ? AND uid = ?`, accountID, folder, uid); err != nil {
+		return fmt.Errorf("failed to save deadlines: %w", err)
+	}
+	for _, deadline := range deadlines {
+		_, err := db.Exec(`
+			INSERT OR IGNORE INTO deadlines (account_id, folder, uid, phrase, due) VALUES (?, ?, ?, ?, ?)`,
+			accountID, folder, uid, deadline.Phrase, deadline.Due.UTC())
+		if err != nil {
+			return fmt.Errorf("failed to save deadlines: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// UpcomingDeadlines returns the deadlines of an account's synced emails due
+// between from and until, soonest first. A non-empty folder keeps those of
+// the emails of that folder.
+func (d *Database) UpcomingDeadlines(accountID, folder string, from, until time.Time, limit int) ([]UpcomingDeadline, error) {
+	rows, err := d.db.Query(`
+		SELECT `+emailColumns+`, deadlines.phrase, deadlines.due FROM deadlines
+		JOIN emails ON emails.account_id = deadlines.account_id AND emails.folder = deadlines.folder AND emails.uid = deadlines.uid
+		WHERE deadlines.account_id = ? AND (? = '' OR deadlines.folder = ?) AND deadlines.due >= ? AND deadlines.due <= ?
+		ORDER BY deadlines.due, emails.date DESC LIMIT ?`,
+		accountID, folder, folder, from.UTC(), until.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query deadlines: %w", err)
+	}
+	defer rows.Close()
+
+	var deadlines []UpcomingDeadline
+	for rows.Next() {
+		var deadline UpcomingDeadline
+		e, err := scanEmail(rows, &deadline.Phrase, &deadline.Due)
+		if err != nil {
+			return nil, err
+		}
+		deadline.Email = *e
+		deadlines = append(deadlines, deadline)
+	}
+	return deadlines, rows.Err()
+}
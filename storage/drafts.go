@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Draft is an unsent message kept for review before sending. Drafts saved to
+// the server also have a copy in the account's Drafts folder, identified by
+// MessageID so it can be replaced on update and removed once sent.
+type Draft struct {
+	ID           int64     `json:"id"`
+	AccountID    string    `json:"account_id"`
+	MessageID    string    `json:"message_id"`
+	To           []string  `json:"to"`
+	Cc           []string  `json:"cc,omitempty"`
+	Bcc          []string  `json:"bcc,omitempty"`
+	Subject      string    `json:"subject"`
+	Body         string    `json:"body"`
+	ServerFolder string    `json:"server_folder,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func (d *Database) initDrafts() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS drafts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		account_id TEXT NOT NULL,
+		message_id TEXT NOT NULL,
+		recipients TEXT,
+		cc TEXT,
+		bcc TEXT,
+		subject TEXT,
+		body TEXT,
+		server_folder TEXT,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_drafts_account ON drafts(account_id, updated_at);`
+
+	if _, err := d.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to initialize drafts: %w", err)
+	}
+	return nil
+}
+
+// CreateDraft stores a new draft and sets its ID and timestamps
+func (d *Database) CreateDraft(draft *Draft) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	draft.CreatedAt, draft.UpdatedAt = now, now
+
+	err := d.db.QueryRow(`
+		INSERT INTO drafts (account_id, message_id, recipients, cc, bcc, subject, body, server_folder, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id`,
+		draft.AccountID, draft.MessageID, strings.Join(draft.To, ", "), strings.Join(draft.Cc, ", "),
+		strings.Join(draft.Bcc, ", "), draft.Subject, draft.Body, draft.ServerFolder,
+		draft.CreatedAt, draft.UpdatedAt).Scan(&draft.ID)
+	if err != nil {
+		return fmt.Errorf("failed to save draft: %w", err)
+	}
+	return nil
+}
+
+// UpdateDraft overwrites the stored fields of an existing draft
+func (d *Database) UpdateDraft(draft *Draft) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	draft.UpdatedAt = time.Now()
+
+	res, err := d.db.Exec(`
+		UPDATE drafts SET recipients = ?, cc = ?, bcc = ?, subject = ?, body = ?, server_folder = ?, updated_at = ?
+		WHERE id = ?`,
+		strings.Join(draft.To, ", "), strings.Join(draft.Cc, ", "), strings.Join(draft.Bcc, ", "),
+		draft.Subject, draft.Body, draft.ServerFolder, draft.UpdatedAt, draft.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update draft: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("draft %w: %d", ErrNotFound, draft.ID)
+	}
+	return nil
+}
+
+const draftColumns = `id, account_id, message_id, recipients, cc, bcc, subject, body, server_folder, created_at, updated_at`
+
+// GetDraft returns a draft by ID
+func (d *Database) GetDraft(id int64) (*Draft, error) {
+	rows, err := d.db.Query(`SELECT `+draftColumns+` FROM drafts WHERE id = ?`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query draft: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("draft %w: %d", ErrNotFound, id)
+	}
+	return scanDraft(rows)
+}
+
+// ListDrafts returns the drafts of an account, most recently edited first.
+// An empty accountID lists the drafts of every account.
+func (d *Database) ListDrafts(accountID string) ([]Draft, error) {
+	query := `SELECT ` + draftColumns + ` FROM drafts`
+	var args []interface{}
+	if accountID != "" {
+		query += ` WHERE account_id = ?`
+		args = append(args, accountID)
+	}
+	query += ` ORDER BY updated_at DESC`
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query drafts: %w", err)
+	}
+	defer rows.Close()
+
+	var drafts []Draft
+	for rows.Next() {
+		draft, err := scanDraft(rows)
+		if err != nil {
+			return nil, err
+		}
+		drafts = append(drafts, *draft)
+	}
+	return drafts, rows.Err()
+}
+
+// DeleteDraft removes a draft, e.g. after it has been sent
+func (d *Database) DeleteDraft(id int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec(`DELETE FROM drafts WHERE id = ?`, id)
+	return err
+}
+
+func scanDraft(rows *sql.Rows) (*Draft, error) {
+	var draft Draft
+	var to, cc, bcc, subject, body, serverFolder sql.NullString
+	if err := rows.Scan(&draft.ID, &draft.AccountID, &draft.MessageID, &to, &cc, &bcc, &subject, &body,
+		&serverFolder, &draft.CreatedAt, &draft.UpdatedAt); err != nil {
+		return nil, fmt.Errorf("failed to scan draft: %w", err)
+	}
+
+	draft.To = splitAddresses(to.String)
+	draft.Cc = splitAddresses(cc.String)
+	draft.Bcc = splitAddresses(bcc.String)
+	draft.Subject = subject.String
+	draft.Body = body.String
+	draft.ServerFolder = serverFolder.String
+	return &draft, nil
+}
+
+func splitAddresses(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ", ")
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"email-mcp-server/mail"
+)
+
+// Duplicate reasons
+const (
+	DuplicateMessageID = "message_id"
+	DuplicateContent   = "content"
+)
+
+// Duplicate is a synced email that is a copy of one synced before it, such as
+// the same message reaching two accounts through a forwarding rule
+type Duplicate struct {
+	Email             Email     `json:"email"` // the copy
+	OriginalAccountID string    `json:"original_account_id"`
+	OriginalFolder    string    `json:"original_folder"`
+	OriginalUID       uint32    `json:"original_uid"`
+	Reason            string    `json:"reason"` // message_id or content
+	DetectedAt        time.Time `json:"detected_at"`
+}
+
+func (d *Database) initDuplicates() error {
+	if err := d.addColumn("emails", "content_hash TEXT"); err != nil {
+		return err
+	}
+
+	schema := `
+	CREATE INDEX IF NOT EXISTS idx_emails_content_hash ON emails(content_hash);
+
+	CREATE TABLE IF NOT EXISTS duplicates (
+		account_id TEXT NOT NULL,
+		folder TEXT NOT NULL,
+		uid INTEGER NOT NULL,
+		original_account_id TEXT NOT NULL,
+		original_folder TEXT NOT NULL,
+		original_uid INTEGER NOT NULL,
+		reason TEXT NOT NULL,
+		detected_at DATETIME NOT NULL,
+		PRIMARY KEY(account_id, folder, uid)
+	);
+	CREATE INDEX IF NOT EXISTS idx_duplicates_original ON duplicates(original_account_id, original_folder, original_uid);`
+
+	if _, err := d.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to initialize duplicates: %w", err)
+	}
+	return d.backfillDuplicates()
+}
+
+// backfillDuplicates hashes the emails synced before content hashes existed,
+// oldest first, and records the duplicates among them
+func (d *Database) backfillDuplicates() error {
+	rows, err := d.db.Query(`SELECT ` + emailColumns + ` FROM emails WHERE content_hash IS NULL ORDER BY id`)
+	if err != nil {
+		return fmt.Errorf("failed to backfill duplicates: %w", err)
+	}
+	var emails []*Email
+	for rows.Next() {
+		e, err := scanEmail(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		emails = append(emails, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to backfill duplicates: %w", err)
+	}
+
+	for _, e := range emails {
+		hash := ContentHash(e)
+		if _, err := d.db.Exec(`UPDATE emails SET content_hash = ? WHERE id = ?`, hash, e.ID); err != nil {
+			return fmt.Errorf("failed to backfill duplicates: %w", err)
+		}
+		if err := recordDuplicate(d.db, e, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ContentHash identifies the content of an email regardless of its
+// Message-ID: the sender's address, subject, date to the minute and body
+// snippet, with case and whitespace differences ignored
+func ContentHash(email *Email) string {
+	from := email.FromAddress.Address
+	if from == "" {
+		from = strings.TrimSpace(email.From)
+		if addr, err := mail.ParseAddress(email.From); err == nil {
+			from = addr.Address
+		}
+	}
+	from = strings.ToLower(from)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%d\x00%s", from,
+		strings.ToLower(strings.Join(strings.Fields(email.Subject), " ")),
+		email.Date.UTC().Truncate(time.Minute).Unix(),
+		strings.ToLower(strings.Join(strings.Fields(email.BodySnippet), " ")))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recordDuplicate records email as a duplicate when an earlier email that is
+// not a duplicate itself has the same Message-ID or content hash
+func recordDuplicate(db execer, email *Email, hash string) error {
+	var original Duplicate
+	err := db.QueryRow(`
+		SELECT e.account_id, e.folder, e.uid, CASE WHEN ? <> '' AND e.message_id = ? THEN ? ELSE ? END FROM emails e
+		WHERE e.id < ? AND ((? <> '' AND e.message_id = ?) OR e.content_hash = ?)
+			AND NOT EXISTS (SELECT 1 FROM duplicates d WHERE d.account_id = e.account_id AND d.folder = e.folder AND d.uid = e.uid)
+		ORDER BY e.id LIMIT 1`,
+		email.MessageID, email.MessageID, DuplicateMessageID, DuplicateContent,
+		email.ID, email.MessageID, email.MessageID, hash).
+		Scan(&original.OriginalAccountID, &original.OriginalFolder, &original.OriginalUID, &original.Reason)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check for duplicates: %w", err)
+	}
+
+	_, err = db.Exec(`
+		INSERT OR IGNORE INTO duplicates (account_id, folder, uid, original_account_id, original_folder, original_uid, reason, detected_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		email.AccountID, email.Folder, email.UID, original.OriginalAccountID, original.OriginalFolder, original.OriginalUID,
+		original.Reason, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record duplicate: %w", err)
+	}
+	return nil
+}
+
+// ListDuplicates returns the duplicates found in an account's synced emails,
+// or in every account when accountID is empty, newest first. A non-empty
+// folder keeps the duplicates in that folder.
+func (d *Database) ListDuplicates(accountID, folder string, limit int) ([]Duplicate, error) {
+	rows, err := d.db.Query(`
+		SELECT `+emailColumns+`, d.original_account_id, d.original_folder, d.original_uid, d.reason, d.detected_at
+		FROM duplicates d
+		JOIN emails ON emails.account_id = d.account_id AND emails.folder = d.folder AND emails.uid = d.uid
+		WHERE (? = '' OR d.account_id = ?) AND (? = '' OR d.folder = ?)
+		ORDER BY emails.date DESC LIMIT ?`, accountID, accountID, folder, folder, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query duplicates: %w", err)
+	}
+	defer rows.Close()
+
+	var duplicates []Duplicate
+	for rows.Next() {
+		var dup Duplicate
+		e, err := scanEmail(rows, &dup.OriginalAccountID, &dup.OriginalFolder, &dup.OriginalUID, &dup.Reason, &dup.DetectedAt)
+		if err != nil {
+			return nil, err
+		}
+		dup.Email = *e
+		duplicates = append(duplicates, dup)
+	}
+	return duplicates, rows.Err()
+}
+
+// DuplicateUIDs returns the UIDs of the emails of a folder that are
+// duplicates of another email
+func (d *Database) DuplicateUIDs(accountID, folder string) (map[uint32]bool, error) {
+	rows, err := d.db.Query(`SELECT uid FROM duplicates WHERE account_id = ? AND folder = ?`, accountID, folder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query duplicates: %w", err)
+	}
+	defer rows.Close()
+
+	uids := make(map[uint32]bool)
+	for rows.Next() {
+		var uid uint32
+		if err := rows.Scan(&uid); err != nil {
+			return nil, fmt.Errorf("failed to scan duplicate: %w", err)
+		}
+		uids[uid] = true
+	}
+	return uids, rows.Err()
+}
@@ -0,0 +1,317 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"email-mcp-server/credentials"
+
+	sqlite "modernc.org/sqlite"
+)
+
+// An encrypted database is kept on disk as one AES-256-GCM sealed SQLite
+// image, under a key derived from a passphrase with PBKDF2. While open it
+// lives in memory (SQLite's memdb VFS, shared by the connections of the
+// pool) and is written back whenever it changed, every flush interval and
+// on Close. Full-text search and every query work as on a plain database;
+// nothing readable ever touches the disk.
+
+// encryptedMagic starts every encrypted database file
+const encryptedMagic = "EMCPDB01"
+
+const encryptedSaltSize = 16
+
+// encryption is the state of an encrypted database
+type encryption struct {
+	path       string
+	passphrase string // Decrypts backups made under another salt
+	salt       []byte
+	aead       cipher.AEAD
+	uri        string    // In-memory database
+	pin        *sql.Conn // Keeps the in-memory database alive; serialized on flush
+
+	mu      sync.Mutex // serializes flushes
+	lastSum [sha256.Size]byte
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// sqliteConn is the part of the modernc.org/sqlite connection used to move
+// database images in and out of memory
+type sqliteConn interface {
+	Serialize() ([]byte, error)
+	Deserialize(buf []byte) error
+	NewBackup(dstUri string) (*sqlite.Backup, error)
+	NewRestore(srcUri string) (*sqlite.Backup, error)
+}
+
+// OpenEncrypted opens the database encrypted with passphrase at path,
+// creating it when missing. Changes are written to path every flushInterval
+// and on Close.
+func OpenEncrypted(path, passphrase string, flushInterval time.Duration) (*Database, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("the database passphrase is empty")
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %w", err)
+		}
+	}
+
+	var salt, image []byte
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if salt, image, err = decryptImage(data, passphrase); err != nil {
+			return nil, fmt.Errorf("failed to decrypt %s: %w", path, err)
+		}
+	case os.IsNotExist(err):
+		salt = make([]byte, encryptedSaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	aead, err := credentials.NewCipher(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	// The memdb name is private to this process
+	name := make([]byte, 8)
+	if _, err := rand.Read(name); err != nil {
+		return nil, err
+	}
+	uri := "file:/emails-" + hex.EncodeToString(name) + ".db?vfs=memdb"
+	db, err := sql.Open("sqlite", uri+"&_pragma=busy_timeout(5000)&_pragma=foreign_keys(ON)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	pin, err := db.Conn(context.Background())
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	enc := &encryption{path: path, passphrase: passphrase, salt: salt, aead: aead, uri: uri, pin: pin}
+	d := &Database{db: db, enc: enc}
+	fail := func(err error) (*Database, error) {
+		pin.Close()
+		db.Close()
+		return nil, err
+	}
+	if image != nil {
+		if err := restoreImage(image, uri); err != nil {
+			return fail(fmt.Errorf("failed to load %s: %w", path, err))
+		}
+		enc.lastSum = sha256.Sum256(image)
+	}
+	if err := d.initSchema(); err != nil {
+		return fail(err)
+	}
+	// Writes the new file, or the schema changes of this version
+	if err := d.Flush(); err != nil {
+		return fail(err)
+	}
+
+	if flushInterval > 0 {
+		enc.stop, enc.stopped = make(chan struct{}), make(chan struct{})
+		go d.flushLoop(flushInterval)
+	}
+	return d, nil
+}
+
+// Encrypted reports whether the database is kept encrypted on disk
+func (d *Database) Encrypted() bool {
+	return d.enc != nil
+}
+
+// Flush writes an encrypted database to disk if it changed since the last
+// flush. It does nothing for a plain database.
+func (d *Database) Flush() error {
+	enc := d.enc
+	if enc == nil {
+		return nil
+	}
+	enc.mu.Lock()
+	defer enc.mu.Unlock()
+
+	var image []byte
+	err := enc.pin.Raw(func(dc interface{}) error {
+		var err error
+		image, err = dc.(sqliteConn).Serialize()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to serialize database: %w", err)
+	}
+	sum := sha256.Sum256(image)
+	if sum == enc.lastSum {
+		return nil
+	}
+	if err := writeEncrypted(enc.path, enc.salt, enc.aead, image); err != nil {
+		return err
+	}
+	enc.lastSum = sum
+	return nil
+}
+
+func (d *Database) flushLoop(interval time.Duration) {
+	defer close(d.enc.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := d.Flush(); err != nil {
+				log.Printf("Encrypted database not saved: %v", err)
+			}
+		case <-d.enc.stop:
+			return
+		}
+	}
+}
+
+// closeEncrypted stops the flush loop and writes the last changes
+func (d *Database) closeEncrypted() error {
+	if d.enc.stop != nil {
+		close(d.enc.stop)
+		<-d.enc.stopped
+	}
+	err := d.Flush()
+	d.enc.pin.Close()
+	return err
+}
+
+// EncryptDatabase writes an encrypted copy of the plain database at src to
+// dst, which must not exist yet. The caller removes src once the copy opens.
+func EncryptDatabase(src, dst, passphrase string) error {
+	if passphrase == "" {
+		return fmt.Errorf("the database passphrase is empty")
+	}
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("%s already exists", dst)
+	}
+	if _, err := os.Stat(src); err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+
+	db, err := sql.Open("sqlite", "file:"+src+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer db.Close()
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer conn.Close()
+
+	// Leaving WAL mode checkpoints the pages still in the WAL, and an image
+	// marked as WAL cannot be opened in memory
+	if _, err := conn.ExecContext(context.Background(), `PRAGMA journal_mode=DELETE`); err != nil {
+		return fmt.Errorf("failed to checkpoint %s: %w", src, err)
+	}
+	var image []byte
+	err = conn.Raw(func(dc interface{}) error {
+		var err error
+		image, err = dc.(sqliteConn).Serialize()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to serialize %s: %w", src, err)
+	}
+
+	salt := make([]byte, encryptedSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	aead, err := credentials.NewCipher(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	return writeEncrypted(dst, salt, aead, image)
+}
+
+// restoreImage copies a database image into the in-memory database at uri
+func restoreImage(image []byte, uri string) error {
+	tmp, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		return err
+	}
+	defer tmp.Close()
+	conn, err := tmp.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(dc interface{}) error {
+		c := dc.(sqliteConn)
+		if err := c.Deserialize(image); err != nil {
+			return err
+		}
+		return runBackup(c.NewBackup(uri))
+	})
+}
+
+// decryptImage returns the salt and the database image of an encrypted file
+func decryptImage(data []byte, passphrase string) (salt, image []byte, err error) {
+	if !bytes.HasPrefix(data, []byte(encryptedMagic)) {
+		return nil, nil, fmt.Errorf("not an encrypted database")
+	}
+	data = data[len(encryptedMagic):]
+	if len(data) < encryptedSaltSize {
+		return nil, nil, fmt.Errorf("file is truncated")
+	}
+	salt, data = data[:encryptedSaltSize], data[encryptedSaltSize:]
+
+	aead, err := credentials.NewCipher(passphrase, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, nil, fmt.Errorf("file is truncated")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	if image, err = aead.Open(nil, nonce, ciphertext, []byte(encryptedMagic)); err != nil {
+		return nil, nil, fmt.Errorf("wrong passphrase or corrupted file")
+	}
+	return salt, image, nil
+}
+
+// writeEncrypted seals image and replaces the file at path atomically, so a
+// crash leaves the previous version intact
+func writeEncrypted(path string, salt []byte, aead cipher.AEAD, image []byte) error {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	data := make([]byte, 0, len(encryptedMagic)+len(salt)+len(nonce)+len(image)+aead.Overhead())
+	data = append(data, encryptedMagic...)
+	data = append(data, salt...)
+	data = append(data, nonce...)
+	data = aead.Seal(data, nonce, image, []byte(encryptedMagic))
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
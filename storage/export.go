@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ExportFilter selects the synced emails returned by ExportEmails. Zero
+// fields do not filter.
+type ExportFilter struct {
+	AccountID   string
+	Folder      string
+	Query       string // Full-text query, as for SearchEmails
+	From        string // Part of the sender
+	Since       time.Time
+	Until       time.Time
+	Category    string // Stored classification
+	MinPriority int    // Lowest stored priority score; excludes unscored emails
+	UnreadOnly  bool
+	Limit       int
+}
+
+// ExportedEmail is a synced email with its stored classification and
+// priority, empty when the email was never classified or scored
+type ExportedEmail struct {
+	Email
+	Category      string   `json:"category,omitempty"`
+	Confidence    float64  `json:"confidence,omitempty"`
+	Tags          []string `json:"tags,omitempty"`
+	PriorityScore *int     `json:"priority_score,omitempty"`
+	PriorityLevel string   `json:"priority_level,omitempty"`
+}
+
+// ExportEmails returns the synced emails matching filter, oldest first
+func (d *Database) ExportEmails(filter ExportFilter) ([]ExportedEmail, error) {
+	stmt := `SELECT ` + emailColumns + `, c.category, c.confidence, c.tags, p.score, p.level
+		FROM emails
+		LEFT JOIN classifications c ON c.account_id = emails.account_id AND c.folder = emails.folder AND c.uid = emails.uid
+		LEFT JOIN priorities p ON p.account_id = emails.account_id AND p.folder = emails.folder AND p.uid = emails.uid
+		WHERE 1 = 1`
+	var args []interface{}
+
+	if filter.Query != "" {
+		match := buildMatchQuery(filter.Query)
+		if match == "" {
+			return nil, fmt.Errorf("search query is empty")
+		}
+		stmt += ` AND emails.id IN (SELECT rowid FROM emails_fts WHERE emails_fts MATCH ?)`
+		args = append(args, match)
+	}
+	if filter.AccountID != "" {
+		stmt += ` AND emails.account_id = ?`
+		args = append(args, filter.AccountID)
+	}
+	if filter.Folder != "" {
+		stmt += ` AND emails.folder = ?`
+		args = append(args, filter.Folder)
+	}
+	if filter.From != "" {
+		stmt += ` AND emails.sender LIKE ?`
+		args = append(args, "%"+filter.From+"%")
+	}
+	if !filter.Since.IsZero() {
+		stmt += ` AND emails.date >= ?`
+		args = append(args, filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		stmt += ` AND emails.date < ?`
+		args = append(args, filter.Until)
+	}
+	if filter.Category != "" {
+		stmt += ` AND c.category = ?`
+		args = append(args, filter.Category)
+	}
+	if filter.MinPriority > 0 {
+		stmt += ` AND p.score >= ?`
+		args = append(args, filter.MinPriority)
+	}
+	if filter.UnreadOnly {
+		stmt += ` AND instr(COALESCE(emails.flags, ''), '\Seen') = 0`
+	}
+	stmt += ` ORDER BY emails.date, emails.id`
+	if filter.Limit > 0 {
+		stmt += ` LIMIT ?`
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := d.db.Query(stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query emails: %w", err)
+	}
+	defer rows.Close()
+
+	var emails []ExportedEmail
+	for rows.Next() {
+		var category, tags, level sql.NullString
+		var confidence sql.NullFloat64
+		var score sql.NullInt64
+		e, err := scanEmail(rows, &category, &confidence, &tags, &score, &level)
+		if err != nil {
+			return nil, err
+		}
+		exported := ExportedEmail{
+			Email:         *e,
+			Category:      category.String,
+			Confidence:    confidence.Float64,
+			PriorityLevel: level.String,
+		}
+		if exported.Tags, err = decodeTags(tags); err != nil {
+			return nil, err
+		}
+		if score.Valid {
+			s := int(score.Int64)
+			exported.PriorityScore = &s
+		}
+		emails = append(emails, exported)
+	}
+	return emails, rows.Err()
+}
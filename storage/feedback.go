@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Feedback is a user's correction of a stored classification
+type Feedback struct {
+	ID        int64     `json:"id"`
+	AccountID string    `json:"account_id"`
+	Folder    string    `json:"folder"`
+	UID       uint32    `json:"uid"`
+	Sender    string    `json:"sender"`
+	Predicted string    `json:"predicted"`
+	Rule      string    `json:"rule,omitempty"` // rule behind the predicted category, if any
+	Correct   string    `json:"correct"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LearnedSender maps a sender address to the category users keep assigning
+type LearnedSender struct {
+	Sender     string    `json:"sender"`
+	Category   string    `json:"category"`
+	Confidence float64   `json:"confidence"`
+	Samples    int       `json:"samples"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func (d *Database) initFeedback() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS classification_feedback (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		account_id TEXT NOT NULL,
+		folder TEXT NOT NULL,
+		uid INTEGER NOT NULL,
+		sender TEXT NOT NULL,
+		predicted TEXT,
+		rule TEXT,
+		correct TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_feedback_sender ON classification_feedback(sender, correct);
+	CREATE INDEX IF NOT EXISTS idx_feedback_rule ON classification_feedback(rule);
+
+	CREATE TABLE IF NOT EXISTS learned_senders (
+		sender TEXT PRIMARY KEY,
+		category TEXT NOT NULL,
+		confidence REAL NOT NULL,
+		samples INTEGER NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS rule_adjustments (
+		rule TEXT PRIMARY KEY,
+		delta REAL NOT NULL,
+		updated_at DATETIME NOT NULL
+	);`
+
+	if _, err := d.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to initialize feedback: %w", err)
+	}
+	return nil
+}
+
+// SaveFeedback records a correction and returns how many corrections moved
+// this sender to the same category and how many times the predicting rule was
+// wrong, both including this one
+func (d *Database) SaveFeedback(f *Feedback) (senderSamples, ruleMistakes int, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f.Sender = strings.ToLower(f.Sender)
+	if f.CreatedAt.IsZero() {
+		f.CreatedAt = time.Now()
+	}
+
+	err = d.db.QueryRow(`
+		INSERT INTO classification_feedback (account_id, folder, uid, sender, predicted, rule, correct, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		f.AccountID, f.Folder, f.UID, f.Sender, f.Predicted, f.Rule, f.Correct, f.CreatedAt).Scan(&f.ID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to save feedback: %w", err)
+	}
+
+	err = d.db.QueryRow(`SELECT COUNT(*) FROM classification_feedback WHERE sender = ? AND correct = ?`,
+		f.Sender, f.Correct).Scan(&senderSamples)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count feedback: %w", err)
+	}
+
+	if f.Rule != "" {
+		err = d.db.QueryRow(`SELECT COUNT(*) FROM classification_feedback WHERE rule = ? AND correct <> predicted`,
+			f.Rule).Scan(&ruleMistakes)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to count feedback: %w", err)
+		}
+	}
+
+	return senderSamples, ruleMistakes, nil
+}
+
+// SaveLearnedSender stores or replaces a learned sender mapping
+func (d *Database) SaveLearnedSender(s *LearnedSender) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec(`
+		INSERT INTO learned_senders (sender, category, confidence, samples, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(sender) DO UPDATE SET
+			category = excluded.category, confidence = excluded.confidence,
+			samples = excluded.samples, updated_at = excluded.updated_at`,
+		strings.ToLower(s.Sender), s.Category, s.Confidence, s.Samples, s.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save learned sender: %w", err)
+	}
+	return nil
+}
+
+// ListLearnedSenders returns every learned sender mapping
+func (d *Database) ListLearnedSenders() ([]LearnedSender, error) {
+	rows, err := d.db.Query(`SELECT sender, category, confidence, samples, updated_at FROM learned_senders ORDER BY sender`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list learned senders: %w", err)
+	}
+	defer rows.Close()
+
+	var senders []LearnedSender
+	for rows.Next() {
+		var s LearnedSender
+		if err := rows.Scan(&s.Sender, &s.Category, &s.Confidence, &s.Samples, &s.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan learned sender: %w", err)
+		}
+		senders = append(senders, s)
+	}
+	return senders, rows.Err()
+}
+
+// SaveRuleAdjustment stores the learned confidence change of a rule
+func (d *Database) SaveRuleAdjustment(rule string, delta float64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec(`
+		INSERT INTO rule_adjustments (rule, delta, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(rule) DO UPDATE SET delta = excluded.delta, updated_at = excluded.updated_at`,
+		rule, delta, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to save rule adjustment: %w", err)
+	}
+	return nil
+}
+
+// RuleAdjustments returns the learned confidence change of each rule
+func (d *Database) RuleAdjustments() (map[string]float64, error) {
+	rows, err := d.db.Query(`SELECT rule, delta FROM rule_adjustments`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rule adjustments: %w", err)
+	}
+	defer rows.Close()
+
+	adjustments := make(map[string]float64)
+	for rows.Next() {
+		var rule string
+		var delta float64
+		if err := rows.Scan(&rule, &delta); err != nil {
+			return nil, fmt.Errorf("failed to scan rule adjustment: %w", err)
+		}
+		adjustments[rule] = delta
+	}
+	return adjustments, rows.Err()
+}
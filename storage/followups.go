@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Follow-up statuses
+const (
+	FollowupPending  = "pending"
+	FollowupResolved = "resolved"
+)
+
+// Followup is a sent message awaiting a reply by ReplyBy. It is resolved when
+// a synced email references MessageID.
+type Followup struct {
+	ID             int64      `json:"id"`
+	AccountID      string     `json:"account_id"`
+	MessageID      string     `json:"message_id"`
+	Subject        string     `json:"subject,omitempty"`
+	To             []string   `json:"to,omitempty"`
+	ReplyBy        time.Time  `json:"reply_by"`
+	Status         string     `json:"status"`
+	Overdue        bool       `json:"overdue"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`
+	ReplyFrom      string     `json:"reply_from,omitempty"`
+	ReplyMessageID string     `json:"reply_message_id,omitempty"`
+}
+
+func (d *Database) initFollowups() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS followups (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		account_id TEXT NOT NULL,
+		message_id TEXT NOT NULL,
+		subject TEXT,
+		recipients TEXT,
+		reply_by DATETIME NOT NULL,
+		status TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		resolved_at DATETIME,
+		reply_from TEXT,
+		reply_message_id TEXT,
+		UNIQUE(account_id, message_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_followups_status ON followups(account_id, status, reply_by);`
+
+	if _, err := d.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to initialize followups: %w", err)
+	}
+	return nil
+}
+
+// TrackFollowup starts waiting for a reply to f.MessageID. Tracking the same
+// message again updates its deadline. A reply that was already synced
+// resolves the follow-up at once.
+func (d *Database) TrackFollowup(f *Followup) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f.ReplyBy = f.ReplyBy.UTC()
+	f.CreatedAt = time.Now().UTC()
+
+	err := d.db.QueryRow(`
+		INSERT INTO followups (account_id, message_id, subject, recipients, reply_by, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(account_id, message_id) DO UPDATE SET reply_by = excluded.reply_by,
+			subject = COALESCE(NULLIF(excluded.subject, ''), subject),
+			recipients = COALESCE(NULLIF(excluded.recipients, ''), recipients)
+		RETURNING id, status, created_at`,
+		f.AccountID, f.MessageID, f.Subject, strings.Join(f.To, ", "), f.ReplyBy, FollowupPending, f.CreatedAt).
+		Scan(&f.ID, &f.Status, &f.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to track follow-up: %w", err)
+	}
+	if f.Status != FollowupPending {
+		return nil
+	}
+
+	var from, messageID sql.NullString
+	err = d.db.QueryRow(`SELECT sender, message_id FROM emails
+		WHERE account_id = ? AND (in_reply_to = ? OR ' ' || references_ids || ' ' LIKE ?)
+		ORDER BY date LIMIT 1`,
+		f.AccountID, f.MessageID, "% "+f.MessageID+" %").Scan(&from, &messageID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look for replies: %w", err)
+	}
+	if _, err := d.resolveFollowups(f.AccountID, []string{f.MessageID}, from.String, messageID.String); err != nil {
+		return err
+	}
+	f.Status, f.ReplyFrom, f.ReplyMessageID = FollowupResolved, from.String, messageID.String
+	return nil
+}
+
+// ResolveFollowups marks the follow-ups answered by email as resolved and
+// returns how many there were
+func (d *Database) ResolveFollowups(email *Email) (int, error) {
+	parents := append([]string{}, email.References...)
+	if email.InReplyTo != "" {
+		parents = append(parents, email.InReplyTo)
+	}
+	if len(parents) == 0 {
+		return 0, nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.resolveFollowups(email.AccountID, parents, email.From, email.MessageID)
+}
+
+func (d *Database) resolveFollowups(accountID string, messageIDs []string, replyFrom, replyMessageID string) (int, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(messageIDs)), ", ")
+	args := []interface{}{FollowupResolved, time.Now().UTC(), replyFrom, replyMessageID, accountID, FollowupPending}
+	for _, id := range messageIDs {
+		args = append(args, id)
+	}
+
+	res, err := d.db.Exec(`UPDATE followups SET status = ?, resolved_at = ?, reply_from = ?, reply_message_id = ?
+		WHERE account_id = ? AND status = ? AND message_id IN (`+placeholders+`)`, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve follow-ups: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// ListFollowups returns the follow-ups of an account (all accounts if
+// accountID is empty) by deadline, marking those past it at now as overdue.
+// Resolved follow-ups are only included when includeResolved is set.
+func (d *Database) ListFollowups(accountID string, includeResolved bool, now time.Time) ([]Followup, error) {
+	query := `SELECT id, account_id, message_id, subject, recipients, reply_by, status, created_at,
+		resolved_at, reply_from, reply_message_id FROM followups WHERE 1 = 1`
+	var args []interface{}
+	if accountID != "" {
+		query += ` AND account_id = ?`
+		args = append(args, accountID)
+	}
+	if !includeResolved {
+		query += ` AND status = ?`
+		args = append(args, FollowupPending)
+	}
+	query += ` ORDER BY reply_by`
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query follow-ups: %w", err)
+	}
+	defer rows.Close()
+
+	var followups []Followup
+	for rows.Next() {
+		var f Followup
+		var subject, recipients, replyFrom, replyMessageID sql.NullString
+		var resolvedAt sql.NullTime
+		if err := rows.Scan(&f.ID, &f.AccountID, &f.MessageID, &subject, &recipients, &f.ReplyBy, &f.Status,
+			&f.CreatedAt, &resolvedAt, &replyFrom, &replyMessageID); err != nil {
+			return nil, fmt.Errorf("failed to scan follow-up: %w", err)
+		}
+
+		f.Subject = subject.String
+		f.To = splitAddresses(recipients.String)
+		f.ReplyFrom = replyFrom.String
+		f.ReplyMessageID = replyMessageID.String
+		if resolvedAt.Valid {
+			f.ResolvedAt = &resolvedAt.Time
+		}
+		f.Overdue = f.Status == FollowupPending && f.ReplyBy.Before(now)
+		followups = append(followups, f)
+	}
+	return followups, rows.Err()
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Messages of Microsoft Graph accounts are identified by long immutable IDs
+// rather than IMAP UIDs. graph_ids numbers them per account, in the order
+// they are first seen, so the tools and tables keyed by UID work unchanged.
+// Immutable IDs survive moves, so a message keeps its UID in every folder.
+
+func (d *Database) initGraphIDs() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS graph_ids (
+		account_id TEXT NOT NULL,
+		uid INTEGER NOT NULL,
+		graph_id TEXT NOT NULL,
+		PRIMARY KEY(account_id, uid),
+		UNIQUE(account_id, graph_id)
+	);`
+
+	if _, err := d.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to initialize graph IDs: %w", err)
+	}
+	return nil
+}
+
+// GraphUID returns the UID of a Microsoft Graph message, assigning the next
+// free one the first time the message is seen
+func (d *Database) GraphUID(accountID, graphID string) (uint32, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var uid uint32
+	err := d.db.QueryRow(`
+		INSERT INTO graph_ids (account_id, uid, graph_id)
+		SELECT ?, COALESCE(MAX(uid), 0) + 1, ? FROM graph_ids WHERE account_id = ?
+		ON CONFLICT(account_id, graph_id) DO UPDATE SET uid = graph_ids.uid
+		RETURNING uid`, accountID, graphID, accountID).Scan(&uid)
+	if err != nil {
+		return 0, fmt.Errorf("failed to number graph message: %w", err)
+	}
+	return uid, nil
+}
+
+// GraphID returns the Microsoft Graph ID of the message numbered uid
+func (d *Database) GraphID(accountID string, uid uint32) (string, error) {
+	var graphID string
+	err := d.db.QueryRow(`SELECT graph_id FROM graph_ids WHERE account_id = ? AND uid = ?`, accountID, uid).Scan(&graphID)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("email with ID %d %w; list the folder again", uid, ErrNotFound)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read graph ID: %w", err)
+	}
+	return graphID, nil
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Invoice is the payment a synced invoice email asks for
+type Invoice struct {
+	AccountID   string     `json:"account_id"`
+	Folder      string     `json:"folder"`
+	UID         uint32     `json:"uid"`
+	Vendor      string     `json:"vendor,omitempty"`
+	Number      string     `json:"number,omitempty"`
+	Amount      float64    `json:"amount,omitempty"`
+	Currency    string     `json:"currency,omitempty"`
+	Due         *time.Time `json:"due,omitempty"`
+	Method      string     `json:"method"` // how it was extracted: "llm" or "rules"
+	ExtractedAt time.Time  `json:"extracted_at"`
+	// The email it was found in, set by ListInvoices and UpcomingPayments
+	Email *Email `json:"email,omitempty"`
+}
+
+func (d *Database) initInvoices() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS invoices (
+		account_id TEXT NOT NULL,
+		folder TEXT NOT NULL,
+		uid INTEGER NOT NULL,
+		vendor TEXT,
+		number TEXT,
+		amount REAL,
+		currency TEXT,
+		due DATETIME,
+		method TEXT NOT NULL,
+		extracted_at DATETIME NOT NULL,
+		PRIMARY KEY(account_id, folder, uid)
+	);
+	CREATE INDEX IF NOT EXISTS idx_invoices_due ON invoices(account_id, due);`
+
+	if _, err := d.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to initialize invoices: %w", err)
+	}
+	return nil
+}
+
+// SaveInvoice stores the invoice of an email, replacing any extracted before
+func (d *Database) SaveInvoice(inv *Invoice) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var due interface{}
+	if inv.Due != nil {
+		due = inv.Due.UTC()
+	}
+	inv.ExtractedAt = inv.ExtractedAt.UTC()
+	_, err := d.db.Exec(`
+		INSERT INTO invoices (account_id, folder, uid, vendor, number, amount, currency, due, method, extracted_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(account_id, folder, uid) DO UPDATE SET vendor = excluded.vendor, number = excluded.number,
+			amount = excluded.amount, currency = excluded.currency, due = excluded.due, method = excluded.method,
+			extracted_at = excluded.extracted_at`,
+		inv.AccountID, inv.Folder, inv.UID, inv.Vendor, inv.Number, inv.Amount, inv.Currency, due, inv.Method, inv.ExtractedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save invoice: %w", err)
+	}
+	return nil
+}
+
+const invoiceColumns = `invoices.vendor, invoices.number, invoices.amount, invoices.currency, invoices.due,
+	invoices.method, invoices.extracted_at`
+
+// ListInvoices returns the invoices of an account's emails received since a
+// time, newest first. A non-empty folder keeps the invoices of that folder,
+// and a non-empty vendor those whose vendor contains it.
+func (d *Database) ListInvoices(accountID, folder, vendor string, since time.Time, limit int) ([]Invoice, error) {
+	query := `SELECT ` + emailColumns + `, ` + invoiceColumns + ` FROM invoices
+		JOIN emails ON emails.account_id = invoices.account_id AND emails.folder = invoices.folder AND emails.uid = invoices.uid
+		WHERE invoices.account_id = ? AND (? = '' OR invoices.folder = ?) AND emails.date >= ?`
+	args := []interface{}{accountID, folder, folder, since.UTC()}
+	if vendor != "" {
+		query += ` AND invoices.vendor LIKE ? ESCAPE '\'`
+		args = append(args, "%"+strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(vendor)+"%")
+	}
+	query += ` ORDER BY emails.date DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query invoices: %w", err)
+	}
+	return scanInvoices(rows)
+}
+
+// UpcomingPayments returns the invoices of an account due between from and
+// until, soonest first
+func (d *Database) UpcomingPayments(accountID string, from, until time.Time, limit int) ([]Invoice, error) {
+	rows, err := d.db.Query(`
+		SELECT `+emailColumns+`, `+invoiceColumns+` FROM invoices
+		JOIN emails ON emails.account_id = invoices.account_id AND emails.folder = invoices.folder AND emails.uid = invoices.uid
+		WHERE invoices.account_id = ? AND invoices.due >= ? AND invoices.due <= ?
+		ORDER BY invoices.due, emails.date DESC LIMIT ?`,
+		accountID, from.UTC(), until.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query invoices: %w", err)
+	}
+	return scanInvoices(rows)
+}
+
+func scanInvoices(rows *sql.Rows) ([]Invoice, error) {
+	defer rows.Close()
+
+	var invoices []Invoice
+	for rows.Next() {
+		var inv Invoice
+		var vendor, number, currency sql.NullString
+		var amount sql.NullFloat64
+		var due sql.NullTime
+		e, err := scanEmail(rows, &vendor, &number, &amount, &currency, &due, &inv.Method, &inv.ExtractedAt)
+		if err != nil {
+			return nil, err
+		}
+		inv.AccountID, inv.Folder, inv.UID = e.AccountID, e.Folder, e.UID
+		inv.Vendor = vendor.String
+		inv.Number = number.String
+		inv.Amount = amount.Float64
+		inv.Currency = currency.String
+		if due.Valid {
+			inv.Due = &due.Time
+		}
+		inv.Email = e
+		invoices = append(invoices, inv)
+	}
+	return invoices, rows.Err()
+}
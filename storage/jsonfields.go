@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// Priority factors and classification tags are stored as JSON, an object of
+// factor names to points and an array of tags, so every factor and tag is
+// read back as it was saved.
+
+// encodeTags returns the JSON of tags
+func encodeTags(tags []string) (string, error) {
+	data, err := json.Marshal(tags)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode tags: %w", err)
+	}
+	return string(data), nil
+}
+
+// decodeTags reads tags stored by encodeTags; NULL or empty has none
+func decodeTags(stored sql.NullString) ([]string, error) {
+	var tags []string
+	if stored.String != "" {
+		if err := json.Unmarshal([]byte(stored.String), &tags); err != nil {
+			return nil, fmt.Errorf("failed to decode tags: %w", err)
+		}
+	}
+	return tags, nil
+}
+
+// encodeFactors returns the JSON of factors
+func encodeFactors(factors map[string]int) (string, error) {
+	data, err := json.Marshal(factors)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode factors: %w", err)
+	}
+	return string(data), nil
+}
+
+// decodeFactors reads factors stored by encodeFactors; NULL or empty has
+// none
+func decodeFactors(stored sql.NullString) (map[string]int, error) {
+	var factors map[string]int
+	if stored.String != "" {
+		if err := json.Unmarshal([]byte(stored.String), &factors); err != nil {
+			return nil, fmt.Errorf("failed to decode factors: %w", err)
+		}
+	}
+	return factors, nil
+}
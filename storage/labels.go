@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// LabelTagPrefix starts the classification tags that mirror the Gmail labels
+// of an email, such as "label:Receipts"
+const LabelTagPrefix = "label:"
+
+// SaveLabels replaces the label tags of the classifications of a folder's
+// emails, by UID, with their Gmail labels. Emails that were never classified
+// have no tags to update and are skipped.
+func (d *Database) SaveLabels(accountID, folder string, labels map[uint32][]string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to save labels: %w", err)
+	}
+	defer tx.Rollback()
+	db := prepared{d: d, tx: tx}
+
+	for uid, l := range labels {
+		var stored sql.NullString
+		err := db.QueryRow(`SELECT tags FROM classifications WHERE account_id = ? AND folder = ? AND uid = ?`,
+			accountID, folder, uid).Scan(&stored)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tags: %w", err)
+		}
+		tags, err := decodeTags(stored)
+		if err != nil {
+			return err
+		}
+		encoded, err := encodeTags(withLabelTags(tags, labelTags(l)))
+		if err != nil {
+			return err
+		}
+		if _, err := db.Exec(`UPDATE classifications SET tags = ? WHERE account_id = ? AND folder = ? AND uid = ?`,
+			encoded, accountID, folder, uid); err != nil {
+			return fmt.Errorf("failed to save labels: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to save labels: %w", err)
+	}
+	return nil
+}
+
+// labelTags returns the tags of labels
+func labelTags(labels []string) []string {
+	tags := make([]string, len(labels))
+	for i, label := range labels {
+		tags[i] = LabelTagPrefix + label
+	}
+	return tags
+}
+
+// onlyLabelTags returns the label tags among tags
+func onlyLabelTags(tags []string) []string {
+	var labels []string
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, LabelTagPrefix) {
+			labels = append(labels, tag)
+		}
+	}
+	return labels
+}
+
+// withLabelTags returns tags with its label tags replaced by labelTags
+func withLabelTags(tags, labelTags []string) []string {
+	var result []string
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag, LabelTagPrefix) {
+			result = append(result, tag)
+		}
+	}
+	return append(result, labelTags...)
+}
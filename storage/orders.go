@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Order is a purchase a synced order, shipping or delivery email reports on
+type Order struct {
+	AccountID      string    `json:"account_id"`
+	Folder         string    `json:"folder"`
+	UID            uint32    `json:"uid"`
+	Merchant       string    `json:"merchant,omitempty"`
+	Number         string    `json:"number,omitempty"`
+	Status         string    `json:"status"`
+	Carrier        string    `json:"carrier,omitempty"`
+	TrackingNumber string    `json:"tracking_number,omitempty"`
+	TrackingURL    string    `json:"tracking_url,omitempty"`
+	Total          float64   `json:"total,omitempty"`
+	Currency       string    `json:"currency,omitempty"`
+	ExtractedAt    time.Time `json:"extracted_at"`
+	// The latest email about the order, set by ListOrders
+	Email *Email `json:"email,omitempty"`
+}
+
+func (d *Database) initOrders() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS orders (
+		account_id TEXT NOT NULL,
+		folder TEXT NOT NULL,
+		uid INTEGER NOT NULL,
+		merchant TEXT,
+		number TEXT,
+		status TEXT NOT NULL,
+		carrier TEXT,
+		tracking_number TEXT,
+		tracking_url TEXT,
+		total REAL,
+		currency TEXT,
+		extracted_at DATETIME NOT NULL,
+		PRIMARY KEY(account_id, folder, uid)
+	);`
+
+	if _, err := d.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to initialize orders: %w", err)
+	}
+	return nil
+}
+
+// SaveOrder stores the order an email reports on, replacing any extracted
+// before
+func (d *Database) SaveOrder(order *Order) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	order.ExtractedAt = order.ExtractedAt.UTC()
+	_, err := d.db.Exec(`
+		INSERT INTO orders (account_id, folder, uid, merchant, number, status, carrier, tracking_number, tracking_url,
+			total, currency, extracted_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(account_id, folder, uid) DO UPDATE SET merchant = excluded.merchant, number = excluded.number,
+			status = excluded.status, carrier = excluded.carrier, tracking_number = excluded.tracking_number,
+			tracking_url = excluded.tracking_url, total = excluded.total, currency = excluded.currency,
+			extracted_at = excluded.extracted_at`,
+		order.AccountID, order.Folder, order.UID, order.Merchant, order.Number, order.Status, order.Carrier,
+		order.TrackingNumber, order.TrackingURL, order.Total, order.Currency, order.ExtractedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save order: %w", err)
+	}
+	return nil
+}
+
+// ListOrders returns the orders of an account's emails received since a
+// time, newest first. The emails about one order, from its confirmation to
+// its delivery, make up a single order with the status of the latest and
+// the tracking and total of whichever mentions them. A non-empty folder
+// keeps the emails of that folder, and a non-empty status the orders in it.
+func (d *Database) ListOrders(accountID, folder, status string, since time.Time, limit int) ([]Order, error) {
+	rows, err := d.db.Query(`
+		SELECT `+emailColumns+`, orders.merchant, orders.number, orders.status, orders.carrier, orders.tracking_number,
+			orders.tracking_url, orders.total, orders.currency, orders.extracted_at FROM orders
+		JOIN emails ON emails.account_id = orders.account_id AND emails.folder = orders.folder AND emails.uid = orders.uid
+		WHERE orders.account_id = ? AND (? = '' OR orders.folder = ?) AND emails.date >= ?
+		ORDER BY emails.date DESC`,
+		accountID, folder, folder, since.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query orders: %w", err)
+	}
+	defer rows.Close()
+
+	var orders []Order
+	index := make(map[string]int)
+	for rows.Next() {
+		var o Order
+		var merchant, number, carrier, trackingNumber, trackingURL, currency sql.NullString
+		var total sql.NullFloat64
+		e, err := scanEmail(rows, &merchant, &number, &o.Status, &carrier, &trackingNumber, &trackingURL, &total, &currency, &o.ExtractedAt)
+		if err != nil {
+			return nil, err
+		}
+		o.AccountID, o.Folder, o.UID = e.AccountID, e.Folder, e.UID
+		o.Merchant, o.Number, o.Carrier = merchant.String, number.String, carrier.String
+		o.TrackingNumber, o.TrackingURL = trackingNumber.String, trackingURL.String
+		o.Total, o.Currency = total.Float64, currency.String
+		o.Email = e
+
+		if o.Number == "" {
+			orders = append(orders, o)
+			continue
+		}
+		key := strings.ToLower(o.Merchant) + "\x00" + o.Number
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(orders)
+			orders = append(orders, o)
+			continue
+		}
+		// Rows come newest first: older emails only fill in what is missing
+		latest := &orders[i]
+		if latest.TrackingNumber == "" {
+			latest.TrackingNumber = o.TrackingNumber
+		}
+		if latest.Carrier == "" {
+			latest.Carrier = o.Carrier
+		}
+		if latest.TrackingURL == "" {
+			latest.TrackingURL = o.TrackingURL
+		}
+		if latest.Total == 0 {
+			latest.Total, latest.Currency = o.Total, o.Currency
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var result []Order
+	for _, o := range orders {
+		if (status == "" || o.Status == status) && len(result) < limit {
+			result = append(result, o)
+		}
+	}
+	return result, nil
+}
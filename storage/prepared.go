@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"database/sql"
+)
+
+// stmt returns query prepared on the database, preparing it the first time it
+// is run. Statements live until the database is closed; SQLite prepares them
+// again by itself after a schema change, such as a restore.
+func (d *Database) stmt(query string) (*sql.Stmt, error) {
+	d.stmtMu.Lock()
+	defer d.stmtMu.Unlock()
+
+	if s, ok := d.stmts[query]; ok {
+		return s, nil
+	}
+	s, err := d.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	if d.stmts == nil {
+		d.stmts = make(map[string]*sql.Stmt)
+	}
+	d.stmts[query] = s
+	return s, nil
+}
+
+// closeStmts closes the prepared statements
+func (d *Database) closeStmts() {
+	d.stmtMu.Lock()
+	defer d.stmtMu.Unlock()
+
+	for _, s := range d.stmts {
+		s.Close()
+	}
+	d.stmts = nil
+}
+
+// prepared runs queries through the prepared statements of a database, in tx
+// when it is set. It implements execer, so the helpers shared with other
+// writes get prepared statements too.
+type prepared struct {
+	d  *Database
+	tx *sql.Tx
+}
+
+// prepared returns the statements of d outside a transaction
+func (d *Database) prepared() prepared {
+	return prepared{d: d}
+}
+
+func (p prepared) prepare(query string) (*sql.Stmt, error) {
+	s, err := p.d.stmt(query)
+	if err != nil || p.tx == nil {
+		return s, err
+	}
+	return p.tx.Stmt(s), nil
+}
+
+func (p prepared) Exec(query string, args ...interface{}) (sql.Result, error) {
+	s, err := p.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return s.Exec(args...)
+}
+
+func (p prepared) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	s, err := p.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return s.Query(args...)
+}
+
+// QueryRow reports a statement that cannot be prepared through the returned
+// row, by running it unprepared
+func (p prepared) QueryRow(query string, args ...interface{}) *sql.Row {
+	s, err := p.prepare(query)
+	if err == nil {
+		return s.QueryRow(args...)
+	}
+	if p.tx != nil {
+		return p.tx.QueryRow(query, args...)
+	}
+	return p.d.db.QueryRow(query, args...)
+}
@@ -0,0 +1,440 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Priority is the stored priority score of an email
+type Priority struct {
+	AccountID string         `json:"account_id"`
+	Folder    string         `json:"folder"`
+	UID       uint32         `json:"uid"`
+	Score     int            `json:"score"`
+	Level     string         `json:"level"`
+	Factors   map[string]int `json:"factors,omitempty"`
+	ScoredAt  time.Time      `json:"scored_at"`
+}
+
+// LevelCount is the number of emails at a priority level
+type LevelCount struct {
+	Level        string  `json:"level"`
+	Count        int     `json:"count"`
+	AverageScore float64 `json:"average_score"`
+}
+
+// FactorCount is the number of emails a priority factor applied to
+type FactorCount struct {
+	Factor string `json:"factor"`
+	Count  int    `json:"count"`
+}
+
+// ThreadPriority rolls up the priorities of the emails of a thread
+type ThreadPriority struct {
+	AccountID    string    `json:"account_id"`
+	ThreadID     string    `json:"thread_id"`
+	Subject      string    `json:"subject"` // of the first email
+	Messages     int       `json:"messages"`
+	Scored       int       `json:"scored"` // emails with a stored priority
+	Unread       int       `json:"unread"`
+	MaxScore     int       `json:"max_score"`
+	MeanScore    float64   `json:"mean_score"`
+	LastActivity time.Time `json:"last_activity"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// PriorityDistribution describes the stored priorities of an account's
+// synced emails. Emails no longer in the database are left out.
+type PriorityDistribution struct {
+	AccountID    string        `json:"account_id"`
+	Levels       []LevelCount  `json:"levels"` // highest level first
+	Factors      []FactorCount `json:"factors"`
+	Scored       int           `json:"scored"`
+	Unscored     int           `json:"unscored"` // synced emails never scored
+	AverageScore float64       `json:"average_score"`
+}
+
+func (d *Database) initPriorities() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS priorities (
+		account_id TEXT NOT NULL,
+		folder TEXT NOT NULL,
+		uid INTEGER NOT NULL,
+		score INTEGER NOT NULL,
+		level TEXT NOT NULL,
+		factors TEXT,
+		scored_at DATETIME NOT NULL,
+		PRIMARY KEY(account_id, folder, uid)
+	);
+	CREATE INDEX IF NOT EXISTS idx_priorities_level ON priorities(account_id, level);
+
+	CREATE TABLE IF NOT EXISTS thread_priorities (
+		account_id TEXT NOT NULL,
+		thread_id TEXT NOT NULL,
+		subject TEXT,
+		messages INTEGER NOT NULL,
+		scored INTEGER NOT NULL,
+		unread INTEGER NOT NULL,
+		max_score INTEGER NOT NULL,
+		mean_score REAL NOT NULL,
+		last_activity DATETIME,
+		updated_at DATETIME NOT NULL,
+		PRIMARY KEY(account_id, thread_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_thread_priorities_activity ON thread_priorities(account_id, last_activity);`
+
+	if _, err := d.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to initialize priorities: %w", err)
+	}
+	if err := d.initPriorityBuckets(); err != nil {
+		return err
+	}
+
+	// Databases synced before thread rollups existed get them now
+	var threads int
+	if err := d.db.QueryRow(`SELECT COUNT(*) FROM thread_priorities`).Scan(&threads); err != nil {
+		return fmt.Errorf("failed to initialize priorities: %w", err)
+	}
+	if threads > 0 {
+		return nil
+	}
+	rows, err := d.db.Query(`SELECT DISTINCT account_id FROM emails`)
+	if err != nil {
+		return fmt.Errorf("failed to initialize priorities: %w", err)
+	}
+	var accounts []string
+	for rows.Next() {
+		var accountID string
+		if err := rows.Scan(&accountID); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to initialize priorities: %w", err)
+		}
+		accounts = append(accounts, accountID)
+	}
+	rows.Close()
+	for _, accountID := range accounts {
+		if err := d.rebuildThreadPriorities(accountID); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// initPriorityBuckets copies the level and score of each stored priority to
+// the priority_bucket and priority_score columns of its email, kept up to date
+// by triggers, so emails are counted and listed by level from the emails
+// table and its index alone
+func (d *Database) initPriorityBuckets() error {
+	var existing int
+	err := d.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('emails') WHERE name = 'priority_bucket'`).Scan(&existing)
+	if err != nil {
+		return fmt.Errorf("failed to inspect table emails: %w", err)
+	}
+	for _, column := range []string{"priority_bucket TEXT", "priority_score INTEGER"} {
+		if err := d.addColumn("emails", column); err != nil {
+			return err
+		}
+	}
+
+	schema := `
+	CREATE INDEX IF NOT EXISTS idx_emails_priority ON emails(account_id, priority_bucket, priority_score);
+
+	CREATE TRIGGER IF NOT EXISTS priorities_bucket_insert AFTER INSERT ON priorities BEGIN
+		UPDATE emails SET priority_bucket = new.level, priority_score = new.score
+		WHERE account_id = new.account_id AND folder = new.folder AND uid = new.uid;
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS priorities_bucket_update AFTER UPDATE OF score, level ON priorities BEGIN
+		UPDATE emails SET priority_bucket = new.level, priority_score = new.score
+		WHERE account_id = new.account_id AND folder = new.folder AND uid = new.uid;
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS priorities_bucket_delete AFTER DELETE ON priorities BEGIN
+		UPDATE emails SET priority_bucket = NULL, priority_score = NULL
+		WHERE account_id = old.account_id AND folder = old.folder AND uid = old.uid;
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS emails_bucket_insert AFTER INSERT ON emails
+	WHEN EXISTS (SELECT 1 FROM priorities WHERE account_id = new.account_id AND folder = new.folder AND uid = new.uid) BEGIN
+		UPDATE emails SET (priority_bucket, priority_score) = (SELECT level, score FROM priorities
+			WHERE account_id = new.account_id AND folder = new.folder AND uid = new.uid)
+		WHERE id = new.id;
+	END;`
+	if _, err := d.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to initialize priority buckets: %w", err)
+	}
+	if existing > 0 {
+		return nil
+	}
+
+	// Emails scored before the columns existed
+	_, err = d.db.Exec(`UPDATE emails SET (priority_bucket, priority_score) = (SELECT p.level, p.score FROM priorities p
+		WHERE p.account_id = emails.account_id AND p.folder = emails.folder AND p.uid = emails.uid)`)
+	if err != nil {
+		return fmt.Errorf("failed to fill priority buckets: %w", err)
+	}
+	return nil
+}
+
+// SavePriority stores the priority of an email, replacing any previous one,
+// and updates the rollup of its thread
+func (d *Database) SavePriority(p *Priority) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	db := d.prepared()
+	factors, err := encodeFactors(p.Factors)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO priorities (account_id, folder, uid, score, level, factors, scored_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(account_id, folder, uid) DO UPDATE SET
+			score = excluded.score, level = excluded.level, factors = excluded.factors, scored_at = excluded.scored_at`,
+		p.AccountID, p.Folder, p.UID, p.Score, p.Level, factors, p.ScoredAt)
+	if err != nil {
+		return fmt.Errorf("failed to save priority: %w", err)
+	}
+
+	var threadID sql.NullString
+	err = db.QueryRow(`SELECT thread_id FROM emails WHERE account_id = ? AND folder = ? AND uid = ?`,
+		p.AccountID, p.Folder, p.UID).Scan(&threadID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save priority: %w", err)
+	}
+	return refreshThreadPriority(db, p.AccountID, threadID.String)
+}
+
+// refreshThreadPriority recomputes the rollup of a thread from its emails and
+// their stored priorities, removing it when the thread has no emails left
+func refreshThreadPriority(db execer, accountID, threadID string) error {
+	if threadID == "" {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	res, err := db.Exec(`
+		INSERT INTO thread_priorities (account_id, thread_id, subject, messages, scored, unread, max_score, mean_score, last_activity, updated_at)
+		SELECT e.account_id, e.thread_id,
+			(SELECT f.subject FROM emails f WHERE f.account_id = e.account_id AND f.thread_id = e.thread_id ORDER BY f.date, f.id LIMIT 1),
+			COUNT(*), COUNT(p.score), SUM(instr(COALESCE(e.flags, ''), '\Seen') = 0),
+			COALESCE(MAX(p.score), 0), COALESCE(AVG(p.score), 0), MAX(e.date), ?
+		FROM emails e
+		LEFT JOIN priorities p ON p.account_id = e.account_id AND p.folder = e.folder AND p.uid = e.uid
+		WHERE e.account_id = ? AND e.thread_id = ?
+		GROUP BY e.account_id, e.thread_id
+		ON CONFLICT(account_id, thread_id) DO UPDATE SET
+			subject = excluded.subject, messages = excluded.messages, scored = excluded.scored, unread = excluded.unread,
+			max_score = excluded.max_score, mean_score = excluded.mean_score, last_activity = excluded.last_activity,
+			updated_at = excluded.updated_at`,
+		now, accountID, threadID)
+	if err != nil {
+		return fmt.Errorf("failed to update thread priority: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if _, err := db.Exec(`DELETE FROM thread_priorities WHERE account_id = ? AND thread_id = ?`, accountID, threadID); err != nil {
+			return fmt.Errorf("failed to update thread priority: %w", err)
+		}
+	}
+	return nil
+}
+
+// rebuildThreadPriorities recomputes the rollups of every thread of an account
+func (d *Database) rebuildThreadPriorities(accountID string) error {
+	if _, err := d.db.Exec(`DELETE FROM thread_priorities WHERE account_id = ?`, accountID); err != nil {
+		return fmt.Errorf("failed to rebuild thread priorities: %w", err)
+	}
+	rows, err := d.db.Query(`SELECT DISTINCT thread_id FROM emails WHERE account_id = ? AND thread_id <> ''`, accountID)
+	if err != nil {
+		return fmt.Errorf("failed to rebuild thread priorities: %w", err)
+	}
+	var threadIDs []string
+	for rows.Next() {
+		var threadID string
+		if err := rows.Scan(&threadID); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to rebuild thread priorities: %w", err)
+		}
+		threadIDs = append(threadIDs, threadID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to rebuild thread priorities: %w", err)
+	}
+
+	for _, threadID := range threadIDs {
+		if err := refreshThreadPriority(d.db, accountID, threadID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ThreadPriorities returns the thread rollups of an account with activity
+// since the given time, most recently active first
+func (d *Database) ThreadPriorities(accountID string, since time.Time) ([]ThreadPriority, error) {
+	rows, err := d.db.Query(`
+		SELECT thread_id, subject, messages, scored, unread, max_score, mean_score, last_activity, updated_at
+		FROM thread_priorities WHERE account_id = ? AND last_activity >= ?
+		ORDER BY last_activity DESC`, accountID, since.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query thread priorities: %w", err)
+	}
+	defer rows.Close()
+
+	var threads []ThreadPriority
+	for rows.Next() {
+		t := ThreadPriority{AccountID: accountID}
+		var subject sql.NullString
+		if err := rows.Scan(&t.ThreadID, &subject, &t.Messages, &t.Scored, &t.Unread, &t.MaxScore, &t.MeanScore,
+			&t.LastActivity, &t.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan thread priority: %w", err)
+		}
+		t.Subject = subject.String
+		threads = append(threads, t)
+	}
+	return threads, rows.Err()
+}
+
+// GetPriority returns the stored priority of an email, or nil if it was never
+// scored
+func (d *Database) GetPriority(accountID, folder string, uid uint32) (*Priority, error) {
+	p := &Priority{AccountID: accountID, Folder: folder, UID: uid}
+	var factors sql.NullString
+
+	err := d.db.QueryRow(`
+		SELECT score, level, factors, scored_at FROM priorities
+		WHERE account_id = ? AND folder = ? AND uid = ?`, accountID, folder, uid).
+		Scan(&p.Score, &p.Level, &factors, &p.ScoredAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read priority: %w", err)
+	}
+
+	if p.Factors, err = decodeFactors(factors); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// PrioritizedEmail is a synced email with its stored priority
+type PrioritizedEmail struct {
+	Email
+	Score int    `json:"score"`
+	Level string `json:"level"`
+}
+
+// How PriorityInbox lists starred emails
+const (
+	StarredOnly  = "only"  // only starred emails
+	StarredFirst = "first" // starred emails of any level, above the others
+)
+
+// PriorityInbox returns the scored emails of an account, or of one of its
+// folders when folder is not empty, whose priority level is one of levels,
+// highest score first, then newest first. starred is "", StarredOnly or
+// StarredFirst.
+func (d *Database) PriorityInbox(accountID, folder string, levels []string, starred string, limit int) ([]PrioritizedEmail, error) {
+	if len(levels) == 0 {
+		return nil, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(levels)), ", ")
+	args := []interface{}{accountID, folder, folder}
+	for _, level := range levels {
+		args = append(args, level)
+	}
+	pinned := starred == StarredFirst
+	args = append(args, pinned, starred == StarredOnly, pinned, limit)
+
+	rows, err := d.db.Query(`SELECT `+emailColumns+`, emails.priority_score, emails.priority_bucket FROM emails
+		WHERE account_id = ? AND (? = '' OR folder = ?) AND priority_bucket IS NOT NULL
+			AND (priority_bucket IN (`+placeholders+`) OR (? AND `+starredCondition+`))
+			AND (NOT ? OR `+starredCondition+`)
+		ORDER BY ? AND `+starredCondition+` DESC, priority_score DESC, date DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query priority inbox: %w", err)
+	}
+	defer rows.Close()
+
+	var emails []PrioritizedEmail
+	for rows.Next() {
+		var p PrioritizedEmail
+		e, err := scanEmail(rows, &p.Score, &p.Level)
+		if err != nil {
+			return nil, err
+		}
+		p.Email = *e
+		emails = append(emails, p)
+	}
+	return emails, rows.Err()
+}
+
+// PriorityDistribution counts the scored emails of an account per level and
+// per factor
+func (d *Database) PriorityDistribution(accountID string) (*PriorityDistribution, error) {
+	dist := &PriorityDistribution{AccountID: accountID, Levels: []LevelCount{}, Factors: []FactorCount{}}
+
+	// Unscored emails are the group without a bucket
+	rows, err := d.db.Query(`
+		SELECT priority_bucket, COUNT(*), COALESCE(AVG(priority_score), 0) FROM emails
+		WHERE account_id = ?
+		GROUP BY priority_bucket ORDER BY MIN(priority_score) DESC`, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count priority levels: %w", err)
+	}
+	var total float64
+	for rows.Next() {
+		var bucket sql.NullString
+		var level LevelCount
+		if err := rows.Scan(&bucket, &level.Count, &level.AverageScore); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan priority level: %w", err)
+		}
+		if !bucket.Valid {
+			dist.Unscored = level.Count
+			continue
+		}
+		level.Level = bucket.String
+		dist.Levels = append(dist.Levels, level)
+		dist.Scored += level.Count
+		total += level.AverageScore * float64(level.Count)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if dist.Scored > 0 {
+		dist.AverageScore = total / float64(dist.Scored)
+	}
+
+	rows, err = d.db.Query(`
+		SELECT f.key, COUNT(*) FROM priorities p
+		JOIN emails e ON e.account_id = p.account_id AND e.folder = p.folder AND e.uid = p.uid
+		JOIN json_each(p.factors) f
+		WHERE p.account_id = ? AND f.key <> 'base'
+		GROUP BY f.key ORDER BY COUNT(*) DESC, f.key`, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count priority factors: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var factor FactorCount
+		if err := rows.Scan(&factor.Factor, &factor.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan priority factor: %w", err)
+		}
+		dist.Factors = append(dist.Factors, factor)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return dist, nil
+}
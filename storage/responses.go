@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ContactResponse is how quickly the user replied to one contact
+type ContactResponse struct {
+	Contact string `json:"contact"`
+	ResponseTime
+	LastReply time.Time `json:"last_reply"`
+}
+
+// ResponseActivity describes how the user of an account answered their mail
+// between Since and Until, from the emails synced from INBOX and Sent
+type ResponseActivity struct {
+	AccountID string    `json:"account_id"`
+	Since     time.Time `json:"since"`
+	Until     time.Time `json:"until"`
+	Sent      int       `json:"sent"`
+	// Replies to any contact
+	Overall *ResponseTime `json:"response_time,omitempty"`
+	// Most replied contacts first
+	Contacts []ContactResponse `json:"contacts"`
+	// Received emails with no later message from the user in their thread,
+	// oldest first
+	Unanswered []Email `json:"unanswered"`
+	// Sent emails with no later message from the user in their thread,
+	// oldest first
+	LastSent []Email `json:"last_sent"`
+}
+
+// ResponseActivity computes reply latencies per contact, unanswered emails
+// and the sent emails the user has not written after, for the emails of an
+// account dated since since. Emails whose sender is one of own count as the
+// user's.
+func (d *Database) ResponseActivity(accountID string, own []string, since, now time.Time) (*ResponseActivity, error) {
+	rows, err := d.db.Query(`SELECT `+emailColumns+` FROM emails WHERE account_id = ? ORDER BY date`, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query emails: %w", err)
+	}
+	var emails []*Email
+	for rows.Next() {
+		e, err := scanEmail(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		emails = append(emails, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query emails: %w", err)
+	}
+
+	activity := &ResponseActivity{AccountID: accountID, Since: since, Until: now}
+	received := make(map[string]*Email) // Message-ID -> received email
+	lastOwn := make(map[string]time.Time)
+	for _, e := range emails {
+		if isOwnSender(e.From, own) {
+			if e.ThreadID != "" && e.Date.After(lastOwn[e.ThreadID]) {
+				lastOwn[e.ThreadID] = e.Date
+			}
+		} else if e.MessageID != "" {
+			received[e.MessageID] = e
+		}
+	}
+
+	var all []time.Duration
+	byContact := make(map[string][]time.Duration)
+	lastReply := make(map[string]time.Time)
+	for _, e := range emails {
+		if e.Date.Before(since) || e.Date.After(now) {
+			continue
+		}
+		// Emails without a thread cannot be followed, so they count as
+		// answered and written after
+		answered := e.ThreadID == "" || lastOwn[e.ThreadID].After(e.Date)
+
+		if !isOwnSender(e.From, own) {
+			if !answered {
+				activity.Unanswered = append(activity.Unanswered, *e)
+			}
+			continue
+		}
+
+		activity.Sent++
+		if !answered {
+			activity.LastSent = append(activity.LastSent, *e)
+		}
+		original, ok := received[e.InReplyTo]
+		if !ok || !e.Date.After(original.Date) {
+			continue
+		}
+		contact := strings.ToLower(senderAddress(original.From))
+		all = append(all, e.Date.Sub(original.Date))
+		byContact[contact] = append(byContact[contact], e.Date.Sub(original.Date))
+		if e.Date.After(lastReply[contact]) {
+			lastReply[contact] = e.Date
+		}
+	}
+
+	activity.Overall = summarizeResponses(all)
+	for contact, durations := range byContact {
+		activity.Contacts = append(activity.Contacts, ContactResponse{
+			Contact:      contact,
+			ResponseTime: *summarizeResponses(durations),
+			LastReply:    lastReply[contact],
+		})
+	}
+	sort.Slice(activity.Contacts, func(i, j int) bool {
+		a, b := activity.Contacts[i], activity.Contacts[j]
+		return a.Replies > b.Replies || a.Replies == b.Replies && a.Contact < b.Contact
+	})
+	return activity, nil
+}
+
+// SaveResponseTimes stores the user's average reply time to each contact in
+// the sender analytics, keeping their VIP status
+func (d *Database) SaveResponseTimes(contacts []ContactResponse) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	for _, c := range contacts {
+		_, err := d.db.Exec(`
+			INSERT INTO sender_analytics (sender, avg_response_hours, replies, updated_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT(sender) DO UPDATE SET
+				avg_response_hours = excluded.avg_response_hours,
+				replies = excluded.replies,
+				updated_at = excluded.updated_at`,
+			strings.ToLower(c.Contact), c.AverageHours, c.Replies, now)
+		if err != nil {
+			return fmt.Errorf("failed to save response time of %s: %w", c.Contact, err)
+		}
+	}
+	return nil
+}
+
+// isOwnSender reports whether sender contains one of the user's addresses
+func isOwnSender(sender string, own []string) bool {
+	sender = strings.ToLower(sender)
+	for _, address := range own {
+		if address != "" && strings.Contains(sender, strings.ToLower(address)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Scheduled email statuses
+const (
+	ScheduledPending   = "pending"
+	ScheduledSent      = "sent"
+	ScheduledFailed    = "failed"
+	ScheduledCancelled = "cancelled"
+)
+
+// ScheduledEmail is an outgoing message queued to be sent at SendAt. Failed
+// attempts are retried at NextAttempt until the dispatcher gives up.
+type ScheduledEmail struct {
+	ID          int64      `json:"id"`
+	AccountID   string     `json:"account_id"`
+	To          []string   `json:"to"`
+	Cc          []string   `json:"cc,omitempty"`
+	Bcc         []string   `json:"bcc,omitempty"`
+	Subject     string     `json:"subject"`
+	Body        string     `json:"body"`
+	SendAt      time.Time  `json:"send_at"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	NextAttempt time.Time  `json:"next_attempt"`
+	LastError   string     `json:"last_error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	SentAt      *time.Time `json:"sent_at,omitempty"`
+	// Message-ID the message was sent with, and what bounces reported about
+	// its delivery (see RecordDeliveryFailures)
+	MessageID      string `json:"message_id,omitempty"`
+	DeliveryStatus string `json:"delivery_status,omitempty"`
+}
+
+func (d *Database) initScheduled() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS scheduled_emails (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		account_id TEXT NOT NULL,
+		recipients TEXT NOT NULL,
+		cc TEXT,
+		bcc TEXT,
+		subject TEXT,
+		body TEXT,
+		send_at DATETIME NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt DATETIME NOT NULL,
+		last_error TEXT,
+		created_at DATETIME NOT NULL,
+		sent_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS idx_scheduled_due ON scheduled_emails(status, next_attempt);`
+
+	if _, err := d.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to initialize scheduled emails: %w", err)
+	}
+	if err := d.addColumn("scheduled_emails", "message_id TEXT"); err != nil {
+		return err
+	}
+	return d.addColumn("scheduled_emails", "delivery_status TEXT")
+}
+
+// CreateScheduled queues a message for sending at its SendAt time
+func (d *Database) CreateScheduled(email *ScheduledEmail) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Times are stored in UTC so due messages can be found by comparing the
+	// stored text values
+	email.SendAt = email.SendAt.UTC()
+	email.Status = ScheduledPending
+	email.NextAttempt = email.SendAt
+	email.CreatedAt = time.Now().UTC()
+
+	err := d.db.QueryRow(`
+		INSERT INTO scheduled_emails (account_id, recipients, cc, bcc, subject, body, send_at, status, attempts, next_attempt, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, 0, ?, ?)
+		RETURNING id`,
+		email.AccountID, strings.Join(email.To, ", "), strings.Join(email.Cc, ", "), strings.Join(email.Bcc, ", "),
+		email.Subject, email.Body, email.SendAt, email.Status, email.NextAttempt, email.CreatedAt).Scan(&email.ID)
+	if err != nil {
+		return fmt.Errorf("failed to schedule email: %w", err)
+	}
+	return nil
+}
+
+const scheduledColumns = `id, account_id, recipients, cc, bcc, subject, body, send_at, status, attempts,
+	next_attempt, last_error, created_at, sent_at, message_id, delivery_status`
+
+// DueScheduled returns pending messages whose next attempt is at or before now
+func (d *Database) DueScheduled(now time.Time) ([]ScheduledEmail, error) {
+	return d.queryScheduled(`SELECT `+scheduledColumns+` FROM scheduled_emails
+		WHERE status = ? AND next_attempt <= ? ORDER BY next_attempt`, ScheduledPending, now.UTC())
+}
+
+// ListScheduled returns queued messages of an account (all accounts if
+// accountID is empty), soonest first. Sent, failed and cancelled messages are
+// only included when includeDone is set.
+func (d *Database) ListScheduled(accountID string, includeDone bool) ([]ScheduledEmail, error) {
+	query := `SELECT ` + scheduledColumns + ` FROM scheduled_emails WHERE 1 = 1`
+	var args []interface{}
+	if accountID != "" {
+		query += ` AND account_id = ?`
+		args = append(args, accountID)
+	}
+	if !includeDone {
+		query += ` AND status = ?`
+		args = append(args, ScheduledPending)
+	}
+	query += ` ORDER BY send_at`
+
+	return d.queryScheduled(query, args...)
+}
+
+// UpdateScheduled stores the outcome of a send attempt
+func (d *Database) UpdateScheduled(email *ScheduledEmail) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	email.NextAttempt = email.NextAttempt.UTC()
+	_, err := d.db.Exec(`
+		UPDATE scheduled_emails SET status = ?, attempts = ?, next_attempt = ?, last_error = ?, sent_at = ?,
+			message_id = ?, delivery_status = ?
+		WHERE id = ?`,
+		email.Status, email.Attempts, email.NextAttempt, email.LastError, email.SentAt,
+		email.MessageID, email.DeliveryStatus, email.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update scheduled email: %w", err)
+	}
+	return nil
+}
+
+// CancelScheduled cancels a message that has not been sent yet
+func (d *Database) CancelScheduled(id int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	res, err := d.db.Exec(`UPDATE scheduled_emails SET status = ? WHERE id = ? AND status = ?`,
+		ScheduledCancelled, id, ScheduledPending)
+	if err != nil {
+		return fmt.Errorf("failed to cancel scheduled email: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("no pending scheduled email with ID %d", id)
+	}
+	return nil
+}
+
+func (d *Database) queryScheduled(query string, args ...interface{}) ([]ScheduledEmail, error) {
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scheduled emails: %w", err)
+	}
+	defer rows.Close()
+
+	var emails []ScheduledEmail
+	for rows.Next() {
+		var e ScheduledEmail
+		var to, cc, bcc, subject, body, lastError, messageID, deliveryStatus sql.NullString
+		var sentAt sql.NullTime
+		if err := rows.Scan(&e.ID, &e.AccountID, &to, &cc, &bcc, &subject, &body, &e.SendAt, &e.Status,
+			&e.Attempts, &e.NextAttempt, &lastError, &e.CreatedAt, &sentAt, &messageID, &deliveryStatus); err != nil {
+			return nil, fmt.Errorf("failed to scan scheduled email: %w", err)
+		}
+
+		e.To = splitAddresses(to.String)
+		e.Cc = splitAddresses(cc.String)
+		e.Bcc = splitAddresses(bcc.String)
+		e.Subject = subject.String
+		e.Body = body.String
+		e.LastError = lastError.String
+		e.MessageID = messageID.String
+		e.DeliveryStatus = deliveryStatus.String
+		if sentAt.Valid {
+			e.SentAt = &sentAt.Time
+		}
+		emails = append(emails, e)
+	}
+	return emails, rows.Err()
+}
@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SearchFilter narrows a full-text search. Zero values are ignored.
+type SearchFilter struct {
+	AccountID string
+	Folder    string
+	From      string
+	Since     time.Time
+	Until     time.Time
+	Starred   bool // Only starred emails
+	Limit     int
+	Offset    int // Results to skip, for pagination
+}
+
+// SearchResult is an email matched by SearchEmails. Lower Rank is better.
+type SearchResult struct {
+	Email
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+// initSearchIndex creates the emails_fts index over subject, body snippet and
+// sender, kept in sync with the emails table by triggers. Databases created
+// before the index existed are indexed once on first open.
+func (d *Database) initSearchIndex() error {
+	var exists int
+	if err := d.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'emails_fts'`).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check search index: %w", err)
+	}
+
+	schema := `
+	CREATE VIRTUAL TABLE IF NOT EXISTS emails_fts USING fts5(
+		subject, body_snippet, sender,
+		content = 'emails', content_rowid = 'id',
+		tokenize = 'unicode61 remove_diacritics 2'
+	);
+
+	CREATE TRIGGER IF NOT EXISTS emails_fts_insert AFTER INSERT ON emails BEGIN
+		INSERT INTO emails_fts(rowid, subject, body_snippet, sender)
+		VALUES (new.id, new.subject, new.body_snippet, new.sender);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS emails_fts_delete AFTER DELETE ON emails BEGIN
+		INSERT INTO emails_fts(emails_fts, rowid, subject, body_snippet, sender)
+		VALUES ('delete', old.id, old.subject, old.body_snippet, old.sender);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS emails_fts_update AFTER UPDATE OF subject, body_snippet, sender ON emails BEGIN
+		INSERT INTO emails_fts(emails_fts, rowid, subject, body_snippet, sender)
+		VALUES ('delete', old.id, old.subject, old.body_snippet, old.sender);
+		INSERT INTO emails_fts(rowid, subject, body_snippet, sender)
+		VALUES (new.id, new.subject, new.body_snippet, new.sender);
+	END;`
+
+	if _, err := d.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to initialize search index: %w", err)
+	}
+
+	if exists == 0 {
+		if _, err := d.db.Exec(`INSERT INTO emails_fts(emails_fts) VALUES ('rebuild')`); err != nil {
+			return fmt.Errorf("failed to build search index: %w", err)
+		}
+	}
+	return nil
+}
+
+// SearchEmails runs a full-text query over synced emails, best matches first.
+// The query supports "quoted phrases", prefix* terms and the AND/OR/NOT
+// operators; any other punctuation is treated literally.
+func (d *Database) SearchEmails(query string, filter SearchFilter) ([]SearchResult, error) {
+	match := buildMatchQuery(query)
+	if match == "" {
+		return nil, fmt.Errorf("search query is empty")
+	}
+
+	// Subject hits weigh most, then sender, then body
+	stmt := `SELECT ` + emailColumns + `, bm25(emails_fts, 10.0, 1.0, 5.0) AS rank,
+		snippet(emails_fts, 1, '[', ']', '…', 12)
+		FROM emails_fts JOIN emails ON emails.id = emails_fts.rowid
+		WHERE emails_fts MATCH ?`
+	args := []interface{}{match}
+
+	if filter.AccountID != "" {
+		stmt += ` AND emails.account_id = ?`
+		args = append(args, filter.AccountID)
+	}
+	if filter.Folder != "" {
+		stmt += ` AND emails.folder = ?`
+		args = append(args, filter.Folder)
+	}
+	if filter.From != "" {
+		stmt += ` AND emails.sender LIKE ?`
+		args = append(args, "%"+filter.From+"%")
+	}
+	if !filter.Since.IsZero() {
+		stmt += ` AND emails.date >= ?`
+		args = append(args, filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		stmt += ` AND emails.date < ?`
+		args = append(args, filter.Until)
+	}
+	if filter.Starred {
+		stmt += ` AND ` + starredCondition
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+	stmt += ` ORDER BY rank, emails.id LIMIT ? OFFSET ?`
+	args = append(args, limit, max(filter.Offset, 0))
+
+	rows, err := d.db.Query(stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search emails: %w", err)
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var result SearchResult
+		e, err := scanEmail(rows, &result.Rank, &result.Snippet)
+		if err != nil {
+			return nil, err
+		}
+		result.Email = *e
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// buildMatchQuery turns user input into a safe FTS5 MATCH expression. Quoted
+// phrases are kept, trailing * marks a prefix term, AND/OR/NOT pass through as
+// operators and every other token is quoted so stray punctuation cannot cause
+// a syntax error.
+func buildMatchQuery(query string) string {
+	var terms []string
+	for len(query) > 0 {
+		query = strings.TrimLeft(query, " \t\r\n")
+		if query == "" {
+			break
+		}
+
+		if query[0] == '"' {
+			end := strings.IndexByte(query[1:], '"')
+			if end < 0 {
+				end = len(query) - 1
+			}
+			if phrase := strings.TrimSpace(query[1 : end+1]); phrase != "" {
+				terms = append(terms, quoteTerm(phrase))
+			}
+			query = query[min(end+2, len(query)):]
+			continue
+		}
+
+		end := strings.IndexAny(query, " \t\r\n\"")
+		if end < 0 {
+			end = len(query)
+		}
+		token := query[:end]
+		query = query[end:]
+
+		switch {
+		case isOperator(token):
+			if len(terms) > 0 && !isOperator(terms[len(terms)-1]) {
+				terms = append(terms, token)
+			}
+		case strings.HasSuffix(token, "*") && len(strings.TrimRight(token, "*")) > 0:
+			terms = append(terms, quoteTerm(strings.TrimRight(token, "*"))+"*")
+		default:
+			if token = strings.Trim(token, "*"); token != "" {
+				terms = append(terms, quoteTerm(token))
+			}
+		}
+	}
+
+	// A trailing operator has no right-hand side
+	for len(terms) > 0 && isOperator(terms[len(terms)-1]) {
+		terms = terms[:len(terms)-1]
+	}
+	return strings.Join(terms, " ")
+}
+
+func isOperator(token string) bool {
+	return token == "AND" || token == "OR" || token == "NOT"
+}
+
+func quoteTerm(term string) string {
+	return `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SenderStats describes a sender: whether the user marked them as a VIP, how
+// much mail from them is in the local database and how quickly the user
+// replies to them
+type SenderStats struct {
+	Sender     string     `json:"sender"`
+	IsVIP      bool       `json:"is_vip"`
+	Note       string     `json:"note,omitempty"`
+	VIPSince   *time.Time `json:"vip_since,omitempty"`
+	EmailCount int        `json:"email_count"`
+	LastSeen   *time.Time `json:"last_seen,omitempty"`
+	// From the synced Sent folder, see SaveResponseTimes
+	AvgResponseHours *float64 `json:"avg_response_time,omitempty"`
+	Replies          int      `json:"replies,omitempty"`
+}
+
+func (d *Database) initSenders() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS sender_analytics (
+		sender TEXT PRIMARY KEY,
+		is_vip INTEGER NOT NULL DEFAULT 0,
+		note TEXT,
+		vip_since DATETIME,
+		updated_at DATETIME NOT NULL
+	);`
+
+	if _, err := d.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to initialize sender analytics: %w", err)
+	}
+	if err := d.addColumn("sender_analytics", "avg_response_hours REAL"); err != nil {
+		return err
+	}
+	return d.addColumn("sender_analytics", "replies INTEGER NOT NULL DEFAULT 0")
+}
+
+// SetVIP marks or unmarks sender, an email address, as a VIP
+func (d *Database) SetVIP(sender string, vip bool, note string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	var vipSince interface{}
+	if vip {
+		vipSince = now
+	}
+
+	_, err := d.db.Exec(`
+		INSERT INTO sender_analytics (sender, is_vip, note, vip_since, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(sender) DO UPDATE SET
+			is_vip = excluded.is_vip,
+			note = CASE WHEN excluded.note <> '' THEN excluded.note ELSE sender_analytics.note END,
+			vip_since = CASE WHEN sender_analytics.is_vip AND excluded.is_vip THEN sender_analytics.vip_since ELSE excluded.vip_since END,
+			updated_at = excluded.updated_at`,
+		strings.ToLower(sender), vip, note, vipSince, now)
+	if err != nil {
+		return fmt.Errorf("failed to update sender: %w", err)
+	}
+	return nil
+}
+
+// IsVIP reports whether sender is marked as a VIP
+func (d *Database) IsVIP(sender string) (bool, error) {
+	var vip bool
+	err := d.db.QueryRow(`SELECT is_vip FROM sender_analytics WHERE sender = ?`, strings.ToLower(sender)).Scan(&vip)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read sender: %w", err)
+	}
+	return vip, nil
+}
+
+// ListVIPs returns the VIP senders with the number of synced emails from each
+func (d *Database) ListVIPs() ([]SenderStats, error) {
+	rows, err := d.db.Query(`SELECT sender, note, vip_since, avg_response_hours, replies FROM sender_analytics WHERE is_vip ORDER BY sender`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VIPs: %w", err)
+	}
+
+	var senders []SenderStats
+	for rows.Next() {
+		s := SenderStats{IsVIP: true}
+		var note sql.NullString
+		var vipSince sql.NullTime
+		var avgResponse sql.NullFloat64
+		if err := rows.Scan(&s.Sender, &note, &vipSince, &avgResponse, &s.Replies); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan sender: %w", err)
+		}
+		s.Note = note.String
+		if vipSince.Valid {
+			s.VIPSince = &vipSince.Time
+		}
+		if avgResponse.Valid {
+			s.AvgResponseHours = &avgResponse.Float64
+		}
+		senders = append(senders, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list VIPs: %w", err)
+	}
+
+	for i := range senders {
+		if err := d.senderActivity(&senders[i]); err != nil {
+			return nil, err
+		}
+	}
+	return senders, nil
+}
+
+// senderActivity fills in how many synced emails came from s and when the
+// latest arrived
+func (d *Database) senderActivity(s *SenderStats) error {
+	pattern := "%" + s.Sender + "%"
+	if err := d.db.QueryRow(`SELECT COUNT(*) FROM emails WHERE lower(sender) LIKE ?`, pattern).Scan(&s.EmailCount); err != nil {
+		return fmt.Errorf("failed to count emails from %s: %w", s.Sender, err)
+	}
+	if s.EmailCount == 0 {
+		return nil
+	}
+
+	var lastSeen time.Time
+	err := d.db.QueryRow(`SELECT date FROM emails WHERE lower(sender) LIKE ? ORDER BY date DESC LIMIT 1`, pattern).Scan(&lastSeen)
+	if err != nil {
+		return fmt.Errorf("failed to read emails from %s: %w", s.Sender, err)
+	}
+	s.LastSeen = &lastSeen
+	return nil
+}
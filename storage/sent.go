@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"email-mcp-server/mail"
+)
+
+// Delivery statuses of sent messages, updated by the bounces that report on
+// them
+const (
+	DeliverySent    = "sent"    // No bounce reported a problem
+	DeliveryDelayed = "delayed" // A bounce reported a delay, delivery is still tried
+	DeliveryFailed  = "failed"  // A bounce reported a permanent failure
+)
+
+// SentMessage is a message sent through the server, identified by the
+// Message-ID the server generated for it
+type SentMessage struct {
+	ID               int64      `json:"id"`
+	AccountID        string     `json:"account_id"`
+	MessageID        string     `json:"message_id"`
+	To               []string   `json:"to,omitempty"`
+	Subject          string     `json:"subject,omitempty"`
+	SentAt           time.Time  `json:"sent_at"`
+	DeliveryStatus   string     `json:"delivery_status"`
+	FailedRecipients []string   `json:"failed_recipients,omitempty"` // Recipients a bounce reported on
+	Diagnostic       string     `json:"diagnostic,omitempty"`        // The latest bounce's diagnostic
+	UpdatedAt        *time.Time `json:"updated_at,omitempty"`        // When a bounce last changed the status
+}
+
+func (d *Database) initSent() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS sent_messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		account_id TEXT NOT NULL,
+		message_id TEXT NOT NULL,
+		recipients TEXT,
+		subject TEXT,
+		sent_at DATETIME NOT NULL,
+		delivery_status TEXT NOT NULL,
+		failed_recipients TEXT,
+		diagnostic TEXT,
+		updated_at DATETIME,
+		UNIQUE(account_id, message_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_sent_status ON sent_messages(account_id, delivery_status, sent_at);`
+
+	if _, err := d.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to initialize sent messages: %w", err)
+	}
+	return nil
+}
+
+// RecordSent stores a message that was just sent, with DeliverySent as its
+// status. Recording the same Message-ID again keeps the first record.
+func (d *Database) RecordSent(m *SentMessage) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	m.SentAt = m.SentAt.UTC()
+	m.DeliveryStatus = DeliverySent
+	_, err := d.db.Exec(`
+		INSERT INTO sent_messages (account_id, message_id, recipients, subject, sent_at, delivery_status)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(account_id, message_id) DO NOTHING`,
+		m.AccountID, m.MessageID, strings.Join(m.To, ", "), m.Subject, m.SentAt, m.DeliveryStatus)
+	if err != nil {
+		return fmt.Errorf("failed to record sent message: %w", err)
+	}
+	return nil
+}
+
+// RecordDeliveryFailures applies bounces to the sent messages they report
+// on, matched by their OriginalMessageID, and to the scheduled emails sent as
+// those messages. A permanent failure marks the message failed and a delay
+// marks it delayed; a failed message stays failed. It returns the messages
+// that were updated; bounces about messages the server did not send are
+// ignored.
+func (d *Database) RecordDeliveryFailures(accountID string, bounces []mail.Bounce) ([]SentMessage, error) {
+	byMessage := make(map[string][]mail.Bounce)
+	var order []string
+	for _, b := range bounces {
+		id := strings.Trim(b.OriginalMessageID, "<> ")
+		if id == "" {
+			continue
+		}
+		if _, ok := byMessage[id]; !ok {
+			order = append(order, id)
+		}
+		byMessage[id] = append(byMessage[id], b)
+	}
+	if len(order) == 0 {
+		return nil, nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to record delivery failures: %w", err)
+	}
+	defer tx.Rollback()
+	db := prepared{d: d, tx: tx}
+
+	now := time.Now().UTC()
+	var updated []SentMessage
+	for _, id := range order {
+		// Message-IDs are stored as generated, in angle brackets
+		rows, err := db.Query(`SELECT `+sentColumns+` FROM sent_messages
+			WHERE account_id = ? AND message_id IN (?, ?)`, accountID, "<"+id+">", id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query sent messages: %w", err)
+		}
+		messages, err := scanSent(rows)
+		if err != nil {
+			return nil, err
+		}
+		if len(messages) == 0 {
+			continue
+		}
+
+		m := messages[0]
+		for _, b := range byMessage[id] {
+			if !slices.Contains(m.FailedRecipients, b.Recipient) {
+				m.FailedRecipients = append(m.FailedRecipients, b.Recipient)
+			}
+			if b.Permanent() {
+				m.DeliveryStatus = DeliveryFailed
+			} else if m.DeliveryStatus != DeliveryFailed {
+				m.DeliveryStatus = DeliveryDelayed
+			}
+			if b.Diagnostic != "" {
+				m.Diagnostic = b.Diagnostic
+			}
+		}
+		m.UpdatedAt = &now
+
+		_, err = db.Exec(`
+			UPDATE sent_messages SET delivery_status = ?, failed_recipients = ?, diagnostic = ?, updated_at = ?
+			WHERE id = ?`,
+			m.DeliveryStatus, strings.Join(m.FailedRecipients, ", "), m.Diagnostic, now, m.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update sent message: %w", err)
+		}
+		_, err = db.Exec(`UPDATE scheduled_emails SET delivery_status = ? WHERE account_id = ? AND message_id = ?`,
+			m.DeliveryStatus, accountID, m.MessageID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update scheduled email: %w", err)
+		}
+		updated = append(updated, m)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to record delivery failures: %w", err)
+	}
+	return updated, nil
+}
+
+const sentColumns = `id, account_id, message_id, recipients, subject, sent_at, delivery_status,
+	failed_recipients, diagnostic, updated_at`
+
+// ListSent returns the messages an account sent since a time, newest first.
+// An empty status lists every status.
+func (d *Database) ListSent(accountID, status string, since time.Time, limit int) ([]SentMessage, error) {
+	query := `SELECT ` + sentColumns + ` FROM sent_messages WHERE account_id = ? AND sent_at >= ?`
+	args := []interface{}{accountID, since.UTC()}
+	if status != "" {
+		query += ` AND delivery_status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY sent_at DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sent messages: %w", err)
+	}
+	return scanSent(rows)
+}
+
+// GetSent returns the sent message with a Message-ID, nil when the server did
+// not send it
+func (d *Database) GetSent(accountID, messageID string) (*SentMessage, error) {
+	id := strings.Trim(messageID, "<> ")
+	rows, err := d.db.Query(`SELECT `+sentColumns+` FROM sent_messages
+		WHERE account_id = ? AND message_id IN (?, ?)`, accountID, "<"+id+">", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sent messages: %w", err)
+	}
+	messages, err := scanSent(rows)
+	if err != nil || len(messages) == 0 {
+		return nil, err
+	}
+	return &messages[0], nil
+}
+
+func scanSent(rows *sql.Rows) ([]SentMessage, error) {
+	defer rows.Close()
+
+	var messages []SentMessage
+	for rows.Next() {
+		var m SentMessage
+		var to, subject, failed, diagnostic sql.NullString
+		var updatedAt sql.NullTime
+		if err := rows.Scan(&m.ID, &m.AccountID, &m.MessageID, &to, &subject, &m.SentAt, &m.DeliveryStatus,
+			&failed, &diagnostic, &updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan sent message: %w", err)
+		}
+		m.To = splitAddresses(to.String)
+		m.Subject = subject.String
+		m.FailedRecipients = splitAddresses(failed.String)
+		m.Diagnostic = diagnostic.String
+		if updatedAt.Valid {
+			m.UpdatedAt = &updatedAt.Time
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
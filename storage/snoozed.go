@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Snoozed email statuses
+const (
+	SnoozePending = "pending"
+	SnoozeWoken   = "woken"
+	SnoozeFailed  = "failed"
+)
+
+// SnoozedEmail is a message moved out of Folder until Until. It is found
+// again in SnoozeFolder by its Message-ID, since the move changes its UID.
+type SnoozedEmail struct {
+	ID           int64      `json:"id"`
+	AccountID    string     `json:"account_id"`
+	Folder       string     `json:"folder"`
+	SnoozeFolder string     `json:"snooze_folder"`
+	MessageID    string     `json:"message_id"`
+	From         string     `json:"from"`
+	Subject      string     `json:"subject"`
+	Until        time.Time  `json:"until"`
+	Status       string     `json:"status"`
+	Attempts     int        `json:"attempts"`
+	NextAttempt  time.Time  `json:"next_attempt"`
+	LastError    string     `json:"last_error,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	WokenAt      *time.Time `json:"woken_at,omitempty"`
+}
+
+func (d *Database) initSnoozed() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS snoozed_emails (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		account_id TEXT NOT NULL,
+		folder TEXT NOT NULL,
+		snooze_folder TEXT NOT NULL,
+		message_id TEXT NOT NULL,
+		sender TEXT,
+		subject TEXT,
+		until DATETIME NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt DATETIME NOT NULL,
+		last_error TEXT,
+		created_at DATETIME NOT NULL,
+		woken_at DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS idx_snoozed_due ON snoozed_emails(status, next_attempt);`
+
+	if _, err := d.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to initialize snoozed emails: %w", err)
+	}
+	return nil
+}
+
+// CreateSnoozed records a message snoozed until its Until time
+func (d *Database) CreateSnoozed(email *SnoozedEmail) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Stored in UTC like scheduled emails, so due rows compare as text
+	email.Until = email.Until.UTC()
+	email.Status = SnoozePending
+	email.NextAttempt = email.Until
+	email.CreatedAt = time.Now().UTC()
+
+	err := d.db.QueryRow(`
+		INSERT INTO snoozed_emails (account_id, folder, snooze_folder, message_id, sender, subject, until, status, attempts, next_attempt, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, 0, ?, ?)
+		RETURNING id`,
+		email.AccountID, email.Folder, email.SnoozeFolder, email.MessageID, email.From, email.Subject,
+		email.Until, email.Status, email.NextAttempt, email.CreatedAt).Scan(&email.ID)
+	if err != nil {
+		return fmt.Errorf("failed to snooze email: %w", err)
+	}
+	return nil
+}
+
+const snoozedColumns = `id, account_id, folder, snooze_folder, message_id, sender, subject, until, status, attempts,
+	next_attempt, last_error, created_at, woken_at`
+
+// DueSnoozed returns pending snoozes whose next attempt is at or before now
+func (d *Database) DueSnoozed(now time.Time) ([]SnoozedEmail, error) {
+	return d.querySnoozed(`SELECT `+snoozedColumns+` FROM snoozed_emails
+		WHERE status = ? AND next_attempt <= ? ORDER BY next_attempt`, SnoozePending, now.UTC())
+}
+
+// ListSnoozed returns the snoozed emails of an account (all accounts if
+// accountID is empty), soonest first. Woken and failed snoozes are
+// only included when includeDone is set.
+func (d *Database) ListSnoozed(accountID string, includeDone bool) ([]SnoozedEmail, error) {
+	query := `SELECT ` + snoozedColumns + ` FROM snoozed_emails WHERE 1 = 1`
+	var args []interface{}
+	if accountID != "" {
+		query += ` AND account_id = ?`
+		args = append(args, accountID)
+	}
+	if !includeDone {
+		query += ` AND status = ?`
+		args = append(args, SnoozePending)
+	}
+	query += ` ORDER BY until`
+
+	return d.querySnoozed(query, args...)
+}
+
+// UpdateSnoozed stores the outcome of a wake attempt
+func (d *Database) UpdateSnoozed(email *SnoozedEmail) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	email.NextAttempt = email.NextAttempt.UTC()
+	_, err := d.db.Exec(`
+		UPDATE snoozed_emails SET status = ?, attempts = ?, next_attempt = ?, last_error = ?, woken_at = ?
+		WHERE id = ?`,
+		email.Status, email.Attempts, email.NextAttempt, email.LastError, email.WokenAt, email.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update snoozed email: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) querySnoozed(query string, args ...interface{}) ([]SnoozedEmail, error) {
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query snoozed emails: %w", err)
+	}
+	defer rows.Close()
+
+	var emails []SnoozedEmail
+	for rows.Next() {
+		var e SnoozedEmail
+		var sender, subject, lastError sql.NullString
+		var wokenAt sql.NullTime
+		if err := rows.Scan(&e.ID, &e.AccountID, &e.Folder, &e.SnoozeFolder, &e.MessageID, &sender, &subject, &e.Until,
+			&e.Status, &e.Attempts, &e.NextAttempt, &lastError, &e.CreatedAt, &wokenAt); err != nil {
+			return nil, fmt.Errorf("failed to scan snoozed email: %w", err)
+		}
+
+		e.From = sender.String
+		e.Subject = subject.String
+		e.LastError = lastError.String
+		if wokenAt.Valid {
+			e.WokenAt = &wokenAt.Time
+		}
+		emails = append(emails, e)
+	}
+	return emails, rows.Err()
+}
@@ -0,0 +1,337 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"email-mcp-server/mail"
+)
+
+// VolumeCount is the number of emails of a day or of a week starting on Date
+type VolumeCount struct {
+	Date     string `json:"date"` // YYYY-MM-DD, local time
+	Received int    `json:"received"`
+	Sent     int    `json:"sent"`
+	Unread   int    `json:"unread"`
+	// Emails of this period and earlier ones in the window still unread
+	UnreadBacklog int `json:"unread_backlog"`
+}
+
+// SenderCount is the number of emails received from a sender
+type SenderCount struct {
+	Sender string `json:"sender"`
+	Count  int    `json:"count"`
+}
+
+// WeekSenders lists the top senders of the week starting on Week
+type WeekSenders struct {
+	Week    string        `json:"week"`
+	Senders []SenderCount `json:"senders"`
+}
+
+// CategoryCount is the number of classified emails in a category
+type CategoryCount struct {
+	Category string `json:"category"`
+	Count    int    `json:"count"`
+}
+
+// FolderUsage is the storage used by the synced emails of a folder
+type FolderUsage struct {
+	Folder string `json:"folder"`
+	Emails int    `json:"emails"`
+	Unread int    `json:"unread"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// ResponseTime summarizes the delay between a message and its reply
+type ResponseTime struct {
+	Replies      int     `json:"replies"`
+	AverageHours float64 `json:"average_hours"`
+	MedianHours  float64 `json:"median_hours"`
+	FastestHours float64 `json:"fastest_hours"`
+	SlowestHours float64 `json:"slowest_hours"`
+}
+
+// MailboxStats describes the synced mail of an account between Since and
+// Until. Folders covers every synced email, whatever its date.
+type MailboxStats struct {
+	AccountID  string          `json:"account_id"`
+	Since      time.Time       `json:"since"`
+	Until      time.Time       `json:"until"`
+	Received   int             `json:"received"`
+	Sent       int             `json:"sent"`
+	Unread     int             `json:"unread"`
+	Daily      []VolumeCount   `json:"daily"`
+	Weekly     []VolumeCount   `json:"weekly"`
+	Categories []CategoryCount `json:"categories"`
+	// Emails received in the window that were never classified
+	Unclassified  int           `json:"unclassified"`
+	TopSenders    []SenderCount `json:"top_senders"`
+	SendersByWeek []WeekSenders `json:"top_senders_by_week"`
+	// How quickly the account owner replied, from their own replies in the
+	// database
+	YourResponse *ResponseTime `json:"your_response_time,omitempty"`
+	// How quickly others replied to tracked follow-ups
+	TheirResponse *ResponseTime `json:"their_response_time,omitempty"`
+	Folders       []FolderUsage `json:"folders"`
+	TotalEmails   int           `json:"total_emails"`
+	TotalBytes    int64         `json:"total_bytes"`
+}
+
+// MailboxStats computes volume, category, sender, response time and unread
+// trends for the emails of an account dated in the last days days. Emails
+// whose sender is one of own count as sent, the others as received.
+func (d *Database) MailboxStats(accountID string, own []string, days int, now time.Time) (*MailboxStats, error) {
+	if days <= 0 {
+		days = 30
+	}
+	firstDay := startOfDay(now).AddDate(0, 0, -(days - 1))
+	stats := &MailboxStats{AccountID: accountID, Since: firstDay, Until: now}
+
+	rows, err := d.db.Query(`
+		SELECT emails.folder, emails.sender, emails.date, emails.size, emails.flags, emails.message_id,
+			emails.in_reply_to, classifications.category
+		FROM emails
+		LEFT JOIN classifications ON classifications.account_id = emails.account_id
+			AND classifications.folder = emails.folder AND classifications.uid = emails.uid
+		WHERE emails.account_id = ?`, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query emails: %w", err)
+	}
+	defer rows.Close()
+
+	type reply struct {
+		inReplyTo string
+		date      time.Time
+	}
+	var (
+		folders    = make(map[string]*FolderUsage)
+		daily      = make(map[string]*VolumeCount)
+		weekly     = make(map[string]*VolumeCount)
+		categories = make(map[string]int)
+		senders    = make(map[string]int)
+		byWeek     = make(map[string]map[string]int)
+		received   = make(map[string]time.Time) // Message-ID -> date of received emails
+		replies    []reply
+	)
+	for rows.Next() {
+		var folder string
+		var date time.Time
+		var size int64
+		var sender, flags, messageID, inReplyTo, category sql.NullString
+		if err := rows.Scan(&folder, &sender, &date, &size, &flags, &messageID, &inReplyTo, &category); err != nil {
+			return nil, fmt.Errorf("failed to scan email: %w", err)
+		}
+		unread := !strings.Contains(flags.String, `\Seen`)
+		sent := isOwnSender(sender.String, own)
+
+		usage := folders[folder]
+		if usage == nil {
+			usage = &FolderUsage{Folder: folder}
+			folders[folder] = usage
+		}
+		usage.Emails++
+		usage.Bytes += size
+		if unread {
+			usage.Unread++
+		}
+		stats.TotalEmails++
+		stats.TotalBytes += size
+
+		if sent {
+			if inReplyTo.String != "" {
+				replies = append(replies, reply{inReplyTo: inReplyTo.String, date: date})
+			}
+		} else if messageID.String != "" {
+			received[messageID.String] = date
+		}
+
+		if date.Before(firstDay) || date.After(now) {
+			continue
+		}
+		day := date.In(now.Location()).Format("2006-01-02")
+		week := startOfWeek(date.In(now.Location())).Format("2006-01-02")
+		for _, bucket := range []*VolumeCount{volumeBucket(daily, day), volumeBucket(weekly, week)} {
+			if sent {
+				bucket.Sent++
+			} else {
+				bucket.Received++
+			}
+			if unread && !sent {
+				bucket.Unread++
+			}
+		}
+		if sent {
+			stats.Sent++
+			continue
+		}
+
+		stats.Received++
+		if unread {
+			stats.Unread++
+		}
+		if category.String != "" {
+			categories[category.String]++
+		} else {
+			stats.Unclassified++
+		}
+		address := strings.ToLower(senderAddress(sender.String))
+		senders[address]++
+		if byWeek[week] == nil {
+			byWeek[week] = make(map[string]int)
+		}
+		byWeek[week][address]++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query emails: %w", err)
+	}
+
+	// Every day and week of the window is listed, with zeros, so the series
+	// can be charted as-is
+	backlog := 0
+	for day := firstDay; !day.After(now); day = day.AddDate(0, 0, 1) {
+		count := volumeBucket(daily, day.Format("2006-01-02"))
+		backlog += count.Unread
+		count.UnreadBacklog = backlog
+		stats.Daily = append(stats.Daily, *count)
+	}
+	backlog = 0
+	for week := startOfWeek(firstDay); !week.After(now); week = week.AddDate(0, 0, 7) {
+		count := volumeBucket(weekly, week.Format("2006-01-02"))
+		backlog += count.Unread
+		count.UnreadBacklog = backlog
+		stats.Weekly = append(stats.Weekly, *count)
+
+		if top := topSenders(byWeek[count.Date], 3); len(top) > 0 {
+			stats.SendersByWeek = append(stats.SendersByWeek, WeekSenders{Week: count.Date, Senders: top})
+		}
+	}
+
+	for category, count := range categories {
+		stats.Categories = append(stats.Categories, CategoryCount{Category: category, Count: count})
+	}
+	sort.Slice(stats.Categories, func(i, j int) bool {
+		a, b := stats.Categories[i], stats.Categories[j]
+		return a.Count > b.Count || a.Count == b.Count && a.Category < b.Category
+	})
+	stats.TopSenders = topSenders(senders, 10)
+
+	for _, usage := range folders {
+		stats.Folders = append(stats.Folders, *usage)
+	}
+	sort.Slice(stats.Folders, func(i, j int) bool { return stats.Folders[i].Bytes > stats.Folders[j].Bytes })
+
+	var yours []time.Duration
+	for _, r := range replies {
+		if r.date.Before(firstDay) || r.date.After(now) {
+			continue
+		}
+		if original, ok := received[r.inReplyTo]; ok && r.date.After(original) {
+			yours = append(yours, r.date.Sub(original))
+		}
+	}
+	stats.YourResponse = summarizeResponses(yours)
+
+	theirs, err := d.followupResponses(accountID, firstDay, now)
+	if err != nil {
+		return nil, err
+	}
+	stats.TheirResponse = summarizeResponses(theirs)
+
+	return stats, nil
+}
+
+// followupResponses returns how long resolved follow-ups resolved in the
+// window waited for their reply
+func (d *Database) followupResponses(accountID string, since, until time.Time) ([]time.Duration, error) {
+	rows, err := d.db.Query(`SELECT created_at, resolved_at FROM followups
+		WHERE account_id = ? AND status = ? AND resolved_at IS NOT NULL`, accountID, FollowupResolved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query followups: %w", err)
+	}
+	defer rows.Close()
+
+	var durations []time.Duration
+	for rows.Next() {
+		var created, resolved time.Time
+		if err := rows.Scan(&created, &resolved); err != nil {
+			return nil, fmt.Errorf("failed to scan followup: %w", err)
+		}
+		// Follow-ups tracked after their reply arrived say nothing about
+		// the delay
+		if resolved.Before(since) || resolved.After(until) || !resolved.After(created) {
+			continue
+		}
+		durations = append(durations, resolved.Sub(created))
+	}
+	return durations, rows.Err()
+}
+
+func summarizeResponses(durations []time.Duration) *ResponseTime {
+	if len(durations) == 0 {
+		return nil
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+	var total time.Duration
+	for _, d := range durations {
+		total += d
+	}
+	median := durations[len(durations)/2]
+	if len(durations)%2 == 0 {
+		median = (durations[len(durations)/2-1] + median) / 2
+	}
+	hours := func(d time.Duration) float64 { return float64(int(d.Hours()*10+0.5)) / 10 }
+	return &ResponseTime{
+		Replies:      len(durations),
+		AverageHours: hours(total / time.Duration(len(durations))),
+		MedianHours:  hours(median),
+		FastestHours: hours(durations[0]),
+		SlowestHours: hours(durations[len(durations)-1]),
+	}
+}
+
+func volumeBucket(buckets map[string]*VolumeCount, date string) *VolumeCount {
+	count := buckets[date]
+	if count == nil {
+		count = &VolumeCount{Date: date}
+		buckets[date] = count
+	}
+	return count
+}
+
+func topSenders(counts map[string]int, limit int) []SenderCount {
+	var senders []SenderCount
+	for sender, count := range counts {
+		senders = append(senders, SenderCount{Sender: sender, Count: count})
+	}
+	sort.Slice(senders, func(i, j int) bool {
+		a, b := senders[i], senders[j]
+		return a.Count > b.Count || a.Count == b.Count && a.Sender < b.Sender
+	})
+	if len(senders) > limit {
+		senders = senders[:limit]
+	}
+	return senders
+}
+
+// senderAddress extracts the address from a "Name <address>" sender
+func senderAddress(sender string) string {
+	if addr, err := mail.ParseAddress(sender); err == nil {
+		return addr.Address
+	}
+	return strings.TrimSpace(sender)
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// startOfWeek returns the Monday of t's week
+func startOfWeek(t time.Time) time.Time {
+	day := startOfDay(t)
+	return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Template is a named subject and body for recurring emails. Both may hold
+// {{placeholders}}, replaced by Render with the values given when the
+// template is used.
+type Template struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Subject     string    `json:"subject"`
+	Body        string    `json:"body"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// placeholderPattern matches {{name}}, allowing spaces inside the braces
+var placeholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+
+// Placeholders returns the names used in the subject and body, in order of
+// first appearance
+func (t *Template) Placeholders() []string {
+	var names []string
+	for _, match := range placeholderPattern.FindAllStringSubmatch(t.Subject+"\n"+t.Body, -1) {
+		if !slices.Contains(names, match[1]) {
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
+
+// Render replaces the placeholders of the subject and body with vars.
+// Placeholders without a value are left as they are and returned in missing.
+func (t *Template) Render(vars map[string]string) (subject, body string, missing []string) {
+	expand := func(s string) string {
+		return placeholderPattern.ReplaceAllStringFunc(s, func(placeholder string) string {
+			name := placeholderPattern.FindStringSubmatch(placeholder)[1]
+			if value, ok := vars[name]; ok {
+				return value
+			}
+			if !slices.Contains(missing, name) {
+				missing = append(missing, name)
+			}
+			return placeholder
+		})
+	}
+	return expand(t.Subject), expand(t.Body), missing
+}
+
+func (d *Database) initTemplates() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS templates (
+		name TEXT PRIMARY KEY,
+		description TEXT,
+		subject TEXT NOT NULL,
+		body TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);`
+
+	if _, err := d.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to initialize templates: %w", err)
+	}
+	return nil
+}
+
+// SaveTemplate creates the template or replaces the one with the same name,
+// keeping its creation time
+func (d *Database) SaveTemplate(t *Template) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	t.Name = strings.TrimSpace(t.Name)
+	now := time.Now()
+	t.CreatedAt, t.UpdatedAt = now, now
+
+	err := d.db.QueryRow(`
+		INSERT INTO templates (name, description, subject, body, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			description = excluded.description, subject = excluded.subject,
+			body = excluded.body, updated_at = excluded.updated_at
+		RETURNING created_at`,
+		t.Name, t.Description, t.Subject, t.Body, t.CreatedAt, t.UpdatedAt).Scan(&t.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save template: %w", err)
+	}
+	return nil
+}
+
+const templateColumns = `name, description, subject, body, created_at, updated_at`
+
+// GetTemplate returns a template by name
+func (d *Database) GetTemplate(name string) (*Template, error) {
+	rows, err := d.db.Query(`SELECT `+templateColumns+` FROM templates WHERE name = ?`, strings.TrimSpace(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query template: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("template %w: %s", ErrNotFound, name)
+	}
+	return scanTemplate(rows)
+}
+
+// ListTemplates returns every template sorted by name
+func (d *Database) ListTemplates() ([]Template, error) {
+	rows, err := d.db.Query(`SELECT ` + templateColumns + ` FROM templates ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query templates: %w", err)
+	}
+	defer rows.Close()
+
+	var templates []Template
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, *t)
+	}
+	return templates, rows.Err()
+}
+
+// DeleteTemplate removes a template
+func (d *Database) DeleteTemplate(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	res, err := d.db.Exec(`DELETE FROM templates WHERE name = ?`, strings.TrimSpace(name))
+	if err != nil {
+		return fmt.Errorf("failed to delete template: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("template %w: %s", ErrNotFound, name)
+	}
+	return nil
+}
+
+func scanTemplate(rows *sql.Rows) (*Template, error) {
+	var t Template
+	var description sql.NullString
+	if err := rows.Scan(&t.Name, &description, &t.Subject, &t.Body, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		return nil, fmt.Errorf("failed to scan template: %w", err)
+	}
+	t.Description = description.String
+	return &t, nil
+}
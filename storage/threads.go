@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// resolveThreadID finds the conversation an email belongs to. A message joins
+// the thread of any stored message it references, or of a stored reply to it;
+// otherwise it starts a thread named after the root of its References chain,
+// so replies synced before their parent still end up together. batch holds
+// the emails stored before it in the same transaction, whose rows are not
+// written yet.
+func resolveThreadID(db execer, email *Email, batch []*Email) (string, error) {
+	parents := append([]string{}, email.References...)
+	if email.InReplyTo != "" {
+		parents = append(parents, email.InReplyTo)
+	}
+
+	var threadID sql.NullString
+	if len(parents) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(parents)), ", ")
+		args := []interface{}{email.AccountID}
+		for _, id := range parents {
+			args = append(args, id)
+		}
+		err := db.QueryRow(`SELECT thread_id FROM emails
+			WHERE account_id = ? AND thread_id IS NOT NULL AND message_id IN (`+placeholders+`) LIMIT 1`, args...).Scan(&threadID)
+		if err != nil && err != sql.ErrNoRows {
+			return "", fmt.Errorf("failed to resolve thread: %w", err)
+		}
+		if threadID.String != "" {
+			return threadID.String, nil
+		}
+		for _, other := range batch {
+			if other.AccountID == email.AccountID && other.MessageID != "" && slices.Contains(parents, other.MessageID) {
+				return other.ThreadID, nil
+			}
+		}
+	}
+
+	if email.MessageID != "" {
+		// The same message in another folder, or a reply that arrived first
+		err := db.QueryRow(`SELECT thread_id FROM emails
+			WHERE account_id = ? AND thread_id IS NOT NULL
+			AND (message_id = ? OR in_reply_to = ? OR ' ' || references_ids || ' ' LIKE ?) LIMIT 1`,
+			email.AccountID, email.MessageID, email.MessageID, "% "+email.MessageID+" %").Scan(&threadID)
+		if err != nil && err != sql.ErrNoRows {
+			return "", fmt.Errorf("failed to resolve thread: %w", err)
+		}
+		if threadID.String != "" {
+			return threadID.String, nil
+		}
+		for _, other := range batch {
+			if other.AccountID == email.AccountID && (other.MessageID == email.MessageID ||
+				other.InReplyTo == email.MessageID || slices.Contains(other.References, email.MessageID)) {
+				return other.ThreadID, nil
+			}
+		}
+	}
+
+	switch {
+	case len(email.References) > 0:
+		return email.References[0], nil
+	case email.InReplyTo != "":
+		return email.InReplyTo, nil
+	case email.MessageID != "":
+		return email.MessageID, nil
+	}
+	return fmt.Sprintf("%s/%d", email.Folder, email.UID), nil
+}
+
+// GetEmail returns a synced email by folder and UID
+func (d *Database) GetEmail(accountID, folder string, uid uint32) (*Email, error) {
+	rows, err := d.prepared().Query(`SELECT `+emailColumns+` FROM emails WHERE account_id = ? AND folder = ? AND uid = ?`,
+		accountID, folder, uid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query email: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("email %d %w in local %s; run sync_now first", uid, ErrNotFound, folder)
+	}
+	return scanEmail(rows)
+}
+
+// GetThread returns every synced email of a thread in chronological order.
+// Copies of the same message in several folders are only returned once.
+func (d *Database) GetThread(accountID, threadID string) ([]Email, error) {
+	rows, err := d.db.Query(`SELECT `+emailColumns+` FROM emails
+		WHERE account_id = ? AND thread_id = ? ORDER BY date, id`, accountID, threadID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query thread: %w", err)
+	}
+	defer rows.Close()
+
+	var emails []Email
+	seen := make(map[string]bool)
+	for rows.Next() {
+		e, err := scanEmail(rows)
+		if err != nil {
+			return nil, err
+		}
+		if e.MessageID != "" {
+			if seen[e.MessageID] {
+				continue
+			}
+			seen[e.MessageID] = true
+		}
+		emails = append(emails, *e)
+	}
+	return emails, rows.Err()
+}
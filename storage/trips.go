@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Trip is a flight a synced itinerary or booking email lists
+type Trip struct {
+	AccountID        string     `json:"account_id"`
+	Folder           string     `json:"folder"`
+	UID              uint32     `json:"uid"`
+	Airline          string     `json:"airline,omitempty"`
+	FlightNumber     string     `json:"flight_number"`
+	BookingReference string     `json:"booking_reference,omitempty"`
+	Origin           string     `json:"origin,omitempty"`
+	Destination      string     `json:"destination,omitempty"`
+	Departure        *time.Time `json:"departure,omitempty"`
+	// The email it was found in, set by UpcomingTrips
+	Email *Email `json:"email,omitempty"`
+}
+
+func (d *Database) initTrips() error {
+	schema := `
+	CREATE TABLE IF NOT EXISTS trips (
+		account_id TEXT NOT NULL,
+		folder TEXT NOT NULL,
+		uid INTEGER NOT NULL,
+		airline TEXT,
+		flight_number TEXT NOT NULL,
+		booking_reference TEXT,
+		origin TEXT,
+		destination TEXT,
+		departure DATETIME
+	);
+	CREATE INDEX IF NOT EXISTS idx_trips_email ON trips(account_id, folder, uid);
+	CREATE INDEX IF NOT EXISTS idx_trips_departure ON trips(account_id, departure);`
+
+	if _, err := d.db.Exec(schema); err != nil {
+		return fmt.Errorf("failed to initialize trips: %w", err)
+	}
+	return nil
+}
+
+// SaveTrips replaces the trips stored for an email. An empty list clears
+// them.
+func (d *Database) SaveTrips(accountID, folder string, uid uint32, trips []Trip) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to save trips: %w", err)
+	}
+	defer tx.Rollback()
+	db := prepared{d: d, tx: tx}
+
+	if _, err := db.Exec(`DELETE FROM trips WHERE account_id = ? AND folder = ? AND uid = ?`, accountID, folder, uid); err != nil {
+		return fmt.Errorf("failed to save trips: %w", err)
+	}
+	for _, trip := range trips {
+		var departure interface{}
+		if trip.Departure != nil {
+			departure = trip.Departure.UTC()
+		}
+		_, err := db.Exec(`
+			INSERT INTO trips (account_id, folder, uid, airline, flight_number, booking_reference, origin, destination, departure)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			accountID, folder, uid, trip.Airline, trip.FlightNumber, trip.BookingReference, trip.Origin, trip.Destination, departure)
+		if err != nil {
+			return fmt.Errorf("failed to save trips: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// UpcomingTrips returns the flights of an account departing between from
+// and until, soonest first. A flight listed by several emails, such as the
+// booking confirmation and the boarding pass, is returned once with the
+// latest of them. A non-empty folder keeps the emails of that folder.
+func (d *Database) UpcomingTrips(accountID, folder string, from, until time.Time, limit int) ([]Trip, error) {
+	rows, err := d.db.Query(`
+		SELECT `+emailColumns+`, trips.airline, trips.flight_number, trips.booking_reference, trips.origin,
+			trips.destination, trips.departure FROM trips
+		JOIN emails ON emails.account_id = trips.account_id AND emails.folder = trips.folder AND emails.uid = trips.uid
+		WHERE trips.account_id = ? AND (? = '' OR trips.folder = ?) AND trips.departure >= ? AND trips.departure <= ?
+		ORDER BY trips.departure, emails.date DESC`,
+		accountID, folder, folder, from.UTC(), until.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trips: %w", err)
+	}
+	defer rows.Close()
+
+	var trips []Trip
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var t Trip
+		var airline, reference, origin, destination sql.NullString
+		var departure sql.NullTime
+		e, err := scanEmail(rows, &airline, &t.FlightNumber, &reference, &origin, &destination, &departure)
+		if err != nil {
+			return nil, err
+		}
+		t.AccountID, t.Folder, t.UID = e.AccountID, e.Folder, e.UID
+		t.Airline, t.BookingReference = airline.String, reference.String
+		t.Origin, t.Destination = origin.String, destination.String
+		if departure.Valid {
+			t.Departure = &departure.Time
+		}
+		t.Email = e
+
+		key := t.FlightNumber + "\x00" + departure.Time.String()
+		if seen[key] || len(trips) >= limit {
+			continue
+		}
+		seen[key] = true
+		trips = append(trips, t)
+	}
+	return trips, rows.Err()
+}
@@ -0,0 +1,106 @@
+package sync
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"email-mcp-server/mail"
+	"email-mcp-server/storage"
+)
+
+// DeltaSource reads the folders of an account that is not served over
+// IMAP, such as a Microsoft Graph account, through incremental change
+// queries instead of UIDs
+type DeltaSource interface {
+	// SentFolder names the folder holding the sent mail of the account, ""
+	// to skip it
+	SentFolder() string
+	// Changes returns the messages of folder added or changed since the
+	// delta link of a previous call, or all of them when link is "", with
+	// the link of the next call. The emails only carry metadata. Stored
+	// emails that left the folder since link are removed from the database.
+	Changes(ctx context.Context, folder, link string) ([]*storage.Email, string, error)
+	// Fetch returns the full content of an email returned by Changes
+	Fetch(ctx context.Context, email *storage.Email) (*mail.ParsedEmail, error)
+}
+
+// syncDelta stores the changes of a folder since its last sync. Emails seen
+// before only get their flags updated; new ones are fetched in full and
+// returned. A folder that was never synced only gets its newest
+// initialLimit messages.
+func (e *Engine) syncDelta(ctx context.Context, source DeltaSource, accountID, folder string) ([]*storage.Email, error) {
+	state, err := e.db.GetSyncState(accountID, folder)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		state = &storage.SyncState{AccountID: accountID, Folder: folder}
+	}
+
+	changes, link, err := source.Changes(ctx, folder, state.DeltaLink)
+	var stored []*storage.Email
+	if err == nil {
+		sort.Slice(changes, func(i, j int) bool { return changes[i].Date.Before(changes[j].Date) })
+		if state.DeltaLink == "" && e.initialLimit > 0 && len(changes) > int(e.initialLimit) {
+			changes = changes[len(changes)-int(e.initialLimit):]
+		}
+		stored, err = e.storeChanges(ctx, source, state, changes)
+		if err == nil {
+			state.DeltaLink = link
+		}
+	}
+
+	state.LastSync = time.Now()
+	state.LastError = ""
+	if err != nil {
+		state.LastError = err.Error()
+	}
+	if saveErr := e.db.SaveSyncState(state); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return stored, err
+}
+
+// storeChanges saves changes in order and returns the new emails. Emails
+// fetched before a failure are still stored, so the next sync only fetches
+// the rest.
+func (e *Engine) storeChanges(ctx context.Context, source DeltaSource, state *storage.SyncState, changes []*storage.Email) ([]*storage.Email, error) {
+	var batch, fresh []*storage.Email
+	var err error
+	for _, email := range changes {
+		if _, getErr := e.db.GetEmail(email.AccountID, email.Folder, email.UID); getErr == nil {
+			// Already synced: only the flags are updated
+			batch = append(batch, email)
+			continue
+		}
+
+		parsed, fetchErr := source.Fetch(ctx, email)
+		if fetchErr != nil {
+			err = fetchErr
+			break
+		}
+		body := parsed.TextBody
+		if e.Redact != nil {
+			body = e.Redact(state.AccountID, body)
+		}
+		email.BodySnippet = e.snippet(body)
+		email.References = strings.Fields(parsed.Header("References"))
+		if email.InReplyTo == "" {
+			email.InReplyTo = parsed.Header("In-Reply-To")
+		}
+		batch = append(batch, email)
+		fresh = append(fresh, email)
+	}
+
+	// The changes are stored in one transaction
+	if saveErr := e.db.CreateEmails(batch); saveErr != nil {
+		return nil, saveErr
+	}
+	for _, email := range fresh {
+		e.resolveFollowups(state.Folder, email)
+		state.LastUID = max(state.LastUID, email.UID)
+	}
+	return fresh, err
+}
@@ -0,0 +1,755 @@
+// Package sync copies new messages from IMAP into the local database,
+// tracking UIDVALIDITY/UIDNEXT per folder so each run only fetches what is new.
+// Accounts without IMAP are synced through the delta links of a DeltaSource.
+// INBOX is always synced, and the Sent folder when it can be found.
+package sync
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	stdsync "sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+
+	"email-mcp-server/imapext"
+	"email-mcp-server/mail"
+	"email-mcp-server/storage"
+)
+
+// snippetLength is the default number of body characters stored per synced
+// email, and snippetBytes the default number of bytes of its text part
+// fetched to build them
+const (
+	snippetLength = 500
+	snippetBytes  = 4096
+)
+
+// Dialer opens an authenticated IMAP connection for an account that is
+// closed when ctx ends
+type Dialer func(ctx context.Context, accountID string) (*client.Client, error)
+
+// Status reports the outcome of the latest sync of an account
+type Status struct {
+	AccountID   string     `json:"account_id"`
+	Folder      string     `json:"folder"`
+	Running     bool       `json:"running"`
+	LastSync    *time.Time `json:"last_sync,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	NewMessages int        `json:"new_messages"`
+	TotalSynced int        `json:"total_synced"`
+	// Sent folder synced along with INBOX, if any
+	SentFolder string `json:"sent_folder,omitempty"`
+	NewSent    int    `json:"new_sent,omitempty"`
+	// New messages by folder, for accounts whose folders are configured
+	NewByFolder map[string]int `json:"new_by_folder,omitempty"`
+	// State of the IDLE connection when push is on
+	Push string `json:"push,omitempty"`
+}
+
+// Folder is a folder an account syncs, with its settings
+type Folder struct {
+	Name string
+	// HeadersOnly stores the messages without a body snippet, sparing the
+	// fetch of their text
+	HeadersOnly bool
+	// MaxAge, when positive, limits the first sync of the folder to the
+	// messages received within it
+	MaxAge time.Duration
+	// InitialLimit, when positive, replaces the engine's cap on the messages
+	// fetched the first time the folder is synced
+	InitialLimit int
+}
+
+// Engine periodically syncs INBOX, and the Sent folder, of every configured
+// account
+type Engine struct {
+	db           *storage.Database
+	dial         Dialer
+	accounts     []string
+	interval     time.Duration
+	initialLimit uint32
+
+	// OnNewMail, if set before Start, is called after a sync stores new
+	// messages in a folder
+	OnNewMail func(accountID, folder string, count int)
+	// OnNewEmails, if set before Start, receives the messages a sync stored,
+	// after OnNewMail
+	OnNewEmails func(accountID, folder string, emails []*storage.Email)
+	// OnSynced, if set before Start, is called after every successful sync
+	// of an account, new messages or not, after OnNewEmails
+	OnSynced func(ctx context.Context, accountID string)
+	// Redact, if set before Start, filters the body of each message of an
+	// account before its snippet is stored
+	Redact func(accountID, body string) string
+	// SentFolder, if set before Start, names the folder holding the sent
+	// mail of an account, synced after INBOX. "" skips it.
+	SentFolder func(c *client.Client, accountID string) (string, error)
+	// Folders, if set before Start, returns the folders of an account to
+	// sync instead of INBOX and the Sent folder; nil keeps those. Only the
+	// names apply to accounts synced through a DeltaSource.
+	Folders func(accountID string) []Folder
+	// Delta, if set before Start, returns the DeltaSource of an account
+	// that is synced without IMAP, nil for IMAP accounts
+	Delta func(accountID string) DeltaSource
+	// SnippetLength and SnippetBytes, if set before Start, replace the
+	// number of body characters stored per email and the number of bytes
+	// of its text part fetched for them
+	SnippetLength int
+	SnippetBytes  int
+	// Push, if set before Start, keeps an IDLE connection to the INBOX of
+	// every IMAP account and syncs it when the server reports a change, see
+	// idle.go
+	Push bool
+	// IdleRenew and IdleRetry, if set before Start, replace the period after
+	// which IDLE is restarted and the first delay before reconnecting a lost
+	// IDLE connection
+	IdleRenew time.Duration
+	IdleRetry time.Duration
+
+	mu      stdsync.Mutex
+	status  map[string]*Status
+	locks   map[string]*stdsync.Mutex
+	cancel  context.CancelFunc
+	stopped chan struct{}
+
+	listenCtx context.Context               // ends with Stop; nil unless push runs
+	listeners map[string]context.CancelFunc // IDLE listeners by account ID
+	listening stdsync.WaitGroup
+}
+
+// NewEngine creates a sync engine. interval is the period of the background
+// loop started by Start; initialLimit caps how many of the newest messages
+// are fetched the first time a folder is synced.
+func NewEngine(db *storage.Database, dial Dialer, accounts []string, interval time.Duration, initialLimit int) *Engine {
+	e := &Engine{
+		db:           db,
+		dial:         dial,
+		accounts:     accounts,
+		interval:     interval,
+		initialLimit: uint32(initialLimit),
+		status:       make(map[string]*Status),
+		locks:        make(map[string]*stdsync.Mutex),
+		listeners:    make(map[string]context.CancelFunc),
+	}
+	for _, account := range accounts {
+		e.status[account] = &Status{AccountID: account, Folder: "INBOX"}
+		e.locks[account] = &stdsync.Mutex{}
+	}
+	return e
+}
+
+// Start runs a sync of every account immediately and then every interval,
+// and with Push the IDLE listeners, until Stop is called
+func (e *Engine) Start() {
+	if e.interval <= 0 && !e.Push {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	e.stopped = make(chan struct{})
+
+	if e.Push {
+		e.mu.Lock()
+		e.listenCtx = ctx
+		for _, account := range e.accounts {
+			e.startListener(account)
+		}
+		e.mu.Unlock()
+	}
+
+	go func() {
+		defer close(e.stopped)
+		if e.interval <= 0 {
+			// The listeners sync each account when they connect
+			<-ctx.Done()
+			return
+		}
+
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+
+		for {
+			e.SyncAll(ctx)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the background loop, aborting a running sync, and waits for it
+// to return
+func (e *Engine) Stop() {
+	if e.cancel == nil {
+		return
+	}
+	e.cancel()
+	<-e.stopped
+	e.listening.Wait()
+	e.cancel = nil
+	e.mu.Lock()
+	e.listenCtx = nil
+	clear(e.listeners)
+	e.mu.Unlock()
+}
+
+// AddAccount starts syncing an account added after the engine was created
+func (e *Engine) AddAccount(accountID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.locks[accountID]; ok {
+		return
+	}
+	e.accounts = append(e.accounts, accountID)
+	e.status[accountID] = &Status{AccountID: accountID, Folder: "INBOX"}
+	e.locks[accountID] = &stdsync.Mutex{}
+	e.startListener(accountID)
+}
+
+// RemoveAccount stops syncing an account. Its synced emails stay in the
+// database.
+func (e *Engine) RemoveAccount(accountID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i, account := range e.accounts {
+		if account == accountID {
+			e.accounts = append(e.accounts[:i:i], e.accounts[i+1:]...)
+			break
+		}
+	}
+	delete(e.status, accountID)
+	delete(e.locks, accountID)
+	e.stopListener(accountID)
+}
+
+func (e *Engine) accountIDs() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.accounts...)
+}
+
+// SyncAll syncs every account, logging failures, and returns their statuses.
+// Accounts not reached before ctx ends are skipped.
+func (e *Engine) SyncAll(ctx context.Context) []Status {
+	for _, account := range e.accountIDs() {
+		if ctx.Err() != nil {
+			break
+		}
+		if _, err := e.SyncAccount(ctx, account); err != nil {
+			log.Printf("Sync of account %s failed: %v", account, err)
+		}
+	}
+	return e.Status()
+}
+
+// SyncAccount fetches the new messages of an account's folders, INBOX and
+// the Sent folder unless Folders names others, into the database. The IMAP connection is closed, failing the sync, when ctx ends.
+func (e *Engine) SyncAccount(ctx context.Context, accountID string) (*Status, error) {
+	e.mu.Lock()
+	lock, ok := e.locks[accountID]
+	e.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("account not found: %s", accountID)
+	}
+
+	// Only one sync per account at a time; a manual sync_now waits for the
+	// background one instead of fetching the same messages twice
+	lock.Lock()
+	defer lock.Unlock()
+
+	e.updateStatus(accountID, func(s *Status) { s.Running = true })
+
+	var folders []Folder
+	if e.Folders != nil {
+		folders = e.Folders(accountID)
+	}
+	configured := len(folders) > 0
+
+	// The new emails of each synced folder, in sync order
+	type folderEmails struct {
+		name   string
+		emails []*storage.Email
+	}
+	var synced []folderEmails
+	var err error
+	sentFolder := ""
+	if source := e.deltaSource(accountID); source != nil {
+		if !configured {
+			folders = []Folder{{Name: "INBOX"}}
+			if sentFolder = source.SentFolder(); sentFolder != "" {
+				folders = append(folders, Folder{Name: sentFolder})
+			}
+		}
+		for _, folder := range folders {
+			var emails []*storage.Email
+			if emails, err = e.syncDelta(ctx, source, accountID, folder.Name); err != nil {
+				break
+			}
+			synced = append(synced, folderEmails{folder.Name, emails})
+		}
+	} else {
+		var c *client.Client
+		c, err = e.dial(ctx, accountID)
+		if err == nil {
+			if !configured {
+				folders = []Folder{{Name: "INBOX"}}
+			}
+			for i := 0; i < len(folders) && err == nil; i++ {
+				var emails []*storage.Email
+				if emails, err = e.syncFolder(c, accountID, folders[i]); err != nil {
+					break
+				}
+				synced = append(synced, folderEmails{folders[i].Name, emails})
+				// The Sent folder is looked up once INBOX is synced
+				if !configured && i == 0 && e.SentFolder != nil {
+					if sentFolder, err = e.SentFolder(c, accountID); err == nil && sentFolder != "" {
+						folders = append(folders, Folder{Name: sentFolder})
+					}
+				}
+			}
+			c.Logout()
+		}
+	}
+	if err != nil && ctx.Err() != nil {
+		// The connection was closed under the sync
+		err = fmt.Errorf("sync aborted: %v", ctx.Err())
+	}
+	count, sent := 0, 0
+	var byFolder map[string]int
+	if configured {
+		byFolder = make(map[string]int)
+	}
+	for _, f := range synced {
+		switch {
+		case configured:
+			byFolder[f.name] = len(f.emails)
+			count += len(f.emails)
+		case f.name == "INBOX":
+			count = len(f.emails)
+		default:
+			sent = len(f.emails)
+		}
+	}
+	total, _ := e.db.CountEmails(accountID)
+
+	now := time.Now()
+	e.updateStatus(accountID, func(s *Status) {
+		s.Running = false
+		s.LastSync = &now
+		s.NewMessages = count
+		s.TotalSynced = total
+		s.SentFolder = sentFolder
+		s.NewSent = sent
+		s.NewByFolder = byFolder
+		s.LastError = ""
+		if err != nil {
+			s.LastError = err.Error()
+		}
+	})
+
+	for _, f := range synced {
+		if len(f.emails) > 0 && e.OnNewMail != nil {
+			e.OnNewMail(accountID, f.name, len(f.emails))
+		}
+		if len(f.emails) > 0 && e.OnNewEmails != nil {
+			e.OnNewEmails(accountID, f.name, f.emails)
+		}
+	}
+	if err == nil && e.OnSynced != nil {
+		e.OnSynced(ctx, accountID)
+	}
+
+	status := e.accountStatus(accountID)
+	return &status, err
+}
+
+// deltaSource returns the DeltaSource of an account, nil when it is synced
+// over IMAP
+func (e *Engine) deltaSource(accountID string) DeltaSource {
+	if e.Delta == nil {
+		return nil
+	}
+	return e.Delta(accountID)
+}
+
+// Status returns the sync status of every account
+func (e *Engine) Status() []Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	statuses := make([]Status, 0, len(e.accounts))
+	for _, account := range e.accounts {
+		statuses = append(statuses, *e.status[account])
+	}
+	return statuses
+}
+
+func (e *Engine) accountStatus(accountID string) Status {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if status, ok := e.status[accountID]; ok {
+		return *status
+	}
+	return Status{AccountID: accountID, Folder: "INBOX"}
+}
+
+// updateStatus applies update unless the account was removed meanwhile
+func (e *Engine) updateStatus(accountID string, update func(*Status)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if status, ok := e.status[accountID]; ok {
+		update(status)
+	}
+}
+
+func (e *Engine) syncFolder(c *client.Client, accountID string, settings Folder) ([]*storage.Email, error) {
+	folder := settings.Name
+	// Read before SELECT: STATUS of the selected folder is not reliable
+	modseq, err := imapext.HighestModSeq(c, folder)
+	if err != nil {
+		log.Printf("Flag changes of %s/%s not synced: %v", accountID, folder, err)
+		modseq = 0
+	}
+
+	mbox, err := c.Select(folder, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select folder %s: %w", folder, err)
+	}
+
+	state, err := e.db.GetSyncState(accountID, folder)
+	if err != nil {
+		return nil, err
+	}
+
+	if state == nil || state.UIDValidity != mbox.UidValidity {
+		if state != nil {
+			log.Printf("UIDVALIDITY of %s/%s changed, resyncing folder", accountID, folder)
+			if err := e.db.DeleteFolderEmails(accountID, folder); err != nil {
+				return nil, err
+			}
+		}
+		state = &storage.SyncState{AccountID: accountID, Folder: folder, UIDValidity: mbox.UidValidity}
+	}
+
+	// With CONDSTORE, flags changed on the server since the last sync, such
+	// as messages read elsewhere, are synced too; without it only the stars
+	// of the synced messages are, besides the new messages
+	if state.ModSeq > 0 && modseq > state.ModSeq && state.LastUID > 0 {
+		err = e.syncFlags(c, state)
+	} else if modseq == 0 && state.LastUID > 0 {
+		err = e.syncStarred(c, state)
+	}
+	// Expunging raises HIGHESTMODSEQ, so with CONDSTORE an unchanged
+	// folder needs no search
+	if err == nil && state.LastUID > 0 && (modseq == 0 || modseq != state.ModSeq) {
+		err = e.syncExpunged(c, state)
+	}
+	var emails []*storage.Email
+	if err == nil && mbox.Messages > 0 && (mbox.UidNext == 0 || mbox.UidNext > state.LastUID+1) {
+		emails, err = e.fetchNew(c, mbox, state, settings)
+	}
+	if err == nil {
+		state.ModSeq = modseq
+	}
+
+	state.UIDNext = mbox.UidNext
+	state.LastSync = time.Now()
+	state.LastError = ""
+	if err != nil {
+		state.LastError = err.Error()
+	}
+	if saveErr := e.db.SaveSyncState(state); saveErr != nil && err == nil {
+		err = saveErr
+	}
+
+	return emails, err
+}
+
+// syncFlags updates the flags of the synced messages changed since
+// state.ModSeq
+func (e *Engine) syncFlags(c *client.Client, state *storage.SyncState) error {
+	uidset := new(imap.SeqSet)
+	uidset.AddRange(1, state.LastUID)
+
+	messages := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- imapext.FetchChangedFlags(c, uidset, state.ModSeq, messages)
+	}()
+
+	flags := make(map[uint32][]string)
+	for msg := range messages {
+		flags[msg.Uid] = msg.Flags
+	}
+	if err := <-done; err != nil {
+		return fmt.Errorf("failed to fetch flag changes: %w", err)
+	}
+	return e.db.UpdateAllFlags(state.AccountID, state.Folder, flags)
+}
+
+// syncStarred updates which synced messages are starred, those with the
+// \Flagged flag, searching for them all since without CONDSTORE the server
+// cannot tell which changed
+func (e *Engine) syncStarred(c *client.Client, state *storage.SyncState) error {
+	criteria := imap.NewSearchCriteria()
+	criteria.Uid = new(imap.SeqSet)
+	criteria.Uid.AddRange(1, state.LastUID)
+	criteria.WithFlags = []string{imap.FlaggedFlag}
+	uids, err := c.UidSearch(criteria)
+	if err != nil {
+		return fmt.Errorf("failed to search starred messages: %w", err)
+	}
+	return e.db.SyncStarred(state.AccountID, state.Folder, uids, state.LastUID)
+}
+
+// syncExpunged deletes the synced messages that are no longer in the
+// folder, expunged or moved elsewhere, comparing the synced UIDs with those
+// the server still has
+func (e *Engine) syncExpunged(c *client.Client, state *storage.SyncState) error {
+	local, err := e.db.SyncedUIDs(state.AccountID, state.Folder, state.LastUID)
+	if err != nil || len(local) == 0 {
+		return err
+	}
+
+	criteria := imap.NewSearchCriteria()
+	criteria.Uid = new(imap.SeqSet)
+	criteria.Uid.AddRange(local[0], state.LastUID)
+	uids, err := c.UidSearch(criteria)
+	if err != nil {
+		return fmt.Errorf("failed to search for expunged messages: %w", err)
+	}
+	present := make(map[uint32]bool, len(uids))
+	for _, uid := range uids {
+		present[uid] = true
+	}
+
+	var gone []uint32
+	for _, uid := range local {
+		if !present[uid] {
+			gone = append(gone, uid)
+		}
+	}
+	if len(gone) == 0 {
+		return nil
+	}
+	log.Printf("Removing %d messages no longer in %s/%s", len(gone), state.AccountID, state.Folder)
+	return e.db.DeleteEmails(state.AccountID, state.Folder, gone)
+}
+
+// fetchNew stores every message above state.LastUID. A folder that was never
+// synced only gets its newest initialLimit messages, received within
+// settings.MaxAge when set. Only the envelopes and body structures, which
+// also list the attachments, are fetched at first; the snippets are then
+// built from the first SnippetBytes of each text part, so large messages
+// cost no more than small ones.
+func (e *Engine) fetchNew(c *client.Client, mbox *imap.MailboxStatus, state *storage.SyncState, settings Folder) ([]*storage.Email, error) {
+	fields := append([]string{"References"}, mail.AutoKindHeaders...)
+	header := &imap.BodySectionName{BodyPartName: imap.BodyPartName{Specifier: imap.HeaderSpecifier, Fields: fields}, Peek: true}
+	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchFlags, imap.FetchRFC822Size, imap.FetchUid, imap.FetchBodyStructure, header.FetchItem()}
+
+	limit := e.initialLimit
+	if settings.InitialLimit > 0 {
+		limit = uint32(settings.InitialLimit)
+	}
+	var recent []uint32
+	if state.LastUID == 0 && settings.MaxAge > 0 {
+		criteria := imap.NewSearchCriteria()
+		criteria.Since = time.Now().Add(-settings.MaxAge)
+		uids, err := c.UidSearch(criteria)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search %s for recent messages: %w", state.Folder, err)
+		}
+		if len(uids) == 0 {
+			return nil, nil
+		}
+		slices.Sort(uids)
+		if limit > 0 && len(uids) > int(limit) {
+			uids = uids[len(uids)-int(limit):]
+		}
+		recent = uids
+	}
+
+	messages := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
+
+	go func() {
+		seqset := new(imap.SeqSet)
+		switch {
+		case recent != nil:
+			seqset.AddNum(recent...)
+			done <- c.UidFetch(seqset, items, messages)
+		case state.LastUID == 0:
+			from := uint32(1)
+			if limit > 0 && mbox.Messages > limit {
+				from = mbox.Messages - limit + 1
+			}
+			seqset.AddRange(from, mbox.Messages)
+			done <- c.Fetch(seqset, items, messages)
+		default:
+			seqset.AddRange(state.LastUID+1, 0)
+			done <- c.UidFetch(seqset, items, messages)
+		}
+	}()
+
+	var emails []*storage.Email
+	parts := make(map[uint32]*imapext.BodyPart)
+	for msg := range messages {
+		// "UID n:*" always returns the last message, even if already synced
+		if msg.Uid <= state.LastUID || msg.Envelope == nil {
+			continue
+		}
+
+		from := firstAddress(msg.Envelope.From)
+		email := &storage.Email{
+			AccountID:   state.AccountID,
+			Folder:      state.Folder,
+			UID:         msg.Uid,
+			MessageID:   msg.Envelope.MessageId,
+			Subject:     mail.DecodeHeader(msg.Envelope.Subject),
+			From:        from.String(),
+			FromAddress: from,
+			To:          formatAddresses(msg.Envelope.To),
+			Date:        msg.Envelope.Date,
+			Size:        msg.Size,
+			Flags:       msg.Flags,
+			InReplyTo:   msg.Envelope.InReplyTo,
+		}
+		if r := msg.GetBody(header); r != nil {
+			if parsed, err := mail.ParseHeader(r); err == nil {
+				email.References = strings.Fields(parsed.Header("References"))
+				email.Automated = parsed.AutoKind()
+			}
+		}
+		if msg.BodyStructure != nil {
+			if text, _ := imapext.BodyParts(msg.BodyStructure); text != nil && !settings.HeadersOnly {
+				parts[msg.Uid] = text
+			}
+			for _, a := range imapext.Attachments(msg.BodyStructure) {
+				email.Attachments = append(email.Attachments, mail.Attachment{Filename: a.Filename, ContentType: a.ContentType, Size: int(a.Size)})
+			}
+		}
+		emails = append(emails, email)
+	}
+	if err := <-done; err != nil {
+		return nil, err
+	}
+
+	bodies, err := e.fetchSnippetText(c, parts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, email := range emails {
+		body := bodies[email.UID]
+		if e.Redact != nil {
+			body = e.Redact(state.AccountID, body)
+		}
+		email.BodySnippet = e.snippet(body)
+	}
+
+	// The whole batch is stored in one transaction
+	if err := e.db.CreateEmails(emails); err != nil {
+		return nil, err
+	}
+	for _, email := range emails {
+		e.resolveFollowups(state.Folder, email)
+		state.LastUID = max(state.LastUID, email.UID)
+	}
+	return emails, nil
+}
+
+// resolveFollowups closes the follow-ups answered by a newly stored email.
+// Only replies received can resolve a follow-up, not the user's own.
+func (e *Engine) resolveFollowups(folder string, email *storage.Email) {
+	if folder != "INBOX" {
+		return
+	}
+	if n, err := e.db.ResolveFollowups(email); err != nil {
+		log.Printf("Failed to resolve follow-ups for %s: %v", email.MessageID, err)
+	} else if n > 0 {
+		log.Printf("Reply from %s resolved %d follow-up(s)", email.From, n)
+	}
+}
+
+// fetchSnippetText fetches and decodes the first SnippetBytes of the text
+// part of each message, by UID. Messages whose text part has the same path,
+// "1" for most, are fetched together.
+func (e *Engine) fetchSnippetText(c *client.Client, parts map[uint32]*imapext.BodyPart) (map[uint32]string, error) {
+	limit := e.SnippetBytes
+	if limit <= 0 {
+		limit = snippetBytes
+	}
+
+	groups := make(map[string][]uint32)
+	for uid, part := range parts {
+		key := fmt.Sprint(part.Path)
+		groups[key] = append(groups[key], uid)
+	}
+
+	bodies := make(map[uint32]string, len(parts))
+	for _, uids := range groups {
+		section := parts[uids[0]].Section(limit)
+		uidset := new(imap.SeqSet)
+		uidset.AddNum(uids...)
+
+		messages := make(chan *imap.Message, 10)
+		done := make(chan error, 1)
+		go func() {
+			done <- c.UidFetch(uidset, []imap.FetchItem{imap.FetchUid, section.FetchItem()}, messages)
+		}()
+
+		for msg := range messages {
+			part, ok := parts[msg.Uid]
+			if !ok {
+				continue
+			}
+			if r := msg.GetBody(section); r != nil {
+				bodies[msg.Uid] = mail.DecodePart(r, part.Encoding, part.Charset, 0)
+			}
+		}
+		if err := <-done; err != nil {
+			return nil, fmt.Errorf("failed to fetch message text: %w", err)
+		}
+	}
+	return bodies, nil
+}
+
+// snippet returns the first SnippetLength characters of body, with its
+// whitespace collapsed
+func (e *Engine) snippet(body string) string {
+	length := e.SnippetLength
+	if length <= 0 {
+		length = snippetLength
+	}
+	body = strings.Join(strings.Fields(body), " ")
+	if runes := []rune(body); len(runes) > length {
+		return string(runes[:length])
+	}
+	return body
+}
+
+// firstAddress returns the first envelope address, e.g. the sender
+func firstAddress(addrs []*imap.Address) mail.Address {
+	if len(addrs) == 0 {
+		return mail.Address{}
+	}
+	return mail.Address{Name: mail.DecodeHeader(addrs[0].PersonalName), Address: addrs[0].Address()}
+}
+
+func formatAddresses(addrs []*imap.Address) []string {
+	var result []string
+	for _, addr := range addrs {
+		result = append(result, addr.Address())
+	}
+	return result
+}
@@ -0,0 +1,222 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/emersion/go-imap/client"
+)
+
+// With Push on, each IMAP account keeps a connection in IDLE on INBOX and is
+// synced as soon as the server reports a change. IDLE is ended and restarted
+// every IdleRenew, well before the 30 minutes after which RFC 2177 lets a
+// server drop an idle client, and a NOOP then checks the connection is still
+// alive, since a connection lost to a network change or a sleeping laptop
+// otherwise looks like a quiet mailbox. The account is synced after each
+// renewal too, catching up on changes a server did not report. When the
+// connection fails it is opened again after a delay that doubles up to
+// maxIdleRetry, and the account is resynced to pick up what arrived while
+// it was down.
+
+const (
+	// defaultIdleRenew is the default period after which IDLE is restarted
+	defaultIdleRenew = 25 * time.Minute
+	// defaultIdleRetry is the default first delay before reconnecting
+	defaultIdleRetry = 10 * time.Second
+	// maxIdleRetry caps the delay between reconnection attempts
+	maxIdleRetry = 5 * time.Minute
+	// idleResponseTimeout bounds the wait for the answer to DONE and NOOP
+	idleResponseTimeout = time.Minute
+)
+
+// errIdleUnsupported ends the listener of an account whose server does not
+// support IDLE; the periodic sync still covers it
+var errIdleUnsupported = errors.New("the server does not support IDLE")
+
+// startListener starts the IDLE listener of an account. e.mu must be held.
+func (e *Engine) startListener(accountID string) {
+	if e.listenCtx == nil || e.listeners[accountID] != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(e.listenCtx)
+	e.listeners[accountID] = cancel
+	e.listening.Add(1)
+	go func() {
+		defer e.listening.Done()
+		e.listen(ctx, accountID)
+	}()
+}
+
+// stopListener stops the IDLE listener of an account. e.mu must be held.
+func (e *Engine) stopListener(accountID string) {
+	if cancel := e.listeners[accountID]; cancel != nil {
+		cancel()
+		delete(e.listeners, accountID)
+	}
+}
+
+// listen keeps an IDLE connection open for an account until ctx ends,
+// reconnecting when it fails
+func (e *Engine) listen(ctx context.Context, accountID string) {
+	if e.deltaSource(accountID) != nil {
+		return
+	}
+	renew := e.IdleRenew
+	if renew <= 0 {
+		renew = defaultIdleRenew
+	}
+	firstRetry := e.IdleRetry
+	if firstRetry <= 0 {
+		firstRetry = defaultIdleRetry
+	}
+
+	retry := firstRetry
+	for {
+		started := time.Now()
+		err := e.idle(ctx, accountID, renew)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, errIdleUnsupported) {
+			e.updateStatus(accountID, func(s *Status) { s.Push = "unsupported, polling only" })
+			log.Printf("Push for account %s is off: %v", accountID, err)
+			return
+		}
+		// A connection that lasted a renewal was healthy: start over from
+		// the first delay
+		if time.Since(started) > renew {
+			retry = firstRetry
+		}
+		e.updateStatus(accountID, func(s *Status) { s.Push = fmt.Sprintf("reconnecting in %v: %v", retry, err) })
+		log.Printf("IDLE connection of account %s lost, reconnecting in %v: %v", accountID, retry, err)
+
+		select {
+		case <-time.After(retry):
+		case <-ctx.Done():
+			return
+		}
+		if retry *= 2; retry > maxIdleRetry {
+			retry = maxIdleRetry
+		}
+	}
+}
+
+// idle opens a connection, syncs the account and idles on INBOX until the
+// connection fails or ctx ends
+func (e *Engine) idle(ctx context.Context, accountID string, renew time.Duration) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	c, err := e.dial(ctx, accountID)
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+	if ok, err := c.Support("IDLE"); err != nil {
+		return err
+	} else if !ok {
+		return errIdleUnsupported
+	}
+
+	// Updates must be read, or the connection stalls; any of them during
+	// IDLE means the account needs a sync. Those answering SELECT and NOOP
+	// are covered by the sync that follows them.
+	updates := make(chan client.Update, 16)
+	changed := make(chan struct{}, 1)
+	var idling atomic.Bool
+	c.Updates = updates
+	go func() {
+		for {
+			select {
+			case update := <-updates:
+				if !idling.Load() {
+					continue
+				}
+				switch update.(type) {
+				case *client.MailboxUpdate, *client.ExpungeUpdate, *client.MessageUpdate:
+					select {
+					case changed <- struct{}{}:
+					default:
+					}
+				}
+			case <-c.LoggedOut():
+				return
+			}
+		}
+	}()
+
+	if _, err := c.Select("INBOX", true); err != nil {
+		return err
+	}
+	// Catch up on what arrived while there was no connection
+	e.syncFromIdle(ctx, accountID)
+
+	// IDLE waits for minutes without a word from the server, so the command
+	// timeout the dialer may have set would cut it short
+	c.Timeout = 0
+	for {
+		e.updateStatus(accountID, func(s *Status) { s.Push = "idle" })
+		stop := make(chan struct{})
+		done := make(chan error, 1)
+		idling.Store(true)
+		go func() {
+			done <- c.Idle(stop, &client.IdleOptions{LogoutTimeout: -1})
+		}()
+
+		timer := time.NewTimer(renew)
+		select {
+		case err := <-done:
+			timer.Stop()
+			if err == nil {
+				err = errors.New("the server ended IDLE")
+			}
+			return err
+		case <-ctx.Done():
+			timer.Stop()
+		case <-changed:
+			timer.Stop()
+		case <-timer.C:
+		}
+
+		close(stop)
+		idling.Store(false)
+		select {
+		case err := <-done:
+			if err != nil {
+				return err
+			}
+		case <-time.After(idleResponseTimeout):
+			c.Terminate()
+			return fmt.Errorf("no answer to DONE within %v", idleResponseTimeout)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c.Timeout = idleResponseTimeout
+		err := c.Noop()
+		c.Timeout = 0
+		if err != nil {
+			return fmt.Errorf("keepalive NOOP failed: %w", err)
+		}
+
+		// Changes reported while the account syncs are picked up by the
+		// next round
+		select {
+		case <-changed:
+		default:
+		}
+		e.syncFromIdle(ctx, accountID)
+	}
+}
+
+// syncFromIdle syncs an account on a connection of its own, logging
+// failures
+func (e *Engine) syncFromIdle(ctx context.Context, accountID string) {
+	if _, err := e.SyncAccount(ctx, accountID); err != nil && ctx.Err() == nil {
+		log.Printf("Sync of account %s failed: %v", accountID, err)
+	}
+}
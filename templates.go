@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"email-mcp-server/mail"
+	"email-mcp-server/storage"
+)
+
+// Templates are kept in the local database, shared by every account. Their
+// {{placeholders}} are filled from the variables passed to render_template
+// and send_from_template; sending refuses templates left with placeholders
+// that have no value.
+
+func (es *EmailServer) handleSaveTemplate(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("templates are not available: %w", errNoDatabase)
+	}
+
+	t := &storage.Template{}
+	t.Name, _ = args["name"].(string)
+	t.Subject, _ = args["subject"].(string)
+	t.Body, _ = args["body"].(string)
+	t.Description, _ = args["description"].(string)
+	if strings.TrimSpace(t.Name) == "" || t.Subject == "" || t.Body == "" {
+		return nil, invalidArgument("missing required parameters: name, subject, body")
+	}
+
+	if err := es.db.SaveTemplate(t); err != nil {
+		return nil, err
+	}
+
+	text := fmt.Sprintf("Template %q saved", t.Name)
+	if placeholders := t.Placeholders(); len(placeholders) > 0 {
+		text += fmt.Sprintf(" with placeholders: %s", strings.Join(placeholders, ", "))
+	}
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: text,
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleListTemplates(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("templates are not available: %w", errNoDatabase)
+	}
+
+	templates, err := es.db.ListTemplates()
+	if err != nil {
+		return nil, err
+	}
+
+	type templateInfo struct {
+		storage.Template
+		Placeholders []string `json:"placeholders,omitempty"`
+	}
+	infos := make([]templateInfo, 0, len(templates))
+	for _, t := range templates {
+		infos = append(infos, templateInfo{Template: t, Placeholders: t.Placeholders()})
+	}
+
+	templatesJSON, _ := json.MarshalIndent(infos, "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("%d template(s):\n%s", len(infos), string(templatesJSON)),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleRenderTemplate(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	t, err := es.templateFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, body, missing := t.Render(templateVariables(args["variables"]))
+	text := fmt.Sprintf("Subject: %s\n\n%s", subject, body)
+	if len(missing) > 0 {
+		text += fmt.Sprintf("\n\nWarning: no value for %s", strings.Join(missing, ", "))
+	}
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: text,
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleSendFromTemplate(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	t, err := es.templateFromArgs(args)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, body, missing := t.Render(templateVariables(args["variables"]))
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("template %q needs values for: %s", t.Name, strings.Join(missing, ", "))
+	}
+
+	accountID, _ := args["account"].(string)
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+	to, cc, bcc, err := es.recipientArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(to) == 0 {
+		return nil, fmt.Errorf("%w: to", errMissingParameter)
+	}
+
+	msg := &mail.OutgoingMessage{
+		To:        to,
+		Cc:        cc,
+		Bcc:       bcc,
+		Subject:   subject,
+		Body:      config.signed(body),
+		MessageID: mail.NewMessageID(config.Username),
+	}
+	if err := es.sendEmail(ctx, config.ID, msg); err != nil {
+		return nil, fmt.Errorf("failed to send email: %w", err)
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Email from template %q sent successfully to %s (Message-ID: %s)", t.Name, strings.Join(msg.Recipients(), ", "), msg.MessageID),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleDeleteTemplate(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("templates are not available: %w", errNoDatabase)
+	}
+
+	name, _ := args["name"].(string)
+	if name == "" {
+		return nil, fmt.Errorf("%w: name", errMissingParameter)
+	}
+	if err := es.db.DeleteTemplate(name); err != nil {
+		return nil, err
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Template %q deleted", name),
+		}},
+	}, nil
+}
+
+func (es *EmailServer) templateFromArgs(args map[string]interface{}) (*storage.Template, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("templates are not available: %w", errNoDatabase)
+	}
+
+	name, _ := args["name"].(string)
+	if name == "" {
+		return nil, fmt.Errorf("%w: name", errMissingParameter)
+	}
+	return es.db.GetTemplate(name)
+}
+
+// templateVariables converts the variables object of a tool call, accepting
+// numbers and booleans as well as strings
+func templateVariables(v interface{}) map[string]string {
+	vars := make(map[string]string)
+	object, _ := v.(map[string]interface{})
+	for name, value := range object {
+		if value != nil {
+			vars[name] = fmt.Sprint(value)
+		}
+	}
+	return vars
+}
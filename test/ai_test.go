@@ -0,0 +1,1100 @@
+package test
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"email-mcp-server/ai"
+	"email-mcp-server/config"
+	"email-mcp-server/mail"
+)
+
+type fakeProvider struct {
+	calls int
+	reply string
+	err   error
+}
+
+func (p *fakeProvider) Name() string  { return "fake" }
+func (p *fakeProvider) Model() string { return "fake-1" }
+
+func (p *fakeProvider) Complete(ctx context.Context, req ai.Request) (string, error) {
+	p.calls++
+	return p.reply, p.err
+}
+
+var testEmail = ai.Email{
+	From:    "ana@example.com",
+	Subject: "Budget",
+	Body:    "The budget was approved. We start on Monday. Details follow.\n\nOn Fri, Bob wrote:\n> Any news?",
+	Date:    time.Date(2025, 3, 14, 9, 0, 0, 0, time.UTC),
+}
+
+func TestSummarizerCachesLLMResults(t *testing.T) {
+	provider := &fakeProvider{reply: "Budget approved, starting Monday."}
+	s := ai.NewSummarizer(config.DefaultAIConfig(), provider)
+
+	first := s.SummarizeEmail(context.Background(), testEmail)
+	if first.Method != "llm" || first.Text != provider.reply || first.Cached {
+		t.Errorf("unexpected summary: %+v", first)
+	}
+
+	second := s.SummarizeEmail(context.Background(), testEmail)
+	if !second.Cached || provider.calls != 1 {
+		t.Errorf("expected cached summary after 1 call, got %+v after %d calls", second, provider.calls)
+	}
+}
+
+func TestSummarizerFallsBackWithoutProvider(t *testing.T) {
+	cfg := config.DefaultAIConfig()
+	cfg.Provider = "openai"
+	if _, err := ai.NewProvider(cfg); err != ai.ErrNotConfigured {
+		t.Fatalf("NewProvider without key = %v, want ErrNotConfigured", err)
+	}
+
+	for _, provider := range []ai.Provider{nil, &fakeProvider{err: errors.New("timeout")}} {
+		summary := ai.NewSummarizer(cfg, provider).SummarizeEmail(context.Background(), testEmail)
+		if summary.Method != "extractive" || summary.Note == "" {
+			t.Errorf("unexpected summary: %+v", summary)
+		}
+		if !strings.Contains(summary.Text, "We start on Monday.") || strings.Contains(summary.Text, "Any news") {
+			t.Errorf("extractive summary = %q", summary.Text)
+		}
+	}
+}
+
+func TestClassifierHybridPath(t *testing.T) {
+	cfg := config.DefaultAIConfig()
+	cfg.Classification.RateLimitPerMinute = 1
+
+	provider := &fakeProvider{reply: "```json\n{\"category\": \"promotions\", \"confidence\": 0.9, \"reasoning\": \"discount offer\"}\n```"}
+	c := ai.NewClassifier(config.DefaultRules(), cfg, provider)
+
+	// A confident rule match never reaches the LLM
+	invoice, err := c.Classify(context.Background(), ai.Email{From: "billing@shop.com", Subject: "Your invoice #123"})
+	if err != nil || invoice.Category != "invoice" || invoice.Method != ai.MethodRules || provider.calls != 0 {
+		t.Fatalf("invoice = %+v, %v (calls %d)", invoice, err, provider.calls)
+	}
+
+	// "sale" matches the promotions rule below the threshold; the LLM confirms it
+	sale := ai.Email{MessageID: "<sale@shop.com>", From: "news@shop.com", Subject: "Spring sale"}
+	result, err := c.Classify(context.Background(), sale)
+	if err != nil || result.Category != "promotions" || result.Method != ai.MethodHybrid || result.Confidence != 0.9 {
+		t.Fatalf("sale = %+v, %v", result, err)
+	}
+
+	// Cached: no second request even though the rate limit is exhausted
+	if _, err := c.Classify(context.Background(), sale); err != nil || provider.calls != 1 {
+		t.Fatalf("expected cached result, got %v after %d calls", err, provider.calls)
+	}
+
+	// Rate limited: falls back to the rule result
+	other, err := c.Classify(context.Background(), ai.Email{From: "friend@example.com", Subject: "Dinner?"})
+	if err != nil || other.Method != ai.MethodRules || other.Category != ai.DefaultCategory || provider.calls != 1 {
+		t.Fatalf("rate limited = %+v, %v (calls %d)", other, err, provider.calls)
+	}
+
+	if stats := c.GetStats(); stats.Total != 4 || stats.RateLimited != 1 || stats.CacheHits != 1 || stats.CacheMisses != 2 || stats.CacheEntries != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := ai.NewCache[string](2, time.Hour)
+	cache.Put("a", "first")
+	cache.Put("b", "second")
+	if _, ok := cache.Get("a"); !ok {
+		t.Fatal("a should be cached")
+	}
+
+	// b is now the least recently used and makes room for c
+	cache.Put("c", "third")
+	if _, ok := cache.Get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	if v, ok := cache.Get("a"); !ok || v != "first" {
+		t.Errorf("Get(a) = %q, %v", v, ok)
+	}
+	if v, ok := cache.Get("c"); !ok || v != "third" {
+		t.Errorf("Get(c) = %q, %v", v, ok)
+	}
+
+	want := ai.CacheStats{Hits: 3, Misses: 1, Evictions: 1, Entries: 2}
+	if stats := cache.Stats(); stats != want {
+		t.Errorf("Stats = %+v, want %+v", stats, want)
+	}
+
+	// A cache without a TTL stores nothing
+	disabled := ai.NewCache[string](10, 0)
+	disabled.Put("a", "first")
+	if _, ok := disabled.Get("a"); ok || disabled.Stats() != (ai.CacheStats{}) {
+		t.Error("a cache with a TTL of 0 should be disabled")
+	}
+}
+
+func TestClassifyBatchKeepsOrder(t *testing.T) {
+	cfg := config.DefaultAIConfig()
+	cfg.Classification.UseAI = false
+	c := ai.NewClassifier(config.DefaultRules(), cfg, nil)
+
+	emails := make([]ai.Email, 20)
+	for i := range emails {
+		emails[i] = ai.Email{UID: uint32(i), From: "friend@example.com", Subject: "Dinner?"}
+		if i%2 == 0 {
+			emails[i] = ai.Email{UID: uint32(i), From: "billing@shop.com", Subject: "Your invoice #123"}
+		}
+	}
+
+	results, errs := c.ClassifyBatch(context.Background(), emails, 4)
+	for i, result := range results {
+		if errs[i] != nil {
+			t.Fatalf("email %d: %v", i, errs[i])
+		}
+		want := ai.DefaultCategory
+		if i%2 == 0 {
+			want = "invoice"
+		}
+		if result.Category != want {
+			t.Errorf("email %d = %s, want %s", i, result.Category, want)
+		}
+	}
+	if stats := c.GetStats(); stats.Total != len(emails) {
+		t.Errorf("Total = %d, want %d", stats.Total, len(emails))
+	}
+}
+
+func TestClassifierWithoutFallbackReturnsError(t *testing.T) {
+	cfg := config.DefaultAIConfig()
+	cfg.Classification.FallbackToRules = false
+
+	c := ai.NewClassifier(config.DefaultRules(), cfg, &fakeProvider{err: errors.New("unavailable")})
+	if _, err := c.Classify(context.Background(), ai.Email{Subject: "Hello"}); err == nil {
+		t.Error("expected an error when the LLM fails and fallback_to_rules is off")
+	}
+}
+
+func TestClassifierAutomated(t *testing.T) {
+	provider := &fakeProvider{reply: `{"category": "work", "confidence": 0.9}`}
+	c := ai.NewClassifier(config.DefaultRules(), config.DefaultAIConfig(), provider)
+
+	// Bounces are filed as automated without asking the LLM, whatever their subject
+	email := ai.Email{From: "MAILER-DAEMON@example.com", Subject: "Undelivered: your invoice", Automated: "bounce"}
+	result, err := c.Classify(context.Background(), email)
+	if err != nil || result.Category != ai.CategoryAutomated || result.Method != ai.MethodRules || provider.calls != 0 {
+		t.Fatalf("bounce = %+v, %v (calls %d)", result, err, provider.calls)
+	}
+	if !slices.Contains(result.Tags, "bounce") {
+		t.Errorf("expected the kind as a tag, got %v", result.Tags)
+	}
+}
+
+func TestClassifierTagsNeedsReview(t *testing.T) {
+	cfg := config.DefaultAIConfig()
+	cfg.Classification.UseAI = false
+	c := ai.NewClassifier(config.DefaultRules(), cfg, nil)
+
+	// No rule matches, so the default category has no confidence
+	unsure, err := c.Classify(context.Background(), ai.Email{Subject: "Hello"})
+	if err != nil || !slices.Contains(unsure.Tags, ai.TagNeedsReview) {
+		t.Fatalf("expected an unsure result to need review, got %+v, %v", unsure, err)
+	}
+
+	invoice, err := c.Classify(context.Background(), ai.Email{From: "billing@shop.com", Subject: "Your invoice #123"})
+	if err != nil || slices.Contains(invoice.Tags, ai.TagNeedsReview) {
+		t.Fatalf("expected a confident result not to need review, got %+v, %v", invoice, err)
+	}
+
+	cfg.Classification.ReviewThreshold = 0
+	c = ai.NewClassifier(config.DefaultRules(), cfg, nil)
+	if unsure, _ := c.Classify(context.Background(), ai.Email{Subject: "Hello"}); slices.Contains(unsure.Tags, ai.TagNeedsReview) {
+		t.Errorf("expected a zero threshold to disable reviews, got %+v", unsure)
+	}
+}
+
+func TestRateLimiterWaitQueues(t *testing.T) {
+	// 600 per minute refills a token every 100ms
+	l := ai.NewRateLimiter(600)
+	for i := 0; i < 600; i++ {
+		if !l.Allow() {
+			t.Fatalf("burst token %d was refused", i)
+		}
+	}
+
+	start := time.Now()
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("Wait returned after %v with an empty bucket", waited)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Wait(ctx); err != context.Canceled {
+		t.Errorf("Wait with a cancelled context = %v", err)
+	}
+
+	var unlimited *ai.RateLimiter
+	if err := unlimited.Wait(ctx); err != nil {
+		t.Errorf("nil limiter Wait = %v", err)
+	}
+}
+
+func TestReplyGenerator(t *testing.T) {
+	email := ai.Email{From: "Ana Pérez <ana@example.com>", Subject: "Lunch on Friday?", Body: "Shall we have lunch on Friday?"}
+	opts := ai.ReplyOptions{Tone: "formal", Intent: "decline"}
+
+	reply := ai.NewReplyGenerator(config.DefaultAIConfig(), nil).GenerateReply(context.Background(), email, opts)
+	if reply.Method != "template" || reply.To != "ana@example.com" || reply.Subject != "Re: Lunch on Friday?" {
+		t.Errorf("unexpected template reply: %+v", reply)
+	}
+	if !strings.HasPrefix(reply.Body, "Dear Ana,") || !strings.Contains(reply.Body, "decline") {
+		t.Errorf("template body = %q", reply.Body)
+	}
+
+	provider := &fakeProvider{reply: "Thank you, but I can't make it on Friday."}
+	email.Subject = "RE: Lunch on Friday?"
+	reply = ai.NewReplyGenerator(config.DefaultAIConfig(), provider).GenerateReply(context.Background(), email, opts)
+	if reply.Method != "llm" || reply.Body != provider.reply || reply.Subject != email.Subject {
+		t.Errorf("unexpected LLM reply: %+v", reply)
+	}
+}
+
+func TestClassifierLearnsFromFeedback(t *testing.T) {
+	cfg := config.DefaultAIConfig()
+	cfg.Classification.UseAI = false
+	c := ai.NewClassifier(config.DefaultRules(), cfg, nil)
+
+	email := ai.Email{From: "Billing <Billing@Vendor.com>", Subject: "Your invoice for March"}
+	before, _ := c.Classify(context.Background(), email)
+	if before.Category != "invoice" || before.Rule != "invoices" {
+		t.Fatalf("unexpected initial classification: %+v", before)
+	}
+
+	feedback := ai.Feedback{Email: email, Predicted: "invoice", Rule: "invoices", Correct: "work", SenderSamples: 2, RuleMistakes: 2}
+	if update := c.LearnFromFeedback(feedback); update.LearnedSender != nil || update.RuleAdjustment != nil {
+		t.Errorf("learned below min_samples: %+v", update)
+	}
+
+	feedback.SenderSamples, feedback.RuleMistakes = 3, 3
+	update := c.LearnFromFeedback(feedback)
+	if update.LearnedSender == nil || update.LearnedSender.Sender != "billing@vendor.com" {
+		t.Errorf("expected learned sender, got %+v", update)
+	}
+	if update.RuleAdjustment == nil || math.Abs(update.RuleAdjustment.Confidence-0.8) > 1e-9 {
+		t.Errorf("expected rule confidence 0.8, got %+v", update.RuleAdjustment)
+	}
+
+	after, _ := c.Classify(context.Background(), email)
+	if after.Category != "work" || after.Method != ai.MethodLearned {
+		t.Errorf("unexpected classification after learning: %+v", after)
+	}
+}
+
+func TestClassifierTestRules(t *testing.T) {
+	cfg := config.DefaultAIConfig()
+	cfg.Classification.UseAI = false
+	c := ai.NewClassifier(config.DefaultRules(), cfg, nil)
+
+	rules := &config.Rules{
+		VIPSenders: []string{"boss@corp.com"},
+		Classification: []config.ClassificationRule{
+			{
+				Name: "boss", Category: "urgent", Confidence: 0.9,
+				Conditions: []config.Condition{
+					{Field: "from", Operator: "equals", Value: "boss@corp.com"},
+					{Field: "subject", Operator: "contains", Value: "urgent"},
+				},
+			},
+			{
+				Name: "reports", Category: "work", Confidence: 0.7,
+				Conditions: []config.Condition{{Field: "subject", Operator: "starts_with", Value: "report"}},
+			},
+			{
+				Name: "social", Category: "social", Confidence: 0.5,
+				Conditions: []config.Condition{{Field: "from", Operator: "domain", Value: "linkedin.com"}},
+			},
+		},
+	}
+
+	report := c.TestRules(ai.Email{From: "Boss <boss@corp.com>", Subject: "Weekly report"}, rules)
+
+	if len(report.Matched) != 0 || report.Result.Category != ai.DefaultCategory {
+		t.Errorf("expected no match, got %+v", report)
+	}
+	if !report.VIPSender {
+		t.Error("expected the sender to be reported as VIP")
+	}
+	if len(report.NearMisses) != 2 {
+		t.Fatalf("expected 2 near misses, got %+v", report.NearMisses)
+	}
+	for _, miss := range report.NearMisses {
+		if miss.Rule == "social" {
+			t.Errorf("rule with no close condition reported as near miss: %+v", miss)
+		}
+		// equals compares the bare address of "Boss <boss@corp.com>"
+		if miss.Rule == "boss" && (miss.Failed != 1 || !miss.Conditions[0].Matched) {
+			t.Errorf("expected only the boss subject condition to fail, got %+v", miss)
+		}
+	}
+
+	report = c.TestRules(ai.Email{From: "boss@corp.com", Subject: "URGENT: report"}, rules)
+	if len(report.Matched) != 1 || report.Result.Rule != "boss" || report.Result.Confidence != 0.9 {
+		t.Errorf("expected the boss rule to win, got %+v", report)
+	}
+}
+
+func TestClassifierAccountRules(t *testing.T) {
+	cfg := config.DefaultAIConfig()
+	cfg.Classification.UseAI = false
+
+	rules := config.DefaultRules()
+	rules.VIPSenders = []string{"ceo@corp.com"}
+	rules.Accounts = map[string]*config.AccountRules{
+		"personal": {
+			DisabledRules: []string{"promotions"},
+			VIPSenders:    []string{"mum@example.com"},
+		},
+		"work": {
+			Classification: []config.ClassificationRule{{
+				// Replaces the global rule of the same name
+				Name: "invoices", Category: "finance", Confidence: 0.9,
+				Conditions: []config.Condition{{Field: "subject", Operator: "contains", Value: "invoice"}},
+			}},
+		},
+	}
+	if err := rules.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	c := ai.NewClassifier(rules, cfg, nil)
+
+	sale := ai.Email{From: "shop@example.com", Subject: "Summer sale"}
+	if got, _ := c.Classify(context.Background(), sale); got.Category != "promotions" {
+		t.Errorf("global rules: %+v", got)
+	}
+	sale.AccountID = "personal"
+	if got, _ := c.Classify(context.Background(), sale); got.Category != ai.DefaultCategory {
+		t.Errorf("disabled rule still applied: %+v", got)
+	}
+
+	invoice := ai.Email{AccountID: "work", Subject: "Invoice 42"}
+	if got, _ := c.Classify(context.Background(), invoice); got.Category != "finance" || got.Confidence != 0.9 {
+		t.Errorf("account rule did not replace the global one: %+v", got)
+	}
+
+	personal := rules.ForAccount("personal")
+	if len(personal.VIPSenders) != 2 || len(rules.ForAccount("work").VIPSenders) != 1 {
+		t.Errorf("unexpected VIPs: %v", personal.VIPSenders)
+	}
+	if len(personal.Classification) != len(rules.Classification)-1 {
+		t.Errorf("expected one global rule disabled, got %d rules", len(personal.Classification))
+	}
+
+	rules.Accounts["personal"].DisabledRules = []string{"missing"}
+	if err := rules.Validate(); err == nil {
+		t.Error("expected an error for a disabled rule that does not exist")
+	}
+}
+
+func TestClassifierMatchStrategies(t *testing.T) {
+	cfg := config.DefaultAIConfig()
+	cfg.Classification.UseAI = false
+
+	subject := func(value string) []config.Condition {
+		return []config.Condition{{Field: "subject", Operator: "contains", Value: value}}
+	}
+	rules := &config.Rules{
+		Classification: []config.ClassificationRule{
+			{Name: "meeting", Category: "meeting", Confidence: 0.8, Tags: []string{"calendar"}, Conditions: subject("meeting")},
+			{Name: "project", Category: "work", Confidence: 0.6, Tags: []string{"project"}, Conditions: subject("project")},
+			{Name: "team", Category: "work", Confidence: 0.6, Tags: []string{"team"}, Conditions: subject("team")},
+			{Name: "vendor", Category: "support", Confidence: 0.9, Priority: 10, Exclusive: true, Conditions: subject("vendor")},
+		},
+		Accounts: map[string]*config.AccountRules{
+			"first":    {MatchStrategy: config.MatchFirst},
+			"weighted": {MatchStrategy: config.MatchWeighted},
+		},
+	}
+	if err := rules.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	c := ai.NewClassifier(rules, cfg, nil)
+
+	email := ai.Email{Subject: "Project team meeting"}
+	for _, tc := range []struct {
+		account, category string
+		confidence        float64
+		reasoning         string
+	}{
+		{"", "meeting", 0.8, `matched rule "meeting" (best_confidence of 3 matches)`},
+		{"first", "meeting", 0.8, `matched rule "meeting" (first_match, priority 0)`},
+		{"weighted", "work", 0.84, `matched rules "project", "team" (weighted, 2 of 3 matches for work)`},
+	} {
+		email.AccountID = tc.account
+		got := c.ClassifyByRules(email)
+		if got.Category != tc.category || math.Abs(got.Confidence-tc.confidence) > 1e-9 || got.Reasoning != tc.reasoning {
+			t.Errorf("%s: %+v", cmp.Or(tc.account, "default"), got)
+		}
+	}
+	email.AccountID = "weighted"
+	if got := c.ClassifyByRules(email); got.Rule != "project" || !slices.Equal(got.Tags, []string{"project", "team"}) {
+		t.Errorf("weighted rule and tags: %+v", got)
+	}
+
+	// The exclusive rule has the highest priority, so no other rule is tried
+	vendor := ai.Email{Subject: "Vendor meeting"}
+	if got := c.ClassifyByRules(vendor); got.Category != "support" ||
+		got.Reasoning != `matched rule "vendor" (best_confidence, exclusive rule "vendor" ended the evaluation)` {
+		t.Errorf("exclusive: %+v", got)
+	}
+
+	rules.MatchStrategy = "random"
+	if err := rules.Validate(); err == nil {
+		t.Error("Validate accepted an unknown match_strategy")
+	}
+}
+
+func TestActionRules(t *testing.T) {
+	rules := config.DefaultRules()
+	rules.Actions = []config.ActionRule{
+		{Name: "old-promotions", Category: "promotions", OlderThanDays: 30, Action: config.ActionArchive},
+		{Name: "star-vips", VIP: true, Action: config.ActionStar},
+	}
+	rules.Accounts = map[string]*config.AccountRules{
+		"work": {
+			DisabledRules: []string{"star-vips"},
+			Actions: []config.ActionRule{{
+				Name: "receipts", Action: config.ActionMoveTo, Destination: "Receipts", DryRun: true,
+				Conditions: []config.Condition{{Field: "subject", Operator: "contains", Value: "receipt"}},
+			}},
+		},
+	}
+	if err := rules.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	if got := rules.ForAccount("home").Actions; len(got) != 2 {
+		t.Errorf("home actions = %+v", got)
+	}
+	work := rules.ForAccount("work").Actions
+	if len(work) != 2 || work[0].Name != "old-promotions" || work[1].Name != "receipts" {
+		t.Errorf("work actions = %+v", work)
+	}
+
+	receipt := ai.Email{Subject: "Your receipt"}
+	if !ai.MatchesConditions(work[1].Conditions, receipt) || ai.MatchesConditions(work[1].Conditions, ai.Email{Subject: "Hello"}) {
+		t.Error("MatchesConditions does not follow the rule conditions")
+	}
+
+	for _, invalid := range []config.ActionRule{
+		{Name: "no-criteria", Action: config.ActionArchive},
+		{Name: "no-destination", VIP: true, Action: config.ActionMoveTo},
+		{Name: "unknown", VIP: true, Action: "forward"},
+		{VIP: true, Action: config.ActionStar},
+	} {
+		bad := &config.Rules{Actions: []config.ActionRule{invalid}}
+		if err := bad.Validate(); err == nil {
+			t.Errorf("Validate accepted %+v", invalid)
+		}
+	}
+}
+
+func TestIgnoredMail(t *testing.T) {
+	rules := config.DefaultRules()
+	rules.IgnoredSenders = []string{"deals@shop.example", "marketing.example.com", "@lists.example.org"}
+	rules.IgnoredSubjects = []string{"Weekly Digest"}
+	rules.AutoArchiveIgnored = true
+	off := false
+	rules.Accounts = map[string]*config.AccountRules{
+		"work": {IgnoredSenders: []string{"alerts@ci.example"}, AutoArchiveIgnored: &off},
+	}
+	if err := rules.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	home := rules.ForAccount("home")
+	for _, tc := range []struct {
+		from, subject string
+		want          bool
+	}{
+		{"Deals <DEALS@shop.example>", "Sale", true},
+		{"news@marketing.example.com", "Hello", true},
+		{"news@eu.marketing.example.com", "Hello", true},
+		{"bob@lists.example.org", "Hello", true},
+		{"ana@example.com", "Your weekly digest", true},
+		{"ana@example.com", "Budget", false},
+		{"alerts@ci.example", "Build failed", false},
+		{"other@shop.example", "Sale", false},
+	} {
+		sender, _ := mail.ParseAddress(tc.from)
+		if got := home.IsIgnored(sender, tc.subject); got != tc.want {
+			t.Errorf("IsIgnored(%q, %q) = %v, want %v", tc.from, tc.subject, got, tc.want)
+		}
+	}
+	if last := home.Actions[len(home.Actions)-1]; last.Name != config.AutoArchiveRule || !last.Ignored || !last.MarkRead || last.Action != config.ActionArchive {
+		t.Errorf("home actions = %+v", home.Actions)
+	}
+
+	work := rules.ForAccount("work")
+	if sender, _ := mail.ParseAddress("alerts@ci.example"); !work.IsIgnored(sender, "Build failed") {
+		t.Error("account ignored_senders are not merged")
+	}
+	for _, rule := range work.Actions {
+		if rule.Name == config.AutoArchiveRule {
+			t.Error("auto_archive_ignored is not turned off for the account")
+		}
+	}
+
+	for _, tc := range []struct {
+		rule  config.ActionRule
+		valid bool
+	}{
+		{config.ActionRule{Name: "ignored", Ignored: true, Action: config.ActionMoveTo, Destination: "Ignored", MarkRead: true}, true},
+		{config.ActionRule{Name: config.AutoArchiveRule, Ignored: true, Action: config.ActionArchive}, false},
+		{config.ActionRule{Name: "delete-read", Ignored: true, Action: config.ActionDelete, MarkRead: true}, false},
+	} {
+		r := &config.Rules{Actions: []config.ActionRule{tc.rule}}
+		if err := r.Validate(); (err == nil) != tc.valid {
+			t.Errorf("Validate(%+v) = %v", tc.rule, err)
+		}
+	}
+}
+
+func TestDetectDeadlines(t *testing.T) {
+	now := time.Date(2025, 3, 12, 10, 0, 0, 0, time.UTC) // a Wednesday
+
+	text := "Please send the slides by Friday and the budget by tomorrow.\n" +
+		"The report is due March 17, deadline: 2025-03-20. Also, by Friday again.\n" +
+		"The old form was due before 2025-03-01."
+	deadlines := ai.DetectDeadlines(text, now)
+
+	want := []string{"2025-03-13", "2025-03-14", "2025-03-17", "2025-03-20"}
+	if len(deadlines) != len(want) {
+		t.Fatalf("expected %d deadlines, got %+v", len(want), deadlines)
+	}
+	for i, day := range want {
+		if got := deadlines[i].Due.Format("2006-01-02"); got != day {
+			t.Errorf("deadline %d: expected %s, got %s (%q)", i, day, got, deadlines[i].Phrase)
+		}
+	}
+
+	if d := ai.DetectDeadlines("Necesito el informe antes del viernes", now); len(d) != 1 || d[0].Due.Day() != 14 {
+		t.Errorf("expected Spanish weekday to resolve to Friday 14, got %+v", d)
+	}
+	if d := ai.DetectDeadlines("We met on Friday", now); len(d) != 0 {
+		t.Errorf("expected no deadline without a trigger word, got %+v", d)
+	}
+	if d := ai.DetectDeadlines("Need this EOD tomorrow, thanks", now); len(d) != 1 || d[0].Due.Day() != 13 || d[0].Phrase != "EOD tomorrow" {
+		t.Errorf("expected EOD tomorrow to resolve to the 13th, got %+v", d)
+	}
+	if d := ai.DetectDeadlines("Please review by end of day Friday", now); len(d) != 1 || d[0].Due.Day() != 14 {
+		t.Errorf("expected end of day Friday to resolve to the 14th, got %+v", d)
+	}
+}
+
+func TestDeadlinePoints(t *testing.T) {
+	now := time.Date(2025, 3, 12, 10, 0, 0, 0, time.UTC)
+	cases := map[time.Duration]int{
+		-time.Hour:          0,
+		3 * time.Hour:       25,
+		48 * time.Hour:      15,
+		5 * 24 * time.Hour:  5,
+		10 * 24 * time.Hour: 0,
+	}
+	for until, want := range cases {
+		if got := ai.DeadlinePoints(now.Add(until), now); got != want {
+			t.Errorf("due in %v: expected %d points, got %d", until, want, got)
+		}
+	}
+
+	// "by Friday" in Monday's email is this Friday, now only hours away
+	email := ai.Email{Subject: "Slides", Body: "Send them by Friday.", Date: time.Date(2025, 3, 10, 9, 0, 0, 0, time.UTC)}
+	friday := time.Date(2025, 3, 14, 15, 0, 0, 0, time.UTC)
+	if p := ai.ScorePriority(email, nil, ai.PrioritySignals{}, friday); p.Factors["deadline"] != 25 {
+		t.Errorf("expected the deadline to add 25 on the day, got %+v", p)
+	}
+}
+
+func TestFollowUpPromise(t *testing.T) {
+	cases := map[string]string{
+		"Thanks Ana. I'll get back to you by Friday.\n> Can you confirm?": "I'll get back to you by Friday",
+		"Hola, lo reviso y te digo algo mañana.":                          "lo reviso y te digo algo mañana",
+		"Booked. See you there!":                                          "",
+		"Done.\n> I'll get back to you":                                   "",
+	}
+	for body, want := range cases {
+		if got := ai.FollowUpPromise(body); got != want {
+			t.Errorf("FollowUpPromise(%q) = %q, want %q", body, got, want)
+		}
+	}
+}
+
+func TestScoreThread(t *testing.T) {
+	now := time.Date(2025, 3, 12, 10, 0, 0, 0, time.UTC)
+	busy := ai.ThreadActivity{Messages: 6, Unread: 2, MaxScore: 80, MeanScore: 50, LastActivity: now.Add(-time.Hour)}
+	// 80*0.7 + 50*0.3 + 2 unread * 3 + 5 for a long thread
+	if got := ai.ScoreThread(busy, now); got != 82 {
+		t.Errorf("expected 82 for a busy thread, got %d", got)
+	}
+
+	quiet := busy
+	quiet.LastActivity = now.AddDate(0, 0, -20)
+	if got := ai.ScoreThread(quiet, now); got != 62 {
+		t.Errorf("expected a thread quiet for 20 days to lose 20 points, got %d", got)
+	}
+
+	if got := ai.ScoreThread(ai.ThreadActivity{Messages: 1, LastActivity: now.AddDate(0, 0, -30)}, now); got != 0 {
+		t.Errorf("expected scores to stop at 0, got %d", got)
+	}
+}
+
+func TestExtractActionItems(t *testing.T) {
+	email := ai.Email{
+		Folder:  "INBOX",
+		UID:     7,
+		From:    "Ana <ana@example.com>",
+		To:      []string{"bob@example.com"},
+		Subject: "Launch",
+		Date:    time.Date(2025, 3, 12, 9, 0, 0, 0, time.UTC), // a Wednesday
+		Body: "Hi Bob,\n\nPlease find the plan attached. Could you review the budget by Friday?\n" +
+			"Carla, please book the room. I'll send the agenda tomorrow.\n\n" +
+			"Next steps:\n- Update the landing page\n- Ping @dave about the logo\n\nThanks!\n\n" +
+			"On Tue, Bob wrote:\n> Please advise",
+	}
+
+	items := ai.ExtractActionItems([]ai.Email{email})
+	want := []struct{ action, owner string }{
+		{"Could you review the budget by Friday", "bob@example.com"},
+		{"Carla, please book the room", "Carla"},
+		{"I'll send the agenda tomorrow", "ana@example.com"},
+		{"Update the landing page", "bob@example.com"},
+		{"Ping @dave about the logo", "dave"},
+	}
+	if len(items) != len(want) {
+		t.Fatalf("expected %d action items, got %+v", len(want), items)
+	}
+	for i, w := range want {
+		if items[i].Action != w.action || items[i].Owner != w.owner || items[i].UID != 7 {
+			t.Errorf("item %d: expected %q owned by %s, got %+v", i, w.action, w.owner, items[i])
+		}
+	}
+	if items[0].Due == nil || items[0].Due.Day() != 14 {
+		t.Errorf("expected the review to be due Friday 14, got %v", items[0].Due)
+	}
+
+	provider := &fakeProvider{reply: `{"action_items": [{"message": 1, "action": "Review the budget", "owner": "Bob", "due": "2025-03-14"}]}`}
+	result := ai.NewActionExtractor(config.DefaultAIConfig(), provider).Extract(context.Background(), []ai.Email{email})
+	if result.Method != "llm" || len(result.Items) != 1 || result.Items[0].UID != 7 || result.Items[0].Due == nil {
+		t.Errorf("unexpected LLM action items: %+v", result)
+	}
+
+	provider = &fakeProvider{err: errors.New("timeout")}
+	result = ai.NewActionExtractor(config.DefaultAIConfig(), provider).Extract(context.Background(), []ai.Email{email})
+	if result.Method != "rules" || len(result.Items) != len(want) || result.Note == "" {
+		t.Errorf("expected a rule-based fallback, got %+v", result)
+	}
+}
+
+func TestExtractInvoice(t *testing.T) {
+	sent := time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		email    ai.Email
+		vendor   string
+		number   string
+		amount   float64
+		currency string
+		due      string
+	}{
+		{
+			name: "english",
+			email: ai.Email{From: "Acme Billing <billing@acme.com>", Subject: "Invoice #INV-2025-031", Date: sent,
+				Body: "Subtotal: $1,200.00\nTax: $252.00\nTotal due: $1,452.00\nPayment due by March 31, 2025."},
+			vendor: "Acme Billing", number: "INV-2025-031", amount: 1452, currency: "USD", due: "2025-03-31",
+		},
+		{
+			name: "spanish",
+			email: ai.Email{From: "facturas@luzyagua.es", Subject: "Su factura nº F-0042", Date: sent,
+				Body: "Importe total: 1.234,56 €\nFecha de vencimiento: 15/04/2025"},
+			vendor: "luzyagua.es", number: "F-0042", amount: 1234.56, currency: "EUR", due: "2025-04-15",
+		},
+		{
+			name: "largest amount and relative due date",
+			email: ai.Email{From: "Hosting <no-reply@host.io>", Subject: "Your bill", Date: sent,
+				Body: "Server: EUR 20\nBackups: EUR 4,50\nPlease pay by Friday."},
+			vendor: "Hosting", amount: 20, currency: "EUR", due: "2025-03-07",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invoice := ai.ExtractInvoice(tt.email)
+			if invoice.Vendor != tt.vendor || invoice.Number != tt.number || invoice.Amount != tt.amount ||
+				invoice.Currency != tt.currency || invoice.Method != "rules" {
+				t.Errorf("unexpected invoice: %+v", invoice)
+			}
+			if invoice.Due == nil || invoice.Due.Format("2006-01-02") != tt.due {
+				t.Errorf("due = %v, want %s", invoice.Due, tt.due)
+			}
+		})
+	}
+
+	if invoice := ai.ExtractInvoice(ai.Email{From: "a@b.com", Subject: "Invoice", Body: "See attached."}); invoice.Amount != 0 || invoice.Due != nil {
+		t.Errorf("expected no amount or due date, got %+v", invoice)
+	}
+
+	cfg := config.DefaultAIConfig()
+	email := tests[0].email
+	provider := &fakeProvider{reply: `{"vendor": "Acme Inc.", "number": "INV-2025-031", "amount": 1452, "currency": "usd", "due": "2025-03-31"}`}
+	if invoice := ai.NewInvoiceExtractor(cfg, provider).Extract(context.Background(), email); invoice.Method != "rules" || provider.calls != 0 {
+		t.Errorf("expected patterns without invoices.use_ai, got %+v", invoice)
+	}
+	cfg.Invoices.UseAI = true
+	invoice := ai.NewInvoiceExtractor(cfg, provider).Extract(context.Background(), email)
+	if invoice.Method != "llm" || invoice.Vendor != "Acme Inc." || invoice.Currency != "USD" || invoice.Due == nil {
+		t.Errorf("unexpected LLM invoice: %+v", invoice)
+	}
+	provider = &fakeProvider{err: errors.New("timeout")}
+	if invoice := ai.NewInvoiceExtractor(cfg, provider).Extract(context.Background(), email); invoice.Method != "rules" || invoice.Amount != 1452 {
+		t.Errorf("expected a pattern fallback, got %+v", invoice)
+	}
+}
+
+func TestExtractOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		email ai.Email
+		want  ai.Order
+	}{
+		{
+			name: "confirmation",
+			email: ai.Email{From: "Gadget Store <orders@gadgets.com>", Subject: "Your order #112-3456789 has been received",
+				Body: "Thanks for shopping with us.\nItems: $45.00\nShipping: $5.00\nOrder total: $50.00"},
+			want: ai.Order{Merchant: "Gadget Store", Number: "112-3456789", Status: ai.OrderPlaced, Total: 50, Currency: "USD"},
+		},
+		{
+			name: "shipped with a carrier link",
+			email: ai.Email{From: "Gadget Store <orders@gadgets.com>", Subject: "Your order #112-3456789 has shipped",
+				Body: "Tracking number: 1Z999AA10123456784\nTrack it at https://www.ups.com/track?tracknum=1Z999AA10123456784."},
+			want: ai.Order{Merchant: "Gadget Store", Number: "112-3456789", Status: ai.OrderShipped, Carrier: "UPS",
+				TrackingNumber: "1Z999AA10123456784", TrackingURL: "https://www.ups.com/track?tracknum=1Z999AA10123456784"},
+		},
+		{
+			name: "spanish delivery",
+			email: ai.Email{From: "pedidos@tiendaonline.es", Subject: "Tu pedido nº 55671 ha sido entregado",
+				Body: "Tu pedido ha sido entregado por SEUR: https://www.seur.com/livetracking/?segOnlineIdentificador=ES998877"},
+			want: ai.Order{Merchant: "tiendaonline.es", Number: "55671", Status: ai.OrderDelivered, Carrier: "SEUR",
+				TrackingURL: "https://www.seur.com/livetracking/?segOnlineIdentificador=ES998877"},
+		},
+		{
+			name:  "cancelled",
+			email: ai.Email{From: "Shop <no-reply@shop.com>", Subject: "Order A77812 cancelled", Body: "Your refund of 19,99 € is on its way."},
+			want:  ai.Order{Merchant: "Shop", Number: "A77812", Status: ai.OrderCancelled, Total: 19.99, Currency: "EUR"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if order := ai.ExtractOrder(tt.email); *order != tt.want {
+				t.Errorf("ExtractOrder = %+v, want %+v", *order, tt.want)
+			}
+		})
+	}
+
+	// Links to the shop itself are not tracking links
+	order := ai.ExtractOrder(ai.Email{From: "shop@shop.com", Subject: "Your order", Body: "Visit https://shop.com/account to see it."})
+	if order.TrackingURL != "" || order.Number != "" {
+		t.Errorf("expected no tracking or number, got %+v", order)
+	}
+}
+
+func TestExtractTrips(t *testing.T) {
+	sent := time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC)
+	email := ai.Email{From: "Iberia <noreply@iberia.com>", Subject: "Your booking confirmation - Booking reference: X7K2PQ", Date: sent,
+		Body: "Outbound\nFlight IB 3101 Madrid (MAD) → Barcelona (BCN)\nDeparture: 12 March 2025 08:45\n\n" +
+			"Return\nIB3112 BCN-MAD\nFecha: 16/03/2025 Hora: 19:30\n\nFlight IB 3101 is operated by Iberia Regional."}
+	trips := ai.ExtractTrips(email)
+	if len(trips) != 2 {
+		t.Fatalf("expected 2 trips, got %+v", trips)
+	}
+	outbound, inbound := trips[0], trips[1]
+	if outbound.FlightNumber != "IB3101" || outbound.Airline != "Iberia" || outbound.Origin != "MAD" || outbound.Destination != "BCN" ||
+		outbound.BookingReference != "X7K2PQ" || outbound.Departure == nil || !outbound.Departure.Equal(time.Date(2025, 3, 12, 8, 45, 0, 0, time.UTC)) {
+		t.Errorf("unexpected outbound flight: %+v", outbound)
+	}
+	if inbound.FlightNumber != "IB3112" || inbound.Origin != "BCN" || inbound.Destination != "MAD" ||
+		inbound.Departure == nil || !inbound.Departure.Equal(time.Date(2025, 3, 16, 19, 30, 0, 0, time.UTC)) {
+		t.Errorf("unexpected return flight: %+v", inbound)
+	}
+
+	vueling := ai.ExtractTrips(ai.Email{From: "Vueling <info@vueling.com>", Subject: "Tarjeta de embarque", Date: sent,
+		Body: "Vuelo: VY1234\nMAD a LIS\n20/04/2025 07:10"})
+	if len(vueling) != 1 || vueling[0].Airline != "Vueling" || vueling[0].Origin != "MAD" || vueling[0].Destination != "LIS" || vueling[0].Departure == nil {
+		t.Errorf("unexpected Vueling trip: %+v", vueling)
+	}
+
+	if trips := ai.ExtractTrips(ai.Email{From: "deals@travel.com", Subject: "Flight deals to Paris", Body: "Fly from 29 €"}); len(trips) != 0 {
+		t.Errorf("expected no trips, got %+v", trips)
+	}
+}
+
+func TestNeedsReply(t *testing.T) {
+	cases := []struct {
+		email    ai.Email
+		category string
+		want     bool
+	}{
+		{ai.Email{From: "ana@example.com", Subject: "Lunch", Body: "Are you free on Thursday?"}, "", true},
+		{ai.Email{From: "ana@example.com", Subject: "Slides", Body: "Could you review the deck?"}, "work", true},
+		{ai.Email{From: "ana@example.com", Subject: "FYI", Body: "Done.\n\nOn Fri, Bob wrote:\n> Can you check?"}, "", false},
+		{ai.Email{From: "no-reply@shop.com", Subject: "Rate us?", Body: "How did we do?"}, "", false},
+		{ai.Email{From: "news@blog.com", Subject: "Weekly", Body: "What do you think?"}, "newsletter", false},
+		{ai.Email{From: "ana@example.com", Subject: "Out of office", Body: "Can it wait until Monday?", Automated: "autoreply"}, "", false},
+	}
+	for i, c := range cases {
+		if got := ai.NeedsReply(c.email, c.category); got != c.want {
+			t.Errorf("case %d: expected %v, got %v", i, c.want, got)
+		}
+	}
+}
+
+func TestScorePriority(t *testing.T) {
+	now := time.Date(2025, 3, 12, 10, 0, 0, 0, time.UTC)
+
+	email := ai.Email{From: "boss@corp.com", Subject: "URGENT: contract", Body: "Can you sign it by tomorrow?"}
+	p := ai.ScorePriority(email, &ai.Classification{Category: "work"}, ai.PrioritySignals{VIP: true}, now)
+	if p.Score != 100 || p.Level != ai.PriorityCritical {
+		t.Errorf("expected a capped critical score, got %+v", p)
+	}
+	if reasons := p.Reasons(); len(reasons) != 4 || reasons[0] != "vip sender +30" {
+		t.Errorf("unexpected reasons: %v", reasons)
+	}
+
+	news := ai.Email{From: "news@blog.com", Subject: "Weekly digest", Body: "Our latest posts."}
+	p = ai.ScorePriority(news, &ai.Classification{Category: "newsletter"}, ai.PrioritySignals{}, now)
+	if p.Score != 10 || p.Level != ai.PriorityMinimal {
+		t.Errorf("expected a newsletter to score 10, got %+v", p)
+	}
+
+	p = ai.ScorePriority(news, nil, ai.PrioritySignals{SenderHistory: 5}, now)
+	if p.Factors["frequent_contact"] != 10 || p.Score != 40 {
+		t.Errorf("expected a frequent sender to add 10, got %+v", p)
+	}
+
+	p = ai.ScorePriority(email, &ai.Classification{Category: "spam"}, ai.PrioritySignals{VIP: true}, now)
+	if p.Score != 0 {
+		t.Errorf("expected spam to score 0, got %+v", p)
+	}
+
+	signed := ai.Email{From: "legal@corp.com", Subject: "Documents", Attachments: []mail.Attachment{
+		{Filename: "logo.png", ContentType: "image/png"},
+		{Filename: "Signed_Contract-2025.PDF", ContentType: "application/octet-stream"},
+	}}
+	p = ai.ScorePriority(signed, nil, ai.PrioritySignals{}, now)
+	if p.Factors["document"] != 15 || p.Score != 45 {
+		t.Errorf("expected a contract to add 15, got %+v", p)
+	}
+	if name := ai.ImportantDocument(signed.Attachments); name != "Signed_Contract-2025.PDF" {
+		t.Errorf("ImportantDocument = %q", name)
+	}
+	if name := ai.ImportantDocument([]mail.Attachment{{Filename: "invoice.zip"}, {Filename: "holidays.pdf"}}); name != "" {
+		t.Errorf("expected archives and other documents to be ignored, got %q", name)
+	}
+}
+
+func TestScoreKeywords(t *testing.T) {
+	keywords := []config.Keyword{
+		{Phrase: "urgent", Weight: 20},
+		{Phrase: "action required", Weight: 20},
+		{Phrase: "reply today", Weight: 16, Within: 2},
+		{Phrase: "not urgent", Weight: -30, Within: 1},
+	}
+	for _, tc := range []struct {
+		subject, body string
+		want          int
+	}{
+		{"URGENT: contract", "", 20},
+		{"Urgently needed", "", 0},
+		{"Weekly news", "An urgent update from our blog", 10},
+		{"Urgent: action required", "", 30},
+		{"Please reply by today", "", 16},
+		{"Please reply to me by end of today", "", 0},
+		{"Not very urgent", "", -30},
+		{"Not urgent, but urgent for Ana", "", -10},
+		{"Urgent, action required, reply today", "Urgent!", 34},
+	} {
+		if got, _ := ai.ScoreKeywords(tc.subject, tc.body, keywords); got != tc.want {
+			t.Errorf("ScoreKeywords(%q, %q) = %d, want %d", tc.subject, tc.body, got, tc.want)
+		}
+	}
+	if got, found := ai.ScoreKeywords("Action required", "", keywords, 25); got != 35 || !slices.Equal(found, []string{"action required"}) {
+		t.Errorf("ScoreKeywords with a tag = %d %v", got, found)
+	}
+	if got, _ := ai.ScoreKeywords("Urgent", "", keywords, 50); got != 40 {
+		t.Errorf("ScoreKeywords = %d, want the cap of 40", got)
+	}
+
+	now := time.Date(2025, 3, 12, 10, 0, 0, 0, time.UTC)
+	calm := ai.Email{From: "ana@example.com", Subject: "Not urgent: lunch"}
+	if p := ai.ScorePriority(calm, nil, ai.PrioritySignals{}, now); p.Factors["not_urgent"] != -30 || p.Factors["urgent"] != 0 {
+		t.Errorf("expected not urgent to outweigh urgent, got %+v", p)
+	}
+
+	rules := &config.Rules{Accounts: map[string]*config.AccountRules{
+		"work": {UrgencyKeywords: []config.Keyword{{Phrase: "Urgent", Weight: 10}}},
+	}}
+	work := rules.ForAccount("work").UrgencyKeywords
+	if len(work) != len(config.DefaultKeywords) || work[len(work)-1].Weight != 10 {
+		t.Errorf("work keywords = %+v", work)
+	}
+	for _, invalid := range []config.Keyword{{Phrase: "!!", Weight: 5}, {Phrase: "urgent"}, {Phrase: "urgent", Weight: 5, Within: 2}} {
+		bad := &config.Rules{UrgencyKeywords: []config.Keyword{invalid}}
+		if err := bad.Validate(); err == nil {
+			t.Errorf("Validate accepted %+v", invalid)
+		}
+	}
+}
+
+func TestRegexConditions(t *testing.T) {
+	re, err := config.CompileRegex(`(?i)^invoice #\d+`)
+	if err != nil {
+		t.Fatalf("CompileRegex: %v", err)
+	}
+	if again, _ := config.CompileRegex(`(?i)^invoice #\d+`); again != re {
+		t.Error("CompileRegex does not cache compiled patterns")
+	}
+
+	for _, pattern := range []string{`(unclosed`, strings.Repeat("a", 1001), `(?:foo|bar){1000}`} {
+		rules := &config.Rules{Classification: []config.ClassificationRule{{
+			Name: "bad", Category: "work", Confidence: 0.9,
+			Conditions: []config.Condition{{Field: "subject", Operator: "regex", Value: pattern}},
+		}}}
+		if err := rules.Validate(); err == nil {
+			t.Errorf("Validate accepted the regex %.40q", pattern)
+		}
+	}
+
+	cond := []config.Condition{{Field: "body", Operator: "regex", Value: `needle$`}}
+	if !ai.MatchesConditions(cond, ai.Email{Body: "a needle"}) {
+		t.Error("regex condition does not match")
+	}
+	if ai.MatchesConditions(cond, ai.Email{Body: strings.Repeat("x", 70*1024) + "needle"}) {
+		t.Error("regex condition matched past the input limit")
+	}
+}
+
+func TestAttachmentConditions(t *testing.T) {
+	cfg := config.DefaultAIConfig()
+	cfg.Classification.UseAI = false
+	c := ai.NewClassifier(config.DefaultRules(), cfg, nil)
+
+	invoice := ai.Email{From: "billing@shop.com", Subject: "Your order", Attachments: []mail.Attachment{
+		{Filename: "Factura-0042.pdf", ContentType: "application/pdf"},
+	}}
+	result, err := c.Classify(context.Background(), invoice)
+	if err != nil || result.Category != "invoice" || result.Rule != "invoice_attachments" {
+		t.Errorf("expected the invoice attachment rule to match, got %+v, %v", result, err)
+	}
+
+	conditions := []config.Condition{
+		{Field: "has_attachment", Operator: "equals", Value: "true"},
+		{Field: "attachment_type", Operator: "equals", Value: "application/pdf"},
+	}
+	if !ai.MatchesConditions(conditions, invoice) {
+		t.Error("expected a PDF attachment to match")
+	}
+	if ai.MatchesConditions(conditions, ai.Email{Subject: "Your order"}) {
+		t.Error("expected an email without attachments not to match")
+	}
+	none := []config.Condition{{Field: "has_attachment", Operator: "equals", Value: "false"}}
+	if !ai.MatchesConditions(none, ai.Email{Subject: "Hello"}) || ai.MatchesConditions(none, invoice) {
+		t.Error("has_attachment false does not follow the attachments")
+	}
+
+	rules := &config.Rules{Classification: []config.ClassificationRule{{
+		Name: "contracts", Category: "work", Confidence: 0.8,
+		Conditions: []config.Condition{
+			{Field: "subject", Operator: "contains", Value: "contract"},
+			{Field: "attachment_name", Operator: "contains", Value: "contract"},
+		},
+	}}}
+	if err := rules.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	report := c.TestRules(ai.Email{Subject: "Contract"}, rules)
+	if len(report.NearMisses) != 1 || report.NearMisses[0].Conditions[1].Reason != "the email has no attachments" {
+		t.Errorf("expected the missing attachment to be explained, got %+v", report.NearMisses)
+	}
+
+	for _, invalid := range []config.Condition{
+		{Field: "has_attachment", Operator: "contains", Value: "true"},
+		{Field: "has_attachment", Operator: "equals", Value: "yes"},
+	} {
+		bad := &config.Rules{Classification: []config.ClassificationRule{{Name: "bad", Category: "work", Confidence: 0.5, Conditions: []config.Condition{invalid}}}}
+		if err := bad.Validate(); err == nil {
+			t.Errorf("Validate accepted %+v", invalid)
+		}
+	}
+}
+
+func TestCheckPhishing(t *testing.T) {
+	legit := ai.PhishingInput{
+		Headers: map[string][]string{
+			"From":                   {"PayPal <service@paypal.com>"},
+			"Authentication-Results": {"mx.example.com; spf=pass smtp.mailfrom=paypal.com; dkim=fail header.d=old.paypal.com; dkim=pass header.d=paypal.com; dmarc=pass header.from=paypal.com"},
+		},
+		HTMLBody: `<p>Your receipt: <a href="https://www.paypal.com/activity">www.paypal.com</a></p>`,
+	}
+	report := ai.CheckPhishing(legit)
+	if report.Score != 0 || report.Level != ai.RiskNone || report.DKIM != "pass" || report.SPF != "pass" {
+		t.Errorf("expected a clean report, got %+v", report)
+	}
+
+	phish := ai.PhishingInput{
+		Headers: map[string][]string{
+			"From":                   {`"PayPal Security" <alert@secure-mail.xyz>`},
+			"Reply-To":               {"paypal.help@gmail.com"},
+			"Subject":                {"Action required: verify your account"},
+			"Authentication-Results": {"mx.example.com; spf=softfail smtp.mailfrom=secure-mail.xyz; dmarc=fail header.from=secure-mail.xyz"},
+		},
+		HTMLBody: `<a href="http://paypal.com.account-check.top/login">https://www.paypal.com/signin</a>`,
+		TextBody: "Or go to http://192.168.10.5/verify now.",
+	}
+	report = ai.CheckPhishing(phish)
+	if report.Score != 100 || report.Level != ai.RiskHigh {
+		t.Errorf("expected a capped high risk, got %+v", report)
+	}
+	checks := make(map[string]int)
+	for _, finding := range report.Findings {
+		checks[finding.Check] = finding.Points
+	}
+	for check, points := range map[string]int{
+		"dmarc": 30, "spf": 10, "brand_impersonation": 25, "reply_to_mismatch": 25, "link_text_mismatch": 30,
+		"lookalike_url": 25, "suspicious_tld": 10, "ip_url": 20, "credential_request": 15,
+	} {
+		if checks[check] != points {
+			t.Errorf("expected %s worth %d, got %d (%+v)", check, points, checks[check], report.Findings)
+		}
+	}
+	if report.Findings[0].Points < report.Findings[len(report.Findings)-1].Points {
+		t.Error("expected findings sorted by points")
+	}
+
+	spoof := ai.PhishingInput{Headers: map[string][]string{
+		"From":     {`"ceo@corp.com" <ceo.corp@mailer.net>`},
+		"Reply-To": {"billing@mail.corp.co.uk"},
+	}}
+	report = ai.CheckPhishing(spoof)
+	if len(report.Findings) != 2 || report.Findings[0].Check != "display_name_spoof" || report.Level != ai.RiskMedium {
+		t.Errorf("expected display name spoofing and a Reply-To mismatch, got %+v", report)
+	}
+
+	family := ai.PhishingInput{Headers: map[string][]string{"From": {"Microsoft Outlook <no-reply@microsoft.com>"}}}
+	if report = ai.CheckPhishing(family); report.Score != 0 {
+		t.Errorf("expected a brand's own product name to pass, got %+v", report)
+	}
+}
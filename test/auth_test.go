@@ -0,0 +1,135 @@
+package test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"net/smtp"
+	"testing"
+	"unicode/utf16"
+
+	"email-mcp-server/auth"
+)
+
+func TestAuthMechanisms(t *testing.T) {
+	// RFC 2195 example exchange
+	cram, _ := auth.New("cram-md5", "tim", "tanstaaftanstaaf")
+	if mech, ir, _ := cram.Start(); mech != auth.CRAMMD5 || ir != nil {
+		t.Errorf("CRAM-MD5 Start = %q, %q", mech, ir)
+	}
+	resp, err := cram.Next([]byte("<1896.697170952@postoffice.reston.mci.net>"))
+	if err != nil || string(resp) != "tim b913a602c7eda7a495b4e6e7334d3890" {
+		t.Errorf("CRAM-MD5 response = %q, %v", resp, err)
+	}
+
+	login, _ := auth.New(auth.Login, "ana", "secret")
+	if mech, ir, _ := login.Start(); mech != auth.Login || ir != nil {
+		t.Errorf("LOGIN Start = %q, %q", mech, ir)
+	}
+	if resp, _ := login.Next([]byte("Username:")); string(resp) != "ana" {
+		t.Errorf("LOGIN username = %q", resp)
+	}
+	if resp, _ := login.Next([]byte("password:")); string(resp) != "secret" {
+		t.Errorf("LOGIN password = %q", resp)
+	}
+
+	plain, _ := auth.New(auth.Plain, "ana", "secret")
+	if _, ir, _ := plain.Start(); string(ir) != "\x00ana\x00secret" {
+		t.Errorf("PLAIN initial response = %q", ir)
+	}
+
+	if mech, err := auth.Normalize(""); mech != auth.Auto || err != nil {
+		t.Errorf("Normalize(\"\") = %q, %v", mech, err)
+	}
+	if mech, err := auth.Normalize("ntlm"); mech != auth.NTLM || err != nil {
+		t.Errorf("Normalize(ntlm) = %q, %v", mech, err)
+	}
+	if _, err := auth.Normalize("GSSAPI"); err == nil {
+		t.Error("Normalize accepted an unsupported mechanism")
+	}
+
+	// Clear-text mechanisms need TLS, except to localhost
+	a := auth.SMTP(plain)
+	if _, _, err := a.Start(&smtp.ServerInfo{Name: "smtp.example.com"}); err == nil {
+		t.Error("PLAIN allowed over an unencrypted connection")
+	}
+	if _, _, err := a.Start(&smtp.ServerInfo{Name: "127.0.0.1"}); err != nil {
+		t.Errorf("PLAIN refused on localhost: %v", err)
+	}
+	if _, _, err := auth.SMTP(cram).Start(&smtp.ServerInfo{Name: "smtp.example.com"}); err != nil {
+		t.Errorf("CRAM-MD5 refused over an unencrypted connection: %v", err)
+	}
+}
+
+func TestNTLMAuthenticate(t *testing.T) {
+	// User, domain and password of the MS-NLMP 4.2.4 examples
+	client := auth.NewNTLMClient(`Domain\User`, "Password")
+	mech, negotiate, err := client.Start()
+	if err != nil || mech != auth.NTLM {
+		t.Fatalf("Start = %q, %v", mech, err)
+	}
+	if string(negotiate[:8]) != "NTLMSSP\x00" || binary.LittleEndian.Uint32(negotiate[8:]) != 1 {
+		t.Fatalf("unexpected NEGOTIATE message %x", negotiate)
+	}
+
+	serverChallenge := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	targetInfo := []byte{2, 0, 12, 0}
+	targetInfo = append(targetInfo, ntlmUTF16("Domain")...)
+	targetInfo = append(targetInfo, 0, 0, 0, 0)
+	challenge := make([]byte, 48)
+	copy(challenge, "NTLMSSP\x00")
+	binary.LittleEndian.PutUint32(challenge[8:], 2)
+	binary.LittleEndian.PutUint32(challenge[20:], 0x00888201) // Unicode, NTLM, target info
+	copy(challenge[24:], serverChallenge)
+	binary.LittleEndian.PutUint16(challenge[40:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint16(challenge[42:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint32(challenge[44:], 48)
+	challenge = append(challenge, targetInfo...)
+
+	msg, err := client.Next(challenge)
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if string(msg[:8]) != "NTLMSSP\x00" || binary.LittleEndian.Uint32(msg[8:]) != 3 {
+		t.Fatalf("unexpected AUTHENTICATE message %x", msg)
+	}
+	field := func(i int) []byte {
+		length := binary.LittleEndian.Uint16(msg[12+8*i:])
+		offset := binary.LittleEndian.Uint32(msg[16+8*i:])
+		return msg[offset : offset+uint32(length)]
+	}
+	if !bytes.Equal(field(2), ntlmUTF16("Domain")) || !bytes.Equal(field(3), ntlmUTF16("User")) {
+		t.Errorf("domain/user = %q/%q", field(2), field(3))
+	}
+
+	// NTOWFv2 of the MS-NLMP examples, from the MD4 password hash
+	ntHash, _ := hex.DecodeString("a4f49c406510bdcab6824ee7c30fd852")
+	mac := hmac.New(md5.New, ntHash)
+	mac.Write(ntlmUTF16("USERDomain"))
+	key := mac.Sum(nil)
+	if hex.EncodeToString(key) != "0c868a403bfd7a93a3001ef22ef02e3f" {
+		t.Fatalf("NTOWFv2 = %x", key)
+	}
+
+	ntResponse := field(1)
+	blob := ntResponse[16:]
+	if !bytes.Contains(blob, targetInfo) {
+		t.Error("NT response does not carry the target info")
+	}
+	mac = hmac.New(md5.New, key)
+	mac.Write(serverChallenge)
+	mac.Write(blob)
+	if !bytes.Equal(ntResponse[:16], mac.Sum(nil)) {
+		t.Errorf("NTProofStr = %x, want %x", ntResponse[:16], mac.Sum(nil))
+	}
+}
+
+func ntlmUTF16(s string) []byte {
+	var b []byte
+	for _, code := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, code)
+	}
+	return b
+}
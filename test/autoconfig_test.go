@@ -0,0 +1,142 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"email-mcp-server/autoconfig"
+)
+
+// fakeResolver answers SRV and MX lookups from maps keyed by name
+type fakeResolver struct {
+	srv map[string][]*net.SRV
+	mx  map[string][]*net.MX
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	key := "_" + service + "._" + proto + "." + name
+	if records, ok := r.srv[key]; ok {
+		return key, records, nil
+	}
+	return "", nil, fmt.Errorf("no such host %s", key)
+}
+
+func (r *fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	if records, ok := r.mx[name]; ok {
+		return records, nil
+	}
+	return nil, fmt.Errorf("no such host %s", name)
+}
+
+const autoconfigXML = `<?xml version="1.0"?>
+<clientConfig version="1.1">
+  <emailProvider id="example.org">
+    <domain>example.org</domain>
+    <displayName>Example Mail</displayName>
+    <incomingServer type="pop3">
+      <hostname>pop.example.org</hostname><port>995</port><socketType>SSL</socketType>
+    </incomingServer>
+    <incomingServer type="imap">
+      <hostname>imap.example.org</hostname><port>143</port><socketType>plain</socketType>
+    </incomingServer>
+    <incomingServer type="imap">
+      <hostname>mail.%EMAILDOMAIN%</hostname><port>993</port><socketType>SSL</socketType>
+    </incomingServer>
+    <outgoingServer type="smtp">
+      <hostname>smtp.example.org</hostname><port>2525</port><socketType>SSL</socketType>
+    </outgoingServer>
+    <outgoingServer type="smtp">
+      <hostname>smtp.example.org</hostname><port>587</port><socketType>STARTTLS</socketType>
+    </outgoingServer>
+  </emailProvider>
+</clientConfig>`
+
+func TestAutoconfigPreset(t *testing.T) {
+	d := &autoconfig.Discoverer{URLs: []string{}, Resolver: &fakeResolver{}}
+	settings, err := d.Discover(context.Background(), "Someone@GMail.com")
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	if settings.Provider != "Gmail" || settings.IMAPHost != "imap.gmail.com" || settings.SMTPPort != 587 || settings.Source != autoconfig.SourcePreset {
+		t.Errorf("settings = %+v, want the Gmail preset", settings)
+	}
+	if autoconfig.PresetFor("me@example.org") != nil {
+		t.Error("PresetFor found a preset for an unknown domain")
+	}
+	if _, err := d.Discover(context.Background(), "not-an-address"); err == nil {
+		t.Error("Discover accepted an address without a domain")
+	}
+}
+
+func TestAutoconfigFile(t *testing.T) {
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.String())
+		if r.URL.Path != "/ispdb/example.org" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, autoconfigXML)
+	}))
+	defer server.Close()
+
+	d := &autoconfig.Discoverer{
+		URLs:     []string{server.URL + "/mail/config-v1.1.xml?emailaddress={email}", server.URL + "/ispdb/{domain}"},
+		Resolver: &fakeResolver{},
+	}
+	settings, err := d.Discover(context.Background(), "me+tag@example.org")
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	// The unencrypted IMAP server and the SMTP server with implicit TLS on
+	// a port other than 465 are skipped
+	want := autoconfig.Settings{Provider: "Example Mail", IMAPHost: "mail.example.org", IMAPPort: 993, IMAPSecurity: autoconfig.SecurityTLS,
+		SMTPHost: "smtp.example.org", SMTPPort: 587, SMTPSecurity: autoconfig.SecurityStartTLS, Source: autoconfig.SourceAutoconfig}
+	if *settings != want {
+		t.Errorf("settings = %+v, want %+v", *settings, want)
+	}
+	if len(requested) != 2 || requested[0] != "/mail/config-v1.1.xml?emailaddress=me%2Btag%40example.org" {
+		t.Errorf("requested %v", requested)
+	}
+}
+
+func TestAutoconfigSRVAndMX(t *testing.T) {
+	resolver := &fakeResolver{
+		srv: map[string][]*net.SRV{
+			"_imaps._tcp.example.net":       {{Target: ".", Port: 0}},
+			"_imap._tcp.example.net":        {{Target: "imap.example.net.", Port: 143}},
+			"_submissions._tcp.example.net": {{Target: "smtp.example.net.", Port: 465}},
+		},
+		mx: map[string][]*net.MX{
+			"example.com": {{Host: "mx1.example.com.", Pref: 5}, {Host: "ASPMX.L.GOOGLE.COM.", Pref: 10}},
+		},
+	}
+	d := &autoconfig.Discoverer{URLs: []string{}, Resolver: resolver}
+
+	settings, err := d.Discover(context.Background(), "me@example.net")
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	want := autoconfig.Settings{IMAPHost: "imap.example.net", IMAPPort: 143, IMAPSecurity: autoconfig.SecurityStartTLS,
+		SMTPHost: "smtp.example.net", SMTPPort: 465, SMTPSecurity: autoconfig.SecurityTLS, Source: autoconfig.SourceSRV}
+	if *settings != want {
+		t.Errorf("settings = %+v, want %+v", *settings, want)
+	}
+
+	settings, err = d.Discover(context.Background(), "me@example.com")
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	if settings.Provider != "Gmail" || settings.IMAPHost != "imap.gmail.com" || settings.Source != autoconfig.SourceMX {
+		t.Errorf("settings = %+v, want the Gmail preset found by MX", settings)
+	}
+
+	if _, err := d.Discover(context.Background(), "me@unknown.test"); err == nil {
+		t.Error("Discover found settings for a domain without any")
+	}
+}
@@ -0,0 +1,82 @@
+package test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"email-mcp-server/credentials"
+)
+
+func TestCredentialsFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.enc")
+	store := credentials.NewFileStore(path, "correct horse")
+
+	if err := store.Set("work", "s3cret"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := store.Set("home", "other"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("credentials file not written: %v", err)
+	}
+	if strings.Contains(string(data), "s3cret") {
+		t.Error("credentials file contains the password in plain text")
+	}
+
+	// A new store reads what the first one wrote
+	reopened := credentials.NewFileStore(path, "correct horse")
+	if password, err := reopened.Get("work"); err != nil || password != "s3cret" {
+		t.Errorf("Get(work) = %q, %v; want s3cret", password, err)
+	}
+
+	if err := reopened.Delete("work"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := reopened.Get("work"); !errors.Is(err, credentials.ErrNotFound) {
+		t.Errorf("Get after Delete returned %v, want ErrNotFound", err)
+	}
+	if password, err := reopened.Get("home"); err != nil || password != "other" {
+		t.Errorf("Get(home) = %q, %v; want other", password, err)
+	}
+
+	wrong := credentials.NewFileStore(path, "wrong")
+	if _, err := wrong.Get("home"); err == nil || errors.Is(err, credentials.ErrNotFound) {
+		t.Errorf("Get with a wrong passphrase returned %v, want a decryption error", err)
+	}
+}
+
+func TestCredentialsOpen(t *testing.T) {
+	t.Setenv("TEST_ACCOUNT_PASSWORD", "from-env")
+
+	store, err := credentials.Open(credentials.SourceEnv, "TEST_ACCOUNT_PASSWORD", credentials.Options{})
+	if err != nil {
+		t.Fatalf("Open(env) failed: %v", err)
+	}
+	if password, err := store.Get("work"); err != nil || password != "from-env" {
+		t.Errorf("Get = %q, %v; want from-env", password, err)
+	}
+	if err := store.Set("work", "x"); err == nil {
+		t.Error("Set on the env store should fail")
+	}
+
+	missing, _ := credentials.Open(credentials.SourceEnv, "TEST_ACCOUNT_PASSWORD_UNSET", credentials.Options{})
+	if _, err := missing.Get("work"); !errors.Is(err, credentials.ErrNotFound) {
+		t.Errorf("Get with an unset variable returned %v, want ErrNotFound", err)
+	}
+
+	if _, err := credentials.Open(credentials.SourceEnv, "", credentials.Options{}); err == nil {
+		t.Error("Open(env) without a variable should fail")
+	}
+	if _, err := credentials.Open(credentials.SourceFile, "", credentials.Options{FilePath: "x"}); err == nil {
+		t.Error("Open(file) without a passphrase should fail")
+	}
+	if _, err := credentials.Open("vault", "", credentials.Options{}); err == nil {
+		t.Error("Open with an unknown source should fail")
+	}
+}
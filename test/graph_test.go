@@ -0,0 +1,142 @@
+package test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"email-mcp-server/graph"
+)
+
+// newGraphServer serves the token endpoint and mux as Microsoft Graph, and
+// returns a client signed in with the refresh token "refresh-1"
+func newGraphServer(t *testing.T, mux *http.ServeMux) (*graph.Client, *[]string) {
+	var rotated []string
+	mux.HandleFunc("/tenant/oauth2/v2.0/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("grant_type") != "refresh_token" || r.Form.Get("refresh_token") != "refresh-1" {
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access-1", "refresh_token": "refresh-2", "expires_in": 3600,
+		})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	oauth := &graph.OAuth{Tenant: "tenant", ClientID: "client", Authority: server.URL}
+	tokens := graph.NewTokenSource(oauth, "refresh-1")
+	tokens.OnRefresh = func(token string) { rotated = append(rotated, token) }
+	client := graph.NewClient(tokens)
+	client.BaseURL = server.URL + "/v1.0"
+	return client, &rotated
+}
+
+func TestGraphDelta(t *testing.T) {
+	mux := http.NewServeMux()
+	var serverURL string
+	mux.HandleFunc("/v1.0/me/mailFolders/inbox/messages/delta", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Query().Get("page") {
+		case "":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"value": []map[string]interface{}{
+					{"id": "AAA", "subject": "First", "isRead": true, "receivedDateTime": "2024-05-01T10:00:00Z",
+						"from": map[string]interface{}{"emailAddress": map[string]string{"name": "Ana", "address": "ana@example.com"}}},
+				},
+				"@odata.nextLink": serverURL + "/v1.0/me/mailFolders/inbox/messages/delta?page=2",
+			})
+		case "2":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"value": []map[string]interface{}{
+					{"id": "BBB", "subject": "Second", "flag": map[string]string{"flagStatus": "flagged"}},
+					{"id": "CCC", "@removed": map[string]string{"reason": "deleted"}},
+				},
+				"@odata.deltaLink": serverURL + "/v1.0/me/mailFolders/inbox/messages/delta?page=next",
+			})
+		}
+	})
+	client, rotated := newGraphServer(t, mux)
+	serverURL = strings.TrimSuffix(client.BaseURL, "/v1.0")
+
+	messages, deltaLink, err := client.Delta(context.Background(), "inbox", "")
+	if err != nil {
+		t.Fatalf("Delta failed: %v", err)
+	}
+	if len(messages) != 3 {
+		t.Fatalf("got %d messages over both pages, want 3", len(messages))
+	}
+	if messages[0].From == nil || messages[0].From.EmailAddress.Address != "ana@example.com" || !messages[0].IsRead {
+		t.Errorf("first message decoded as %+v", messages[0])
+	}
+	if !messages[1].Flagged() || messages[1].Removed != nil {
+		t.Errorf("second message should be flagged and present: %+v", messages[1])
+	}
+	if messages[2].Removed == nil || messages[2].Removed.Reason != "deleted" {
+		t.Errorf("third message should be marked removed: %+v", messages[2])
+	}
+	if !strings.HasSuffix(deltaLink, "page=next") {
+		t.Errorf("delta link = %q, want the link of the last page", deltaLink)
+	}
+	if len(*rotated) != 1 || (*rotated)[0] != "refresh-2" {
+		t.Errorf("rotated refresh tokens = %v, want [refresh-2]", *rotated)
+	}
+}
+
+func TestGraphSendMIME(t *testing.T) {
+	raw := "From: me@example.com\r\nTo: you@example.com\r\nSubject: Hi\r\n\r\nHello\r\n"
+	var got []byte
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1.0/me/sendMail", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "text/plain" {
+			t.Errorf("sendMail called with %s and Content-Type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		got, _ = base64.StdEncoding.DecodeString(string(body))
+		w.WriteHeader(http.StatusAccepted)
+	})
+	client, _ := newGraphServer(t, mux)
+
+	if err := client.SendMIME(context.Background(), []byte(raw)); err != nil {
+		t.Fatalf("SendMIME failed: %v", err)
+	}
+	if string(got) != raw {
+		t.Errorf("sent %q, want %q", got, raw)
+	}
+}
+
+func TestGraphErrors(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1.0/me/messages/missing", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": map[string]string{"code": "ErrorItemNotFound", "message": "The specified object was not found in the store."},
+		})
+	})
+	client, _ := newGraphServer(t, mux)
+
+	_, err := client.Message(context.Background(), "missing")
+	if !graph.IsNotFound(err) {
+		t.Fatalf("Message(missing) = %v, want a not found error", err)
+	}
+	if !strings.Contains(err.Error(), "ErrorItemNotFound") {
+		t.Errorf("error %q does not name the Graph error code", err)
+	}
+
+	// A refresh token that was revoked fails every call
+	oauth := &graph.OAuth{Tenant: "tenant", ClientID: "client", Authority: strings.TrimSuffix(client.BaseURL, "/v1.0")}
+	revoked := graph.NewClient(graph.NewTokenSource(oauth, "revoked"))
+	revoked.BaseURL = client.BaseURL
+	if _, err := revoked.Me(context.Background()); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("Me with a revoked token = %v, want invalid_grant", err)
+	}
+}
@@ -0,0 +1,708 @@
+package test
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"email-mcp-server/mail"
+)
+
+func TestParseMultipartAlternative(t *testing.T) {
+	raw := strings.Join([]string{
+		"From: Ana <ana@example.com>",
+		"Subject: =?UTF-8?Q?Reuni=C3=B3n_ma=C3=B1ana?=",
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/mixed; boundary=outer",
+		"",
+		"--outer",
+		"Content-Type: multipart/alternative; boundary=inner",
+		"",
+		"--inner",
+		"Content-Type: text/plain; charset=ISO-8859-1",
+		"Content-Transfer-Encoding: quoted-printable",
+		"",
+		"Nos vemos ma=F1ana a las 10.",
+		"--inner",
+		"Content-Type: text/html; charset=UTF-8",
+		"Content-Transfer-Encoding: base64",
+		"",
+		"PHA+Tm9zIHZlbW9zIG1hw7FhbmE8L3A+",
+		"--inner--",
+		"--outer",
+		"Content-Type: application/pdf; name=\"factura.pdf\"",
+		"Content-Disposition: attachment; filename=\"factura.pdf\"",
+		"Content-Transfer-Encoding: base64",
+		"",
+		"JVBERi0xLjQK",
+		"--outer--",
+		"",
+	}, "\r\n")
+
+	parsed, err := mail.Parse(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if got := parsed.Header("Subject"); got != "Reunión mañana" {
+		t.Errorf("Subject = %q", got)
+	}
+	if parsed.TextBody != "Nos vemos mañana a las 10." {
+		t.Errorf("TextBody = %q", parsed.TextBody)
+	}
+	if parsed.HTMLBody != "<p>Nos vemos mañana</p>" {
+		t.Errorf("HTMLBody = %q", parsed.HTMLBody)
+	}
+	if len(parsed.Attachments) != 1 {
+		t.Fatalf("expected 1 attachment, got %d", len(parsed.Attachments))
+	}
+	att := parsed.Attachments[0]
+	if att.Filename != "factura.pdf" || att.ContentType != "application/pdf" || string(att.Data) != "%PDF-1.4\n" {
+		t.Errorf("unexpected attachment: %+v", att)
+	}
+}
+
+func TestParsePlainMessage(t *testing.T) {
+	raw := "From: a@example.com\r\nSubject: hi\r\n\r\n--not a boundary\r\nContent-less line\r\n"
+
+	parsed, err := mail.Parse(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	// Lines that merely look like MIME syntax must survive in plain bodies
+	if parsed.TextBody != "--not a boundary\r\nContent-less line" {
+		t.Errorf("TextBody = %q", parsed.TextBody)
+	}
+}
+
+func TestBuildWithAttachmentRoundTrip(t *testing.T) {
+	msg := &mail.OutgoingMessage{
+		From:    "me@example.com",
+		To:      []string{"you@example.com"},
+		Subject: "Report",
+		Body:    "See attached.",
+		Attachments: []mail.Attachment{
+			{Filename: "report.pdf", ContentType: "application/pdf", Data: []byte("%PDF-1.4\n")},
+		},
+	}
+
+	raw, err := msg.Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	parsed, err := mail.ParseBytes(raw)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if parsed.TextBody != "See attached." {
+		t.Errorf("TextBody = %q", parsed.TextBody)
+	}
+	if len(parsed.Attachments) != 1 {
+		t.Fatalf("expected 1 attachment, got %d", len(parsed.Attachments))
+	}
+	att := parsed.Attachments[0]
+	if att.Filename != "report.pdf" || att.ContentType != "application/pdf" || string(att.Data) != "%PDF-1.4\n" {
+		t.Errorf("unexpected attachment: %+v", att)
+	}
+}
+
+func TestBuildOmitsBcc(t *testing.T) {
+	msg := &mail.OutgoingMessage{
+		From:    "me@example.com",
+		To:      []string{"a@example.com", "b@example.com"},
+		Cc:      []string{"c@example.com"},
+		Bcc:     []string{"hidden@example.com"},
+		Subject: "Hi",
+		Body:    "Hello",
+	}
+
+	raw, err := msg.Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	parsed, err := mail.ParseBytes(raw)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if got := parsed.Header("To"); got != "a@example.com, b@example.com" {
+		t.Errorf("To = %q", got)
+	}
+	if got := parsed.Header("Cc"); got != "c@example.com" {
+		t.Errorf("Cc = %q", got)
+	}
+	if strings.Contains(string(raw), "hidden@example.com") {
+		t.Error("Bcc recipient leaked into message headers")
+	}
+	if got := msg.Recipients(); len(got) != 4 || got[3] != "hidden@example.com" {
+		t.Errorf("Recipients = %v", got)
+	}
+}
+
+func TestBuildHeaders(t *testing.T) {
+	subject := "Presupuesto de la reunión del año: revisión de costes, calendario y próximos pasos"
+	msg := &mail.OutgoingMessage{
+		From:    "José Pérez <jose@example.com>",
+		To:      []string{"Ana <ana@example.com>", "bob@example.com"},
+		Subject: subject,
+		Body:    "Hola Ana,\n¿Nos vemos mañana?",
+	}
+	raw, err := msg.Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+
+	header, _, _ := strings.Cut(string(raw), "\r\n\r\n")
+	for _, line := range strings.Split(header, "\r\n") {
+		if len(line) > 78 {
+			t.Errorf("header line not folded: %q", line)
+		}
+		for i := 0; i < len(line); i++ {
+			if line[i] >= 0x80 {
+				t.Fatalf("non-ASCII header line: %q", line)
+			}
+		}
+	}
+
+	parsed, err := mail.ParseBytes(raw)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if got := parsed.Header("Subject"); got != subject {
+		t.Errorf("Subject = %q", got)
+	}
+	if from, err := mail.ParseAddress(parsed.Header("From")); err != nil || from.Name != "José Pérez" || from.Address != "jose@example.com" {
+		t.Errorf("From = %+v, %v", from, err)
+	}
+	if parsed.Header("Date") == "" || !strings.HasSuffix(parsed.Header("Message-ID"), "@example.com>") || parsed.Header("MIME-Version") != "1.0" {
+		t.Errorf("missing Date, Message-ID or MIME-Version in:\n%s", header)
+	}
+	if parsed.TextBody != "Hola Ana,\r\n¿Nos vemos mañana?" && parsed.TextBody != "Hola Ana,\n¿Nos vemos mañana?" {
+		t.Errorf("TextBody = %q", parsed.TextBody)
+	}
+
+	// A line break in the subject cannot start another header
+	msg = &mail.OutgoingMessage{From: "me@example.com", To: []string{"you@example.com"}, Subject: "Hi\r\nBcc: eve@example.com", Body: "Hello"}
+	if raw, err = msg.Build(); err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if strings.Contains(string(raw), "\r\nBcc:") {
+		t.Errorf("subject injected a header:\n%s", raw)
+	}
+}
+
+func TestParseListUnsubscribe(t *testing.T) {
+	options := mail.ParseListUnsubscribe(
+		"<mailto:leave@lists.example.com?subject=unsubscribe%20me>, <https://example.com/u/123>, <ftp://example.com/x>, junk",
+		"List-Unsubscribe=One-Click")
+
+	if len(options) != 2 {
+		t.Fatalf("got %d options, want 2: %+v", len(options), options)
+	}
+	if options[0].Method != "mailto" || options[0].Address != "leave@lists.example.com" || options[0].Subject != "unsubscribe me" {
+		t.Errorf("unexpected mailto option: %+v", options[0])
+	}
+	if options[1].Method != "http" || !options[1].OneClick || options[1].URL != "https://example.com/u/123" {
+		t.Errorf("unexpected http option: %+v", options[1])
+	}
+
+	// One-click needs both the POST header and an HTTPS URL
+	if opts := mail.ParseListUnsubscribe("<http://example.com/u/123>", "List-Unsubscribe=One-Click"); len(opts) != 1 || opts[0].OneClick {
+		t.Errorf("plain HTTP option marked one-click: %+v", opts)
+	}
+	if opts := mail.ParseListUnsubscribe("<https://example.com/u/123>", ""); len(opts) != 1 || opts[0].OneClick {
+		t.Errorf("option without List-Unsubscribe-Post marked one-click: %+v", opts)
+	}
+
+	// Commas inside the brackets belong to the URI, and whitespace left by
+	// folding is dropped
+	opts := mail.ParseListUnsubscribe("<https://example.com/u?id=1,2&list=a,b>,\r\n <mailto:leave@example.com?subject=bye,\r\n now>", "")
+	if len(opts) != 2 || opts[0].URL != "https://example.com/u?id=1,2&list=a,b" || opts[1].Address != "leave@example.com" || opts[1].Subject != "bye,now" {
+		t.Errorf("URIs with commas parsed as %+v", opts)
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	cases := []struct {
+		in         string
+		name, addr string
+	}{
+		{"ana@example.com", "", "ana@example.com"},
+		{"Ana <ana@example.com>", "Ana", "ana@example.com"},
+		{`"Doe, John" <john@example.com>`, "Doe, John", "john@example.com"},
+		{"=?UTF-8?Q?Jos=C3=A9?= <jose@example.com>", "José", "jose@example.com"},
+		// Unquoted names stored by earlier versions
+		{"Doe, John <john@example.com>", "Doe, John", "john@example.com"},
+	}
+	for _, c := range cases {
+		addr, err := mail.ParseAddress(c.in)
+		if err != nil {
+			t.Errorf("ParseAddress(%q): %v", c.in, err)
+			continue
+		}
+		if addr.Name != c.name || addr.Address != c.addr {
+			t.Errorf("ParseAddress(%q) = %+v", c.in, addr)
+		}
+		// String must parse back to the same address
+		if back, err := mail.ParseAddress(addr.String()); err != nil || back != addr {
+			t.Errorf("ParseAddress(%q) = %+v, %v", addr.String(), back, err)
+		}
+	}
+
+	if _, err := mail.ParseAddress("not an address"); err == nil {
+		t.Error("ParseAddress accepted an invalid address")
+	}
+	if got := (mail.Address{Name: "Doe, John", Address: "john@example.com"}).String(); got != `"Doe, John" <john@example.com>` {
+		t.Errorf("String = %q", got)
+	}
+	a := mail.Address{Name: "Boss", Address: "Boss@Example.com"}
+	if !a.Equal(mail.Address{Address: "boss@example.com"}) || a.Domain() != "example.com" {
+		t.Errorf("Equal/Domain of %+v", a)
+	}
+}
+
+func TestParseCalendarInvite(t *testing.T) {
+	raw := strings.Join([]string{
+		"From: Ana <ana@example.com>",
+		"Subject: Invitation: Sprint review",
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=alt",
+		"",
+		"--alt",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		"You have been invited.",
+		"--alt",
+		"Content-Type: text/calendar; charset=UTF-8; method=REQUEST",
+		"",
+		"BEGIN:VCALENDAR",
+		"METHOD:REQUEST",
+		"BEGIN:VEVENT",
+		"UID:abc-123@example.com",
+		"SEQUENCE:2",
+		"SUMMARY:Sprint review\\, Q3",
+		"DTSTART;TZID=Romance Standard Time:20251014T100000",
+		"DURATION:PT1H30M",
+		"LOCATION:Room 4",
+		"DESCRIPTION:Agenda:\\nDemo and retro with a long description that is fo",
+		" lded over two lines",
+		`ORGANIZER;CN="Ana, PM":mailto:ana@example.com`,
+		"ATTENDEE;CN=Bob;PARTSTAT=ACCEPTED:mailto:bob@example.com",
+		"ATTENDEE;RSVP=TRUE;ROLE=REQ-PARTICIPANT:MAILTO:Me@Example.com",
+		"BEGIN:VALARM",
+		"DESCRIPTION:Reminder",
+		"END:VALARM",
+		"END:VEVENT",
+		"END:VCALENDAR",
+		"--alt--",
+	}, "\r\n")
+
+	parsed, err := mail.ParseBytes([]byte(raw))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	events := parsed.Events()
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	event := events[0]
+
+	if event.Method != "REQUEST" || event.UID != "abc-123@example.com" || event.Sequence != 2 {
+		t.Errorf("unexpected identity: %+v", event)
+	}
+	if event.Summary != "Sprint review, Q3" {
+		t.Errorf("Summary = %q", event.Summary)
+	}
+	if event.Description != "Agenda:\nDemo and retro with a long description that is folded over two lines" {
+		t.Errorf("Description = %q (VALARM must not override it)", event.Description)
+	}
+	paris, _ := time.LoadLocation("Europe/Paris")
+	if want := time.Date(2025, 10, 14, 10, 0, 0, 0, paris); !event.Start.Equal(want) {
+		t.Errorf("Start = %v, want %v", event.Start, want)
+	}
+	if got := event.End.Sub(event.Start); got != 90*time.Minute {
+		t.Errorf("duration = %v, want 1h30m", got)
+	}
+	if event.Organizer == nil || event.Organizer.Name != "Ana, PM" || event.Organizer.Email != "ana@example.com" {
+		t.Errorf("Organizer = %+v", event.Organizer)
+	}
+	me := event.Attendee("me@example.com")
+	if me == nil || me.Status != mail.PartStatNeedsAction || !me.RSVP {
+		t.Errorf("own attendee = %+v", me)
+	}
+	if bob := event.Attendee("bob@example.com"); bob == nil || bob.Status != mail.PartStatAccepted {
+		t.Errorf("bob = %+v", bob)
+	}
+}
+
+func TestBuildCalendarReplyRoundTrip(t *testing.T) {
+	event := mail.Event{
+		UID:       "abc-123@example.com",
+		Sequence:  2,
+		Summary:   "Sprint review; Q3",
+		Start:     time.Date(2025, 10, 14, 8, 0, 0, 0, time.UTC),
+		Organizer: &mail.Attendee{Email: "ana@example.com"},
+	}
+	reply, err := mail.BuildCalendarReply(event, mail.Attendee{Name: "Me", Email: "me@example.com"}, mail.PartStatDeclined, time.Now())
+	if err != nil {
+		t.Fatalf("BuildCalendarReply: %v", err)
+	}
+	if _, err := mail.BuildCalendarReply(event, mail.Attendee{Email: "me@example.com"}, "MAYBE", time.Now()); err == nil {
+		t.Error("invalid status accepted")
+	}
+
+	msg := &mail.OutgoingMessage{
+		From:     "me@example.com",
+		To:       []string{"ana@example.com"},
+		Subject:  "Declined: Sprint review; Q3",
+		Body:     "Me has declined the invitation",
+		Calendar: reply,
+	}
+	raw, err := msg.Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	if !strings.Contains(string(raw), "multipart/alternative") || !strings.Contains(string(raw), "method=REPLY") {
+		t.Errorf("calendar part not sent as a REPLY alternative:\n%s", raw)
+	}
+
+	parsed, err := mail.ParseBytes(raw)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if parsed.TextBody != "Me has declined the invitation" {
+		t.Errorf("TextBody = %q", parsed.TextBody)
+	}
+	events := parsed.Events()
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	got := events[0]
+	if got.Method != "REPLY" || got.UID != event.UID || got.Sequence != 2 || got.Summary != event.Summary || !got.Start.Equal(event.Start) {
+		t.Errorf("unexpected reply event: %+v", got)
+	}
+	if me := got.Attendee("me@example.com"); me == nil || me.Status != mail.PartStatDeclined || me.Name != "Me" {
+		t.Errorf("reply attendee = %+v", me)
+	}
+}
+
+func TestParseNotify(t *testing.T) {
+	notify, err := mail.ParseNotify("failure, delay", "FAILURE")
+	if err != nil || strings.Join(notify, ",") != "FAILURE,DELAY" {
+		t.Errorf("ParseNotify = %v, %v", notify, err)
+	}
+	if notify, err := mail.ParseNotify(""); err != nil || notify != nil {
+		t.Errorf("ParseNotify(\"\") = %v, %v", notify, err)
+	}
+	if _, err := mail.ParseNotify("never,success"); err == nil {
+		t.Error("never accepted with another condition")
+	}
+	if _, err := mail.ParseNotify("bounce"); err == nil {
+		t.Error("unknown condition accepted")
+	}
+
+	if got := mail.XText("<a+b=c@example.com>"); got != "<a+2Bb+3Dc@example.com>" {
+		t.Errorf("XText = %q", got)
+	}
+}
+
+func TestAutoReplyAddress(t *testing.T) {
+	from := mail.Address{Name: "Ana", Address: "ana@example.com"}
+	for _, tc := range []struct {
+		headers string
+		want    string
+	}{
+		{"Subject: Hello", "ana@example.com"},
+		{"Reply-To: Team <team@example.com>, other@example.com", "team@example.com"},
+		{"Return-Path: <bounces+ana@lists.example.com>\r\nReply-To: team@example.com", "bounces+ana@lists.example.com"},
+		{"Return-Path: <>", ""},
+		{"Return-Path: not an address\r\nReply-To: team@example.com", "team@example.com"},
+	} {
+		parsed, err := mail.Parse(strings.NewReader("From: Ana <ana@example.com>\r\n" + tc.headers + "\r\n\r\nbody"))
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		if got := parsed.AutoReplyAddress(from); got.Address != tc.want {
+			t.Errorf("AutoReplyAddress() with %q = %q, want %q", tc.headers, got.Address, tc.want)
+		}
+	}
+}
+
+func TestAutomatedMessages(t *testing.T) {
+	for _, tc := range []struct {
+		headers   string
+		automated bool
+	}{
+		{"Subject: Hello", false},
+		{"Auto-Submitted: no", false},
+		{"Auto-Submitted: auto-replied", true},
+		{"Precedence: bulk", true},
+		{"List-Id: <team.example.com>", true},
+		{"X-Auto-Response-Suppress: OOF, AutoReply", true},
+	} {
+		parsed, err := mail.Parse(strings.NewReader("From: a@example.com\r\n" + tc.headers + "\r\n\r\nbody"))
+		if err != nil {
+			t.Fatalf("Parse: %v", err)
+		}
+		if got := parsed.Automated(); got != tc.automated {
+			t.Errorf("Automated() with %q = %v, want %v", tc.headers, got, tc.automated)
+		}
+	}
+
+	for addr, robot := range map[string]bool{
+		"MAILER-DAEMON@example.com": true,
+		"no-reply@example.com":      true,
+		"noreply+abc@example.com":   true,
+		"nora@example.com":          false,
+	} {
+		if got := (mail.Address{Address: addr}).IsRobot(); got != robot {
+			t.Errorf("IsRobot(%s) = %v, want %v", addr, got, robot)
+		}
+	}
+}
+
+func TestAutoKind(t *testing.T) {
+	for _, tc := range []struct {
+		headers string
+		kind    string
+	}{
+		{"From: ana@example.com\r\nSubject: Hello", ""},
+		{"From: ana@example.com\r\nAuto-Submitted: no", ""},
+		{"From: ana@example.com\r\nSubject: Out of Office: back Monday", mail.KindAutoReply},
+		{"From: ana@example.com\r\nAuto-Submitted: auto-replied", mail.KindAutoReply},
+		{"From: ana@example.com\r\nX-Autoreply: yes", mail.KindAutoReply},
+		{"From: alerts@example.com\r\nAuto-Submitted: auto-generated", mail.KindAutoGenerated},
+		{"From: MAILER-DAEMON@example.com\r\nSubject: Undelivered Mail Returned to Sender", mail.KindBounce},
+		{"From: ana@example.com\r\nX-Failed-Recipients: bob@example.com", mail.KindBounce},
+		{"From: postmaster@example.com\r\nContent-Type: multipart/report; report-type=delivery-status; boundary=b", mail.KindBounce},
+	} {
+		parsed, err := mail.ParseHeader(strings.NewReader(tc.headers + "\r\n\r\n"))
+		if err != nil {
+			t.Fatalf("ParseHeader: %v", err)
+		}
+		if got := parsed.AutoKind(); got != tc.kind {
+			t.Errorf("AutoKind() with %q = %q, want %q", tc.headers, got, tc.kind)
+		}
+	}
+}
+
+func TestBounces(t *testing.T) {
+	raw := "From: MAILER-DAEMON@mx.example.com\r\n" +
+		"Subject: Undelivered Mail Returned to Sender\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/report; report-type=delivery-status; boundary=\"b\"\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nYour message could not be delivered.\r\n" +
+		"--b\r\nContent-Type: message/delivery-status\r\n\r\n" +
+		"Reporting-MTA: dns; mx.example.com\r\n\r\n" +
+		"Final-Recipient: rfc822; Bob@example.org\r\nAction: failed\r\nStatus: 5.1.1\r\n" +
+		"Diagnostic-Code: smtp; 550 5.1.1 <bob@example.org>:\r\n  user unknown\r\n\r\n" +
+		"Final-Recipient: rfc822; carol@example.org\r\nAction: delayed\r\nStatus: 4.4.1\r\n\r\n" +
+		"Final-Recipient: rfc822; dave@example.org\r\nAction: delivered\r\nStatus: 2.0.0\r\n" +
+		"--b\r\nContent-Type: text/rfc822-headers\r\n\r\n" +
+		"Message-ID: <report-1@example.com>\r\nSubject: Report\r\n" +
+		"--b--\r\n"
+
+	parsed, err := mail.Parse(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if kind := parsed.AutoKind(); kind != mail.KindBounce {
+		t.Fatalf("AutoKind() = %q, want bounce", kind)
+	}
+	bounces := parsed.Bounces()
+	if len(bounces) != 2 {
+		t.Fatalf("expected the failed and delayed recipients, got %+v", bounces)
+	}
+	bob, carol := bounces[0], bounces[1]
+	if bob.Recipient != "bob@example.org" || bob.Status != "5.1.1" || !bob.Permanent() ||
+		bob.Diagnostic != "550 5.1.1 <bob@example.org>: user unknown" || bob.OriginalMessageID != "report-1@example.com" {
+		t.Errorf("unexpected failed bounce: %+v", bob)
+	}
+	if carol.Recipient != "carol@example.org" || carol.Permanent() {
+		t.Errorf("unexpected delayed bounce: %+v", carol)
+	}
+
+	// Without a delivery-status part the header names the recipients
+	parsed, err = mail.Parse(strings.NewReader("From: MAILER-DAEMON@example.com\r\nX-Failed-Recipients: eve@example.org\r\n\r\nFailed."))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if bounces := parsed.Bounces(); len(bounces) != 1 || bounces[0].Recipient != "eve@example.org" || !bounces[0].Permanent() {
+		t.Errorf("unexpected bounces from X-Failed-Recipients: %+v", bounces)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	all, err := mail.ParseRedact("all")
+	if err != nil || len(all) != len(mail.RedactKinds) {
+		t.Fatalf("ParseRedact(all) = %v, %v", all, err)
+	}
+	if _, err := mail.ParseRedact("card,passport"); err == nil {
+		t.Error("unknown redaction accepted")
+	}
+
+	for _, tc := range []struct {
+		text, want string
+	}{
+		{"Card 4111 1111 1111 1111 expires soon", "Card [REDACTED CARD] expires soon"},
+		{"Order 4111 1111 1111 1112 shipped", "Order 4111 1111 1111 1112 shipped"}, // fails the Luhn check
+		{"Pay to GB82 WEST 1234 5698 7654 32 THANKS", "Pay to [REDACTED IBAN] THANKS"},
+		{"IBAN: ES9121000418450200051332.", "IBAN: [REDACTED IBAN]."},
+		{"SSN 123-45-6789, not 666-12-3456", "SSN [REDACTED SSN], not 666-12-3456"},
+		{"Your verification code is 482913.", "Your verification code is [REDACTED OTP]."},
+		{"482913 is your login code", "[REDACTED OTP] is your login code"},
+		{"Meeting at 1530 in room 12", "Meeting at 1530 in room 12"},
+	} {
+		report := map[string]int{}
+		if got := mail.Redact(tc.text, all, report); got != tc.want {
+			t.Errorf("Redact(%q) = %q, want %q", tc.text, got, tc.want)
+		}
+	}
+
+	report := map[string]int{}
+	text := "Card 4111-1111-1111-1111, code 1234"
+	if got := mail.Redact(text, []string{mail.RedactCard}, report); got != "Card [REDACTED CARD], code 1234" {
+		t.Errorf("only cards redacted = %q", got)
+	}
+	if report[mail.RedactCard] != 1 || report[mail.RedactOTP] != 0 {
+		t.Errorf("report = %v", report)
+	}
+}
+
+func TestVerificationCode(t *testing.T) {
+	for _, tc := range []struct {
+		subject, body, want string
+	}{
+		{"482913 is your GitHub launch code", "Here is your code.", "482913"},
+		{"Sign in to Example", "Hi Ana,\n\nYour verification code is: 7731\n\nCode sent in 2025 by Example", "7731"},
+		{"Tu código de acceso", "Introduce el código 90817263 para continuar.", "90817263"},
+		// Years next to the word are not codes
+		{"Security alert", "Code of conduct updated in 2025.", ""},
+		{"Lunch", "See you at 1300", ""},
+	} {
+		if got := mail.VerificationCode(tc.subject, tc.body); got != tc.want {
+			t.Errorf("VerificationCode(%q, %q) = %q, want %q", tc.subject, tc.body, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		from, subject string
+		want          bool
+	}{
+		{"noreply@github.com", "[GitHub] Please verify your device", true},
+		{"account-security-noreply@accountprotection.microsoft.com", "Microsoft account", true},
+		{"ana@example.com", "Your login code", true},
+		{"ana@example.com", "Door code for Saturday", true},
+		{"ana@example.com", "Lunch tomorrow?", false},
+	} {
+		from, _ := mail.ParseAddress(tc.from)
+		if got := mail.LooksLikeVerification(from, tc.subject); got != tc.want {
+			t.Errorf("LooksLikeVerification(%q, %q) = %v", tc.from, tc.subject, got)
+		}
+	}
+}
+
+func TestWriteMbox(t *testing.T) {
+	raw := "From: Ana <ana@example.com>\r\nSubject: Hi\r\n\r\nFrom now on\r\n>From the team\r\nbye"
+	var b strings.Builder
+	date := time.Date(2025, 3, 14, 9, 30, 0, 0, time.UTC)
+	if err := mail.WriteMbox(&b, "ana@example.com", date, []byte(raw)); err != nil {
+		t.Fatalf("WriteMbox: %v", err)
+	}
+	want := "From ana@example.com Fri Mar 14 09:30:00 2025\n" +
+		"From: Ana <ana@example.com>\nSubject: Hi\n\n>From now on\n>>From the team\nbye\n\n"
+	if b.String() != want {
+		t.Errorf("WriteMbox wrote\n%q\nwant\n%q", b.String(), want)
+	}
+}
+
+func TestDecodePartCutShort(t *testing.T) {
+	// "año " repeated in base64, cut by a partial fetch inside a quantum
+	encoded := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("año ", 10)))
+	if got := mail.DecodePart(strings.NewReader(encoded[:10]), "base64", "utf-8", 0); got != "año a" {
+		t.Errorf("base64 cut = %q, want %q", got, "año a")
+	}
+
+	// "mañana" in ISO-8859-1 quoted-printable, cut inside an escape
+	if got := mail.DecodePart(strings.NewReader("ma=F1ana ma=F"), "quoted-printable", "ISO-8859-1", 0); got != "mañana ma" {
+		t.Errorf("quoted-printable cut = %q, want %q", got, "mañana ma")
+	}
+
+	// A limit that splits a character drops it
+	if got := mail.DecodePart(strings.NewReader("mañana"), "", "utf-8", 3); got != "ma" {
+		t.Errorf("limited = %q, want %q", got, "ma")
+	}
+}
+
+func TestDecodeHeader(t *testing.T) {
+	for _, tc := range []struct{ raw, want string }{
+		{"=?UTF-8?Q?Reuni=C3=B3n_de_ma=C3=B1ana?=", "Reunión de mañana"},
+		{"=?UTF-8?B?wr9RdcOpIHRhbD8=?=", "¿Qué tal?"},
+		{"=?ISO-8859-1?Q?Se=F1or_Mu=F1oz?=", "Señor Muñoz"},
+		{"=?windows-1252?Q?Factura_=80_=93urgente=94?=", "Factura € “urgente”"},
+		{"=?iso-8859-15?Q?Caf=E9_=A4?=", "Café €"},
+		// Adjacent words are joined without the space between them
+		{"=?UTF-8?Q?Informe_del_?= =?UTF-8?Q?a=C3=B1o?=", "Informe del año"},
+		// Senders that leave spaces unencoded
+		{"Re: =?UTF-8?Q?Informe del a=C3=B1o?=", "Re: Informe del año"},
+		// Unknown charsets are read as UTF-8, or windows-1252 when invalid
+		{"=?x-unknown?Q?Jos=C3=A9?=", "José"},
+		{"=?x-unknown?Q?Jos=E9?=", "José"},
+		{"Plain =? subject", "Plain =? subject"},
+	} {
+		if got := mail.DecodeHeader(tc.raw); got != tc.want {
+			t.Errorf("DecodeHeader(%q) = %q, want %q", tc.raw, got, tc.want)
+		}
+	}
+}
+
+func TestParseLegacyCharsets(t *testing.T) {
+	for _, tc := range []struct {
+		name, contentType, encoding, body, want string
+	}{
+		{"latin1", "text/plain; charset=ISO-8859-1", "quoted-printable", "Ma=F1ana a las 10, se=F1or.", "Mañana a las 10, señor."},
+		{"latin1 read as windows-1252", "text/plain; charset=iso-8859-1", "quoted-printable", "Precio: 5=80 =96 =93oferta=94", "Precio: 5€ – “oferta”"},
+		{"windows-1252", "text/plain; charset=windows-1252", "8bit", "Caf\xe9 y t\xe9, 3\x80", "Café y té, 3€"},
+		{"unlabelled 8bit", "text/plain", "8bit", "Espa\xf1a", "España"},
+		{"us-ascii that is UTF-8", "text/plain; charset=us-ascii", "8bit", "Espa\xc3\xb1a", "España"},
+		{"html", "text/html; charset=windows-1252", "quoted-printable", "<p>Se=F1or</p>", "<p>Señor</p>"},
+	} {
+		raw := "From: ana@example.com\r\nSubject: Hola\r\nMIME-Version: 1.0\r\n" +
+			"Content-Type: " + tc.contentType + "\r\nContent-Transfer-Encoding: " + tc.encoding + "\r\n\r\n" + tc.body
+		parsed, err := mail.Parse(strings.NewReader(raw))
+		if err != nil {
+			t.Fatalf("%s: Parse: %v", tc.name, err)
+		}
+		got := parsed.TextBody
+		if strings.HasPrefix(tc.contentType, "text/html") {
+			got = parsed.HTMLBody
+		}
+		if got != tc.want {
+			t.Errorf("%s: body = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	// Parts fetched on their own are decoded the same way
+	if got := mail.DecodePart(strings.NewReader("Espa\xf1a"), "8bit", "", 0); got != "España" {
+		t.Errorf("DecodePart unlabelled = %q", got)
+	}
+	if got := mail.DecodePart(strings.NewReader("5=80"), "quoted-printable", "ISO-8859-1", 0); got != "5€" {
+		t.Errorf("DecodePart latin1 = %q", got)
+	}
+}
+
+func TestParseHeaderOfMultipart(t *testing.T) {
+	// The header block of a multipart message, as BODY[HEADER] returns it
+	raw := "From: a@example.com\r\nSubject: =?UTF-8?Q?Informe_a=C3=B1o?=\r\nContent-Type: multipart/mixed; boundary=outer\r\n\r\n"
+
+	parsed, err := mail.ParseHeader(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ParseHeader: %v", err)
+	}
+	if got := parsed.Header("Subject"); got != "Informe año" {
+		t.Errorf("Subject = %q", got)
+	}
+}
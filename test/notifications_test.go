@@ -0,0 +1,157 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"email-mcp-server/config"
+	"email-mcp-server/mail"
+	"email-mcp-server/notifications"
+)
+
+// webhookRecorder collects the JSON bodies posted to it
+type webhookRecorder struct {
+	mu       sync.Mutex
+	payloads []map[string]interface{}
+}
+
+func (w *webhookRecorder) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	var payload map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&payload)
+	w.mu.Lock()
+	w.payloads = append(w.payloads, payload)
+	w.mu.Unlock()
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func testAlert(id, level string, score int) notifications.Alert {
+	return notifications.Alert{
+		AccountID: "work",
+		Folder:    "INBOX",
+		MessageID: id,
+		From:      "boss@corp.com",
+		Subject:   "Contract " + id,
+		Date:      time.Now(),
+		Score:     score,
+		Level:     level,
+		Reasons:   []string{"vip sender +30"},
+	}
+}
+
+func TestNotifierChannels(t *testing.T) {
+	slack, discord := &webhookRecorder{}, &webhookRecorder{}
+	slackServer, discordServer := httptest.NewServer(slack), httptest.NewServer(discord)
+	defer slackServer.Close()
+	defer discordServer.Close()
+
+	var sent []*mail.OutgoingMessage
+	cfg := config.DefaultNotificationConfig()
+	cfg.Enabled = true
+	cfg.Webhooks = []config.WebhookChannel{
+		{URL: slackServer.URL, Format: "slack"},
+		{URL: discordServer.URL, Format: "discord", MinLevel: "critical"},
+	}
+	cfg.Email = config.EmailChannel{Enabled: true, Account: "home", To: "me@example.com"}
+
+	n := notifications.New(cfg, func(accountID string, msg *mail.OutgoingMessage) error {
+		if accountID != "home" {
+			t.Errorf("expected the email channel account, got %q", accountID)
+		}
+		sent = append(sent, msg)
+		return nil
+	})
+
+	if n.Level(85) != "critical" || n.Level(60) != "high" || n.Level(59) != "" {
+		t.Errorf("unexpected levels: %q %q %q", n.Level(85), n.Level(60), n.Level(59))
+	}
+
+	result, err := n.Notify([]notifications.Alert{testAlert("<a@x>", "critical", 90), testAlert("<b@x>", "high", 65)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Alerts != 2 || result.Delivered != 3 {
+		t.Errorf("expected 2 alerts delivered to 3 channels, got %+v", result)
+	}
+
+	if len(slack.payloads) != 1 || !strings.Contains(slack.payloads[0]["text"].(string), "2 high-priority emails") {
+		t.Errorf("unexpected Slack payloads: %v", slack.payloads)
+	}
+	if len(discord.payloads) != 1 {
+		t.Fatalf("expected one Discord post, got %d", len(discord.payloads))
+	}
+	if embeds := discord.payloads[0]["embeds"].([]interface{}); len(embeds) != 1 {
+		t.Errorf("expected only the critical alert on Discord, got %d embeds", len(embeds))
+	}
+	if len(sent) != 1 || sent[0].To[0] != "me@example.com" || !strings.Contains(sent[0].Body, "Contract <b@x>") {
+		t.Errorf("unexpected summary emails: %+v", sent)
+	}
+
+	// The same emails again are duplicates; only the new one is sent
+	result, _ = n.Notify([]notifications.Alert{testAlert("<a@x>", "critical", 90), testAlert("<c@x>", "high", 70)})
+	if result.Duplicates != 1 || result.Alerts != 1 || result.Delivered != 2 {
+		t.Errorf("expected one duplicate and one high alert for Slack and email, got %+v", result)
+	}
+}
+
+func TestNotifierRateLimit(t *testing.T) {
+	hook := &webhookRecorder{}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+
+	cfg := config.DefaultNotificationConfig()
+	cfg.RateLimitPerHour = 2
+	cfg.Webhooks = []config.WebhookChannel{{URL: server.URL, Format: "json"}}
+	n := notifications.New(cfg, nil)
+
+	limited := 0
+	for _, id := range []string{"<1@x>", "<2@x>", "<3@x>"} {
+		result, err := n.Notify([]notifications.Alert{testAlert(id, "high", 70)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		limited += result.RateLimited
+	}
+	if len(hook.payloads) != 2 || limited != 1 {
+		t.Errorf("expected 2 posts and 1 rate limited, got %d posts and %d limited", len(hook.payloads), limited)
+	}
+	if alerts := hook.payloads[0]["alerts"].([]interface{}); len(alerts) != 1 {
+		t.Errorf("expected the json format to carry the alerts, got %v", hook.payloads[0])
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	cfg.Webhooks = []config.WebhookChannel{{URL: failing.URL}}
+	if _, err := notifications.New(cfg, nil).Notify([]notifications.Alert{testAlert("<4@x>", "high", 70)}); err == nil {
+		t.Error("expected an error from a failing webhook")
+	}
+}
+
+func TestLoadNotificationConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	cfg, err := config.LoadNotificationConfig(filepath.Join(dir, "missing.json"))
+	if err != nil || cfg.Enabled || cfg.HighThreshold != 60 {
+		t.Errorf("expected disabled defaults for a missing file, got %+v, %v", cfg, err)
+	}
+
+	path := filepath.Join(dir, "notifications.json")
+	os.WriteFile(path, []byte(`{"enabled": true, "webhooks": [{"url": "https://example.com", "format": "teams"}]}`), 0o644)
+	if _, err := config.LoadNotificationConfig(path); err == nil {
+		t.Error("expected an unknown webhook format to be rejected")
+	}
+
+	os.WriteFile(path, []byte(`{"enabled": true, "high_threshold": 70, "webhooks": [{"url": "https://example.com"}]}`), 0o644)
+	cfg, err = config.LoadNotificationConfig(path)
+	if err != nil || cfg.HighThreshold != 70 || cfg.CriticalThreshold != 80 {
+		t.Errorf("expected the file over the defaults, got %+v, %v", cfg, err)
+	}
+}
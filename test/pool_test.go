@@ -0,0 +1,64 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"email-mcp-server/pool"
+)
+
+func TestPoolRunCollectsErrorsByIndex(t *testing.T) {
+	var running, peak atomic.Int32
+	results := make([]int, 100)
+	errs := pool.Run(context.Background(), len(results), 3, func(ctx context.Context, i int) error {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		if i%10 == 0 {
+			return fmt.Errorf("item %d failed", i)
+		}
+		results[i] = i * i
+		return nil
+	})
+
+	if peak.Load() > 3 {
+		t.Errorf("%d items ran at once, want at most 3", peak.Load())
+	}
+	for i, err := range errs {
+		if (err != nil) != (i%10 == 0) {
+			t.Errorf("errs[%d] = %v", i, err)
+		}
+		if err == nil && results[i] != i*i {
+			t.Errorf("results[%d] = %d", i, results[i])
+		}
+	}
+	if failed, first := pool.Failed(errs); failed != 10 || first == nil || first.Error() != "item 0 failed" {
+		t.Errorf("Failed = %d, %v", failed, first)
+	}
+}
+
+func TestPoolRunStopsWhenCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := pool.Run(ctx, 50, 2, func(ctx context.Context, i int) error {
+		if i == 5 {
+			cancel()
+		}
+		return nil
+	})
+
+	failed, first := pool.Failed(errs)
+	if failed == 0 || !errors.Is(first, context.Canceled) {
+		t.Errorf("Failed = %d, %v; want the items after the cancel to fail", failed, first)
+	}
+	if errs[49] == nil {
+		t.Error("the last item should not have run")
+	}
+}
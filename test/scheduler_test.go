@@ -0,0 +1,185 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"email-mcp-server/mail"
+	"email-mcp-server/scheduler"
+	"email-mcp-server/storage"
+)
+
+func TestDispatcherRetriesUntilSent(t *testing.T) {
+	db := openTestDatabase(t)
+
+	sendAt := time.Now().Add(time.Hour)
+	email := &storage.ScheduledEmail{
+		AccountID: "work",
+		To:        []string{"you@example.com"},
+		Subject:   "Later",
+		Body:      "Sent by the dispatcher",
+		SendAt:    sendAt,
+	}
+	if err := db.CreateScheduled(email); err != nil {
+		t.Fatalf("CreateScheduled: %v", err)
+	}
+
+	ctx := context.Background()
+	var sent []*mail.OutgoingMessage
+	fail := true
+	d := scheduler.NewDispatcher(db, func(ctx context.Context, accountID string, msg *mail.OutgoingMessage) error {
+		if fail {
+			return errors.New("smtp unavailable")
+		}
+		sent = append(sent, msg)
+		return nil
+	}, time.Minute, 3)
+
+	if n := d.DispatchDue(ctx, time.Now()); n != 0 {
+		t.Fatalf("sent %d emails before send_at", n)
+	}
+
+	// First attempt fails and is retried after the backoff
+	d.DispatchDue(ctx, sendAt)
+	pending, err := db.ListScheduled("work", false)
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("ListScheduled = %v, %v", pending, err)
+	}
+	if pending[0].Attempts != 1 || pending[0].LastError == "" || !pending[0].NextAttempt.After(sendAt) {
+		t.Errorf("unexpected state after failed attempt: %+v", pending[0])
+	}
+
+	fail = false
+	if n := d.DispatchDue(ctx, sendAt.Add(2*time.Minute)); n != 1 || len(sent) != 1 {
+		t.Fatalf("expected 1 email sent, got %d", n)
+	}
+	if sent[0].Subject != "Later" || sent[0].To[0] != "you@example.com" {
+		t.Errorf("unexpected message: %+v", sent[0])
+	}
+
+	all, err := db.ListScheduled("work", true)
+	if err != nil || len(all) != 1 || all[0].Status != storage.ScheduledSent || all[0].SentAt == nil {
+		t.Errorf("ListScheduled(includeDone) = %+v, %v", all, err)
+	}
+	if err := db.CancelScheduled(email.ID); err == nil {
+		t.Error("CancelScheduled succeeded on a sent email")
+	}
+}
+
+func TestDispatcherWakesSnoozed(t *testing.T) {
+	db := openTestDatabase(t)
+
+	until := time.Now().Add(time.Hour)
+	email := &storage.SnoozedEmail{
+		AccountID:    "work",
+		Folder:       "INBOX",
+		SnoozeFolder: "Snoozed",
+		MessageID:    "<later@example.com>",
+		Subject:      "Look at this tomorrow",
+		Until:        until,
+	}
+	if err := db.CreateSnoozed(email); err != nil {
+		t.Fatalf("CreateSnoozed: %v", err)
+	}
+
+	ctx := context.Background()
+	d := scheduler.NewDispatcher(db, nil, time.Minute, 2)
+	if n := d.WakeDue(ctx, until); n != 0 {
+		t.Fatalf("woke %d emails without a waker", n)
+	}
+
+	var woken []string
+	d.SetWaker(func(ctx context.Context, e *storage.SnoozedEmail) error {
+		if e.Attempts == 0 {
+			return errors.New("imap unavailable")
+		}
+		woken = append(woken, e.MessageID)
+		return nil
+	})
+
+	if n := d.WakeDue(ctx, time.Now()); n != 0 {
+		t.Fatalf("woke %d emails before until", n)
+	}
+
+	// First attempt fails and is retried after the backoff
+	d.WakeDue(ctx, until)
+	pending, err := db.ListSnoozed("work", false)
+	if err != nil || len(pending) != 1 || pending[0].Attempts != 1 || pending[0].LastError == "" {
+		t.Fatalf("unexpected state after failed attempt: %+v, %v", pending, err)
+	}
+
+	if n := d.WakeDue(ctx, until.Add(2*time.Minute)); n != 1 || len(woken) != 1 || woken[0] != "<later@example.com>" {
+		t.Fatalf("expected the email to be woken, got %d: %v", n, woken)
+	}
+
+	if pending, _ := db.ListSnoozed("", false); len(pending) != 0 {
+		t.Errorf("woken email still pending: %+v", pending)
+	}
+	all, err := db.ListSnoozed("work", true)
+	if err != nil || len(all) != 1 || all[0].Status != storage.SnoozeWoken || all[0].WokenAt == nil {
+		t.Errorf("ListSnoozed(includeDone) = %+v, %v", all, err)
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	from := time.Date(2025, 3, 14, 9, 30, 0, 0, time.UTC) // a Friday
+
+	cases := []struct {
+		spec, want string
+	}{
+		{"0 8 * * 1-5", "2025-03-17 08:00"},  // next weekday morning
+		{"*/15 * * * *", "2025-03-14 09:45"}, // step
+		{"30 9 * * *", "2025-03-15 09:30"},   // strictly after from
+		{"0 12 1 * 0", "2025-03-16 12:00"},   // day of month OR day of week
+		{"0 0 1 1,6 *", "2025-06-01 00:00"},  // list of months
+		{"0 18 * * 7", "2025-03-16 18:00"},   // 7 is Sunday too
+	}
+	for _, c := range cases {
+		schedule, err := scheduler.ParseSchedule(c.spec)
+		if err != nil {
+			t.Fatalf("%q: %v", c.spec, err)
+		}
+		if got := schedule.Next(from).Format("2006-01-02 15:04"); got != c.want {
+			t.Errorf("%q: expected %s, got %s", c.spec, c.want, got)
+		}
+	}
+
+	for _, spec := range []string{"", "0 8 * *", "60 * * * *", "0 8 * * mon", "*/0 * * * *", "5-1 * * * *"} {
+		if _, err := scheduler.ParseSchedule(spec); err == nil {
+			t.Errorf("expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestDispatcherSkipsDueAfterCancel(t *testing.T) {
+	db := openTestDatabase(t)
+
+	sendAt := time.Now().Add(time.Hour)
+	email := &storage.ScheduledEmail{
+		AccountID: "work",
+		To:        []string{"you@example.com"},
+		Subject:   "Later",
+		SendAt:    sendAt,
+	}
+	if err := db.CreateScheduled(email); err != nil {
+		t.Fatalf("CreateScheduled: %v", err)
+	}
+
+	d := scheduler.NewDispatcher(db, func(ctx context.Context, accountID string, msg *mail.OutgoingMessage) error {
+		t.Error("send called after the context was cancelled")
+		return nil
+	}, time.Minute, 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if n := d.DispatchDue(ctx, sendAt); n != 0 {
+		t.Fatalf("sent %d emails with a cancelled context", n)
+	}
+
+	pending, err := db.ListScheduled("work", false)
+	if err != nil || len(pending) != 1 || pending[0].Attempts != 0 {
+		t.Errorf("cancelled dispatch counted an attempt: %+v, %v", pending, err)
+	}
+}
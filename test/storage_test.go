@@ -0,0 +1,1854 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"email-mcp-server/mail"
+	"email-mcp-server/storage"
+)
+
+func openTestDatabase(t *testing.T) *storage.Database {
+	t.Helper()
+
+	db, err := storage.New(filepath.Join(t.TempDir(), "data", "emails.db"))
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestDatabaseUsesWAL(t *testing.T) {
+	db := openTestDatabase(t)
+
+	mode, err := db.JournalMode()
+	if err != nil {
+		t.Fatalf("JournalMode: %v", err)
+	}
+	if mode != "wal" {
+		t.Errorf("journal mode = %q, want wal", mode)
+	}
+}
+
+func TestDatabaseCheckWritable(t *testing.T) {
+	db := openTestDatabase(t)
+
+	// The check rolls back its write, so it can run any number of times
+	for i := 0; i < 2; i++ {
+		if err := db.CheckWritable(); err != nil {
+			t.Fatalf("CheckWritable: %v", err)
+		}
+	}
+}
+
+func TestDatabaseEmailCRUD(t *testing.T) {
+	db := openTestDatabase(t)
+
+	date := time.Date(2025, 3, 14, 9, 30, 0, 0, time.UTC)
+	email := &storage.Email{
+		AccountID:   "work",
+		Folder:      "INBOX",
+		UID:         42,
+		MessageID:   "<abc@example.com>",
+		Subject:     "Quarterly report",
+		From:        "Boss <boss@example.com>",
+		To:          []string{"me@example.com", "team@example.com"},
+		Date:        date,
+		BodySnippet: "Please review the attached report",
+		Size:        2048,
+		Flags:       []string{"\\Seen"},
+	}
+	if err := db.CreateEmail(email); err != nil {
+		t.Fatalf("CreateEmail: %v", err)
+	}
+	if email.ID == 0 {
+		t.Fatal("CreateEmail did not assign an ID")
+	}
+
+	// Re-syncing the same UID updates flags rather than duplicating the row
+	updated := *email
+	updated.Flags = []string{"\\Seen", "\\Flagged"}
+	if err := db.CreateEmail(&updated); err != nil {
+		t.Fatalf("CreateEmail (update): %v", err)
+	}
+	if updated.ID != email.ID {
+		t.Errorf("upsert changed ID from %d to %d", email.ID, updated.ID)
+	}
+
+	emails, err := db.GetEmails("work", 10)
+	if err != nil {
+		t.Fatalf("GetEmails: %v", err)
+	}
+	if len(emails) != 1 {
+		t.Fatalf("expected 1 email, got %d", len(emails))
+	}
+	got := emails[0]
+	if got.Subject != email.Subject || got.From != email.From || got.MessageID != email.MessageID {
+		t.Errorf("unexpected email: %+v", got)
+	}
+	if !got.Date.Equal(date) {
+		t.Errorf("Date = %v, want %v", got.Date, date)
+	}
+	if len(got.To) != 2 || got.To[1] != "team@example.com" {
+		t.Errorf("To = %v", got.To)
+	}
+	if len(got.Flags) != 2 || got.Flags[1] != "\\Flagged" {
+		t.Errorf("Flags = %v", got.Flags)
+	}
+
+	if err := db.DeleteFolderEmails("work", "INBOX"); err != nil {
+		t.Fatalf("DeleteFolderEmails: %v", err)
+	}
+	if count, err := db.CountEmails("work"); err != nil || count != 0 {
+		t.Errorf("CountEmails = %d, %v; want 0", count, err)
+	}
+}
+
+func TestDatabaseSyncState(t *testing.T) {
+	db := openTestDatabase(t)
+
+	state, err := db.GetSyncState("work", "INBOX")
+	if err != nil || state != nil {
+		t.Fatalf("GetSyncState on empty database = %+v, %v", state, err)
+	}
+
+	saved := &storage.SyncState{
+		AccountID:   "work",
+		Folder:      "INBOX",
+		UIDValidity: 7,
+		UIDNext:     101,
+		LastUID:     100,
+		LastSync:    time.Now().UTC().Truncate(time.Second),
+	}
+	if err := db.SaveSyncState(saved); err != nil {
+		t.Fatalf("SaveSyncState: %v", err)
+	}
+
+	saved.LastUID = 150
+	saved.LastError = "connection reset"
+	if err := db.SaveSyncState(saved); err != nil {
+		t.Fatalf("SaveSyncState (update): %v", err)
+	}
+
+	state, err = db.GetSyncState("work", "INBOX")
+	if err != nil {
+		t.Fatalf("GetSyncState: %v", err)
+	}
+	if state.UIDValidity != 7 || state.LastUID != 150 || state.LastError != "connection reset" || !state.LastSync.Equal(saved.LastSync) {
+		t.Errorf("unexpected sync state: %+v", state)
+	}
+	if state.ModSeq != 0 {
+		t.Errorf("ModSeq = %d, want 0 until a CONDSTORE sync saves one", state.ModSeq)
+	}
+
+	saved.ModSeq = 90071992547409
+	if err := db.SaveSyncState(saved); err != nil {
+		t.Fatalf("SaveSyncState (modseq): %v", err)
+	}
+	if state, err = db.GetSyncState("work", "INBOX"); err != nil || state.ModSeq != saved.ModSeq {
+		t.Errorf("GetSyncState = %+v, %v; want ModSeq %d", state, err, saved.ModSeq)
+	}
+}
+
+func TestDatabaseUpdateFlags(t *testing.T) {
+	db := openTestDatabase(t)
+
+	email := &storage.Email{AccountID: "work", Folder: "INBOX", UID: 7, Subject: "Hello", Date: time.Now()}
+	if err := db.CreateEmail(email); err != nil {
+		t.Fatalf("CreateEmail: %v", err)
+	}
+
+	if err := db.UpdateFlags("work", "INBOX", 7, []string{"\\Seen", "\\Flagged"}); err != nil {
+		t.Fatalf("UpdateFlags: %v", err)
+	}
+	emails, err := db.GetEmails("work", 10)
+	if err != nil || len(emails) != 1 {
+		t.Fatalf("GetEmails = %v, %v", emails, err)
+	}
+	if got := emails[0].Flags; len(got) != 2 || got[0] != "\\Seen" || got[1] != "\\Flagged" {
+		t.Errorf("Flags = %v, want [\\Seen \\Flagged]", got)
+	}
+
+	// A change reported for a message that was never synced is ignored
+	if err := db.UpdateFlags("work", "INBOX", 8, []string{"\\Seen"}); err != nil {
+		t.Errorf("UpdateFlags of an unknown UID: %v", err)
+	}
+	if count, _ := db.CountEmails("work"); count != 1 {
+		t.Errorf("CountEmails = %d, want 1", count)
+	}
+}
+
+func TestDatabaseStarred(t *testing.T) {
+	db := openTestDatabase(t)
+
+	now := time.Now()
+	for uid, flags := range map[uint32][]string{1: nil, 2: {"\\Seen"}, 3: {"\\Flagged"}} {
+		email := &storage.Email{AccountID: "work", Folder: "INBOX", UID: uid, Subject: "Report", Flags: flags, Date: now}
+		if err := db.CreateEmail(email); err != nil {
+			t.Fatalf("CreateEmail: %v", err)
+		}
+	}
+	starred := func() []uint32 {
+		emails, err := db.GetEmails("work", 10)
+		if err != nil {
+			t.Fatalf("GetEmails: %v", err)
+		}
+		var uids []uint32
+		for _, e := range emails {
+			if e.Starred {
+				uids = append(uids, e.UID)
+			}
+		}
+		slices.Sort(uids)
+		return uids
+	}
+	if got := starred(); !slices.Equal(got, []uint32{3}) {
+		t.Errorf("starred = %v, want [3]", got)
+	}
+
+	if err := db.SetStarred("work", "INBOX", []uint32{1, 2}, true); err != nil {
+		t.Fatalf("SetStarred: %v", err)
+	}
+	if got := starred(); !slices.Equal(got, []uint32{1, 2, 3}) {
+		t.Errorf("starred = %v, want [1 2 3]", got)
+	}
+	if email, err := db.GetEmail("work", "INBOX", 2); err != nil || !slices.Equal(email.Flags, []string{"\\Seen", "\\Flagged"}) {
+		t.Errorf("GetEmail = %+v, %v; want flags [\\Seen \\Flagged]", email, err)
+	}
+
+	// Emails above the last synced UID keep their star
+	if err := db.SyncStarred("work", "INBOX", []uint32{2}, 2); err != nil {
+		t.Fatalf("SyncStarred: %v", err)
+	}
+	if got := starred(); !slices.Equal(got, []uint32{2, 3}) {
+		t.Errorf("starred = %v, want [2 3]", got)
+	}
+	if email, err := db.GetEmail("work", "INBOX", 1); err != nil || len(email.Flags) != 0 {
+		t.Errorf("GetEmail = %+v, %v; want no flags", email, err)
+	}
+
+	results, err := db.SearchEmails("report", storage.SearchFilter{AccountID: "work", Starred: true})
+	if err != nil || len(results) != 2 {
+		t.Errorf("SearchEmails(starred) = %d results, %v; want 2", len(results), err)
+	}
+
+	for uid, level := range map[uint32]string{1: "critical", 2: "low"} {
+		p := &storage.Priority{AccountID: "work", Folder: "INBOX", UID: uid, Score: 85, Level: level, ScoredAt: now}
+		if level == "low" {
+			p.Score = 25
+		}
+		if err := db.SavePriority(p); err != nil {
+			t.Fatalf("SavePriority: %v", err)
+		}
+	}
+	high := []string{"critical", "high"}
+	if inbox, err := db.PriorityInbox("work", "", high, storage.StarredFirst, 10); err != nil || len(inbox) != 2 || inbox[0].UID != 2 || inbox[1].UID != 1 {
+		t.Errorf("PriorityInbox(first) = %+v, %v; want the starred low email, then the critical one", inbox, err)
+	}
+	if inbox, err := db.PriorityInbox("work", "", high, storage.StarredOnly, 10); err != nil || len(inbox) != 0 {
+		t.Errorf("PriorityInbox(only) = %+v, %v; want none", inbox, err)
+	}
+	if inbox, err := db.PriorityInbox("work", "", append(high, "medium", "low"), storage.StarredOnly, 10); err != nil || len(inbox) != 1 || inbox[0].UID != 2 {
+		t.Errorf("PriorityInbox(only, low) = %+v, %v; want email 2", inbox, err)
+	}
+}
+
+func TestDatabaseCreateEmailsBatch(t *testing.T) {
+	db := openTestDatabase(t)
+
+	base := time.Date(2025, 3, 14, 9, 0, 0, 0, time.UTC)
+	emails := []*storage.Email{
+		// A reply before the message it answers, all in the same batch
+		{UID: 3, MessageID: "<c@x>", InReplyTo: "<b@x>", References: []string{"<a@x>", "<b@x>"}, Date: base.Add(2 * time.Hour)},
+		{UID: 1, MessageID: "<a@x>", Date: base},
+		{UID: 2, MessageID: "<b@x>", InReplyTo: "<a@x>", Date: base.Add(time.Hour)},
+	}
+	// More than one INSERT worth of emails from the same sender
+	for uid := uint32(10); uid < 80; uid++ {
+		emails = append(emails, &storage.Email{UID: uid, MessageID: fmt.Sprintf("<%d@x>", uid), From: "ana@corp.com",
+			Subject: fmt.Sprintf("Note %d", uid), Date: base.Add(time.Duration(uid) * time.Minute)})
+	}
+	for _, e := range emails {
+		e.AccountID, e.Folder = "work", "INBOX"
+	}
+	if err := db.CreateEmails(emails); err != nil {
+		t.Fatalf("CreateEmails: %v", err)
+	}
+
+	if count, _ := db.CountEmails("work"); count != len(emails) {
+		t.Errorf("CountEmails = %d, want %d", count, len(emails))
+	}
+	thread, err := db.GetThread("work", emails[0].ThreadID)
+	if err != nil || len(thread) != 3 {
+		t.Fatalf("GetThread = %d emails, %v; want 3", len(thread), err)
+	}
+	if ana, _ := db.ContactByAddress("ana@corp.com"); ana == nil || ana.ReceivedCount != 70 {
+		t.Errorf("expected 70 emails from ana, got %+v", ana)
+	}
+	for _, e := range emails {
+		if e.ID == 0 {
+			t.Fatalf("email %d got no ID", e.UID)
+		}
+	}
+
+	// Syncing the batch again keeps the rows and counts nothing twice
+	first := emails[0].ID
+	emails[0].Flags = []string{"\\Seen"}
+	if err := db.CreateEmails(emails); err != nil {
+		t.Fatalf("CreateEmails (again): %v", err)
+	}
+	if emails[0].ID != first {
+		t.Errorf("upsert changed ID from %d to %d", first, emails[0].ID)
+	}
+	if ana, _ := db.ContactByAddress("ana@corp.com"); ana == nil || ana.ReceivedCount != 70 {
+		t.Errorf("expected 70 emails from ana after re-sync, got %+v", ana)
+	}
+
+	flags := map[uint32][]string{1: {"\\Flagged"}, 2: {"\\Seen"}, 99: {"\\Seen"}}
+	if err := db.UpdateAllFlags("work", "INBOX", flags); err != nil {
+		t.Fatalf("UpdateAllFlags: %v", err)
+	}
+	if e, err := db.GetEmail("work", "INBOX", 1); err != nil || len(e.Flags) != 1 || e.Flags[0] != "\\Flagged" {
+		t.Errorf("flags of email 1 = %v, %v", e, err)
+	}
+}
+
+func TestDatabaseSearchEmails(t *testing.T) {
+	db := openTestDatabase(t)
+
+	emails := []*storage.Email{
+		{AccountID: "work", Folder: "INBOX", UID: 1, Subject: "Invoice March", From: "billing@vendor.com",
+			BodySnippet: "Your invoice is attached", Date: time.Now().Add(-48 * time.Hour)},
+		{AccountID: "work", Folder: "INBOX", UID: 2, Subject: "Lunch?", From: "friend@example.com",
+			BodySnippet: "Are you free for lunch? I paid the invoice yesterday", Date: time.Now()},
+		{AccountID: "personal", Folder: "INBOX", UID: 1, Subject: "Reunión de equipo", From: "ana@example.com",
+			BodySnippet: "Nos vemos el lunes en la oficina", Date: time.Now()},
+	}
+	for _, email := range emails {
+		if err := db.CreateEmail(email); err != nil {
+			t.Fatalf("CreateEmail: %v", err)
+		}
+	}
+
+	results, err := db.SearchEmails("invoice", storage.SearchFilter{})
+	if err != nil {
+		t.Fatalf("SearchEmails: %v", err)
+	}
+	if len(results) != 2 || results[0].Subject != "Invoice March" {
+		t.Fatalf("subject match should rank first, got %+v", results)
+	}
+
+	// Pages follow the same ranking
+	page, err := db.SearchEmails("invoice", storage.SearchFilter{Limit: 1, Offset: 1})
+	if err != nil || len(page) != 1 || page[0].UID != results[1].UID {
+		t.Errorf("second page = %+v, %v; want UID %d", page, err, results[1].UID)
+	}
+	if page, _ := db.SearchEmails("invoice", storage.SearchFilter{Offset: 2}); len(page) != 0 {
+		t.Errorf("page past the end = %+v", page)
+	}
+
+	results, err = db.SearchEmails(`"paid the invoice"`, storage.SearchFilter{})
+	if err != nil || len(results) != 1 || results[0].UID != 2 {
+		t.Errorf("phrase search = %+v, %v", results, err)
+	}
+
+	results, err = db.SearchEmails("reunion", storage.SearchFilter{AccountID: "personal"})
+	if err != nil || len(results) != 1 {
+		t.Errorf("diacritic-insensitive search = %+v, %v", results, err)
+	}
+
+	results, err = db.SearchEmails("lun*", storage.SearchFilter{AccountID: "work"})
+	if err != nil || len(results) != 1 || results[0].UID != 2 {
+		t.Errorf("prefix search = %+v, %v", results, err)
+	}
+
+	// Stray FTS syntax must not turn into a query error
+	if _, err := db.SearchEmails(`invoice AND ( "unterminated`, storage.SearchFilter{}); err != nil {
+		t.Errorf("malformed query returned error: %v", err)
+	}
+
+	if err := db.DeleteFolderEmails("work", "INBOX"); err != nil {
+		t.Fatalf("DeleteFolderEmails: %v", err)
+	}
+	results, err = db.SearchEmails("invoice", storage.SearchFilter{})
+	if err != nil || len(results) != 0 {
+		t.Errorf("deleted emails still indexed: %+v, %v", results, err)
+	}
+}
+
+func TestDatabaseDrafts(t *testing.T) {
+	db := openTestDatabase(t)
+
+	draft := &storage.Draft{
+		AccountID: "work",
+		MessageID: "<draft1@example.com>",
+		To:        []string{"a@example.com", "b@example.com"},
+		Subject:   "Proposal",
+		Body:      "First version",
+	}
+	if err := db.CreateDraft(draft); err != nil {
+		t.Fatalf("CreateDraft: %v", err)
+	}
+	if draft.ID == 0 {
+		t.Fatal("CreateDraft did not assign an ID")
+	}
+
+	draft.Body = "Second version"
+	draft.Cc = []string{"c@example.com"}
+	if err := db.UpdateDraft(draft); err != nil {
+		t.Fatalf("UpdateDraft: %v", err)
+	}
+
+	got, err := db.GetDraft(draft.ID)
+	if err != nil {
+		t.Fatalf("GetDraft: %v", err)
+	}
+	if got.Body != "Second version" || len(got.To) != 2 || len(got.Cc) != 1 || got.MessageID != draft.MessageID {
+		t.Errorf("unexpected draft: %+v", got)
+	}
+
+	if drafts, err := db.ListDrafts("home"); err != nil || len(drafts) != 0 {
+		t.Errorf("ListDrafts(home) = %v, %v", drafts, err)
+	}
+	if drafts, err := db.ListDrafts(""); err != nil || len(drafts) != 1 {
+		t.Errorf("ListDrafts() = %v, %v", drafts, err)
+	}
+
+	if err := db.DeleteDraft(draft.ID); err != nil {
+		t.Fatalf("DeleteDraft: %v", err)
+	}
+	if _, err := db.GetDraft(draft.ID); err == nil {
+		t.Error("GetDraft succeeded after DeleteDraft")
+	}
+}
+
+func TestDatabaseTemplates(t *testing.T) {
+	db := openTestDatabase(t)
+
+	tmpl := &storage.Template{Name: "invoice-ack", Subject: "Re: Invoice {{invoice}}", Body: "Hi {{ name }}, invoice {{invoice}} received."}
+	if err := db.SaveTemplate(tmpl); err != nil {
+		t.Fatalf("SaveTemplate: %v", err)
+	}
+	created := tmpl.CreatedAt
+
+	tmpl.Body = "Hi {{name}}, we received invoice {{invoice}} for {{amount}}."
+	if err := db.SaveTemplate(tmpl); err != nil {
+		t.Fatalf("SaveTemplate (replace): %v", err)
+	}
+	if templates, err := db.ListTemplates(); err != nil || len(templates) != 1 {
+		t.Fatalf("ListTemplates = %v, %v", templates, err)
+	}
+
+	got, err := db.GetTemplate("invoice-ack")
+	if err != nil {
+		t.Fatalf("GetTemplate: %v", err)
+	}
+	if got.Body != tmpl.Body || !got.CreatedAt.Equal(created) {
+		t.Errorf("unexpected template: %+v", got)
+	}
+	if placeholders := got.Placeholders(); len(placeholders) != 3 || placeholders[0] != "invoice" || placeholders[1] != "name" {
+		t.Errorf("Placeholders = %v", placeholders)
+	}
+
+	subject, body, missing := got.Render(map[string]string{"invoice": "F-1024", "name": "Ana"})
+	if subject != "Re: Invoice F-1024" || body != "Hi Ana, we received invoice F-1024 for {{amount}}." {
+		t.Errorf("Render = %q, %q", subject, body)
+	}
+	if len(missing) != 1 || missing[0] != "amount" {
+		t.Errorf("missing = %v", missing)
+	}
+
+	if err := db.DeleteTemplate("invoice-ack"); err != nil {
+		t.Fatalf("DeleteTemplate: %v", err)
+	}
+	if _, err := db.GetTemplate("invoice-ack"); err == nil {
+		t.Error("GetTemplate succeeded after DeleteTemplate")
+	}
+}
+
+func TestDatabaseAutoresponder(t *testing.T) {
+	db := openTestDatabase(t)
+
+	if responder, err := db.GetAutoresponder("work"); err != nil || responder != nil {
+		t.Fatalf("GetAutoresponder before enabling = %+v, %v", responder, err)
+	}
+	if err := db.SetAutoresponderEnabled("work", false); err == nil {
+		t.Error("SetAutoresponderEnabled succeeded without an autoresponder")
+	}
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	until := start.Add(48 * time.Hour)
+	err := db.SaveAutoresponder(&storage.Autoresponder{
+		AccountID: "work", Enabled: true, Body: "Away until {{until}}", MatchFrom: "@client.com", Start: start, Until: &until,
+	})
+	if err != nil {
+		t.Fatalf("SaveAutoresponder: %v", err)
+	}
+
+	responder, err := db.GetAutoresponder("work")
+	if err != nil || responder == nil {
+		t.Fatalf("GetAutoresponder = %+v, %v", responder, err)
+	}
+	if !responder.Start.Equal(start) || responder.Until == nil || !responder.Until.Equal(until) || responder.Interval() != 7*24*time.Hour {
+		t.Errorf("unexpected autoresponder: %+v", responder)
+	}
+	if !responder.Active(time.Now()) || responder.Active(start.Add(-time.Minute)) || responder.Active(until) {
+		t.Error("Active does not follow the start and until times")
+	}
+	client := &storage.Email{Subject: "Invoice", FromAddress: mail.Address{Address: "Ana@Client.com"}}
+	other := &storage.Email{Subject: "Invoice", FromAddress: mail.Address{Address: "bob@example.com"}}
+	if !responder.Matches(client) || responder.Matches(other) {
+		t.Error("Matches does not apply match_from")
+	}
+
+	if err := db.SetAutoresponderEnabled("work", false); err != nil {
+		t.Fatalf("SetAutoresponderEnabled: %v", err)
+	}
+	if responder, _ := db.GetAutoresponder("work"); responder.Enabled || responder.Body != "Away until {{until}}" {
+		t.Errorf("disabled autoresponder = %+v", responder)
+	}
+
+	if last, err := db.LastAutoreply("work", "ana@client.com"); err != nil || !last.IsZero() {
+		t.Errorf("LastAutoreply before replying = %v, %v", last, err)
+	}
+	repliedAt := time.Now().Truncate(time.Second)
+	if err := db.RecordAutoreply("work", "Ana@Client.com", "<auto1@example.com>", repliedAt); err != nil {
+		t.Fatalf("RecordAutoreply: %v", err)
+	}
+	if last, err := db.LastAutoreply("work", "ana@client.com"); err != nil || !last.Equal(repliedAt) {
+		t.Errorf("LastAutoreply = %v, %v, want %v", last, err, repliedAt)
+	}
+}
+
+func TestDatabaseAppliedActions(t *testing.T) {
+	db := openTestDatabase(t)
+
+	now := time.Now()
+	for i, subject := range []string{"Old sale", "New sale"} {
+		email := &storage.Email{
+			AccountID: "work", Folder: "INBOX", UID: uint32(i + 1), From: "shop@example.com",
+			Subject: subject, Date: now.AddDate(0, 0, -40+35*i),
+		}
+		if err := db.CreateEmail(email); err != nil {
+			t.Fatalf("CreateEmail: %v", err)
+		}
+	}
+	db.SaveClassification(&storage.Classification{AccountID: "work", Folder: "INBOX", UID: 1, Category: "promotions", Method: "rules", ClassifiedAt: now})
+
+	candidates, err := db.ActionCandidates("work", "INBOX", "old-promotions", false, now.AddDate(0, 0, -30))
+	if err != nil {
+		t.Fatalf("ActionCandidates: %v", err)
+	}
+	if len(candidates) != 1 || candidates[0].UID != 1 || candidates[0].Category != "promotions" {
+		t.Fatalf("candidates = %+v", candidates)
+	}
+
+	// A dry run does not keep the rule from applying for real later
+	dryRun := []storage.AppliedAction{{AccountID: "work", Folder: "INBOX", UID: 1, Rule: "old-promotions", Action: "archive", DryRun: true}}
+	if err := db.RecordAppliedActions(dryRun); err != nil {
+		t.Fatalf("RecordAppliedActions: %v", err)
+	}
+	if candidates, _ := db.ActionCandidates("work", "INBOX", "old-promotions", true, time.Time{}); len(candidates) != 1 || candidates[0].UID != 2 {
+		t.Errorf("dry run candidates = %+v", candidates)
+	}
+	if candidates, _ := db.ActionCandidates("work", "INBOX", "old-promotions", false, time.Time{}); len(candidates) != 2 {
+		t.Errorf("candidates after a dry run = %+v", candidates)
+	}
+
+	// An archived email is left alone by every rule
+	archived := []storage.AppliedAction{{AccountID: "work", Folder: "INBOX", UID: 1, Rule: "old-promotions", Action: "archive", Destination: "Archive"}}
+	if err := db.RecordAppliedActions(archived); err != nil {
+		t.Fatalf("RecordAppliedActions: %v", err)
+	}
+	if candidates, _ := db.ActionCandidates("work", "INBOX", "star-vips", false, time.Time{}); len(candidates) != 1 || candidates[0].UID != 2 {
+		t.Errorf("candidates after archiving = %+v", candidates)
+	}
+
+	actions, err := db.ListAppliedActions("work", "old-promotions", time.Time{}, 10)
+	if err != nil || len(actions) != 2 {
+		t.Fatalf("ListAppliedActions = %+v, %v", actions, err)
+	}
+	if actions[0].DryRun || actions[0].Destination != "Archive" || !actions[1].DryRun {
+		t.Errorf("unexpected audit entries: %+v", actions)
+	}
+}
+
+func TestDatabaseAudit(t *testing.T) {
+	db := openTestDatabase(t)
+
+	now := time.Now()
+	entries := []*storage.AuditEntry{
+		{Time: now.AddDate(0, 0, -100), Actor: "client:claude-desktop", Operation: "delete_email", AccountID: "work", Success: true},
+		{Time: now.Add(-time.Hour), Actor: "action_rule:old-promotions", Operation: "bulk_action", AccountID: "work", Success: true,
+			Arguments: json.RawMessage(`{"action":"archive","uids":[1,2]}`)},
+		{Time: now, Actor: "client:claude-desktop", Operation: "send_email", AccountID: "home", Error: "550 mailbox unavailable"},
+	}
+	for _, e := range entries {
+		if err := db.RecordAudit(e); err != nil {
+			t.Fatalf("RecordAudit: %v", err)
+		}
+	}
+
+	all, err := db.ListAudit(storage.AuditFilter{})
+	if err != nil || len(all) != 3 {
+		t.Fatalf("ListAudit = %+v, %v", all, err)
+	}
+	if all[0].Operation != "send_email" || all[2].Operation != "delete_email" {
+		t.Errorf("entries not newest first: %+v", all)
+	}
+	if string(all[1].Arguments) != `{"action":"archive","uids":[1,2]}` {
+		t.Errorf("arguments = %s", all[1].Arguments)
+	}
+
+	if failed, _ := db.ListAudit(storage.AuditFilter{ErrorsOnly: true}); len(failed) != 1 || failed[0].Error != "550 mailbox unavailable" {
+		t.Errorf("failed entries = %+v", failed)
+	}
+	if rules, _ := db.ListAudit(storage.AuditFilter{AccountID: "work", Actor: "action_rule"}); len(rules) != 1 || rules[0].Operation != "bulk_action" {
+		t.Errorf("action rule entries = %+v", rules)
+	}
+	if recent, _ := db.ListAudit(storage.AuditFilter{Since: now.AddDate(0, 0, -1)}); len(recent) != 2 {
+		t.Errorf("recent entries = %+v", recent)
+	}
+
+	pruned, err := db.PruneAudit(now.AddDate(0, 0, -90))
+	if err != nil || pruned != 1 {
+		t.Fatalf("PruneAudit = %d, %v", pruned, err)
+	}
+	if rest, _ := db.ListAudit(storage.AuditFilter{Operation: "delete_email"}); len(rest) != 0 {
+		t.Errorf("entry older than the retention kept: %+v", rest)
+	}
+}
+
+func TestDatabaseThreads(t *testing.T) {
+	db := openTestDatabase(t)
+
+	base := time.Date(2025, 3, 14, 9, 0, 0, 0, time.UTC)
+	emails := []*storage.Email{
+		// The second reply is synced before the message it answers
+		{UID: 3, MessageID: "<c@x>", InReplyTo: "<b@x>", References: []string{"<a@x>", "<b@x>"}, Date: base.Add(2 * time.Hour)},
+		{UID: 1, MessageID: "<a@x>", Date: base},
+		{UID: 2, MessageID: "<b@x>", InReplyTo: "<a@x>", Date: base.Add(time.Hour)},
+		{UID: 4, MessageID: "<other@x>", Subject: "Unrelated", Date: base},
+	}
+	for _, e := range emails {
+		e.AccountID, e.Folder = "work", "INBOX"
+		if err := db.CreateEmail(e); err != nil {
+			t.Fatalf("CreateEmail: %v", err)
+		}
+	}
+
+	first, err := db.GetEmail("work", "INBOX", 2)
+	if err != nil {
+		t.Fatalf("GetEmail: %v", err)
+	}
+	thread, err := db.GetThread("work", first.ThreadID)
+	if err != nil {
+		t.Fatalf("GetThread: %v", err)
+	}
+	if len(thread) != 3 {
+		t.Fatalf("expected 3 messages in thread, got %d", len(thread))
+	}
+	for i, want := range []uint32{1, 2, 3} {
+		if thread[i].UID != want {
+			t.Errorf("thread[%d].UID = %d, want %d", i, thread[i].UID, want)
+		}
+	}
+	if emails[3].ThreadID == first.ThreadID {
+		t.Error("unrelated message joined the thread")
+	}
+}
+
+func TestDatabaseClassificationTags(t *testing.T) {
+	db := openTestDatabase(t)
+
+	c := &storage.Classification{
+		AccountID: "work", Folder: "INBOX", UID: 7,
+		Category: "invoice", Confidence: 0.85, Tags: []string{"finance", "urgent"},
+		Method: "hybrid", ClassifiedAt: time.Now(),
+	}
+	if err := db.SaveClassification(c); err != nil {
+		t.Fatalf("SaveClassification: %v", err)
+	}
+
+	got, err := db.GetClassification("work", "INBOX", 7)
+	if err != nil || got == nil {
+		t.Fatalf("GetClassification = %v, %v", got, err)
+	}
+	if got.Category != "invoice" || got.Method != "hybrid" || len(got.Tags) != 2 || got.Tags[1] != "urgent" {
+		t.Errorf("unexpected classification: %+v", got)
+	}
+
+	// Tags are stored as JSON, so separators and quotes survive
+	c.Tags = []string{"a,b", `say "hi"`, "needs_review"}
+	if err := db.SaveClassification(c); err != nil {
+		t.Fatalf("SaveClassification: %v", err)
+	}
+	if got, _ := db.GetClassification("work", "INBOX", 7); !reflect.DeepEqual(got.Tags, c.Tags) {
+		t.Errorf("tags = %q, want %q", got.Tags, c.Tags)
+	}
+	if missing, err := db.GetClassification("work", "INBOX", 8); missing != nil || err != nil {
+		t.Errorf("GetClassification(missing) = %v, %v", missing, err)
+	}
+}
+
+func TestDatabasePriorityFactors(t *testing.T) {
+	db := openTestDatabase(t)
+
+	p := &storage.Priority{
+		AccountID: "work", Folder: "INBOX", UID: 7, Score: 70, Level: "high",
+		Factors:  map[string]int{"base": 30, "vip_sender": 30, "deadline": 25, "newsletter": -15},
+		ScoredAt: time.Now(),
+	}
+	if err := db.SavePriority(p); err != nil {
+		t.Fatalf("SavePriority: %v", err)
+	}
+	got, err := db.GetPriority("work", "INBOX", 7)
+	if err != nil || got == nil {
+		t.Fatalf("GetPriority = %v, %v", got, err)
+	}
+	if got.Score != 70 || got.Level != "high" || !reflect.DeepEqual(got.Factors, p.Factors) {
+		t.Errorf("GetPriority = %+v, want factors %v", got, p.Factors)
+	}
+
+	// Rescoring replaces the factors rather than merging them
+	p.Factors = map[string]int{"base": 30}
+	if err := db.SavePriority(p); err != nil {
+		t.Fatalf("SavePriority: %v", err)
+	}
+	if got, _ := db.GetPriority("work", "INBOX", 7); !reflect.DeepEqual(got.Factors, p.Factors) {
+		t.Errorf("factors after rescoring = %v, want %v", got.Factors, p.Factors)
+	}
+
+	p.UID, p.Factors = 8, nil
+	if err := db.SavePriority(p); err != nil {
+		t.Fatalf("SavePriority: %v", err)
+	}
+	if got, err := db.GetPriority("work", "INBOX", 8); err != nil || len(got.Factors) != 0 {
+		t.Errorf("GetPriority without factors = %+v, %v", got, err)
+	}
+	if missing, err := db.GetPriority("work", "INBOX", 9); missing != nil || err != nil {
+		t.Errorf("GetPriority(missing) = %v, %v", missing, err)
+	}
+}
+
+func TestDatabaseLabelTags(t *testing.T) {
+	db := openTestDatabase(t)
+
+	c := &storage.Classification{
+		AccountID: "work", Folder: "INBOX", UID: 7,
+		Category: "invoice", Confidence: 0.85, Tags: []string{"finance"},
+		Method: "rules", ClassifiedAt: time.Now(),
+	}
+	if err := db.SaveClassification(c); err != nil {
+		t.Fatalf("SaveClassification: %v", err)
+	}
+
+	// Emails that were never classified are skipped
+	labels := map[uint32][]string{7: {"Receipts", `\Important`}, 8: {"Receipts"}}
+	if err := db.SaveLabels("work", "INBOX", labels); err != nil {
+		t.Fatalf("SaveLabels: %v", err)
+	}
+	got, _ := db.GetClassification("work", "INBOX", 7)
+	if want := []string{"finance", "label:Receipts", `label:\Important`}; !slices.Equal(got.Tags, want) {
+		t.Errorf("tags = %v, want %v", got.Tags, want)
+	}
+	if missing, _ := db.GetClassification("work", "INBOX", 8); missing != nil {
+		t.Errorf("unclassified email got a classification: %+v", missing)
+	}
+
+	// Labels replace the previous ones and survive reclassification
+	if err := db.SaveLabels("work", "INBOX", map[uint32][]string{7: {"Paid"}}); err != nil {
+		t.Fatalf("SaveLabels: %v", err)
+	}
+	c.Category, c.Tags = "general", []string{"newsletter"}
+	if err := db.SaveClassification(c); err != nil {
+		t.Fatalf("SaveClassification: %v", err)
+	}
+	got, _ = db.GetClassification("work", "INBOX", 7)
+	if want := []string{"newsletter", "label:Paid"}; got.Category != "general" || !slices.Equal(got.Tags, want) {
+		t.Errorf("reclassified = %s %v, want general %v", got.Category, got.Tags, want)
+	}
+}
+
+func TestDatabaseReviewQueue(t *testing.T) {
+	db := openTestDatabase(t)
+
+	now := time.Now()
+	classifications := []*storage.Classification{
+		{UID: 1, Category: "general", Confidence: 0, Tags: []string{"needs_review"}, Method: "rules"},
+		{UID: 2, Category: "work", Confidence: 0.4, Tags: []string{"urgent", "needs_review"}, Method: "rules"},
+		{UID: 3, Category: "invoice", Confidence: 0.9, Tags: []string{"finance"}, Method: "rules"},
+		// Classifications of emails that are not synced are left out
+		{UID: 9, Category: "general", Confidence: 0, Tags: []string{"needs_review"}, Method: "rules"},
+	}
+	for _, c := range classifications {
+		if c.UID < 9 {
+			email := &storage.Email{AccountID: "work", Folder: "INBOX", UID: c.UID, Subject: "Email", From: "a@example.com", Date: now}
+			if err := db.CreateEmail(email); err != nil {
+				t.Fatalf("CreateEmail: %v", err)
+			}
+		}
+		c.AccountID, c.Folder, c.ClassifiedAt = "work", "INBOX", now
+		if err := db.SaveClassification(c); err != nil {
+			t.Fatalf("SaveClassification: %v", err)
+		}
+	}
+
+	items, total, err := db.ReviewQueue("work", "needs_review", 1)
+	if err != nil {
+		t.Fatalf("ReviewQueue: %v", err)
+	}
+	if total != 2 || len(items) != 1 || items[0].Email.UID != 1 || items[0].Classification.Category != "general" {
+		t.Fatalf("unexpected review queue: %d, %+v", total, items)
+	}
+
+	// A correction replaces the classification and its tags
+	if err := db.SaveClassification(&storage.Classification{AccountID: "work", Folder: "INBOX", UID: 1,
+		Category: "personal", Confidence: 1, Method: "feedback", ClassifiedAt: now}); err != nil {
+		t.Fatalf("SaveClassification: %v", err)
+	}
+	items, total, err = db.ReviewQueue("work", "needs_review", 10)
+	if err != nil || total != 1 || items[0].Email.UID != 2 || len(items[0].Classification.Tags) != 2 {
+		t.Errorf("unexpected review queue after a correction: %d, %+v, %v", total, items, err)
+	}
+}
+
+func TestDatabasePriorityDistribution(t *testing.T) {
+	db := openTestDatabase(t)
+
+	now := time.Now()
+	for uid := uint32(1); uid <= 4; uid++ {
+		email := &storage.Email{AccountID: "work", Folder: "INBOX", UID: uid, Subject: "Email", From: "a@example.com", Date: now}
+		if err := db.CreateEmail(email); err != nil {
+			t.Fatalf("CreateEmail: %v", err)
+		}
+	}
+
+	priorities := []*storage.Priority{
+		{UID: 1, Score: 85, Level: "critical", Factors: map[string]int{"base": 30, "vip_sender": 30, "urgent": 25}},
+		{UID: 2, Score: 55, Level: "medium", Factors: map[string]int{"base": 30, "urgent": 25}},
+		{UID: 3, Score: 45, Level: "medium", Factors: map[string]int{"base": 30, "flagged": 15}},
+		// Scores of emails no longer synced are left out
+		{UID: 9, Score: 0, Level: "minimal", Factors: map[string]int{"base": 30, "spam": -30}},
+	}
+	for _, p := range priorities {
+		p.AccountID, p.Folder, p.ScoredAt = "work", "INBOX", now
+		if err := db.SavePriority(p); err != nil {
+			t.Fatalf("SavePriority: %v", err)
+		}
+	}
+	// Rescoring replaces the previous score
+	priorities[2].Score, priorities[2].Level = 40, "medium"
+	if err := db.SavePriority(priorities[2]); err != nil {
+		t.Fatalf("SavePriority: %v", err)
+	}
+
+	dist, err := db.PriorityDistribution("work")
+	if err != nil {
+		t.Fatalf("PriorityDistribution: %v", err)
+	}
+	if dist.Scored != 3 || dist.Unscored != 1 || dist.AverageScore != 60 {
+		t.Errorf("scored %d, unscored %d, average %v", dist.Scored, dist.Unscored, dist.AverageScore)
+	}
+	if len(dist.Levels) != 2 || dist.Levels[0].Level != "critical" || dist.Levels[1].Count != 2 || dist.Levels[1].AverageScore != 47.5 {
+		t.Errorf("unexpected levels: %+v", dist.Levels)
+	}
+	if len(dist.Factors) != 3 || dist.Factors[0] != (storage.FactorCount{Factor: "urgent", Count: 2}) {
+		t.Errorf("unexpected factors: %+v", dist.Factors)
+	}
+
+	got, err := db.GetPriority("work", "INBOX", 1)
+	if err != nil || got == nil || got.Score != 85 || got.Factors["vip_sender"] != 30 {
+		t.Errorf("GetPriority = %+v, %v", got, err)
+	}
+	if empty, err := db.PriorityDistribution("personal"); err != nil || empty.Scored != 0 || len(empty.Levels) != 0 {
+		t.Errorf("PriorityDistribution(personal) = %+v, %v", empty, err)
+	}
+
+	inbox, err := db.PriorityInbox("work", "", []string{"critical", "high", "medium"}, "", 10)
+	if err != nil {
+		t.Fatalf("PriorityInbox: %v", err)
+	}
+	if len(inbox) != 3 || inbox[0].UID != 1 || inbox[0].Level != "critical" || inbox[2].UID != 3 || inbox[2].Score != 40 {
+		t.Errorf("unexpected priority inbox: %+v", inbox)
+	}
+	if folder, err := db.PriorityInbox("work", "INBOX", []string{"critical", "high", "medium"}, "", 10); err != nil || len(folder) != 3 {
+		t.Errorf("PriorityInbox(INBOX) = %d emails, %v; want 3", len(folder), err)
+	}
+	if other, err := db.PriorityInbox("work", "Invoices", []string{"critical", "high", "medium"}, "", 10); err != nil || len(other) != 0 {
+		t.Errorf("PriorityInbox(Invoices) = %+v, %v; want none", other, err)
+	}
+	// An email synced after it was scored is found by its stored score
+	if err := db.CreateEmail(&storage.Email{AccountID: "work", Folder: "INBOX", UID: 9, Subject: "Spam", Date: now}); err != nil {
+		t.Fatalf("CreateEmail: %v", err)
+	}
+	if minimal, err := db.PriorityInbox("work", "", []string{"minimal"}, "", 10); err != nil || len(minimal) != 1 || minimal[0].UID != 9 {
+		t.Errorf("PriorityInbox(minimal) = %+v, %v", minimal, err)
+	}
+}
+
+func TestDatabaseThreadPriorities(t *testing.T) {
+	db := openTestDatabase(t)
+
+	now := time.Now().Truncate(time.Second)
+	emails := []*storage.Email{
+		{AccountID: "work", Folder: "INBOX", UID: 1, ThreadID: "budget", Subject: "Budget", Date: now.Add(-3 * time.Hour), Flags: []string{"\\Seen"}},
+		{AccountID: "work", Folder: "INBOX", UID: 2, ThreadID: "budget", Subject: "Re: Budget", Date: now.Add(-time.Hour)},
+		{AccountID: "work", Folder: "INBOX", UID: 3, ThreadID: "budget", Subject: "Re: Budget", Date: now.Add(-2 * time.Hour)},
+		{AccountID: "work", Folder: "INBOX", UID: 4, ThreadID: "lunch", Subject: "Lunch", Date: now.AddDate(0, 0, -40)},
+	}
+	for _, email := range emails {
+		if err := db.CreateEmail(email); err != nil {
+			t.Fatalf("CreateEmail: %v", err)
+		}
+	}
+	for uid, score := range map[uint32]int{1: 80, 2: 40} {
+		p := &storage.Priority{AccountID: "work", Folder: "INBOX", UID: uid, Score: score, Level: "medium", ScoredAt: now}
+		if err := db.SavePriority(p); err != nil {
+			t.Fatalf("SavePriority: %v", err)
+		}
+	}
+
+	threads, err := db.ThreadPriorities("work", now.AddDate(0, 0, -30))
+	if err != nil {
+		t.Fatalf("ThreadPriorities: %v", err)
+	}
+	if len(threads) != 1 {
+		t.Fatalf("expected only the recent thread, got %+v", threads)
+	}
+	budget := threads[0]
+	if budget.ThreadID != "budget" || budget.Subject != "Budget" || budget.Messages != 3 || budget.Scored != 2 ||
+		budget.Unread != 2 || budget.MaxScore != 80 || budget.MeanScore != 60 || !budget.LastActivity.Equal(now.Add(-time.Hour)) {
+		t.Errorf("unexpected rollup: %+v", budget)
+	}
+
+	// Reading a message updates the unread count on the next sync
+	emails[1].Flags = []string{"\\Seen"}
+	if err := db.CreateEmail(emails[1]); err != nil {
+		t.Fatalf("CreateEmail: %v", err)
+	}
+	threads, _ = db.ThreadPriorities("work", now.AddDate(0, 0, -60))
+	if len(threads) != 2 || threads[0].Unread != 1 || threads[1].ThreadID != "lunch" || threads[1].Scored != 0 {
+		t.Errorf("unexpected rollups after a sync: %+v", threads)
+	}
+
+	if err := db.DeleteFolderEmails("work", "INBOX"); err != nil {
+		t.Fatalf("DeleteFolderEmails: %v", err)
+	}
+	if threads, err := db.ThreadPriorities("work", time.Time{}); err != nil || len(threads) != 0 {
+		t.Errorf("expected no rollups once the folder is gone, got %+v, %v", threads, err)
+	}
+}
+
+func TestDatabaseDeadlines(t *testing.T) {
+	db := openTestDatabase(t)
+
+	now := time.Now()
+	for uid := uint32(1); uid <= 2; uid++ {
+		email := &storage.Email{AccountID: "work", Folder: "INBOX", UID: uid, Subject: "Report", From: "a@example.com", Date: now}
+		if err := db.CreateEmail(email); err != nil {
+			t.Fatalf("CreateEmail: %v", err)
+		}
+	}
+
+	save := func(uid uint32, deadlines ...storage.Deadline) {
+		t.Helper()
+		if err := db.SaveDeadlines("work", "INBOX", uid, deadlines); err != nil {
+			t.Fatalf("SaveDeadlines: %v", err)
+		}
+	}
+	save(1, storage.Deadline{Phrase: "by Friday", Due: now.Add(48 * time.Hour)},
+		storage.Deadline{Phrase: "due next month", Due: now.Add(40 * 24 * time.Hour)})
+	save(2, storage.Deadline{Phrase: "by yesterday", Due: now.Add(-24 * time.Hour)})
+	// Saving again replaces the previous deadlines
+	save(2, storage.Deadline{Phrase: "EOD tomorrow", Due: now.Add(24 * time.Hour)})
+
+	deadlines, err := db.UpcomingDeadlines("work", "", now, now.Add(7*24*time.Hour), 10)
+	if err != nil {
+		t.Fatalf("UpcomingDeadlines: %v", err)
+	}
+	if len(deadlines) != 2 || deadlines[0].Phrase != "EOD tomorrow" || deadlines[1].Email.UID != 1 {
+		t.Fatalf("unexpected deadlines: %+v", deadlines)
+	}
+	if deadlines[0].Email.Subject != "Report" {
+		t.Errorf("expected the email to be joined, got %+v", deadlines[0].Email)
+	}
+
+	if err := db.DeleteFolderEmails("work", "INBOX"); err != nil {
+		t.Fatalf("DeleteFolderEmails: %v", err)
+	}
+	if deadlines, _ := db.UpcomingDeadlines("work", "", now.Add(-time.Hour), now.Add(60*24*time.Hour), 10); len(deadlines) != 0 {
+		t.Errorf("expected deadlines to be deleted with the folder, got %+v", deadlines)
+	}
+}
+
+func TestDatabaseInvoices(t *testing.T) {
+	db := openTestDatabase(t)
+
+	now := time.Now()
+	for uid := uint32(1); uid <= 3; uid++ {
+		email := &storage.Email{AccountID: "work", Folder: "INBOX", UID: uid, Subject: "Invoice", From: "billing@example.com",
+			Date: now.Add(-time.Duration(uid) * time.Hour)}
+		if err := db.CreateEmail(email); err != nil {
+			t.Fatalf("CreateEmail: %v", err)
+		}
+	}
+
+	due := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+	for _, inv := range []storage.Invoice{
+		{UID: 1, Vendor: "Acme", Amount: 120, Currency: "EUR", Due: due(72 * time.Hour)},
+		{UID: 2, Vendor: "Hosting Co", Amount: 20, Currency: "USD", Due: due(-24 * time.Hour)},
+		{UID: 3, Vendor: "Acme", Number: "F-7"},
+		// Extracting again replaces the first result
+		{UID: 1, Vendor: "Acme", Amount: 150, Currency: "EUR", Due: due(48 * time.Hour)},
+	} {
+		inv.AccountID, inv.Folder, inv.Method, inv.ExtractedAt = "work", "INBOX", "rules", now
+		if err := db.SaveInvoice(&inv); err != nil {
+			t.Fatalf("SaveInvoice: %v", err)
+		}
+	}
+
+	invoices, err := db.ListInvoices("work", "", "", now.AddDate(0, 0, -30), 10)
+	if err != nil {
+		t.Fatalf("ListInvoices: %v", err)
+	}
+	if len(invoices) != 3 || invoices[0].UID != 1 || invoices[0].Amount != 150 || invoices[2].Due != nil || invoices[2].Number != "F-7" {
+		t.Fatalf("unexpected invoices: %+v", invoices)
+	}
+	if invoices[0].Email == nil || invoices[0].Email.Subject != "Invoice" {
+		t.Errorf("expected the email to be joined, got %+v", invoices[0].Email)
+	}
+	if acme, _ := db.ListInvoices("work", "", "acm", now.AddDate(0, 0, -30), 10); len(acme) != 2 {
+		t.Errorf("expected 2 invoices from Acme, got %+v", acme)
+	}
+
+	payments, err := db.UpcomingPayments("work", now, now.AddDate(0, 0, 30), 10)
+	if err != nil {
+		t.Fatalf("UpcomingPayments: %v", err)
+	}
+	if len(payments) != 1 || payments[0].UID != 1 {
+		t.Fatalf("unexpected payments: %+v", payments)
+	}
+	if overdue, _ := db.UpcomingPayments("work", now.AddDate(0, 0, -30), now.AddDate(0, 0, 30), 10); len(overdue) != 2 || overdue[0].UID != 2 {
+		t.Errorf("expected the overdue invoice first, got %+v", overdue)
+	}
+
+	if err := db.DeleteFolderEmails("work", "INBOX"); err != nil {
+		t.Fatalf("DeleteFolderEmails: %v", err)
+	}
+	if invoices, _ := db.ListInvoices("work", "", "", now.AddDate(0, 0, -30), 10); len(invoices) != 0 {
+		t.Errorf("expected invoices to be deleted with the folder, got %+v", invoices)
+	}
+}
+
+func TestDatabaseOrdersAndTrips(t *testing.T) {
+	db := openTestDatabase(t)
+
+	now := time.Now()
+	for uid := uint32(1); uid <= 4; uid++ {
+		email := &storage.Email{AccountID: "work", Folder: "INBOX", UID: uid, Subject: "Your order", From: "orders@shop.com",
+			Date: now.Add(-time.Duration(10-uid) * time.Hour)}
+		if err := db.CreateEmail(email); err != nil {
+			t.Fatalf("CreateEmail: %v", err)
+		}
+	}
+
+	for _, o := range []storage.Order{
+		{UID: 1, Merchant: "Shop", Number: "A-100", Status: "placed", Total: 50, Currency: "EUR"},
+		{UID: 2, Merchant: "Shop", Number: "A-100", Status: "shipped", Carrier: "SEUR", TrackingURL: "https://seur.com/t/1"},
+		{UID: 3, Merchant: "Other", Number: "B-7", Status: "placed"},
+		// A later confirmation of the same order replaces the first result
+		{UID: 3, Merchant: "Other", Number: "B-8", Status: "placed", Total: 9.5, Currency: "EUR"},
+	} {
+		o.AccountID, o.Folder, o.ExtractedAt = "work", "INBOX", now
+		if err := db.SaveOrder(&o); err != nil {
+			t.Fatalf("SaveOrder: %v", err)
+		}
+	}
+
+	orders, err := db.ListOrders("work", "", "", now.AddDate(0, 0, -30), 10)
+	if err != nil {
+		t.Fatalf("ListOrders: %v", err)
+	}
+	if len(orders) != 2 || orders[0].Number != "B-8" || orders[1].Number != "A-100" {
+		t.Fatalf("unexpected orders: %+v", orders)
+	}
+	if shipped := orders[1]; shipped.UID != 2 || shipped.Status != "shipped" || shipped.Carrier != "SEUR" || shipped.Total != 50 {
+		t.Errorf("expected the emails about an order merged, got %+v", shipped)
+	}
+	if placed, _ := db.ListOrders("work", "", "placed", now.AddDate(0, 0, -30), 10); len(placed) != 1 || placed[0].Number != "B-8" {
+		t.Errorf("expected only the placed order, got %+v", placed)
+	}
+
+	departure := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+	if err := db.SaveTrips("work", "INBOX", 1, []storage.Trip{
+		{FlightNumber: "IB3101", Origin: "MAD", Destination: "BCN", Departure: departure(48 * time.Hour)},
+		{FlightNumber: "IB3112", Origin: "BCN", Destination: "MAD", Departure: departure(-48 * time.Hour)},
+	}); err != nil {
+		t.Fatalf("SaveTrips: %v", err)
+	}
+	// The boarding pass lists the outbound flight again
+	if err := db.SaveTrips("work", "INBOX", 4, []storage.Trip{
+		{FlightNumber: "IB3101", Origin: "MAD", Destination: "BCN", Departure: departure(48 * time.Hour), BookingReference: "X7K2PQ"},
+	}); err != nil {
+		t.Fatalf("SaveTrips: %v", err)
+	}
+
+	trips, err := db.UpcomingTrips("work", "", now, now.AddDate(0, 0, 30), 10)
+	if err != nil {
+		t.Fatalf("UpcomingTrips: %v", err)
+	}
+	if len(trips) != 1 || trips[0].UID != 4 || trips[0].BookingReference != "X7K2PQ" || trips[0].Email == nil {
+		t.Fatalf("unexpected trips: %+v", trips)
+	}
+	if all, _ := db.UpcomingTrips("work", "", now.AddDate(0, 0, -30), now.AddDate(0, 0, 30), 10); len(all) != 2 || all[0].FlightNumber != "IB3112" {
+		t.Errorf("expected the past flight first, got %+v", all)
+	}
+
+	if err := db.DeleteFolderEmails("work", "INBOX"); err != nil {
+		t.Fatalf("DeleteFolderEmails: %v", err)
+	}
+	if orders, _ := db.ListOrders("work", "", "", now.AddDate(0, 0, -30), 10); len(orders) != 0 {
+		t.Errorf("expected orders to be deleted with the folder, got %+v", orders)
+	}
+	if trips, _ := db.UpcomingTrips("work", "", now.AddDate(0, 0, -30), now.AddDate(0, 0, 30), 10); len(trips) != 0 {
+		t.Errorf("expected trips to be deleted with the folder, got %+v", trips)
+	}
+}
+
+func TestDatabaseBounces(t *testing.T) {
+	db := openTestDatabase(t)
+
+	now := time.Now()
+	save := func(uid uint32, date time.Time, bounces ...mail.Bounce) {
+		t.Helper()
+		if err := db.SaveBounces("work", "INBOX", uid, date, bounces); err != nil {
+			t.Fatalf("SaveBounces: %v", err)
+		}
+	}
+	save(1, now.Add(-48*time.Hour), mail.Bounce{Recipient: "bob@example.org", Action: "delayed", Status: "4.4.1"})
+	save(2, now.Add(-time.Hour), mail.Bounce{Recipient: "bob@example.org", Action: "failed", Status: "5.1.1", Diagnostic: "user unknown"})
+	save(3, now.Add(-2*time.Hour), mail.Bounce{Recipient: "carol@example.org", Action: "delayed"})
+	save(4, now.Add(-60*24*time.Hour), mail.Bounce{Recipient: "old@example.org", Action: "failed"})
+	// Saving again replaces the recipients of the bounce
+	save(3, now.Add(-2*time.Hour), mail.Bounce{Recipient: "dave@example.org", Action: "failed", Status: "5.2.2"})
+
+	addresses, err := db.BouncedAddresses("work", now.AddDate(0, 0, -30), 10)
+	if err != nil {
+		t.Fatalf("BouncedAddresses: %v", err)
+	}
+	if len(addresses) != 2 || addresses[0].Recipient != "bob@example.org" || addresses[1].Recipient != "dave@example.org" {
+		t.Fatalf("unexpected addresses: %+v", addresses)
+	}
+	if bob := addresses[0]; bob.Bounces != 2 || !bob.Permanent || bob.UID != 2 || bob.Diagnostic != "user unknown" {
+		t.Errorf("expected bob's latest bounce with both counted, got %+v", bob)
+	}
+
+	if err := db.DeleteFolderEmails("work", "INBOX"); err != nil {
+		t.Fatalf("DeleteFolderEmails: %v", err)
+	}
+	if addresses, _ := db.BouncedAddresses("work", now.AddDate(0, 0, -90), 10); len(addresses) != 0 {
+		t.Errorf("expected bounces to be deleted with the folder, got %+v", addresses)
+	}
+}
+
+func TestDatabaseSentDelivery(t *testing.T) {
+	db := openTestDatabase(t)
+
+	for _, m := range []*storage.SentMessage{
+		{AccountID: "work", MessageID: "<a1@example.com>", To: []string{"bob@example.org", "carol@example.org"}, Subject: "Report", SentAt: time.Now()},
+		{AccountID: "work", MessageID: "<a2@example.com>", To: []string{"dave@example.org"}, Subject: "Later", SentAt: time.Now()},
+	} {
+		if err := db.RecordSent(m); err != nil {
+			t.Fatalf("RecordSent: %v", err)
+		}
+	}
+	// The second message went out as a scheduled email
+	scheduled := &storage.ScheduledEmail{AccountID: "work", To: []string{"dave@example.org"}, Subject: "Later", SendAt: time.Now()}
+	if err := db.CreateScheduled(scheduled); err != nil {
+		t.Fatalf("CreateScheduled: %v", err)
+	}
+	scheduled.Status, scheduled.MessageID, scheduled.DeliveryStatus = storage.ScheduledSent, "<a2@example.com>", storage.DeliverySent
+	if err := db.UpdateScheduled(scheduled); err != nil {
+		t.Fatalf("UpdateScheduled: %v", err)
+	}
+
+	// Bounces quote Message-IDs without angle brackets; unknown ones are ignored
+	updated, err := db.RecordDeliveryFailures("work", []mail.Bounce{
+		{Recipient: "bob@example.org", Action: "delayed", Status: "4.4.1", OriginalMessageID: "a1@example.com"},
+		{Recipient: "dave@example.org", Action: "failed", Status: "5.1.1", Diagnostic: "user unknown", OriginalMessageID: "a2@example.com"},
+		{Recipient: "eve@example.org", Action: "failed", OriginalMessageID: "elsewhere@example.com"},
+	})
+	if err != nil {
+		t.Fatalf("RecordDeliveryFailures: %v", err)
+	}
+	if len(updated) != 2 || updated[0].DeliveryStatus != storage.DeliveryDelayed || updated[1].DeliveryStatus != storage.DeliveryFailed {
+		t.Fatalf("unexpected updates: %+v", updated)
+	}
+
+	// A later failure of the delayed recipient fails the message; a delay
+	// after it does not undo that
+	for _, action := range []string{"failed", "delayed"} {
+		if _, err := db.RecordDeliveryFailures("work", []mail.Bounce{{Recipient: "bob@example.org", Action: action, OriginalMessageID: "a1@example.com"}}); err != nil {
+			t.Fatalf("RecordDeliveryFailures: %v", err)
+		}
+	}
+	sent, err := db.GetSent("work", "a1@example.com")
+	if err != nil || sent == nil || sent.DeliveryStatus != storage.DeliveryFailed || !slices.Equal(sent.FailedRecipients, []string{"bob@example.org"}) {
+		t.Fatalf("GetSent = %+v, %v", sent, err)
+	}
+
+	failed, err := db.ListSent("work", storage.DeliveryFailed, time.Now().Add(-time.Hour), 10)
+	if err != nil || len(failed) != 2 {
+		t.Fatalf("ListSent(failed) = %+v, %v", failed, err)
+	}
+	all, err := db.ListScheduled("work", true)
+	if err != nil || len(all) != 1 || all[0].DeliveryStatus != storage.DeliveryFailed || all[0].MessageID != "<a2@example.com>" {
+		t.Errorf("expected the scheduled email to be marked failed, got %+v, %v", all, err)
+	}
+}
+
+func TestDatabaseActionItems(t *testing.T) {
+	db := openTestDatabase(t)
+
+	due := time.Date(2025, 3, 14, 23, 59, 59, 0, time.UTC)
+	items := []storage.ActionItem{
+		{Action: "Review the budget", Owner: "bob@example.com", Due: &due, Method: "rules"},
+		{Action: "Book the room", Owner: "Carla", Method: "rules"},
+	}
+	if err := db.SaveActionItems("work", "INBOX", 7, items); err != nil {
+		t.Fatalf("SaveActionItems: %v", err)
+	}
+	if err := db.SaveActionItems("work", "INBOX", 8, []storage.ActionItem{{Action: "Reply", Method: "llm"}}); err != nil {
+		t.Fatalf("SaveActionItems: %v", err)
+	}
+	// An email that no longer asks for anything is cleared
+	if err := db.SaveActionItems("work", "INBOX", 8, nil); err != nil {
+		t.Fatalf("SaveActionItems: %v", err)
+	}
+
+	stored, err := db.ActionItems("work", "INBOX", 7)
+	if err != nil {
+		t.Fatalf("ActionItems: %v", err)
+	}
+	if len(stored) != 2 || stored[0].Action != "Review the budget" || stored[0].Due == nil || !stored[0].Due.Equal(due) || stored[1].Due != nil {
+		t.Fatalf("unexpected action items: %+v", stored)
+	}
+
+	counts, err := db.ActionItemCounts("work", "INBOX")
+	if err != nil {
+		t.Fatalf("ActionItemCounts: %v", err)
+	}
+	if len(counts) != 1 || counts[7] != 2 {
+		t.Errorf("unexpected counts: %v", counts)
+	}
+}
+
+func TestDatabaseDuplicates(t *testing.T) {
+	db := openTestDatabase(t)
+
+	date := time.Date(2025, 3, 12, 9, 30, 0, 0, time.UTC)
+	emails := []*storage.Email{
+		{AccountID: "work", Folder: "INBOX", UID: 1, MessageID: "<a@example.com>", Subject: "Report", From: "Ana <ana@example.com>", Date: date, BodySnippet: "See attached"},
+		// Forwarded to the second account with the same Message-ID
+		{AccountID: "personal", Folder: "INBOX", UID: 5, MessageID: "<a@example.com>", Subject: "Report", From: "ana@example.com", Date: date, BodySnippet: "See attached"},
+		// Resent with a new Message-ID but the same content
+		{AccountID: "personal", Folder: "INBOX", UID: 6, MessageID: "<b@example.com>", Subject: " report", From: "ANA@example.com", Date: date.Add(20 * time.Second), BodySnippet: "See  attached"},
+		{AccountID: "personal", Folder: "INBOX", UID: 7, MessageID: "<c@example.com>", Subject: "Report", From: "ana@example.com", Date: date.Add(time.Hour), BodySnippet: "See attached"},
+	}
+	for _, email := range emails {
+		if err := db.CreateEmail(email); err != nil {
+			t.Fatalf("CreateEmail: %v", err)
+		}
+	}
+	// Syncing the copy again does not record it twice
+	if err := db.CreateEmail(emails[1]); err != nil {
+		t.Fatalf("CreateEmail: %v", err)
+	}
+
+	duplicates, err := db.ListDuplicates("", "", 10)
+	if err != nil {
+		t.Fatalf("ListDuplicates: %v", err)
+	}
+	if len(duplicates) != 2 {
+		t.Fatalf("expected 2 duplicates, got %+v", duplicates)
+	}
+	for _, dup := range duplicates {
+		if dup.OriginalAccountID != "work" || dup.OriginalUID != 1 {
+			t.Errorf("expected a copy of work #1, got %+v", dup)
+		}
+		want := map[uint32]string{5: storage.DuplicateMessageID, 6: storage.DuplicateContent}[dup.Email.UID]
+		if dup.Reason != want {
+			t.Errorf("email %d: expected reason %q, got %q", dup.Email.UID, want, dup.Reason)
+		}
+	}
+
+	if work, _ := db.ListDuplicates("work", "", 10); len(work) != 0 {
+		t.Errorf("expected no copies in the original account, got %+v", work)
+	}
+	uids, err := db.DuplicateUIDs("personal", "INBOX")
+	if err != nil || len(uids) != 2 || !uids[5] || !uids[6] || uids[7] {
+		t.Errorf("unexpected duplicate UIDs: %v, %v", uids, err)
+	}
+}
+
+func TestDatabaseFeedbackCounts(t *testing.T) {
+	db := openTestDatabase(t)
+
+	for uid := uint32(1); uid <= 3; uid++ {
+		f := &storage.Feedback{AccountID: "work", Folder: "INBOX", UID: uid, Sender: "Billing@Vendor.com",
+			Predicted: "invoice", Rule: "invoices", Correct: "work"}
+		samples, mistakes, err := db.SaveFeedback(f)
+		if err != nil {
+			t.Fatalf("SaveFeedback: %v", err)
+		}
+		if samples != int(uid) || mistakes != int(uid) {
+			t.Errorf("after %d corrections got samples=%d mistakes=%d", uid, samples, mistakes)
+		}
+	}
+
+	if err := db.SaveLearnedSender(&storage.LearnedSender{Sender: "billing@vendor.com", Category: "work",
+		Confidence: 0.9, Samples: 3, UpdatedAt: time.Now()}); err != nil {
+		t.Fatalf("SaveLearnedSender: %v", err)
+	}
+	if err := db.SaveRuleAdjustment("invoices", -0.05); err != nil {
+		t.Fatalf("SaveRuleAdjustment: %v", err)
+	}
+
+	senders, err := db.ListLearnedSenders()
+	if err != nil || len(senders) != 1 || senders[0].Category != "work" {
+		t.Errorf("ListLearnedSenders = %+v, %v", senders, err)
+	}
+	adjustments, err := db.RuleAdjustments()
+	if err != nil || adjustments["invoices"] != -0.05 {
+		t.Errorf("RuleAdjustments = %v, %v", adjustments, err)
+	}
+}
+
+func TestDatabaseVIPs(t *testing.T) {
+	db := openTestDatabase(t)
+
+	date := time.Date(2025, 3, 14, 9, 30, 0, 0, time.UTC)
+	for uid := uint32(1); uid <= 2; uid++ {
+		email := &storage.Email{AccountID: "work", Folder: "INBOX", UID: uid, From: "Boss <Boss@Example.com>",
+			Subject: "Report", Date: date.Add(time.Duration(uid) * time.Hour)}
+		if err := db.CreateEmail(email); err != nil {
+			t.Fatalf("CreateEmail: %v", err)
+		}
+	}
+
+	if err := db.SetVIP("boss@example.com", true, "manager"); err != nil {
+		t.Fatalf("SetVIP: %v", err)
+	}
+	if err := db.SetVIP("ceo@example.com", true, ""); err != nil {
+		t.Fatalf("SetVIP: %v", err)
+	}
+	if err := db.SetVIP("ceo@example.com", false, ""); err != nil {
+		t.Fatalf("SetVIP: %v", err)
+	}
+
+	vips, err := db.ListVIPs()
+	if err != nil || len(vips) != 1 {
+		t.Fatalf("ListVIPs = %+v, %v", vips, err)
+	}
+	if vips[0].Sender != "boss@example.com" || vips[0].Note != "manager" || vips[0].EmailCount != 2 {
+		t.Errorf("unexpected VIP: %+v", vips[0])
+	}
+	if vips[0].LastSeen == nil || !vips[0].LastSeen.Equal(date.Add(2*time.Hour)) {
+		t.Errorf("LastSeen = %v", vips[0].LastSeen)
+	}
+	if vip, err := db.IsVIP("CEO@example.com"); vip || err != nil {
+		t.Errorf("IsVIP(unmarked) = %v, %v", vip, err)
+	}
+}
+
+func TestDatabaseFromAddress(t *testing.T) {
+	db := openTestDatabase(t)
+
+	date := time.Date(2025, 3, 14, 9, 30, 0, 0, time.UTC)
+	email := &storage.Email{AccountID: "work", Folder: "INBOX", UID: 1, From: "Doe, John <John@Example.com>", Subject: "Hi", Date: date}
+	if err := db.CreateEmail(email); err != nil {
+		t.Fatalf("CreateEmail: %v", err)
+	}
+	if email.FromAddress.Name != "Doe, John" || email.FromAddress.Address != "John@Example.com" {
+		t.Errorf("FromAddress = %+v", email.FromAddress)
+	}
+
+	emails, err := db.GetEmails("work", 10)
+	if err != nil || len(emails) != 1 {
+		t.Fatalf("GetEmails = %+v, %v", emails, err)
+	}
+	if emails[0].FromAddress != email.FromAddress {
+		t.Errorf("stored FromAddress = %+v, want %+v", emails[0].FromAddress, email.FromAddress)
+	}
+
+	contact, err := db.ContactByAddress("john@example.com")
+	if err != nil || contact == nil || contact.Name != "Doe, John" {
+		t.Errorf("ContactByAddress = %+v, %v", contact, err)
+	}
+}
+
+func TestDatabaseResponseActivity(t *testing.T) {
+	db := openTestDatabase(t)
+
+	now := time.Date(2025, 10, 15, 18, 0, 0, 0, time.UTC)
+	emails := []*storage.Email{
+		{Folder: "INBOX", UID: 1, MessageID: "<q1@x>", From: "Ana <Ana@x.com>", Subject: "Budget", Date: now.Add(-10 * time.Hour)},
+		{Folder: "Sent", UID: 1, MessageID: "<r1@me>", From: "Me <me@work.com>", To: []string{"ana@x.com"}, InReplyTo: "<q1@x>",
+			Subject: "Re: Budget", Date: now.Add(-8 * time.Hour), BodySnippet: "Let me check the numbers. I'll get back to you tomorrow."},
+		{Folder: "INBOX", UID: 2, MessageID: "<q2@x>", From: "ana@x.com", Subject: "Venue", Date: now.Add(-6 * time.Hour)},
+		{Folder: "Sent", UID: 2, MessageID: "<r2@me>", From: "me@work.com", To: []string{"ana@x.com"}, InReplyTo: "<q2@x>",
+			Subject: "Re: Venue", Date: now.Add(-2 * time.Hour), BodySnippet: "Booked."},
+		// Never answered
+		{Folder: "INBOX", UID: 3, MessageID: "<q3@x>", From: "bob@y.com", Subject: "Contract?", Date: now.AddDate(0, 0, -3)},
+		// Outside the window
+		{Folder: "INBOX", UID: 4, MessageID: "<q4@x>", From: "old@y.com", Subject: "Old", Date: now.AddDate(0, 0, -60)},
+	}
+	for _, e := range emails {
+		e.AccountID = "work"
+		if err := db.CreateEmail(e); err != nil {
+			t.Fatalf("CreateEmail: %v", err)
+		}
+	}
+
+	activity, err := db.ResponseActivity("work", []string{"me@work.com"}, now.AddDate(0, 0, -30), now)
+	if err != nil {
+		t.Fatalf("ResponseActivity: %v", err)
+	}
+	if activity.Sent != 2 || activity.Overall == nil || activity.Overall.Replies != 2 || activity.Overall.AverageHours != 3 {
+		t.Errorf("sent %d, overall %+v", activity.Sent, activity.Overall)
+	}
+	if len(activity.Contacts) != 1 || activity.Contacts[0].Contact != "ana@x.com" || activity.Contacts[0].Replies != 2 ||
+		!activity.Contacts[0].LastReply.Equal(now.Add(-2*time.Hour)) {
+		t.Errorf("unexpected contacts: %+v", activity.Contacts)
+	}
+	if len(activity.Unanswered) != 1 || activity.Unanswered[0].UID != 3 {
+		t.Errorf("unexpected unanswered emails: %+v", activity.Unanswered)
+	}
+	// Both replies are the user's last word in their thread
+	if len(activity.LastSent) != 2 || activity.LastSent[0].Subject != "Re: Budget" {
+		t.Errorf("unexpected last sent emails: %+v", activity.LastSent)
+	}
+
+	if err := db.SetVIP("ana@x.com", true, ""); err != nil {
+		t.Fatalf("SetVIP: %v", err)
+	}
+	if err := db.SaveResponseTimes(activity.Contacts); err != nil {
+		t.Fatalf("SaveResponseTimes: %v", err)
+	}
+	vips, err := db.ListVIPs()
+	if err != nil || len(vips) != 1 || vips[0].AvgResponseHours == nil || *vips[0].AvgResponseHours != 3 || vips[0].Replies != 2 {
+		t.Errorf("ListVIPs = %+v, %v", vips, err)
+	}
+}
+
+func TestDatabaseFollowups(t *testing.T) {
+	db := openTestDatabase(t)
+
+	now := time.Now()
+	for _, f := range []*storage.Followup{
+		{AccountID: "work", MessageID: "<sent1@x>", Subject: "Proposal", ReplyBy: now.Add(-time.Hour)},
+		{AccountID: "work", MessageID: "<sent2@x>", Subject: "Invoice", ReplyBy: now.Add(24 * time.Hour)},
+	} {
+		if err := db.TrackFollowup(f); err != nil {
+			t.Fatalf("TrackFollowup: %v", err)
+		}
+	}
+
+	pending, err := db.ListFollowups("work", false, now)
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("ListFollowups = %+v, %v", pending, err)
+	}
+	if !pending[0].Overdue || pending[1].Overdue {
+		t.Errorf("expected only the first follow-up to be overdue: %+v", pending)
+	}
+
+	// A reply deeper in the thread still references the sent message
+	reply := &storage.Email{AccountID: "work", Folder: "INBOX", UID: 7, MessageID: "<reply@y>", From: "ana@y.com",
+		InReplyTo: "<other@y>", References: []string{"<sent1@x>", "<other@y>"}, Date: now}
+	if err := db.CreateEmail(reply); err != nil {
+		t.Fatalf("CreateEmail: %v", err)
+	}
+	if n, err := db.ResolveFollowups(reply); err != nil || n != 1 {
+		t.Fatalf("ResolveFollowups = %d, %v; want 1", n, err)
+	}
+
+	pending, _ = db.ListFollowups("work", false, now)
+	if len(pending) != 1 || pending[0].MessageID != "<sent2@x>" {
+		t.Errorf("expected only <sent2@x> pending, got %+v", pending)
+	}
+	all, _ := db.ListFollowups("", true, now)
+	for _, f := range all {
+		if f.MessageID == "<sent1@x>" && (f.Status != storage.FollowupResolved || f.ReplyFrom != "ana@y.com" || f.Overdue) {
+			t.Errorf("unexpected resolved follow-up: %+v", f)
+		}
+	}
+
+	// Tracking a message whose reply was already synced resolves it at once
+	late := &storage.Followup{AccountID: "work", MessageID: "<other@y>", ReplyBy: now.Add(time.Hour)}
+	if err := db.TrackFollowup(late); err != nil {
+		t.Fatalf("TrackFollowup: %v", err)
+	}
+	if late.Status != storage.FollowupResolved || late.ReplyMessageID != "<reply@y>" {
+		t.Errorf("expected follow-up resolved by the stored reply, got %+v", late)
+	}
+}
+
+func TestDatabaseContacts(t *testing.T) {
+	db := openTestDatabase(t)
+
+	day := time.Date(2025, 3, 10, 9, 0, 0, 0, time.UTC)
+	emails := []*storage.Email{
+		{UID: 1, From: "Ana Pérez <ana@corp.com>", To: []string{"me@example.com", "bob@corp.com"}, Date: day},
+		{UID: 2, From: "ana@corp.com", To: []string{"me@example.com"}, Date: day.Add(48 * time.Hour)},
+		{UID: 3, From: "Ana Pérez <ana.perez@gmail.com>", To: []string{"me@example.com"}, Date: day.Add(-24 * time.Hour)},
+		{UID: 4, From: "Doe, John <john@doe.org>", To: []string{"me@example.com"}, Date: day},
+		{UID: 5, From: "Anabel <anabel@shop.com>", To: []string{"me@example.com"}, Date: day},
+	}
+	for _, e := range emails {
+		e.AccountID, e.Folder = "work", "INBOX"
+		if err := db.CreateEmail(e); err != nil {
+			t.Fatalf("CreateEmail: %v", err)
+		}
+	}
+	// Re-syncing an email updates flags without counting it again
+	if err := db.CreateEmail(&storage.Email{AccountID: "work", Folder: "INBOX", UID: 1, From: "ana@corp.com", Date: day}); err != nil {
+		t.Fatalf("CreateEmail: %v", err)
+	}
+
+	ana, err := db.ContactByAddress("ANA@corp.com")
+	if err != nil || ana == nil {
+		t.Fatalf("ContactByAddress = %v, %v", ana, err)
+	}
+	if ana.Name != "Ana Pérez" || len(ana.Addresses) != 2 || ana.ReceivedCount != 3 {
+		t.Errorf("expected both addresses of Ana Pérez with 3 emails, got %+v", ana)
+	}
+	if ana.Addresses[0].Address != "ana@corp.com" || !ana.FirstSeen.Equal(day.Add(-24*time.Hour)) || !ana.LastSeen.Equal(day.Add(48*time.Hour)) {
+		t.Errorf("unexpected addresses or dates: %+v", ana)
+	}
+
+	if john, _ := db.ContactByAddress("john@doe.org"); john == nil || john.Name != "Doe, John" {
+		t.Errorf("expected an unquoted display name to be kept, got %+v", john)
+	}
+	if bob, _ := db.ContactByAddress("bob@corp.com"); bob == nil || bob.RecipientCount != 1 || bob.ReceivedCount != 0 {
+		t.Errorf("expected bob as a recipient only, got %+v", bob)
+	}
+
+	// Prefix matches first, then the most frequent correspondents
+	found, err := db.SearchContacts("ana", 10)
+	if err != nil || len(found) != 2 {
+		t.Fatalf("SearchContacts = %+v, %v", found, err)
+	}
+	if found[0].ID != ana.ID {
+		t.Errorf("expected Ana Pérez first, got %+v", found[0])
+	}
+	if found, _ := db.SearchContacts("pérez", 10); len(found) != 1 {
+		t.Errorf("expected a surname match, got %+v", found)
+	}
+	if found, _ := db.SearchContacts("100%", 10); len(found) != 0 {
+		t.Errorf("expected LIKE wildcards to be escaped, got %+v", found)
+	}
+
+	recent, err := db.ContactEmails(ana, 10)
+	if err != nil || len(recent) != 3 || recent[0].UID != 2 {
+		t.Errorf("ContactEmails = %+v, %v", recent, err)
+	}
+
+	// Resyncing a folder recomputes the counts from the remaining emails
+	if err := db.DeleteFolderEmails("work", "INBOX"); err != nil {
+		t.Fatalf("DeleteFolderEmails: %v", err)
+	}
+	if found, _ := db.SearchContacts("", 10); len(found) != 0 {
+		t.Errorf("expected no contacts without emails, got %+v", found)
+	}
+}
+
+func TestDatabaseMailboxStats(t *testing.T) {
+	db := openTestDatabase(t)
+
+	now := time.Date(2025, 10, 15, 18, 0, 0, 0, time.UTC) // A Wednesday
+	emails := []*storage.Email{
+		{UID: 1, MessageID: "<a@x>", From: "Ana <ana@x.com>", Date: now.Add(-2 * time.Hour), Size: 1000},
+		{UID: 2, MessageID: "<b@x>", From: "ana@x.com", Date: now.AddDate(0, 0, -1), Size: 2000, Flags: []string{`\Seen`}},
+		{UID: 3, MessageID: "<c@x>", From: "news@shop.com", Date: now.AddDate(0, 0, -8), Size: 500},
+		{UID: 4, MessageID: "<d@x>", From: "old@x.com", Date: now.AddDate(0, 0, -60), Size: 100},
+		// A reply of the account owner, three hours after <b@x>
+		{UID: 5, MessageID: "<e@me>", From: "Me <me@work.com>", InReplyTo: "<b@x>",
+			Date: now.AddDate(0, 0, -1).Add(3 * time.Hour), Size: 300, Flags: []string{`\Seen`}},
+	}
+	for _, e := range emails {
+		e.AccountID, e.Folder = "work", "INBOX"
+		if err := db.CreateEmail(e); err != nil {
+			t.Fatalf("CreateEmail: %v", err)
+		}
+	}
+	if err := db.SaveClassification(&storage.Classification{AccountID: "work", Folder: "INBOX", UID: 3,
+		Category: "newsletter", Confidence: 0.9, Method: "rules"}); err != nil {
+		t.Fatalf("SaveClassification: %v", err)
+	}
+
+	stats, err := db.MailboxStats("work", []string{"me@work.com"}, 14, now)
+	if err != nil {
+		t.Fatalf("MailboxStats: %v", err)
+	}
+
+	if stats.Received != 3 || stats.Sent != 1 || stats.Unread != 2 {
+		t.Errorf("received/sent/unread = %d/%d/%d, want 3/1/2", stats.Received, stats.Sent, stats.Unread)
+	}
+	if len(stats.Daily) != 14 || stats.Daily[13].Date != "2025-10-15" || stats.Daily[13].Received != 1 {
+		t.Errorf("unexpected daily series: %+v", stats.Daily)
+	}
+	if last := stats.Daily[13]; last.UnreadBacklog != 2 {
+		t.Errorf("UnreadBacklog = %d, want 2", last.UnreadBacklog)
+	}
+	if n := len(stats.Weekly); n != 3 || stats.Weekly[n-1].Date != "2025-10-13" || stats.Weekly[n-1].Received != 2 || stats.Weekly[n-1].Sent != 1 {
+		t.Errorf("unexpected weekly series: %+v", stats.Weekly)
+	}
+	if len(stats.Categories) != 1 || stats.Categories[0].Category != "newsletter" || stats.Unclassified != 2 {
+		t.Errorf("categories = %+v, unclassified %d", stats.Categories, stats.Unclassified)
+	}
+	if len(stats.TopSenders) == 0 || stats.TopSenders[0] != (storage.SenderCount{Sender: "ana@x.com", Count: 2}) {
+		t.Errorf("TopSenders = %+v", stats.TopSenders)
+	}
+	if r := stats.YourResponse; r == nil || r.Replies != 1 || r.AverageHours != 3 {
+		t.Errorf("YourResponse = %+v", r)
+	}
+	// Storage covers emails outside the window too
+	if stats.TotalEmails != 5 || stats.TotalBytes != 3900 || len(stats.Folders) != 1 || stats.Folders[0].Unread != 3 {
+		t.Errorf("storage = %d emails, %d bytes, folders %+v", stats.TotalEmails, stats.TotalBytes, stats.Folders)
+	}
+}
+
+func TestDatabaseEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "emails.db.enc")
+
+	db, err := storage.OpenEncrypted(path, "secret", 0)
+	if err != nil {
+		t.Fatalf("OpenEncrypted: %v", err)
+	}
+	email := &storage.Email{AccountID: "work", Folder: "INBOX", UID: 1, Subject: "Invoice 2025-03", From: "billing@example.com", Date: time.Now()}
+	if err := db.CreateEmail(email); err != nil {
+		t.Fatalf("CreateEmail: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if bytes.Contains(data, []byte("Invoice")) || bytes.Contains(data, []byte("SQLite format")) {
+		t.Error("encrypted database file contains plain text")
+	}
+
+	if _, err := storage.OpenEncrypted(path, "wrong", 0); err == nil {
+		t.Error("OpenEncrypted with the wrong passphrase succeeded")
+	}
+
+	db, err = storage.OpenEncrypted(path, "secret", 0)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer db.Close()
+	results, err := db.SearchEmails("invoice", storage.SearchFilter{})
+	if err != nil {
+		t.Fatalf("SearchEmails: %v", err)
+	}
+	if len(results) != 1 {
+		t.Errorf("search after reopening found %d emails, want 1", len(results))
+	}
+}
+
+func TestEncryptDatabase(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "emails.db")
+
+	db, err := storage.New(plain)
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	email := &storage.Email{AccountID: "work", Folder: "INBOX", UID: 7, Subject: "Contract draft", From: "legal@example.com", Date: time.Now()}
+	if err := db.CreateEmail(email); err != nil {
+		t.Fatalf("CreateEmail: %v", err)
+	}
+	db.Close()
+
+	if err := storage.EncryptDatabase(plain, plain+".enc", "secret"); err != nil {
+		t.Fatalf("EncryptDatabase: %v", err)
+	}
+	if err := storage.EncryptDatabase(plain, plain+".enc", "secret"); err == nil {
+		t.Error("EncryptDatabase overwrote an existing encrypted database")
+	}
+
+	encrypted, err := storage.OpenEncrypted(plain+".enc", "secret", 0)
+	if err != nil {
+		t.Fatalf("OpenEncrypted: %v", err)
+	}
+	defer encrypted.Close()
+	got, err := encrypted.GetEmail("work", "INBOX", 7)
+	if err != nil || got == nil || got.Subject != "Contract draft" {
+		t.Errorf("GetEmail after encryption = %+v, %v", got, err)
+	}
+}
+
+func TestDatabaseBackupRestore(t *testing.T) {
+	dir := t.TempDir()
+	plain, err := storage.New(filepath.Join(dir, "emails.db"))
+	if err != nil {
+		t.Fatalf("storage.New: %v", err)
+	}
+	defer plain.Close()
+	encrypted, err := storage.OpenEncrypted(filepath.Join(dir, "emails.db.enc"), "secret", 0)
+	if err != nil {
+		t.Fatalf("OpenEncrypted: %v", err)
+	}
+	defer encrypted.Close()
+
+	for _, db := range []*storage.Database{plain, encrypted} {
+		if err := db.SaveTemplate(&storage.Template{Name: "welcome", Subject: "Hi", Body: "Hello"}); err != nil {
+			t.Fatalf("SaveTemplate: %v", err)
+		}
+	}
+	plainBackup := filepath.Join(dir, "plain-backup.db")
+	if err := plain.Backup(plainBackup); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+	if err := plain.Backup(plainBackup); err == nil {
+		t.Error("Backup overwrote an existing file")
+	}
+	encryptedBackup := filepath.Join(dir, "encrypted-backup.db.enc")
+	if err := encrypted.Backup(encryptedBackup); err != nil {
+		t.Fatalf("Backup encrypted: %v", err)
+	}
+
+	for _, db := range []*storage.Database{plain, encrypted} {
+		if err := db.DeleteTemplate("welcome"); err != nil {
+			t.Fatalf("DeleteTemplate: %v", err)
+		}
+	}
+	if err := plain.Restore(plainBackup); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if err := encrypted.Restore(encryptedBackup); err != nil {
+		t.Fatalf("Restore encrypted: %v", err)
+	}
+	for _, db := range []*storage.Database{plain, encrypted} {
+		if tmpl, err := db.GetTemplate("welcome"); err != nil || tmpl == nil {
+			t.Errorf("template after restore = %v, %v", tmpl, err)
+		}
+	}
+
+	// A plain backup can move to an encrypted database, not the reverse
+	if err := encrypted.Restore(plainBackup); err != nil {
+		t.Errorf("Restore plain backup into encrypted database: %v", err)
+	}
+	if err := plain.Restore(encryptedBackup); err == nil {
+		t.Error("Restore of an encrypted backup into a plain database succeeded")
+	}
+}
+
+func TestDatabaseExportEmails(t *testing.T) {
+	db := openTestDatabase(t)
+
+	base := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	for i, subject := range []string{"Invoice March", "Team lunch", "Invoice April"} {
+		email := &storage.Email{AccountID: "work", Folder: "INBOX", UID: uint32(i + 1), Subject: subject,
+			From: "Billing <billing@example.com>", Date: base.AddDate(0, 0, i)}
+		if i == 1 {
+			email.Flags = []string{"\\Seen"}
+		}
+		if err := db.CreateEmail(email); err != nil {
+			t.Fatalf("CreateEmail: %v", err)
+		}
+	}
+	if err := db.SaveClassification(&storage.Classification{AccountID: "work", Folder: "INBOX", UID: 1,
+		Category: "finance", Confidence: 0.9, Tags: []string{"invoice"}, Method: "rules"}); err != nil {
+		t.Fatalf("SaveClassification: %v", err)
+	}
+	if err := db.SavePriority(&storage.Priority{AccountID: "work", Folder: "INBOX", UID: 1, Score: 80, Level: "high"}); err != nil {
+		t.Fatalf("SavePriority: %v", err)
+	}
+
+	all, err := db.ExportEmails(storage.ExportFilter{AccountID: "work"})
+	if err != nil {
+		t.Fatalf("ExportEmails: %v", err)
+	}
+	if len(all) != 3 || all[0].Subject != "Invoice March" {
+		t.Fatalf("ExportEmails returned %d emails, want 3 oldest first", len(all))
+	}
+	first := all[0]
+	if first.Category != "finance" || first.PriorityScore == nil || *first.PriorityScore != 80 || len(first.Tags) != 1 {
+		t.Errorf("first export = %+v, want category, tags and priority", first)
+	}
+	if all[1].Category != "" || all[1].PriorityScore != nil {
+		t.Errorf("unclassified email exported with %q and %v", all[1].Category, all[1].PriorityScore)
+	}
+
+	for name, tc := range map[string]struct {
+		filter storage.ExportFilter
+		want   int
+	}{
+		"query":    {storage.ExportFilter{Query: "invoice"}, 2},
+		"category": {storage.ExportFilter{Category: "finance"}, 1},
+		"priority": {storage.ExportFilter{MinPriority: 50}, 1},
+		"unread":   {storage.ExportFilter{UnreadOnly: true}, 2},
+		"since":    {storage.ExportFilter{Since: base.AddDate(0, 0, 1)}, 2},
+		"limit":    {storage.ExportFilter{Limit: 1}, 1},
+	} {
+		emails, err := db.ExportEmails(tc.filter)
+		if err != nil {
+			t.Fatalf("%s: ExportEmails: %v", name, err)
+		}
+		if len(emails) != tc.want {
+			t.Errorf("%s: got %d emails, want %d", name, len(emails), tc.want)
+		}
+	}
+}
+
+func TestDatabaseGraphIDs(t *testing.T) {
+	db := openTestDatabase(t)
+
+	first, err := db.GraphUID("work", "AAMkAD-first")
+	if err != nil {
+		t.Fatalf("GraphUID failed: %v", err)
+	}
+	second, _ := db.GraphUID("work", "AAMkAD-second")
+	again, _ := db.GraphUID("work", "AAMkAD-first")
+	other, _ := db.GraphUID("home", "AAMkAD-first")
+	if first != 1 || second != 2 || again != first || other != 1 {
+		t.Errorf("UIDs = %d, %d, %d, %d; want 1, 2, 1 and 1 for the other account", first, second, again, other)
+	}
+
+	if id, err := db.GraphID("work", second); err != nil || id != "AAMkAD-second" {
+		t.Errorf("GraphID(2) = %q, %v", id, err)
+	}
+	if _, err := db.GraphID("work", 99); err == nil {
+		t.Error("GraphID of an unknown UID should fail")
+	}
+
+	// Delta links survive in the sync state
+	state := &storage.SyncState{AccountID: "work", Folder: "INBOX", LastUID: 2, DeltaLink: "https://graph/delta?token=1"}
+	if err := db.SaveSyncState(state); err != nil {
+		t.Fatalf("SaveSyncState failed: %v", err)
+	}
+	saved, err := db.GetSyncState("work", "INBOX")
+	if err != nil || saved == nil || saved.DeltaLink != state.DeltaLink {
+		t.Errorf("GetSyncState = %+v, %v; want the delta link", saved, err)
+	}
+}
@@ -0,0 +1,476 @@
+package test
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/server"
+
+	"email-mcp-server/storage"
+	emailsync "email-mcp-server/sync"
+)
+
+// newIMAPServer starts an in-memory IMAP server and returns a dialer logged
+// in to it. Its INBOX starts with one plain text message.
+func newIMAPServer(t *testing.T) emailsync.Dialer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	s := server.New(memory.New())
+	s.AllowInsecureAuth = true
+	go s.Serve(listener)
+	t.Cleanup(func() { s.Close() })
+
+	return func(ctx context.Context, accountID string) (*client.Client, error) {
+		c, err := client.Dial(listener.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+		if err := c.Login("username", "password"); err != nil {
+			c.Logout()
+			return nil, err
+		}
+		return c, nil
+	}
+}
+
+func TestSyncSnippetsFromPartialFetch(t *testing.T) {
+	dial := newIMAPServer(t)
+
+	text := strings.Repeat("Mañana revisamos el presupuesto. ", 300)
+	raw := strings.Join([]string{
+		"From: Ana <ana@example.com>",
+		"To: me@example.com",
+		"Subject: Presupuesto",
+		"Date: Mon, 03 Mar 2025 09:00:00 +0000",
+		"Message-ID: <budget@example.com>",
+		"References: <thread-1@example.com>",
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/mixed; boundary=outer",
+		"",
+		"--outer",
+		"Content-Type: text/plain; charset=UTF-8",
+		"Content-Transfer-Encoding: base64",
+		"",
+		base64.StdEncoding.EncodeToString([]byte(text)),
+		"--outer",
+		"Content-Type: application/octet-stream; name=\"data.bin\"",
+		"Content-Disposition: attachment; filename=\"data.bin\"",
+		"Content-Transfer-Encoding: base64",
+		"",
+		base64.StdEncoding.EncodeToString(make([]byte, 256*1024)),
+		"--outer--",
+		"",
+	}, "\r\n")
+
+	c, err := dial(context.Background(), "work")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	if err := c.Append("INBOX", nil, time.Now(), strings.NewReader(raw)); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	c.Logout()
+
+	db := openTestDatabase(t)
+	engine := emailsync.NewEngine(db, dial, []string{"work"}, 0, 0)
+	engine.SnippetLength = 32
+	engine.SnippetBytes = 100
+	if _, err := engine.SyncAccount(context.Background(), "work"); err != nil {
+		t.Fatalf("SyncAccount: %v", err)
+	}
+
+	emails, err := db.GetEmails("work", 10)
+	if err != nil || len(emails) != 2 {
+		t.Fatalf("GetEmails = %d emails, %v; want 2", len(emails), err)
+	}
+	for _, email := range emails {
+		switch email.Subject {
+		case "Presupuesto":
+			if want := "Mañana revisamos el presupuesto."; email.BodySnippet != want {
+				t.Errorf("snippet = %q, want %q", email.BodySnippet, want)
+			}
+			if len(email.References) != 1 || email.References[0] != "<thread-1@example.com>" {
+				t.Errorf("References = %v", email.References)
+			}
+		default:
+			if email.BodySnippet != "Hi there :)" {
+				t.Errorf("snippet of %q = %q", email.Subject, email.BodySnippet)
+			}
+		}
+	}
+}
+
+func TestSyncDecodesAccentedContent(t *testing.T) {
+	dial := newIMAPServer(t)
+
+	raw := strings.Join([]string{
+		"From: =?ISO-8859-1?Q?Jos=E9_P=E9rez?= <jose@example.com>",
+		"To: me@example.com",
+		"Subject: =?windows-1252?Q?Reuni=F3n_de_ma=F1ana:_5=80?=",
+		"Date: Mon, 03 Mar 2025 09:00:00 +0000",
+		"Message-ID: <reunion@example.com>",
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=iso-8859-1",
+		"Content-Transfer-Encoding: quoted-printable",
+		"",
+		"Se=F1or, la reuni=F3n cuesta 5=80 y es =93breve=94.",
+		"",
+	}, "\r\n")
+
+	c, err := dial(context.Background(), "work")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	if err := c.Append("INBOX", nil, time.Now(), strings.NewReader(raw)); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	c.Logout()
+
+	db := openTestDatabase(t)
+	engine := emailsync.NewEngine(db, dial, []string{"work"}, 0, 0)
+	if _, err := engine.SyncAccount(context.Background(), "work"); err != nil {
+		t.Fatalf("SyncAccount: %v", err)
+	}
+
+	var email *storage.Email
+	emails, err := db.GetEmails("work", 10)
+	for i := range emails {
+		if emails[i].MessageID == "<reunion@example.com>" {
+			email = &emails[i]
+		}
+	}
+	if err != nil || email == nil {
+		t.Fatalf("GetEmails = %+v, %v", emails, err)
+	}
+	if email.Subject != "Reunión de mañana: 5€" {
+		t.Errorf("Subject = %q", email.Subject)
+	}
+	if email.FromAddress.Name != "José Pérez" {
+		t.Errorf("From name = %q", email.FromAddress.Name)
+	}
+	if want := "Señor, la reunión cuesta 5€ y es “breve”."; email.BodySnippet != want {
+		t.Errorf("snippet = %q, want %q", email.BodySnippet, want)
+	}
+}
+
+func TestSyncStoresAttachments(t *testing.T) {
+	dial := newIMAPServer(t)
+
+	raw := strings.Join([]string{
+		"From: legal@example.com",
+		"To: me@example.com",
+		"Subject: Signed copy",
+		"Date: Mon, 03 Mar 2025 09:00:00 +0000",
+		"Message-ID: <signed@example.com>",
+		"MIME-Version: 1.0",
+		`Content-Type: multipart/mixed; boundary="b1"`,
+		"",
+		"--b1",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		"Attached is the signed contract.",
+		"--b1",
+		`Content-Type: application/pdf; name="contract.pdf"`,
+		`Content-Disposition: attachment; filename="contract.pdf"`,
+		"Content-Transfer-Encoding: base64",
+		"",
+		"JVBERi0xLjQK",
+		"--b1--",
+		"",
+	}, "\r\n")
+
+	c, err := dial(context.Background(), "work")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	if err := c.Append("INBOX", nil, time.Now(), strings.NewReader(raw)); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	c.Logout()
+
+	db := openTestDatabase(t)
+	engine := emailsync.NewEngine(db, dial, []string{"work"}, 0, 0)
+	if _, err := engine.SyncAccount(context.Background(), "work"); err != nil {
+		t.Fatalf("SyncAccount: %v", err)
+	}
+
+	var email *storage.Email
+	emails, err := db.GetEmails("work", 10)
+	for i := range emails {
+		if emails[i].MessageID == "<signed@example.com>" {
+			email = &emails[i]
+		}
+	}
+	if err != nil || email == nil {
+		t.Fatalf("GetEmails = %+v, %v", emails, err)
+	}
+	if len(email.Attachments) != 1 || email.Attachments[0].Filename != "contract.pdf" ||
+		email.Attachments[0].ContentType != "application/pdf" || email.Attachments[0].Size == 0 {
+		t.Errorf("Attachments = %+v", email.Attachments)
+	}
+	if email.BodySnippet != "Attached is the signed contract." {
+		t.Errorf("snippet = %q", email.BodySnippet)
+	}
+}
+
+func TestSyncConfiguredFolders(t *testing.T) {
+	dial := newIMAPServer(t)
+
+	c, err := dial(context.Background(), "work")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	if err := c.Create("Invoices"); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	for i, date := range []time.Time{time.Now().AddDate(0, 0, -60), time.Now().AddDate(0, 0, -2), time.Now()} {
+		raw := fmt.Sprintf("From: billing@example.com\r\nSubject: Invoice %d\r\nContent-Type: text/plain\r\n\r\nAmount due: 10 EUR\r\n", i)
+		if err := c.Append("Invoices", nil, date, strings.NewReader(raw)); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	c.Logout()
+
+	db := openTestDatabase(t)
+	engine := emailsync.NewEngine(db, dial, []string{"work"}, 0, 0)
+	engine.Folders = func(accountID string) []emailsync.Folder {
+		return []emailsync.Folder{
+			{Name: "INBOX", HeadersOnly: true},
+			{Name: "Invoices", MaxAge: 30 * 24 * time.Hour, InitialLimit: 1},
+		}
+	}
+	var notified []string
+	engine.OnNewMail = func(accountID, folder string, count int) {
+		notified = append(notified, fmt.Sprintf("%s:%d", folder, count))
+	}
+	status, err := engine.SyncAccount(context.Background(), "work")
+	if err != nil {
+		t.Fatalf("SyncAccount: %v", err)
+	}
+	if status.NewByFolder["INBOX"] != 1 || status.NewByFolder["Invoices"] != 1 || status.NewMessages != 2 || status.SentFolder != "" {
+		t.Errorf("status = %+v", status)
+	}
+	if strings.Join(notified, " ") != "INBOX:1 Invoices:1" {
+		t.Errorf("OnNewMail calls = %v", notified)
+	}
+
+	emails, err := db.GetEmails("work", 10)
+	if err != nil || len(emails) != 2 {
+		t.Fatalf("GetEmails = %d emails, %v; want 2", len(emails), err)
+	}
+	for _, email := range emails {
+		switch email.Folder {
+		case "INBOX":
+			// Headers only
+			if email.BodySnippet != "" {
+				t.Errorf("INBOX snippet = %q, want none", email.BodySnippet)
+			}
+		case "Invoices":
+			// The newest of the two messages of the last 30 days
+			if email.Subject != "Invoice 2" || email.BodySnippet != "Amount due: 10 EUR" {
+				t.Errorf("Invoices email = %q, %q", email.Subject, email.BodySnippet)
+			}
+		default:
+			t.Errorf("email of unexpected folder %s", email.Folder)
+		}
+	}
+}
+
+func TestSyncStarsWithoutCondStore(t *testing.T) {
+	dial := newIMAPServer(t)
+	db := openTestDatabase(t)
+	engine := emailsync.NewEngine(db, dial, []string{"work"}, 0, 0)
+	if _, err := engine.SyncAccount(context.Background(), "work"); err != nil {
+		t.Fatalf("SyncAccount: %v", err)
+	}
+
+	// The memory backend has no CONDSTORE, so a star set by another client
+	// is found by searching for flagged messages
+	c, err := dial(context.Background(), "work")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	if _, err := c.Select("INBOX", false); err != nil {
+		t.Fatalf("Select: %v", err)
+	}
+	uidset := new(imap.SeqSet)
+	uidset.AddNum(6)
+	star := func(op imap.FlagsOp) {
+		if err := c.UidStore(uidset, imap.FormatFlagsOp(op, true), []interface{}{imap.FlaggedFlag}, nil); err != nil {
+			t.Fatalf("UidStore: %v", err)
+		}
+	}
+	starred := func() bool {
+		if _, err := engine.SyncAccount(context.Background(), "work"); err != nil {
+			t.Fatalf("SyncAccount: %v", err)
+		}
+		email, err := db.GetEmail("work", "INBOX", 6)
+		if err != nil {
+			t.Fatalf("GetEmail: %v", err)
+		}
+		return email.Starred
+	}
+
+	star(imap.AddFlags)
+	if !starred() {
+		t.Error("a star set on the server was not synced")
+	}
+	star(imap.RemoveFlags)
+	c.Logout()
+	if starred() {
+		t.Error("a star removed on the server was not synced")
+	}
+}
+
+func TestSyncRemovesExpungedMessages(t *testing.T) {
+	dial := newIMAPServer(t)
+	db := openTestDatabase(t)
+	c, err := dial(context.Background(), "work")
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Logout()
+	raw := "From: ana@example.com\r\nSubject: Second\r\nMessage-ID: <second@example.com>\r\n\r\nHello\r\n"
+	if err := c.Append("INBOX", nil, time.Now(), strings.NewReader(raw)); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+
+	engine := emailsync.NewEngine(db, dial, []string{"work"}, 0, 0)
+	if _, err := engine.SyncAccount(context.Background(), "work"); err != nil {
+		t.Fatalf("SyncAccount: %v", err)
+	}
+	if _, err := db.GetEmail("work", "INBOX", 7); err != nil {
+		t.Fatalf("appended message not synced: %v", err)
+	}
+
+	// Expunged by another client
+	if _, err := c.Select("INBOX", false); err != nil {
+		t.Fatalf("Select: %v", err)
+	}
+	uidset := new(imap.SeqSet)
+	uidset.AddNum(6)
+	if err := c.UidStore(uidset, imap.FormatFlagsOp(imap.AddFlags, true), []interface{}{imap.DeletedFlag}, nil); err != nil {
+		t.Fatalf("UidStore: %v", err)
+	}
+	if err := c.Expunge(nil); err != nil {
+		t.Fatalf("Expunge: %v", err)
+	}
+
+	if _, err := engine.SyncAccount(context.Background(), "work"); err != nil {
+		t.Fatalf("SyncAccount: %v", err)
+	}
+	if _, err := db.GetEmail("work", "INBOX", 6); err == nil {
+		t.Error("expunged message still stored")
+	}
+	if _, err := db.GetEmail("work", "INBOX", 7); err != nil {
+		t.Errorf("message still on the server was removed: %v", err)
+	}
+}
+
+func TestSyncPushRenewsAndReconnects(t *testing.T) {
+	dial := newIMAPServer(t)
+	db := openTestDatabase(t)
+
+	// The engine's connections, to drop them as a network change would
+	var mu sync.Mutex
+	var conns []*client.Client
+	engine := emailsync.NewEngine(db, func(ctx context.Context, accountID string) (*client.Client, error) {
+		c, err := dial(ctx, accountID)
+		if err == nil {
+			mu.Lock()
+			conns = append(conns, c)
+			mu.Unlock()
+		}
+		return c, err
+	}, []string{"work"}, 0, 0)
+	engine.Push = true
+	engine.IdleRenew = 200 * time.Millisecond
+	engine.IdleRetry = 50 * time.Millisecond
+	engine.Start()
+	defer engine.Stop()
+
+	appendMessage := func(subject string) {
+		c, err := dial(context.Background(), "work")
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer c.Logout()
+		raw := "From: ana@example.com\r\nSubject: " + subject + "\r\n\r\nHello\r\n"
+		if err := c.Append("INBOX", nil, time.Now(), strings.NewReader(raw)); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	waitFor := func(what string, done func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); !done(); time.Sleep(20 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s; status %+v", what, engine.Status())
+			}
+		}
+	}
+	synced := func(want int) func() bool {
+		return func() bool {
+			count, err := db.CountEmails("work")
+			return err == nil && count == want
+		}
+	}
+
+	// The listener syncs when it connects, and again after each renewal,
+	// which the in-memory server needs as it reports no changes in IDLE
+	waitFor("the first sync", synced(1))
+	appendMessage("While idle")
+	waitFor("the sync after a renewal", synced(2))
+
+	mu.Lock()
+	for _, c := range conns {
+		c.Terminate()
+	}
+	mu.Unlock()
+	appendMessage("While disconnected")
+	waitFor("the sync after reconnecting", synced(3))
+	waitFor("IDLE again", func() bool { return engine.Status()[0].Push == "idle" })
+}
+
+func TestSyncPushOutlastsCommandTimeout(t *testing.T) {
+	dial := newIMAPServer(t)
+	db := openTestDatabase(t)
+
+	// Like the server's dialer, connections get a command timeout, much
+	// shorter here than the renewal period
+	engine := emailsync.NewEngine(db, func(ctx context.Context, accountID string) (*client.Client, error) {
+		c, err := dial(ctx, accountID)
+		if err == nil {
+			c.Timeout = 100 * time.Millisecond
+		}
+		return c, err
+	}, []string{"work"}, 0, 0)
+	engine.Push = true
+	engine.IdleRenew = time.Minute
+	engine.IdleRetry = time.Minute
+	engine.Start()
+	defer engine.Stop()
+
+	for deadline := time.Now().Add(5 * time.Second); engine.Status()[0].Push != "idle"; time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for IDLE; status %+v", engine.Status())
+		}
+	}
+	for end := time.Now().Add(time.Second); time.Now().Before(end); time.Sleep(50 * time.Millisecond) {
+		if push := engine.Status()[0].Push; push != "idle" {
+			t.Fatalf("IDLE ended within the renewal period: %s", push)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/server"
+)
+
+// testCertificate returns a certificate for 127.0.0.1 and a PEM file of
+// its CA
+func testCertificate(t *testing.T) ([]tls.Certificate, string) {
+	t.Helper()
+	// httptest has one at hand
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	ts.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return ts.TLS.Certificates, caFile
+}
+
+// newTLSServer starts an IMAP server offering STARTTLS, up to TLS 1.2, with
+// a certificate for 127.0.0.1, and returns an account on it without TLS
+// settings and a PEM file of the certificate's CA
+func newTLSServer(t *testing.T) (EmailConfig, string) {
+	t.Helper()
+	certs, caFile := testCertificate(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	s := server.New(memory.New())
+	s.TLSConfig = &tls.Config{Certificates: certs, MaxVersion: tls.VersionTLS12}
+	go s.Serve(listener)
+	t.Cleanup(func() { s.Close() })
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	imapPort, _ := strconv.Atoi(port)
+	return EmailConfig{ID: "lab", Username: "username", Password: "password",
+		IMAPHost: host, IMAPPort: imapPort, UseStartTLS: true}, caFile
+}
+
+func TestAccountTLS(t *testing.T) {
+	config, caFile := newTLSServer(t)
+	tests := []struct {
+		name    string
+		set     func(c *EmailConfig)
+		problem string // "" when the login works
+	}{
+		{"unknown CA", func(c *EmailConfig) {}, problemTLS},
+		{"CA file", func(c *EmailConfig) { c.TLSCAFile = caFile }, ""},
+		{"skip verify", func(c *EmailConfig) { c.TLSInsecureSkipVerify = true }, ""},
+		{"min version above the server's", func(c *EmailConfig) { c.TLSCAFile, c.TLSMinVersion = caFile, "1.3" }, problemTLS},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := config
+			tt.set(&c)
+			client, err := dialIMAP(context.Background(), &c, nil)
+			if tt.problem == "" {
+				if err != nil {
+					t.Fatalf("dialIMAP: %v", err)
+				}
+				client.Logout()
+				return
+			}
+			if err == nil {
+				client.Logout()
+				t.Fatal("dialIMAP succeeded")
+			}
+			if problem := classifyLoginError(err); problem != tt.problem {
+				t.Errorf("dialIMAP error %q classified as %s, want %s", err, problem, tt.problem)
+			}
+		})
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "ca.txt")
+	os.WriteFile(notPEM, []byte("not a certificate"), 0o600)
+
+	for name, c := range map[string]EmailConfig{
+		"missing CA file": {TLSCAFile: filepath.Join(dir, "missing.pem")},
+		"CA file not PEM": {TLSCAFile: notPEM},
+		"bad key pair":    {TLSCertFile: notPEM, TLSKeyFile: notPEM},
+	} {
+		if _, err := c.tlsConfig("imap.lab.local"); err == nil {
+			t.Errorf("%s: tlsConfig succeeded", name)
+		}
+	}
+
+	c := EmailConfig{TLSMinVersion: "1.3", TLSInsecureSkipVerify: true}
+	config, err := c.tlsConfig("imap.lab.local")
+	if err != nil || config.MinVersion != tls.VersionTLS13 || !config.InsecureSkipVerify || config.ServerName != "imap.lab.local" {
+		t.Errorf("tlsConfig = %+v, %v", config, err)
+	}
+}
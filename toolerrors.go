@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"email-mcp-server/credentials"
+	"email-mcp-server/graph"
+	"email-mcp-server/storage"
+)
+
+// A tool that fails is answered with a result marked isError, so the client
+// shows its text to the model like any other result, rather than with a
+// JSON-RPC error. JSON-RPC errors are kept for problems with the request
+// itself: a malformed call, an unknown tool or arguments that do not match
+// the tool's schema.
+
+// ErrorData describes why a call failed: a code a client can act on, the
+// account the call was for and whether the same call may succeed if retried
+// later. Failed tools carry it as JSON after the error text; JSON-RPC errors
+// carry it as their data.
+type ErrorData struct {
+	Code      string `json:"code"`
+	Account   string `json:"account,omitempty"`
+	Retryable bool   `json:"retryable"`
+}
+
+// Error codes of ErrorData
+const (
+	errCodeTimeout      = "timeout"
+	errCodeRateLimited  = "rate_limited"
+	errCodeConnection   = "connection_failed"
+	errCodeAuth         = "auth_failed"
+	errCodeAccount      = "account_not_found"
+	errCodeNotFound     = "not_found"
+	errCodeInvalidArgs  = "invalid_arguments"
+	errCodeUnavailable  = "unavailable"
+	errCodeToolFailed   = "tool_failed"
+	errCodeUnknownTool  = "unknown_tool"
+	errCodeDisabledTool = "tool_disabled"
+)
+
+// Errors that tools wrap with %w, so that classifyError knows them whatever
+// the message around them says
+var (
+	errMissingParameter = errors.New("missing required parameter")
+	errInvalidEmailID   = errors.New("invalid email ID")
+	errNotFound         = errors.New("not found")
+	errAccountNotFound  = errors.New("account not found")
+	errAmbiguousAccount = errors.New("is ambiguous")
+	errNoDatabase       = errors.New("local database could not be opened")
+	errRateLimit        = errors.New("rate limit")
+)
+
+// argumentError is an argument a tool rejects beyond what its schema checks,
+// such as a malformed date
+type argumentError struct {
+	err error
+}
+
+func (e *argumentError) Error() string { return e.err.Error() }
+func (e *argumentError) Unwrap() error { return e.err }
+
+// invalidArgument returns an argumentError with the text of fmt.Errorf
+func invalidArgument(format string, args ...interface{}) error {
+	return &argumentError{err: fmt.Errorf(format, args...)}
+}
+
+// failedLogin is a login to a server of an account that failed, or was not
+// tried while its failures cool down, with the problem classifyLoginError
+// found
+type failedLogin struct {
+	problem string
+	err     error
+}
+
+func (e *failedLogin) Error() string { return e.err.Error() }
+func (e *failedLogin) Unwrap() error { return e.err }
+
+// loginFailure returns err, the error of a login, as a failedLogin; nil when
+// err is nil
+func loginFailure(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &failedLogin{problem: classifyLoginError(err), err: err}
+}
+
+// errorKinds gives the code of the errors wrapping each error, first match
+// wins
+var errorKinds = []struct {
+	err       error
+	code      string
+	retryable bool
+}{
+	{errRateLimit, errCodeRateLimited, true},
+	{errAccountNotFound, errCodeAccount, false},
+	{errAmbiguousAccount, errCodeAccount, false},
+	{errMissingParameter, errCodeInvalidArgs, false},
+	{errInvalidEmailID, errCodeInvalidArgs, false},
+	{errNoDatabase, errCodeUnavailable, false},
+	{errNotFound, errCodeNotFound, false},
+	{storage.ErrNotFound, errCodeNotFound, false},
+	{sql.ErrNoRows, errCodeNotFound, false},
+	{credentials.ErrNotFound, errCodeAuth, false},
+	{io.ErrUnexpectedEOF, errCodeConnection, true},
+	{syscall.ECONNREFUSED, errCodeConnection, true},
+	{syscall.ECONNRESET, errCodeConnection, true},
+	{syscall.EPIPE, errCodeConnection, true},
+	{syscall.ENETUNREACH, errCodeConnection, true},
+}
+
+// loginProblems gives the code of each kind of failedLogin
+var loginProblems = map[string]struct {
+	code      string
+	retryable bool
+}{
+	problemAuth:    {errCodeAuth, false},
+	problemQuota:   {errCodeRateLimited, true},
+	problemNetwork: {errCodeConnection, true},
+	problemTLS:     {errCodeConnection, false},
+}
+
+// classifyError gives the code of err and whether retrying may help
+func classifyError(err error) (string, bool) {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return errCodeTimeout, true
+	}
+	var login *failedLogin
+	if errors.As(err, &login) {
+		if kind, ok := loginProblems[login.problem]; ok {
+			return kind.code, kind.retryable
+		}
+	}
+	var argErr *argumentError
+	if errors.As(err, &argErr) {
+		return errCodeInvalidArgs, false
+	}
+	var graphErr *graph.Error
+	if errors.As(err, &graphErr) {
+		switch {
+		case graphErr.Status == http.StatusTooManyRequests:
+			return errCodeRateLimited, true
+		case graphErr.Status == http.StatusUnauthorized || graphErr.Status == http.StatusForbidden:
+			return errCodeAuth, false
+		case graphErr.Status == http.StatusNotFound:
+			return errCodeNotFound, false
+		case graphErr.Status >= 500:
+			return errCodeConnection, true
+		}
+	}
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind.err) {
+			return kind.code, kind.retryable
+		}
+	}
+	if errors.As(err, &netErr) {
+		return errCodeConnection, true
+	}
+	return errCodeToolFailed, false
+}
+
+// errorAccount names the account a failed call was for: the ID of the
+// account argument when it resolves, the argument as given when it does not
+func (es *EmailServer) errorAccount(args map[string]interface{}) string {
+	account := es.auditAccount(args)
+	if config, err := es.getConfig(account); err == nil {
+		return config.ID
+	}
+	return account
+}
+
+// toolErrorResult turns the error of a tool into a result marked isError: the
+// error text, followed by its ErrorData as JSON
+func (es *EmailServer) toolErrorResult(err error, args map[string]interface{}) ToolResult {
+	code, retryable := classifyError(err)
+	data, _ := json.MarshalIndent(ErrorData{
+		Code:      code,
+		Account:   es.errorAccount(args),
+		Retryable: retryable,
+	}, "", "  ")
+	return ToolResult{
+		Content: []TextContent{
+			{Type: "text", Text: err.Error()},
+			{Type: "text", Text: string(data)},
+		},
+		IsError: true,
+	}
+}
+
+// ToolNotFoundError is a tools/call for a tool that is not registered or is
+// hidden by TOOLS_ALLOW or TOOLS_DENY; it is answered with a -32602 error
+type ToolNotFoundError struct {
+	Tool     string
+	Disabled bool
+}
+
+func (e *ToolNotFoundError) Error() string {
+	if e.Disabled {
+		return "tool " + e.Tool + " is disabled on this server"
+	}
+	return "unknown tool: " + e.Tool
+}
+
+// Data is the ErrorData of the -32602 error
+func (e *ToolNotFoundError) Data() ErrorData {
+	if e.Disabled {
+		return ErrorData{Code: errCodeDisabledTool}
+	}
+	return ErrorData{Code: errCodeUnknownTool}
+}
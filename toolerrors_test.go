@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+
+	"email-mcp-server/credentials"
+	"email-mcp-server/graph"
+	"email-mcp-server/storage"
+)
+
+// timeoutError is a net.Error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		code      string
+		retryable bool
+	}{
+		{"deadline", fmt.Errorf("get_emails timed out after 30s: %w", context.DeadlineExceeded), errCodeTimeout, true},
+		{"network timeout", &net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}, errCodeTimeout, true},
+		{"rate limit", fmt.Errorf("gave up waiting for the IMAP %w of account work: %w", errRateLimit, context.Canceled), errCodeRateLimited, true},
+		{"unknown account", fmt.Errorf("%w: home", errAccountNotFound), errCodeAccount, false},
+		{"ambiguous account", fmt.Errorf("account %q %w", "w", errAmbiguousAccount), errCodeAccount, false},
+		{"missing parameter", fmt.Errorf("%w: to", errMissingParameter), errCodeInvalidArgs, false},
+		{"invalid email ID", fmt.Errorf("%w: abc", errInvalidEmailID), errCodeInvalidArgs, false},
+		{"invalid argument", fmt.Errorf("search: %w", invalidArgument("invalid date %q", "yesterday")), errCodeInvalidArgs, false},
+		{"no database", fmt.Errorf("searching needs the %w", errNoDatabase), errCodeUnavailable, false},
+		{"email not found", fmt.Errorf("email with ID %d %w", 7, errNotFound), errCodeNotFound, false},
+		{"stored row not found", fmt.Errorf("draft 3: %w", storage.ErrNotFound), errCodeNotFound, false},
+		{"no rows", fmt.Errorf("loading thread: %w", sql.ErrNoRows), errCodeNotFound, false},
+		{"password not found", fmt.Errorf("account work: %w", credentials.ErrNotFound), errCodeAuth, false},
+		{"login rejected", loginFailure(errors.New("NO [AUTHENTICATIONFAILED] Invalid credentials")), errCodeAuth, false},
+		{"login paused", &failedLogin{problem: problemQuota, err: errors.New("IMAP login of account work paused")}, errCodeRateLimited, true},
+		{"login over TLS", &failedLogin{problem: problemTLS, err: errors.New("x509: certificate signed by unknown authority")}, errCodeConnection, false},
+		{"graph throttled", fmt.Errorf("listing messages: %w", &graph.Error{Status: 429}), errCodeRateLimited, true},
+		{"graph unauthorized", &graph.Error{Status: 401}, errCodeAuth, false},
+		{"graph not found", &graph.Error{Status: 404}, errCodeNotFound, false},
+		{"graph unavailable", &graph.Error{Status: 503}, errCodeConnection, true},
+		{"connection refused", fmt.Errorf("dial: %w", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}), errCodeConnection, true},
+		{"connection reset", fmt.Errorf("fetching: %w", syscall.ECONNRESET), errCodeConnection, true},
+		{"other", errors.New("the server said no"), errCodeToolFailed, false},
+		{"other mentioning a kind", errors.New("message body not found in timeout folder"), errCodeToolFailed, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, retryable := classifyError(tt.err)
+			if code != tt.code || retryable != tt.retryable {
+				t.Errorf("classifyError(%q) = %s, %v; want %s, %v", tt.err, code, retryable, tt.code, tt.retryable)
+			}
+		})
+	}
+}
@@ -0,0 +1,2663 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path"
+)
+
+// maxUID is the largest IMAP UID, the bound of email ID arguments
+const maxUID int64 = math.MaxUint32
+
+// ToolHandler executes a tool call with its decoded arguments. ctx is
+// cancelled when the call times out, the client cancels the request or
+// disconnects.
+type ToolHandler func(ctx context.Context, args map[string]interface{}) (interface{}, error)
+
+// ToolRegistry keeps each tool's schema next to its handler, so tools/list
+// and tools/call can never disagree about which tools exist
+type ToolRegistry struct {
+	tools    []Tool
+	handlers map[string]ToolHandler
+	schemas  map[string]interface{} // Input schemas the arguments are validated against
+	mutating map[string]bool
+	disabled map[string]bool // Tools removed by Restrict
+}
+
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{handlers: make(map[string]ToolHandler), schemas: make(map[string]interface{}),
+		mutating: make(map[string]bool), disabled: make(map[string]bool)}
+}
+
+// Register adds a tool. Tools are listed in registration order.
+func (r *ToolRegistry) Register(tool Tool, handler ToolHandler) {
+	if _, exists := r.handlers[tool.Name]; exists {
+		panic(fmt.Sprintf("tool registered twice: %s", tool.Name))
+	}
+	r.tools = append(r.tools, tool)
+	r.handlers[tool.Name] = handler
+	r.schemas[tool.Name] = tool.InputSchema
+	r.mutating[tool.Name] = tool.Mutating
+}
+
+// List returns the schemas of every registered tool
+func (r *ToolRegistry) List() []Tool {
+	return r.tools
+}
+
+// Mutating reports whether the named tool changes mail, accounts or
+// settings
+func (r *ToolRegistry) Mutating(name string) bool {
+	return r.mutating[name]
+}
+
+// Disabled reports whether Restrict removed the named tool
+func (r *ToolRegistry) Disabled(name string) bool {
+	return r.disabled[name]
+}
+
+// Restrict keeps only the tools matching a pattern of allow (every tool
+// when empty) and none of deny. Patterns are names or path.Match globs
+// such as "get_*". Removed tools are neither listed nor callable. A
+// pattern matching no tool is an error, so a typo cannot silently expose
+// or hide tools.
+func (r *ToolRegistry) Restrict(allow, deny []string) error {
+	for _, pattern := range append(append([]string{}, allow...), deny...) {
+		if !r.matchesAny(pattern) {
+			return fmt.Errorf("TOOLS_ALLOW/TOOLS_DENY pattern %q matches no tool", pattern)
+		}
+	}
+
+	var kept []Tool
+	for _, tool := range r.tools {
+		if (len(allow) > 0 && !matchTool(allow, tool.Name)) || matchTool(deny, tool.Name) {
+			r.disabled[tool.Name] = true
+			delete(r.handlers, tool.Name)
+			continue
+		}
+		kept = append(kept, tool)
+	}
+	r.tools = kept
+	return nil
+}
+
+func (r *ToolRegistry) matchesAny(pattern string) bool {
+	for name := range r.handlers {
+		if matchTool([]string{pattern}, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTool reports whether name matches one of patterns
+func matchTool(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Call routes a tools/call request to the registered handler once its
+// arguments match the tool's input schema; an *InvalidParamsError otherwise
+func (r *ToolRegistry) Call(ctx context.Context, params ToolCallParams) (interface{}, error) {
+	if r.disabled[params.Name] {
+		return nil, &ToolNotFoundError{Tool: params.Name, Disabled: true}
+	}
+	handler, ok := r.handlers[params.Name]
+	if !ok {
+		return nil, &ToolNotFoundError{Tool: params.Name}
+	}
+	if err := validateArgs(params.Name, r.schemas[params.Name], params.Arguments); err != nil {
+		return nil, err
+	}
+	return handler(ctx, params.Arguments)
+}
+
+func (es *EmailServer) registerTools() *ToolRegistry {
+	r := NewToolRegistry()
+
+	r.Register(Tool{
+		Name:        "get_emails",
+		Description: "Get list of emails from inbox. Emails with phishing warning signs carry a phishing_risk report; emails of Gmail accounts list their labels",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to use (default: INBOX)",
+				},
+				"limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Maximum number of emails to retrieve (default: 10)",
+					"minimum":     1,
+					"maximum":     100,
+				},
+				"cursor": map[string]interface{}{
+					"type":        "string",
+					"description": "next_cursor returned by the previous call, to get the next page (optional)",
+				},
+				"include_body": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Fetch and decode the message body (default: false)",
+				},
+				"include_html": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Also return the HTML body when include_body is set (default: false)",
+				},
+				"hide_duplicates": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Leave out emails that sync found to be copies of an earlier email, see find_duplicates (default: false)",
+				},
+				"label": map[string]interface{}{
+					"type":        "string",
+					"description": "Only list emails with this Gmail label, such as Receipts or \\Important (optional, Gmail accounts only)",
+				},
+			},
+		},
+	}, es.handleGetEmails)
+
+	r.Register(Tool{
+		Name:        "get_email_body",
+		Description: "Get the full decoded body of an email by ID",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to use (default: INBOX)",
+				},
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Email ID to read",
+					"minimum":     1,
+					"maximum":     maxUID,
+				},
+				"include_html": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Also return the HTML body (default: false)",
+				},
+			},
+			"required": []string{"id"},
+		},
+	}, es.handleGetEmailBody)
+
+	r.Register(Tool{
+		Name:        "send_email",
+		Description: "Send an email",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use for sending (optional, uses default if not specified)",
+				},
+				"to": map[string]interface{}{
+					"type":        []string{"string", "array"},
+					"description": "Recipient email address, or an array of addresses. A contact name (see search_contacts) is replaced by its address",
+					"items":       map[string]interface{}{"type": "string"},
+				},
+				"cc": map[string]interface{}{
+					"type":        []string{"string", "array"},
+					"description": "Carbon-copy recipients (optional)",
+					"items":       map[string]interface{}{"type": "string"},
+				},
+				"bcc": map[string]interface{}{
+					"type":        []string{"string", "array"},
+					"description": "Blind carbon-copy recipients, not shown in the headers (optional)",
+					"items":       map[string]interface{}{"type": "string"},
+				},
+				"subject": map[string]interface{}{
+					"type":        "string",
+					"description": "Email subject",
+				},
+				"body": map[string]interface{}{
+					"type":        "string",
+					"description": "Email body content",
+				},
+				"attachments": map[string]interface{}{
+					"type":        "array",
+					"description": "Files to attach (optional)",
+					"items": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"path": map[string]interface{}{
+								"type":        "string",
+								"description": "Local file path to attach",
+							},
+							"content": map[string]interface{}{
+								"type":        "string",
+								"description": "Base64-encoded file content (alternative to path)",
+							},
+							"filename": map[string]interface{}{
+								"type":        "string",
+								"description": "Attachment file name (required with content)",
+							},
+							"content_type": map[string]interface{}{
+								"type":        "string",
+								"description": "MIME type (optional, guessed from filename)",
+							},
+						},
+					},
+				},
+				"notify": map[string]interface{}{
+					"type":        "array",
+					"description": "Delivery status notifications to request from the server (DSN), overriding the account's SMTPNotify: success, failure and delay reports are sent to the account's address; never asks for none (optional)",
+					"items":       map[string]interface{}{"type": "string", "enum": []string{"success", "failure", "delay", "never"}},
+				},
+				"expect_reply_by": map[string]interface{}{
+					"type":        "string",
+					"description": "Track the email with track_followup, expecting a reply by this time: a duration such as 3d or a time as in schedule_email (optional)",
+				},
+			},
+			"required": []string{"to", "subject", "body"},
+		},
+	}, es.confirmed("send_email", es.prepareSendEmail, es.handleSendEmail))
+
+	r.Register(Tool{
+		Name:        "list_attachments",
+		Description: "List the attachments of an email",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to use (default: INBOX)",
+				},
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Email ID",
+					"minimum":     1,
+					"maximum":     maxUID,
+				},
+			},
+			"required": []string{"id"},
+		},
+	}, es.handleListAttachments)
+
+	r.Register(Tool{
+		Name:        "download_attachment",
+		Description: "Download an attachment, saving it to the downloads directory or returning it base64-encoded",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to use (default: INBOX)",
+				},
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Email ID",
+					"minimum":     1,
+					"maximum":     maxUID,
+				},
+				"part": map[string]interface{}{
+					"type":        "string",
+					"description": "Attachment part as returned by list_attachments (e.g. \"2\" or \"1.2\")",
+				},
+				"save": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Save to the downloads directory instead of returning base64 content (default: false)",
+				},
+			},
+			"required": []string{"id", "part"},
+		},
+	}, es.handleDownloadAttachment)
+
+	r.Register(Tool{
+		Name:        "export_email",
+		Description: "Export the full RFC 822 source of an email (.eml), as base64 content or saved to a file",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to use (default: INBOX)",
+				},
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Email ID to export",
+					"minimum":     1,
+					"maximum":     maxUID,
+				},
+				"path": map[string]interface{}{
+					"type":        "string",
+					"description": "File or directory inside the downloads directory to save the .eml to, relative to it. Existing files are never overwritten (optional, returns base64 content if not specified)",
+				},
+			},
+			"required": []string{"id"},
+		},
+	}, es.handleExportEmail)
+
+	r.Register(Tool{
+		Name:        "import_eml",
+		Description: "Import an .eml file into a folder with IMAP APPEND, keeping its original date",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to import into (default: INBOX)",
+				},
+				"path": map[string]interface{}{
+					"type":        "string",
+					"description": "The .eml file to import, inside the downloads directory and relative to it",
+				},
+				"content": map[string]interface{}{
+					"type":        "string",
+					"description": "Base64-encoded message source (alternative to path)",
+				},
+				"seen": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Mark the imported email as read (default: true)",
+				},
+			},
+		},
+	}, es.handleImportEML)
+
+	r.Register(Tool{
+		Name:        "summarize_emails",
+		Description: "Get a summary of emails in inbox",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to use (default: INBOX)",
+				},
+				"limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Number of emails to analyze (default: 50)",
+					"minimum":     1,
+					"maximum":     200,
+				},
+			},
+		},
+	}, es.handleSummarizeEmails)
+
+	r.Register(Tool{
+		Name:        "summarize_email",
+		Description: "Summarize a single email with the configured LLM (extractive summary when no provider is configured)",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to use (default: INBOX)",
+				},
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Email ID to summarize",
+					"minimum":     1,
+					"maximum":     maxUID,
+				},
+			},
+			"required": []string{"id"},
+		},
+	}, es.handleSummarizeEmail)
+
+	r.Register(Tool{
+		Name:        "summarize_thread",
+		Description: "Summarize a whole conversation from the local database with the configured LLM",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder of the email given by id (default: INBOX)",
+				},
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "ID of any email in the thread",
+					"minimum":     1,
+					"maximum":     maxUID,
+				},
+				"thread_id": map[string]interface{}{
+					"type":        "string",
+					"description": "Thread ID (alternative to id)",
+				},
+			},
+		},
+	}, es.handleSummarizeThread)
+
+	r.Register(Tool{
+		Name:        "extract_action_items",
+		Description: "List the actions an email or a whole thread asks for, with their owners and due dates, using the configured LLM or pattern matching without one. The items are stored so the daily digest can mark emails that need action",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder of the email given by id (default: INBOX)",
+				},
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Email ID",
+					"minimum":     1,
+					"maximum":     maxUID,
+				},
+				"thread": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Scan the whole thread of the email given by id (default: false)",
+				},
+				"thread_id": map[string]interface{}{
+					"type":        "string",
+					"description": "Thread ID to scan (alternative to id)",
+				},
+			},
+		},
+	}, es.handleExtractActionItems)
+
+	r.Register(Tool{
+		Name:        "classify_emails",
+		Description: "Categorize emails with the classification rules, asking the LLM when the rules are unsure",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to use (default: INBOX)",
+				},
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Classify only this email (optional)",
+					"minimum":     1,
+					"maximum":     maxUID,
+				},
+				"limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Number of recent emails to classify when no id is given (default: 10)",
+					"minimum":     1,
+					"maximum":     50,
+				},
+			},
+		},
+	}, es.handleClassifyEmails)
+
+	r.Register(Tool{
+		Name:        "correct_classification",
+		Description: "Correct the category of an email, or confirm it by passing the same category. Repeated corrections teach the classifier sender categories and lower the confidence of rules that keep getting it wrong. Resolves the email in review_queue",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to use (default: INBOX)",
+				},
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Email ID to correct",
+					"minimum":     1,
+					"maximum":     maxUID,
+				},
+				"category": map[string]interface{}{
+					"type":        "string",
+					"description": "The correct category",
+				},
+			},
+			"required": []string{"id", "category"},
+		},
+	}, es.handleCorrectClassification)
+
+	r.Register(Tool{
+		Name:        "review_queue",
+		Description: "List the classified emails whose confidence is below classification.review_threshold, least confident first. Resolve each with correct_classification, which also feeds the classifier's learning",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Maximum number of emails to list (default: 20)",
+					"minimum":     1,
+				},
+			},
+		},
+	}, es.handleReviewQueue)
+
+	r.Register(Tool{
+		Name:        "test_rules",
+		Description: "Dry-run priority_rules.json against an email: which rules match, which almost match and why, and the resulting category. Reads the rules file again, so edits can be tested without restarting",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account whose rules and emails to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to use (default: INBOX)",
+				},
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Email ID to test",
+					"minimum":     1,
+					"maximum":     maxUID,
+				},
+				"from": map[string]interface{}{
+					"type":        "string",
+					"description": "Sample sender, when testing without an ID",
+				},
+				"to": map[string]interface{}{
+					"type":        "string",
+					"description": "Sample recipient, when testing without an ID",
+				},
+				"subject": map[string]interface{}{
+					"type":        "string",
+					"description": "Sample subject, when testing without an ID",
+				},
+				"body": map[string]interface{}{
+					"type":        "string",
+					"description": "Sample body, when testing without an ID",
+				},
+				"attachments": map[string]interface{}{
+					"type":        "array",
+					"description": "Sample attachment file names, when testing without an ID; their types are guessed from the extensions",
+					"items":       map[string]interface{}{"type": "string"},
+				},
+			},
+		},
+	}, es.handleTestRules)
+
+	r.Register(Tool{
+		Name:        "check_phishing",
+		Description: "Check an email for spam and phishing signs: SPF/DKIM/DMARC results, display-name spoofing, Reply-To mismatches and suspicious links, with a 0-100 risk score",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to use (default: INBOX)",
+				},
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Email ID to check",
+					"minimum":     1,
+					"maximum":     maxUID,
+				},
+				"raw": map[string]interface{}{
+					"type":        "string",
+					"description": "Full message source, headers included, to check instead of an email by ID",
+				},
+			},
+		},
+	}, es.handleCheckPhishing)
+
+	r.Register(Tool{
+		Name:        "list_meetings",
+		Description: "List meeting invitations (.ics calendar parts) in recent emails with their time, location, organizer and your RSVP status",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to scan (default: INBOX)",
+				},
+				"limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Number of most recent emails to scan (default: 50)",
+				},
+				"include_past": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Also list meetings that have already ended (default: false)",
+				},
+			},
+		},
+	}, es.handleListMeetings)
+
+	r.Register(Tool{
+		Name:        "respond_to_meeting",
+		Description: "Accept, decline or tentatively accept a meeting invitation; the response is emailed to the organizer so their calendar updates",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to use (default: INBOX)",
+				},
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "ID of the email with the invitation",
+					"minimum":     1,
+					"maximum":     maxUID,
+				},
+				"response": map[string]interface{}{
+					"type":        "string",
+					"enum":        []string{"accept", "decline", "tentative"},
+					"description": "Your answer",
+				},
+				"comment": map[string]interface{}{
+					"type":        "string",
+					"description": "Note for the organizer, added to the response email (optional)",
+				},
+			},
+			"required": []string{"id", "response"},
+		},
+	}, es.handleRespondToMeeting)
+
+	r.Register(Tool{
+		Name:        "generate_reply",
+		Description: "Draft a reply to an email with the configured LLM; the result can be passed to create_draft or send_email",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to use (default: INBOX)",
+				},
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Email ID to reply to",
+					"minimum":     1,
+					"maximum":     maxUID,
+				},
+				"from": map[string]interface{}{
+					"type":        "string",
+					"description": "Sender of the original message (when no id is given)",
+				},
+				"subject": map[string]interface{}{
+					"type":        "string",
+					"description": "Subject of the original message (when no id is given)",
+				},
+				"body": map[string]interface{}{
+					"type":        "string",
+					"description": "Body of the original message (when no id is given)",
+				},
+				"tone": map[string]interface{}{
+					"type":        "string",
+					"description": "Tone of the reply (default: neutral)",
+					"enum":        []string{"formal", "friendly", "neutral"},
+				},
+				"length": map[string]interface{}{
+					"type":        "string",
+					"description": "Length of the reply (default: normal)",
+					"enum":        []string{"brief", "normal", "detailed"},
+				},
+				"intent": map[string]interface{}{
+					"type":        "string",
+					"description": "What the reply should do (optional)",
+					"enum":        []string{"accept", "decline", "acknowledge"},
+				},
+				"instructions": map[string]interface{}{
+					"type":        "string",
+					"description": "Extra guidance for the reply, e.g. \"propose Tuesday instead\" (optional)",
+				},
+			},
+		},
+	}, es.handleGenerateReply)
+
+	r.Register(Tool{
+		Name:        "mark_vip",
+		Description: "Mark a sender as a VIP",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"email": map[string]interface{}{
+					"type":        "string",
+					"description": "Sender email address",
+				},
+				"note": map[string]interface{}{
+					"type":        "string",
+					"description": "Why this sender matters (optional)",
+				},
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Make the sender a VIP of this account only (optional); saved to the accounts section of priority_rules.json instead of the database",
+				},
+				"update_rules": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Also add the sender to vip_senders in priority_rules.json (default: false)",
+				},
+			},
+			"required": []string{"email"},
+		},
+	}, es.handleMarkVIP)
+
+	r.Register(Tool{
+		Name:        "unmark_vip",
+		Description: "Remove a sender from the VIP list",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"email": map[string]interface{}{
+					"type":        "string",
+					"description": "Sender email address",
+				},
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Remove the sender from the VIPs of this account only (optional); saved to the accounts section of priority_rules.json instead of the database",
+				},
+				"update_rules": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Also remove the sender from vip_senders in priority_rules.json (default: false)",
+				},
+			},
+			"required": []string{"email"},
+		},
+	}, es.handleUnmarkVIP)
+
+	r.Register(Tool{
+		Name:        "list_vips",
+		Description: "List VIP senders with how many synced emails came from each",
+		InputSchema: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{},
+		},
+	}, es.handleListVIPs)
+
+	r.Register(Tool{
+		Name:        "unsubscribe",
+		Description: "Show the List-Unsubscribe options of an email, or act on one by sending the unsubscribe email or the RFC 8058 one-click request",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to use (default: INBOX)",
+				},
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Email ID of a message from the mailing list",
+					"minimum":     1,
+					"maximum":     maxUID,
+				},
+				"method": map[string]interface{}{
+					"type":        "string",
+					"description": "How to unsubscribe; omit to only list the options",
+					"enum":        []string{"mailto", "one_click"},
+				},
+			},
+			"required": []string{"id"},
+		},
+	}, es.confirmed("unsubscribe", es.prepareUnsubscribe, es.handleUnsubscribe))
+
+	r.Register(Tool{
+		Name:        "delete_email",
+		Description: "Delete an email by ID",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to use (default: INBOX)",
+				},
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Email ID to delete",
+					"minimum":     1,
+					"maximum":     maxUID,
+				},
+			},
+			"required": []string{"id"},
+		},
+	}, es.confirmed("delete_email", es.prepareDeleteEmail, es.handleDeleteEmail))
+
+	r.Register(Tool{
+		Name:        "get_verification_code",
+		Description: "Find the login or verification code (4 to 8 digits) in the newest inbox email of the last few minutes sent to deliver one, optionally deleting that email afterwards",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"minutes": map[string]interface{}{
+					"type":        "number",
+					"description": "How many minutes back to look (default: 10)",
+					"minimum":     1,
+				},
+				"sender": map[string]interface{}{
+					"type":        "string",
+					"description": "Only consider emails whose sender contains this text, e.g. github.com (optional)",
+				},
+				"delete": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Delete the email once the code is read (default: false)",
+				},
+			},
+		},
+	}, es.confirmed("get_verification_code", es.prepareGetVerificationCode, es.handleGetVerificationCode))
+
+	r.Register(Tool{
+		Name:        "move_email",
+		Description: "Move an email to another folder",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to use (default: INBOX)",
+				},
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Email ID to move",
+					"minimum":     1,
+					"maximum":     maxUID,
+				},
+				"destination": map[string]interface{}{
+					"type":        "string",
+					"description": "Destination folder (e.g. \"Processed\")",
+				},
+			},
+			"required": []string{"id", "destination"},
+		},
+	}, es.handleMoveEmail)
+
+	r.Register(Tool{
+		Name:        "archive_email",
+		Description: "Move an email to the account's archive folder",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to use (default: INBOX)",
+				},
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Email ID to archive",
+					"minimum":     1,
+					"maximum":     maxUID,
+				},
+			},
+			"required": []string{"id"},
+		},
+	}, es.handleArchiveEmail)
+
+	r.Register(Tool{
+		Name:        "set_flags",
+		Description: "Add or remove flags on an email (mark as read/unread, flag, answered, custom keywords)",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to use (default: INBOX)",
+				},
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Email ID",
+					"minimum":     1,
+					"maximum":     maxUID,
+				},
+				"add": map[string]interface{}{
+					"type":        "array",
+					"description": "Flags to add: seen, flagged, answered, or custom keywords",
+					"items":       map[string]interface{}{"type": "string"},
+				},
+				"remove": map[string]interface{}{
+					"type":        "array",
+					"description": "Flags to remove: seen, flagged, answered, or custom keywords",
+					"items":       map[string]interface{}{"type": "string"},
+				},
+			},
+			"required": []string{"id"},
+		},
+	}, es.handleSetFlags)
+
+	r.Register(Tool{
+		Name:        "star_email",
+		Description: "Star an email, setting the \\Flagged flag other email clients show as a star or pin, on the server and in the local database",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to use (default: INBOX)",
+				},
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Email ID",
+					"minimum":     1,
+					"maximum":     maxUID,
+				},
+			},
+			"required": []string{"id"},
+		},
+	}, es.handleStarEmail)
+
+	r.Register(Tool{
+		Name:        "unstar_email",
+		Description: "Remove the star of an email, clearing its \\Flagged flag on the server and in the local database",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to use (default: INBOX)",
+				},
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Email ID",
+					"minimum":     1,
+					"maximum":     maxUID,
+				},
+			},
+			"required": []string{"id"},
+		},
+	}, es.handleUnstarEmail)
+
+	r.Register(Tool{
+		Name:        "add_label",
+		Description: "Add a Gmail label to an email (Gmail accounts only); the label is created if it does not exist",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to use (default: INBOX)",
+				},
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Email ID",
+					"minimum":     1,
+					"maximum":     maxUID,
+				},
+				"label": map[string]interface{}{
+					"type":        "string",
+					"description": "Label to add, such as Receipts, Projects/2024 or \\Starred",
+				},
+			},
+			"required": []string{"id", "label"},
+		},
+	}, es.handleAddLabel)
+
+	r.Register(Tool{
+		Name:        "remove_label",
+		Description: "Remove a Gmail label from an email (Gmail accounts only)",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to use (default: INBOX)",
+				},
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Email ID",
+					"minimum":     1,
+					"maximum":     maxUID,
+				},
+				"label": map[string]interface{}{
+					"type":        "string",
+					"description": "Label to remove",
+				},
+			},
+			"required": []string{"id", "label"},
+		},
+	}, es.handleRemoveLabel)
+
+	r.Register(Tool{
+		Name:        "bulk_action",
+		Description: "Delete, archive, mark as read or move many emails at once, selected by ID or by a local search query",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to use (default: INBOX)",
+				},
+				"ids": map[string]interface{}{
+					"type":        "array",
+					"description": "Email IDs to act on",
+					"items":       map[string]interface{}{"type": "integer", "minimum": 1, "maximum": maxUID},
+				},
+				"query": map[string]interface{}{
+					"type":        "string",
+					"description": "Select the synced emails of the folder matching this local_search query instead of ids",
+				},
+				"limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Maximum number of emails selected by query (default: 100)",
+				},
+				"action": map[string]interface{}{
+					"type":        "string",
+					"description": "What to do with the emails",
+					"enum":        []string{"delete", "archive", "mark_read", "move_to"},
+				},
+				"destination": map[string]interface{}{
+					"type":        "string",
+					"description": "Destination folder for move_to",
+				},
+				"dry_run": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Only report which emails would be affected (default: false)",
+				},
+			},
+			"required": []string{"action"},
+		},
+	}, es.confirmed("bulk_action", es.prepareBulkAction, es.handleBulkAction))
+
+	r.Register(Tool{
+		Name:        "confirm_action",
+		Description: "Run a delete_email, bulk_action or external send_email that returned a confirmation token (when CONFIRM_DESTRUCTIVE is on). Only confirm after the user approved the described action",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"token": map[string]interface{}{
+					"type":        "string",
+					"description": "Confirmation token returned by the destructive tool",
+				},
+				"cancel": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Discard the pending action instead of running it (default: false)",
+				},
+			},
+			"required": []string{"token"},
+		},
+	}, es.handleConfirmAction)
+
+	r.Register(Tool{
+		Name:        "get_applied_actions",
+		Description: "List what the action_rules of priority_rules.json did during sync, most recent first, including dry runs",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID (optional, lists all accounts if not specified)",
+				},
+				"rule": map[string]interface{}{
+					"type":        "string",
+					"description": "Only the actions of this rule (optional)",
+				},
+				"limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Maximum number of actions (default: 50)",
+				},
+			},
+		},
+	}, es.handleGetAppliedActions)
+
+	r.Register(Tool{
+		Name:        "ignored_mail",
+		Description: "The ignored mail auto_archive_ignored marked read and archived, by account and sender, as text followed by JSON, so nothing on the ignore lists is lost silently",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID (optional, lists all accounts if not specified)",
+				},
+				"days": map[string]interface{}{
+					"type":        "number",
+					"description": "Days to look back (default: 7)",
+					"minimum":     1,
+				},
+			},
+		},
+	}, es.handleIgnoredMail)
+
+	r.Register(Tool{
+		Name:        "get_audit_log",
+		Description: "List the recorded sends, deletes, moves, flag changes, bulk actions and account changes, most recent first: who made them, with which arguments and how they ended",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID (optional, lists all accounts if not specified)",
+				},
+				"operation": map[string]interface{}{
+					"type":        "string",
+					"description": "Only this operation, e.g. delete_email or bulk_action (optional)",
+				},
+				"actor": map[string]interface{}{
+					"type":        "string",
+					"description": "Only entries whose actor contains this text, e.g. client, action_rule, autoresponder or scheduler (optional)",
+				},
+				"since": map[string]interface{}{
+					"type":        "string",
+					"description": "Only entries on or after this date, YYYY-MM-DD (optional)",
+				},
+				"errors_only": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Only operations that failed (default: false)",
+				},
+				"limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Maximum number of entries (default: 50)",
+				},
+			},
+		},
+	}, es.handleGetAuditLog)
+
+	r.Register(Tool{
+		Name:        "list_accounts",
+		Description: "List the configured email accounts",
+		InputSchema: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{},
+		},
+	}, es.handleListAccounts)
+
+	r.Register(Tool{
+		Name:        "add_account",
+		Description: "Add an email account, test its IMAP and SMTP login and save it to email_config.json",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"id": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID, e.g. work",
+				},
+				"username": map[string]interface{}{
+					"type":        "string",
+					"description": "Email address used to log in",
+				},
+				"password": map[string]interface{}{
+					"type":        "string",
+					"description": "Password or app password (not needed with password_source env)",
+				},
+				"password_source": map[string]interface{}{
+					"type":        "string",
+					"enum":        []string{"plain", "keyring", "env", "file"},
+					"description": "Where the password is kept: plain (in email_config.json), keyring (OS keychain), env (an environment variable) or file (encrypted with CREDENTIALS_PASSPHRASE) (default: plain)",
+				},
+				"password_env": map[string]interface{}{
+					"type":        "string",
+					"description": "Environment variable holding the password when password_source is env",
+				},
+				"imap_host": map[string]interface{}{
+					"type":        "string",
+					"description": "IMAP server (optional: found from the address through provider presets, autoconfig, SRV or MX records)",
+				},
+				"imap_port": map[string]interface{}{
+					"type":        "number",
+					"description": "IMAP port (default: the one found with the server, else 993)",
+				},
+				"smtp_host": map[string]interface{}{
+					"type":        "string",
+					"description": "SMTP server (optional: found from the address like imap_host)",
+				},
+				"smtp_port": map[string]interface{}{
+					"type":        "number",
+					"description": "SMTP port (default: the one found with the server, else 587)",
+				},
+				"use_starttls": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Upgrade plain IMAP connections with STARTTLS (default: true)",
+				},
+				"imap_auth": map[string]interface{}{
+					"type":        "string",
+					"enum":        []string{"auto", "LOGIN", "PLAIN", "CRAM-MD5", "NTLM"},
+					"description": "IMAP authentication: auto tries the mechanisms the server offers until one works; LOGIN is the LOGIN command (default: auto)",
+				},
+				"smtp_auth": map[string]interface{}{
+					"type":        "string",
+					"enum":        []string{"auto", "PLAIN", "LOGIN", "CRAM-MD5", "NTLM"},
+					"description": "SMTP authentication: auto tries the mechanisms the server offers until one works (default: auto)",
+				},
+				"smtp_hello_name": map[string]interface{}{
+					"type":        "string",
+					"description": "Host name sent with EHLO, for relays that check it (default: localhost)",
+				},
+				"smtp_notify": map[string]interface{}{
+					"type":        "string",
+					"description": "Delivery status notifications requested for sent emails by default: comma-separated success, failure, delay or never (optional)",
+				},
+				"redact": map[string]interface{}{
+					"type":        "string",
+					"description": "Personal data removed from email bodies before they are returned or stored: comma-separated card, iban, ssn, otp or all (optional)",
+				},
+				"tls_ca_file": map[string]interface{}{
+					"type":        "string",
+					"description": "PEM file of CA certificates to trust besides the system ones, e.g. an internal CA (optional)",
+				},
+				"tls_cert_file": map[string]interface{}{
+					"type":        "string",
+					"description": "PEM client certificate presented to the servers, with tls_key_file (optional)",
+				},
+				"tls_key_file": map[string]interface{}{
+					"type":        "string",
+					"description": "PEM private key of tls_cert_file (optional)",
+				},
+				"tls_min_version": map[string]interface{}{
+					"type":        "string",
+					"enum":        []string{"1.0", "1.1", "1.2", "1.3"},
+					"description": "Minimum TLS version (default: 1.2)",
+				},
+				"tls_insecure_skip_verify": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Accept any server certificate. Insecure: only for lab environments (default: false)",
+				},
+				"display_name": map[string]interface{}{
+					"type":        "string",
+					"description": "Name shown in the From header (optional)",
+				},
+				"signature": map[string]interface{}{
+					"type":        "string",
+					"description": "Signature appended to composed emails (optional)",
+				},
+				"archive_folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Archive folder (optional)",
+				},
+				"trash_folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Move deleted emails here instead of removing them (optional)",
+				},
+				"sent_folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Store a copy of sent emails here (optional)",
+				},
+				"include_in_daily_summary": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Include the account in daily_summary (default: true)",
+				},
+				"test": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Test the login before saving (default: true)",
+				},
+			},
+			"required": []string{"id", "username"},
+		},
+	}, es.handleAddAccount)
+
+	r.Register(Tool{
+		Name:        "remove_account",
+		Description: "Remove an email account from email_config.json",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"id": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to remove",
+				},
+			},
+			"required": []string{"id"},
+		},
+	}, es.handleRemoveAccount)
+
+	r.Register(Tool{
+		Name:        "test_account",
+		Description: "Test the IMAP and SMTP login of an account, even while account_health shows it cooling down, and clear its failures when the login works",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to test (optional, uses default if not specified)",
+				},
+			},
+		},
+	}, es.handleTestAccount)
+
+	r.Register(Tool{
+		Name:        "account_health",
+		Description: "Login state of each account's IMAP, SMTP or Microsoft Graph server: failures in a row classified as auth, network, tls or quota, the last error, the cool-down before the next attempt and how to fix it, as text followed by JSON",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Only report this account (optional, default: all accounts)",
+				},
+			},
+		},
+	}, es.handleAccountHealth)
+
+	r.Register(Tool{
+		Name:        "get_capabilities",
+		Description: "Report the IMAP extensions each account's server supports (MOVE, UIDPLUS, IDLE, CONDSTORE, QRESYNC, ESEARCH, SPECIAL-USE) and how moves, deletions, sync and searches are carried out with them",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID (optional, every account if not specified)",
+				},
+				"refresh": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Probe the server again instead of using the cached capabilities (default: false)",
+				},
+			},
+		},
+	}, es.handleGetCapabilities)
+
+	r.Register(Tool{
+		Name:        "migrate_credentials",
+		Description: "Move plain-text passwords out of email_config.json into the OS keyring or an encrypted file",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to migrate (optional, migrates every plain-text account if not specified)",
+				},
+				"to": map[string]interface{}{
+					"type":        "string",
+					"enum":        []string{"keyring", "file"},
+					"description": "Destination: keyring (OS keychain) or file (encrypted with CREDENTIALS_PASSPHRASE) (default: keyring)",
+				},
+			},
+		},
+	}, es.handleMigrateCredentials)
+
+	r.Register(Tool{
+		Name:        "list_folders",
+		Description: "List the folders (mailboxes) of an account with message counts",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+			},
+		},
+	}, es.handleListFolders)
+
+	r.Register(Tool{
+		Name:        "create_folder",
+		Description: "Create a new folder",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"name": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder name (use the server's hierarchy delimiter for subfolders)",
+				},
+			},
+			"required": []string{"name"},
+		},
+	}, es.handleCreateFolder)
+
+	r.Register(Tool{
+		Name:        "rename_folder",
+		Description: "Rename a folder",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"name": map[string]interface{}{
+					"type":        "string",
+					"description": "Current folder name",
+				},
+				"new_name": map[string]interface{}{
+					"type":        "string",
+					"description": "New folder name",
+				},
+			},
+			"required": []string{"name", "new_name"},
+		},
+	}, es.handleRenameFolder)
+
+	r.Register(Tool{
+		Name:        "delete_folder",
+		Description: "Delete a folder and all emails in it",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"name": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder name to delete",
+				},
+			},
+			"required": []string{"name"},
+		},
+	}, es.confirmed("delete_folder", es.prepareDeleteFolder, es.handleDeleteFolder))
+
+	r.Register(Tool{
+		Name:        "sync_now",
+		Description: "Sync new inbox and sent emails into the local database",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to sync (optional, syncs all accounts if not specified)",
+				},
+			},
+		},
+	}, es.handleSyncNow)
+
+	r.Register(Tool{
+		Name:        "sync_status",
+		Description: "Show the local sync status of every account",
+		InputSchema: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{},
+		},
+	}, es.handleSyncStatus)
+
+	r.Register(Tool{
+		Name:        "backup_data",
+		Description: "Save the local database (synced emails, classifications, learned rules, contacts, drafts, audit log) and the configuration files to a timestamped .tar.gz archive",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"directory": map[string]interface{}{
+					"type":        "string",
+					"description": "Directory the archive is written to (default: BACKUP_DIR or backups)",
+				},
+			},
+		},
+	}, es.handleBackupData)
+
+	r.Register(Tool{
+		Name:        "restore_data",
+		Description: "Replace the local database with the one in a backup_data archive, and optionally restore its configuration files",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"archive": map[string]interface{}{
+					"type":        "string",
+					"description": "Path of the .tar.gz archive written by backup_data",
+				},
+				"config": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Also restore email_config.json and the other configuration files, keeping the current ones as .bak; they are loaded on the next start (default: false)",
+				},
+			},
+			"required": []string{"archive"},
+		},
+	}, es.handleRestoreData)
+
+	r.Register(Tool{
+		Name:        "local_search",
+		Description: "Full-text search over emails synced to the local database, best matches first",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"query": map[string]interface{}{
+					"type":        "string",
+					"description": "Search terms; supports \"exact phrases\", prefix* matching and AND/OR/NOT",
+				},
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to search (optional, searches all accounts if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Only search this folder (optional)",
+				},
+				"from": map[string]interface{}{
+					"type":        "string",
+					"description": "Only emails whose sender contains this text (optional)",
+				},
+				"since": map[string]interface{}{
+					"type":        "string",
+					"description": "Only emails on or after this date, YYYY-MM-DD (optional)",
+				},
+				"until": map[string]interface{}{
+					"type":        "string",
+					"description": "Only emails before this date, YYYY-MM-DD (optional)",
+				},
+				"starred": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Only starred emails (optional)",
+				},
+				"limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Maximum number of results (default: 20)",
+					"minimum":     1,
+					"maximum":     100,
+				},
+				"cursor": map[string]interface{}{
+					"type":        "string",
+					"description": "next_cursor returned by the previous call, to get the next page (optional)",
+				},
+			},
+			"required": []string{"query"},
+		},
+	}, es.handleLocalSearch)
+
+	r.Register(Tool{
+		Name:        "export_emails",
+		Description: "Export synced emails matching a filter to a file: CSV or JSON Lines of the metadata with categories and priority scores, for spreadsheets and pandas, or an mbox of the full messages fetched from the server, for archiving",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"format": map[string]interface{}{
+					"type":        "string",
+					"enum":        []string{"csv", "jsonl", "mbox"},
+					"description": "csv or jsonl for metadata, mbox for full messages",
+				},
+				"path": map[string]interface{}{
+					"type":        "string",
+					"description": "File or directory inside the downloads directory to write to, relative to it. Existing files are never overwritten (optional, default emails-<time>.<format> in the downloads directory)",
+				},
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID (optional, exports all accounts if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Only emails of this folder (optional)",
+				},
+				"query": map[string]interface{}{
+					"type":        "string",
+					"description": "Full-text query, as for local_search (optional)",
+				},
+				"from": map[string]interface{}{
+					"type":        "string",
+					"description": "Only emails whose sender contains this text (optional)",
+				},
+				"since": map[string]interface{}{
+					"type":        "string",
+					"description": "Only emails on or after this date, YYYY-MM-DD (optional)",
+				},
+				"until": map[string]interface{}{
+					"type":        "string",
+					"description": "Only emails before this date, YYYY-MM-DD (optional)",
+				},
+				"category": map[string]interface{}{
+					"type":        "string",
+					"description": "Only emails classified in this category (optional)",
+				},
+				"min_priority": map[string]interface{}{
+					"type":        "number",
+					"description": "Only emails with a stored priority score of at least this (optional)",
+				},
+				"unread_only": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Only unread emails (default: false)",
+				},
+				"limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Maximum number of emails, oldest first (optional, exports every match if not specified)",
+				},
+			},
+			"required": []string{"format"},
+		},
+	}, es.handleExportEmails)
+
+	r.Register(Tool{
+		Name:        "find_duplicates",
+		Description: "List synced emails that are copies of an earlier one, found by Message-ID or by sender, subject, date and content, such as the same message reaching two accounts through a forwarding rule",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Only list the copies in this account (optional, lists all accounts if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Only list synced emails of this folder, such as INBOX or a folder in SyncFolders (optional, all synced folders if not specified)",
+				},
+				"limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Maximum number of duplicates to return (default: 50)",
+					"minimum":     1,
+				},
+			},
+		},
+	}, es.handleFindDuplicates)
+
+	r.Register(Tool{
+		Name:        "get_thread",
+		Description: "Get a conversation from the local database in chronological order, grouped by References/In-Reply-To",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder of the email given by id (default: INBOX)",
+				},
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "ID of any email in the thread",
+					"minimum":     1,
+					"maximum":     maxUID,
+				},
+				"thread_id": map[string]interface{}{
+					"type":        "string",
+					"description": "Thread ID as returned in local_search or get_thread results (alternative to id)",
+				},
+			},
+		},
+	}, es.handleGetThread)
+
+	r.Register(Tool{
+		Name:        "daily_summary",
+		Description: "Get a markdown digest of all configured accounts: new high-priority emails, emails needing a reply, detected deadlines and counts by category",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Number of emails to analyze per account (default: 50)",
+					"minimum":     1,
+					"maximum":     200,
+				},
+				"hours": map[string]interface{}{
+					"type":        "number",
+					"description": "How recent an email must be to count as new (default: 24)",
+					"minimum":     1,
+				},
+				"send": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Also email the digest to DIGEST_TO (default: the sending account's own address)",
+				},
+			},
+		},
+	}, es.handleDailySummary)
+
+	r.Register(Tool{
+		Name:        "inbox_stats",
+		Description: "Mailbox statistics from the local database: email volume per day and week, counts by category, top senders over time, average response time, unread growth and storage per folder, as text followed by JSON for charting",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"days": map[string]interface{}{
+					"type":        "number",
+					"description": "Number of days to cover, today included (default: 30)",
+					"minimum":     1,
+				},
+			},
+		},
+	}, es.handleInboxStats)
+
+	r.Register(Tool{
+		Name:        "my_response_stats",
+		Description: "Your outbound mail from the synced INBOX and Sent folders: average reply time per contact, sent emails promising to get back to someone (\"I'll get back to you\") with no later message of yours in the thread, and the senders waiting longest for a reply, as text followed by JSON",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"days": map[string]interface{}{
+					"type":        "number",
+					"description": "Number of days to cover (default: 30)",
+					"minimum":     1,
+				},
+				"limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Maximum number of contacts and waiting senders to list (default: 10)",
+					"minimum":     1,
+				},
+			},
+		},
+	}, es.handleMyResponseStats)
+
+	r.Register(Tool{
+		Name:        "recalc_priorities",
+		Description: "Recalculate and store the priority score and deadlines of the newest synced emails of an account, from their stored classification, VIP senders, flags, deadlines and sender history. Run it after changing VIPs or rules, or to find the deadlines of emails synced before they were recorded",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Number of the newest synced emails to score (default: 500)",
+					"minimum":     1,
+				},
+			},
+		},
+	}, es.handleRecalcPriorities)
+
+	r.Register(Tool{
+		Name:        "priority_stats",
+		Description: "Distribution of the stored priority scores of an account: emails per level (critical, high, medium, low, minimal), the factors that raised or lowered scores most often and how many synced emails were never scored, as text followed by JSON",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+			},
+		},
+	}, es.handlePriorityStats)
+
+	r.Register(Tool{
+		Name:        "priority_inbox",
+		Description: "The synced emails of an account with a stored priority at or above a level, highest score first, as text followed by JSON. Emails are scored during sync when notifications are on, or by recalc_priorities",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Only list synced emails of this folder, such as INBOX or a folder in SyncFolders (optional, all synced folders if not specified)",
+				},
+				"level": map[string]interface{}{
+					"type":        "string",
+					"description": "Lowest priority level to list (default: high)",
+					"enum":        []string{"critical", "high", "medium", "low", "minimal"},
+				},
+				"starred": map[string]interface{}{
+					"type":        "string",
+					"description": "only: list only starred emails; first: list starred emails of any level above the others (optional)",
+					"enum":        []string{"only", "first"},
+				},
+				"limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Maximum number of emails (default: 20)",
+					"minimum":     1,
+				},
+			},
+		},
+	}, es.handlePriorityInbox)
+
+	r.Register(Tool{
+		Name:        "priority_threads",
+		Description: "The most important conversations of an account, ranked by a rollup of the stored priorities of their emails (highest and mean score), unread messages and last activity, as text followed by JSON; pass a thread_id to get_thread to read one",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Number of conversations to return (default: 10)",
+					"minimum":     1,
+				},
+				"days": map[string]interface{}{
+					"type":        "number",
+					"description": "Only consider conversations active in the last N days (default: 30)",
+					"minimum":     1,
+				},
+			},
+		},
+	}, es.handlePriorityThreads)
+
+	r.Register(Tool{
+		Name:        "upcoming_deadlines",
+		Description: "Deadlines found in synced emails (\"by Friday\", \"EOD tomorrow\", \"due March 14\"), soonest first, with the email mentioning each one and how much it raises the email's priority, as text followed by JSON",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Only list synced emails of this folder, such as INBOX or a folder in SyncFolders (optional, all synced folders if not specified)",
+				},
+				"days": map[string]interface{}{
+					"type":        "number",
+					"description": "Number of days ahead to look (default: 7)",
+					"minimum":     1,
+				},
+				"include_overdue": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Also list deadlines that passed in the last 'days' days (default: false)",
+				},
+				"limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Maximum number of deadlines to return (default: 50)",
+					"minimum":     1,
+				},
+			},
+		},
+	}, es.handleUpcomingDeadlines)
+
+	r.Register(Tool{
+		Name:        "list_invoices",
+		Description: "Invoices found in synced emails, newest first, with the vendor, invoice number, amount, currency and due date extracted from each one and the totals per currency, as text followed by JSON",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Only list synced emails of this folder, such as INBOX or a folder in SyncFolders (optional, all synced folders if not specified)",
+				},
+				"vendor": map[string]interface{}{
+					"type":        "string",
+					"description": "Only list invoices whose vendor contains this text (optional)",
+				},
+				"days": map[string]interface{}{
+					"type":        "number",
+					"description": "Number of days back to look (default: 90)",
+					"minimum":     1,
+				},
+				"limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Maximum number of invoices to return (default: 50)",
+					"minimum":     1,
+				},
+			},
+		},
+	}, es.handleListInvoices)
+
+	r.Register(Tool{
+		Name:        "upcoming_payments",
+		Description: "Invoices found in synced emails that fall due soon, soonest first, with the amount to pay and the totals per currency, as text followed by JSON",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"days": map[string]interface{}{
+					"type":        "number",
+					"description": "Number of days ahead to look (default: 30)",
+					"minimum":     1,
+				},
+				"include_overdue": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Also list payments that fell due in the last 'days' days (default: false)",
+				},
+				"limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Maximum number of payments to return (default: 50)",
+					"minimum":     1,
+				},
+			},
+		},
+	}, es.handleUpcomingPayments)
+
+	r.Register(Tool{
+		Name:        "my_orders",
+		Description: "Online orders found in synced order confirmation, shipping and delivery emails, newest first, one per order with its latest status, carrier, tracking link and total, as text followed by JSON",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Only list synced emails of this folder, such as INBOX or a folder in SyncFolders (optional, all synced folders if not specified)",
+				},
+				"status": map[string]interface{}{
+					"type":        "string",
+					"description": "Only list orders in this status (default: all)",
+					"enum":        []string{"all", "placed", "shipped", "delivered", "cancelled"},
+				},
+				"days": map[string]interface{}{
+					"type":        "number",
+					"description": "Number of days back to look (default: 60)",
+					"minimum":     1,
+				},
+				"limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Maximum number of orders to return (default: 50)",
+					"minimum":     1,
+				},
+			},
+		},
+	}, es.handleMyOrders)
+
+	r.Register(Tool{
+		Name:        "my_trips",
+		Description: "Flights found in synced itinerary, booking and boarding pass emails, soonest first, with the route, departure and booking reference, as text followed by JSON",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Only list synced emails of this folder, such as INBOX or a folder in SyncFolders (optional, all synced folders if not specified)",
+				},
+				"days": map[string]interface{}{
+					"type":        "number",
+					"description": "Number of days ahead to look (default: 90)",
+					"minimum":     1,
+				},
+				"include_past": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Also list flights that departed in the last 'days' days (default: false)",
+				},
+				"limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Maximum number of flights to return (default: 50)",
+					"minimum":     1,
+				},
+			},
+		},
+	}, es.handleMyTrips)
+
+	r.Register(Tool{
+		Name:        "list_bounces",
+		Description: "Recipients whose mail bounced, read from the delivery status notifications synced into the inbox, most recently bounced first, with whether the failure is permanent and the remote server's diagnostic, as text followed by JSON",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"days": map[string]interface{}{
+					"type":        "number",
+					"description": "Number of days back to look (default: 30)",
+					"minimum":     1,
+				},
+				"limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Maximum number of addresses to return (default: 50)",
+					"minimum":     1,
+				},
+			},
+		},
+	}, es.handleListBounces)
+
+	r.Register(Tool{
+		Name:        "sent_status",
+		Description: "Delivery status of the messages sent through this server: sent, delayed or failed, as reported by the bounces synced into the inbox, with the recipients that bounced, newest first, as text followed by JSON",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"message_id": map[string]interface{}{
+					"type":        "string",
+					"description": "Message-ID of one sent message, as returned by send_email (optional)",
+				},
+				"status": map[string]interface{}{
+					"type":        "string",
+					"description": "Only list messages with this delivery status (default: all)",
+					"enum":        []string{"all", "sent", "delayed", "failed"},
+				},
+				"days": map[string]interface{}{
+					"type":        "number",
+					"description": "Number of days back to look (default: 7)",
+					"minimum":     1,
+				},
+				"limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Maximum number of messages to return (default: 50)",
+					"minimum":     1,
+				},
+			},
+		},
+	}, es.handleSentStatus)
+
+	r.Register(Tool{
+		Name:        "search_contacts",
+		Description: "Search the address book built from synced mail by name or address, most frequent correspondents first; useful to autocomplete recipients",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"query": map[string]interface{}{
+					"type":        "string",
+					"description": "Part of a name or address (optional, lists all contacts if empty)",
+				},
+				"limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Maximum number of contacts (default: 10)",
+					"minimum":     1,
+				},
+				"cursor": map[string]interface{}{
+					"type":        "string",
+					"description": "next_cursor returned by the previous call, to get the next page (optional)",
+				},
+			},
+		},
+	}, es.handleSearchContacts)
+
+	r.Register(Tool{
+		Name:        "get_contact",
+		Description: "Get a contact with all their addresses, message counts, first and last seen dates, VIP status and recent emails",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Contact ID from search_contacts",
+					"minimum":     1,
+				},
+				"address": map[string]interface{}{
+					"type":        "string",
+					"description": "Any email address of the contact (alternative to id)",
+				},
+				"recent_limit": map[string]interface{}{
+					"type":        "number",
+					"description": "Number of recent emails from the contact to include (default: 10)",
+					"minimum":     0,
+				},
+			},
+		},
+	}, es.handleGetContact)
+
+	r.Register(Tool{
+		Name:        "test_notification",
+		Description: "Send a sample high-priority alert to every configured notification channel (webhooks, email, desktop)",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"level": map[string]interface{}{
+					"type":        "string",
+					"description": "Alert level to test, which decides the channels reached through their min_level (default: critical)",
+					"enum":        []string{"critical", "high"},
+				},
+			},
+		},
+	}, es.handleTestNotification)
+
+	r.Register(Tool{
+		Name:        "create_draft",
+		Description: "Save an email as a draft for review before sending",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"to": map[string]interface{}{
+					"type":        []string{"string", "array"},
+					"description": "Recipient email address, or an array of addresses",
+					"items":       map[string]interface{}{"type": "string"},
+				},
+				"cc": map[string]interface{}{
+					"type":        []string{"string", "array"},
+					"description": "Carbon-copy recipients (optional)",
+					"items":       map[string]interface{}{"type": "string"},
+				},
+				"bcc": map[string]interface{}{
+					"type":        []string{"string", "array"},
+					"description": "Blind carbon-copy recipients, not shown in the headers (optional)",
+					"items":       map[string]interface{}{"type": "string"},
+				},
+				"subject": map[string]interface{}{
+					"type":        "string",
+					"description": "Email subject",
+				},
+				"body": map[string]interface{}{
+					"type":        "string",
+					"description": "Email body content",
+				},
+				"save_to_server": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Also store a copy in the account's Drafts folder (default: false)",
+				},
+			},
+		},
+	}, es.handleCreateDraft)
+
+	r.Register(Tool{
+		Name:        "list_drafts",
+		Description: "List saved drafts, most recently edited first",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to list (optional, lists all accounts if not specified)",
+				},
+			},
+		},
+	}, es.handleListDrafts)
+
+	r.Register(Tool{
+		Name:        "update_draft",
+		Description: "Edit a saved draft; only the given fields are changed",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"draft_id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Draft ID returned by create_draft or list_drafts",
+					"minimum":     1,
+				},
+				"to": map[string]interface{}{
+					"type":        []string{"string", "array"},
+					"description": "Recipient email address, or an array of addresses",
+					"items":       map[string]interface{}{"type": "string"},
+				},
+				"cc": map[string]interface{}{
+					"type":        []string{"string", "array"},
+					"description": "Carbon-copy recipients (optional)",
+					"items":       map[string]interface{}{"type": "string"},
+				},
+				"bcc": map[string]interface{}{
+					"type":        []string{"string", "array"},
+					"description": "Blind carbon-copy recipients, not shown in the headers (optional)",
+					"items":       map[string]interface{}{"type": "string"},
+				},
+				"subject": map[string]interface{}{
+					"type":        "string",
+					"description": "Email subject",
+				},
+				"body": map[string]interface{}{
+					"type":        "string",
+					"description": "Email body content",
+				},
+			},
+			"required": []string{"draft_id"},
+		},
+	}, es.handleUpdateDraft)
+
+	r.Register(Tool{
+		Name:        "send_draft",
+		Description: "Send a saved draft and delete it",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"draft_id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Draft ID returned by create_draft or list_drafts",
+					"minimum":     1,
+				},
+			},
+			"required": []string{"draft_id"},
+		},
+	}, es.confirmed("send_draft", es.prepareSendDraft, es.handleSendDraft))
+
+	r.Register(Tool{
+		Name:        "enable_autoresponder",
+		Description: "Automatically reply to new inbox mail, e.g. while on vacation. Each sender gets at most one reply per interval; mailing lists, no-reply senders and other automatic messages are never answered",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID (optional, uses default if not specified)",
+				},
+				"subject": map[string]interface{}{
+					"type":        "string",
+					"description": "Reply subject (default: \"Re: {{subject}}\")",
+				},
+				"body": map[string]interface{}{
+					"type":        "string",
+					"description": "Reply body. May use {{name}}, {{sender}}, {{subject}} (of the received email) and {{until}}",
+				},
+				"template": map[string]interface{}{
+					"type":        "string",
+					"description": "Name of a saved template to use instead of subject and body, with the same placeholders",
+				},
+				"interval_days": map[string]interface{}{
+					"type":        "number",
+					"description": "Reply at most once per sender in this many days (default: 7)",
+				},
+				"start": map[string]interface{}{
+					"type":        "string",
+					"description": "Only answer mail dated from this time, RFC 3339 or YYYY-MM-DD HH:MM (default: now)",
+				},
+				"until": map[string]interface{}{
+					"type":        "string",
+					"description": "Stop answering after this time: a duration such as 14d or a time as in start (optional)",
+				},
+				"match_from": map[string]interface{}{
+					"type":        "string",
+					"description": "Only answer senders whose address contains this, e.g. @client.com (optional)",
+				},
+				"match_subject": map[string]interface{}{
+					"type":        "string",
+					"description": "Only answer emails whose subject contains this (optional)",
+				},
+			},
+		},
+	}, es.handleEnableAutoresponder)
+
+	r.Register(Tool{
+		Name:        "disable_autoresponder",
+		Description: "Stop automatic replies for an account",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID (optional, uses default if not specified)",
+				},
+			},
+		},
+	}, es.handleDisableAutoresponder)
+
+	r.Register(Tool{
+		Name:        "save_template",
+		Description: "Save a named email template, replacing any template with the same name. Subject and body may contain {{placeholders}}",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"name": map[string]interface{}{
+					"type":        "string",
+					"description": "Template name, e.g. invoice-ack",
+				},
+				"subject": map[string]interface{}{
+					"type":        "string",
+					"description": "Subject, e.g. \"Re: Invoice {{invoice}}\"",
+				},
+				"body": map[string]interface{}{
+					"type":        "string",
+					"description": "Body, e.g. \"Hi {{name}}, we received invoice {{invoice}}.\"",
+				},
+				"description": map[string]interface{}{
+					"type":        "string",
+					"description": "What the template is for (optional)",
+				},
+			},
+			"required": []string{"name", "subject", "body"},
+		},
+	}, es.handleSaveTemplate)
+
+	r.Register(Tool{
+		Name:        "list_templates",
+		Description: "List the saved email templates with their placeholders",
+		InputSchema: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{},
+		},
+	}, es.handleListTemplates)
+
+	r.Register(Tool{
+		Name:        "render_template",
+		Description: "Show a template with its placeholders filled in, without sending it",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"name": map[string]interface{}{
+					"type":        "string",
+					"description": "Template name",
+				},
+				"variables": map[string]interface{}{
+					"type":        "object",
+					"description": "Placeholder values, e.g. {\"name\": \"Ana\", \"invoice\": \"F-1024\"}",
+				},
+			},
+			"required": []string{"name"},
+		},
+	}, es.handleRenderTemplate)
+
+	r.Register(Tool{
+		Name:        "send_from_template",
+		Description: "Fill in a template and send it. Fails when a placeholder has no value",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use for sending (optional, uses default if not specified)",
+				},
+				"name": map[string]interface{}{
+					"type":        "string",
+					"description": "Template name",
+				},
+				"variables": map[string]interface{}{
+					"type":        "object",
+					"description": "Placeholder values, e.g. {\"name\": \"Ana\", \"invoice\": \"F-1024\"}",
+				},
+				"to": map[string]interface{}{
+					"type":        []string{"string", "array"},
+					"description": "Recipient email address, or an array of addresses. A contact name (see search_contacts) is replaced by its address",
+					"items":       map[string]interface{}{"type": "string"},
+				},
+				"cc": map[string]interface{}{
+					"type":        []string{"string", "array"},
+					"description": "Carbon-copy recipients (optional)",
+					"items":       map[string]interface{}{"type": "string"},
+				},
+				"bcc": map[string]interface{}{
+					"type":        []string{"string", "array"},
+					"description": "Blind carbon-copy recipients, not shown in the headers (optional)",
+					"items":       map[string]interface{}{"type": "string"},
+				},
+			},
+			"required": []string{"name", "to"},
+		},
+	}, es.confirmed("send_from_template", es.prepareSendFromTemplate, es.handleSendFromTemplate))
+
+	r.Register(Tool{
+		Name:        "delete_template",
+		Description: "Delete a saved email template",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"name": map[string]interface{}{
+					"type":        "string",
+					"description": "Template name",
+				},
+			},
+			"required": []string{"name"},
+		},
+	}, es.handleDeleteTemplate)
+
+	r.Register(Tool{
+		Name:        "schedule_email",
+		Description: "Queue an email to be sent at a later time",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use for sending (optional, uses default if not specified)",
+				},
+				"to": map[string]interface{}{
+					"type":        []string{"string", "array"},
+					"description": "Recipient email address, or an array of addresses",
+					"items":       map[string]interface{}{"type": "string"},
+				},
+				"cc": map[string]interface{}{
+					"type":        []string{"string", "array"},
+					"description": "Carbon-copy recipients (optional)",
+					"items":       map[string]interface{}{"type": "string"},
+				},
+				"bcc": map[string]interface{}{
+					"type":        []string{"string", "array"},
+					"description": "Blind carbon-copy recipients, not shown in the headers (optional)",
+					"items":       map[string]interface{}{"type": "string"},
+				},
+				"subject": map[string]interface{}{
+					"type":        "string",
+					"description": "Email subject",
+				},
+				"body": map[string]interface{}{
+					"type":        "string",
+					"description": "Email body content",
+				},
+				"send_at": map[string]interface{}{
+					"type":        "string",
+					"description": "When to send, as RFC 3339 (2025-03-14T09:00:00+01:00) or YYYY-MM-DD HH:MM in server local time",
+				},
+			},
+			"required": []string{"to", "subject", "body", "send_at"},
+		},
+	}, es.confirmed("schedule_email", es.prepareScheduleEmail, es.handleScheduleEmail))
+
+	r.Register(Tool{
+		Name:        "list_scheduled",
+		Description: "List emails queued for later sending, soonest first",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to list (optional, lists all accounts if not specified)",
+				},
+				"include_done": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Also list sent, failed and cancelled emails (default: false)",
+				},
+			},
+		},
+	}, es.handleListScheduled)
+
+	r.Register(Tool{
+		Name:        "cancel_scheduled",
+		Description: "Cancel a scheduled email that has not been sent yet",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Scheduled email ID returned by schedule_email or list_scheduled",
+					"minimum":     1,
+				},
+			},
+			"required": []string{"id"},
+		},
+	}, es.handleCancelScheduled)
+
+	r.Register(Tool{
+		Name:        "snooze_email",
+		Description: "Move an email out of the way until a given time; it then comes back to its folder flagged and unread",
+		Mutating:    true,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder to use (default: INBOX)",
+				},
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Email ID to snooze",
+					"minimum":     1,
+					"maximum":     maxUID,
+				},
+				"until": map[string]interface{}{
+					"type":        "string",
+					"description": "When to bring the email back: a duration such as 3h or 2d, RFC 3339 (2025-01-15T09:00:00+01:00) or YYYY-MM-DD HH:MM in server local time",
+				},
+			},
+			"required": []string{"id", "until"},
+		},
+	}, es.handleSnoozeEmail)
+
+	r.Register(Tool{
+		Name:        "list_snoozed",
+		Description: "List snoozed emails, soonest to wake first",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to list (optional, lists all accounts if not specified)",
+				},
+				"include_done": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Also list woken and failed snoozes (default: false)",
+				},
+			},
+		},
+	}, es.handleListSnoozed)
+
+	r.Register(Tool{
+		Name:        "track_followup",
+		Description: "Wait for a reply to a sent email; it is resolved when a synced email references it, and listed by pending_followups as overdue after the deadline",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to use (optional, uses default if not specified)",
+				},
+				"message_id": map[string]interface{}{
+					"type":        "string",
+					"description": "Message-ID of the sent email, as returned by send_email",
+				},
+				"folder": map[string]interface{}{
+					"type":        "string",
+					"description": "Folder of the email given by id, e.g. Sent (default: INBOX)",
+				},
+				"id": map[string]interface{}{
+					"type":        "integer",
+					"description": "Email ID, instead of message_id",
+					"minimum":     1,
+					"maximum":     maxUID,
+				},
+				"subject": map[string]interface{}{
+					"type":        "string",
+					"description": "Subject to show in pending_followups, with message_id (optional)",
+				},
+				"reply_by": map[string]interface{}{
+					"type":        "string",
+					"description": "When a reply is expected: a duration such as 3d, RFC 3339 or YYYY-MM-DD HH:MM in server local time",
+				},
+			},
+			"required": []string{"reply_by"},
+		},
+	}, es.handleTrackFollowup)
+
+	r.Register(Tool{
+		Name:        "pending_followups",
+		Description: "List sent emails still waiting for a reply, by deadline, marking overdue ones",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"account": map[string]interface{}{
+					"type":        "string",
+					"description": "Account ID to list (optional, lists all accounts if not specified)",
+				},
+				"overdue_only": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Only list follow-ups past their deadline (default: false)",
+				},
+				"include_resolved": map[string]interface{}{
+					"type":        "boolean",
+					"description": "Also list follow-ups that have been answered (default: false)",
+				},
+			},
+		},
+	}, es.handlePendingFollowups)
+
+	return r
+}
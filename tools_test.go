@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// newEchoRegistry returns a registry whose tools answer with their name
+func newEchoRegistry(names ...string) *ToolRegistry {
+	r := NewToolRegistry()
+	for _, name := range names {
+		name := name
+		r.Register(Tool{Name: name, InputSchema: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"uid": map[string]interface{}{"type": "integer"}},
+		}, Mutating: name == "delete_email"}, func(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+			return name, nil
+		})
+	}
+	return r
+}
+
+func toolNames(tools []Tool) []string {
+	var names []string
+	for _, tool := range tools {
+		names = append(names, tool.Name)
+	}
+	return names
+}
+
+func TestToolRegistry(t *testing.T) {
+	r := newEchoRegistry("get_emails", "get_email_body", "delete_email")
+	ctx := context.Background()
+
+	if names := toolNames(r.List()); !slices.Equal(names, []string{"get_emails", "get_email_body", "delete_email"}) {
+		t.Errorf("List = %v, want registration order", names)
+	}
+	if !r.Mutating("delete_email") || r.Mutating("get_emails") {
+		t.Error("Mutating does not follow the registered tools")
+	}
+
+	result, err := r.Call(ctx, ToolCallParams{Name: "get_email_body", Arguments: map[string]interface{}{"uid": float64(6)}})
+	if err != nil || result != "get_email_body" {
+		t.Errorf("Call = %v, %v; want the get_email_body handler", result, err)
+	}
+
+	var invalid *InvalidParamsError
+	if _, err := r.Call(ctx, ToolCallParams{Name: "get_emails", Arguments: map[string]interface{}{"uid": "6"}}); !errors.As(err, &invalid) {
+		t.Errorf("Call with a wrong argument type = %v, want an *InvalidParamsError", err)
+	}
+
+	var notFound *ToolNotFoundError
+	if _, err := r.Call(ctx, ToolCallParams{Name: "send_fax"}); !errors.As(err, &notFound) || notFound.Disabled {
+		t.Errorf("Call of an unknown tool = %v, want a *ToolNotFoundError", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a tool twice did not panic")
+		}
+	}()
+	r.Register(Tool{Name: "get_emails"}, nil)
+}
+
+func TestToolRegistryRestrict(t *testing.T) {
+	tests := []struct {
+		name        string
+		allow, deny []string
+		want        []string
+		wantErr     bool
+	}{
+		{"everything", nil, nil, []string{"get_emails", "get_email_body", "delete_email", "send_email"}, false},
+		{"allow glob", []string{"get_*"}, nil, []string{"get_emails", "get_email_body"}, false},
+		{"deny", nil, []string{"delete_email", "send_*"}, []string{"get_emails", "get_email_body"}, false},
+		{"deny wins", []string{"get_*", "delete_email"}, []string{"get_email_body"}, []string{"get_emails", "delete_email"}, false},
+		{"pattern matching nothing", nil, []string{"delete_emails"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newEchoRegistry("get_emails", "get_email_body", "delete_email", "send_email")
+			err := r.Restrict(tt.allow, tt.deny)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("Restrict succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Restrict: %v", err)
+			}
+			if names := toolNames(r.List()); !slices.Equal(names, tt.want) {
+				t.Errorf("List = %v, want %v", names, tt.want)
+			}
+			for _, name := range []string{"get_emails", "get_email_body", "delete_email", "send_email"} {
+				kept := slices.Contains(tt.want, name)
+				if r.Disabled(name) == kept {
+					t.Errorf("Disabled(%s) = %v", name, !kept)
+				}
+				var notFound *ToolNotFoundError
+				_, err := r.Call(context.Background(), ToolCallParams{Name: name})
+				if got := errors.As(err, &notFound) && notFound.Disabled; got == kept {
+					t.Errorf("Call(%s) = %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRegisteredToolSchemas(t *testing.T) {
+	es := newTestServer(t)
+	for _, tool := range es.registerTools().List() {
+		schema, ok := tool.InputSchema.(map[string]interface{})
+		if !ok || schema["type"] != "object" {
+			t.Errorf("%s: input schema is not an object schema", tool.Name)
+			continue
+		}
+		if tool.Description == "" {
+			t.Errorf("%s: no description", tool.Name)
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for _, name := range schemaStrings(schema["required"]) {
+			if _, ok := properties[name]; !ok {
+				t.Errorf("%s: required property %s is not declared", tool.Name, name)
+			}
+		}
+	}
+}
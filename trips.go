@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"email-mcp-server/ai"
+	"email-mcp-server/storage"
+)
+
+// recordTrips stores the flights a synced itinerary or booking email lists
+func (es *EmailServer) recordTrips(email *storage.Email) error {
+	var trips []storage.Trip
+	for _, trip := range ai.ExtractTrips(es.fullEmail(context.Background(), *email)) {
+		trips = append(trips, storage.Trip{
+			AccountID:        email.AccountID,
+			Folder:           email.Folder,
+			UID:              email.UID,
+			Airline:          trip.Airline,
+			FlightNumber:     trip.FlightNumber,
+			BookingReference: trip.BookingReference,
+			Origin:           trip.Origin,
+			Destination:      trip.Destination,
+			Departure:        trip.Departure,
+		})
+	}
+	if err := es.db.SaveTrips(email.AccountID, email.Folder, email.UID, trips); err != nil {
+		return fmt.Errorf("failed to store trips of %s/%d: %w", email.Folder, email.UID, err)
+	}
+	return nil
+}
+
+func (es *EmailServer) handleMyTrips(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("trips are not available: %w", errNoDatabase)
+	}
+
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	days := 90
+	if d, ok := args["days"].(float64); ok && d >= 1 {
+		days = int(d)
+	}
+	limit := 50
+	if l, ok := args["limit"].(float64); ok && l >= 1 {
+		limit = int(l)
+	}
+	includePast, _ := args["include_past"].(bool)
+
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	from := now
+	if includePast {
+		from = now.AddDate(0, 0, -days)
+	}
+	trips, err := es.db.UpcomingTrips(config.ID, folder, from, now.AddDate(0, 0, days), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	tripsJSON, _ := json.MarshalIndent(trips, "", "  ")
+	return ToolResult{
+		Content: []TextContent{
+			{Type: "text", Text: formatTrips(config.ID, trips, days, now)},
+			{Type: "text", Text: string(tripsJSON)},
+		},
+	}, nil
+}
+
+func formatTrips(accountID string, trips []storage.Trip, days int, now time.Time) string {
+	if len(trips) == 0 {
+		return fmt.Sprintf("No flights in the next %d days for %s", days, accountID)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d flights in the next %d days for %s:\n", len(trips), days, accountID)
+	for _, t := range trips {
+		departure := t.Departure.Local()
+		when := formatDigestDay(departure, now)
+		if departure.Hour() != 0 || departure.Minute() != 0 {
+			when += " " + departure.Format("15:04")
+		}
+		if departure.Before(now) {
+			when += " (departed)"
+		}
+		fmt.Fprintf(&b, "- %s · %s", when, t.FlightNumber)
+		if t.Airline != "" {
+			fmt.Fprintf(&b, " (%s)", t.Airline)
+		}
+		if t.Origin != "" {
+			fmt.Fprintf(&b, " · %s → %s", t.Origin, t.Destination)
+		}
+		if t.BookingReference != "" {
+			fmt.Fprintf(&b, " · booking %s", t.BookingReference)
+		}
+		fmt.Fprintf(&b, " [%s/%d]\n", t.Folder, t.UID)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
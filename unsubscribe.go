@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"email-mcp-server/mail"
+)
+
+// unsubscribeClient performs RFC 8058 one-click requests. No cookies are sent
+// and the request carries no credentials, as the RFC requires.
+var unsubscribeClient = &http.Client{Timeout: 30 * time.Second}
+
+func (es *EmailServer) handleUnsubscribe(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	folder, _ := args["folder"].(string)
+	id, ok := args["id"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("%w: id", errMissingParameter)
+	}
+	method, _ := args["method"].(string)
+
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	headers, err := es.getEmailHeaders(ctx, config.ID, folder, uint32(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get email: %w", err)
+	}
+
+	options := mail.ParseListUnsubscribe(headers.Header("List-Unsubscribe"), headers.Header("List-Unsubscribe-Post"))
+	if len(options) == 0 {
+		return ToolResult{
+			Content: []TextContent{{
+				Type: "text",
+				Text: fmt.Sprintf("Email %d has no usable List-Unsubscribe header", uint32(id)),
+			}},
+		}, nil
+	}
+
+	switch method {
+	case "":
+		optionsJSON, _ := json.MarshalIndent(options, "", "  ")
+		return ToolResult{
+			Content: []TextContent{{
+				Type: "text",
+				Text: fmt.Sprintf("Unsubscribe options for email %d (call again with method \"mailto\" or \"one_click\" to act; other links must be opened in a browser):\n\n%s",
+					uint32(id), string(optionsJSON)),
+			}},
+		}, nil
+
+	case "mailto":
+		for _, option := range options {
+			if option.Method != "mailto" {
+				continue
+			}
+			msg := &mail.OutgoingMessage{To: []string{option.Address}, Subject: option.Subject, Body: option.Body}
+			if msg.Subject == "" {
+				msg.Subject = "unsubscribe"
+			}
+			if msg.Body == "" {
+				msg.Body = "unsubscribe"
+			}
+			if err := es.sendEmail(ctx, config.ID, msg); err != nil {
+				return nil, fmt.Errorf("failed to send unsubscribe email: %w", err)
+			}
+			return ToolResult{
+				Content: []TextContent{{
+					Type: "text",
+					Text: fmt.Sprintf("Unsubscribe email sent to %s", option.Address),
+				}},
+			}, nil
+		}
+		return nil, fmt.Errorf("email %d has no mailto: unsubscribe option", uint32(id))
+
+	case "one_click":
+		for _, option := range options {
+			if !option.OneClick {
+				continue
+			}
+			if err := oneClickUnsubscribe(ctx, option.URL); err != nil {
+				return nil, err
+			}
+			return ToolResult{
+				Content: []TextContent{{
+					Type: "text",
+					Text: fmt.Sprintf("Unsubscribed with one-click request to %s", option.URL),
+				}},
+			}, nil
+		}
+		return nil, fmt.Errorf("email %d does not support one-click unsubscribe (RFC 8058)", uint32(id))
+	}
+
+	return nil, fmt.Errorf("unknown method: %s", method)
+}
+
+// oneClickUnsubscribe sends the RFC 8058 POST to target, giving up when ctx
+// ends
+func oneClickUnsubscribe(ctx context.Context, target string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, strings.NewReader("List-Unsubscribe=One-Click"))
+	if err != nil {
+		return fmt.Errorf("failed to unsubscribe: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := unsubscribeClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to unsubscribe: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to unsubscribe: server returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOneClickUnsubscribe(t *testing.T) {
+	var body, contentType string
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		if r.URL.Path == "/slow" {
+			<-release
+			return
+		}
+		body, contentType = string(data), r.Header.Get("Content-Type")
+	}))
+	defer server.Close()
+	defer close(release)
+
+	if err := oneClickUnsubscribe(context.Background(), server.URL+"/unsubscribe"); err != nil {
+		t.Fatalf("oneClickUnsubscribe: %v", err)
+	}
+	if body != "List-Unsubscribe=One-Click" || contentType != "application/x-www-form-urlencoded" {
+		t.Errorf("posted %q as %q", body, contentType)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := oneClickUnsubscribe(ctx, server.URL+"/slow"); err == nil {
+		t.Error("a request outliving its context succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancelled request returned after %v", elapsed)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"email-mcp-server/mail"
+)
+
+// verificationScan is how many of the newest inbox emails get_verification_code
+// looks through
+const verificationScan = 20
+
+// VerificationCode is a login or verification code found by
+// get_verification_code
+type VerificationCode struct {
+	Code    string    `json:"code"`
+	From    string    `json:"from"`
+	Subject string    `json:"subject"`
+	Date    time.Time `json:"date"`
+	Folder  string    `json:"folder"`
+	ID      uint32    `json:"id"`
+	Deleted bool      `json:"deleted"`
+}
+
+// findVerificationCode returns the code of the newest inbox email received
+// since a time that looks sent to deliver one, nil when there is none.
+// Bodies are only fetched for the emails whose subject has no code.
+func (es *EmailServer) findVerificationCode(ctx context.Context, accountID, sender string, since time.Time) (*VerificationCode, error) {
+	emails, err := es.getEmails(ctx, accountID, "INBOX", verificationScan, false)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(emails, func(a, b EmailMessage) int { return b.Date.Compare(a.Date) })
+
+	sender = strings.ToLower(sender)
+	for _, email := range emails {
+		if email.Date.Before(since) {
+			break
+		}
+		if sender != "" && !strings.Contains(strings.ToLower(email.From), sender) {
+			continue
+		}
+		if !mail.LooksLikeVerification(email.FromAddress, email.Subject) {
+			continue
+		}
+
+		code := mail.VerificationCode(email.Subject, "")
+		if code == "" {
+			full, err := es.getEmailBody(ctx, accountID, "INBOX", email.ID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read email %d: %w", email.ID, err)
+			}
+			code = mail.VerificationCode("", full.Body)
+		}
+		if code != "" {
+			return &VerificationCode{Code: code, From: email.From, Subject: email.Subject, Date: email.Date, Folder: "INBOX", ID: email.ID}, nil
+		}
+	}
+	return nil, nil
+}
+
+func (es *EmailServer) handleGetVerificationCode(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	accountID, _ := args["account"].(string)
+	sender, _ := args["sender"].(string)
+	minutes := 10
+	if m, ok := args["minutes"].(float64); ok && m >= 1 {
+		minutes = int(m)
+	}
+	deleteAfter, _ := args["delete"].(bool)
+
+	config, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(config.redactKinds(), mail.RedactOTP) {
+		return nil, fmt.Errorf("verification codes are redacted for account %s (Redact: %s)", config.ID, config.Redact)
+	}
+
+	found, err := es.findVerificationCode(ctx, config.ID, sender, time.Now().Add(-time.Duration(minutes)*time.Minute))
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		text := fmt.Sprintf("No verification code in the last %d minutes for %s", minutes, config.ID)
+		if sender != "" {
+			text = fmt.Sprintf("No verification code from %q in the last %d minutes for %s", sender, minutes, config.ID)
+		}
+		return ToolResult{Content: []TextContent{{Type: "text", Text: text}}}, nil
+	}
+
+	// A failed delete still returns the code, which is what was asked for
+	var deleteErr error
+	if deleteAfter {
+		deleteErr = es.deleteEmail(ctx, config.ID, found.Folder, found.ID)
+		found.Deleted = deleteErr == nil
+	}
+
+	// The code, often in the subject too, stays off the first line, the
+	// only one the audit log keeps
+	text := fmt.Sprintf("Verification code from %s, received %s [%s/%d]", found.From, found.Date.Local().Format("15:04"), found.Folder, found.ID)
+	if found.Deleted {
+		text += ", email deleted"
+	} else if deleteErr != nil {
+		text += fmt.Sprintf(", failed to delete the email: %v", deleteErr)
+	}
+	text += fmt.Sprintf("\nSubject: %s\nCode: %s", found.Subject, found.Code)
+
+	foundJSON, _ := json.MarshalIndent(found, "", "  ")
+	return ToolResult{
+		Content: []TextContent{
+			{Type: "text", Text: text},
+			{Type: "text", Text: string(foundJSON)},
+		},
+	}, nil
+}
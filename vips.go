@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"email-mcp-server/config"
+	"email-mcp-server/mail"
+	"email-mcp-server/storage"
+)
+
+// VIPs are kept in the sender_analytics table. With update_rules the change is
+// also written to the vip_senders list of priority_rules.json, so it survives
+// a new database and can be edited by hand. VIPs of a single account only live
+// in the accounts section of the rules file.
+
+func (es *EmailServer) handleMarkVIP(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	return es.setVIP(args, true)
+}
+
+func (es *EmailServer) handleUnmarkVIP(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	return es.setVIP(args, false)
+}
+
+func (es *EmailServer) setVIP(args map[string]interface{}, vip bool) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("VIP management is not available: %w", errNoDatabase)
+	}
+
+	raw, _ := args["email"].(string)
+	addr, err := mail.ParseAddress(raw)
+	if err != nil {
+		return nil, invalidArgument("invalid email address %q: %w", raw, err)
+	}
+	sender := strings.ToLower(addr.Address)
+	note, _ := args["note"].(string)
+	updateRules, _ := args["update_rules"].(bool)
+	accountID, _ := args["account"].(string)
+
+	if accountID != "" {
+		return es.setAccountVIP(accountID, sender, vip)
+	}
+
+	if err := es.db.SetVIP(sender, vip, note); err != nil {
+		return nil, err
+	}
+
+	text := fmt.Sprintf("%s is no longer a VIP", sender)
+	if vip {
+		text = fmt.Sprintf("%s marked as VIP", sender)
+	}
+
+	if updateRules {
+		if es.rulesPath == "" {
+			return nil, fmt.Errorf("failed to update rules: the rules file could not be read at startup")
+		}
+		es.rulesMu.Lock()
+		defer es.rulesMu.Unlock()
+		if es.rules.SetVIP(sender, vip) {
+			if err := config.SaveRules(es.rulesPath, es.rules); err != nil {
+				return nil, err
+			}
+			text += fmt.Sprintf(" (saved to %s)", es.rulesPath)
+		}
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: text,
+		}},
+	}, nil
+}
+
+// setAccountVIP marks or unmarks sender as a VIP of one account only
+func (es *EmailServer) setAccountVIP(accountID, sender string, vip bool) (interface{}, error) {
+	account, err := es.getConfig(accountID)
+	if err != nil {
+		return nil, err
+	}
+	if es.rulesPath == "" {
+		return nil, fmt.Errorf("failed to update rules: the rules file could not be read at startup")
+	}
+
+	text := fmt.Sprintf("%s is no longer a VIP of %s", sender, account.ID)
+	if vip {
+		text = fmt.Sprintf("%s marked as VIP of %s", sender, account.ID)
+	}
+	es.rulesMu.Lock()
+	defer es.rulesMu.Unlock()
+	if es.rules.SetAccountVIP(account.ID, sender, vip) {
+		if err := config.SaveRules(es.rulesPath, es.rules); err != nil {
+			return nil, err
+		}
+		text += fmt.Sprintf(" (saved to %s)", es.rulesPath)
+	}
+
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: text,
+		}},
+	}, nil
+}
+
+func (es *EmailServer) handleListVIPs(ctx context.Context, args map[string]interface{}) (interface{}, error) {
+	if es.db == nil {
+		return nil, fmt.Errorf("VIP management is not available: %w", errNoDatabase)
+	}
+
+	stored, err := es.db.ListVIPs()
+	if err != nil {
+		return nil, err
+	}
+
+	type vipSender struct {
+		storage.SenderStats
+		InRules bool   `json:"in_rules"`
+		Account string `json:"account,omitempty"` // set for VIPs of a single account
+	}
+
+	es.rulesMu.RLock()
+	defer es.rulesMu.RUnlock()
+	inRules := make(map[string]bool)
+	for _, sender := range es.rules.VIPSenders {
+		inRules[strings.ToLower(sender)] = true
+	}
+
+	var vips []vipSender
+	for _, s := range stored {
+		vips = append(vips, vipSender{SenderStats: s, InRules: inRules[s.Sender]})
+		delete(inRules, s.Sender)
+	}
+	// VIPs added to the rules file by hand
+	for _, sender := range es.rules.VIPSenders {
+		if inRules[strings.ToLower(sender)] {
+			vips = append(vips, vipSender{SenderStats: storage.SenderStats{Sender: strings.ToLower(sender), IsVIP: true}, InRules: true})
+		}
+	}
+	for _, accountID := range slices.Sorted(maps.Keys(es.rules.Accounts)) {
+		if account := es.rules.Accounts[accountID]; account != nil {
+			for _, sender := range account.VIPSenders {
+				vips = append(vips, vipSender{SenderStats: storage.SenderStats{Sender: strings.ToLower(sender), IsVIP: true}, InRules: true, Account: accountID})
+			}
+		}
+	}
+
+	vipsJSON, _ := json.MarshalIndent(vips, "", "  ")
+	return ToolResult{
+		Content: []TextContent{{
+			Type: "text",
+			Text: fmt.Sprintf("Found %d VIP senders:\n\n%s", len(vips), string(vipsJSON)),
+		}},
+	}, nil
+}
+
+// isVIP reports whether from is a VIP of accountID, in the database or the
+// rules file. An empty accountID checks the VIPs shared by every account.
+func (es *EmailServer) isVIP(accountID string, from mail.Address) bool {
+	if from.IsZero() {
+		return false
+	}
+	es.rulesMu.RLock()
+	inRules := es.rules != nil && es.rules.ForAccount(accountID).IsVIP(from)
+	es.rulesMu.RUnlock()
+	if inRules {
+		return true
+	}
+	if es.db == nil {
+		return false
+	}
+	vip, err := es.db.IsVIP(strings.ToLower(from.Address))
+	return err == nil && vip
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"email-mcp-server/imapext"
+
+	"github.com/emersion/go-imap"
+)
+
+// With CategoryWriteBack on, the categories stored by classify_emails and
+// correct_classification are written back to the mail server so other email
+// clients show them: as labels on Gmail and as IMAP keywords, which
+// Thunderbird and Apple Mail show as tags, elsewhere. The name written for a
+// category comes from the account's CategoryLabels, by default
+// $Category/<Category>; a category mapped to "" is not written. When the
+// category of an email changes, the name of the previous one is removed.
+
+// categoryLabelPrefix starts the default name written for a category
+const categoryLabelPrefix = "$Category/"
+
+// categoryChange is the category to write back to an email, with the
+// category it had before, "" when it had none
+type categoryChange struct {
+	UID      uint32
+	Previous string
+	Category string
+}
+
+// categoryLabel returns the keyword or label written for category, "" for
+// none
+func (c *EmailConfig) categoryLabel(category string) string {
+	if category == "" {
+		return ""
+	}
+	for name, label := range c.CategoryLabels {
+		if strings.EqualFold(name, category) {
+			return label
+		}
+	}
+	return categoryLabelPrefix + strings.ToUpper(category[:1]) + category[1:]
+}
+
+// isKeyword reports whether s can be sent as an IMAP keyword, an atom that
+// does not start with a backslash
+func isKeyword(s string) bool {
+	if s == "" || strings.HasPrefix(s, `\`) {
+		return false
+	}
+	for _, r := range s {
+		if r > unicode.MaxASCII || unicode.IsControl(r) || strings.ContainsRune(` (){%*"\]`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// writeBackCategories writes the categories of changes to the emails of
+// folder and returns how many emails it changed. It does nothing unless the
+// account has CategoryWriteBack on.
+func (es *EmailServer) writeBackCategories(ctx context.Context, config *EmailConfig, folder string, changes []categoryChange) (int, error) {
+	if !config.CategoryWriteBack || len(changes) == 0 {
+		return 0, nil
+	}
+	if config.isGraph() {
+		return 0, fmt.Errorf("account %s uses Microsoft Graph, which has no keywords or labels to write categories to", config.ID)
+	}
+
+	add := make(map[string][]uint32)
+	remove := make(map[string][]uint32)
+	changed := 0
+	for _, change := range changes {
+		label := config.categoryLabel(change.Category)
+		previous := config.categoryLabel(change.Previous)
+		if previous != "" && previous != label {
+			remove[previous] = append(remove[previous], change.UID)
+		}
+		if label != "" {
+			add[label] = append(add[label], change.UID)
+		}
+		if label != "" || (previous != "" && previous != label) {
+			changed++
+		}
+	}
+	if changed == 0 {
+		return 0, nil
+	}
+
+	c, err := es.connectIMAP(ctx, config.ID)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+
+	labels := imapext.SupportsLabels(c)
+	mbox, err := selectFolder(c, folder, false)
+	if err != nil {
+		return 0, err
+	}
+	if !labels {
+		if !slices.Contains(mbox.PermanentFlags, imap.TryCreateFlag) {
+			return 0, fmt.Errorf("folder %s of account %s does not accept new keywords", folder, config.ID)
+		}
+		for _, names := range []map[string][]uint32{remove, add} {
+			for name := range names {
+				if !isKeyword(name) {
+					return 0, fmt.Errorf("%q is not a valid IMAP keyword: map its category to one without spaces or any of (){%%*\"\\] in CategoryLabels", name)
+				}
+			}
+		}
+	}
+
+	for _, change := range []struct {
+		names map[string][]uint32
+		add   bool
+	}{{remove, false}, {add, true}} {
+		for name, uids := range change.names {
+			uidset := new(imap.SeqSet)
+			uidset.AddNum(uids...)
+			if labels {
+				err = imapext.UidStoreLabels(c, uidset, []string{name}, change.add)
+			} else {
+				op := imap.FlagsOp(imap.RemoveFlags)
+				if change.add {
+					op = imap.AddFlags
+				}
+				err = c.UidStore(uidset, imap.FormatFlagsOp(op, true), []interface{}{name}, nil)
+			}
+			if err != nil {
+				return 0, fmt.Errorf("failed to write %s to the server: %w", name, err)
+			}
+		}
+	}
+	return changed, nil
+}
+
+// describeWriteBack reports the outcome of writeBackCategories in a tool
+// result, "" when there was nothing to write
+func describeWriteBack(written int, err error) string {
+	if err != nil {
+		return fmt.Sprintf("\n\nFailed to write categories to the server: %v", err)
+	}
+	if written == 0 {
+		return ""
+	}
+	return fmt.Sprintf("\n\nWrote the categories of %d emails to the server", written)
+}
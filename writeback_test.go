@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// hasFlag reports whether flags hold flag; servers may change the case of
+// keywords
+func hasFlag(flags []string, flag string) bool {
+	return slices.ContainsFunc(flags, func(f string) bool { return strings.EqualFold(f, flag) })
+}
+
+func TestCategoryLabel(t *testing.T) {
+	config := &EmailConfig{CategoryLabels: map[string]string{"Invoice": "Finance/Invoices", "newsletter": ""}}
+	tests := map[string]string{
+		"work":       "$Category/Work",
+		"invoice":    "Finance/Invoices",
+		"Newsletter": "",
+		"":           "",
+	}
+	for category, want := range tests {
+		if got := config.categoryLabel(category); got != want {
+			t.Errorf("categoryLabel(%q) = %q, want %q", category, got, want)
+		}
+	}
+}
+
+func TestIsKeyword(t *testing.T) {
+	tests := map[string]bool{
+		"$Category/Work": true,
+		"processed":      true,
+		"":               false,
+		`\Seen`:          false,
+		"My Work":        false,
+		"a(b)":           false,
+		"büro":           false,
+		"50%":            false,
+		"tab\there":      false,
+	}
+	for s, want := range tests {
+		if got := isKeyword(s); got != want {
+			t.Errorf("isKeyword(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestWriteBackCategories(t *testing.T) {
+	es := newTestServer(t)
+	ctx := context.Background()
+	config := &es.configs[0]
+
+	if written, err := es.writeBackCategories(ctx, config, "INBOX", []categoryChange{{UID: 6, Category: "work"}}); written != 0 || err != nil {
+		t.Errorf("writeBackCategories with write-back off = %d, %v", written, err)
+	}
+	if flags := messageFlags(t, es, "INBOX", 6); hasFlag(flags, "$Category/Work") {
+		t.Fatal("category written with write-back off")
+	}
+
+	config.CategoryWriteBack = true
+	if written, err := es.writeBackCategories(ctx, config, "INBOX", []categoryChange{{UID: 6, Category: "work"}}); written != 1 || err != nil {
+		t.Fatalf("writeBackCategories = %d, %v", written, err)
+	}
+	if flags := messageFlags(t, es, "INBOX", 6); !hasFlag(flags, "$Category/Work") {
+		t.Errorf("flags = %v, want $Category/Work", flags)
+	}
+
+	// A new category replaces the previous one
+	if _, err := es.writeBackCategories(ctx, config, "INBOX", []categoryChange{{UID: 6, Previous: "work", Category: "invoice"}}); err != nil {
+		t.Fatalf("writeBackCategories: %v", err)
+	}
+	flags := messageFlags(t, es, "INBOX", 6)
+	if hasFlag(flags, "$Category/Work") || !hasFlag(flags, "$Category/Invoice") {
+		t.Errorf("flags after recategorizing = %v, want only $Category/Invoice", flags)
+	}
+
+	// Categories mapped to "" are not written
+	config.CategoryLabels = map[string]string{"newsletter": ""}
+	if written, err := es.writeBackCategories(ctx, config, "INBOX", []categoryChange{{UID: 6, Category: "newsletter"}}); written != 0 || err != nil {
+		t.Errorf("writeBackCategories of an unmapped category = %d, %v", written, err)
+	}
+
+	config.CategoryLabels = map[string]string{"personal": "My Stuff"}
+	if _, err := es.writeBackCategories(ctx, config, "INBOX", []categoryChange{{UID: 6, Category: "personal"}}); err == nil {
+		t.Error("writeBackCategories of a label that is no keyword succeeded")
+	}
+}